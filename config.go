@@ -1,15 +1,22 @@
 package goKCL
 
 import (
+	"fmt"
 	"log"
 	"math"
+	"regexp"
 	"strings"
 	"time"
 
 	"github.com/google/uuid"
 
 	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/arn"
 	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+
+	"github.com/guygma/goKCL/record"
+	"github.com/guygma/goKCL/util"
 )
 
 const (
@@ -100,8 +107,272 @@ const (
 
 	// The number of times the Proxy will retry listShards call when throttled.
 	DEFAULT_MAX_LIST_SHARDS_RETRY_ATTEMPTS = 50
+
+	// Record failing validation are dropped by default.
+	DEFAULT_INVALID_RECORD_POLICY = record.SKIP
+
+	// The number of retries all shard consumers of a worker can spend before retries fail fast.
+	DEFAULT_RETRY_BUDGET_SIZE = 100
+
+	// The number of retries added back to the retry budget per second.
+	DEFAULT_RETRY_BUDGET_REFILL_PER_SECOND = 10
+
+	// The max number of conditional lease writes a worker issues concurrently when acquiring leases.
+	DEFAULT_MAX_LEASE_ACQUISITION_CONCURRENCY = 10
+
+	// DEFAULT_MAX_LEASES_PER_ACQUISITION takes one lease per lease acquisition cycle, leaving the other shards to the
+	// other workers so that the leases balance across the fleet.
+	DEFAULT_MAX_LEASES_PER_ACQUISITION = 1
+
+	// A shard is backpressured when GetRecords keeps returning at least this percentage of MaxRecords ...
+	DEFAULT_BACKPRESSURE_THRESHOLD_PERCENT = 90
+
+	// ... for this long in milliseconds.
+	DEFAULT_BACKPRESSURE_WINDOW_MILLIS = 60000
+
+	// Expired shard iterators are refreshed by default.
+	DEFAULT_EXPIRED_ITERATOR_POLICY = REFRESH_ITERATOR
+
+	// The minimum time in milliseconds between two lease acquisition cycles of a worker. By default leases are
+	// acquired on every shard sync.
+	DEFAULT_MIN_LEASE_ACQUISITION_INTERVAL_MILLIS = 0
+
+	// The library doesn't checkpoint on behalf of the record processor by default.
+	DEFAULT_AUTO_CHECKPOINT_RECORD_COUNT    = 0
+	DEFAULT_AUTO_CHECKPOINT_INTERVAL_MILLIS = 0
+
+	// Stuck shard detection is disabled by default.
+	DEFAULT_STUCK_SHARD_TIMEOUT_MILLIS = 0
+
+	// Audited values are included as they are by default.
+	DEFAULT_AUDIT_VALUE_POLICY = util.INCLUDE_VALUES
+
+	// How often in milliseconds workers check for leases released by departing peers, when cooperative shutdown
+	// is enabled.
+	DEFAULT_LEASE_RELEASE_POLL_INTERVAL_MILLIS = 1000
+
+	// Checkpoints behind the current one are accepted by default, for compatibility.
+	DEFAULT_STRICT_CHECKPOINT_MONOTONICITY = false
+
+	// Leases are read with eventually consistent reads by default.
+	DEFAULT_LEASE_READ_CONSISTENCY = EVENTUAL_READS
+
+	// Processing record isn't timed out by default, and there is no warm-up period.
+	DEFAULT_PROCESS_RECORDS_TIMEOUT_MILLIS = 0
+	DEFAULT_PROCESSOR_WARM_UP_MILLIS       = 0
+
+	// Reshard driven rebalances aren't coalesced by default.
+	DEFAULT_RESHARD_COALESCE_WINDOW_MILLIS = 0
+
+	// Shard sync interval while the stream has no open shard.
+	DEFAULT_IDLE_SHARD_SYNC_INTERVAL_MILLIS = 10000
+
+	// The in-flight batches of a shard are unbounded by default.
+	DEFAULT_MAX_IN_FLIGHT_BATCHES = 0
+
+	// Lag isn't snapshotted to the lease table by default.
+	DEFAULT_LAG_SNAPSHOT_INTERVAL_MILLIS = 0
+
+	// A lease is relinquished on the first failed renewal by default.
+	DEFAULT_LEASE_RENEWAL_FAILURE_TOLERANCE = 0
+
+	// Record aren't accumulated across GetRecords calls by default.
+	DEFAULT_BATCHING_WINDOW_MAX_RECORDS = 0
+	DEFAULT_BATCHING_WINDOW_MILLIS      = 0
+
+	// Record with duplicate sequence numbers are delivered by default, for compatibility.
+	DEFAULT_DUPLICATE_RECORD_POLICY = DELIVER_DUPLICATES
+
+	// Record are delivered in order, one batch at a time, by default.
+	DEFAULT_RECORD_DELIVERY_CONCURRENCY = 1
+
+	// Expired leases of other workers are taken over without grace period by default.
+	DEFAULT_LEASE_TAKEOVER_GRACE_MILLIS = 0
+
+	// Checkpoints are written right away by default.
+	DEFAULT_CHECKPOINT_MIN_RECORDS      = 0
+	DEFAULT_CHECKPOINT_MAX_DELAY_MILLIS = 0
+
+	// The latest GetRecords call weighs 20% of the moving average of the processing throughput by default.
+	DEFAULT_THROUGHPUT_SMOOTHING_FACTOR = 0.2
+
+	// A throttled lookup of the lease table is retried 3 times at startup by default.
+	DEFAULT_LEASE_TABLE_STARTUP_RETRIES = 3
+
+	// The shards are discovered at every shard sync by default.
+	DEFAULT_SHARD_DISCOVERY_MIN_INTERVAL_MILLIS = 0
+	DEFAULT_SHARD_DISCOVERY_MAX_INTERVAL_MILLIS = 0
+
+	// The last 10 owners of every shard are kept by default.
+	DEFAULT_LEASE_OWNERSHIP_HISTORY_LENGTH = 10
+
+	// Empty batches aren't delivered on a schedule by default.
+	DEFAULT_EMPTY_BATCH_INTERVAL_MILLIS = 0
+
+	// A throttled listing of the shards is retried 5 times, from a backoff of 1 second, by default.
+	DEFAULT_SHARD_LISTING_RETRIES        = 5
+	DEFAULT_SHARD_LISTING_BACKOFF_MILLIS = 1000
+
+	// Poison shards aren't detected by default, and alerted on once the detection is enabled.
+	DEFAULT_POISON_SHARD_OWNER_SWITCHES = 0
+	DEFAULT_POISON_SHARD_POLICY         = ALERT_POISON_SHARD
+
+	// The lease table is created with provisioned capacity by default.
+	DEFAULT_LEASE_TABLE_BILLING_MODE = dynamodb.BillingModeProvisioned
+
+	// The stale leases are deleted one at a time, without rate limit, by default.
+	DEFAULT_LEASE_GC_CONCURRENCY     = 1
+	DEFAULT_LEASE_GC_RATE_PER_SECOND = 0
+
+	// A failed acquisition of a shard iterator is retried 3 times, from a backoff of 500 milliseconds, by default.
+	DEFAULT_SHARD_ITERATOR_RETRIES        = 3
+	DEFAULT_SHARD_ITERATOR_BACKOFF_MILLIS = 500
+
+	// A quiesced worker hands its leases off over 1 minute by default.
+	DEFAULT_QUIESCE_WINDOW_MILLIS = 60000
+
+	// The record aggregated by the Kinesis Producer Library are deaggregated by default.
+	DEFAULT_ENABLE_AGGREGATION = true
+
+	// Idle enhanced fan-out connections are pinged after 10 seconds and closed if the ping isn't answered within 15
+	// seconds, by default.
+	DEFAULT_FAN_OUT_KEEP_ALIVE_MILLIS   = 10000
+	DEFAULT_FAN_OUT_PING_TIMEOUT_MILLIS = 15000
+
+	// A shard subscription without any event for 30 seconds is renewed by default.
+	DEFAULT_FAN_OUT_READ_TIMEOUT_MILLIS = 30000
+
+	// Workers wait up to one second before stealing leases by default.
+	DEFAULT_LEASE_STEALING_JITTER_MILLIS = 1000
+
+	// DEFAULT_SHARD_MAP_MAX_AGE_MILLIS stops acquiring leases as soon as a shard discovery fails.
+	DEFAULT_SHARD_MAP_MAX_AGE_MILLIS = 0
+
+	// DEFAULT_RETRY_BACKOFF_BASE_MILLIS bounds the backoff before the first retry of a failed call.
+	DEFAULT_RETRY_BACKOFF_BASE_MILLIS = 100
+
+	// DEFAULT_RETRY_BACKOFF_MAX_MILLIS caps the backoff between retries.
+	DEFAULT_RETRY_BACKOFF_MAX_MILLIS = 30000
+
+	// DEFAULT_RETRY_ATTEMPTS is the first call and 5 retries.
+	DEFAULT_RETRY_ATTEMPTS = 6
+
+	// DEFAULT_SUMMARY_LOG_INTERVAL_MILLIS disables the periodic summary of the worker activity.
+	DEFAULT_SUMMARY_LOG_INTERVAL_MILLIS = 0
+
+	// DEFAULT_MAX_CHILD_LEASES_PER_ACQUISITION doesn't bound the leases of new child shards created per cycle.
+	DEFAULT_MAX_CHILD_LEASES_PER_ACQUISITION = 0
+)
+
+const (
+	// REFRESH_ITERATOR re-acquires the shard iterator from the last checkpoint and continues consuming the shard.
+	REFRESH_ITERATOR ExpiredIteratorPolicy = iota + 1
+
+	// FAIL_SHARD treats an expired shard iterator as (potential) data loss and stops consuming the shard with an
+	// error, so that dangerously slow processing fails loudly.
+	FAIL_SHARD
+)
+
+const (
+	// DELIVER_DUPLICATES delivers the record as they are fetched, including the ones fetched again.
+	DELIVER_DUPLICATES DuplicateRecordPolicy = iota + 1
+
+	// SUPPRESS_DUPLICATES drops the record at or behind the last record delivered in the shard, e.g. fetched again
+	// after a shard iterator refresh, so that they are processed once and the checkpoint never regresses.
+	SUPPRESS_DUPLICATES
+)
+
+const (
+	// EVENTUAL_READS reads the lease table with eventually consistent reads, which may return a stale view.
+	EVENTUAL_READS LeaseReadConsistency = iota + 1
+
+	// CONSISTENT_READS reads the lease table with strongly consistent reads, at twice the read capacity.
+	CONSISTENT_READS
+
+	// RECONCILED_READS reads each lease twice with eventually consistent reads and falls back to a strongly
+	// consistent read if they disagree.
+	RECONCILED_READS
 )
 
+const (
+	// ALERT_POISON_SHARD emits an EVENT_POISON_SHARD event every time the lease of a poison shard changes owner.
+	ALERT_POISON_SHARD PoisonShardPolicy = iota + 1
+
+	// DELAY_POISON_SHARD_TAKEOVER also slows the takeovers of the lease down: on top of the takeover grace period,
+	// the lease must have been expired for one more lease duration per owner switch at or beyond the threshold.
+	DELAY_POISON_SHARD_TAKEOVER
+)
+
+// MaxRecordsFunc returns the MaxRecords limit of the next GetRecords call on a shard, given how far the last record
+// fetched from it were behind the tip of the stream (0 before the first fetch).
+type MaxRecordsFunc func(shardID string, millisBehindLatest int64) int
+
+// StartingSequenceNumber explicitly sets where a shard consumer starts reading a shard, for targeted debugging
+// or replay. It overrides both the stored checkpoint and the initial position in stream.
+type StartingSequenceNumber struct {
+	SequenceNumber string
+
+	// After starts reading right after the sequence number (AFTER_SEQUENCE_NUMBER) instead of at it.
+	After bool
+}
+
+// ExpiredIteratorPolicy determines how a shard consumer reacts to GetRecords failing with ExpiredIteratorException.
+type ExpiredIteratorPolicy int
+
+// DuplicateRecordPolicy determines how a shard consumer handles record with a sequence number it already delivered.
+type DuplicateRecordPolicy int
+
+// LeaseReadConsistency determines how the leases read to balance them among the workers are protected against
+// the eventual consistency of DynamoDB.
+type LeaseReadConsistency int
+
+// PoisonShardPolicy determines how a worker reacts to a shard whose lease keeps changing owner without checkpoint.
+type PoisonShardPolicy int
+
+// LeaseAttributeNames are the names of the attributes of the lease items in the lease table.
+type LeaseAttributeNames struct {
+	LeaseKey                     string
+	LeaseOwner                   string
+	LeaseTimeout                 string
+	Checkpoint                   string
+	ParentShardId                string
+	OwnerSwitchesSinceCheckpoint string
+}
+
+// LeaseTableConfig describes the lease table the worker creates if it doesn't exist yet.
+type LeaseTableConfig struct {
+	// BillingMode is dynamodb.BillingModeProvisioned or dynamodb.BillingModePayPerRequest
+	BillingMode string
+
+	// Capacity to provision with the PROVISIONED billing mode, ignored with PAY_PER_REQUEST. 0 provisions
+	// InitialLeaseTableReadCapacity and InitialLeaseTableWriteCapacity.
+	ReadCapacityUnits  int64
+	WriteCapacityUnits int64
+}
+
+// MultiStreamConfig lists the streams consumed by a single Worker, e.g. one stream per tenant. The streams share the
+// Kinesis client and the lease table of the worker, their leases are namespaced by account and stream name so that
+// their shard IDs don't collide. A single stream is consumed as if it was configured with its name, its leases aren't
+// namespaced.
+type MultiStreamConfig struct {
+	// StreamARNs of the consumed streams, e.g. arn:aws:kinesis:us-west-2:123456789012:stream/tenant-a. The streams
+	// have to be in the region of the worker.
+	StreamARNs []string
+}
+
+// CheckpointConfig makes the shard consumers checkpoint on behalf of the record processors, every RecordCount
+// successfully processed records or once Interval has passed since the last checkpoint, whichever triggers first. A
+// zero RecordCount or Interval disables that trigger.
+//
+// The record processors can still checkpoint by themselves, which restarts the count and the interval. A batch the
+// record processor failed, see record.ProcessRecordsInput.Fail, isn't checkpointed: the automatic checkpoints are
+// suspended until the record processor checkpoints again. They are suspended while the worker shuts down too, the
+// last processed record then being checkpointed if configured in CheckpointOnShutdown.
+type CheckpointConfig struct {
+	Interval    time.Duration
+	RecordCount int
+}
+
 // InitialPositionInStream Used to specify the Position in the stream where a new application should start from
 // This is used during initial application bootstrap (when a checkpoint doesn't exist for a shard or its parents)
 type InitialPositionInStream int
@@ -168,6 +439,10 @@ type KinesisClientLibConfiguration struct {
 	/// MaxRecords Max record to read per Kinesis getRecords() call
 	MaxRecords int
 
+	// MaxRecordsForShard overrides MaxRecords per shard, e.g. to fetch larger batches from the shards which are behind
+	// and smaller ones from the idle shards. Its limits are bounded to [1, DEFAULT_MAX_RECORDS]. Optional.
+	MaxRecordsForShard MaxRecordsFunc
+
 	// IdleTimeBetweenReadsInMillis Idle time between calls to fetch data from Kinesis
 	IdleTimeBetweenReadsInMillis int
 
@@ -224,8 +499,353 @@ type KinesisClientLibConfiguration struct {
 	// Worker should skip syncing shards and leases at startup if leases are present
 	// This is useful for optimizing deployments to large fleets working on a stable stream.
 	SkipShardSyncAtWorkerInitializationIfLeasesExist bool
+
+	// RecordValidator is run against every record before it is delivered to the record processor. Optional.
+	RecordValidator record.RecordValidator
+
+	// RecordTimestampExtractor returns the event time of a record for the record age metric and the event time
+	// watermark of the shards. Optional, the time the record arrived in Kinesis is used if nil.
+	RecordTimestampExtractor record.TimestampExtractor
+
+	// InvalidRecordPolicy determines what happens to record failing validation: SKIP drops them, DEAD_LETTER
+	// hands them to the DeadLetterHandler and STOP stops consuming the shard.
+	InvalidRecordPolicy record.FailurePolicy
+
+	// DeadLetterHandler receives record which are dead-lettered.
+	DeadLetterHandler record.IDeadLetterHandler
+
+	// ProcessingFailurePolicy determines what happens to the batches the record processor failed with
+	// ProcessRecordsInput.Fail: the retryable failures are delivered again with RetryBackoff, the others, and the
+	// retryable ones failing for good, are dropped by SKIP, handed to the DeadLetterHandler by DEAD_LETTER, or stop
+	// the consumption of the shard with STOP. Unset, the failed batches are neither delivered again nor checkpointed.
+	ProcessingFailurePolicy record.FailurePolicy
+
+	// RetryBudgetSize is the number of retries shared by all shard consumers of a worker, the shard listing and the
+	// checkpointer
+	RetryBudgetSize int
+
+	// RetryBudgetRefillPerSecond is the number of retries added back to the budget every second
+	RetryBudgetRefillPerSecond int
+
+	// MaxLeaseAcquisitionConcurrency bounds the lease writes in flight while acquiring leases (e.g. at startup)
+	MaxLeaseAcquisitionConcurrency int
+
+	// MaxLeasesPerAcquisition bounds the leases a worker takes per lease acquisition cycle, so that a starting worker
+	// doesn't take all the available shards before the other workers of the fleet get a chance to.
+	MaxLeasesPerAcquisition int
+
+	// DeliverRawRecords delivers the record exactly as returned by Kinesis, bypassing any transformation or
+	// filtering (e.g. RecordValidator) done by the library.
+	DeliverRawRecords bool
+
+	// BackpressureThresholdPercent is the fill level of the fetched record buffer (in percent of MaxRecords) at
+	// which a shard is considered saturated
+	BackpressureThresholdPercent int
+
+	// BackpressureWindowMillis is how long a shard has to stay saturated before backpressure is signaled
+	BackpressureWindowMillis int
+
+	// ExpiredIteratorPolicy determines whether an expired shard iterator is refreshed or fails the shard consumer
+	ExpiredIteratorPolicy ExpiredIteratorPolicy
+
+	// MinLeaseAcquisitionIntervalMillis is the minimum time between two lease acquisition cycles of a worker
+	MinLeaseAcquisitionIntervalMillis int
+
+	// AutoCheckpointRecordCount makes the library checkpoint every N successfully processed record (0 disables)
+	AutoCheckpointRecordCount int
+
+	// AutoCheckpointIntervalMillis makes the library checkpoint once this long has passed since the last
+	// checkpoint (0 disables). Combined with AutoCheckpointRecordCount, whichever triggers first wins.
+	AutoCheckpointIntervalMillis int
+
+	// CheckpointConfig makes the library checkpoint on behalf of the record processors, it replaces
+	// AutoCheckpointRecordCount and AutoCheckpointIntervalMillis if set.
+	CheckpointConfig CheckpointConfig
+
+	// StuckShardTimeoutMillis flags a shard as stuck if it neither received record nor made checkpoint progress
+	// for this long while still behind (0 disables)
+	StuckShardTimeoutMillis int
+
+	// EventListener receives noteworthy events (e.g. stuck shards) emitted by the worker. Optional.
+	EventListener util.EventListener
+
+	// StartingSequenceNumbers overrides, keyed by shard ID, where the worker starts reading the shard when it
+	// first takes its lease
+	StartingSequenceNumbers map[string]StartingSequenceNumber
+
+	// AuditHook receives a record of every lease and checkpoint mutation done by the worker. Optional.
+	AuditHook util.AuditHook
+
+	// AuditValuePolicy determines whether audited values are included as they are or hashed
+	AuditValuePolicy util.AuditValuePolicy
+
+	// CooperativeShutdown makes a departing worker signal its peers once it released its leases, so they pick
+	// them up right away rather than on their next shard sync. Requires a checkpointer implementing
+	// shard.LeaseReleaseSignaler.
+	CooperativeShutdown bool
+
+	// LeaseReleasePollIntervalMillis is how often a worker checks for leases released by departing peers
+	LeaseReleasePollIntervalMillis int
+
+	// ReadOnlyFollower makes the worker follow every shard of the stream in read-only mode, e.g. to build a
+	// read replica of the processing. It coexists with the workers owning the leases: it neither takes leases
+	// nor checkpoints, and starts reading each shard from the checkpoint of its owner.
+	ReadOnlyFollower bool
+
+	// KinesisRequestTimeoutMillis is the timeout of the requests to Kinesis (0 means no timeout)
+	KinesisRequestTimeoutMillis int
+
+	// KinesisMaxConnections limits the connections to Kinesis (0 means unlimited)
+	KinesisMaxConnections int
+
+	// DynamoDBRequestTimeoutMillis is the timeout of the requests to DynamoDB, i.e. the lease operations
+	// (0 means no timeout)
+	DynamoDBRequestTimeoutMillis int
+
+	// DynamoDBMaxConnections limits the connections to DynamoDB (0 means unlimited)
+	DynamoDBMaxConnections int
+
+	// WorkerStateStore persists the known shards and held leases of the worker, so that a restarted worker takes
+	// back its previous leases first. Optional.
+	WorkerStateStore WorkerStateStore
+
+	// StrictCheckpointMonotonicity rejects checkpoints behind the current checkpoint of the shard with an
+	// IllegalArgumentError, protecting against record processors regressing the progress
+	StrictCheckpointMonotonicity bool
+
+	// LeaseReadConsistency determines the consistency of the lease table reads
+	LeaseReadConsistency LeaseReadConsistency
+
+	// ProcessRecordsTimeoutMillis is how long the record processor may take to process a batch of record before
+	// the consumer gives up the shard and releases its lease (0 disables)
+	ProcessRecordsTimeoutMillis int
+
+	// ProcessorWarmUpMillis is a grace period after Initialize during which slow processing (e.g. with cold caches)
+	// isn't considered a failure, i.e. ProcessRecordsTimeoutMillis isn't enforced
+	ProcessorWarmUpMillis int
+
+	// ReshardCoalesceWindowMillis is how long a worker waits for the children of a reshard to be registered before
+	// rebalancing leases. Every new child shard restarts the window, so that a reshard leads to a single rebalance
+	// instead of one per child shard. 0 rebalances right away.
+	ReshardCoalesceWindowMillis int
+
+	// IdleShardSyncIntervalMillis is the time between shard syncs while the stream has no open shard (e.g. all of
+	// them are closed, awaiting new ones). The worker idles until an open shard appears.
+	IdleShardSyncIntervalMillis int
+
+	// LeaseAttributeNames maps the attributes of the lease items to custom names, the default names are used if
+	// not set
+	LeaseAttributeNames LeaseAttributeNames
+
+	// CheckpointOnShutdown tells, per shutdown reason, whether the library checkpoints at the last processed record
+	// before shutting the record processor down, independent of the processor checkpointing. ZOMBIE never does.
+	CheckpointOnShutdown map[util.ShutdownReason]bool
+
+	// MaxInFlightBatches bounds how many delivered batches a shard can have outstanding, i.e. not checkpointed yet,
+	// e.g. with a record processor processing asynchronously. Fetching pauses once the limit is reached, which
+	// bounds the reprocessing on a crash. 0 means unbounded.
+	MaxInFlightBatches int
+
+	// LagSnapshotIntervalMillis makes the shard consumers write the latest MillisBehindLatest of their shard into
+	// its lease this often, so that dashboards can read the lag from the lease table. Every snapshot is an extra
+	// write to the lease table. 0 disables the snapshots.
+	LagSnapshotIntervalMillis int
+
+	// AvailabilityZone tags the worker with its availability zone. The zone is written into the leases the worker
+	// holds, and lease acquisition is biased so that the shards are spread across the zones reported by the
+	// workers, to survive the outage of a zone. Empty disables the affinity.
+	AvailabilityZone string
+
+	// LeaseRenewalFailureTolerance is how many consecutive failed lease renewals a shard consumer tolerates before
+	// relinquishing its lease. Tolerating failures makes the consumers renew their lease from the middle of the
+	// failover window on, so that failed renewals are retried while the lease is still valid. The lease is
+	// relinquished regardless once about to expire, rather than gambling on a last renewal and risking two
+	// workers processing the shard. 0 relinquishes the lease on the first failure.
+	LeaseRenewalFailureTolerance int
+
+	// BatchingWindowMaxRecords and BatchingWindowMillis make the shard consumers accumulate the record of several
+	// GetRecords calls into a single ProcessRecords call, e.g. for efficient batch writes downstream. A batch is
+	// delivered once it holds at least BatchingWindowMaxRecords record, or BatchingWindowMillis after its first
+	// record was fetched, whichever comes first. The record are delivered in order and the batch is only
+	// checkpointed as a whole. 0 for both delivers every fetch as is.
+	BatchingWindowMaxRecords int
+	BatchingWindowMillis     int
+
+	// DuplicateRecordPolicy determines whether record with a sequence number already delivered are suppressed
+	DuplicateRecordPolicy DuplicateRecordPolicy
+
+	// RecordDeliveryConcurrency is how many record of a batch are processed concurrently by record processors
+	// implementing record.IOrderIndependentRecordProcessor. Above 1, the record of a shard are no longer processed
+	// in order, the library checkpoints every batch once all its record are processed instead. Only opt in for
+	// workloads which don't depend on the record order, e.g. idempotent writes. Other record processors always
+	// receive the record in order.
+	RecordDeliveryConcurrency int
+
+	// LeaseTakeoverGraceMillis is a grace period added to the expiry of the lease of another worker before taking
+	// it over, so that a worker whose renewals are briefly delayed, e.g. by a DynamoDB hiccup, isn't declared dead
+	// right away. It doesn't affect when workers renew their own leases.
+	LeaseTakeoverGraceMillis int
+
+	// CheckpointMinRecords and CheckpointMaxDelayMillis defer the checkpoints of the record processors until at
+	// least CheckpointMinRecords record have been delivered since the last checkpoint written, reducing the write
+	// amplification of processors checkpointing every batch of a low-volume shard. A deferred checkpoint is written
+	// regardless once CheckpointMaxDelayMillis have passed since it was requested, and when the record processor is
+	// shut down, unless it lost its lease. 0 writes every checkpoint right away.
+	CheckpointMinRecords     int
+	CheckpointMaxDelayMillis int
+
+	// ThroughputSmoothingFactor is the weight, in (0, 1], of the latest GetRecords call in the exponentially weighted
+	// moving average of the record processed per second by every shard consumer. Lower values smooth out bursty
+	// traffic more, 1 reports the throughput of the latest call only.
+	ThroughputSmoothingFactor float64
+
+	// LeaseTableStartupRetries is how many times the worker retries, with exponential backoff, the lookup of the lease
+	// table at startup when it is throttled, before failing to start with a LeasingProvisionedThroughputError.
+	LeaseTableStartupRetries int
+
+	// ShardDiscoveryMinIntervalMillis and ShardDiscoveryMaxIntervalMillis make the shard discovery adaptive, for
+	// streams with so many shards that listing and diffing them is expensive. The discovery interval starts at the
+	// minimum and doubles with every discovery finding the same shards, up to the maximum. It drops back to the
+	// minimum as soon as a reshard is detected. Leases are still acquired every ShardSyncIntervalMillis. 0 for both
+	// discovers the shards every ShardSyncIntervalMillis.
+	ShardDiscoveryMinIntervalMillis int
+	ShardDiscoveryMaxIntervalMillis int
+
+	// LeaseOwnershipHistoryLength is how many ownership changes of every shard, as observed by the worker, are kept
+	// to diagnose lease flapping, see shard.Status.GetLeaseOwnershipHistory. 0 keeps none.
+	LeaseOwnershipHistoryLength int
+
+	// EmptyBatchIntervalMillis makes the shard consumers call ProcessRecords with an empty batch once no record has
+	// been delivered for this long, and then again every interval until record arrive, so that time driven record
+	// processors, e.g. flushing windowed aggregates, get a tick regardless of the traffic. The ticks are as precise as
+	// IdleTimeBetweenReadsInMillis. 0 disables the ticks, see CallProcessRecordsEvenForEmptyRecordList to deliver
+	// every empty batch instead.
+	EmptyBatchIntervalMillis int
+
+	// ShardListingRetries is how many times a throttled listing of the shards, i.e. a DescribeStream call failing
+	// with LimitExceededException, is retried before the shard discovery fails. The retries wait with exponential
+	// backoff from ShardListingBackoffMillis, randomized so that the workers of the application don't retry together.
+	ShardListingRetries int
+
+	// ShardListingBackoffMillis is the backoff before the first retry of a throttled listing of the shards.
+	ShardListingBackoffMillis int
+
+	// PoisonShardOwnerSwitches is the number of owner switches of a lease since its last checkpoint at which the shard
+	// is considered poison, i.e. bouncing between workers which fail to make progress on it. The owner switches are
+	// counted in the lease table and reset by every checkpoint. 0 disables the detection.
+	PoisonShardOwnerSwitches int
+
+	// PoisonShardPolicy determines how a worker reacts to a poison shard
+	PoisonShardPolicy PoisonShardPolicy
+
+	// LeaseTableConfig determines the billing mode and capacity of the lease table, when the worker creates it.
+	LeaseTableConfig LeaseTableConfig
+
+	// LeaseGCConcurrency bounds the deletions in flight while garbage collecting the leases of the shards which
+	// no longer exist in the stream, e.g. after many shards expired at once.
+	LeaseGCConcurrency int
+
+	// LeaseGCRatePerSecond limits the rate of the lease deletions of the garbage collection, 0 means unlimited.
+	LeaseGCRatePerSecond int
+
+	// ShardIteratorRetries is how many times a transient failure of the acquisition of the shard iterator a consumer
+	// starts from, e.g. a throttled GetShardIterator call, is retried before the consumer of the shard fails and
+	// releases its lease. The retries wait with exponential backoff from ShardIteratorBackoffMillis.
+	ShardIteratorRetries int
+
+	// ShardIteratorBackoffMillis is the backoff before the first retry of a failed acquisition of a shard iterator.
+	ShardIteratorBackoffMillis int
+
+	// ConsumerName enables enhanced fan-out: the shards are read by the stream consumer of this name, which Kinesis
+	// pushes the record to with SubscribeToShard, rather than polled with GetRecords. Every enhanced fan-out consumer
+	// gets its own read throughput of the shards. The worker registers the consumer if it doesn't exist, and
+	// deregisters it on shutdown if it registered it. Empty, the default, polls the shards.
+	ConsumerName string
+
+	// QuiesceWindowMillis is the window over which a quiesced worker hands its leases off, one at a time at even
+	// intervals, see Worker.Quiesce.
+	QuiesceWindowMillis int
+
+	// EnableAggregation deaggregates the record aggregated by the Kinesis Producer Library, so that the record
+	// processor receives the user record, each with its sub-sequence number, see record.ProcessRecordsInput.
+	EnableAggregation bool
+
+	// AllowPrematureShardEnd accepts the checkpoints at SHARD_END before the consumer reached the end of the shard.
+	// They are rejected with an InvalidStateError by default, as they let the child shards start before the record
+	// of their parent are processed.
+	AllowPrematureShardEnd bool
+
+	// FanOutKeepAliveMillis is how long an enhanced fan-out connection may stay silent before it is health checked
+	// with an HTTP/2 ping, and the TCP keepalive period of its connections.
+	FanOutKeepAliveMillis int
+
+	// FanOutPingTimeoutMillis is how long an unanswered HTTP/2 ping waits before the connection is closed as dead.
+	FanOutPingTimeoutMillis int
+
+	// FanOutReadTimeoutMillis is how long a shard subscription waits for an event before it is considered dead and
+	// renewed. Kinesis sends an event at least every 5 seconds, even without record.
+	FanOutReadTimeoutMillis int
+
+	// EnableLeaseStealing makes a worker holding less than its fair share of the leases, i.e. the leases over the
+	// live workers, take leases from the most loaded worker, up to MaxLeasesToStealAtOneTime per lease acquisition.
+	// The checkpointer needs to list and steal leases, see shard.LeaseLister and shard.LeaseStealer.
+	EnableLeaseStealing bool
+
+	// LeaseStealingJitterMillis is the maximum random delay before a worker steals leases, so that the workers
+	// joining together don't all steal from the same worker at once.
+	LeaseStealingJitterMillis int
+
+	// ShutdownOrder is the order of the steps shutting a shard consumer down. Fetching always stops first and the
+	// lease is always released last, the checkpoint is written before the record processor shuts down by default.
+	ShutdownOrder []util.ShutdownStep
+
+	// ShutdownHook is called before and after every shutdown step of the shard consumers. Optional.
+	ShutdownHook util.ShutdownHook
+
+	// MultiStreamConfig lists the streams consumed by the worker instead of StreamName, if any.
+	MultiStreamConfig MultiStreamConfig
+
+	// ShardMapMaxAgeMillis is how long the shards discovered last can be relied on while the shard discovery fails,
+	// e.g. while listing the shards is throttled. Past it the shard map is stale: no lease is acquired from it, since the
+	// stream may have been resharded meanwhile, while the shards already owned keep being processed. 0 stops
+	// acquiring leases as soon as a discovery fails.
+	ShardMapMaxAgeMillis int
+	// SharedLeaseTable namespaces the lease keys with the ApplicationName, e.g. "orders#shardId-000000000000", so
+	// that several applications can keep their leases in one lease table, e.g. in a shared account. Every
+	// application of the table needs it: the leases of the others are out of its reach, while an application without
+	// it would take them for leases of its own.
+	SharedLeaseTable bool
+	// RetryBackoff spreads the retries of the calls to Kinesis and DynamoDB failing with a retryable error, e.g.
+	// throttled: it bounds the backoff before each retry, randomized so that the workers don't retry together, and
+	// the attempts of the lease store operations, see shard.LeaseStoreCheckpointer. The retries of a shard iterator
+	// acquisition or a lease table lookup are bounded by ShardIteratorRetries and LeaseTableStartupRetries instead.
+	RetryBackoff util.Backoff
+	// SummaryLogIntervalMillis is how often the worker logs a summary of its activity at INFO level: the shards it
+	// owns, the records processed per second, the maximal lag of its shards, and the checkpoints written and the
+	// throttled calls since the last summary. It gives a pulse of the worker without any metrics infrastructure.
+	// 0 disables the summary.
+	SummaryLogIntervalMillis int
+	// MaxChildLeasesPerAcquisition bounds the leases of new child shards a worker creates per lease acquisition
+	// cycle, so that a shard ending into many children doesn't make a single worker take them all at once. The
+	// other children are left to the next cycles and to the other workers. 0 leaves it unbounded.
+	MaxChildLeasesPerAcquisition int
+	// RecreateDeletedLeaseTable makes the DynamoDB checkpointer create the lease table again, as at startup, when it
+	// is deleted while the worker runs, instead of failing every lease operation. The leases are written again from
+	// the state of the shards held by the worker as they are renewed, the checkpoints of the others are lost. Off by
+	// default, since it silently undoes a deletion which may have been intended.
+	RecreateDeletedLeaseTable bool
+	// CoalesceLeaseRenewals makes the DynamoDB checkpointer renew the lease of a shard in the same write as its
+	// checkpoint once the lease is past the middle of its duration, sparing the separate renewal write. The write is
+	// conditioned on the owner and lease timeout of the lease like a renewal, so that a lease taken over by another
+	// worker isn't checkpointed.
+	CoalesceLeaseRenewals bool
 }
 
+var sequenceNumberRegexp = regexp.MustCompile(`^(0|[1-9][0-9]{0,128})$`)
+
+// applicationNameRegexp restricts the ApplicationName of a shared lease table, so that it can't run into its separator
+var applicationNameRegexp = regexp.MustCompile(`^[a-zA-Z0-9_.-]+$`)
+
 var positionMap = map[InitialPositionInStream]*string{
 	LATEST:       aws.String("LATEST"),
 	TRIM_HORIZON: aws.String("TRIM_HORIZON"),
@@ -256,6 +876,39 @@ func checkIsValuePositive(key string, value int) {
 	}
 }
 
+// streamIdentity identifies a stream of a MultiStreamConfig.
+type streamIdentity struct {
+	arn    string
+	name   string
+	region string
+	// namespaces the leases of the stream by account and stream name
+	leaseKeyPrefix string
+}
+
+// parseStreamARN returns the identity of the stream of the ARN.
+func parseStreamARN(streamARN string) (*streamIdentity, error) {
+	parsed, err := arn.Parse(streamARN)
+	if err != nil {
+		return nil, err
+	}
+	if parsed.Service != "kinesis" || !strings.HasPrefix(parsed.Resource, "stream/") {
+		return nil, fmt.Errorf("not the ARN of a Kinesis stream: %s", streamARN)
+	}
+
+	name := strings.TrimPrefix(parsed.Resource, "stream/")
+	return &streamIdentity{
+		arn:            streamARN,
+		name:           name,
+		region:         parsed.Region,
+		leaseKeyPrefix: parsed.AccountID + ":" + name + ":",
+	}, nil
+}
+
+// IsValidSequenceNumber checks the format of a Kinesis sequence number, which is a decimal of up to 129 digits.
+func IsValidSequenceNumber(sequenceNumber string) bool {
+	return sequenceNumberRegexp.MatchString(sequenceNumber)
+}
+
 func newInitialPositionAtTimestamp(timestamp *time.Time) *InitialPositionInStreamExtended {
 	return &InitialPositionInStreamExtended{Position: AT_TIMESTAMP, Timestamp: timestamp}
 }
@@ -316,6 +969,67 @@ func NewKinesisClientLibConfigWithCredentials(applicationName, streamName, regio
 		InitialLeaseTableReadCapacity:                    DEFAULT_INITIAL_LEASE_TABLE_READ_CAPACITY,
 		InitialLeaseTableWriteCapacity:                   DEFAULT_INITIAL_LEASE_TABLE_WRITE_CAPACITY,
 		SkipShardSyncAtWorkerInitializationIfLeasesExist: DEFAULT_SKIP_SHARD_SYNC_AT_STARTUP_IF_LEASES_EXIST,
+		InvalidRecordPolicy:                              DEFAULT_INVALID_RECORD_POLICY,
+		RetryBudgetSize:                                  DEFAULT_RETRY_BUDGET_SIZE,
+		RetryBudgetRefillPerSecond:                       DEFAULT_RETRY_BUDGET_REFILL_PER_SECOND,
+		MaxLeaseAcquisitionConcurrency:                   DEFAULT_MAX_LEASE_ACQUISITION_CONCURRENCY,
+		MaxLeasesPerAcquisition:                          DEFAULT_MAX_LEASES_PER_ACQUISITION,
+		BackpressureThresholdPercent:                     DEFAULT_BACKPRESSURE_THRESHOLD_PERCENT,
+		BackpressureWindowMillis:                         DEFAULT_BACKPRESSURE_WINDOW_MILLIS,
+		ExpiredIteratorPolicy:                            DEFAULT_EXPIRED_ITERATOR_POLICY,
+		MinLeaseAcquisitionIntervalMillis:                DEFAULT_MIN_LEASE_ACQUISITION_INTERVAL_MILLIS,
+		AutoCheckpointRecordCount:                        DEFAULT_AUTO_CHECKPOINT_RECORD_COUNT,
+		AutoCheckpointIntervalMillis:                     DEFAULT_AUTO_CHECKPOINT_INTERVAL_MILLIS,
+		StuckShardTimeoutMillis:                          DEFAULT_STUCK_SHARD_TIMEOUT_MILLIS,
+		AuditValuePolicy:                                 DEFAULT_AUDIT_VALUE_POLICY,
+		LeaseReleasePollIntervalMillis:                   DEFAULT_LEASE_RELEASE_POLL_INTERVAL_MILLIS,
+		StrictCheckpointMonotonicity:                     DEFAULT_STRICT_CHECKPOINT_MONOTONICITY,
+		LeaseReadConsistency:                             DEFAULT_LEASE_READ_CONSISTENCY,
+		ProcessRecordsTimeoutMillis:                      DEFAULT_PROCESS_RECORDS_TIMEOUT_MILLIS,
+		ProcessorWarmUpMillis:                            DEFAULT_PROCESSOR_WARM_UP_MILLIS,
+		ReshardCoalesceWindowMillis:                      DEFAULT_RESHARD_COALESCE_WINDOW_MILLIS,
+		IdleShardSyncIntervalMillis:                      DEFAULT_IDLE_SHARD_SYNC_INTERVAL_MILLIS,
+		MaxInFlightBatches:                               DEFAULT_MAX_IN_FLIGHT_BATCHES,
+		LagSnapshotIntervalMillis:                        DEFAULT_LAG_SNAPSHOT_INTERVAL_MILLIS,
+		LeaseRenewalFailureTolerance:                     DEFAULT_LEASE_RENEWAL_FAILURE_TOLERANCE,
+		BatchingWindowMaxRecords:                         DEFAULT_BATCHING_WINDOW_MAX_RECORDS,
+		BatchingWindowMillis:                             DEFAULT_BATCHING_WINDOW_MILLIS,
+		DuplicateRecordPolicy:                            DEFAULT_DUPLICATE_RECORD_POLICY,
+		RecordDeliveryConcurrency:                        DEFAULT_RECORD_DELIVERY_CONCURRENCY,
+		LeaseTakeoverGraceMillis:                         DEFAULT_LEASE_TAKEOVER_GRACE_MILLIS,
+		CheckpointMinRecords:                             DEFAULT_CHECKPOINT_MIN_RECORDS,
+		CheckpointMaxDelayMillis:                         DEFAULT_CHECKPOINT_MAX_DELAY_MILLIS,
+		ThroughputSmoothingFactor:                        DEFAULT_THROUGHPUT_SMOOTHING_FACTOR,
+		LeaseTableStartupRetries:                         DEFAULT_LEASE_TABLE_STARTUP_RETRIES,
+		ShardDiscoveryMinIntervalMillis:                  DEFAULT_SHARD_DISCOVERY_MIN_INTERVAL_MILLIS,
+		ShardDiscoveryMaxIntervalMillis:                  DEFAULT_SHARD_DISCOVERY_MAX_INTERVAL_MILLIS,
+		LeaseOwnershipHistoryLength:                      DEFAULT_LEASE_OWNERSHIP_HISTORY_LENGTH,
+		EmptyBatchIntervalMillis:                         DEFAULT_EMPTY_BATCH_INTERVAL_MILLIS,
+		ShardListingRetries:                              DEFAULT_SHARD_LISTING_RETRIES,
+		ShardListingBackoffMillis:                        DEFAULT_SHARD_LISTING_BACKOFF_MILLIS,
+		PoisonShardOwnerSwitches:                         DEFAULT_POISON_SHARD_OWNER_SWITCHES,
+		PoisonShardPolicy:                                DEFAULT_POISON_SHARD_POLICY,
+		LeaseTableConfig:                                 LeaseTableConfig{BillingMode: DEFAULT_LEASE_TABLE_BILLING_MODE},
+		LeaseGCConcurrency:                               DEFAULT_LEASE_GC_CONCURRENCY,
+		LeaseGCRatePerSecond:                             DEFAULT_LEASE_GC_RATE_PER_SECOND,
+		ShardIteratorRetries:                             DEFAULT_SHARD_ITERATOR_RETRIES,
+		ShardIteratorBackoffMillis:                       DEFAULT_SHARD_ITERATOR_BACKOFF_MILLIS,
+		QuiesceWindowMillis:                              DEFAULT_QUIESCE_WINDOW_MILLIS,
+		EnableAggregation:                                DEFAULT_ENABLE_AGGREGATION,
+		FanOutKeepAliveMillis:                            DEFAULT_FAN_OUT_KEEP_ALIVE_MILLIS,
+		FanOutPingTimeoutMillis:                          DEFAULT_FAN_OUT_PING_TIMEOUT_MILLIS,
+		FanOutReadTimeoutMillis:                          DEFAULT_FAN_OUT_READ_TIMEOUT_MILLIS,
+		LeaseStealingJitterMillis:                        DEFAULT_LEASE_STEALING_JITTER_MILLIS,
+		ShardMapMaxAgeMillis:                             DEFAULT_SHARD_MAP_MAX_AGE_MILLIS,
+		SummaryLogIntervalMillis:                         DEFAULT_SUMMARY_LOG_INTERVAL_MILLIS,
+		MaxChildLeasesPerAcquisition:                     DEFAULT_MAX_CHILD_LEASES_PER_ACQUISITION,
+		ShutdownOrder: []util.ShutdownStep{util.STOP_FETCHING, util.CHECKPOINT, util.SHUTDOWN_PROCESSOR,
+			util.RELEASE_LEASE},
+		RetryBackoff: util.Backoff{
+			Base:     DEFAULT_RETRY_BACKOFF_BASE_MILLIS * time.Millisecond,
+			Max:      DEFAULT_RETRY_BACKOFF_MAX_MILLIS * time.Millisecond,
+			Attempts: DEFAULT_RETRY_ATTEMPTS,
+		},
 	}
 }
 
@@ -367,6 +1081,13 @@ func (c *KinesisClientLibConfiguration) WithMaxRecords(maxRecords int) *KinesisC
 	return c
 }
 
+// WithMaxRecordsForShard configures the MaxRecords limit of every GetRecords call from the shard and its lag, instead
+// of the global one.
+func (c *KinesisClientLibConfiguration) WithMaxRecordsForShard(maxRecords MaxRecordsFunc) *KinesisClientLibConfiguration {
+	c.MaxRecordsForShard = maxRecords
+	return c
+}
+
 // WithMaxLeasesForWorker configures maximum lease this worker can handles. It determines how maximun number of shards
 // this worker can handle.
 func (c *KinesisClientLibConfiguration) WithMaxLeasesForWorker(n int) *KinesisClientLibConfiguration {
@@ -425,3 +1146,618 @@ func (c *KinesisClientLibConfiguration) WithMetricsMaxQueueSize(metricsMaxQueueS
 	c.MetricsMaxQueueSize = metricsMaxQueueSize
 	return c
 }
+
+// WithRecordValidator configures a validator for every record and the policy applied to record failing it.
+func (c *KinesisClientLibConfiguration) WithRecordValidator(validator record.RecordValidator, policy record.FailurePolicy) *KinesisClientLibConfiguration {
+	c.RecordValidator = validator
+	c.InvalidRecordPolicy = policy
+	return c
+}
+
+// WithRecordTimestampExtractor configures where the event time of the record is taken from, e.g. their payload,
+// instead of the time they arrived in Kinesis.
+func (c *KinesisClientLibConfiguration) WithRecordTimestampExtractor(extractor record.TimestampExtractor) *KinesisClientLibConfiguration {
+	c.RecordTimestampExtractor = extractor
+	return c
+}
+
+// WithDeadLetterHandler configures the handler receiving dead-lettered record.
+func (c *KinesisClientLibConfiguration) WithDeadLetterHandler(handler record.IDeadLetterHandler) *KinesisClientLibConfiguration {
+	c.DeadLetterHandler = handler
+	return c
+}
+
+// WithRetryBudget configures the retry budget shared by all shard consumers of a worker, the shard listing and the
+// checkpointer, see shard.RetryingCheckpointer. Once size retries have been spent, retries fail fast until the
+// budget has been refilled at refillPerSecond retries per second.
+func (c *KinesisClientLibConfiguration) WithRetryBudget(size, refillPerSecond int) *KinesisClientLibConfiguration {
+	checkIsValuePositive("RetryBudgetSize", size)
+	checkIsValuePositive("RetryBudgetRefillPerSecond", refillPerSecond)
+	c.RetryBudgetSize = size
+	c.RetryBudgetRefillPerSecond = refillPerSecond
+	return c
+}
+
+// WithMaxLeaseAcquisitionConcurrency bounds the number of conditional lease writes issued concurrently when the
+// worker acquires many leases at once, smoothing the write load on the lease table at startup.
+func (c *KinesisClientLibConfiguration) WithMaxLeaseAcquisitionConcurrency(n int) *KinesisClientLibConfiguration {
+	checkIsValuePositive("MaxLeaseAcquisitionConcurrency", n)
+	c.MaxLeaseAcquisitionConcurrency = n
+	return c
+}
+
+// WithMaxLeasesPerAcquisition bounds the number of leases the worker takes per lease acquisition cycle.
+func (c *KinesisClientLibConfiguration) WithMaxLeasesPerAcquisition(n int) *KinesisClientLibConfiguration {
+	checkIsValuePositive("MaxLeasesPerAcquisition", n)
+	c.MaxLeasesPerAcquisition = n
+	return c
+}
+
+// WithRawRecords configures whether record are delivered to the record processor exactly as returned by Kinesis.
+func (c *KinesisClientLibConfiguration) WithRawRecords(raw bool) *KinesisClientLibConfiguration {
+	c.DeliverRawRecords = raw
+	return c
+}
+
+// WithBackpressure configures when backpressure is signaled: once the record fetched per GetRecords call stay at or
+// above thresholdPercent of MaxRecords for windowMillis.
+func (c *KinesisClientLibConfiguration) WithBackpressure(thresholdPercent, windowMillis int) *KinesisClientLibConfiguration {
+	checkIsValuePositive("BackpressureThresholdPercent", thresholdPercent)
+	checkIsValuePositive("BackpressureWindowMillis", windowMillis)
+	c.BackpressureThresholdPercent = thresholdPercent
+	c.BackpressureWindowMillis = windowMillis
+	return c
+}
+
+// WithExpiredIteratorPolicy configures how a shard consumer reacts to an expired shard iterator.
+func (c *KinesisClientLibConfiguration) WithExpiredIteratorPolicy(policy ExpiredIteratorPolicy) *KinesisClientLibConfiguration {
+	c.ExpiredIteratorPolicy = policy
+	return c
+}
+
+// WithMinLeaseAcquisitionIntervalMillis rate limits how often a worker attempts to acquire leases. Since every cycle
+// acquires as many leases as the worker may hold, the worker still converges within a single cycle.
+func (c *KinesisClientLibConfiguration) WithMinLeaseAcquisitionIntervalMillis(interval int) *KinesisClientLibConfiguration {
+	checkIsValuePositive("MinLeaseAcquisitionIntervalMillis", interval)
+	c.MinLeaseAcquisitionIntervalMillis = interval
+	return c
+}
+
+// WithAutoCheckpointRecordCount makes the library checkpoint every n successfully processed record.
+func (c *KinesisClientLibConfiguration) WithAutoCheckpointRecordCount(n int) *KinesisClientLibConfiguration {
+	checkIsValuePositive("AutoCheckpointRecordCount", n)
+	c.AutoCheckpointRecordCount = n
+	return c
+}
+
+// WithAutoCheckpointIntervalMillis makes the library checkpoint the processed record at most this long after
+// the last checkpoint.
+func (c *KinesisClientLibConfiguration) WithAutoCheckpointIntervalMillis(interval int) *KinesisClientLibConfiguration {
+	checkIsValuePositive("AutoCheckpointIntervalMillis", interval)
+	c.AutoCheckpointIntervalMillis = interval
+	return c
+}
+
+// WithStuckShardTimeoutMillis enables the watchdog flagging shards which are behind but made no progress for this long.
+func (c *KinesisClientLibConfiguration) WithStuckShardTimeoutMillis(timeout int) *KinesisClientLibConfiguration {
+	checkIsValuePositive("StuckShardTimeoutMillis", timeout)
+	c.StuckShardTimeoutMillis = timeout
+	return c
+}
+
+// WithEventListener configures the listener receiving the events emitted by the worker.
+func (c *KinesisClientLibConfiguration) WithEventListener(listener util.EventListener) *KinesisClientLibConfiguration {
+	c.EventListener = listener
+	return c
+}
+
+// WithStartingSequenceNumber makes the worker start reading the shard at (or after) the given sequence number the
+// first time it takes the lease of the shard, regardless of the checkpoint and the initial position in stream.
+func (c *KinesisClientLibConfiguration) WithStartingSequenceNumber(shardID, sequenceNumber string, after bool) *KinesisClientLibConfiguration {
+	checkIsValueNotEmpty("ShardID", shardID)
+	if !IsValidSequenceNumber(sequenceNumber) {
+		// There is no point to continue for incorrect configuration. Fail fast!
+		log.Panicf("Invalid sequence number for shard %v: %v", shardID, sequenceNumber)
+	}
+	if c.StartingSequenceNumbers == nil {
+		c.StartingSequenceNumbers = make(map[string]StartingSequenceNumber)
+	}
+	c.StartingSequenceNumbers[shardID] = StartingSequenceNumber{SequenceNumber: sequenceNumber, After: after}
+	return c
+}
+
+// WithAuditHook configures the hook receiving an audit entry for every lease and checkpoint mutation.
+func (c *KinesisClientLibConfiguration) WithAuditHook(hook util.AuditHook, policy util.AuditValuePolicy) *KinesisClientLibConfiguration {
+	c.AuditHook = hook
+	c.AuditValuePolicy = policy
+	return c
+}
+
+// WithCooperativeShutdown enables signaling the leases released on shutdown to the peers, which check for such
+// signals every pollIntervalMillis.
+func (c *KinesisClientLibConfiguration) WithCooperativeShutdown(pollIntervalMillis int) *KinesisClientLibConfiguration {
+	checkIsValuePositive("LeaseReleasePollIntervalMillis", pollIntervalMillis)
+	c.CooperativeShutdown = true
+	c.LeaseReleasePollIntervalMillis = pollIntervalMillis
+	return c
+}
+
+// WithReadOnlyFollower makes the worker a read-only follower of all shards, see ReadOnlyFollower.
+func (c *KinesisClientLibConfiguration) WithReadOnlyFollower() *KinesisClientLibConfiguration {
+	c.ReadOnlyFollower = true
+	return c
+}
+
+// WithKinesisClientConfig configures the request timeout and max connections of the Kinesis client.
+func (c *KinesisClientLibConfiguration) WithKinesisClientConfig(requestTimeoutMillis, maxConnections int) *KinesisClientLibConfiguration {
+	checkIsValuePositive("KinesisRequestTimeoutMillis", requestTimeoutMillis)
+	checkIsValuePositive("KinesisMaxConnections", maxConnections)
+	c.KinesisRequestTimeoutMillis = requestTimeoutMillis
+	c.KinesisMaxConnections = maxConnections
+	return c
+}
+
+// WithDynamoDBClientConfig configures the request timeout and max connections of the DynamoDB client.
+func (c *KinesisClientLibConfiguration) WithDynamoDBClientConfig(requestTimeoutMillis, maxConnections int) *KinesisClientLibConfiguration {
+	checkIsValuePositive("DynamoDBRequestTimeoutMillis", requestTimeoutMillis)
+	checkIsValuePositive("DynamoDBMaxConnections", maxConnections)
+	c.DynamoDBRequestTimeoutMillis = requestTimeoutMillis
+	c.DynamoDBMaxConnections = maxConnections
+	return c
+}
+
+// WithWorkerStateStore configures the store persisting the worker state across restarts.
+func (c *KinesisClientLibConfiguration) WithWorkerStateStore(store WorkerStateStore) *KinesisClientLibConfiguration {
+	c.WorkerStateStore = store
+	return c
+}
+
+// WithStrictCheckpointMonotonicity enables or disables rejecting checkpoints behind the current one.
+func (c *KinesisClientLibConfiguration) WithStrictCheckpointMonotonicity(strict bool) *KinesisClientLibConfiguration {
+	c.StrictCheckpointMonotonicity = strict
+	return c
+}
+
+// WithLeaseReadConsistency configures the consistency of the lease table reads.
+func (c *KinesisClientLibConfiguration) WithLeaseReadConsistency(consistency LeaseReadConsistency) *KinesisClientLibConfiguration {
+	c.LeaseReadConsistency = consistency
+	return c
+}
+
+// WithProcessRecordsTimeoutMillis makes the consumer give up a shard whose record processor takes longer than this
+// to process a batch of record.
+func (c *KinesisClientLibConfiguration) WithProcessRecordsTimeoutMillis(timeout int) *KinesisClientLibConfiguration {
+	checkIsValuePositive("ProcessRecordsTimeoutMillis", timeout)
+	c.ProcessRecordsTimeoutMillis = timeout
+	return c
+}
+
+// WithProcessorWarmUpMillis configures the grace period after Initialize during which slow processing is tolerated.
+func (c *KinesisClientLibConfiguration) WithProcessorWarmUpMillis(warmUp int) *KinesisClientLibConfiguration {
+	checkIsValuePositive("ProcessorWarmUpMillis", warmUp)
+	c.ProcessorWarmUpMillis = warmUp
+	return c
+}
+
+// WithReshardCoalesceWindowMillis configures how long to wait for the children of a reshard before rebalancing leases.
+func (c *KinesisClientLibConfiguration) WithReshardCoalesceWindowMillis(window int) *KinesisClientLibConfiguration {
+	checkIsValuePositive("ReshardCoalesceWindowMillis", window)
+	c.ReshardCoalesceWindowMillis = window
+	return c
+}
+
+// WithIdleShardSyncIntervalMillis configures how often a stream without open shards is re-discovered.
+func (c *KinesisClientLibConfiguration) WithIdleShardSyncIntervalMillis(interval int) *KinesisClientLibConfiguration {
+	checkIsValuePositive("IdleShardSyncIntervalMillis", interval)
+	c.IdleShardSyncIntervalMillis = interval
+	return c
+}
+
+// WithLeaseAttributeNames maps the attributes of the lease items to custom names, e.g. to fit the schema of an
+// existing lease table. Every attribute must be named, and the names must be distinct.
+func (c *KinesisClientLibConfiguration) WithLeaseAttributeNames(names LeaseAttributeNames) *KinesisClientLibConfiguration {
+	attributes := map[string]string{
+		"LeaseKey":                     names.LeaseKey,
+		"LeaseOwner":                   names.LeaseOwner,
+		"LeaseTimeout":                 names.LeaseTimeout,
+		"Checkpoint":                   names.Checkpoint,
+		"ParentShardId":                names.ParentShardId,
+		"OwnerSwitchesSinceCheckpoint": names.OwnerSwitchesSinceCheckpoint,
+	}
+	seen := make(map[string]string)
+	for attribute, name := range attributes {
+		checkIsValueNotEmpty("LeaseAttributeNames."+attribute, name)
+		if other, ok := seen[name]; ok {
+			log.Panicf("Lease attributes %v and %v are both named %v", other, attribute, name)
+		}
+		seen[name] = attribute
+	}
+	c.LeaseAttributeNames = names
+	return c
+}
+
+// WithCheckpointOnShutdown configures whether the library checkpoints at the last processed record when the record
+// processor is shut down for the given reason. A ZOMBIE lost its lease, so it can't checkpoint on shutdown.
+func (c *KinesisClientLibConfiguration) WithCheckpointOnShutdown(reason util.ShutdownReason, checkpoint bool) *KinesisClientLibConfiguration {
+	if reason == util.ZOMBIE && checkpoint {
+		// There is no point to continue for incorrect configuration. Fail fast!
+		log.Panicf("Checkpoint on shutdown can't be enabled for %v", aws.StringValue(util.ShutdownReasonMessage(reason)))
+	}
+	if c.CheckpointOnShutdown == nil {
+		c.CheckpointOnShutdown = make(map[util.ShutdownReason]bool)
+	}
+	c.CheckpointOnShutdown[reason] = checkpoint
+	return c
+}
+
+// WithMaxInFlightBatches bounds how many delivered batches of a shard can be outstanding before fetching pauses.
+func (c *KinesisClientLibConfiguration) WithMaxInFlightBatches(max int) *KinesisClientLibConfiguration {
+	checkIsValuePositive("MaxInFlightBatches", max)
+	c.MaxInFlightBatches = max
+	return c
+}
+
+// WithLagSnapshotIntervalMillis enables writing the lag of every shard into its lease at the given interval.
+func (c *KinesisClientLibConfiguration) WithLagSnapshotIntervalMillis(interval int) *KinesisClientLibConfiguration {
+	checkIsValuePositive("LagSnapshotIntervalMillis", interval)
+	c.LagSnapshotIntervalMillis = interval
+	return c
+}
+
+// WithAvailabilityZone tags the worker with its availability zone and spreads the leases across zones.
+func (c *KinesisClientLibConfiguration) WithAvailabilityZone(availabilityZone string) *KinesisClientLibConfiguration {
+	checkIsValueNotEmpty("AvailabilityZone", availabilityZone)
+	c.AvailabilityZone = availabilityZone
+	return c
+}
+
+// WithLeaseRenewalFailureTolerance sets how many consecutive failed lease renewals are tolerated.
+func (c *KinesisClientLibConfiguration) WithLeaseRenewalFailureTolerance(tolerance int) *KinesisClientLibConfiguration {
+	checkIsValuePositive("LeaseRenewalFailureTolerance", tolerance)
+	c.LeaseRenewalFailureTolerance = tolerance
+	return c
+}
+
+// WithBatchingWindow accumulates the fetched record until maxRecords of them are fetched or windowMillis have
+// passed, before delivering them to the record processor as one batch.
+func (c *KinesisClientLibConfiguration) WithBatchingWindow(maxRecords, windowMillis int) *KinesisClientLibConfiguration {
+	checkIsValuePositive("BatchingWindowMaxRecords", maxRecords)
+	checkIsValuePositive("BatchingWindowMillis", windowMillis)
+	c.BatchingWindowMaxRecords = maxRecords
+	c.BatchingWindowMillis = windowMillis
+	return c
+}
+
+// WithDuplicateRecordPolicy configures how record with a sequence number already delivered are handled.
+func (c *KinesisClientLibConfiguration) WithDuplicateRecordPolicy(policy DuplicateRecordPolicy) *KinesisClientLibConfiguration {
+	c.DuplicateRecordPolicy = policy
+	return c
+}
+
+// WithRecordDeliveryConcurrency opts order independent record processors into processing the record of a batch
+// concurrently, giving up on the record order within a shard.
+func (c *KinesisClientLibConfiguration) WithRecordDeliveryConcurrency(concurrency int) *KinesisClientLibConfiguration {
+	checkIsValuePositive("RecordDeliveryConcurrency", concurrency)
+	c.RecordDeliveryConcurrency = concurrency
+	return c
+}
+
+// WithLeaseTakeoverGraceMillis sets the grace period beyond the expiry of the lease of another worker before it is
+// taken over.
+func (c *KinesisClientLibConfiguration) WithLeaseTakeoverGraceMillis(grace int) *KinesisClientLibConfiguration {
+	checkIsValuePositive("LeaseTakeoverGraceMillis", grace)
+	c.LeaseTakeoverGraceMillis = grace
+	return c
+}
+
+// WithCheckpointMinRecords defers the checkpoints until minRecords record have been delivered since the last one,
+// or maxDelayMillis have passed since a checkpoint was deferred.
+func (c *KinesisClientLibConfiguration) WithCheckpointMinRecords(minRecords, maxDelayMillis int) *KinesisClientLibConfiguration {
+	checkIsValuePositive("CheckpointMinRecords", minRecords)
+	checkIsValuePositive("CheckpointMaxDelayMillis", maxDelayMillis)
+	c.CheckpointMinRecords = minRecords
+	c.CheckpointMaxDelayMillis = maxDelayMillis
+	return c
+}
+
+// WithThroughputSmoothingFactor sets the weight of the latest GetRecords call in the moving average of the
+// processing throughput.
+func (c *KinesisClientLibConfiguration) WithThroughputSmoothingFactor(factor float64) *KinesisClientLibConfiguration {
+	if factor <= 0 || factor > 1 {
+		// There is no point to continue for incorrect configuration. Fail fast!
+		log.Panicf("Value in (0, 1] expected for ThroughputSmoothingFactor, actual: %v", factor)
+	}
+	c.ThroughputSmoothingFactor = factor
+	return c
+}
+
+// WithLeaseTableStartupRetries sets how many times a throttled lookup of the lease table is retried at startup.
+func (c *KinesisClientLibConfiguration) WithLeaseTableStartupRetries(retries int) *KinesisClientLibConfiguration {
+	checkIsValuePositive("LeaseTableStartupRetries", retries)
+	c.LeaseTableStartupRetries = retries
+	return c
+}
+
+// WithShardDiscoveryBackoff widens the shard discovery interval from minMillis up to maxMillis while the shards of
+// the stream are stable.
+func (c *KinesisClientLibConfiguration) WithShardDiscoveryBackoff(minMillis, maxMillis int) *KinesisClientLibConfiguration {
+	checkIsValuePositive("ShardDiscoveryMinIntervalMillis", minMillis)
+	checkIsValuePositive("ShardDiscoveryMaxIntervalMillis", maxMillis)
+	if maxMillis < minMillis {
+		// There is no point to continue for incorrect configuration. Fail fast!
+		log.Panicf("ShardDiscoveryMaxIntervalMillis %v is below ShardDiscoveryMinIntervalMillis %v", maxMillis, minMillis)
+	}
+	c.ShardDiscoveryMinIntervalMillis = minMillis
+	c.ShardDiscoveryMaxIntervalMillis = maxMillis
+	return c
+}
+
+// WithLeaseOwnershipHistoryLength sets how many ownership changes of every shard are kept.
+func (c *KinesisClientLibConfiguration) WithLeaseOwnershipHistoryLength(length int) *KinesisClientLibConfiguration {
+	checkIsValuePositive("LeaseOwnershipHistoryLength", length)
+	c.LeaseOwnershipHistoryLength = length
+	return c
+}
+
+// WithEmptyBatchIntervalMillis delivers an empty batch to the record processor every interval while no record arrive.
+func (c *KinesisClientLibConfiguration) WithEmptyBatchIntervalMillis(interval int) *KinesisClientLibConfiguration {
+	checkIsValuePositive("EmptyBatchIntervalMillis", interval)
+	c.EmptyBatchIntervalMillis = interval
+	return c
+}
+
+// WithShardListingRetries sets how many times a throttled listing of the shards is retried, and the backoff before
+// the first retry.
+func (c *KinesisClientLibConfiguration) WithShardListingRetries(retries, backoffMillis int) *KinesisClientLibConfiguration {
+	checkIsValuePositive("ShardListingRetries", retries)
+	checkIsValuePositive("ShardListingBackoffMillis", backoffMillis)
+	c.ShardListingRetries = retries
+	c.ShardListingBackoffMillis = backoffMillis
+	return c
+}
+
+// WithPoisonShardDetection considers a shard poison once its lease changed owner ownerSwitches times since its last
+// checkpoint, and reacts to it according to the policy.
+func (c *KinesisClientLibConfiguration) WithPoisonShardDetection(ownerSwitches int, policy PoisonShardPolicy) *KinesisClientLibConfiguration {
+	checkIsValuePositive("PoisonShardOwnerSwitches", ownerSwitches)
+	c.PoisonShardOwnerSwitches = ownerSwitches
+	c.PoisonShardPolicy = policy
+	return c
+}
+
+// WithLeaseTableConfig sets the billing mode and capacity of the lease table, when the worker creates it.
+func (c *KinesisClientLibConfiguration) WithLeaseTableConfig(config LeaseTableConfig) *KinesisClientLibConfiguration {
+	switch config.BillingMode {
+	case dynamodb.BillingModeProvisioned:
+		if config.ReadCapacityUnits < 0 || config.WriteCapacityUnits < 0 {
+			// There is no point to continue for incorrect configuration. Fail fast!
+			log.Panicf("Non-negative capacity expected for LeaseTableConfig, actual: %d read, %d write",
+				config.ReadCapacityUnits, config.WriteCapacityUnits)
+		}
+	case dynamodb.BillingModePayPerRequest:
+	default:
+		// There is no point to continue for incorrect configuration. Fail fast!
+		log.Panicf("Unknown billing mode for LeaseTableConfig: %s", config.BillingMode)
+	}
+	c.LeaseTableConfig = config
+	return c
+}
+
+// WithCheckpointConfig makes the library checkpoint on behalf of the record processors, see CheckpointConfig.
+func (c *KinesisClientLibConfiguration) WithCheckpointConfig(config CheckpointConfig) *KinesisClientLibConfiguration {
+	if config.Interval < 0 || config.RecordCount < 0 || config == (CheckpointConfig{}) {
+		// There is no point to continue for incorrect configuration. Fail fast!
+		log.Panicf("Positive Interval or RecordCount expected for CheckpointConfig, actual: %v, %d", config.Interval,
+			config.RecordCount)
+	}
+	c.CheckpointConfig = config
+	return c
+}
+
+// WithLeaseGC bounds the concurrency and the rate per second of the lease deletions when garbage collecting the
+// leases of the shards which no longer exist in the stream. A rate of 0 leaves it unlimited.
+func (c *KinesisClientLibConfiguration) WithLeaseGC(concurrency, ratePerSecond int) *KinesisClientLibConfiguration {
+	checkIsValuePositive("LeaseGCConcurrency", concurrency)
+	if ratePerSecond < 0 {
+		log.Panicf("Non-negative value expected for LeaseGCRatePerSecond, actual: %v", ratePerSecond)
+	}
+	c.LeaseGCConcurrency = concurrency
+	c.LeaseGCRatePerSecond = ratePerSecond
+	return c
+}
+
+// WithShardIteratorRetries sets how many times a transient failure of the acquisition of a shard iterator is
+// retried, and the backoff before the first retry.
+func (c *KinesisClientLibConfiguration) WithShardIteratorRetries(retries, backoffMillis int) *KinesisClientLibConfiguration {
+	checkIsValuePositive("ShardIteratorRetries", retries)
+	checkIsValuePositive("ShardIteratorBackoffMillis", backoffMillis)
+	c.ShardIteratorRetries = retries
+	c.ShardIteratorBackoffMillis = backoffMillis
+	return c
+}
+
+// WithEnhancedFanOut reads the shards with enhanced fan-out, as the stream consumer of the given name.
+func (c *KinesisClientLibConfiguration) WithEnhancedFanOut(consumerName string) *KinesisClientLibConfiguration {
+	checkIsValueNotEmpty("ConsumerName", consumerName)
+	c.ConsumerName = consumerName
+	return c
+}
+
+// WithQuiesceWindow sets the window over which a quiesced worker hands its leases off.
+func (c *KinesisClientLibConfiguration) WithQuiesceWindow(windowMillis int) *KinesisClientLibConfiguration {
+	checkIsValuePositive("QuiesceWindowMillis", windowMillis)
+	c.QuiesceWindowMillis = windowMillis
+	return c
+}
+
+// WithAggregation configures whether the record aggregated by the Kinesis Producer Library are deaggregated before
+// they are delivered to the record processor. Consumers of producers not using the KPL can disable it.
+func (c *KinesisClientLibConfiguration) WithAggregation(enabled bool) *KinesisClientLibConfiguration {
+	c.EnableAggregation = enabled
+	return c
+}
+
+// WithPrematureShardEnd configures whether the record processors may checkpoint SHARD_END before the end of the
+// shard is reached, for advanced cases such as abandoning a shard on purpose.
+func (c *KinesisClientLibConfiguration) WithPrematureShardEnd(allowed bool) *KinesisClientLibConfiguration {
+	c.AllowPrematureShardEnd = allowed
+	return c
+}
+
+// WithEnhancedFanOutTimeouts tunes how the long-lived enhanced fan-out connections are kept alive and how promptly
+// dead ones are detected: the HTTP/2 ping interval of idle connections, the timeout of the pings, and how long a
+// shard subscription waits for an event before it is renewed.
+func (c *KinesisClientLibConfiguration) WithEnhancedFanOutTimeouts(keepAliveMillis, pingTimeoutMillis,
+	readTimeoutMillis int) *KinesisClientLibConfiguration {
+	checkIsValuePositive("FanOutKeepAliveMillis", keepAliveMillis)
+	checkIsValuePositive("FanOutPingTimeoutMillis", pingTimeoutMillis)
+	checkIsValuePositive("FanOutReadTimeoutMillis", readTimeoutMillis)
+	c.FanOutKeepAliveMillis = keepAliveMillis
+	c.FanOutPingTimeoutMillis = pingTimeoutMillis
+	c.FanOutReadTimeoutMillis = readTimeoutMillis
+	return c
+}
+
+// WithLeaseStealing enables balancing the leases across the workers: a worker holding less than its fair share takes
+// up to maxLeasesToSteal leases from the most loaded worker per lease acquisition, after a random delay of up to
+// jitterMillis. The previous owner shuts the record processor of a stolen shard down as ZOMBIE.
+func (c *KinesisClientLibConfiguration) WithLeaseStealing(maxLeasesToSteal,
+	jitterMillis int) *KinesisClientLibConfiguration {
+	checkIsValuePositive("MaxLeasesToStealAtOneTime", maxLeasesToSteal)
+	checkIsValuePositive("LeaseStealingJitterMillis", jitterMillis)
+	c.EnableLeaseStealing = true
+	c.MaxLeasesToStealAtOneTime = maxLeasesToSteal
+	c.LeaseStealingJitterMillis = jitterMillis
+	return c
+}
+
+// WithShutdownOrder configures the order of the shutdown steps of the shard consumers. Fetching has to stop first
+// and the lease has to be released last, the checkpoint may be written before or after the record processor shuts
+// down, e.g. after it flushed the processed records downstream.
+func (c *KinesisClientLibConfiguration) WithShutdownOrder(steps ...util.ShutdownStep) *KinesisClientLibConfiguration {
+	seen := make(map[util.ShutdownStep]bool)
+	for _, step := range steps {
+		seen[step] = true
+	}
+	if len(steps) != 4 || len(seen) != 4 || steps[0] != util.STOP_FETCHING || steps[3] != util.RELEASE_LEASE ||
+		!seen[util.CHECKPOINT] || !seen[util.SHUTDOWN_PROCESSOR] {
+		log.Panicf("Invalid shutdown order %v, fetching has to stop first and the lease has to be released last", steps)
+	}
+	c.ShutdownOrder = steps
+	return c
+}
+
+// WithShutdownHook configures the hook called before and after every shutdown step of the shard consumers.
+func (c *KinesisClientLibConfiguration) WithShutdownHook(hook util.ShutdownHook) *KinesisClientLibConfiguration {
+	c.ShutdownHook = hook
+	return c
+}
+
+// WithMultiStreamConfig makes the worker consume the listed streams instead of StreamName, which is then ignored. A
+// single stream replaces StreamName, its leases aren't namespaced.
+func (c *KinesisClientLibConfiguration) WithMultiStreamConfig(config MultiStreamConfig) *KinesisClientLibConfiguration {
+	if len(config.StreamARNs) == 0 {
+		// There is no point to continue for incorrect configuration. Fail fast!
+		log.Panicf("Non-empty StreamARNs expected for MultiStreamConfig")
+	}
+	seen := make(map[string]bool)
+	for _, streamARN := range config.StreamARNs {
+		stream, err := parseStreamARN(streamARN)
+		if err != nil {
+			log.Panicf("Invalid stream ARN in MultiStreamConfig: %v", err)
+		}
+		if stream.region != c.RegionName {
+			log.Panicf("Stream %s expected in region %s, actual: %s", stream.name, c.RegionName, stream.region)
+		}
+		if seen[streamARN] {
+			log.Panicf("Duplicate stream ARN in MultiStreamConfig: %s", streamARN)
+		}
+		seen[streamARN] = true
+
+		if len(config.StreamARNs) == 1 {
+			c.StreamName = stream.name
+		}
+	}
+
+	c.MultiStreamConfig = config
+	return c
+}
+
+// WithShardMapMaxAgeMillis configures how long leases are still acquired from the shards discovered last while the
+// shard discovery fails.
+func (c *KinesisClientLibConfiguration) WithShardMapMaxAgeMillis(maxAgeMillis int) *KinesisClientLibConfiguration {
+	checkIsValuePositive("ShardMapMaxAgeMillis", maxAgeMillis)
+	c.ShardMapMaxAgeMillis = maxAgeMillis
+	return c
+}
+
+// WithSharedLeaseTable keeps the leases in the given lease table shared with other applications, namespacing their
+// keys with the ApplicationName. The ApplicationName may only contain letters, digits, '_', '-' and '.'.
+func (c *KinesisClientLibConfiguration) WithSharedLeaseTable(tableName string) *KinesisClientLibConfiguration {
+	checkIsValueNotEmpty("TableName", tableName)
+	if !applicationNameRegexp.MatchString(c.ApplicationName) {
+		// There is no point to continue for incorrect configuration. Fail fast!
+		log.Panicf("ApplicationName of a shared lease table may only contain letters, digits, '_', '-' and '.', "+
+			"actual: %v", c.ApplicationName)
+	}
+	c.TableName = tableName
+	c.SharedLeaseTable = true
+	return c
+}
+
+// WithRetryBackoff configures the backoff between the retries of the failed calls to Kinesis and DynamoDB, randomized
+// between 0 and baseMillis doubling with every retry up to maxMillis, and the number of attempts of a call.
+func (c *KinesisClientLibConfiguration) WithRetryBackoff(baseMillis, maxMillis, attempts int) *KinesisClientLibConfiguration {
+	checkIsValuePositive("RetryBackoff.Base", baseMillis)
+	checkIsValuePositive("RetryBackoff.Max", maxMillis)
+	checkIsValuePositive("RetryBackoff.Attempts", attempts)
+	if maxMillis < baseMillis {
+		// There is no point to continue for incorrect configuration. Fail fast!
+		log.Panicf("RetryBackoff.Max %d expected to be at least RetryBackoff.Base %d", maxMillis, baseMillis)
+	}
+	c.RetryBackoff = util.Backoff{
+		Base:     time.Duration(baseMillis) * time.Millisecond,
+		Max:      time.Duration(maxMillis) * time.Millisecond,
+		Attempts: attempts,
+	}
+	return c
+}
+
+// WithSummaryLogIntervalMillis makes the worker log a summary of its activity at the given interval.
+func (c *KinesisClientLibConfiguration) WithSummaryLogIntervalMillis(intervalMillis int) *KinesisClientLibConfiguration {
+	checkIsValuePositive("SummaryLogIntervalMillis", intervalMillis)
+	c.SummaryLogIntervalMillis = intervalMillis
+	return c
+}
+
+// WithProcessingFailurePolicy configures the policy applied to the batches the record processor failed, according to
+// whether their error is retryable, see util.IsRetryable.
+func (c *KinesisClientLibConfiguration) WithProcessingFailurePolicy(policy record.FailurePolicy) *KinesisClientLibConfiguration {
+	if policy < record.STOP || policy > record.DEAD_LETTER {
+		// There is no point to continue for incorrect configuration. Fail fast!
+		log.Panicf("Invalid processing failure policy: %d", policy)
+	}
+	c.ProcessingFailurePolicy = policy
+	return c
+}
+
+// WithMaxChildLeasesPerAcquisition bounds the leases of new child shards the worker creates per lease acquisition
+// cycle.
+func (c *KinesisClientLibConfiguration) WithMaxChildLeasesPerAcquisition(n int) *KinesisClientLibConfiguration {
+	checkIsValuePositive("MaxChildLeasesPerAcquisition", n)
+	c.MaxChildLeasesPerAcquisition = n
+	return c
+}
+
+// WithCoalescedLeaseRenewals enables or disables renewing the leases in the same write as the checkpoints.
+func (c *KinesisClientLibConfiguration) WithCoalescedLeaseRenewals(coalesce bool) *KinesisClientLibConfiguration {
+	c.CoalesceLeaseRenewals = coalesce
+	return c
+}
+
+// WithRecreateDeletedLeaseTable enables or disables creating the lease table again if it is deleted while the worker
+// runs.
+func (c *KinesisClientLibConfiguration) WithRecreateDeletedLeaseTable(recreate bool) *KinesisClientLibConfiguration {
+	c.RecreateDeletedLeaseTable = recreate
+	return c
+}
@@ -0,0 +1,101 @@
+package goKCL
+
+import (
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/kinesis"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/guygma/goKCL/util"
+)
+
+const (
+	// consumerActivationPollInterval is how often the status of a stream consumer being registered is polled.
+	consumerActivationPollInterval = time.Second
+
+	// consumerActivationPolls bounds the wait for a stream consumer being registered to become active.
+	consumerActivationPolls = 60
+)
+
+// registerStreamConsumer looks the stream consumer of the configured name up, registering it if it doesn't exist,
+// and waits until it is active. The worker falls back to polling if the Kinesis client doesn't support enhanced
+// fan-out, or if the stream has no room for another consumer.
+func (w *Worker) registerStreamConsumer() error {
+	efo, ok := w.kc.(EnhancedFanOutAPI)
+	if !ok {
+		log.Warn("Kinesis client doesn't support enhanced fan-out, polling the shards instead.")
+		return nil
+	}
+
+	summary, err := w.kc.DescribeStreamSummary(&kinesis.DescribeStreamSummaryInput{StreamName: aws.String(w.streamName)})
+	if err != nil {
+		return err
+	}
+	streamARN := summary.StreamDescriptionSummary.StreamARN
+	consumerName := aws.String(w.kclConfig.ConsumerName)
+
+	var consumerARN, status *string
+	described, err := efo.DescribeStreamConsumer(&kinesis.DescribeStreamConsumerInput{
+		StreamARN:    streamARN,
+		ConsumerName: consumerName,
+	})
+	if awsErr, ok := err.(awserr.Error); ok && awsErr.Code() == kinesis.ErrCodeResourceNotFoundException {
+		log.Infof("Registering consumer %s of stream %s", w.kclConfig.ConsumerName, w.streamName)
+		registered, err := efo.RegisterStreamConsumer(&kinesis.RegisterStreamConsumerInput{
+			StreamARN:    streamARN,
+			ConsumerName: consumerName,
+		})
+		if awsErr, ok := err.(awserr.Error); ok && awsErr.Code() == kinesis.ErrCodeLimitExceededException {
+			log.Warnf("No enhanced fan-out capacity left for consumer %s of stream %s, polling the shards instead: %v",
+				w.kclConfig.ConsumerName, w.streamName, err)
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		w.consumerRegistered = true
+		consumerARN, status = registered.Consumer.ConsumerARN, registered.Consumer.ConsumerStatus
+	} else if err != nil {
+		return err
+	} else {
+		consumerARN, status = described.ConsumerDescription.ConsumerARN, described.ConsumerDescription.ConsumerStatus
+	}
+	w.consumerARN = aws.StringValue(consumerARN)
+
+	for polls := 0; aws.StringValue(status) != kinesis.ConsumerStatusActive; polls++ {
+		if polls >= consumerActivationPolls {
+			return util.KinesisClientLibDependencyError.MakeErr().
+				WithDetail("consumer %s is still %s", w.consumerARN, aws.StringValue(status))
+		}
+		time.Sleep(consumerActivationPollInterval)
+		described, err := efo.DescribeStreamConsumer(&kinesis.DescribeStreamConsumerInput{
+			ConsumerARN: aws.String(w.consumerARN),
+		})
+		if err != nil {
+			return err
+		}
+		status = described.ConsumerDescription.ConsumerStatus
+	}
+
+	log.Infof("Reading the shards of stream %s with enhanced fan-out as consumer %s", w.streamName, w.consumerARN)
+	return nil
+}
+
+// deregisterStreamConsumer deregisters the stream consumer if the worker registered it.
+func (w *Worker) deregisterStreamConsumer() {
+	if !w.consumerRegistered {
+		return
+	}
+
+	log.Infof("Deregistering consumer %s", w.consumerARN)
+	efo := w.kc.(EnhancedFanOutAPI)
+	if _, err := efo.DeregisterStreamConsumer(&kinesis.DeregisterStreamConsumerInput{
+		ConsumerARN: aws.String(w.consumerARN),
+	}); err != nil {
+		log.Errorf("Failed to deregister consumer %s: %+v", w.consumerARN, err)
+		return
+	}
+	w.consumerRegistered = false
+}
@@ -1,22 +1,30 @@
 package goKCL
 
 import (
+	"context"
 	"errors"
+	"fmt"
+	"math/rand"
+	"sort"
+	"strings"
 	"sync"
 	"time"
 
 	log "github.com/sirupsen/logrus"
 
 	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/kinesis"
-	"github.com/aws/aws-sdk-go/service/kinesis/kinesisiface"
 
 	"github.com/guygma/goKCL/record"
 	"github.com/guygma/goKCL/shard"
 	"github.com/guygma/goKCL/util"
 )
 
+// retentionRefreshInterval is how long the retention period of the stream is cached.
+const retentionRefreshInterval = time.Hour
+
 //TODO: Determine if any of these fields need to be exported (capitalized).
 // High level struct that governs KCL execution.
 type Worker struct {
@@ -26,17 +34,98 @@ type Worker struct {
 
 	processorFactory record.IRecordProcessorFactory
 	kclConfig        *KinesisClientLibConfiguration
-	kc               kinesisiface.KinesisAPI
+	kc               KinesisAPI
 	checkpointer     shard.Checkpointer
 
 	stop      *chan struct{}
 	waitGroup *sync.WaitGroup
-	done      bool
 
+	// the shutdown is requested once, and done, closing done, once the shard consumers exited and the worker was
+	// cleaned up
+	shutdownOnce sync.Once
+	done         chan struct{}
+
+	// the shards of the stream: only the event loop writes the map, under shardMux, and reads it without locking.
+	// Reads from other goroutines go through lookupShard and listShards.
 	shardStatus map[string]*shard.Status
+	shardMux    sync.RWMutex
 
 	metricsConfig *util.MonitoringConfiguration
 	mService      util.MonitoringService
+	retryBudget   *util.RetryBudget
+
+	lastLeaseAcquisition time.Time
+	// last time a new child shard of a reshard was found
+	lastReshardChild time.Time
+	// the stream has no open shard
+	idle bool
+	// adaptive shard discovery: current interval and time of the next discovery
+	discoveryInterval time.Duration
+	nextDiscovery     time.Time
+	// last time the shards were discovered, and whether the shard map got stale since
+	lastShardSync time.Time
+	staleShardMap bool
+	// throttled listings of the shards since the last summary of the worker activity
+	listingThrottles int
+
+	// cooperative shutdown: signals leases released by departing peers to the event loop
+	releaseSignaler shard.LeaseReleaseSignaler
+	leasesReleased  chan struct{}
+
+	// writes the lag snapshots of the shards to the lease table
+	lagRecorder shard.LagRecorder
+
+	// lists the leases to spread them across availability zones and to balance them across the workers
+	leaseLister shard.LeaseLister
+
+	// takes leases from the most loaded worker, nil unless lease stealing is enabled
+	leaseStealer shard.LeaseStealer
+
+	// reports the lease and checkpoint mutations to the AuditHook, nil unless configured, closed on shutdown
+	auditing *shard.AuditingCheckpointer
+
+	// leases held before a restart, acquired first
+	preferredLeases map[string]bool
+
+	// shards followed by a read-only follower
+	following map[string]bool
+	followMux sync.Mutex
+
+	// cached retention period of the stream
+	retentionPeriod    time.Duration
+	retentionFetchedAt time.Time
+	retentionMux       sync.Mutex
+
+	// starting sequence numbers which haven't been applied yet
+	startingSequenceNumbers map[string]StartingSequenceNumber
+	startingMux             sync.Mutex
+
+	// leases of the shards which no longer exist in the stream, still to be deleted, and whether some of them are
+	// being deleted in the background
+	staleLeases map[string]bool
+	staleMux    sync.Mutex
+	collecting  bool
+
+	// enhanced fan-out: ARN of the stream consumer reading the shards, empty while polling, and whether the worker
+	// registered it
+	consumerARN        string
+	consumerRegistered bool
+	// client of the shard subscriptions, kept alive over HTTP/2 if the worker created the Kinesis client, nil to
+	// subscribe with the Kinesis client
+	fanOutKc EnhancedFanOutAPI
+
+	// closed by Resume while the worker is quiesced, nil otherwise
+	quiesce    chan struct{}
+	quiesceMux sync.Mutex
+
+	// ARN of the stream consumed, if configured with a MultiStreamConfig, and the workers of the streams when
+	// consuming several
+	streamARN     string
+	streamWorkers []*Worker
+
+	// closed once the worker completed its first lease acquisition cycle, see WaitReady
+	ready     chan struct{}
+	readyOnce sync.Once
 }
 
 // NewWorker constructs a Worker instance for processing Kinesis stream data.
@@ -51,7 +140,17 @@ func NewWorker(factory record.IRecordProcessorFactory,
 		processorFactory: factory,
 		kclConfig:        kclConfig,
 		metricsConfig:    metricsConfig,
-		done:             false,
+		done:             make(chan struct{}),
+		ready:            make(chan struct{}),
+	}
+
+	w.startingSequenceNumbers = make(map[string]StartingSequenceNumber)
+	for shardID, start := range kclConfig.StartingSequenceNumbers {
+		w.startingSequenceNumbers[shardID] = start
+	}
+
+	if len(kclConfig.MultiStreamConfig.StreamARNs) == 1 {
+		w.streamARN = kclConfig.MultiStreamConfig.StreamARNs[0]
 	}
 
 	if w.metricsConfig == nil {
@@ -62,11 +161,18 @@ func NewWorker(factory record.IRecordProcessorFactory,
 }
 
 // WithKinesis is used to provide Kinesis service for either custom implementation or unit testing.
-func (w *Worker) WithKinesis(svc kinesisiface.KinesisAPI) *Worker {
+func (w *Worker) WithKinesis(svc KinesisAPI) *Worker {
 	w.kc = svc
 	return w
 }
 
+// WithMetricsPublisher routes the metrics of the worker to a custom MetricsPublisher, e.g. an in-house metrics
+// system, instead of the configured monitoring service.
+func (w *Worker) WithMetricsPublisher(publisher util.MetricsPublisher) *Worker {
+	w.metricsConfig.Publisher = publisher
+	return w
+}
+
 // WithCheckpointer is used to provide a custom checkpointer service for non-dynamodb implementation
 // or unit testing.
 func (w *Worker) WithCheckpointer(checker shard.Checkpointer) *Worker {
@@ -74,13 +180,28 @@ func (w *Worker) WithCheckpointer(checker shard.Checkpointer) *Worker {
 	return w
 }
 
+// WithLeaseStore stores the leases and checkpoints in a custom lease store, e.g. a relational database, instead of
+// the DynamoDB lease table, see shard.LeaseStore.
+func (w *Worker) WithLeaseStore(store shard.LeaseStore) *Worker {
+	w.checkpointer = shard.NewLeaseStoreCheckpointer(w.kclConfig, store)
+	return w
+}
+
 // Run starts consuming data from the stream, and pass it to the application record processors.
 func (w *Worker) Start() error {
+	if w.multiStream() {
+		return w.startStreams()
+	}
+
 	if err := w.initialize(); err != nil {
 		log.Errorf("Failed to initialize Worker: %+v", err)
 		return err
 	}
 
+	if w.kclConfig.WorkerStateStore != nil {
+		w.restoreState()
+	}
+
 	// Start monitoring service
 	log.Info("Starting monitoring service.")
 	if err := w.mService.Start(); err != nil {
@@ -88,28 +209,338 @@ func (w *Worker) Start() error {
 		return err
 	}
 
+	if w.releaseSignaler != nil {
+		log.Info("Watching for leases released by departing workers.")
+		go w.watchLeaseReleases()
+	}
+
 	log.Info("Starting worker event loop.")
 	// entering event loop
 	go w.eventLoop()
 	return nil
 }
 
+// WaitReady blocks until the worker completed its first shard discovery and lease acquisition cycle, e.g. so that
+// an orchestrator marks the worker ready only once it actually consumes the stream, or until ctx is done. A worker
+// consuming several streams is ready once the workers of all its streams are.
+func (w *Worker) WaitReady(ctx context.Context) error {
+	select {
+	case <-w.ready:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+
+	for _, sw := range w.streamWorkers {
+		if err := sw.WaitReady(ctx); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// markReady releases the callers of WaitReady.
+func (w *Worker) markReady() {
+	w.readyOnce.Do(func() { close(w.ready) })
+}
+
 // Shutdown signals worker to shutdown. Worker will try initiating shutdown of all record processors.
 func (w *Worker) Shutdown() {
+	w.ShutdownWithContext(context.Background())
+}
+
+// ShutdownWithContext shuts the worker down gracefully, e.g. from a SIGTERM handler: the shard consumers stop
+// fetching, finish the batch being processed, shut their record processor down with REQUESTED so that it can
+// checkpoint, and release their lease. Once all shard consumers exited, the released leases are signaled to the
+// peers, the worker state is persisted and the worker cleaned up. It returns once done, or with a ShutdownError
+// listing the shards still draining once ctx is done. These keep draining in the background and the worker is cleaned
+// up after them. Calling it again waits for the same shutdown.
+func (w *Worker) ShutdownWithContext(ctx context.Context) error {
 	log.Info("Worker shutdown is requested.")
 
-	if w.done {
-		return
+	if w.multiStream() {
+		return w.shutdownStreams(ctx)
+	}
+
+	w.shutdownOnce.Do(func() {
+		released := w.ownedShardIDs()
+		close(*w.stop)
+		go func() {
+			w.waitGroup.Wait()
+			w.cleanup(released)
+			close(w.done)
+		}()
+	})
+
+	select {
+	case <-w.done:
+		return nil
+	case <-ctx.Done():
+		draining := w.drainingShardIDs()
+		log.Errorf("Worker shutdown interrupted before shards %v drained: %v", draining, ctx.Err())
+		return util.ShutdownError.MakeErr().
+			WithDetail("shards not drained: %s", strings.Join(draining, ", ")).
+			WithCause(ctx.Err())
+	}
+}
+
+// cleanup signals the leases released by the shard consumers to the peers, persists the worker state and releases
+// the resources of the worker, once the shard consumers exited.
+func (w *Worker) cleanup(released []string) {
+	if w.releaseSignaler != nil {
+		if err := w.releaseSignaler.SignalLeasesReleased(w.workerID, released); err != nil {
+			log.Errorf("Failed to signal released leases to peers: %+v", err)
+		}
 	}
 
-	close(*w.stop)
-	w.done = true
-	w.waitGroup.Wait()
+	if w.kclConfig.WorkerStateStore != nil {
+		w.persistState(released)
+	}
 
+	w.deregisterStreamConsumer()
+	if w.auditing != nil {
+		w.auditing.Close()
+	}
 	w.mService.Shutdown()
 	log.Info("Worker loop is complete. Exiting from worker.")
 }
 
+// Quiesce stops the worker from acquiring leases and hands the leases it holds off over QuiesceWindowMillis, one
+// at a time at even intervals, e.g. at the start of a rolling deploy so that the old fleet sheds its load smoothly
+// to the new one. The record processors of the shards handed off are shut down with REQUESTED, so that they can
+// checkpoint. Resume reverts it.
+func (w *Worker) Quiesce() {
+	for _, sw := range w.streamWorkers {
+		sw.Quiesce()
+	}
+
+	w.quiesceMux.Lock()
+	defer w.quiesceMux.Unlock()
+	if w.quiesce != nil {
+		return
+	}
+
+	w.quiesce = make(chan struct{})
+	var held []*shard.Status
+	for _, sh := range w.listShards() {
+		if sh.GetLeaseOwner() == w.workerID {
+			held = append(held, sh)
+		}
+	}
+	sort.Slice(held, func(i, j int) bool { return held[i].ID < held[j].ID })
+	log.Infof("Worker quiesced, handing off %d leases", len(held))
+	go w.handOffLeases(held, w.quiesce)
+}
+
+// Resume lets a quiesced worker acquire leases again. The leases not handed off yet are kept.
+func (w *Worker) Resume() {
+	for _, sw := range w.streamWorkers {
+		sw.Resume()
+	}
+
+	w.quiesceMux.Lock()
+	defer w.quiesceMux.Unlock()
+	if w.quiesce == nil {
+		return
+	}
+
+	close(w.quiesce)
+	w.quiesce = nil
+	log.Info("Worker resumed.")
+}
+
+func (w *Worker) quiesced() bool {
+	w.quiesceMux.Lock()
+	defer w.quiesceMux.Unlock()
+	return w.quiesce != nil
+}
+
+// handOffLeases asks the consumers of the shards to release their lease, one at a time over the quiesce window,
+// until the worker is resumed or shut down.
+func (w *Worker) handOffLeases(held []*shard.Status, resumed chan struct{}) {
+	if len(held) == 0 {
+		return
+	}
+
+	interval := time.Duration(w.kclConfig.QuiesceWindowMillis) * time.Millisecond / time.Duration(len(held))
+	for _, sh := range held {
+		select {
+		case <-resumed:
+			return
+		case <-*w.stop:
+			return
+		case <-time.After(interval):
+		}
+
+		if sh.GetLeaseOwner() == w.workerID {
+			log.Infof("Handing off the lease of shard %s", sh.ID)
+			sh.RequestLeaseRelease()
+		}
+	}
+}
+
+// drainingShardIDs returns the shards whose consumer is still running, sorted.
+func (w *Worker) drainingShardIDs() []string {
+	var shardIDs []string
+	now := time.Now()
+	for _, sh := range w.listShards() {
+		if sh.GetConsumerUptime(now) > 0 {
+			shardIDs = append(shardIDs, sh.ID)
+		}
+	}
+	sort.Strings(shardIDs)
+	return shardIDs
+}
+
+// GetShardConsumerUptime returns how long the consumer of the shard has been running since its last (re)start and
+// how many times it has been restarted, to surface flapping shards.
+func (w *Worker) GetShardConsumerUptime(shardID string) (time.Duration, int) {
+	sh, ok := w.lookupShard(shardID)
+	if !ok {
+		return 0, 0
+	}
+	return sh.GetConsumerUptime(time.Now()), sh.GetConsumerRestarts()
+}
+
+// GetShardThroughput returns the moving average of the record processed per second by the consumer of the shard.
+func (w *Worker) GetShardThroughput(shardID string) float64 {
+	sh, ok := w.lookupShard(shardID)
+	if !ok {
+		return 0
+	}
+	return sh.GetThroughput()
+}
+
+// GetThroughput returns the moving average of the record processed per second by the worker, i.e. the sum of the
+// moving averages of the shards it owns.
+func (w *Worker) GetThroughput() float64 {
+	throughput := 0.0
+	for _, sw := range w.streamWorkers {
+		throughput += sw.GetThroughput()
+	}
+	for _, sh := range w.listShards() {
+		if sh.GetLeaseOwner() == w.workerID {
+			throughput += sh.GetThroughput()
+		}
+	}
+	return throughput
+}
+
+// GetLeaseOwnershipHistory returns the last owners of the lease of the shard as observed by the worker, oldest
+// first.
+func (w *Worker) GetLeaseOwnershipHistory(shardID string) []shard.LeaseOwnership {
+	sh, ok := w.lookupShard(shardID)
+	if !ok {
+		return nil
+	}
+	return sh.GetLeaseOwnershipHistory()
+}
+
+// GetShardFetchDiagnostics returns where the consumers of the shards owned by the worker are reading, to debug
+// stuck consumers.
+//
+// Unstable: it is meant for diagnostics only, see shard.FetchDiagnostics.
+func (w *Worker) GetShardFetchDiagnostics() []shard.FetchDiagnostics {
+	var diagnostics []shard.FetchDiagnostics
+	for _, sh := range w.listShards() {
+		if sh.GetLeaseOwner() == w.workerID {
+			diagnostics = append(diagnostics, sh.GetFetchDiagnostics())
+		}
+	}
+	return diagnostics
+}
+
+// GetShardStartingPositions returns where the consumers of the shards owned by the worker started reading, and why.
+//
+// Unstable: it is meant for diagnostics only, see shard.StartingPosition.
+func (w *Worker) GetShardStartingPositions() []shard.StartingPosition {
+	var positions []shard.StartingPosition
+	for _, sh := range w.listShards() {
+		if sh.GetLeaseOwner() == w.workerID {
+			positions = append(positions, sh.GetStartingPosition())
+		}
+	}
+	return positions
+}
+
+// lookupShard returns the status of the shard, if known. Unlike the shard map, it may be called from any goroutine.
+func (w *Worker) lookupShard(shardID string) (*shard.Status, bool) {
+	w.shardMux.RLock()
+	defer w.shardMux.RUnlock()
+	sh, ok := w.shardStatus[shardID]
+	return sh, ok
+}
+
+// listShards returns the statuses of the known shards. Unlike the shard map, it may be called from any goroutine.
+func (w *Worker) listShards() []*shard.Status {
+	w.shardMux.RLock()
+	defer w.shardMux.RUnlock()
+	shards := make([]*shard.Status, 0, len(w.shardStatus))
+	for _, sh := range w.shardStatus {
+		shards = append(shards, sh)
+	}
+	return shards
+}
+
+// GetRetentionPeriod returns the retention period of the stream. It is cached and refreshed periodically.
+func (w *Worker) GetRetentionPeriod() (time.Duration, error) {
+	w.retentionMux.Lock()
+	defer w.retentionMux.Unlock()
+
+	if !w.retentionFetchedAt.IsZero() && time.Since(w.retentionFetchedAt) < retentionRefreshInterval {
+		return w.retentionPeriod, nil
+	}
+
+	summary, err := w.kc.DescribeStreamSummary(&kinesis.DescribeStreamSummaryInput{
+		StreamName: aws.String(w.streamName),
+	})
+	if err != nil {
+		log.Errorf("Error in DescribeStreamSummary: %s Error: %+v", w.streamName, err)
+		return w.retentionPeriod, err
+	}
+
+	w.retentionPeriod = time.Duration(aws.Int64Value(summary.StreamDescriptionSummary.RetentionPeriodHours)) * time.Hour
+	w.retentionFetchedAt = time.Now()
+	return w.retentionPeriod, nil
+}
+
+// cachedRetentionPeriod returns the retention period of the stream, zero if it isn't known.
+func (w *Worker) cachedRetentionPeriod() time.Duration {
+	retention, err := w.GetRetentionPeriod()
+	if err != nil {
+		return 0
+	}
+	return retention
+}
+
+// validateInitialPosition rejects an unknown initial position, an AT_TIMESTAMP one without a timestamp, and one
+// beyond the retention period of the stream, since the record there have been trimmed already.
+func (w *Worker) validateInitialPosition() error {
+	switch w.kclConfig.InitialPositionInStream {
+	case LATEST, TRIM_HORIZON:
+		return nil
+	case AT_TIMESTAMP:
+	default:
+		return util.IllegalArgumentError.MakeErr().
+			WithDetail("unknown initial position %d", w.kclConfig.InitialPositionInStream)
+	}
+
+	timestamp := w.kclConfig.InitialPositionInStreamExtended.Timestamp
+	if timestamp == nil {
+		return util.IllegalArgumentError.MakeErr().WithDetail("initial position AT_TIMESTAMP without a timestamp")
+	}
+
+	retention, err := w.GetRetentionPeriod()
+	if err != nil {
+		// can't validate without the retention period, the timestamp is used as it is
+		return nil
+	}
+	if timestamp.Before(time.Now().Add(-retention)) {
+		return util.IllegalArgumentError.MakeErr().
+			WithDetail("initial position %v is beyond the retention period %v of stream %s", *timestamp, retention, w.streamName)
+	}
+	return nil
+}
+
 // Publish to write some data into stream. This function is mainly used for testing purpose.
 func (w *Worker) Publish(streamName, partitionKey string, data []byte) error {
 	_, err := w.kc.PutRecord(&kinesis.PutRecordInput{
@@ -129,22 +560,21 @@ func (w *Worker) initialize() error {
 
 	// Create default Kinesis session
 	if w.kc == nil {
-		// create session for Kinesis
-		log.Info("Creating Kinesis session")
+		w.createKinesisClients()
+	} else {
+		log.Info("Use custom Kinesis service.")
+	}
 
-		s, err := session.NewSession(&aws.Config{
-			Region:      aws.String(w.regionName),
-			Endpoint:    &w.kclConfig.KinesisEndpoint,
-			Credentials: w.kclConfig.KinesisCredentials,
-		})
+	if err := w.validateInitialPosition(); err != nil {
+		log.Errorf("Invalid initial position in stream: %+v", err)
+		return err
+	}
 
-		if err != nil {
-			// no need to move forward
-			log.Fatalf("Failed in getting Kinesis session for creating Worker: %+v", err)
+	if w.kclConfig.ConsumerName != "" {
+		if err := w.registerStreamConsumer(); err != nil {
+			log.Errorf("Failed to register consumer %s: %+v", w.kclConfig.ConsumerName, err)
+			return err
 		}
-		w.kc = kinesis.New(s)
-	} else {
-		log.Info("Use custom Kinesis service.")
 	}
 
 	// Create default dynamodb based checkpointer implementation
@@ -155,6 +585,62 @@ func (w *Worker) initialize() error {
 		log.Info("Use custom checkpointer implementation.")
 	}
 
+	w.retryBudget = util.NewRetryBudget(w.kclConfig.RetryBudgetSize, float64(w.kclConfig.RetryBudgetRefillPerSecond))
+	if retrying, ok := w.checkpointer.(shard.RetryingCheckpointer); ok {
+		retrying.SetRetryBudget(w.retryBudget)
+	}
+
+	if w.kclConfig.CooperativeShutdown {
+		if signaler, ok := w.checkpointer.(shard.LeaseReleaseSignaler); ok {
+			w.releaseSignaler = signaler
+		} else {
+			log.Warn("Checkpointer doesn't support cooperative shutdown, released leases are picked up on shard sync.")
+		}
+	}
+
+	if w.kclConfig.LagSnapshotIntervalMillis > 0 && !w.kclConfig.ReadOnlyFollower {
+		if recorder, ok := w.checkpointer.(shard.LagRecorder); ok {
+			w.lagRecorder = recorder
+		} else {
+			log.Warn("Checkpointer doesn't support lag snapshots, the lag won't be written to the lease table.")
+		}
+	}
+
+	if w.kclConfig.AvailabilityZone != "" && !w.kclConfig.ReadOnlyFollower {
+		if lister, ok := w.checkpointer.(shard.LeaseLister); ok {
+			w.leaseLister = lister
+		} else {
+			log.Warn("Checkpointer can't list the leases, they won't be spread across availability zones.")
+		}
+	}
+
+	if w.kclConfig.EnableLeaseStealing && !w.kclConfig.ReadOnlyFollower {
+		lister, listing := w.checkpointer.(shard.LeaseLister)
+		stealer, stealing := w.checkpointer.(shard.LeaseStealer)
+		if listing && stealing {
+			w.leaseLister = lister
+			w.leaseStealer = stealer
+		} else {
+			log.Warn("Checkpointer can't list or steal the leases, they won't be balanced across the workers.")
+		}
+	}
+
+	if w.kclConfig.AuditHook != nil {
+		log.Info("Auditing lease and checkpoint mutations.")
+		auditing := shard.NewAuditingCheckpointer(w.checkpointer, w.workerID, w.kclConfig.AuditHook,
+			w.kclConfig.AuditValuePolicy)
+		w.checkpointer = auditing
+		w.auditing = auditing
+		if w.leaseStealer != nil {
+			w.leaseStealer = auditing
+		}
+	}
+
+	if w.kclConfig.ReadOnlyFollower {
+		log.Info("Running as read-only follower, no leases or checkpoints will be written.")
+		w.checkpointer = shard.NewFollowerCheckpointer(w.checkpointer)
+	}
+
 	err := w.metricsConfig.Init(w.kclConfig.ApplicationName, w.streamName, w.workerID)
 	if err != nil {
 		log.Errorf("Failed to start monitoring service: %+v", err)
@@ -167,7 +653,11 @@ func (w *Worker) initialize() error {
 		return err
 	}
 
+	w.shardMux.Lock()
 	w.shardStatus = make(map[string]*shard.Status)
+	w.shardMux.Unlock()
+	w.leasesReleased = make(chan struct{}, 1)
+	w.following = make(map[string]bool)
 
 	stopChan := make(chan struct{})
 	w.stop = &stopChan
@@ -180,6 +670,43 @@ func (w *Worker) initialize() error {
 	return nil
 }
 
+// createKinesisClients creates the Kinesis client of the worker, and the one of the enhanced fan-out subscriptions
+// if a ConsumerName is configured.
+func (w *Worker) createKinesisClients() {
+	// create session for Kinesis
+	log.Info("Creating Kinesis session")
+
+	s, err := session.NewSession(&aws.Config{
+		Region:      aws.String(w.regionName),
+		Endpoint:    &w.kclConfig.KinesisEndpoint,
+		Credentials: w.kclConfig.KinesisCredentials,
+		HTTPClient: util.NewHTTPClient(time.Duration(w.kclConfig.KinesisRequestTimeoutMillis)*time.Millisecond,
+			w.kclConfig.KinesisMaxConnections),
+	})
+
+	if err != nil {
+		// no need to move forward
+		log.Fatalf("Failed in getting Kinesis session for creating Worker: %+v", err)
+	}
+	w.kc = kinesis.New(s)
+
+	// the subscriptions outlive any request timeout, their connections are health checked instead
+	if w.kclConfig.ConsumerName != "" {
+		fanOut, err := session.NewSession(&aws.Config{
+			Region:      aws.String(w.regionName),
+			Endpoint:    &w.kclConfig.KinesisEndpoint,
+			Credentials: w.kclConfig.KinesisCredentials,
+			HTTPClient: util.NewStreamingHTTPClient(w.kclConfig.KinesisMaxConnections,
+				time.Duration(w.kclConfig.FanOutKeepAliveMillis)*time.Millisecond,
+				time.Duration(w.kclConfig.FanOutPingTimeoutMillis)*time.Millisecond),
+		})
+		if err != nil {
+			log.Fatalf("Failed in getting Kinesis session for enhanced fan-out: %+v", err)
+		}
+		w.fanOutKc = kinesis.New(fanOut)
+	}
+}
+
 // newShardConsumer to create a shard consumer instance
 func (w *Worker) newShardConsumer(shard *shard.Status) *shard.Consumer {
 	s := &shard.Consumer{
@@ -187,28 +714,67 @@ func (w *Worker) newShardConsumer(shard *shard.Status) *shard.Consumer {
 		shard:           shard,
 		kc:              w.kc,
 		checkpointer:    w.checkpointer,
-		recordProcessor: w.processorFactory.CreateProcessor(),
+		recordProcessor: w.createProcessor(),
 		kclConfig:       w.kclConfig,
 		consumerID:      w.workerID,
 		stop:            w.stop,
 		waitGroup:       w.waitGroup,
 		mService:        w.mService,
+		retryBudget:     w.retryBudget,
 		state:           shard.WAITING_ON_PARENT_SHARDS,
+		follower:        w.kclConfig.ReadOnlyFollower,
+
+		startingSequenceNumber: w.takeStartingSequenceNumber(shard.ID),
+		retentionPeriod:        w.cachedRetentionPeriod(),
+		lagRecorder:            w.lagRecorder,
+		consumerARN:            w.consumerARN,
+		fanOutKc:               w.fanOutKc,
+		parentListed: func(shardID string) bool {
+			_, ok := w.lookupShard(shardID)
+			return ok
+		},
 	}
 	return s
 }
 
+// takeStartingSequenceNumber returns the configured starting sequence number of the shard, if any. It is only
+// returned once, so that it applies to the first consumer of the shard only.
+func (w *Worker) takeStartingSequenceNumber(shardID string) *StartingSequenceNumber {
+	w.startingMux.Lock()
+	defer w.startingMux.Unlock()
+
+	start, ok := w.startingSequenceNumbers[shardID]
+	if !ok {
+		return nil
+	}
+	delete(w.startingSequenceNumbers, shardID)
+	return &start
+}
+
 // eventLoop
 func (w *Worker) eventLoop() {
+	// the summaries are logged from the event loop, which owns the shard map
+	var summaries <-chan time.Time
+	if interval := w.kclConfig.SummaryLogIntervalMillis; interval > 0 {
+		ticker := time.NewTicker(time.Duration(interval) * time.Millisecond)
+		defer ticker.Stop()
+		summaries = ticker.C
+	}
+
 	for {
-		err := w.syncShard()
+		now := time.Now()
+		err := w.discoverShards(now)
 		if err != nil {
 			log.Errorf("Error getting Kinesis shards: %+v", err)
-			time.Sleep(time.Duration(w.kclConfig.ShardSyncIntervalMillis) * time.Millisecond)
-			continue
+			if w.shardMapStale(now) {
+				time.Sleep(w.shardSyncInterval())
+				continue
+			}
+			log.Warnf("Acquiring leases from the shards discovered %v ago", now.Sub(w.lastShardSync))
 		}
 
 		log.Infof("Found %d shards", len(w.shardStatus))
+		w.checkIdle()
 
 		// Count the number of leases hold by this worker excluding the processed sh
 		counter := 0
@@ -219,56 +785,462 @@ func (w *Worker) eventLoop() {
 		}
 
 		// max number of lease has not been reached yet
-		if counter < w.kclConfig.MaxLeasesForWorker {
-			for _, sh := range w.shardStatus {
-				// already owner of the sh
-				if sh.GetLeaseOwner() == w.workerID {
-					continue
-				}
-
-				err := w.checkpointer.FetchCheckpoint(sh)
-				if err != nil {
-					// checkpoint may not existed yet is not an error condition.
-					if err != shard.ErrSequenceIDNotFound {
-						log.Errorf(" Error: %+v", err)
-						// move on to next sh
-						continue
-					}
-				}
+		if !w.kclConfig.ReadOnlyFollower && !w.quiesced() && counter < w.kclConfig.MaxLeasesForWorker &&
+			w.reshardSettled(time.Now()) && w.leaseAcquisitionAllowed(time.Now()) {
+			w.acquireLeases(w.kclConfig.MaxLeasesForWorker - counter)
+			if w.leaseStealer != nil {
+				w.stealLeases()
+			}
+		}
 
-				// The sh is closed and we have processed all record
-				if sh.Checkpoint == shard.SHARD_END {
-					continue
-				}
+		if w.kclConfig.ReadOnlyFollower {
+			w.followShards()
+		}
 
-				err = w.checkpointer.GetLease(sh, w.workerID)
-				if err != nil {
-					// cannot get lease on the sh
-					if err.Error() != shard.ErrLeaseNotAquired {
-						log.Error(err)
-					}
-					continue
-				}
+		if w.kclConfig.WorkerStateStore != nil {
+			w.persistState(w.ownedShardIDs())
+		}
 
-				// log metrics on got lease
-				w.mService.LeaseGained(sh.ID)
+		// the worker is ready once it went through a first lease acquisition cycle
+		w.markReady()
 
-				log.Infof("Start Shard Consumer for sh: %v", sh.ID)
-				sc := w.newShardConsumer(sh)
-				go sc.GetRecords(sh) // Need to handle the error using a channel or rework the goroutine
-				w.waitGroup.Add(1)
-				// exit from for loop and do not get any more shards for now.
-				break
+		nextSync := time.After(w.shardSyncInterval())
+	wait:
+		for {
+			select {
+			case <-*w.stop:
+				log.Info("Shutting down...")
+				return
+			case <-nextSync:
+				break wait
+			case <-w.leasesReleased:
+				log.Info("Leases released by a departing worker, acquiring them.")
+				w.lastLeaseAcquisition = time.Time{}
+				break wait
+			case <-summaries:
+				w.logSummary()
 			}
 		}
+	}
+}
+
+// logSummary logs the shards owned by the worker, the records processed per second, the maximal lag of its shards,
+// and the checkpoints written and the throttled calls since the last summary.
+func (w *Worker) logSummary() {
+	owned := 0
+	var maxLag int64
+	checkpointWrites, throttles := 0, w.listingThrottles
+	w.listingThrottles = 0
+	for _, sh := range w.shardStatus {
+		// the activity of the shards lost since the last summary is counted too
+		writes, throttled := sh.TakeActivity()
+		checkpointWrites += writes
+		throttles += throttled
+		if sh.GetLeaseOwner() != w.workerID {
+			continue
+		}
+		owned++
+		if lag := sh.GetMillisBehindLatest(); lag > maxLag {
+			maxLag = lag
+		}
+	}
+
+	throughput := w.GetThroughput()
+	log.WithFields(log.Fields{
+		"stream":                w.streamName,
+		"worker":                w.workerID,
+		"shards":                owned,
+		"recordsPerSecond":      throughput,
+		"maxMillisBehindLatest": maxLag,
+		"checkpointWrites":      checkpointWrites,
+		"throttles":             throttles,
+	}).Infof("Worker %s owns %d shards of stream %s, %.1f records/s, max lag %d ms, %d checkpoints written, "+
+		"%d throttles", w.workerID, owned, w.streamName, throughput, maxLag, checkpointWrites, throttles)
+}
+
+// checkIdle tracks whether the stream has any open shard left. A stream without open shards, e.g. whose shards
+// are all closed awaiting new ones, isn't an error: the worker idles and keeps re-discovering the stream.
+func (w *Worker) checkIdle() {
+	open := 0
+	for _, sh := range w.shardStatus {
+		if sh.EndingSequenceNumber == "" {
+			open++
+		}
+	}
+
+	if open == 0 && !w.idle {
+		w.idle = true
+		util.EmitEvent(w.kclConfig.EventListener, util.INFO, util.EVENT_STREAM_IDLE, "",
+			fmt.Sprintf("stream %s has no open shard, idling", w.streamName))
+	} else if open > 0 && w.idle {
+		w.idle = false
+		util.EmitEvent(w.kclConfig.EventListener, util.INFO, util.EVENT_STREAM_RESUMED, "",
+			fmt.Sprintf("stream %s has %d open shards, resuming", w.streamName, open))
+	}
+}
+
+// shardSyncInterval returns the time until the next shard sync.
+func (w *Worker) shardSyncInterval() time.Duration {
+	if w.idle {
+		return time.Duration(w.kclConfig.IdleShardSyncIntervalMillis) * time.Millisecond
+	}
+	return time.Duration(w.kclConfig.ShardSyncIntervalMillis) * time.Millisecond
+}
+
+// watchLeaseReleases polls for lease release signals of departing peers and wakes up the event loop on every
+// new one, so that the released leases are picked up right away.
+func (w *Worker) watchLeaseReleases() {
+	var lastSeen time.Time
+	if signal, err := w.releaseSignaler.FetchLeaseReleaseSignal(); err == nil && signal != nil {
+		lastSeen = signal.ReleasedAt
+	}
 
+	ticker := time.NewTicker(time.Duration(w.kclConfig.LeaseReleasePollIntervalMillis) * time.Millisecond)
+	defer ticker.Stop()
+	for {
 		select {
 		case <-*w.stop:
-			log.Info("Shutting down...")
 			return
-		case <-time.After(time.Duration(w.kclConfig.ShardSyncIntervalMillis) * time.Millisecond):
+		case <-ticker.C:
+		}
+
+		signal, err := w.releaseSignaler.FetchLeaseReleaseSignal()
+		if err != nil {
+			log.Errorf("Failed to fetch lease release signal: %+v", err)
+			continue
+		}
+		if signal == nil || !signal.ReleasedAt.After(lastSeen) {
+			continue
+		}
+		lastSeen = signal.ReleasedAt
+		if signal.WorkerID == w.workerID {
+			continue
+		}
+
+		log.Infof("Worker %s released the leases of %v", signal.WorkerID, signal.ShardIDs)
+		select {
+		case w.leasesReleased <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// ownedShardIDs returns the shards whose lease is held by the worker. It is called on shutdown too, while the event
+// loop may still be running.
+func (w *Worker) ownedShardIDs() []string {
+	var shardIDs []string
+	for _, sh := range w.listShards() {
+		if sh.GetLeaseOwner() == w.workerID {
+			shardIDs = append(shardIDs, sh.ID)
+		}
+	}
+	return shardIDs
+}
+
+// followShards starts a follower consumer for every shard which isn't followed yet. Shards the owners are done with
+// are skipped. A follower consumer failing is restarted on the next shard sync.
+func (w *Worker) followShards() {
+	for _, sh := range w.shardStatus {
+		w.followMux.Lock()
+		following := w.following[sh.ID]
+		w.followMux.Unlock()
+		if following {
+			continue
+		}
+
+		err := w.checkpointer.FetchCheckpoint(sh)
+		if err != nil && err != shard.ErrSequenceIDNotFound {
+			log.Errorf("Failed to fetch checkpoint of shard %s: %+v", sh.ID, err)
+			continue
+		}
+		if sh.Checkpoint == shard.SHARD_END {
+			continue
+		}
+
+		log.Infof("Start follower Shard Consumer for sh: %v", sh.ID)
+		w.followMux.Lock()
+		w.following[sh.ID] = true
+		w.followMux.Unlock()
+
+		sc := w.newShardConsumer(sh)
+		w.waitGroup.Add(1)
+		go func(sh *shard.Status) {
+			if err := sc.GetRecords(sh); err != nil {
+				log.Errorf("Follower of shard %s failed: %+v", sh.ID, err)
+				w.followMux.Lock()
+				delete(w.following, sh.ID)
+				w.followMux.Unlock()
+			}
+		}(sh)
+	}
+}
+
+// leaseAcquisitionAllowed rate limits the lease acquisition cycles of the worker, so that it doesn't hammer the
+// lease table when many leases are available. It returns true, and starts a new cycle, if at least
+// MinLeaseAcquisitionIntervalMillis have passed since the previous cycle.
+func (w *Worker) leaseAcquisitionAllowed(now time.Time) bool {
+	minInterval := time.Duration(w.kclConfig.MinLeaseAcquisitionIntervalMillis) * time.Millisecond
+	if now.Sub(w.lastLeaseAcquisition) < minInterval {
+		return false
+	}
+	w.lastLeaseAcquisition = now
+	return true
+}
+
+// discoverShards syncs the shards if the discovery is due. With adaptive discovery, the interval doubles after every
+// discovery finding the same shards, up to ShardDiscoveryMaxIntervalMillis, and drops back to
+// ShardDiscoveryMinIntervalMillis once the shards changed, i.e. after a reshard.
+func (w *Worker) discoverShards(now time.Time) error {
+	if w.kclConfig.ShardDiscoveryMaxIntervalMillis <= 0 {
+		return w.syncShard()
+	}
+	if now.Before(w.nextDiscovery) {
+		return nil
+	}
+
+	known := make(map[string]bool, len(w.shardStatus))
+	for shardID := range w.shardStatus {
+		known[shardID] = true
+	}
+	if err := w.syncShard(); err != nil {
+		return err
+	}
+
+	changed := len(known) != len(w.shardStatus)
+	for shardID := range w.shardStatus {
+		if !known[shardID] {
+			changed = true
+		}
+	}
+
+	minInterval := time.Duration(w.kclConfig.ShardDiscoveryMinIntervalMillis) * time.Millisecond
+	maxInterval := time.Duration(w.kclConfig.ShardDiscoveryMaxIntervalMillis) * time.Millisecond
+	switch {
+	case changed || w.discoveryInterval < minInterval:
+		w.discoveryInterval = minInterval
+	case w.discoveryInterval*2 > maxInterval:
+		w.discoveryInterval = maxInterval
+	default:
+		w.discoveryInterval *= 2
+	}
+	w.nextDiscovery = now.Add(w.discoveryInterval)
+	return nil
+}
+
+// shardMapStale returns true once the shards haven't been discovered for longer than ShardMapMaxAgeMillis. No lease
+// is acquired from a stale shard map, since the stream may have been resharded meanwhile.
+func (w *Worker) shardMapStale(now time.Time) bool {
+	maxAge := time.Duration(w.kclConfig.ShardMapMaxAgeMillis) * time.Millisecond
+	if !w.lastShardSync.IsZero() && now.Sub(w.lastShardSync) <= maxAge {
+		return false
+	}
+
+	if !w.staleShardMap && !w.lastShardSync.IsZero() {
+		w.staleShardMap = true
+		util.EmitEvent(w.kclConfig.EventListener, util.WARNING, util.EVENT_SHARD_MAP_STALE, "",
+			fmt.Sprintf("shards of stream %s not discovered for %v, not acquiring leases",
+				w.streamName, now.Sub(w.lastShardSync)))
+	}
+	return true
+}
+
+// reshardSettled returns false while the children of a reshard are still being registered, i.e. until
+// ReshardCoalesceWindowMillis have passed since the last new child shard was found. The rebalance is then done
+// once for all the children instead of once per shard sync.
+func (w *Worker) reshardSettled(now time.Time) bool {
+	window := time.Duration(w.kclConfig.ReshardCoalesceWindowMillis) * time.Millisecond
+	if now.Sub(w.lastReshardChild) >= window {
+		return true
+	}
+	log.Debugf("Waiting for reshard to settle before rebalancing leases")
+	return false
+}
+
+// acquireLeases tries to take the lease of up to n available shards, and at most MaxLeasesPerAcquisition of them,
+// and starts a shard consumer for every lease gained. Only the leases actually taken count toward the limit. The
+// conditional lease writes are issued concurrently, with at most MaxLeaseAcquisitionConcurrency of them in flight to
+// avoid a write spike on the lease table. At most MaxChildLeasesPerAcquisition leases of child shards without lease
+// yet are created.
+func (w *Worker) acquireLeases(n int) {
+	n = w.availabilityZoneQuota(n)
+	if n > w.kclConfig.MaxLeasesPerAcquisition {
+		n = w.kclConfig.MaxLeasesPerAcquisition
+	}
+	childLeases := 0
+	wg := sync.WaitGroup{}
+
+	// the writes in flight may all fail, so no more are issued than the leases still to take
+	mux := sync.Mutex{}
+	written := sync.NewCond(&mux)
+	taken, inFlight := 0, 0
+	reserve := func() bool {
+		mux.Lock()
+		defer mux.Unlock()
+		for inFlight > 0 && (taken+inFlight >= n || inFlight >= w.kclConfig.MaxLeaseAcquisitionConcurrency) {
+			written.Wait()
+		}
+		if taken >= n {
+			return false
+		}
+		inFlight++
+		return true
+	}
+
+	for _, sh := range w.leaseCandidates() {
+		mux.Lock()
+		done := taken >= n
+		mux.Unlock()
+		if done {
+			break
+		}
+
+		// already owner of the sh
+		if sh.GetLeaseOwner() == w.workerID {
+			continue
+		}
+
+		err := w.checkpointer.FetchCheckpoint(sh)
+		newLease := false
+		if err != nil {
+			// checkpoint may not existed yet is not an error condition.
+			if err != shard.ErrSequenceIDNotFound {
+				log.Errorf(" Error: %+v", err)
+				// move on to next sh
+				continue
+			}
+			newLease = true
+		}
+
+		// The sh is closed and we have processed all record
+		if sh.Checkpoint == shard.SHARD_END {
+			continue
 		}
+
+		// the children of a split or a merge are left unleased until their parents are processed to their end
+		if err := w.checkParentShards(sh); err != nil {
+			if errors.Is(err, util.BlockedOnParentShardError.MakeErr()) {
+				log.Debugf("Deferring the lease of shard %s: %v", sh.ID, err)
+			} else {
+				log.Errorf("Error in checking the parents of shard %s: %+v", sh.ID, err)
+			}
+			continue
+		}
+
+		// the lease of a new child shard is created, the other children are left to the next cycles
+		if newLease && sh.ParentShardId != "" && w.kclConfig.MaxChildLeasesPerAcquisition > 0 {
+			if childLeases >= w.kclConfig.MaxChildLeasesPerAcquisition {
+				log.Debugf("Deferring the lease of child shard %s, %d created already", sh.ID, childLeases)
+				continue
+			}
+			childLeases++
+		}
+
+		if !reserve() {
+			break
+		}
+		wg.Add(1)
+		go func(sh *shard.Status) {
+			defer wg.Done()
+
+			err := w.checkpointer.GetLease(sh, w.workerID)
+			mux.Lock()
+			inFlight--
+			if err == nil {
+				taken++
+			}
+			written.Broadcast()
+			mux.Unlock()
+
+			if err != nil {
+				// cannot get lease on the sh
+				if err.Error() != shard.ErrLeaseNotAquired {
+					log.Error(err)
+				}
+				return
+			}
+
+			w.startShardConsumer(sh)
+		}(sh)
 	}
+
+	wg.Wait()
+
+	// the leases held before a restart are only preferred on the first attempt
+	w.preferredLeases = nil
+}
+
+// startShardConsumer starts the shard consumer of a lease gained by the worker.
+func (w *Worker) startShardConsumer(sh *shard.Status) {
+	// log metrics on got lease
+	w.mService.LeaseGained(sh.ID)
+
+	log.Infof("Start Shard Consumer for sh: %v", sh.ID)
+	sc := w.newShardConsumer(sh)
+	w.waitGroup.Add(1)
+	go sc.GetRecords(sh) // Need to handle the error using a channel or rework the goroutine
+}
+
+// availabilityZoneQuota caps the number n of leases to acquire so that the availability zone of the worker doesn't
+// hold more than its share of the shards, the shards being shared by the zones of the live leases. The leases of a
+// zone going down expire, and the remaining zones then take its shards over.
+func (w *Worker) availabilityZoneQuota(n int) int {
+	if w.leaseLister == nil || w.kclConfig.AvailabilityZone == "" {
+		return n
+	}
+
+	leases, err := w.leaseLister.GetLeases()
+	if err != nil {
+		log.Warnf("Failed to list the leases, acquiring them regardless of availability zones: %+v", err)
+		return n
+	}
+
+	// leases within the takeover grace period are still held
+	now := time.Now().Add(-time.Duration(w.kclConfig.LeaseTakeoverGraceMillis) * time.Millisecond)
+	zones := map[string]bool{w.kclConfig.AvailabilityZone: true}
+	held := 0
+	shards := len(w.shardStatus)
+	for _, lease := range leases {
+		if lease.Checkpoint == shard.SHARD_END {
+			if _, ok := w.shardStatus[lease.ShardID]; ok {
+				shards--
+			}
+			continue
+		}
+		if lease.Owner == "" || lease.OwnerAvailabilityZone == "" || !lease.LeaseTimeout.After(now) {
+			continue
+		}
+		zones[lease.OwnerAvailabilityZone] = true
+		if lease.OwnerAvailabilityZone == w.kclConfig.AvailabilityZone {
+			held++
+		}
+	}
+
+	share := (shards + len(zones) - 1) / len(zones)
+	if quota := share - held; quota < n {
+		log.Debugf("Availability zone %s holds %d of %d shards across %d zones, acquiring up to %d leases",
+			w.kclConfig.AvailabilityZone, held, shards, len(zones), quota)
+		if quota < 0 {
+			return 0
+		}
+		return quota
+	}
+	return n
+}
+
+// leaseCandidates returns the known shards, the ones whose lease was held before a restart first.
+func (w *Worker) leaseCandidates() []*shard.Status {
+	candidates := make([]*shard.Status, 0, len(w.shardStatus))
+	for _, sh := range w.shardStatus {
+		if w.preferredLeases[sh.ID] {
+			candidates = append(candidates, sh)
+		}
+	}
+	for _, sh := range w.shardStatus {
+		if !w.preferredLeases[sh.ID] {
+			candidates = append(candidates, sh)
+		}
+	}
+	return candidates
 }
 
 // List all ACTIVE shard and store them into shardStatus table
@@ -283,7 +1255,7 @@ func (w *Worker) getShardIDs(startShardID string, shardInfo map[string]bool) err
 		args.ExclusiveStartShardId = aws.String(startShardID)
 	}
 
-	streamDesc, err := w.kc.DescribeStream(args)
+	streamDesc, err := w.describeStream(args)
 	if err != nil {
 		log.Errorf("Error in DescribeStream: %s Error: %+v Request: %s", w.streamName, err, args)
 		return err
@@ -302,13 +1274,17 @@ func (w *Worker) getShardIDs(startShardID string, shardInfo map[string]bool) err
 		// found new shard
 		if _, ok := w.shardStatus[*s.ShardId]; !ok {
 			log.Infof("Found new shard with id %s", *s.ShardId)
+			w.shardMux.Lock()
 			w.shardStatus[*s.ShardId] = &shard.Status{
 				ID:                     *s.ShardId,
 				ParentShardId:          aws.StringValue(s.ParentShardId),
+				AdjacentParentShardId:  aws.StringValue(s.AdjacentParentShardId),
 				Mux:                    &sync.Mutex{},
 				StartingSequenceNumber: aws.StringValue(s.SequenceNumberRange.StartingSequenceNumber),
 				EndingSequenceNumber:   aws.StringValue(s.SequenceNumberRange.EndingSequenceNumber),
+				HashKeyRange:           s.HashKeyRange,
 			}
+			w.shardMux.Unlock()
 		}
 		lastShardID = *s.ShardId
 	}
@@ -324,8 +1300,40 @@ func (w *Worker) getShardIDs(startShardID string, shardInfo map[string]bool) err
 	return nil
 }
 
+// describeStream lists a page of shards. The listing is rate limited for the whole stream: while it is throttled,
+// it is retried up to ShardListingRetries times with exponential backoff, as long as the retry budget allows. Every
+// backoff is randomized, so that the workers of the application, which all list the shards at the same interval,
+// spread their retries instead of being throttled together again.
+func (w *Worker) describeStream(args *kinesis.DescribeStreamInput) (*kinesis.DescribeStreamOutput, error) {
+	backoff := time.Duration(w.kclConfig.ShardListingBackoffMillis) * time.Millisecond
+	for attempt := 0; ; attempt++ {
+		streamDesc, err := w.kc.DescribeStream(args)
+		awsErr, ok := err.(awserr.Error)
+		if !ok || awsErr.Code() != kinesis.ErrCodeLimitExceededException || attempt >= w.kclConfig.ShardListingRetries {
+			return streamDesc, err
+		}
+		if w.retryBudget != nil && !w.retryBudget.Acquire() {
+			log.Errorf("Retry budget exhausted, giving up listing the shards of stream %s", w.streamName)
+			return streamDesc, err
+		}
+
+		w.mService.IncrShardListingThrottles(w.streamName)
+		w.listingThrottles++
+		// wait between half and the whole backoff
+		wait := backoff/2 + time.Duration(rand.Int63n(int64(backoff/2)+1))
+		log.Warnf("Listing the shards of stream %s is throttled, retrying in %v", w.streamName, wait)
+		time.Sleep(wait)
+		backoff *= 2
+	}
+}
+
 // syncShard to sync the cached shard info with actual shard info from Kinesis
 func (w *Worker) syncShard() error {
+	known := make(map[string]bool, len(w.shardStatus))
+	for shardID := range w.shardStatus {
+		known[shardID] = true
+	}
+
 	shardInfo := make(map[string]bool)
 	err := w.getShardIDs("", shardInfo)
 
@@ -333,18 +1341,163 @@ func (w *Worker) syncShard() error {
 		return err
 	}
 
+	w.lastShardSync = time.Now()
+	if w.staleShardMap {
+		w.staleShardMap = false
+		log.Infof("Shards of stream %s discovered again, acquiring leases", w.streamName)
+	}
+
+	// The shards found on the first sync are not the result of a reshard.
+	if len(known) > 0 {
+		for _, sh := range w.shardStatus {
+			if !known[sh.ID] && sh.ParentShardId != "" {
+				w.lastReshardChild = time.Now()
+			}
+		}
+	}
+
+	w.staleMux.Lock()
+	if w.staleLeases == nil {
+		w.staleLeases = make(map[string]bool)
+	}
 	for _, sh := range w.shardStatus {
 		// The cached shard no longer existed, remove it.
 		if _, ok := shardInfo[sh.ID]; !ok {
 			// remove the shard from local status cache
+			w.shardMux.Lock()
 			delete(w.shardStatus, sh.ID)
-			// remove the shard entry in dynamoDB as well
-			// Note: syncShard runs periodically. we don't need to do anything in case of error here.
-			if err := w.checkpointer.RemoveLeaseInfo(sh.ID); err != nil {
-				log.Errorf("Failed to remove shard lease info: %s Error: %+v", sh.ID, err)
+			w.shardMux.Unlock()
+			// and its lease too
+			w.staleLeases[sh.ID] = true
+		}
+	}
+	w.staleMux.Unlock()
+	w.collectStaleLeases()
+
+	return nil
+}
+
+// collectStaleLeases deletes the leases of the shards which no longer exist in the stream in the background, so that
+// a long garbage collection doesn't hold the shard syncs and the lease acquisitions up. The lease of a parent shard is
+// kept as long as one of its children isn't finished, since the consumers of the child check it before starting. A
+// single garbage collection runs at a time, the leases which are kept, or stale since it started, are collected at
+// the next shard sync after it.
+func (w *Worker) collectStaleLeases() {
+	w.staleMux.Lock()
+	defer w.staleMux.Unlock()
+	if w.collecting {
+		return
+	}
+
+	var collectable []string
+	for shardID := range w.staleLeases {
+		if w.hasUnfinishedChild(shardID) {
+			log.Debugf("Keeping lease of shard %s until its children are finished", shardID)
+			continue
+		}
+		collectable = append(collectable, shardID)
+	}
+	if len(collectable) == 0 {
+		return
+	}
+
+	w.collecting = true
+	w.waitGroup.Add(1)
+	go w.deleteStaleLeases(collectable)
+}
+
+// deleteStaleLeases deletes the given leases, with at most LeaseGCConcurrency deletions in flight and
+// LeaseGCRatePerSecond deletions per second. The lease items kept by the record processors of the shard, see
+// record.ILeaseItemsRecordProcessorFactory, are deleted first. It stops at shutdown, the leases which aren't deleted
+// are collected again at the next shard sync.
+func (w *Worker) deleteStaleLeases(shardIDs []string) {
+	defer w.waitGroup.Done()
+	defer func() {
+		w.staleMux.Lock()
+		w.collecting = false
+		w.staleMux.Unlock()
+	}()
+
+	var rate <-chan time.Time
+	if w.kclConfig.LeaseGCRatePerSecond > 0 {
+		ticker := time.NewTicker(time.Second / time.Duration(w.kclConfig.LeaseGCRatePerSecond))
+		defer ticker.Stop()
+		rate = ticker.C
+	}
+
+	sem := make(chan struct{}, w.kclConfig.LeaseGCConcurrency)
+	wg := sync.WaitGroup{}
+collect:
+	for _, shardID := range shardIDs {
+		if rate != nil {
+			select {
+			case <-rate:
+			case <-*w.stop:
+				break collect
 			}
 		}
+		select {
+		case sem <- struct{}{}:
+		case <-*w.stop:
+			break collect
+		}
+		wg.Add(1)
+		go func(shardID string) {
+			defer wg.Done()
+			defer func() { <-sem }()
+
+			// Note: syncShard runs periodically, the deletion is retried at the next sync in case of error.
+			if factory, ok := w.processorFactory.(record.ILeaseItemsRecordProcessorFactory); ok {
+				if err := factory.RemoveLeaseItems(shardID); err != nil {
+					log.Errorf("Failed to remove lease items of record processors of shard: %s Error: %+v", shardID, err)
+					return
+				}
+			}
+			if err := w.checkpointer.RemoveLeaseInfo(shardID); err != nil {
+				log.Errorf("Failed to remove shard lease info: %s Error: %+v", shardID, err)
+				return
+			}
+			w.staleMux.Lock()
+			delete(w.staleLeases, shardID)
+			w.staleMux.Unlock()
+		}(shardID)
 	}
+	wg.Wait()
+}
 
+// checkParentShards returns a BlockedOnParentShardError unless all the parents of the shard are checkpointed at
+// SHARD_END. A parent without a lease is only waited for while it is still listed in the stream: its lease was cleaned
+// up or it expired out of the retention period otherwise.
+func (w *Worker) checkParentShards(sh *shard.Status) error {
+	for _, parentID := range sh.ParentShardIDs() {
+		parent := &shard.Status{
+			ID:  parentID,
+			Mux: &sync.Mutex{},
+		}
+		err := w.checkpointer.FetchCheckpoint(parent)
+		if err == shard.ErrSequenceIDNotFound {
+			if _, ok := w.shardStatus[parentID]; !ok {
+				continue
+			}
+		} else if err != nil {
+			return err
+		}
+
+		if parent.Checkpoint != shard.SHARD_END {
+			return util.BlockedOnParentShardError.MakeErr().
+				WithDetail("parent shard %s of shard %s is not processed to its end", parentID, sh.ID)
+		}
+	}
 	return nil
 }
+
+// hasUnfinishedChild returns true if a known shard has the given parent and hasn't been processed to its end.
+func (w *Worker) hasUnfinishedChild(parentShardID string) bool {
+	for _, sh := range w.shardStatus {
+		isChild := sh.ParentShardId == parentShardID || sh.AdjacentParentShardId == parentShardID
+		if isChild && sh.Checkpoint != shard.SHARD_END {
+			return true
+		}
+	}
+	return false
+}
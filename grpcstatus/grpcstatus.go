@@ -0,0 +1,86 @@
+// Package grpcstatus maps the errors of the library to and from gRPC status, e.g. for a gRPC service fronting a
+// worker. It is a package of its own so that only its users depend on gRPC.
+package grpcstatus
+
+import (
+	"net/http"
+
+	"github.com/golang/protobuf/ptypes/wrappers"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/guygma/goKCL/util"
+)
+
+// Code returns the gRPC code of the error, derived from its retryability and its HTTP status.
+func Code(e *util.ClientLibraryError) codes.Code {
+	switch {
+	case e.Status == http.StatusTooManyRequests:
+		return codes.ResourceExhausted
+	case e.Retryable:
+		return codes.Unavailable
+	case e.Status == http.StatusBadRequest:
+		return codes.InvalidArgument
+	case e.Status == http.StatusNotImplemented:
+		return codes.Unimplemented
+	default:
+		return codes.FailedPrecondition
+	}
+}
+
+// ToStatus exports the error to a gRPC status, e.g. to return it from a gRPC service fronting a worker.
+// The message of the status is the message of the error, the ErrorCode and the Detail of the error are carried
+// in the details of the status so that FromStatus can restore it.
+func ToStatus(e *util.ClientLibraryError) *status.Status {
+	st := status.New(Code(e), e.Error())
+	withDetails, err := st.WithDetails(
+		&wrappers.Int32Value{Value: int32(e.ErrorCode)},
+		&errdetails.DebugInfo{Detail: e.Detail},
+	)
+	if err != nil {
+		// the details can't be marshaled, the code and the message are still meaningful
+		return st
+	}
+	return withDetails
+}
+
+// FromStatus restores the error exported by ToStatus. A status not carrying an ErrorCode, e.g. one made by another
+// service, is mapped to the closest error of its code with the message of the status as Detail.
+func FromStatus(st *status.Status) *util.ClientLibraryError {
+	var code *wrappers.Int32Value
+	var debug *errdetails.DebugInfo
+	for _, d := range st.Details() {
+		switch v := d.(type) {
+		case *wrappers.Int32Value:
+			code = v
+		case *errdetails.DebugInfo:
+			debug = v
+		}
+	}
+
+	if code != nil {
+		if util.ErrorCode(code.Value).IsDefined() {
+			e := util.ErrorCode(code.Value).MakeErr()
+			if debug != nil {
+				e.Detail = debug.Detail
+			}
+			return e
+		}
+	}
+
+	var e *util.ClientLibraryError
+	switch st.Code() {
+	case codes.ResourceExhausted:
+		e = util.ThrottlingError.MakeErr()
+	case codes.Unavailable:
+		e = util.KinesisClientLibRetryableError.MakeErr()
+	case codes.InvalidArgument:
+		e = util.IllegalArgumentError.MakeErr()
+	case codes.Unimplemented:
+		e = util.KinesisClientLibNotImplemented.MakeErr()
+	default:
+		e = util.KinesisClientLibNonRetryableException.MakeErr()
+	}
+	return e.WithDetail("%s", st.Message())
+}
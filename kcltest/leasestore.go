@@ -0,0 +1,158 @@
+// Package kcltest provides in-memory fakes of the lease store and of a Kinesis stream, to drive a Worker and its
+// record processors end-to-end in unit tests without AWS:
+//
+//	stream := kcltest.NewStream("orders", 2)
+//	store := kcltest.NewLeaseStore()
+//	worker := goKCL.NewWorker(factory, kclConfig, nil).WithKinesis(stream).WithLeaseStore(store)
+//
+// Both are safe for concurrent use, and can be shared by several workers of the same application.
+package kcltest
+
+import (
+	"errors"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/guygma/goKCL/shard"
+)
+
+// checkpointPollInterval is how often AssertCheckpoint checks the checkpoint of the shard.
+const checkpointPollInterval = 10 * time.Millisecond
+
+// LeaseStore is an in-memory shard.LeaseStore.
+type LeaseStore struct {
+	mux    sync.Mutex
+	leases map[string]*shard.Lease
+}
+
+// NewLeaseStore returns an empty in-memory lease store.
+func NewLeaseStore() *LeaseStore {
+	return &LeaseStore{leases: make(map[string]*shard.Lease)}
+}
+
+// Init does nothing, the store is ready once created.
+func (s *LeaseStore) Init() error {
+	return nil
+}
+
+// GetLease retrieves the lease of the shard, nil if the shard has none
+func (s *LeaseStore) GetLease(shardID string) (*shard.Lease, error) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	lease, ok := s.leases[shardID]
+	if !ok {
+		return nil, nil
+	}
+	copied := *lease
+	return &copied, nil
+}
+
+// CreateLease creates the lease of a shard, it fails with ErrLeaseNotAquired if the shard already has one
+func (s *LeaseStore) CreateLease(lease *shard.Lease) error {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	if _, ok := s.leases[lease.ShardID]; ok {
+		return errors.New(shard.ErrLeaseNotAquired)
+	}
+	copied := *lease
+	s.leases[lease.ShardID] = &copied
+	return nil
+}
+
+// RenewLease extends the lease until the lease timeout, it fails with ErrLeaseNotAquired if the lease isn't held by
+// its owner anymore
+func (s *LeaseStore) RenewLease(lease *shard.Lease, leaseTimeout time.Time) error {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	current, ok := s.leases[lease.ShardID]
+	if !ok || current.Owner != lease.Owner {
+		return errors.New(shard.ErrLeaseNotAquired)
+	}
+	current.LeaseTimeout = leaseTimeout
+	return nil
+}
+
+// TakeLease assigns the lease to the new owner until the lease timeout, an empty owner releasing it. It fails with
+// ErrLeaseNotAquired if the owner or the lease timeout of the lease changed since it was retrieved.
+func (s *LeaseStore) TakeLease(lease *shard.Lease, newOwner string, leaseTimeout time.Time) error {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	current, ok := s.leases[lease.ShardID]
+	if !ok || current.Owner != lease.Owner || !current.LeaseTimeout.Equal(lease.LeaseTimeout) {
+		return errors.New(shard.ErrLeaseNotAquired)
+	}
+	current.Owner = newOwner
+	current.LeaseTimeout = leaseTimeout
+	return nil
+}
+
+// UpdateCheckpoint writes the checkpoint of the lease, along with its owner and lease timeout
+func (s *LeaseStore) UpdateCheckpoint(lease *shard.Lease) error {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	copied := *lease
+	s.leases[lease.ShardID] = &copied
+	return nil
+}
+
+// ListLeases retrieves all the leases, sorted by shard ID
+func (s *LeaseStore) ListLeases() ([]*shard.Lease, error) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	leases := make([]*shard.Lease, 0, len(s.leases))
+	for _, lease := range s.leases {
+		copied := *lease
+		leases = append(leases, &copied)
+	}
+	sort.Slice(leases, func(i, j int) bool { return leases[i].ShardID < leases[j].ShardID })
+	return leases, nil
+}
+
+// DeleteLease deletes the lease of the shard
+func (s *LeaseStore) DeleteLease(shardID string) error {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	delete(s.leases, shardID)
+	return nil
+}
+
+// Checkpoint returns the checkpoint of the shard, empty if it has none.
+func (s *LeaseStore) Checkpoint(shardID string) string {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	if lease, ok := s.leases[shardID]; ok {
+		return lease.Checkpoint
+	}
+	return ""
+}
+
+// Owner returns the owner of the lease of the shard, empty if it isn't held.
+func (s *LeaseStore) Owner(shardID string) string {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	if lease, ok := s.leases[shardID]; ok {
+		return lease.Owner
+	}
+	return ""
+}
+
+// AssertCheckpoint waits up to timeout for the shard to be checkpointed at want, e.g. shard.SHARD_END once a parent
+// shard was processed to its end, and fails the test with the last checkpoint seen otherwise. It returns whether the
+// checkpoint was reached.
+func AssertCheckpoint(t testing.TB, store *LeaseStore, shardID, want string, timeout time.Duration) bool {
+	t.Helper()
+	deadline := time.Now().Add(timeout)
+	for {
+		got := store.Checkpoint(shardID)
+		if got == want {
+			return true
+		}
+		if time.Now().After(deadline) {
+			t.Errorf("checkpoint of shard %s is %q after %v, expected %q", shardID, got, timeout, want)
+			return false
+		}
+		time.Sleep(checkpointPollInterval)
+	}
+}
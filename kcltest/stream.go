@@ -0,0 +1,422 @@
+package kcltest
+
+import (
+	"crypto/md5"
+	"fmt"
+	"math/big"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/kinesis"
+)
+
+const (
+	// retentionHours is the retention period the stream reports
+	retentionHours = 24
+
+	// maxGetRecordsLimit is the most record a GetRecords call returns, as in Kinesis
+	maxGetRecordsLimit = 10000
+
+	// maxDescribeStreamLimit is the most shards a DescribeStream call lists, as in Kinesis
+	maxDescribeStreamLimit = 100
+)
+
+// maxHashKey is the end of the hash key range of a stream, 2^128 - 1.
+var maxHashKey = new(big.Int).Sub(new(big.Int).Lsh(big.NewInt(1), 128), big.NewInt(1))
+
+// Stream is an in-memory Kinesis stream implementing goKCL.KinesisAPI, and ListShards. The record are routed to the
+// shards by the MD5 hash of their partition key, or their explicit hash key, as in Kinesis, and the stream can be
+// resharded with SplitShard and MergeShards to test the handling of the child shards. The record are never trimmed.
+type Stream struct {
+	mux  sync.Mutex
+	name string
+	// shards in creation order, closed ones included
+	shards []*fakeShard
+	// sequence number of the last record put, shared by all the shards so that it increases across reshards
+	sequence int64
+}
+
+// fakeShard is a shard of the stream and its record.
+type fakeShard struct {
+	id                    string
+	parentShardID         string
+	adjacentParentShardID string
+	startingHashKey       *big.Int
+	endingHashKey         *big.Int
+	startingSequence      int64
+	// sequence number of the last record of a closed shard, zero while the shard is open
+	endingSequence int64
+	closed         bool
+	records        []*kinesis.Record
+}
+
+// NewStream returns an empty stream whose hash key range is split evenly among the given number of shards.
+func NewStream(name string, shards int) *Stream {
+	s := &Stream{name: name}
+	size := new(big.Int).Div(new(big.Int).Add(maxHashKey, big.NewInt(1)), big.NewInt(int64(shards)))
+	start := big.NewInt(0)
+	for i := 0; i < shards; i++ {
+		end := new(big.Int).Sub(new(big.Int).Add(start, size), big.NewInt(1))
+		if i == shards-1 {
+			end = maxHashKey
+		}
+		s.addShard("", "", start, end)
+		start = new(big.Int).Add(end, big.NewInt(1))
+	}
+	return s
+}
+
+// addShard creates an open shard. It must be called with the lock held.
+func (s *Stream) addShard(parentShardID, adjacentParentShardID string, start, end *big.Int) *fakeShard {
+	sh := &fakeShard{
+		id:                    fmt.Sprintf("shardId-%012d", len(s.shards)),
+		parentShardID:         parentShardID,
+		adjacentParentShardID: adjacentParentShardID,
+		startingHashKey:       start,
+		endingHashKey:         end,
+		startingSequence:      s.sequence + 1,
+	}
+	s.shards = append(s.shards, sh)
+	return sh
+}
+
+// shard returns the shard of the given ID, nil if the stream has none. It must be called with the lock held.
+func (s *Stream) shard(shardID string) *fakeShard {
+	for _, sh := range s.shards {
+		if sh.id == shardID {
+			return sh
+		}
+	}
+	return nil
+}
+
+// checkStreamName fails with ResourceNotFoundException unless name is the one of the stream.
+func (s *Stream) checkStreamName(name *string) error {
+	if aws.StringValue(name) != s.name {
+		return awserr.New(kinesis.ErrCodeResourceNotFoundException,
+			fmt.Sprintf("stream %s not found", aws.StringValue(name)), nil)
+	}
+	return nil
+}
+
+// Put puts a record with the given partition key and data, and returns the shard it went to and its sequence number.
+func (s *Stream) Put(partitionKey string, data []byte) (shardID, sequenceNumber string, err error) {
+	output, err := s.PutRecord(&kinesis.PutRecordInput{
+		StreamName:   aws.String(s.name),
+		PartitionKey: aws.String(partitionKey),
+		Data:         data,
+	})
+	if err != nil {
+		return "", "", err
+	}
+	return aws.StringValue(output.ShardId), aws.StringValue(output.SequenceNumber), nil
+}
+
+// PutRecord appends the record to the open shard whose hash key range covers it.
+func (s *Stream) PutRecord(input *kinesis.PutRecordInput) (*kinesis.PutRecordOutput, error) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	if err := s.checkStreamName(input.StreamName); err != nil {
+		return nil, err
+	}
+
+	hashKey, err := recordHashKey(input)
+	if err != nil {
+		return nil, err
+	}
+	for _, sh := range s.shards {
+		if sh.closed || hashKey.Cmp(sh.startingHashKey) < 0 || hashKey.Cmp(sh.endingHashKey) > 0 {
+			continue
+		}
+		s.sequence++
+		sequenceNumber := strconv.FormatInt(s.sequence, 10)
+		sh.records = append(sh.records, &kinesis.Record{
+			Data:                        input.Data,
+			PartitionKey:                input.PartitionKey,
+			SequenceNumber:              aws.String(sequenceNumber),
+			ApproximateArrivalTimestamp: aws.Time(time.Now()),
+		})
+		return &kinesis.PutRecordOutput{ShardId: aws.String(sh.id), SequenceNumber: aws.String(sequenceNumber)}, nil
+	}
+	return nil, awserr.New(kinesis.ErrCodeInvalidArgumentException, "no open shard covers the hash key", nil)
+}
+
+// recordHashKey returns the explicit hash key of the record, or the MD5 hash of its partition key.
+func recordHashKey(input *kinesis.PutRecordInput) (*big.Int, error) {
+	if input.ExplicitHashKey != nil {
+		hashKey, ok := new(big.Int).SetString(aws.StringValue(input.ExplicitHashKey), 10)
+		if !ok || hashKey.Sign() < 0 || hashKey.Cmp(maxHashKey) > 0 {
+			return nil, awserr.New(kinesis.ErrCodeInvalidArgumentException,
+				fmt.Sprintf("invalid explicit hash key %s", aws.StringValue(input.ExplicitHashKey)), nil)
+		}
+		return hashKey, nil
+	}
+	sum := md5.Sum([]byte(aws.StringValue(input.PartitionKey)))
+	return new(big.Int).SetBytes(sum[:]), nil
+}
+
+// DescribeStream lists the shards of the stream, closed ones included, in pages of at most Limit shards.
+func (s *Stream) DescribeStream(input *kinesis.DescribeStreamInput) (*kinesis.DescribeStreamOutput, error) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	if err := s.checkStreamName(input.StreamName); err != nil {
+		return nil, err
+	}
+
+	limit := int(aws.Int64Value(input.Limit))
+	if limit <= 0 || limit > maxDescribeStreamLimit {
+		limit = maxDescribeStreamLimit
+	}
+	start := 0
+	if input.ExclusiveStartShardId != nil {
+		for i, sh := range s.shards {
+			if sh.id == aws.StringValue(input.ExclusiveStartShardId) {
+				start = i + 1
+			}
+		}
+	}
+	end := start + limit
+	if end > len(s.shards) {
+		end = len(s.shards)
+	}
+
+	return &kinesis.DescribeStreamOutput{
+		StreamDescription: &kinesis.StreamDescription{
+			StreamName:           aws.String(s.name),
+			StreamARN:            aws.String("arn:aws:kinesis:us-east-1:123456789012:stream/" + s.name),
+			StreamStatus:         aws.String(kinesis.StreamStatusActive),
+			RetentionPeriodHours: aws.Int64(retentionHours),
+			Shards:               s.describeShards(s.shards[start:end]),
+			HasMoreShards:        aws.Bool(end < len(s.shards)),
+		},
+	}, nil
+}
+
+// DescribeStreamSummary tells the retention period and the number of open shards of the stream.
+func (s *Stream) DescribeStreamSummary(input *kinesis.DescribeStreamSummaryInput) (*kinesis.DescribeStreamSummaryOutput, error) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	if err := s.checkStreamName(input.StreamName); err != nil {
+		return nil, err
+	}
+
+	open := 0
+	for _, sh := range s.shards {
+		if !sh.closed {
+			open++
+		}
+	}
+	return &kinesis.DescribeStreamSummaryOutput{
+		StreamDescriptionSummary: &kinesis.StreamDescriptionSummary{
+			StreamName:           aws.String(s.name),
+			StreamARN:            aws.String("arn:aws:kinesis:us-east-1:123456789012:stream/" + s.name),
+			StreamStatus:         aws.String(kinesis.StreamStatusActive),
+			RetentionPeriodHours: aws.Int64(retentionHours),
+			OpenShardCount:       aws.Int64(int64(open)),
+		},
+	}, nil
+}
+
+// ListShards lists all the shards of the stream, closed ones included, in a single page.
+func (s *Stream) ListShards(input *kinesis.ListShardsInput) (*kinesis.ListShardsOutput, error) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	if err := s.checkStreamName(input.StreamName); err != nil {
+		return nil, err
+	}
+	return &kinesis.ListShardsOutput{Shards: s.describeShards(s.shards)}, nil
+}
+
+// describeShards returns the description of the shards. It must be called with the lock held.
+func (s *Stream) describeShards(shards []*fakeShard) []*kinesis.Shard {
+	described := make([]*kinesis.Shard, 0, len(shards))
+	for _, sh := range shards {
+		d := &kinesis.Shard{
+			ShardId: aws.String(sh.id),
+			HashKeyRange: &kinesis.HashKeyRange{
+				StartingHashKey: aws.String(sh.startingHashKey.String()),
+				EndingHashKey:   aws.String(sh.endingHashKey.String()),
+			},
+			SequenceNumberRange: &kinesis.SequenceNumberRange{
+				StartingSequenceNumber: aws.String(strconv.FormatInt(sh.startingSequence, 10)),
+			},
+		}
+		if sh.parentShardID != "" {
+			d.ParentShardId = aws.String(sh.parentShardID)
+		}
+		if sh.adjacentParentShardID != "" {
+			d.AdjacentParentShardId = aws.String(sh.adjacentParentShardID)
+		}
+		if sh.closed {
+			d.SequenceNumberRange.EndingSequenceNumber = aws.String(strconv.FormatInt(sh.endingSequence, 10))
+		}
+		described = append(described, d)
+	}
+	return described
+}
+
+// GetShardIterator returns an iterator of the shard at the given position. The iterators don't expire.
+func (s *Stream) GetShardIterator(input *kinesis.GetShardIteratorInput) (*kinesis.GetShardIteratorOutput, error) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	if err := s.checkStreamName(input.StreamName); err != nil {
+		return nil, err
+	}
+	sh := s.shard(aws.StringValue(input.ShardId))
+	if sh == nil {
+		return nil, awserr.New(kinesis.ErrCodeResourceNotFoundException,
+			fmt.Sprintf("shard %s not found", aws.StringValue(input.ShardId)), nil)
+	}
+
+	pos := 0
+	switch aws.StringValue(input.ShardIteratorType) {
+	case kinesis.ShardIteratorTypeTrimHorizon:
+	case kinesis.ShardIteratorTypeLatest:
+		pos = len(sh.records)
+	case kinesis.ShardIteratorTypeAtSequenceNumber, kinesis.ShardIteratorTypeAfterSequenceNumber:
+		sequence, err := strconv.ParseInt(aws.StringValue(input.StartingSequenceNumber), 10, 64)
+		if err != nil {
+			return nil, awserr.New(kinesis.ErrCodeInvalidArgumentException,
+				fmt.Sprintf("invalid sequence number %s", aws.StringValue(input.StartingSequenceNumber)), err)
+		}
+		after := aws.StringValue(input.ShardIteratorType) == kinesis.ShardIteratorTypeAfterSequenceNumber
+		pos = len(sh.records)
+		for i, r := range sh.records {
+			recordSequence, _ := strconv.ParseInt(aws.StringValue(r.SequenceNumber), 10, 64)
+			if recordSequence > sequence || recordSequence == sequence && !after {
+				pos = i
+				break
+			}
+		}
+	case kinesis.ShardIteratorTypeAtTimestamp:
+		pos = len(sh.records)
+		for i, r := range sh.records {
+			if !r.ApproximateArrivalTimestamp.Before(aws.TimeValue(input.Timestamp)) {
+				pos = i
+				break
+			}
+		}
+	default:
+		return nil, awserr.New(kinesis.ErrCodeInvalidArgumentException,
+			fmt.Sprintf("invalid shard iterator type %s", aws.StringValue(input.ShardIteratorType)), nil)
+	}
+	return &kinesis.GetShardIteratorOutput{ShardIterator: aws.String(shardIterator(sh.id, pos))}, nil
+}
+
+// shardIterator returns the iterator reading the shard from the record at position pos.
+func shardIterator(shardID string, pos int) string {
+	return fmt.Sprintf("%s/%d", shardID, pos)
+}
+
+// GetRecords returns up to Limit record of the shard from the iterator. Once a closed shard is read to its end, the
+// next shard iterator is nil.
+func (s *Stream) GetRecords(input *kinesis.GetRecordsInput) (*kinesis.GetRecordsOutput, error) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	iterator := aws.StringValue(input.ShardIterator)
+	sep := strings.LastIndex(iterator, "/")
+	var sh *fakeShard
+	pos := -1
+	if sep > 0 {
+		sh = s.shard(iterator[:sep])
+		pos, _ = strconv.Atoi(iterator[sep+1:])
+	}
+	if sh == nil || pos < 0 || pos > len(sh.records) {
+		return nil, awserr.New(kinesis.ErrCodeInvalidArgumentException, fmt.Sprintf("invalid shard iterator %s",
+			iterator), nil)
+	}
+
+	limit := int(aws.Int64Value(input.Limit))
+	if limit <= 0 || limit > maxGetRecordsLimit {
+		limit = maxGetRecordsLimit
+	}
+	end := pos + limit
+	if end > len(sh.records) {
+		end = len(sh.records)
+	}
+
+	output := &kinesis.GetRecordsOutput{
+		Records:            append([]*kinesis.Record(nil), sh.records[pos:end]...),
+		MillisBehindLatest: aws.Int64(0),
+	}
+	// how far the next record to read is behind the last one of the shard
+	if end < len(sh.records) {
+		behind := aws.TimeValue(sh.records[len(sh.records)-1].ApproximateArrivalTimestamp).
+			Sub(aws.TimeValue(sh.records[end].ApproximateArrivalTimestamp))
+		output.MillisBehindLatest = aws.Int64(int64(behind / time.Millisecond))
+	}
+	if !sh.closed || end < len(sh.records) {
+		output.NextShardIterator = aws.String(shardIterator(sh.id, end))
+	}
+	return output, nil
+}
+
+// SplitShard closes the open shard and splits its hash key range at newStartingHashKey into two child shards, as
+// Kinesis does. It returns the IDs of the children, the lower half first.
+func (s *Stream) SplitShard(shardID, newStartingHashKey string) ([]string, error) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	parent := s.shard(shardID)
+	if parent == nil || parent.closed {
+		return nil, awserr.New(kinesis.ErrCodeResourceNotFoundException,
+			fmt.Sprintf("open shard %s not found", shardID), nil)
+	}
+	split, ok := new(big.Int).SetString(newStartingHashKey, 10)
+	if !ok || split.Cmp(parent.startingHashKey) <= 0 || split.Cmp(parent.endingHashKey) > 0 {
+		return nil, awserr.New(kinesis.ErrCodeInvalidArgumentException,
+			fmt.Sprintf("hash key %s doesn't split shard %s", newStartingHashKey, shardID), nil)
+	}
+
+	s.close(parent)
+	lower := s.addShard(parent.id, "", parent.startingHashKey, new(big.Int).Sub(split, big.NewInt(1)))
+	upper := s.addShard(parent.id, "", split, parent.endingHashKey)
+	return []string{lower.id, upper.id}, nil
+}
+
+// MergeShards closes two open shards with adjacent hash key ranges and merges them into a child shard, as Kinesis
+// does. It returns the ID of the child.
+func (s *Stream) MergeShards(shardID, adjacentShardID string) (string, error) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	parent, adjacent := s.shard(shardID), s.shard(adjacentShardID)
+	if parent == nil || parent.closed || adjacent == nil || adjacent.closed {
+		return "", awserr.New(kinesis.ErrCodeResourceNotFoundException,
+			fmt.Sprintf("open shards %s and %s not found", shardID, adjacentShardID), nil)
+	}
+
+	lower, upper := parent, adjacent
+	if lower.startingHashKey.Cmp(upper.startingHashKey) > 0 {
+		lower, upper = upper, lower
+	}
+	if new(big.Int).Add(lower.endingHashKey, big.NewInt(1)).Cmp(upper.startingHashKey) != 0 {
+		return "", awserr.New(kinesis.ErrCodeInvalidArgumentException,
+			fmt.Sprintf("shards %s and %s aren't adjacent", shardID, adjacentShardID), nil)
+	}
+
+	s.close(parent)
+	s.close(adjacent)
+	child := s.addShard(parent.id, adjacent.id, lower.startingHashKey, upper.endingHashKey)
+	return child.id, nil
+}
+
+// close closes the shard, no record can be put into it anymore. It must be called with the lock held.
+func (s *Stream) close(sh *fakeShard) {
+	sh.closed = true
+	sh.endingSequence = s.sequence
+}
+
+// ShardIDs returns the IDs of the shards of the stream, closed ones included, in creation order.
+func (s *Stream) ShardIDs() []string {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	shardIDs := make([]string, 0, len(s.shards))
+	for _, sh := range s.shards {
+		shardIDs = append(shardIDs, sh.id)
+	}
+	return shardIDs
+}
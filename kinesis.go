@@ -0,0 +1,36 @@
+package goKCL
+
+import (
+	"github.com/aws/aws-sdk-go/service/kinesis"
+)
+
+// KinesisAPI is the subset of the Kinesis API the worker and its shard consumers call. Any implementation can be
+// injected with Worker.WithKinesis, e.g. to wrap the SDK client with custom retries or request logging, or to mock
+// Kinesis in tests without implementing the whole kinesisiface.KinesisAPI.
+//
+// The SDK client, *kinesis.Kinesis, implements it as it is, and so does any kinesisiface.KinesisAPI.
+type KinesisAPI interface {
+	// DescribeStream lists the shards of the stream.
+	DescribeStream(*kinesis.DescribeStreamInput) (*kinesis.DescribeStreamOutput, error)
+
+	// DescribeStreamSummary tells the retention period of the stream.
+	DescribeStreamSummary(*kinesis.DescribeStreamSummaryInput) (*kinesis.DescribeStreamSummaryOutput, error)
+
+	GetShardIterator(*kinesis.GetShardIteratorInput) (*kinesis.GetShardIteratorOutput, error)
+	GetRecords(*kinesis.GetRecordsInput) (*kinesis.GetRecordsOutput, error)
+
+	// PutRecord publishes a record with Worker.Publish.
+	PutRecord(*kinesis.PutRecordInput) (*kinesis.PutRecordOutput, error)
+}
+
+// EnhancedFanOutAPI is the part of the Kinesis API reading the shards with enhanced fan-out, see
+// KinesisClientLibConfiguration.ConsumerName. The SDK client implements it, a KinesisAPI which doesn't makes the
+// worker fall back to polling.
+type EnhancedFanOutAPI interface {
+	DescribeStreamConsumer(*kinesis.DescribeStreamConsumerInput) (*kinesis.DescribeStreamConsumerOutput, error)
+	RegisterStreamConsumer(*kinesis.RegisterStreamConsumerInput) (*kinesis.RegisterStreamConsumerOutput, error)
+	DeregisterStreamConsumer(*kinesis.DeregisterStreamConsumerInput) (*kinesis.DeregisterStreamConsumerOutput, error)
+
+	// SubscribeToShard pushes the record of a shard to the registered consumer over an HTTP/2 event stream.
+	SubscribeToShard(*kinesis.SubscribeToShardInput) (*kinesis.SubscribeToShardOutput, error)
+}
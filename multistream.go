@@ -0,0 +1,128 @@
+package goKCL
+
+import (
+	"context"
+	"sync"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/guygma/goKCL/record"
+	"github.com/guygma/goKCL/shard"
+	"github.com/guygma/goKCL/util"
+)
+
+// multiStream returns true if the worker consumes several streams, see MultiStreamConfig. It then runs a worker per
+// stream, sharing its Kinesis client and lease table.
+func (w *Worker) multiStream() bool {
+	return len(w.kclConfig.MultiStreamConfig.StreamARNs) > 1
+}
+
+// GetStreamWorker returns the worker consuming the stream of the given ARN, e.g. to inspect its shards, nil if the
+// stream isn't consumed. A worker consuming a single stream returns itself.
+func (w *Worker) GetStreamWorker(streamARN string) *Worker {
+	if !w.multiStream() {
+		if streamARN == w.streamARN {
+			return w
+		}
+		return nil
+	}
+	for _, sw := range w.streamWorkers {
+		if sw.streamARN == streamARN {
+			return sw
+		}
+	}
+	return nil
+}
+
+// startStreams starts a worker per stream of the MultiStreamConfig. The leases of each stream are namespaced in the
+// lease table, so the checkpointer needs to be a shard.LeaseNamespacer.
+func (w *Worker) startStreams() error {
+	if w.kc == nil {
+		w.createKinesisClients()
+	}
+
+	if w.checkpointer == nil {
+		log.Info("Creating DynamoDB based checkpointer")
+		w.checkpointer = shard.NewDynamoCheckpoint(w.kclConfig)
+	}
+	namespacer, ok := w.checkpointer.(shard.LeaseNamespacer)
+	if !ok {
+		return util.IllegalArgumentError.MakeErr().
+			WithDetail("checkpointer can't keep the leases of several streams in one lease table")
+	}
+	// the lease table is created once for all the streams
+	if err := w.checkpointer.Init(); err != nil {
+		log.Errorf("Failed to start checkpointer: %+v", err)
+		return err
+	}
+
+	for _, streamARN := range w.kclConfig.MultiStreamConfig.StreamARNs {
+		stream, err := parseStreamARN(streamARN)
+		if err != nil {
+			w.stopStreams()
+			return util.IllegalArgumentError.MakeErr().WithCause(err)
+		}
+
+		streamConfig := *w.kclConfig
+		streamConfig.StreamName = stream.name
+		streamConfig.MultiStreamConfig = MultiStreamConfig{}
+		// the monitoring service is initialized per stream, each gets its own copy of the configuration
+		metricsConfig := *w.metricsConfig
+		sw := NewWorker(w.processorFactory, &streamConfig, &metricsConfig)
+		sw.streamARN = streamARN
+		sw.kc = w.kc
+		sw.fanOutKc = w.fanOutKc
+		sw.checkpointer = namespacer.WithLeaseKeyPrefix(stream.leaseKeyPrefix)
+
+		log.Infof("Starting worker of stream %s", stream.name)
+		if err := sw.Start(); err != nil {
+			w.stopStreams()
+			return err
+		}
+		w.streamWorkers = append(w.streamWorkers, sw)
+	}
+	// the streams are waited for by WaitReady
+	w.markReady()
+	return nil
+}
+
+// shutdownStreams shuts the workers of the streams down concurrently, see ShutdownWithContext.
+func (w *Worker) shutdownStreams(ctx context.Context) error {
+	var errs []error
+	mux := sync.Mutex{}
+	wg := sync.WaitGroup{}
+	for _, sw := range w.streamWorkers {
+		wg.Add(1)
+		go func(sw *Worker) {
+			defer wg.Done()
+			if err := sw.ShutdownWithContext(ctx); err != nil {
+				mux.Lock()
+				errs = append(errs, err)
+				mux.Unlock()
+			}
+		}(sw)
+	}
+	wg.Wait()
+
+	if len(errs) > 0 {
+		return util.ShutdownError.MakeErr().WithDetail("%d streams not drained", len(errs)).WithCauses(errs...)
+	}
+	return nil
+}
+
+// stopStreams shuts the workers of the streams started so far down, after one of them failed to start.
+func (w *Worker) stopStreams() {
+	for _, sw := range w.streamWorkers {
+		sw.Shutdown()
+	}
+	w.streamWorkers = nil
+}
+
+// createProcessor creates the record processor of a shard, telling an IMultiStreamRecordProcessorFactory which
+// stream it consumes.
+func (w *Worker) createProcessor() record.IRecordProcessor {
+	if factory, ok := w.processorFactory.(record.IMultiStreamRecordProcessorFactory); ok && w.streamARN != "" {
+		return factory.CreateProcessorForStream(w.streamARN)
+	}
+	return w.processorFactory.CreateProcessor()
+}
@@ -19,7 +19,15 @@ const (
 // DeaggregateRecords takes an array of Kinesis record and expands any Protobuf
 // record within that array, returning an array of all record
 func DeaggregateRecords(records []*kinesis.Record) ([]*kinesis.Record, error) {
+	allRecords, _, err := DeaggregateUserRecords(records)
+	return allRecords, err
+}
+
+// DeaggregateUserRecords expands the record like DeaggregateRecords, and also returns the sub-sequence number of
+// each user record within its aggregated record. They start at 1, the record which weren't aggregated have none.
+func DeaggregateUserRecords(records []*kinesis.Record) ([]*kinesis.Record, map[*kinesis.Record]int64, error) {
 	var isAggregated bool
+	subSequenceNumbers := make(map[*kinesis.Record]int64)
 	allRecords := make([]*kinesis.Record, 0)
 	for _, record := range records {
 		isAggregated = true
@@ -54,14 +62,15 @@ func DeaggregateRecords(records []*kinesis.Record) ([]*kinesis.Record, error) {
 				err := proto.Unmarshal(messageData, aggRecord)
 
 				if err != nil {
-					return nil, err
+					return nil, nil, err
 				}
 
 				partitionKeys := aggRecord.PartitionKeyTable
 
-				for _, aggrec := range aggRecord.Records {
+				for i, aggrec := range aggRecord.Records {
 					newRecord := createUserRecord(partitionKeys, aggrec, record)
 					allRecords = append(allRecords, newRecord)
+					subSequenceNumbers[newRecord] = int64(i + 1)
 				}
 			}
 		}
@@ -71,7 +80,7 @@ func DeaggregateRecords(records []*kinesis.Record) ([]*kinesis.Record, error) {
 		}
 	}
 
-	return allRecords, nil
+	return allRecords, subSequenceNumbers, nil
 }
 
 // createUserRecord takes in the partitionKeys of the aggregated record, the individual
@@ -0,0 +1,70 @@
+package record
+
+import (
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/kinesis"
+
+	"github.com/guygma/goKCL/util"
+)
+
+const (
+	// STOP stops processing any further record without checkpointing, so the failed record are delivered
+	// again once the shard is picked up by a new record processor.
+	STOP FailurePolicy = iota + 1
+
+	// SKIP logs the failure and drops the failed record as if they had been processed.
+	SKIP
+
+	// DEAD_LETTER hands the failed record to the configured IDeadLetterHandler and moves on.
+	DEAD_LETTER
+)
+
+// FailurePolicy determines how record which failed to be processed (or validated) are dealt with.
+type FailurePolicy int
+
+// RecordValidator checks a record before it is delivered to the record processor. A non-nil error marks the
+// record as malformed, and it is handled according to the configured InvalidRecordPolicy.
+type RecordValidator func(r *kinesis.Record) error
+
+// DeadLetterInput carries a record which could not be processed together with the reason. The context fields make
+// the dead-lettered record self-describing, so that it can be stored as is.
+type DeadLetterInput struct {
+	ShardID string
+	Record  *kinesis.Record
+	Error   error
+
+	SequenceNumber              string
+	PartitionKey                string
+	ApproximateArrivalTimestamp time.Time
+	// Attempts is the number of times the record was attempted before being dead-lettered
+	Attempts int
+	// ClientLibraryError is the library error the record was dead-lettered with, its detail holds Error
+	ClientLibraryError *util.ClientLibraryError
+}
+
+// NewDeadLetterInput creates the DeadLetterInput of a record of the shard which failed with err after the given
+// number of attempts. Unless err already is a ClientLibraryError, it is wrapped in one with the given code.
+func NewDeadLetterInput(shardID string, r *kinesis.Record, attempts int, err error, code util.ErrorCode) *DeadLetterInput {
+	cle, ok := err.(*util.ClientLibraryError)
+	if !ok {
+		cle = code.MakeErr().WithDetail("record %s of shard %s", aws.StringValue(r.SequenceNumber), shardID).WithCause(err)
+	}
+
+	return &DeadLetterInput{
+		ShardID:                     shardID,
+		Record:                      r,
+		Error:                       err,
+		SequenceNumber:              aws.StringValue(r.SequenceNumber),
+		PartitionKey:                aws.StringValue(r.PartitionKey),
+		ApproximateArrivalTimestamp: aws.TimeValue(r.ApproximateArrivalTimestamp),
+		Attempts:                    attempts,
+		ClientLibraryError:          cle,
+	}
+}
+
+// IDeadLetterHandler receives record which have been dead-lettered, e.g. to store them for later inspection.
+type IDeadLetterHandler interface {
+	DeadLetter(input *DeadLetterInput)
+}
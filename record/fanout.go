@@ -0,0 +1,256 @@
+package record
+
+import (
+	"sync"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/kinesis"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/guygma/goKCL/shard"
+	"github.com/guygma/goKCL/util"
+)
+
+// FanOutFactory delivers every record of a shard to several independent record processors within the worker,
+// so that one ingestion can feed several pipelines. Each processor checkpoints in its own namespace, stored in
+// the lease table under "<shard ID>.<processor name>" and removed along with the lease of the shard. The shard
+// itself is checkpointed at the slowest of them.
+//
+// Each processor consumes from its own buffer, so a slow processor only blocks the others once its buffer is full.
+type FanOutFactory struct {
+	checkpointer shard.Checkpointer
+	bufferSize   int
+	names        []string
+	factories    []IRecordProcessorFactory
+}
+
+// fanOutProcessor is the IRecordProcessor of a shard dispatching to the processors of a FanOutFactory.
+type fanOutProcessor struct {
+	factory  *FanOutFactory
+	shardID  string
+	branches []*fanOutBranch
+
+	mux *sync.Mutex
+	// checkpointer of the shard, from the latest input
+	checkpointer IRecordProcessorCheckpointer
+	// last checkpoint of the shard made on behalf of all branches
+	checkpoint string
+	// the lease of the shard was lost, the shard isn't checkpointed anymore
+	zombie bool
+}
+
+// fanOutBranch feeds a single processor.
+type fanOutBranch struct {
+	name      string
+	processor IRecordProcessor
+	status    *shard.Status
+	inputs    chan interface{}
+	done      chan struct{}
+}
+
+// namespaceCheckpointer checkpoints a processor of a FanOutFactory in its own namespace.
+type namespaceCheckpointer struct {
+	fanOut *fanOutProcessor
+	branch *fanOutBranch
+}
+
+// NewFanOutFactory creates a FanOutFactory storing the checkpoints of its processors with checkpointer, and
+// buffering up to bufferSize batches for each of them.
+func NewFanOutFactory(checkpointer shard.Checkpointer, bufferSize int) *FanOutFactory {
+	return &FanOutFactory{
+		checkpointer: checkpointer,
+		bufferSize:   bufferSize,
+	}
+}
+
+// WithProcessor registers a processor factory. The name identifies the checkpoint namespace of its processors.
+func (f *FanOutFactory) WithProcessor(name string, factory IRecordProcessorFactory) *FanOutFactory {
+	f.names = append(f.names, name)
+	f.factories = append(f.factories, factory)
+	return f
+}
+
+// RemoveLeaseItems removes the checkpoints of the processors of the shard.
+func (f *FanOutFactory) RemoveLeaseItems(shardID string) error {
+	for _, name := range f.names {
+		if err := f.checkpointer.RemoveLeaseInfo(shardID + "." + name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (f *FanOutFactory) CreateProcessor() IRecordProcessor {
+	p := &fanOutProcessor{
+		factory: f,
+		mux:     &sync.Mutex{},
+	}
+	for i, name := range f.names {
+		p.branches = append(p.branches, &fanOutBranch{
+			name:      name,
+			processor: f.factories[i].CreateProcessor(),
+			inputs:    make(chan interface{}, f.bufferSize),
+			done:      make(chan struct{}),
+		})
+	}
+	return p
+}
+
+func (p *fanOutProcessor) Initialize(input *shard.InitializationInput) {
+	p.shardID = input.ShardId
+	if input.ExtendedSequenceNumber != nil {
+		p.checkpoint = aws.StringValue(input.ExtendedSequenceNumber.SequenceNumber)
+	}
+
+	for _, b := range p.branches {
+		b.status = &shard.Status{ID: input.ShardId + "." + b.name, Mux: &sync.Mutex{}}
+		if err := p.factory.checkpointer.FetchCheckpoint(b.status); err != nil && err != shard.ErrSequenceIDNotFound {
+			log.Errorf("Failed to fetch checkpoint of %s: %+v", b.status.ID, err)
+		}
+		if b.status.Checkpoint == "" {
+			// a new processor starts from the checkpoint of the shard
+			b.status.Checkpoint = p.checkpoint
+		}
+
+		b.processor.Initialize(&shard.InitializationInput{
+			ShardId:                input.ShardId,
+			ExtendedSequenceNumber: &shard.ExtendedSequenceNumber{SequenceNumber: aws.String(b.status.Checkpoint)},
+			HashKeyRange:           input.HashKeyRange,
+			Context:                input.Context,
+		})
+		go p.run(b)
+	}
+}
+
+func (p *fanOutProcessor) ProcessRecords(input *ProcessRecordsInput) {
+	p.mux.Lock()
+	p.checkpointer = input.Checkpointer
+	p.mux.Unlock()
+
+	for _, b := range p.branches {
+		// skip the record a processor already checkpointed before the shard was picked up again
+		b.status.Mux.Lock()
+		checkpoint, checkpointSubSequence := b.status.Checkpoint, b.status.CheckpointSubSequenceNumber
+		b.status.Mux.Unlock()
+
+		records := make([]*kinesis.Record, 0, len(input.Records))
+		for _, r := range input.Records {
+			c := shard.CompareSequenceNumbers(aws.StringValue(r.SequenceNumber), checkpoint)
+			if c > 0 || c == 0 && checkpointSubSequence > 0 && input.SubSequenceNumbers[r] > checkpointSubSequence {
+				records = append(records, r)
+			}
+		}
+
+		b.inputs <- &ProcessRecordsInput{
+			CacheEntryTime:     input.CacheEntryTime,
+			CacheExitTime:      input.CacheExitTime,
+			Records:            records,
+			Checkpointer:       &namespaceCheckpointer{fanOut: p, branch: b},
+			MillisBehindLatest: input.MillisBehindLatest,
+			SubSequenceNumbers: input.SubSequenceNumbers,
+			Context:            input.Context,
+		}
+	}
+}
+
+// Shutdown waits for every processor to process its buffered record and shut down. Once the lease is lost, the
+// checkpoints of the processors no longer advance the one of the shard.
+func (p *fanOutProcessor) Shutdown(input *util.ShutdownInput) {
+	p.mux.Lock()
+	p.checkpointer = input.Checkpointer
+	p.zombie = input.ShutdownReason == util.ZOMBIE
+	p.mux.Unlock()
+
+	for _, b := range p.branches {
+		b.inputs <- &util.ShutdownInput{
+			ShutdownReason: input.ShutdownReason,
+			Checkpointer:   &namespaceCheckpointer{fanOut: p, branch: b},
+			Context:        input.Context,
+		}
+		close(b.inputs)
+	}
+	for _, b := range p.branches {
+		<-b.done
+	}
+}
+
+func (p *fanOutProcessor) run(b *fanOutBranch) {
+	defer close(b.done)
+	for input := range b.inputs {
+		switch in := input.(type) {
+		case *ProcessRecordsInput:
+			b.processor.ProcessRecords(in)
+		case *util.ShutdownInput:
+			b.processor.Shutdown(in)
+		}
+	}
+}
+
+// advance checkpoints the shard at the slowest checkpoint of all processors, once it moved forward.
+func (p *fanOutProcessor) advance() {
+	p.mux.Lock()
+	defer p.mux.Unlock()
+
+	slowest := ""
+	for i, b := range p.branches {
+		b.status.Mux.Lock()
+		checkpoint := b.status.Checkpoint
+		b.status.Mux.Unlock()
+
+		if i == 0 || shard.CompareSequenceNumbers(checkpoint, slowest) < 0 {
+			slowest = checkpoint
+		}
+	}
+
+	if p.zombie || slowest == "" || shard.CompareSequenceNumbers(slowest, p.checkpoint) <= 0 || p.checkpointer == nil {
+		return
+	}
+	p.checkpoint = slowest
+
+	var sequenceNumber *string
+	if slowest != shard.SHARD_END {
+		sequenceNumber = aws.String(slowest)
+	}
+	if err := p.checkpointer.Checkpoint(sequenceNumber); err != nil {
+		log.Errorf("Failed to checkpoint shard: %s Error: %+v", p.shardID, err)
+	}
+}
+
+func (nc *namespaceCheckpointer) Checkpoint(sequenceNumber *string) error {
+	if sequenceNumber == nil {
+		return nc.checkpointAt(shard.SHARD_END, 0)
+	}
+	return nc.checkpointAt(aws.StringValue(sequenceNumber), 0)
+}
+
+func (nc *namespaceCheckpointer) CheckpointSequence(sequenceNumber string) error {
+	return nc.CheckpointSequenceWithSubSequence(sequenceNumber, 0)
+}
+
+func (nc *namespaceCheckpointer) CheckpointSequenceWithSubSequence(sequenceNumber string, subSequenceNumber int64) error {
+	if err := validateCheckpoint(nc.branch.status, sequenceNumber, subSequenceNumber); err != nil {
+		return err
+	}
+	return nc.checkpointAt(sequenceNumber, subSequenceNumber)
+}
+
+func (nc *namespaceCheckpointer) checkpointAt(sequenceNumber string, subSequenceNumber int64) error {
+	status := nc.branch.status
+	status.Mux.Lock()
+	status.Checkpoint = sequenceNumber
+	status.CheckpointSubSequenceNumber = subSequenceNumber
+	status.Mux.Unlock()
+
+	if err := nc.fanOut.factory.checkpointer.CheckpointSequence(status); err != nil {
+		return err
+	}
+	nc.fanOut.advance()
+	return nil
+}
+
+func (nc *namespaceCheckpointer) PrepareCheckpoint(sequenceNumber *string) (IPreparedCheckpointer, error) {
+	return &PreparedCheckpointer{
+		pendingCheckpointSequenceNumber: &shard.ExtendedSequenceNumber{SequenceNumber: sequenceNumber},
+		checkpointer:                    nc,
+	}, nil
+}
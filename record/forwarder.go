@@ -0,0 +1,256 @@
+package record
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/kinesis"
+	"github.com/aws/aws-sdk-go/service/kinesis/kinesisiface"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/guygma/goKCL/shard"
+	"github.com/guygma/goKCL/util"
+)
+
+const (
+	// maxPutRecordsEntries is the maximum number of record of a PutRecords request
+	maxPutRecordsEntries = 500
+
+	// Failed record are retried 3 times, 100 ms after the first failure then with exponential backoff, by default.
+	DEFAULT_FORWARD_RETRIES = 3
+	DEFAULT_FORWARD_BACKOFF = 100 * time.Millisecond
+)
+
+// ForwardingSinkFactory creates record processors which forward every record to another stream (a tee), keeping its
+// data and partition key.
+type ForwardingSinkFactory struct {
+	kc             kinesisiface.KinesisAPI
+	streamName     string
+	retries        int
+	backoff        time.Duration
+	failureHandler IDeadLetterHandler
+}
+
+// ForwardingSink is an IRecordProcessor that forwards record to another stream with PutRecords and checkpoints after
+// every batch has been forwarded.
+//
+// PutRecords can fail for a subset of the record of a request. Only the failed record are retried, with exponential
+// backoff, before any later batch. The record of a partition key are forwarded in order: a record is held back
+// until the previous record of its partition key has been forwarded. Record still failing after the retries, and
+// the record held back behind them, are handed to the failure handler and the batch is checkpointed. Without
+// failure handler, the sink fails the batch and every later one with ProcessRecordsInput.Fail without
+// checkpointing, so the record are delivered again once the shard is picked up by a new record processor.
+type ForwardingSink struct {
+	shardID        string
+	kc             kinesisiface.KinesisAPI
+	streamName     string
+	retries        int
+	backoff        time.Duration
+	failureHandler IDeadLetterHandler
+	// failure is the error the sink stopped with for lack of failure handler, every later batch fails with it
+	failure error
+}
+
+// forwardFailure is a record which couldn't be forwarded after the given number of attempts.
+type forwardFailure struct {
+	record   *kinesis.Record
+	err      error
+	attempts int
+}
+
+// NewForwardingSinkFactory creates a ForwardingSinkFactory forwarding to the given stream.
+func NewForwardingSinkFactory(kc kinesisiface.KinesisAPI, streamName string) *ForwardingSinkFactory {
+	return &ForwardingSinkFactory{
+		kc:         kc,
+		streamName: streamName,
+		retries:    DEFAULT_FORWARD_RETRIES,
+		backoff:    DEFAULT_FORWARD_BACKOFF,
+	}
+}
+
+// WithRetries configures how many times failed record are retried, and the backoff before the first retry. The
+// backoff doubles with every retry.
+func (f *ForwardingSinkFactory) WithRetries(retries int, backoff time.Duration) *ForwardingSinkFactory {
+	f.retries = retries
+	f.backoff = backoff
+	return f
+}
+
+// WithFailureHandler configures the handler receiving the record which couldn't be forwarded after all retries.
+func (f *ForwardingSinkFactory) WithFailureHandler(handler IDeadLetterHandler) *ForwardingSinkFactory {
+	f.failureHandler = handler
+	return f
+}
+
+func (f *ForwardingSinkFactory) CreateProcessor() IRecordProcessor {
+	return &ForwardingSink{
+		kc:             f.kc,
+		streamName:     f.streamName,
+		retries:        f.retries,
+		backoff:        f.backoff,
+		failureHandler: f.failureHandler,
+	}
+}
+
+func (fs *ForwardingSink) Initialize(input *shard.InitializationInput) {
+	fs.shardID = input.ShardId
+}
+
+func (fs *ForwardingSink) ProcessRecords(input *ProcessRecordsInput) {
+	if fs.failure != nil {
+		input.Fail(fs.failure)
+		return
+	}
+	if len(input.Records) == 0 {
+		return
+	}
+
+	failed := fs.forward(input.Records)
+	if len(failed) > 0 {
+		if fs.failureHandler == nil {
+			log.Errorf("Failed to forward %d record of shard: %s to stream: %s, stop processing. Error: %+v",
+				len(failed), fs.shardID, fs.streamName, failed[0].err)
+			fs.failure = util.KinesisClientLibNonRetryableException.MakeErr().
+				WithDetail("forwarding %d record of shard %s to stream %s", len(failed), fs.shardID, fs.streamName).
+				WithCause(failed[0].err)
+			input.Fail(fs.failure)
+			return
+		}
+		for _, f := range failed {
+			fs.failureHandler.DeadLetter(NewDeadLetterInput(fs.shardID, f.record, f.attempts, f.err,
+				util.KinesisClientLibDependencyError))
+		}
+	}
+
+	lastSequenceNumber := input.Records[len(input.Records)-1].SequenceNumber
+	if err := input.Checkpointer.Checkpoint(lastSequenceNumber); err != nil {
+		log.Errorf("Failed to checkpoint shard: %s Error: %+v", fs.shardID, err)
+	}
+}
+
+func (fs *ForwardingSink) Shutdown(input *util.ShutdownInput) {
+	// Every forwarded batch has already been checkpointed. Only a closed shard needs its end to be recorded.
+	if input.ShutdownReason == util.TERMINATE && fs.failure == nil {
+		if err := input.Checkpointer.Checkpoint(nil); err != nil {
+			log.Errorf("Failed to checkpoint end of shard: %s Error: %+v", fs.shardID, err)
+		}
+	}
+}
+
+// forward puts the record to the stream, retrying the failed ones. Every request holds at most one record per
+// partition key, the oldest one not forwarded yet, so that a record is only put once the previous record of its
+// partition key has been. It returns the record which couldn't be forwarded, in order: the ones still failing after
+// all retries, and the later record of their partition key which were held back.
+func (fs *ForwardingSink) forward(records []*kinesis.Record) []*forwardFailure {
+	// the record not forwarded yet per partition key, the keys in the order of their first record
+	var keys []string
+	pending := make(map[string][]*kinesis.Record)
+	for _, r := range records {
+		key := aws.StringValue(r.PartitionKey)
+		if _, ok := pending[key]; !ok {
+			keys = append(keys, key)
+		}
+		pending[key] = append(pending[key], r)
+	}
+
+	failures := make(map[*kinesis.Record]*forwardFailure)
+	attempts := make(map[string]int)
+	backoff := fs.backoff
+	for {
+		var heads []*kinesis.Record
+		for _, key := range keys {
+			if len(pending[key]) > 0 {
+				heads = append(heads, pending[key][0])
+			}
+		}
+		if len(heads) == 0 {
+			break
+		}
+
+		errs := make(map[*kinesis.Record]error)
+		for start := 0; start < len(heads); start += maxPutRecordsEntries {
+			end := start + maxPutRecordsEntries
+			if end > len(heads) {
+				end = len(heads)
+			}
+			failed, e := fs.put(heads[start:end])
+			for i, r := range failed {
+				errs[r] = e[i]
+			}
+		}
+
+		retrying := 0
+		for _, r := range heads {
+			key := aws.StringValue(r.PartitionKey)
+			err, ok := errs[r]
+			if !ok {
+				pending[key] = pending[key][1:]
+				attempts[key] = 0
+				continue
+			}
+			if attempts[key]++; attempts[key] <= fs.retries {
+				retrying++
+				continue
+			}
+			failures[r] = &forwardFailure{record: r, err: err, attempts: attempts[key]}
+			for _, held := range pending[key][1:] {
+				failures[held] = &forwardFailure{
+					record: held,
+					err:    fmt.Errorf("record %s of the same partition key failed: %v", aws.StringValue(r.SequenceNumber), err),
+				}
+			}
+			pending[key] = nil
+		}
+
+		if retrying > 0 {
+			log.Warnf("Failed to forward %d record of shard: %s, retrying in %v", retrying, fs.shardID, backoff)
+			time.Sleep(backoff)
+			backoff *= 2
+		}
+	}
+
+	var failed []*forwardFailure
+	for _, r := range records {
+		if f, ok := failures[r]; ok {
+			failed = append(failed, f)
+		}
+	}
+	return failed
+}
+
+// put sends a single PutRecords request and returns the failed record, in order, with their error.
+func (fs *ForwardingSink) put(records []*kinesis.Record) ([]*kinesis.Record, []error) {
+	entries := make([]*kinesis.PutRecordsRequestEntry, 0, len(records))
+	for _, r := range records {
+		entries = append(entries, &kinesis.PutRecordsRequestEntry{
+			Data:         r.Data,
+			PartitionKey: r.PartitionKey,
+		})
+	}
+
+	resp, err := fs.kc.PutRecords(&kinesis.PutRecordsInput{
+		Records:    entries,
+		StreamName: aws.String(fs.streamName),
+	})
+	if err != nil {
+		errs := make([]error, len(records))
+		for i := range errs {
+			errs[i] = err
+		}
+		return records, errs
+	}
+	if aws.Int64Value(resp.FailedRecordCount) == 0 {
+		return nil, nil
+	}
+
+	var failed []*kinesis.Record
+	var errs []error
+	for i, result := range resp.Records {
+		if result.ErrorCode != nil {
+			failed = append(failed, records[i])
+			errs = append(errs, fmt.Errorf("%s: %s", aws.StringValue(result.ErrorCode), aws.StringValue(result.ErrorMessage)))
+		}
+	}
+	return failed, errs
+}
@@ -0,0 +1,183 @@
+package record
+
+import (
+	"sync"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/kinesis"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/guygma/goKCL/shard"
+	"github.com/guygma/goKCL/util"
+)
+
+// RecordIterator delivers the record of all shards processed by a worker to a pull based consumer. It is the
+// IRecordProcessorFactory of the worker. Every record pulled from it must be Ack'd or Nack'd: the checkpoint of a
+// shard only advances up to its highest contiguous Ack'd record, and Nack'd record are dealt with according to the
+// failure policy.
+type RecordIterator struct {
+	records           chan *AckableRecord
+	failurePolicy     FailurePolicy
+	deadLetterHandler IDeadLetterHandler
+}
+
+// AckableRecord is a record delivered by a RecordIterator.
+type AckableRecord struct {
+	*kinesis.Record
+	ShardID string
+
+	processor *iteratorProcessor
+	settled   bool
+}
+
+// iteratorProcessor is the IRecordProcessor feeding the record of a single shard to a RecordIterator.
+type iteratorProcessor struct {
+	shardID      string
+	iterator     *RecordIterator
+	checkpointer IRecordProcessorCheckpointer
+
+	mux *sync.Mutex
+	// signaled whenever pending record are settled
+	settledCond *sync.Cond
+	// record delivered but not checkpointed yet, in shard order
+	pending []*AckableRecord
+	stopped bool
+}
+
+// NewRecordIterator creates a RecordIterator buffering up to bufferSize record. Once the buffer is full the shard
+// consumers block until record are pulled. Nack'd record follow the STOP failure policy unless configured
+// otherwise with WithFailurePolicy.
+func NewRecordIterator(bufferSize int) *RecordIterator {
+	return &RecordIterator{
+		records:       make(chan *AckableRecord, bufferSize),
+		failurePolicy: STOP,
+	}
+}
+
+// WithFailurePolicy configures how Nack'd record are dealt with. The handler is only used by DEAD_LETTER.
+func (it *RecordIterator) WithFailurePolicy(policy FailurePolicy, handler IDeadLetterHandler) *RecordIterator {
+	it.failurePolicy = policy
+	it.deadLetterHandler = handler
+	return it
+}
+
+// Next blocks until the next record is available.
+func (it *RecordIterator) Next() *AckableRecord {
+	return <-it.records
+}
+
+// Records returns the channel the record are delivered on, e.g. to select on it.
+func (it *RecordIterator) Records() <-chan *AckableRecord {
+	return it.records
+}
+
+func (it *RecordIterator) CreateProcessor() IRecordProcessor {
+	mux := &sync.Mutex{}
+	return &iteratorProcessor{
+		iterator:    it,
+		mux:         mux,
+		settledCond: sync.NewCond(mux),
+	}
+}
+
+// Ack marks the record as processed.
+func (r *AckableRecord) Ack() {
+	r.processor.settle(r, nil)
+}
+
+// Nack marks the record as failed, it is dealt with according to the failure policy of the iterator.
+func (r *AckableRecord) Nack(err error) {
+	r.processor.settle(r, err)
+}
+
+func (p *iteratorProcessor) Initialize(input *shard.InitializationInput) {
+	p.shardID = input.ShardId
+}
+
+func (p *iteratorProcessor) ProcessRecords(input *ProcessRecordsInput) {
+	p.mux.Lock()
+	if p.stopped {
+		p.mux.Unlock()
+		return
+	}
+	p.checkpointer = input.Checkpointer
+	records := make([]*AckableRecord, 0, len(input.Records))
+	for _, r := range input.Records {
+		records = append(records, &AckableRecord{Record: r, ShardID: p.shardID, processor: p})
+	}
+	p.pending = append(p.pending, records...)
+	p.mux.Unlock()
+
+	for _, r := range records {
+		p.iterator.records <- r
+	}
+}
+
+func (p *iteratorProcessor) Shutdown(input *util.ShutdownInput) {
+	p.mux.Lock()
+	defer p.mux.Unlock()
+
+	if input.ShutdownReason != util.TERMINATE {
+		// the lease is going away, record settled from now on must not move the checkpoint
+		p.stopped = true
+		return
+	}
+
+	// The end of a closed shard can only be recorded once all its record have been settled.
+	for len(p.pending) > 0 && !p.stopped {
+		p.settledCond.Wait()
+	}
+	if p.stopped {
+		return
+	}
+	p.stopped = true
+	if err := input.Checkpointer.Checkpoint(nil); err != nil {
+		log.Errorf("Failed to checkpoint end of shard: %s Error: %+v", p.shardID, err)
+	}
+}
+
+// settle records the outcome of a record and advances the checkpoint past the contiguous prefix of settled record.
+func (p *iteratorProcessor) settle(r *AckableRecord, err error) {
+	p.mux.Lock()
+	defer p.mux.Unlock()
+	defer p.settledCond.Broadcast()
+
+	if p.stopped || r.settled {
+		return
+	}
+
+	if err != nil {
+		switch p.iterator.failurePolicy {
+		case SKIP:
+			log.Errorf("Failed to process record %s of shard: %s, skipping it. Error: %+v",
+				aws.StringValue(r.SequenceNumber), p.shardID, err)
+		case DEAD_LETTER:
+			if p.iterator.deadLetterHandler == nil {
+				log.Warnf("No dead-letter handler configured, dropping record %s of shard %s",
+					aws.StringValue(r.SequenceNumber), p.shardID)
+			} else {
+				p.iterator.deadLetterHandler.DeadLetter(
+					NewDeadLetterInput(p.shardID, r.Record, 1, err, util.KinesisClientLibNonRetryableException))
+			}
+		default:
+			log.Errorf("Failed to process record %s of shard: %s, stop processing. Error: %+v",
+				aws.StringValue(r.SequenceNumber), p.shardID, err)
+			p.stopped = true
+			return
+		}
+	}
+	r.settled = true
+
+	var last *AckableRecord
+	for len(p.pending) > 0 && p.pending[0].settled {
+		last = p.pending[0]
+		p.pending = p.pending[1:]
+	}
+	if last == nil {
+		return
+	}
+
+	if err := p.checkpointer.Checkpoint(last.SequenceNumber); err != nil {
+		log.Errorf("Failed to checkpoint shard: %s Error: %+v", p.shardID, err)
+	}
+}
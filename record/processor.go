@@ -1,6 +1,7 @@
 package record
 
 import (
+	"context"
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/service/kinesis"
 	"github.com/guygma/goKCL/shard"
@@ -8,12 +9,48 @@ import (
 	"time"
 )
 
+// ProcessRecordsInput is a batch of record delivered to the record processor. MillisBehindLatest is how far the
+// record are behind the tip of the stream, as reported by GetRecords or by the SubscribeToShardEvent with enhanced
+// fan-out, close to zero once the processor caught up, e.g. to skip expensive work while catching up.
 type ProcessRecordsInput struct {
 	CacheEntryTime     *time.Time
 	CacheExitTime      *time.Time
 	Records            []*kinesis.Record
 	Checkpointer       IRecordProcessorCheckpointer
 	MillisBehindLatest int64
+	// SubSequenceNumbers of the user record deaggregated from record aggregated by the Kinesis Producer Library,
+	// see ExtendedSequenceNumber
+	SubSequenceNumbers map[*kinesis.Record]int64
+	// Context is canceled once the worker shuts down or the lease of the shard is lost, e.g. to abort long calls
+	// whose result can't be checkpointed anymore. It has the deadline of the processing timeout with
+	// IContextRecordProcessor and IPartialFailureRecordProcessor.
+	Context context.Context
+
+	// err the record processor failed the batch with, see Fail
+	err error
+}
+
+// Fail tells the library that the record processor failed to process the batch, so that it isn't checkpointed on
+// its behalf, see goKCL.CheckpointConfig. With a ProcessingFailurePolicy, the batch is delivered again if err is
+// retryable, e.g. util.KinesisClientLibRetryableError.MakeErr().WithCause(cause), and handled according to the
+// policy otherwise, e.g. with a util.KinesisClientLibNonRetryableException. See util.IsRetryable.
+func (i *ProcessRecordsInput) Fail(err error) {
+	i.err = err
+}
+
+// Err returns the error the record processor failed the batch with, nil if it didn't.
+func (i *ProcessRecordsInput) Err() error {
+	return i.err
+}
+
+// ExtendedSequenceNumber returns the sequence number of a record with its sub-sequence number within the record
+// aggregated by the KPL it was part of, starting at 1, or 0 if it wasn't aggregated. Checkpointing it with
+// CheckpointSequenceWithSubSequence resumes after that user record.
+func (i *ProcessRecordsInput) ExtendedSequenceNumber(r *kinesis.Record) *shard.ExtendedSequenceNumber {
+	return &shard.ExtendedSequenceNumber{
+		SequenceNumber:    r.SequenceNumber,
+		SubSequenceNumber: i.SubSequenceNumbers[r],
+	}
 }
 
 // IRecordProcessor is the interface for some callback functions invoked by KCL will
@@ -55,6 +92,87 @@ type IRecordProcessor interface {
 	Shutdown(shutdownInput *util.ShutdownInput)
 }
 
+// ILeaseLostRecordProcessor is implemented by record processors which react to the lease of their shard being taken
+// over by another worker, e.g. to cancel their outstanding work and clear their local caches. LeaseLost is called as
+// soon as the loss is detected, before the record processor is shut down with the ZOMBIE reason. The record of the
+// shard are now processed by the new owner, from its last checkpoint.
+type ILeaseLostRecordProcessor interface {
+	IRecordProcessor
+
+	// LeaseLost is called once the lease of the shard was lost, the context of the record processor is canceled.
+	LeaseLost(leaseLostInput *LeaseLostInput)
+}
+
+// LeaseLostInput tells which shard a record processor lost the lease of, and to which worker.
+type LeaseLostInput struct {
+	ShardID string
+	// NewOwner is the worker owning the lease now, empty if it isn't known
+	NewOwner string
+}
+
+// IOrderIndependentRecordProcessor is implemented by record processors whose record can be processed in any order,
+// e.g. idempotent writes keyed by record. With a RecordDeliveryConcurrency above 1, the record of a batch are then
+// handed to ProcessRecord concurrently instead of being delivered to ProcessRecords, and the library checkpoints
+// the batch once all of them are processed.
+type IOrderIndependentRecordProcessor interface {
+	IRecordProcessor
+
+	// ProcessRecord processes a single record. It is called concurrently for the record of a batch, in no
+	// particular order.
+	ProcessRecord(record *kinesis.Record)
+}
+
+// IContextRecordProcessor is implemented by record processors which bound their own work. The batches are then
+// delivered to ProcessRecordsWithContext instead of ProcessRecords, with a context whose deadline is when the
+// processing timeout of the batch expires, so that the record processor can checkpoint its progress before the
+// library gives up the shard. The context has no deadline without processing timeout, or during the warm-up.
+type IContextRecordProcessor interface {
+	IRecordProcessor
+
+	// ProcessRecordsWithContext processes a batch of record, like ProcessRecords, within the deadline of ctx.
+	ProcessRecordsWithContext(ctx context.Context, processRecordsInput *ProcessRecordsInput)
+}
+
+// IPartialFailureRecordProcessor is implemented by record processors which may process a batch only partially, e.g.
+// when a downstream write fails in the middle of the batch. The batches are then delivered to
+// ProcessRecordsWithResult instead of ProcessRecords, and the result tells how far the batch was processed: the
+// shard consumer checkpoints at the last record processed successfully and delivers the remainder of the batch again
+// with the next batch, after TaskBackoffTimeMillis. Delivery stays at-least-once: the record the record processor
+// handled after the first failure are delivered again too, and so are the record of an aggregated record the failure
+// is in the middle of. Like with IContextRecordProcessor, ProcessRecordsInput.Context expires with the processing
+// timeout of the batch.
+type IPartialFailureRecordProcessor interface {
+	IRecordProcessor
+
+	// ProcessRecordsWithResult processes a batch of record, like ProcessRecords, and returns how far it got.
+	ProcessRecordsWithResult(processRecordsInput *ProcessRecordsInput) ProcessRecordsResult
+}
+
+// ProcessRecordsResult tells how far an IPartialFailureRecordProcessor processed a batch. The zero value is a batch
+// processed entirely.
+type ProcessRecordsResult struct {
+	// Failed tells that the record after the last successful one weren't processed
+	Failed bool
+
+	// LastSuccessfulSequenceNumber is the sequence number of the last record processed successfully, nil if none
+	// was, e.g. the first record of the batch failed. LastSuccessfulSubSequenceNumber tells the user record of an
+	// aggregated record apart, see ProcessRecordsInput.ExtendedSequenceNumber.
+	LastSuccessfulSequenceNumber    *string
+	LastSuccessfulSubSequenceNumber int64
+}
+
+// PartialFailure returns the result of a batch processed up to the given record, nil if its first record failed.
+func (i *ProcessRecordsInput) PartialFailure(lastSuccessful *kinesis.Record) ProcessRecordsResult {
+	if lastSuccessful == nil {
+		return ProcessRecordsResult{Failed: true}
+	}
+	return ProcessRecordsResult{
+		Failed:                          true,
+		LastSuccessfulSequenceNumber:    lastSuccessful.SequenceNumber,
+		LastSuccessfulSubSequenceNumber: i.SubSequenceNumbers[lastSuccessful],
+	}
+}
+
 // IRecordProcessorFactory is interface for creating IRecordProcessor. Each Worker can have multiple threads
 // for processing shard. Client can choose either creating one processor per shard or sharing them.
 type IRecordProcessorFactory interface {
@@ -67,6 +185,26 @@ type IRecordProcessorFactory interface {
 	CreateProcessor() IRecordProcessor
 }
 
+// IMultiStreamRecordProcessorFactory is implemented by the factories of a worker consuming several streams, see
+// MultiStreamConfig, to tell the record processors which stream they consume. The worker calls
+// CreateProcessorForStream instead of CreateProcessor.
+type IMultiStreamRecordProcessorFactory interface {
+	IRecordProcessorFactory
+
+	// CreateProcessorForStream returns a record processor for a shard of the stream of the given ARN.
+	CreateProcessorForStream(streamARN string) IRecordProcessor
+}
+
+// ILeaseItemsRecordProcessorFactory is implemented by the factories whose record processors keep lease items of their
+// own along with the lease of a shard, e.g. FanOutFactory. The worker removes them before the lease of a shard which
+// no longer exists in the stream.
+type ILeaseItemsRecordProcessorFactory interface {
+	IRecordProcessorFactory
+
+	// RemoveLeaseItems removes the lease items kept for the shard of the given ID.
+	RemoveLeaseItems(shardID string) error
+}
+
 type IPreparedCheckpointer interface {
 	GetPendingCheckpoint() *shard.ExtendedSequenceNumber
 
@@ -118,6 +256,31 @@ type IRecordProcessorCheckpointer interface {
 	 */
 	Checkpoint(sequenceNumber *string) error
 
+	/**
+	 * This method will checkpoint the progress at the provided sequenceNumber, e.g. the sequence number of the last
+	 * record durably persisted by the processor, which may lag the last record delivered.
+	 *
+	 * @param sequenceNumber A sequence number at which to checkpoint in this shard. Upon failover,
+	 *        the Kinesis Client Library will start fetching record after this sequence number.
+	 * @error IllegalArgumentError The sequence number is not a valid sequence number for a record in this shard,
+	 *         i.e. it is malformed or out of the sequence number range of the shard.
+	 * @error The errors of Checkpoint otherwise.
+	 */
+	CheckpointSequence(sequenceNumber string) error
+
+	/**
+	 * This method will checkpoint the progress at the user record subSequenceNumber of the aggregated record at the
+	 * provided sequenceNumber. Upon failover, the Kinesis Client Library will start fetching record at this
+	 * sequence number, delivering the aggregated record again.
+	 *
+	 * @param sequenceNumber A sequence number at which to checkpoint in this shard.
+	 * @param subSequenceNumber The sub-sequence number of the user record within the aggregated record.
+	 * @error IllegalArgumentError The sequence number is not a valid sequence number for a record in this shard,
+	 *         or the sub-sequence number is negative.
+	 * @error The errors of Checkpoint otherwise.
+	 */
+	CheckpointSequenceWithSubSequence(sequenceNumber string, subSequenceNumber int64) error
+
 	/**
 	 * This method will record a pending checkpoint at the provided sequenceNumber.
 	 *
@@ -159,6 +322,8 @@ type PreparedCheckpointer struct {
 type RecordProcessorCheckpointer struct {
 	shard      *shard.Status
 	checkpoint shard.Checkpointer
+	// strict rejects checkpoints behind the current one
+	strict bool
 }
 
 func NewRecordProcessorCheckpoint(shard *shard.Status, checkpoint shard.Checkpointer) IRecordProcessorCheckpointer {
@@ -168,6 +333,16 @@ func NewRecordProcessorCheckpoint(shard *shard.Status, checkpoint shard.Checkpoi
 	}
 }
 
+// NewStrictRecordProcessorCheckpoint creates a checkpointer rejecting any checkpoint behind the current one of the
+// shard with an IllegalArgumentError. Only ResetCheckpoint can move the checkpoint backwards.
+func NewStrictRecordProcessorCheckpoint(shard *shard.Status, checkpoint shard.Checkpointer) IRecordProcessorCheckpointer {
+	return &RecordProcessorCheckpointer{
+		shard:      shard,
+		checkpoint: checkpoint,
+		strict:     true,
+	}
+}
+
 func (pc *PreparedCheckpointer) GetPendingCheckpoint() *shard.ExtendedSequenceNumber {
 	return pc.pendingCheckpointSequenceNumber
 }
@@ -177,17 +352,78 @@ func (pc *PreparedCheckpointer) Checkpoint() error {
 }
 
 func (rc *RecordProcessorCheckpointer) Checkpoint(sequenceNumber *string) error {
-	rc.shard.Mux.Lock()
-
 	// checkpoint the last sequence of a closed shard
 	if sequenceNumber == nil {
-		rc.shard.Checkpoint = shard.SHARD_END
-	} else {
-		rc.shard.Checkpoint = aws.StringValue(sequenceNumber)
+		return rc.checkpointAt(shard.SHARD_END, 0)
+	}
+	return rc.checkpointAt(aws.StringValue(sequenceNumber), 0)
+}
+
+// CheckpointSequence checkpoints at the given sequence number once it is validated against the range of the shard.
+func (rc *RecordProcessorCheckpointer) CheckpointSequence(sequenceNumber string) error {
+	return rc.CheckpointSequenceWithSubSequence(sequenceNumber, 0)
+}
+
+// CheckpointSequenceWithSubSequence checkpoints at the given user record of an aggregated record once the sequence
+// number is validated against the range of the shard.
+func (rc *RecordProcessorCheckpointer) CheckpointSequenceWithSubSequence(sequenceNumber string, subSequenceNumber int64) error {
+	if err := validateCheckpoint(rc.shard, sequenceNumber, subSequenceNumber); err != nil {
+		return err
+	}
+	return rc.checkpointAt(sequenceNumber, subSequenceNumber)
+}
+
+func (rc *RecordProcessorCheckpointer) checkpointAt(sequenceNumber string, subSequenceNumber int64) error {
+	rc.shard.Mux.Lock()
+
+	if rc.strict && behindCheckpoint(rc.shard, sequenceNumber, subSequenceNumber) {
+		current := rc.shard.Checkpoint
+		rc.shard.Mux.Unlock()
+		return util.IllegalArgumentError.MakeErr().
+			WithDetail("checkpoint %s is behind the current checkpoint %s", sequenceNumber, current)
 	}
 
+	rc.shard.Checkpoint = sequenceNumber
+	rc.shard.CheckpointSubSequenceNumber = subSequenceNumber
+
 	rc.shard.Mux.Unlock()
-	return rc.checkpoint.CheckpointSequence(rc.shard)
+	return rc.writeCheckpoint()
+}
+
+// behindCheckpoint tells whether a checkpoint is behind the current one of the shard. It must be called with the
+// lock of the shard held.
+func behindCheckpoint(st *shard.Status, sequenceNumber string, subSequenceNumber int64) bool {
+	if c := shard.CompareSequenceNumbers(sequenceNumber, st.Checkpoint); c != 0 {
+		return c < 0
+	}
+	return subSequenceNumber < st.CheckpointSubSequenceNumber
+}
+
+// validateCheckpoint returns an IllegalArgumentError unless the checkpoint is at a record of the shard.
+func validateCheckpoint(st *shard.Status, sequenceNumber string, subSequenceNumber int64) error {
+	if subSequenceNumber < 0 {
+		return util.IllegalArgumentError.MakeErr().WithDetail("negative sub-sequence number %d", subSequenceNumber)
+	}
+	return st.ValidateSequenceNumber(sequenceNumber)
+}
+
+// ResetCheckpoint sets the checkpoint of the shard to the given sequence number even if it is behind the current
+// one, e.g. to reprocess record. It is the only way to move the checkpoint backwards in strict mode.
+func (rc *RecordProcessorCheckpointer) ResetCheckpoint(sequenceNumber *string) error {
+	rc.shard.Mux.Lock()
+	rc.shard.Checkpoint = aws.StringValue(sequenceNumber)
+	rc.shard.CheckpointSubSequenceNumber = 0
+	rc.shard.Mux.Unlock()
+	return rc.writeCheckpoint()
+}
+
+// writeCheckpoint writes the checkpoint of the shard to the lease table, counting it for the worker summary.
+func (rc *RecordProcessorCheckpointer) writeCheckpoint() error {
+	if err := rc.checkpoint.CheckpointSequence(rc.shard); err != nil {
+		return err
+	}
+	rc.shard.MarkCheckpointWritten()
+	return nil
 }
 
 func (rc *RecordProcessorCheckpointer) PrepareCheckpoint(sequenceNumber *string) (IPreparedCheckpointer, error) {
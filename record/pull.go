@@ -0,0 +1,254 @@
+package record
+
+import (
+	"context"
+	"errors"
+	"sync"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/kinesis"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/guygma/goKCL/shard"
+	"github.com/guygma/goKCL/util"
+)
+
+// ErrShardEnd is returned by ShardConsumer.Next once all the record of a closed shard have been pulled and Ack'd,
+// the end of the shard being checkpointed.
+var ErrShardEnd = errors.New("ShardEnd")
+
+// ErrShardConsumerShutdown is returned by ShardConsumer.Next and Ack once the shard isn't consumed anymore, e.g. its
+// lease was lost or the worker shut down. Record Ack'd from then on don't move the checkpoint.
+var ErrShardConsumerShutdown = errors.New("ShardConsumerShutdown")
+
+// Ack checkpoints the shard at the record it was pulled with, and so at all the record pulled before it.
+type Ack func() error
+
+// ShardConsumer delivers the record of a single shard to a pull based consumer, one at a time with Next. It is the
+// IRecordProcessor of the shard, see ShardConsumerFactory to get the ShardConsumer of every shard owned by a worker.
+// Unlike RecordIterator, the record are Ack'd in shard order: an Ack checkpoints all the record pulled before it.
+type ShardConsumer struct {
+	shardID string
+	records chan *pulledRecord
+
+	mux *sync.Mutex
+	// signaled whenever pending record are Ack'd
+	ackedCond *sync.Cond
+	// record delivered but not Ack'd yet, in shard order
+	pending []*pulledRecord
+	// closed once the shard isn't consumed anymore, err telling why
+	done chan struct{}
+	err  error
+}
+
+// pulledRecord is a record delivered by a ShardConsumer, along with its sub-sequence number, if deaggregated, and the
+// checkpointer of its batch.
+type pulledRecord struct {
+	record            *kinesis.Record
+	subSequenceNumber int64
+	checkpointer      IRecordProcessorCheckpointer
+}
+
+// ShardConsumerFactory is the IRecordProcessorFactory of a worker consuming its shards with ShardConsumers.
+type ShardConsumerFactory struct {
+	bufferSize int
+	consumers  chan *ShardConsumer
+}
+
+// NewShardConsumer creates a ShardConsumer buffering up to bufferSize record. Once the buffer is full the shard
+// consumer blocks until record are pulled.
+func NewShardConsumer(bufferSize int) *ShardConsumer {
+	mux := &sync.Mutex{}
+	return &ShardConsumer{
+		records:   make(chan *pulledRecord, bufferSize),
+		mux:       mux,
+		ackedCond: sync.NewCond(mux),
+		done:      make(chan struct{}),
+	}
+}
+
+// NewShardConsumerFactory creates a factory of ShardConsumers buffering up to bufferSize record each.
+func NewShardConsumerFactory(bufferSize int) *ShardConsumerFactory {
+	return &ShardConsumerFactory{
+		bufferSize: bufferSize,
+		consumers:  make(chan *ShardConsumer),
+	}
+}
+
+func (f *ShardConsumerFactory) CreateProcessor() IRecordProcessor {
+	return &acceptedShardConsumer{ShardConsumer: NewShardConsumer(f.bufferSize), factory: f}
+}
+
+// Accept blocks until the worker starts consuming a shard and returns its ShardConsumer. The shard isn't read
+// until its ShardConsumer is accepted.
+func (f *ShardConsumerFactory) Accept(ctx context.Context) (*ShardConsumer, error) {
+	select {
+	case c := <-f.consumers:
+		return c, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// acceptedShardConsumer hands the ShardConsumer over to ShardConsumerFactory.Accept once initialized.
+type acceptedShardConsumer struct {
+	*ShardConsumer
+	factory *ShardConsumerFactory
+}
+
+// Initialize blocks until the ShardConsumer is accepted, or the shard isn't consumed anymore: the lease renewals and
+// the shutdown of the shard consumer wait for it.
+func (c *acceptedShardConsumer) Initialize(input *shard.InitializationInput) {
+	c.ShardConsumer.Initialize(input)
+	select {
+	case c.factory.consumers <- c.ShardConsumer:
+	case <-contextDone(input.Context):
+		log.Warnf("Shard consumer of %s not accepted before the shard went away", c.shardID)
+	}
+}
+
+// ShardID returns the ID of the shard consumed.
+func (c *ShardConsumer) ShardID() string {
+	return c.shardID
+}
+
+// Next blocks until the next record of the shard is available and returns it along with the Ack checkpointing it.
+// It returns ErrShardEnd once the shard is finished, ErrShardConsumerShutdown once it isn't consumed anymore, or the
+// error of the context.
+func (c *ShardConsumer) Next(ctx context.Context) (*kinesis.Record, Ack, error) {
+	select {
+	case p := <-c.records:
+		return p.record, func() error { return c.ack(p) }, nil
+	case <-c.done:
+		return nil, nil, c.err
+	case <-ctx.Done():
+		return nil, nil, ctx.Err()
+	}
+}
+
+func (c *ShardConsumer) Initialize(input *shard.InitializationInput) {
+	c.shardID = input.ShardId
+}
+
+func (c *ShardConsumer) ProcessRecords(input *ProcessRecordsInput) {
+	c.mux.Lock()
+	if c.err != nil {
+		c.mux.Unlock()
+		return
+	}
+	records := make([]*pulledRecord, 0, len(input.Records))
+	for _, r := range input.Records {
+		records = append(records, &pulledRecord{
+			record:            r,
+			subSequenceNumber: input.SubSequenceNumbers[r],
+			checkpointer:      input.Checkpointer,
+		})
+	}
+	c.pending = append(c.pending, records...)
+	c.mux.Unlock()
+
+	// the shard consumer renews the lease and shuts down in between the batches, it can't wait for a full buffer
+	// once the shard is going away
+	for i, r := range records {
+		select {
+		case c.records <- r:
+		case <-c.done:
+			return
+		case <-contextDone(input.Context):
+			c.dropPending(records[i:])
+			return
+		}
+	}
+}
+
+// dropPending removes the record which won't be delivered from the pending ones.
+func (c *ShardConsumer) dropPending(records []*pulledRecord) {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	dropped := make(map[*pulledRecord]bool, len(records))
+	for _, r := range records {
+		dropped[r] = true
+	}
+	kept := c.pending[:0]
+	for _, p := range c.pending {
+		if !dropped[p] {
+			kept = append(kept, p)
+		}
+	}
+	c.pending = kept
+	c.ackedCond.Broadcast()
+}
+
+// contextDone returns the done channel of the context, nil if there is no context.
+func contextDone(ctx context.Context) <-chan struct{} {
+	if ctx == nil {
+		return nil
+	}
+	return ctx.Done()
+}
+
+func (c *ShardConsumer) Shutdown(input *util.ShutdownInput) {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+
+	if input.ShutdownReason != util.TERMINATE {
+		// the lease is going away, record Ack'd from now on must not move the checkpoint
+		c.stop(ErrShardConsumerShutdown)
+		return
+	}
+
+	// The end of a closed shard can only be recorded once all its record have been Ack'd.
+	for len(c.pending) > 0 && c.err == nil {
+		c.ackedCond.Wait()
+	}
+	if c.err != nil {
+		return
+	}
+	if err := input.Checkpointer.Checkpoint(nil); err != nil {
+		log.Errorf("Failed to checkpoint end of shard: %s Error: %+v", c.shardID, err)
+	}
+	c.stop(ErrShardEnd)
+}
+
+// ack checkpoints the shard at the record, unless a record pulled after it was Ack'd already.
+func (c *ShardConsumer) ack(p *pulledRecord) error {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	defer c.ackedCond.Broadcast()
+
+	if c.err != nil {
+		return ErrShardConsumerShutdown
+	}
+
+	acked := -1
+	for i, pending := range c.pending {
+		if pending == p {
+			acked = i
+			break
+		}
+	}
+	if acked < 0 {
+		return nil
+	}
+	c.pending = c.pending[acked+1:]
+
+	// a user record deaggregated from a KPL record only covers the user record up to it
+	err := p.checkpointer.CheckpointSequenceWithSubSequence(aws.StringValue(p.record.SequenceNumber),
+		p.subSequenceNumber)
+	if err != nil {
+		log.Errorf("Failed to checkpoint shard: %s at %s Error: %+v", c.shardID, aws.StringValue(p.record.SequenceNumber),
+			err)
+		return err
+	}
+	return nil
+}
+
+// stop ends the consumption of the shard, Next returning err from now on. The caller holds mux.
+func (c *ShardConsumer) stop(err error) {
+	if c.err != nil {
+		return
+	}
+	c.err = err
+	close(c.done)
+	c.ackedCond.Broadcast()
+}
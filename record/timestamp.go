@@ -0,0 +1,31 @@
+package record
+
+import (
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/kinesis"
+	log "github.com/sirupsen/logrus"
+)
+
+// TimestampExtractor returns the event time of a record, e.g. a timestamp embedded in its payload, for the event time
+// features of the library (record age, event time watermark) which otherwise use the time the record arrived in
+// Kinesis. A non-nil error falls back to the arrival time.
+type TimestampExtractor func(r *kinesis.Record) (time.Time, error)
+
+// EventTime returns the event time of the record according to extractor, its ApproximateArrivalTimestamp if extractor
+// is nil or fails. ok is false if the record has no timestamp at all.
+func EventTime(r *kinesis.Record, extractor TimestampExtractor) (t time.Time, ok bool) {
+	if extractor != nil {
+		extracted, err := extractor(r)
+		if err == nil {
+			return extracted, true
+		}
+		log.Debugf("Unable to extract the timestamp of record %s, using its arrival time: %+v",
+			aws.StringValue(r.SequenceNumber), err)
+	}
+	if r.ApproximateArrivalTimestamp == nil {
+		return time.Time{}, false
+	}
+	return *r.ApproximateArrivalTimestamp, true
+}
@@ -0,0 +1,162 @@
+package record
+
+import (
+	"encoding/binary"
+	"io"
+	"sync"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/guygma/goKCL/shard"
+	"github.com/guygma/goKCL/util"
+)
+
+const (
+	// RAW writes the data of each record back to back without any delimiter.
+	RAW Framing = iota + 1
+
+	// NEWLINE_DELIMITED writes a '\n' after the data of each record.
+	NEWLINE_DELIMITED
+
+	// LENGTH_PREFIXED writes the length of each record as a 4 byte big endian integer before its data.
+	LENGTH_PREFIXED
+)
+
+// Framing determines how the record written to a sink are delimited from each other.
+type Framing int
+
+// flusher is implemented by buffered writers (e.g. bufio.Writer) which need to be flushed before the
+// data written to them can be considered durable.
+type flusher interface {
+	Flush() error
+}
+
+// WriterSinkFactory creates record processors which write the data of every record to a shared io.Writer.
+// It is intended for simple archival/ETL consumers. Writes from different shards are serialized.
+type WriterSinkFactory struct {
+	writer        io.Writer
+	framing       Framing
+	failurePolicy FailurePolicy
+	mux           *sync.Mutex
+}
+
+// WriterSink is an IRecordProcessor that writes record to an io.Writer and checkpoints after every batch
+// has been successfully written and flushed.
+type WriterSink struct {
+	shardID       string
+	writer        io.Writer
+	framing       Framing
+	failurePolicy FailurePolicy
+	mux           *sync.Mutex
+	// failure is the error the sink stopped with under the STOP policy, every later batch fails with it
+	failure error
+}
+
+// NewWriterSinkFactory creates a WriterSinkFactory writing to w using the given framing. Writer errors
+// follow the STOP failure policy unless configured otherwise with WithFailurePolicy.
+func NewWriterSinkFactory(w io.Writer, framing Framing) *WriterSinkFactory {
+	return &WriterSinkFactory{
+		writer:        w,
+		framing:       framing,
+		failurePolicy: STOP,
+		mux:           &sync.Mutex{},
+	}
+}
+
+// WithFailurePolicy configures how the sinks react to writer errors. Only STOP and SKIP are supported: a sink
+// doesn't hold any IDeadLetterHandler to hand the failed record to.
+func (f *WriterSinkFactory) WithFailurePolicy(policy FailurePolicy) *WriterSinkFactory {
+	if policy != STOP && policy != SKIP {
+		log.Panicf("STOP or SKIP expected for the failure policy of a writer sink, actual: %v", policy)
+	}
+	f.failurePolicy = policy
+	return f
+}
+
+func (f *WriterSinkFactory) CreateProcessor() IRecordProcessor {
+	return &WriterSink{
+		writer:        f.writer,
+		framing:       f.framing,
+		failurePolicy: f.failurePolicy,
+		mux:           f.mux,
+	}
+}
+
+func (ws *WriterSink) Initialize(input *shard.InitializationInput) {
+	ws.shardID = input.ShardId
+}
+
+// ProcessRecords writes the batch and checkpoints it. With the STOP policy, a writer error fails the batch and
+// every later one with ProcessRecordsInput.Fail, so that none of them is checkpointed. Configure the worker with the
+// STOP ProcessingFailurePolicy for the shard consumer to stop as well.
+func (ws *WriterSink) ProcessRecords(input *ProcessRecordsInput) {
+	if ws.failure != nil {
+		input.Fail(ws.failure)
+		return
+	}
+	if len(input.Records) == 0 {
+		return
+	}
+
+	if err := ws.write(input); err != nil {
+		if ws.failurePolicy == SKIP {
+			log.Errorf("Failed to write record of shard: %s to sink, skipping batch. Error: %+v", ws.shardID, err)
+		} else {
+			log.Errorf("Failed to write record of shard: %s to sink, stop processing. Error: %+v", ws.shardID, err)
+			ws.failure = util.KinesisClientLibNonRetryableException.MakeErr().
+				WithDetail("writing record of shard %s to sink", ws.shardID).WithCause(err)
+			input.Fail(ws.failure)
+			return
+		}
+	}
+
+	lastSequenceNumber := input.Records[len(input.Records)-1].SequenceNumber
+	if err := input.Checkpointer.Checkpoint(lastSequenceNumber); err != nil {
+		log.Errorf("Failed to checkpoint shard: %s Error: %+v", ws.shardID, err)
+	}
+}
+
+func (ws *WriterSink) Shutdown(input *util.ShutdownInput) {
+	// Every written batch has already been checkpointed. Only a closed shard needs its end to be recorded.
+	if input.ShutdownReason == util.TERMINATE && ws.failure == nil {
+		if err := input.Checkpointer.Checkpoint(nil); err != nil {
+			log.Errorf("Failed to checkpoint end of shard: %s Error: %+v", ws.shardID, err)
+		}
+	}
+}
+
+// write writes all record of the batch using the configured framing and flushes the writer.
+func (ws *WriterSink) write(input *ProcessRecordsInput) error {
+	ws.mux.Lock()
+	defer ws.mux.Unlock()
+
+	for _, r := range input.Records {
+		switch ws.framing {
+		case LENGTH_PREFIXED:
+			prefix := make([]byte, 4)
+			binary.BigEndian.PutUint32(prefix, uint32(len(r.Data)))
+			if _, err := ws.writer.Write(prefix); err != nil {
+				return err
+			}
+			if _, err := ws.writer.Write(r.Data); err != nil {
+				return err
+			}
+		case NEWLINE_DELIMITED:
+			if _, err := ws.writer.Write(r.Data); err != nil {
+				return err
+			}
+			if _, err := ws.writer.Write([]byte{'\n'}); err != nil {
+				return err
+			}
+		default:
+			if _, err := ws.writer.Write(r.Data); err != nil {
+				return err
+			}
+		}
+	}
+
+	if f, ok := ws.writer.(flusher); ok {
+		return f.Flush()
+	}
+	return nil
+}
@@ -0,0 +1,107 @@
+package goKCL
+
+import (
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/guygma/goKCL/shard"
+	"github.com/guygma/goKCL/util"
+)
+
+// replayPollInterval is how often ReplayShard checks whether the consumer of the shard stopped.
+const replayPollInterval = 50 * time.Millisecond
+
+// ReplayShard restarts the consumption of one shard at (or right after, if after is set) the given sequence number,
+// e.g. to reprocess it after a bug fix, leaving the leases of the other shards untouched. If the worker holds the
+// lease of the shard, its record processor is shut down with REQUESTED first. The checkpoint of the shard is then
+// overwritten with the sequence number, in a write conditional on the lease not changing meanwhile, and the lease is
+// released, so that it is taken again and the shard resumed from there by whichever worker takes it. A shard whose
+// lease is held by another live worker can't be replayed from this one: call it on the owner of the lease. It returns
+// an IllegalArgumentError if the sequence number is malformed or outside the shard, a LeasingError if the lease is
+// held by another worker or can't be updated, e.g. because it was taken or renewed during the replay, and a
+// KinesisClientLibNotImplemented error if the checkpointer can't overwrite checkpoints, see
+// shard.CheckpointOverrider. With multiple streams, call it on the worker of the stream, see GetStreamWorker.
+func (w *Worker) ReplayShard(shardID, sequenceNumber string, after bool) error {
+	if !IsValidSequenceNumber(sequenceNumber) {
+		return util.IllegalArgumentError.MakeErr().WithDetail("invalid sequence number %q", sequenceNumber)
+	}
+	overrider, ok := w.checkpointer.(shard.CheckpointOverrider)
+	if !ok {
+		return util.KinesisClientLibNotImplemented.MakeErr().WithDetail("checkpointer can't overwrite checkpoints")
+	}
+	sh, ok := w.lookupShard(shardID)
+	if !ok {
+		return util.IllegalArgumentError.MakeErr().WithDetail("unknown shard %s", shardID)
+	}
+	if err := sh.ValidateSequenceNumber(sequenceNumber); err != nil {
+		return err
+	}
+
+	// the next consumer of the shard started by this worker starts at the sequence number, even if it takes the
+	// lease again before the checkpoint is written
+	w.startingMux.Lock()
+	w.startingSequenceNumbers[shardID] = StartingSequenceNumber{SequenceNumber: sequenceNumber, After: after}
+	w.startingMux.Unlock()
+
+	// the record processor may checkpoint while it is shut down, it has to be done before the checkpoint is written
+	if sh.GetLeaseOwner() == w.workerID {
+		restarts := sh.GetConsumerRestarts()
+		sh.RequestLeaseRelease()
+		if !w.waitConsumerStopped(sh, restarts) {
+			w.takeStartingSequenceNumber(shardID)
+			return util.LeasingError.MakeErr().WithDetail("consumer of shard %s didn't release its lease", shardID)
+		}
+	}
+
+	// a checkpoint right before the record, i.e. at sub-sequence number -1, resumes the shard at the record
+	var subSequence int64
+	if !after {
+		subSequence = -1
+	}
+	replayed := &shard.Status{
+		ID:                          sh.ID,
+		Mux:                         &sync.Mutex{},
+		Checkpoint:                  sequenceNumber,
+		CheckpointSubSequenceNumber: subSequence,
+		AssignedTo:                  w.workerID,
+	}
+	if err := overrider.OverrideCheckpoint(replayed); err != nil {
+		// the shard isn't replayed, the next consumer started by this worker resumes at the checkpoint
+		w.takeStartingSequenceNumber(shardID)
+		if err.Error() == shard.ErrLeaseNotAquired {
+			return util.LeasingError.MakeErr().
+				WithDetail("lease of shard %s held or renewed by another worker", shardID).WithCause(err)
+		}
+		return util.LeasingError.MakeErr().WithDetail("unable to checkpoint shard %s", shardID).WithCause(err)
+	}
+	// unless this worker took the lease again meanwhile, its new consumer starting at the sequence number already
+	if replayed.AssignedTo != w.workerID {
+		if err := w.checkpointer.RemoveLeaseOwner(shardID); err != nil {
+			return util.LeasingError.MakeErr().
+				WithDetail("unable to release the lease of shard %s", shardID).WithCause(err)
+		}
+	}
+
+	sh.Mux.Lock()
+	sh.Checkpoint = sequenceNumber
+	sh.CheckpointSubSequenceNumber = subSequence
+	sh.Mux.Unlock()
+	log.Infof("Shard %s replayed from sequence number %s (after: %v)", shardID, sequenceNumber, after)
+	return nil
+}
+
+// waitConsumerStopped waits for the consumer of the shard to exit for at most the failover time, and returns whether
+// it did. The lease may be taken again and a new consumer started before the exit is noticed, which shows as one
+// more restart than the given ones.
+func (w *Worker) waitConsumerStopped(sh *shard.Status, restarts int) bool {
+	deadline := time.Now().Add(time.Duration(w.kclConfig.FailoverTimeMillis) * time.Millisecond)
+	for time.Now().Before(deadline) {
+		if sh.GetConsumerUptime(time.Now()) == 0 || sh.GetConsumerRestarts() != restarts {
+			return true
+		}
+		time.Sleep(replayPollInterval)
+	}
+	return false
+}
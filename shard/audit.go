@@ -0,0 +1,158 @@
+package shard
+
+import (
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/guygma/goKCL/util"
+)
+
+// auditBufferSize is the number of audit entries buffered before new ones are dropped.
+const auditBufferSize = 1000
+
+// AuditingCheckpointer wraps a Checkpointer and reports every successful lease and checkpoint mutation to an
+// AuditHook. Reporting never blocks the mutations: entries are buffered and dropped (with an error log) if the
+// hook can't keep up. Close stops the reporting once the buffered entries are reported.
+type AuditingCheckpointer struct {
+	Checkpointer
+	actor   string
+	policy  util.AuditValuePolicy
+	entries chan *util.AuditEntry
+	// closed once the buffered entries are reported after Close
+	done chan struct{}
+	// closed stops the reporting, the entries of later mutations are dropped
+	closed    bool
+	closedMux sync.RWMutex
+
+	// last known checkpoint per shard, to report the old value of checkpoint writes
+	checkpoints map[string]string
+	mux         sync.Mutex
+}
+
+// NewAuditingCheckpointer wraps checkpointer, reporting mutations done by actor (the worker ID) to hook.
+func NewAuditingCheckpointer(checkpointer Checkpointer, actor string, hook util.AuditHook,
+	policy util.AuditValuePolicy) *AuditingCheckpointer {
+	a := &AuditingCheckpointer{
+		Checkpointer: checkpointer,
+		actor:        actor,
+		policy:       policy,
+		entries:      make(chan *util.AuditEntry, auditBufferSize),
+		done:         make(chan struct{}),
+		checkpoints:  make(map[string]string),
+	}
+
+	go func() {
+		defer close(a.done)
+		for entry := range a.entries {
+			hook.Audit(entry)
+		}
+	}()
+	return a
+}
+
+// Close reports the buffered entries and stops the reporting, e.g. on worker shutdown. The mutations keep going
+// through, unaudited.
+func (a *AuditingCheckpointer) Close() {
+	a.closedMux.Lock()
+	if !a.closed {
+		a.closed = true
+		close(a.entries)
+	}
+	a.closedMux.Unlock()
+	<-a.done
+}
+
+// GetLease audits taking over a lease from another owner as LEASE_TAKEN and extending an owned lease as
+// LEASE_RENEWED.
+func (a *AuditingCheckpointer) GetLease(shard *Status, newAssignTo string) error {
+	shard.Mux.Lock()
+	oldOwner, oldTimeout := shard.AssignedTo, shard.LeaseTimeout
+	shard.Mux.Unlock()
+
+	if err := a.Checkpointer.GetLease(shard, newAssignTo); err != nil {
+		return err
+	}
+
+	shard.Mux.Lock()
+	newTimeout := shard.LeaseTimeout
+	shard.Mux.Unlock()
+
+	if oldOwner == newAssignTo {
+		a.audit(util.LEASE_RENEWED, shard.ID, oldTimeout.UTC().Format(time.RFC3339), newTimeout.UTC().Format(time.RFC3339))
+	} else {
+		a.audit(util.LEASE_TAKEN, shard.ID, oldOwner, newAssignTo)
+	}
+	return nil
+}
+
+// StealLease audits a lease stolen from a live owner as LEASE_TAKEN. It fails unless the wrapped checkpointer is a
+// LeaseStealer.
+func (a *AuditingCheckpointer) StealLease(shard *Status, owner, newAssignTo string) error {
+	stealer, ok := a.Checkpointer.(LeaseStealer)
+	if !ok {
+		return util.InvalidStateError.MakeErr().WithDetail("checkpointer can't steal the lease of shard %s", shard.ID)
+	}
+	if err := stealer.StealLease(shard, owner, newAssignTo); err != nil {
+		return err
+	}
+
+	a.audit(util.LEASE_TAKEN, shard.ID, owner, newAssignTo)
+	return nil
+}
+
+func (a *AuditingCheckpointer) CheckpointSequence(shard *Status) error {
+	if err := a.Checkpointer.CheckpointSequence(shard); err != nil {
+		return err
+	}
+
+	a.mux.Lock()
+	old := a.checkpoints[shard.ID]
+	a.checkpoints[shard.ID] = shard.Checkpoint
+	a.mux.Unlock()
+
+	a.audit(util.CHECKPOINT_WRITTEN, shard.ID, old, shard.Checkpoint)
+	return nil
+}
+
+func (a *AuditingCheckpointer) FetchCheckpoint(shard *Status) error {
+	err := a.Checkpointer.FetchCheckpoint(shard)
+	if err == nil {
+		a.mux.Lock()
+		a.checkpoints[shard.ID] = shard.Checkpoint
+		a.mux.Unlock()
+	}
+	return err
+}
+
+func (a *AuditingCheckpointer) RemoveLeaseOwner(shardID string) error {
+	if err := a.Checkpointer.RemoveLeaseOwner(shardID); err != nil {
+		return err
+	}
+	a.audit(util.LEASE_RELEASED, shardID, a.actor, "")
+	return nil
+}
+
+func (a *AuditingCheckpointer) audit(auditType util.AuditType, shardID, oldValue, newValue string) {
+	entry := &util.AuditEntry{
+		Type:      auditType,
+		ShardID:   shardID,
+		Actor:     a.policy.ApplyValuePolicy(a.actor),
+		OldValue:  a.policy.ApplyValuePolicy(oldValue),
+		NewValue:  a.policy.ApplyValuePolicy(newValue),
+		Timestamp: time.Now(),
+	}
+
+	a.closedMux.RLock()
+	defer a.closedMux.RUnlock()
+	if a.closed {
+		log.Warnf("Auditing is closed, dropping %v audit entry of shard %s", auditType, shardID)
+		return
+	}
+	select {
+	case a.entries <- entry:
+	default:
+		log.Errorf("Audit buffer is full, dropping %v audit entry of shard %s", auditType, shardID)
+	}
+}
@@ -0,0 +1,87 @@
+package shard
+
+import (
+	"time"
+
+	"github.com/aws/aws-sdk-go/service/kinesis"
+
+	"github.com/guygma/goKCL"
+	"github.com/guygma/goKCL/util"
+)
+
+// autoCheckpointer decides when the shard consumer checkpoints on behalf of the record processor: every
+// recordCount successfully processed record, independent of the batch boundaries, or once interval has passed
+// since the last checkpoint, whichever triggers first. A zero recordCount or interval disables that trigger.
+type autoCheckpointer struct {
+	recordCount    int
+	interval       time.Duration
+	processed      int
+	lastCheckpoint time.Time
+	// a batch failed since the last checkpoint of the record processor
+	suspended bool
+}
+
+func newAutoCheckpointer(kclConfig *goKCL.KinesisClientLibConfiguration) *autoCheckpointer {
+	a := &autoCheckpointer{
+		recordCount:    kclConfig.AutoCheckpointRecordCount,
+		interval:       time.Duration(kclConfig.AutoCheckpointIntervalMillis) * time.Millisecond,
+		lastCheckpoint: time.Now(),
+	}
+	if config := kclConfig.CheckpointConfig; config != (goKCL.CheckpointConfig{}) {
+		a.recordCount = config.RecordCount
+		a.interval = config.Interval
+	}
+	return a
+}
+
+func (a *autoCheckpointer) enabled() bool {
+	return a.recordCount > 0 || a.interval > 0
+}
+
+// checkpointed is called when the record processor checkpointed by itself, which restarts the count and the
+// interval, and resumes the automatic checkpoints after a failed batch.
+func (a *autoCheckpointer) checkpointed(now time.Time) {
+	a.processed = 0
+	a.lastCheckpoint = now
+	a.suspended = false
+}
+
+// failed is called with every batch the record processor failed. No checkpoint is made on its behalf until it
+// checkpoints again, since it would mark the failed batch as processed.
+func (a *autoCheckpointer) failed() {
+	a.processed = 0
+	a.suspended = true
+}
+
+// checkpointAt is called with every successfully processed batch and returns the record to checkpoint at,
+// or nil if no checkpoint is due.
+func (a *autoCheckpointer) checkpointAt(records []*kinesis.Record, now time.Time) *kinesis.Record {
+	if a.suspended {
+		return nil
+	}
+
+	var at *kinesis.Record
+	for _, r := range records {
+		a.processed++
+		if a.recordCount > 0 && a.processed >= a.recordCount {
+			at = r
+			a.processed = 0
+		}
+	}
+
+	if a.interval > 0 && a.processed > 0 && now.Sub(a.lastCheckpoint) >= a.interval {
+		at = records[len(records)-1]
+		a.processed = 0
+	}
+
+	if at != nil {
+		a.lastCheckpoint = now
+	}
+	return at
+}
+
+// checkpointOnShutdown returns true if the last processed record is checkpointed before the record processor is shut
+// down for the given reason. A ZOMBIE lost its lease, it never checkpoints.
+func checkpointOnShutdown(kclConfig *goKCL.KinesisClientLibConfiguration, reason util.ShutdownReason) bool {
+	return reason != util.ZOMBIE && kclConfig.CheckpointOnShutdown[reason]
+}
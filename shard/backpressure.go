@@ -0,0 +1,47 @@
+package shard
+
+import (
+	"time"
+
+	"github.com/guygma/goKCL"
+)
+
+// backpressureDetector tracks how full the record buffer returned by each GetRecords call is, relative to
+// MaxRecords. A full buffer means the shard holds more data than the consumer keeps up with. Once the fill level
+// stays at or above the threshold for the whole window the shard is flagged as backpressured, so an upstream
+// orchestrator can throttle producers or scale consumers. The flag clears as soon as the fill level drops.
+type backpressureDetector struct {
+	threshold      int
+	window         time.Duration
+	saturatedSince time.Time
+	active         bool
+}
+
+func newBackpressureDetector(kclConfig *goKCL.KinesisClientLibConfiguration) *backpressureDetector {
+	return &backpressureDetector{
+		threshold: kclConfig.BackpressureThresholdPercent,
+		window:    time.Duration(kclConfig.BackpressureWindowMillis) * time.Millisecond,
+	}
+}
+
+// observe records the fill level of a fetched buffer and returns true if the backpressure state changed.
+func (b *backpressureDetector) observe(used, capacity int, now time.Time) bool {
+	if capacity <= 0 || used*100 < capacity*b.threshold {
+		b.saturatedSince = time.Time{}
+		if b.active {
+			b.active = false
+			return true
+		}
+		return false
+	}
+
+	if b.saturatedSince.IsZero() {
+		b.saturatedSince = now
+	}
+
+	if !b.active && now.Sub(b.saturatedSince) >= b.window {
+		b.active = true
+		return true
+	}
+	return false
+}
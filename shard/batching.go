@@ -0,0 +1,65 @@
+package shard
+
+import (
+	"time"
+
+	"github.com/aws/aws-sdk-go/service/kinesis"
+)
+
+// batchingWindow accumulates the record of several GetRecords calls into a single batch for the record processor.
+// The batch is delivered once it holds at least maxRecords record, or maxWait after its first record was fetched.
+// A zero maxRecords and maxWait disables the window, every fetch is delivered as is.
+type batchingWindow struct {
+	maxRecords int
+	maxWait    time.Duration
+
+	records []*kinesis.Record
+	// fetch time of the first record of the batch
+	started time.Time
+}
+
+func (w *batchingWindow) enabled() bool {
+	return w.maxRecords > 0 || w.maxWait > 0
+}
+
+// add appends fetched record to the batch, in shard order.
+func (w *batchingWindow) add(records []*kinesis.Record, now time.Time) {
+	if len(records) == 0 {
+		return
+	}
+	if len(w.records) == 0 {
+		w.started = now
+	}
+	w.records = append(w.records, records...)
+}
+
+// ready returns true if the batch is to be delivered.
+func (w *batchingWindow) ready(now time.Time) bool {
+	if len(w.records) == 0 {
+		return false
+	}
+	if w.maxRecords > 0 && len(w.records) >= w.maxRecords {
+		return true
+	}
+	return w.maxWait > 0 && now.Sub(w.started) >= w.maxWait
+}
+
+// take returns the batch and starts a new one.
+func (w *batchingWindow) take() []*kinesis.Record {
+	records := w.records
+	w.records = nil
+	return records
+}
+
+// last returns the last record of the pending batch, nil if there is none.
+func (w *batchingWindow) last() *kinesis.Record {
+	if len(w.records) == 0 {
+		return nil
+	}
+	return w.records[len(w.records)-1]
+}
+
+// reset drops the batch, e.g. when its record are about to be fetched again.
+func (w *batchingWindow) reset() {
+	w.records = nil
+}
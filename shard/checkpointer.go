@@ -1,9 +1,15 @@
 package shard
 
 import (
+	"context"
 	"errors"
+	"fmt"
 	"github.com/guygma/goKCL"
 	"log"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
@@ -14,6 +20,8 @@ import (
 	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
 
 	"github.com/sirupsen/logrus"
+
+	"github.com/guygma/goKCL/util"
 )
 
 const (
@@ -22,6 +30,24 @@ const (
 	LEASE_TIMEOUT_KEY              = "LeaseTimeout"
 	CHECKPOINT_SEQUENCE_NUMBER_KEY = "Checkpoint"
 	PARENT_SHARD_ID_KEY            = "ParentShardId"
+	MILLIS_BEHIND_LATEST_KEY       = "MillisBehindLatest"
+	OWNER_AVAILABILITY_ZONE_KEY    = "OwnerAvailabilityZone"
+	OWNER_SWITCHES_KEY             = "OwnerSwitchesSinceCheckpoint"
+	CHECKPOINT_SUBSEQUENCE_KEY     = "CheckpointSubSequenceNumber"
+
+	// The lease release signal of cooperative shutdown is a dedicated item of the lease table.
+	LEASE_RELEASE_SIGNAL_ID = "LeaseReleaseSignal"
+	RELEASED_BY_KEY         = "ReleasedBy"
+	RELEASED_SHARDS_KEY     = "ReleasedShards"
+	RELEASED_AT_KEY         = "ReleasedAt"
+
+	// APPLICATION_LEASE_KEY_SEPARATOR separates the application name from the rest of the lease key in a shared
+	// lease table, see goKCL.KinesisClientLibConfiguration.SharedLeaseTable
+	APPLICATION_LEASE_KEY_SEPARATOR = "#"
+
+	// ACCESS_PROBE_SHARD_ID is the lease key of the item which ValidateAccess reads and conditionally deletes, it
+	// never exists
+	ACCESS_PROBE_SHARD_ID = "AccessProbe"
 
 	// We've completely processed all record in this shard.
 	SHARD_END = "SHARD_END"
@@ -39,6 +65,7 @@ const (
 // DynamoCheckpoint implements the Checkpoint interface using DynamoDB as a backend
 type DynamoCheckpoint struct {
 	TableName               string
+	leaseTableBillingMode   string
 	leaseTableReadCapacity  int64
 	leaseTableWriteCapacity int64
 
@@ -47,6 +74,34 @@ type DynamoCheckpoint struct {
 	kclConfig      *goKCL.KinesisClientLibConfiguration
 	Retries        int
 	skipTableCheck bool
+
+	readConsistency goKCL.LeaseReadConsistency
+	// names of the lease item attributes
+	attributes goKCL.LeaseAttributeNames
+
+	// last lag snapshot per shard, kept in the lease items rewritten by GetLease and CheckpointSequence
+	lags sync.Map
+
+	// namespaces the lease keys of a stream in a lease table shared by several streams, empty otherwise
+	leaseKeyPrefix string
+
+	// serializes the recreation of a deleted lease table
+	recreateMux sync.Mutex
+
+	// the retries of the lease table lookup draw from it, nil if unlimited
+	retryBudget *util.RetryBudget
+}
+
+// DefaultLeaseAttributeNames returns the default names of the lease item attributes.
+func DefaultLeaseAttributeNames() goKCL.LeaseAttributeNames {
+	return goKCL.LeaseAttributeNames{
+		LeaseKey:                     LEASE_KEY_KEY,
+		LeaseOwner:                   LEASE_OWNER_KEY,
+		LeaseTimeout:                 LEASE_TIMEOUT_KEY,
+		Checkpoint:                   CHECKPOINT_SEQUENCE_NUMBER_KEY,
+		ParentShardId:                PARENT_SHARD_ID_KEY,
+		OwnerSwitchesSinceCheckpoint: OWNER_SWITCHES_KEY,
+	}
 }
 
 func NewDynamoCheckpoint(kclConfig *goKCL.KinesisClientLibConfiguration) *DynamoCheckpoint {
@@ -57,11 +112,52 @@ func NewDynamoCheckpoint(kclConfig *goKCL.KinesisClientLibConfiguration) *Dynamo
 		LeaseDuration:           kclConfig.FailoverTimeMillis,
 		kclConfig:               kclConfig,
 		Retries:                 NumMaxRetries,
+		readConsistency:         kclConfig.LeaseReadConsistency,
+		attributes:              kclConfig.LeaseAttributeNames,
+	}
+	if checkpointer.attributes == (goKCL.LeaseAttributeNames{}) {
+		checkpointer.attributes = DefaultLeaseAttributeNames()
+	}
+
+	tableConfig := kclConfig.LeaseTableConfig
+	checkpointer.leaseTableBillingMode = tableConfig.BillingMode
+	if tableConfig.ReadCapacityUnits > 0 {
+		checkpointer.leaseTableReadCapacity = tableConfig.ReadCapacityUnits
+	}
+	if tableConfig.WriteCapacityUnits > 0 {
+		checkpointer.leaseTableWriteCapacity = tableConfig.WriteCapacityUnits
+	}
+	if kclConfig.SharedLeaseTable {
+		checkpointer.leaseKeyPrefix = kclConfig.ApplicationName + APPLICATION_LEASE_KEY_SEPARATOR
 	}
 
 	return checkpointer
 }
 
+// WithLeaseKeyPrefix returns a checkpointer of the same lease table whose lease keys are prefixed with prefix, to keep
+// the leases of several streams in one lease table. The prefix follows the one of the application in a shared lease
+// table.
+func (checkpointer *DynamoCheckpoint) WithLeaseKeyPrefix(prefix string) Checkpointer {
+	namespaced := NewDynamoCheckpoint(checkpointer.kclConfig)
+	namespaced.TableName = checkpointer.TableName
+	namespaced.LeaseDuration = checkpointer.LeaseDuration
+	namespaced.Retries = checkpointer.Retries
+	namespaced.svc = checkpointer.svc
+	namespaced.skipTableCheck = checkpointer.skipTableCheck
+	namespaced.leaseKeyPrefix = checkpointer.leaseKeyPrefix + prefix
+	return namespaced
+}
+
+// leaseKey returns the key of the lease item of the shard.
+func (checkpointer *DynamoCheckpoint) leaseKey(shardID string) string {
+	return checkpointer.leaseKeyPrefix + shardID
+}
+
+// SetRetryBudget makes the retries of the lease table lookup draw from the budget
+func (checkpointer *DynamoCheckpoint) SetRetryBudget(budget *util.RetryBudget) {
+	checkpointer.retryBudget = budget
+}
+
 // WithDynamoDB is used to provide DynamoDB service
 func (checkpointer *DynamoCheckpoint) WithDynamoDB(svc dynamodbiface.DynamoDBAPI) *DynamoCheckpoint {
 	checkpointer.svc = svc
@@ -70,6 +166,23 @@ func (checkpointer *DynamoCheckpoint) WithDynamoDB(svc dynamodbiface.DynamoDBAPI
 
 // Init initialises the DynamoDB Checkpoint
 func (checkpointer *DynamoCheckpoint) Init() error {
+	checkpointer.connect()
+
+	if checkpointer.skipTableCheck {
+		return nil
+	}
+	exists, err := checkpointer.lookupTable()
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return checkpointer.createTable()
+	}
+	return nil
+}
+
+// connect creates the DynamoDB client, unless one was provided with WithDynamoDB.
+func (checkpointer *DynamoCheckpoint) connect() {
 	logrus.Info("Creating DynamoDB session")
 
 	s, err := session.NewSession(&aws.Config{
@@ -77,6 +190,8 @@ func (checkpointer *DynamoCheckpoint) Init() error {
 		Endpoint:    aws.String(checkpointer.kclConfig.DynamoDBEndpoint),
 		Credentials: checkpointer.kclConfig.DynamoDBCredentials,
 		Retryer:     client.DefaultRetryer{NumMaxRetries: checkpointer.Retries},
+		HTTPClient: util.NewHTTPClient(time.Duration(checkpointer.kclConfig.DynamoDBRequestTimeoutMillis)*time.Millisecond,
+			checkpointer.kclConfig.DynamoDBMaxConnections),
 	})
 
 	if err != nil {
@@ -87,15 +202,83 @@ func (checkpointer *DynamoCheckpoint) Init() error {
 	if checkpointer.svc == nil {
 		checkpointer.svc = dynamodb.New(s)
 	}
+}
 
-	if !checkpointer.skipTableCheck && !checkpointer.doesTableExist() {
-		return checkpointer.createTable()
+// ValidateAccess probes the lease table without changing it: it is described, an item is read, and a conditional
+// delete of an item which doesn't exist checks the writes. A lease table which doesn't exist yet is created by Init,
+// which can't be probed without creating it.
+func (checkpointer *DynamoCheckpoint) ValidateAccess() error {
+	checkpointer.connect()
+
+	_, err := checkpointer.svc.DescribeTable(&dynamodb.DescribeTableInput{
+		TableName: aws.String(checkpointer.TableName),
+	})
+	if awsErr, ok := err.(awserr.Error); ok && awsErr.Code() == dynamodb.ErrCodeResourceNotFoundException {
+		logrus.Warnf("Lease table %s doesn't exist, it will be created on start", checkpointer.TableName)
+		return nil
+	}
+	if err != nil {
+		return util.KinesisClientLibDependencyError.MakeErr().
+			WithDetail("unable to describe lease table %s", checkpointer.TableName).WithCause(err)
+	}
+
+	key := map[string]*dynamodb.AttributeValue{
+		checkpointer.attributes.LeaseKey: {S: aws.String(checkpointer.leaseKey(ACCESS_PROBE_SHARD_ID))},
+	}
+	_, err = checkpointer.svc.GetItem(&dynamodb.GetItemInput{TableName: aws.String(checkpointer.TableName), Key: key})
+	if err != nil {
+		return util.KinesisClientLibDependencyError.MakeErr().
+			WithDetail("unable to read lease table %s", checkpointer.TableName).WithCause(err)
+	}
+
+	_, err = checkpointer.svc.DeleteItem(&dynamodb.DeleteItemInput{
+		TableName:                aws.String(checkpointer.TableName),
+		Key:                      key,
+		ConditionExpression:      aws.String("attribute_exists(#key)"),
+		ExpressionAttributeNames: map[string]*string{"#key": aws.String(checkpointer.attributes.LeaseKey)},
+	})
+	if awsErr, ok := err.(awserr.Error); !ok || awsErr.Code() != dynamodb.ErrCodeConditionalCheckFailedException {
+		return util.KinesisClientLibDependencyError.MakeErr().
+			WithDetail("unable to write lease table %s", checkpointer.TableName).WithCause(err)
 	}
 	return nil
 }
 
+// lookupTable looks the lease table up, retrying with jittered exponential backoff while it is throttled, since all
+// the workers of the application look it up as they start together.
+func (checkpointer *DynamoCheckpoint) lookupTable() (bool, error) {
+	backoff := checkpointer.kclConfig.RetryBackoff
+	backoff.Attempts = checkpointer.kclConfig.LeaseTableStartupRetries + 1
+	backoff.Budget = checkpointer.retryBudget
+	err := backoff.Retry(context.Background(), "Lookup of lease table "+checkpointer.TableName, isThrottlingError,
+		func() error {
+			_, err := checkpointer.svc.DescribeTable(&dynamodb.DescribeTableInput{
+				TableName: aws.String(checkpointer.TableName),
+			})
+			return err
+		})
+	if errors.Is(err, util.ThrottlingError.MakeErr()) {
+		logrus.Errorf("Lookup of lease table %s is still throttled: %v", checkpointer.TableName, err)
+		return false, util.LeasingProvisionedThroughputError.MakeErr().
+			WithDetail("lookup of lease table %s throttled", checkpointer.TableName).WithCause(err)
+	}
+	return err == nil, nil
+}
+
 // GetLease attempts to gain a lock on the given shard
 func (checkpointer *DynamoCheckpoint) GetLease(shard *Status, newAssignTo string) error {
+	return checkpointer.takeLease(shard, newAssignTo, "")
+}
+
+// StealLease takes the lease of the shard from owner, even if it didn't expire yet. It fails with ErrLeaseNotAquired
+// if the lease isn't held by owner anymore.
+func (checkpointer *DynamoCheckpoint) StealLease(shard *Status, owner, newAssignTo string) error {
+	return checkpointer.takeLease(shard, newAssignTo, owner)
+}
+
+// takeLease gains the lease of the shard for newAssignTo. The lease of another owner is only taken once expired,
+// unless it is held by stealFrom.
+func (checkpointer *DynamoCheckpoint) takeLease(shard *Status, newAssignTo, stealFrom string) error {
 	newLeaseTimeout := time.Now().Add(time.Duration(checkpointer.LeaseDuration) * time.Millisecond).UTC()
 	newLeaseTimeoutString := newLeaseTimeout.Format(time.RFC3339)
 	currentCheckpoint, err := checkpointer.getItem(shard.ID)
@@ -103,13 +286,26 @@ func (checkpointer *DynamoCheckpoint) GetLease(shard *Status, newAssignTo string
 		return err
 	}
 
-	assignedVar, assignedToOk := currentCheckpoint[LEASE_OWNER_KEY]
-	leaseVar, leaseTimeoutOk := currentCheckpoint[LEASE_TIMEOUT_KEY]
+	attributes := checkpointer.attributes
+	assignedVar, assignedToOk := currentCheckpoint[attributes.LeaseOwner]
+	leaseVar, leaseTimeoutOk := currentCheckpoint[attributes.LeaseTimeout]
 	var conditionalExpression string
+	var expressionAttributeNames map[string]*string
 	var expressionAttributeValues map[string]*dynamodb.AttributeValue
 
+	// an existing lease changing owner, including a released lease being acquired again
+	switches := checkpointer.ownerSwitches(currentCheckpoint)
+	switched := len(currentCheckpoint) > 0 && (!assignedToOk || aws.StringValue(assignedVar.S) != newAssignTo)
+
+	if stealFrom != "" && (!assignedToOk || aws.StringValue(assignedVar.S) != stealFrom) {
+		return errors.New(ErrLeaseNotAquired)
+	}
+
 	if !leaseTimeoutOk || !assignedToOk {
-		conditionalExpression = "attribute_not_exists(AssignedTo)"
+		conditionalExpression = "attribute_not_exists(#assigned_to)"
+		expressionAttributeNames = map[string]*string{
+			"#assigned_to": aws.String(attributes.LeaseOwner),
+		}
 	} else {
 		assignedTo := *assignedVar.S
 		leaseTimeout := *leaseVar.S
@@ -119,15 +315,27 @@ func (checkpointer *DynamoCheckpoint) GetLease(shard *Status, newAssignTo string
 			return err
 		}
 
-		if !time.Now().UTC().After(currentLeaseTimeout) && assignedTo != newAssignTo {
+		// the lease of another worker is only taken over once the grace period beyond its expiry passed too
+		grace := time.Duration(checkpointer.kclConfig.LeaseTakeoverGraceMillis) * time.Millisecond
+		if threshold := checkpointer.kclConfig.PoisonShardOwnerSwitches; threshold > 0 && switches >= threshold &&
+			checkpointer.kclConfig.PoisonShardPolicy == goKCL.DELAY_POISON_SHARD_TAKEOVER {
+			grace += time.Duration(switches-threshold+1) * time.Duration(checkpointer.LeaseDuration) * time.Millisecond
+		}
+		if !time.Now().UTC().After(currentLeaseTimeout.Add(grace)) && assignedTo != newAssignTo &&
+			assignedTo != stealFrom {
 			return errors.New(ErrLeaseNotAquired)
 		}
 
 		logrus.Debugf("Attempting to get a lock for shard: %s, leaseTimeout: %s, assignedTo: %s", shard.ID, currentLeaseTimeout, assignedTo)
-		conditionalExpression = "ShardID = :id AND AssignedTo = :assigned_to AND LeaseTimeout = :lease_timeout"
+		conditionalExpression = "#id = :id AND #assigned_to = :assigned_to AND #lease_timeout = :lease_timeout"
+		expressionAttributeNames = map[string]*string{
+			"#id":            aws.String(attributes.LeaseKey),
+			"#assigned_to":   aws.String(attributes.LeaseOwner),
+			"#lease_timeout": aws.String(attributes.LeaseTimeout),
+		}
 		expressionAttributeValues = map[string]*dynamodb.AttributeValue{
 			":id": {
-				S: aws.String(shard.ID),
+				S: aws.String(checkpointer.leaseKey(shard.ID)),
 			},
 			":assigned_to": {
 				S: aws.String(assignedTo),
@@ -139,28 +347,40 @@ func (checkpointer *DynamoCheckpoint) GetLease(shard *Status, newAssignTo string
 	}
 
 	marshalledCheckpoint := map[string]*dynamodb.AttributeValue{
-		LEASE_KEY_KEY: {
-			S: aws.String(shard.ID),
+		attributes.LeaseKey: {
+			S: aws.String(checkpointer.leaseKey(shard.ID)),
 		},
-		LEASE_OWNER_KEY: {
+		attributes.LeaseOwner: {
 			S: aws.String(newAssignTo),
 		},
-		LEASE_TIMEOUT_KEY: {
+		attributes.LeaseTimeout: {
 			S: aws.String(newLeaseTimeoutString),
 		},
 	}
 
 	if len(shard.ParentShardId) > 0 {
-		marshalledCheckpoint[PARENT_SHARD_ID_KEY] = &dynamodb.AttributeValue{S: aws.String(shard.ParentShardId)}
+		marshalledCheckpoint[attributes.ParentShardId] = &dynamodb.AttributeValue{S: aws.String(shard.ParentShardId)}
 	}
 
 	if shard.Checkpoint != "" {
-		marshalledCheckpoint[CHECKPOINT_SEQUENCE_NUMBER_KEY] = &dynamodb.AttributeValue{
+		marshalledCheckpoint[attributes.Checkpoint] = &dynamodb.AttributeValue{
 			S: aws.String(shard.Checkpoint),
 		}
+		marshalSubSequenceNumber(shard, marshalledCheckpoint)
+	}
+	if switched {
+		switches++
+	}
+	if switches > 0 {
+		marshalledCheckpoint[attributes.OwnerSwitchesSinceCheckpoint] = &dynamodb.AttributeValue{
+			N: aws.String(strconv.Itoa(switches)),
+		}
 	}
+	checkpointer.keepLag(shard.ID, marshalledCheckpoint)
+	checkpointer.tagAvailabilityZone(marshalledCheckpoint)
 
-	err = checkpointer.conditionalUpdate(conditionalExpression, expressionAttributeValues, marshalledCheckpoint)
+	err = checkpointer.conditionalUpdate(conditionalExpression, expressionAttributeNames, expressionAttributeValues,
+		marshalledCheckpoint)
 	if err != nil {
 		if awsErr, ok := err.(awserr.Error); ok {
 			if awsErr.Code() == dynamodb.ErrCodeConditionalCheckFailedException {
@@ -171,36 +391,236 @@ func (checkpointer *DynamoCheckpoint) GetLease(shard *Status, newAssignTo string
 	}
 
 	shard.Mux.Lock()
-	shard.AssignedTo = newAssignTo
+	shard.changeLeaseOwner(newAssignTo, time.Now(), checkpointer.kclConfig.LeaseOwnershipHistoryLength)
 	shard.LeaseTimeout = newLeaseTimeout
 	shard.Mux.Unlock()
 
+	if threshold := checkpointer.kclConfig.PoisonShardOwnerSwitches; switched && threshold > 0 && switches >= threshold {
+		util.EmitEvent(checkpointer.kclConfig.EventListener, util.WARNING, util.EVENT_POISON_SHARD, shard.ID,
+			fmt.Sprintf("lease changed owner %d times since the last checkpoint, now owned by %s", switches,
+				newAssignTo))
+	}
+
 	return nil
 }
 
 // CheckpointSequence writes a checkpoint at the designated sequence ID
 func (checkpointer *DynamoCheckpoint) CheckpointSequence(shard *Status) error {
+	if checkpointer.renewalPending(shard, time.Now()) {
+		return checkpointer.renewingCheckpoint(shard)
+	}
+
 	leaseTimeout := shard.LeaseTimeout.UTC().Format(time.RFC3339)
+	attributes := checkpointer.attributes
 	marshalledCheckpoint := map[string]*dynamodb.AttributeValue{
-		LEASE_KEY_KEY: {
-			S: aws.String(shard.ID),
+		attributes.LeaseKey: {
+			S: aws.String(checkpointer.leaseKey(shard.ID)),
 		},
-		CHECKPOINT_SEQUENCE_NUMBER_KEY: {
+		attributes.Checkpoint: {
 			S: aws.String(shard.Checkpoint),
 		},
-		LEASE_OWNER_KEY: {
+		attributes.LeaseOwner: {
 			S: aws.String(shard.AssignedTo),
 		},
-		LEASE_TIMEOUT_KEY: {
+		attributes.LeaseTimeout: {
 			S: aws.String(leaseTimeout),
 		},
 	}
 
 	if len(shard.ParentShardId) > 0 {
-		marshalledCheckpoint[PARENT_SHARD_ID_KEY] = &dynamodb.AttributeValue{S: &shard.ParentShardId}
+		marshalledCheckpoint[attributes.ParentShardId] = &dynamodb.AttributeValue{S: &shard.ParentShardId}
+	}
+	marshalSubSequenceNumber(shard, marshalledCheckpoint)
+	checkpointer.keepLag(shard.ID, marshalledCheckpoint)
+	checkpointer.tagAvailabilityZone(marshalledCheckpoint)
+
+	output, err := checkpointer.svc.PutItem(&dynamodb.PutItemInput{
+		TableName:    aws.String(checkpointer.TableName),
+		Item:         marshalledCheckpoint,
+		ReturnValues: aws.String(dynamodb.ReturnValueAllOld),
+	})
+	if err != nil {
+		checkpointer.recreateDeletedTable(err)
+		return err
 	}
+	if output != nil {
+		checkpointer.detectWriteSkew(shard, output.Attributes)
+	}
+	return nil
+}
 
-	return checkpointer.saveItem(marshalledCheckpoint)
+// renewalPending returns true if CoalesceLeaseRenewals is set and the lease of the shard, held by the worker, is past
+// the middle of its duration: the checkpoint renews it too.
+func (checkpointer *DynamoCheckpoint) renewalPending(shard *Status, now time.Time) bool {
+	if !checkpointer.kclConfig.CoalesceLeaseRenewals {
+		return false
+	}
+	shard.Mux.Lock()
+	defer shard.Mux.Unlock()
+	half := time.Duration(checkpointer.LeaseDuration) * time.Millisecond / 2
+	return shard.AssignedTo == checkpointer.kclConfig.WorkerID && !shard.LeaseTimeout.IsZero() &&
+		now.After(shard.LeaseTimeout.Add(-half))
+}
+
+// renewingCheckpoint writes the checkpoint of the shard and renews its lease in a single conditional update. It fails
+// with ErrLeaseNotAquired if the lease changed owner or was renewed since, as a renewal would.
+func (checkpointer *DynamoCheckpoint) renewingCheckpoint(shard *Status) error {
+	newLeaseTimeout := time.Now().Add(time.Duration(checkpointer.LeaseDuration) * time.Millisecond).UTC()
+	shard.Mux.Lock()
+	owner, leaseTimeout := shard.AssignedTo, shard.LeaseTimeout.UTC().Format(time.RFC3339)
+	checkpoint, subSequence := shard.Checkpoint, shard.CheckpointSubSequenceNumber
+	shard.Mux.Unlock()
+
+	// the owner switches are reset by the checkpoint, as by the one replacing the whole lease
+	update := "set #checkpoint = :checkpoint, #lease_timeout = :new_lease_timeout remove #owner_switches"
+	names := map[string]*string{
+		"#assigned_to":    aws.String(checkpointer.attributes.LeaseOwner),
+		"#lease_timeout":  aws.String(checkpointer.attributes.LeaseTimeout),
+		"#checkpoint":     aws.String(checkpointer.attributes.Checkpoint),
+		"#owner_switches": aws.String(checkpointer.attributes.OwnerSwitchesSinceCheckpoint),
+		"#sub_sequence":   aws.String(CHECKPOINT_SUBSEQUENCE_KEY),
+	}
+	values := map[string]*dynamodb.AttributeValue{
+		":assigned_to":       {S: aws.String(owner)},
+		":lease_timeout":     {S: aws.String(leaseTimeout)},
+		":checkpoint":        {S: aws.String(checkpoint)},
+		":new_lease_timeout": {S: aws.String(newLeaseTimeout.Format(time.RFC3339))},
+	}
+	if subSequence != 0 {
+		update = "set #checkpoint = :checkpoint, #lease_timeout = :new_lease_timeout, #sub_sequence = :sub_sequence " +
+			"remove #owner_switches"
+		values[":sub_sequence"] = &dynamodb.AttributeValue{N: aws.String(strconv.FormatInt(subSequence, 10))}
+	} else {
+		update += ", #sub_sequence"
+	}
+
+	_, err := checkpointer.svc.UpdateItem(&dynamodb.UpdateItemInput{
+		TableName: aws.String(checkpointer.TableName),
+		Key: map[string]*dynamodb.AttributeValue{
+			checkpointer.attributes.LeaseKey: {
+				S: aws.String(checkpointer.leaseKey(shard.ID)),
+			},
+		},
+		ConditionExpression:       aws.String("#assigned_to = :assigned_to AND #lease_timeout = :lease_timeout"),
+		UpdateExpression:          aws.String(update),
+		ExpressionAttributeNames:  names,
+		ExpressionAttributeValues: values,
+	})
+	if err != nil {
+		if awsErr, ok := err.(awserr.Error); ok && awsErr.Code() == dynamodb.ErrCodeConditionalCheckFailedException {
+			return errors.New(ErrLeaseNotAquired)
+		}
+		checkpointer.recreateDeletedTable(err)
+		return err
+	}
+
+	shard.Mux.Lock()
+	shard.LeaseTimeout = newLeaseTimeout
+	shard.Mux.Unlock()
+	return nil
+}
+
+// OverrideCheckpoint writes the checkpoint of the shard in a conditional update of its lease item, which leaves the
+// owner and the lease timeout as read. A lease item is created if the shard has none. A live lease of a worker other
+// than the owner of the shard is left untouched: its owner would write its own checkpoint back on renewal.
+func (checkpointer *DynamoCheckpoint) OverrideCheckpoint(shard *Status) error {
+	item, err := checkpointer.readItem(shard.ID, true)
+	if err != nil {
+		return err
+	}
+
+	shard.Mux.Lock()
+	checkpoint, subSequence, requester := shard.Checkpoint, shard.CheckpointSubSequenceNumber, shard.AssignedTo
+	shard.Mux.Unlock()
+
+	if v, ok := item[checkpointer.attributes.LeaseOwner]; ok && aws.StringValue(v.S) != requester {
+		if timeout, ok := item[checkpointer.attributes.LeaseTimeout]; ok {
+			leaseTimeout, err := time.Parse(time.RFC3339, aws.StringValue(timeout.S))
+			if err != nil {
+				return err
+			}
+			grace := time.Duration(checkpointer.kclConfig.LeaseTakeoverGraceMillis) * time.Millisecond
+			if time.Now().Before(leaseTimeout.Add(grace)) {
+				return errors.New(ErrLeaseNotAquired)
+			}
+		}
+	}
+
+	update := "set #checkpoint = :checkpoint remove #owner_switches, #sub_sequence"
+	names := map[string]*string{
+		"#checkpoint":     aws.String(checkpointer.attributes.Checkpoint),
+		"#owner_switches": aws.String(checkpointer.attributes.OwnerSwitchesSinceCheckpoint),
+		"#sub_sequence":   aws.String(CHECKPOINT_SUBSEQUENCE_KEY),
+	}
+	values := map[string]*dynamodb.AttributeValue{
+		":checkpoint": {S: aws.String(checkpoint)},
+	}
+	if subSequence != 0 {
+		update = "set #checkpoint = :checkpoint, #sub_sequence = :sub_sequence remove #owner_switches"
+		values[":sub_sequence"] = &dynamodb.AttributeValue{N: aws.String(strconv.FormatInt(subSequence, 10))}
+	}
+
+	// the lease must be as read: taken over or renewed since, its owner may be processing from the old checkpoint
+	var owner string
+	var conditions []string
+	if len(item) == 0 {
+		conditions = append(conditions, "attribute_not_exists(#lease_key)")
+		names["#lease_key"] = aws.String(checkpointer.attributes.LeaseKey)
+	} else {
+		names["#assigned_to"] = aws.String(checkpointer.attributes.LeaseOwner)
+		names["#lease_timeout"] = aws.String(checkpointer.attributes.LeaseTimeout)
+		if v, ok := item[checkpointer.attributes.LeaseOwner]; ok {
+			owner = aws.StringValue(v.S)
+			conditions = append(conditions, "#assigned_to = :assigned_to")
+			values[":assigned_to"] = &dynamodb.AttributeValue{S: aws.String(owner)}
+		} else {
+			conditions = append(conditions, "attribute_not_exists(#assigned_to)")
+		}
+		if v, ok := item[checkpointer.attributes.LeaseTimeout]; ok {
+			conditions = append(conditions, "#lease_timeout = :lease_timeout")
+			values[":lease_timeout"] = &dynamodb.AttributeValue{S: v.S}
+		} else {
+			conditions = append(conditions, "attribute_not_exists(#lease_timeout)")
+		}
+	}
+
+	_, err = checkpointer.svc.UpdateItem(&dynamodb.UpdateItemInput{
+		TableName: aws.String(checkpointer.TableName),
+		Key: map[string]*dynamodb.AttributeValue{
+			checkpointer.attributes.LeaseKey: {
+				S: aws.String(checkpointer.leaseKey(shard.ID)),
+			},
+		},
+		ConditionExpression:       aws.String(strings.Join(conditions, " AND ")),
+		UpdateExpression:          aws.String(update),
+		ExpressionAttributeNames:  names,
+		ExpressionAttributeValues: values,
+	})
+	if err != nil {
+		if awsErr, ok := err.(awserr.Error); ok && awsErr.Code() == dynamodb.ErrCodeConditionalCheckFailedException {
+			return errors.New(ErrLeaseNotAquired)
+		}
+		checkpointer.recreateDeletedTable(err)
+		return err
+	}
+
+	shard.Mux.Lock()
+	shard.AssignedTo = owner
+	shard.Mux.Unlock()
+	return nil
+}
+
+// detectWriteSkew emits a critical event if the lease replaced by a checkpoint write was owned by another worker:
+// the lease was taken over while the writer was still processing the shard, and the writer took it back.
+func (checkpointer *DynamoCheckpoint) detectWriteSkew(shard *Status, old map[string]*dynamodb.AttributeValue) {
+	owner, ok := old[checkpointer.attributes.LeaseOwner]
+	if !ok || aws.StringValue(owner.S) == shard.AssignedTo {
+		return
+	}
+
+	util.EmitEvent(checkpointer.kclConfig.EventListener, util.CRITICAL, util.EVENT_CHECKPOINT_WRITE_SKEW, shard.ID,
+		fmt.Sprintf("checkpoint %s written by %s while the lease was owned by %s, %d owner switches since the last "+
+			"checkpoint", shard.Checkpoint, shard.AssignedTo, aws.StringValue(owner.S), checkpointer.ownerSwitches(old)))
 }
 
 // FetchCheckpoint retrieves the checkpoint for the given shard
@@ -210,7 +630,7 @@ func (checkpointer *DynamoCheckpoint) FetchCheckpoint(shard *Status) error {
 		return err
 	}
 
-	sequenceID, ok := checkpoint[CHECKPOINT_SEQUENCE_NUMBER_KEY]
+	sequenceID, ok := checkpoint[checkpointer.attributes.Checkpoint]
 	if !ok {
 		return ErrSequenceIDNotFound
 	}
@@ -218,15 +638,18 @@ func (checkpointer *DynamoCheckpoint) FetchCheckpoint(shard *Status) error {
 	shard.Mux.Lock()
 	defer shard.Mux.Unlock()
 	shard.Checkpoint = aws.StringValue(sequenceID.S)
+	shard.CheckpointSubSequenceNumber = subSequenceNumber(checkpoint)
 
-	if assignedTo, ok := checkpoint[LEASE_OWNER_KEY]; ok {
-		shard.AssignedTo = aws.StringValue(assignedTo.S)
+	if assignedTo, ok := checkpoint[checkpointer.attributes.LeaseOwner]; ok {
+		shard.changeLeaseOwner(aws.StringValue(assignedTo.S), time.Now(),
+			checkpointer.kclConfig.LeaseOwnershipHistoryLength)
 	}
 	return nil
 }
 
 // RemoveLeaseInfo to remove lease info for shard entry in dynamoDB because the shard no longer exists in Kinesis
 func (checkpointer *DynamoCheckpoint) RemoveLeaseInfo(shardID string) error {
+	checkpointer.lags.Delete(shardID)
 	err := checkpointer.removeItem(shardID)
 
 	if err != nil {
@@ -243,11 +666,14 @@ func (checkpointer *DynamoCheckpoint) RemoveLeaseOwner(shardID string) error {
 	input := &dynamodb.UpdateItemInput{
 		TableName: aws.String(checkpointer.TableName),
 		Key: map[string]*dynamodb.AttributeValue{
-			LEASE_KEY_KEY: {
-				S: aws.String(shardID),
+			checkpointer.attributes.LeaseKey: {
+				S: aws.String(checkpointer.leaseKey(shardID)),
 			},
 		},
-		UpdateExpression: aws.String("remove " + LEASE_OWNER_KEY),
+		UpdateExpression: aws.String("remove #assigned_to"),
+		ExpressionAttributeNames: map[string]*string{
+			"#assigned_to": aws.String(checkpointer.attributes.LeaseOwner),
+		},
 	}
 
 	_, err := checkpointer.svc.UpdateItem(input)
@@ -255,30 +681,263 @@ func (checkpointer *DynamoCheckpoint) RemoveLeaseOwner(shardID string) error {
 	return err
 }
 
+// SignalLeasesReleased records that the worker released the leases of the given shards while departing
+func (checkpointer *DynamoCheckpoint) SignalLeasesReleased(workerID string, shardIDs []string) error {
+	if len(shardIDs) == 0 {
+		return nil
+	}
+
+	return checkpointer.saveItem(map[string]*dynamodb.AttributeValue{
+		checkpointer.attributes.LeaseKey: {
+			S: aws.String(checkpointer.leaseKey(LEASE_RELEASE_SIGNAL_ID)),
+		},
+		RELEASED_BY_KEY: {
+			S: aws.String(workerID),
+		},
+		RELEASED_SHARDS_KEY: {
+			SS: aws.StringSlice(shardIDs),
+		},
+		RELEASED_AT_KEY: {
+			S: aws.String(time.Now().UTC().Format(time.RFC3339Nano)),
+		},
+	})
+}
+
+// FetchLeaseReleaseSignal retrieves the latest lease release signal, nil if there is none
+func (checkpointer *DynamoCheckpoint) FetchLeaseReleaseSignal() (*LeaseReleaseSignal, error) {
+	item, err := checkpointer.getItem(LEASE_RELEASE_SIGNAL_ID)
+	if err != nil {
+		return nil, err
+	}
+
+	releasedAt, ok := item[RELEASED_AT_KEY]
+	if !ok {
+		return nil, nil
+	}
+	timestamp, err := time.Parse(time.RFC3339Nano, aws.StringValue(releasedAt.S))
+	if err != nil {
+		return nil, err
+	}
+
+	signal := &LeaseReleaseSignal{ReleasedAt: timestamp}
+	if releasedBy, ok := item[RELEASED_BY_KEY]; ok {
+		signal.WorkerID = aws.StringValue(releasedBy.S)
+	}
+	if shardIDs, ok := item[RELEASED_SHARDS_KEY]; ok {
+		signal.ShardIDs = aws.StringValueSlice(shardIDs.SS)
+	}
+	return signal, nil
+}
+
+// RecordLag writes the latest MillisBehindLatest of the shard into its lease, if the lease exists
+func (checkpointer *DynamoCheckpoint) RecordLag(shardID string, millisBehindLatest int64) error {
+	lag := strconv.FormatInt(millisBehindLatest, 10)
+	_, err := checkpointer.svc.UpdateItem(&dynamodb.UpdateItemInput{
+		TableName: aws.String(checkpointer.TableName),
+		Key: map[string]*dynamodb.AttributeValue{
+			checkpointer.attributes.LeaseKey: {
+				S: aws.String(checkpointer.leaseKey(shardID)),
+			},
+		},
+		ConditionExpression: aws.String("attribute_exists(#id)"),
+		UpdateExpression:    aws.String("set #lag = :lag"),
+		ExpressionAttributeNames: map[string]*string{
+			"#id":  aws.String(checkpointer.attributes.LeaseKey),
+			"#lag": aws.String(MILLIS_BEHIND_LATEST_KEY),
+		},
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":lag": {
+				N: aws.String(lag),
+			},
+		},
+	})
+	if err != nil {
+		if awsErr, ok := err.(awserr.Error); ok && awsErr.Code() == dynamodb.ErrCodeConditionalCheckFailedException {
+			// the shard no longer has a lease
+			return nil
+		}
+		return err
+	}
+
+	checkpointer.lags.Store(shardID, lag)
+	return nil
+}
+
+// GetLeases retrieves all the leases of the lease table
+func (checkpointer *DynamoCheckpoint) GetLeases() ([]*Lease, error) {
+	var leases []*Lease
+	attributes := checkpointer.attributes
+	input := &dynamodb.ScanInput{
+		TableName:      aws.String(checkpointer.TableName),
+		ConsistentRead: aws.Bool(checkpointer.readConsistency == goKCL.CONSISTENT_READS),
+	}
+	if checkpointer.leaseKeyPrefix != "" {
+		// the leases of the other streams or applications sharing the lease table aren't even read
+		input.FilterExpression = aws.String("begins_with(#id, :prefix)")
+		input.ExpressionAttributeNames = map[string]*string{"#id": aws.String(attributes.LeaseKey)}
+		input.ExpressionAttributeValues = map[string]*dynamodb.AttributeValue{
+			":prefix": {S: aws.String(checkpointer.leaseKeyPrefix)},
+		}
+	}
+	err := checkpointer.svc.ScanPages(input, func(page *dynamodb.ScanOutput, lastPage bool) bool {
+		for _, item := range page.Items {
+			key := aws.StringValue(item[attributes.LeaseKey].S)
+			if !strings.HasPrefix(key, checkpointer.leaseKeyPrefix) {
+				// a lease of another stream or application sharing the lease table
+				continue
+			}
+			shardID := strings.TrimPrefix(key, checkpointer.leaseKeyPrefix)
+			if shardID == LEASE_RELEASE_SIGNAL_ID {
+				continue
+			}
+			leases = append(leases, checkpointer.unmarshalLease(shardID, item))
+		}
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+	return leases, nil
+}
+
+// unmarshalLease returns the lease of a lease item.
+func (checkpointer *DynamoCheckpoint) unmarshalLease(shardID string, item map[string]*dynamodb.AttributeValue) *Lease {
+	attributes := checkpointer.attributes
+	lease := &Lease{ShardID: shardID, CheckpointSubSequenceNumber: subSequenceNumber(item)}
+	if v, ok := item[attributes.LeaseOwner]; ok {
+		lease.Owner = aws.StringValue(v.S)
+	}
+	if v, ok := item[attributes.LeaseTimeout]; ok {
+		lease.LeaseTimeout, _ = time.Parse(time.RFC3339, aws.StringValue(v.S))
+	}
+	if v, ok := item[attributes.Checkpoint]; ok {
+		lease.Checkpoint = aws.StringValue(v.S)
+	}
+	if v, ok := item[attributes.ParentShardId]; ok {
+		lease.ParentShardId = aws.StringValue(v.S)
+	}
+	if v, ok := item[OWNER_AVAILABILITY_ZONE_KEY]; ok {
+		lease.OwnerAvailabilityZone = aws.StringValue(v.S)
+	}
+	if v, ok := item[MILLIS_BEHIND_LATEST_KEY]; ok {
+		if lag, err := strconv.ParseInt(aws.StringValue(v.N), 10, 64); err == nil {
+			lease.MillisBehindLatest = aws.Int64(lag)
+		}
+	}
+	return lease
+}
+
+// marshalSubSequenceNumber adds the sub-sequence number of the checkpoint of the shard to a lease item, if any.
+func marshalSubSequenceNumber(shard *Status, item map[string]*dynamodb.AttributeValue) {
+	if shard.CheckpointSubSequenceNumber != 0 {
+		item[CHECKPOINT_SUBSEQUENCE_KEY] = &dynamodb.AttributeValue{
+			N: aws.String(strconv.FormatInt(shard.CheckpointSubSequenceNumber, 10)),
+		}
+	}
+}
+
+// subSequenceNumber returns the sub-sequence number of the checkpoint of a lease item, zero if the checkpoint covers
+// the whole record.
+func subSequenceNumber(item map[string]*dynamodb.AttributeValue) int64 {
+	v, ok := item[CHECKPOINT_SUBSEQUENCE_KEY]
+	if !ok {
+		return 0
+	}
+	subSequence, err := strconv.ParseInt(aws.StringValue(v.N), 10, 64)
+	if err != nil {
+		logrus.Warnf("Ignoring invalid checkpoint sub-sequence number %s of lease: %v", aws.StringValue(v.N), err)
+		return 0
+	}
+	return subSequence
+}
+
+// ownerSwitches returns the number of owner switches of a lease item since its last checkpoint. The checkpoints
+// don't write the count, which resets it.
+func (checkpointer *DynamoCheckpoint) ownerSwitches(item map[string]*dynamodb.AttributeValue) int {
+	v, ok := item[checkpointer.attributes.OwnerSwitchesSinceCheckpoint]
+	if !ok {
+		return 0
+	}
+	switches, err := strconv.Atoi(aws.StringValue(v.N))
+	if err != nil {
+		logrus.Warnf("Ignoring invalid owner switches %s of lease: %v", aws.StringValue(v.N), err)
+		return 0
+	}
+	return switches
+}
+
+// keepLag adds the last lag snapshot of the shard to a lease item about to replace the current one.
+func (checkpointer *DynamoCheckpoint) keepLag(shardID string, item map[string]*dynamodb.AttributeValue) {
+	if lag, ok := checkpointer.lags.Load(shardID); ok {
+		item[MILLIS_BEHIND_LATEST_KEY] = &dynamodb.AttributeValue{N: aws.String(lag.(string))}
+	}
+}
+
+// tagAvailabilityZone adds the availability zone of the worker, if any, to a lease item it writes.
+func (checkpointer *DynamoCheckpoint) tagAvailabilityZone(item map[string]*dynamodb.AttributeValue) {
+	if checkpointer.kclConfig.AvailabilityZone != "" {
+		item[OWNER_AVAILABILITY_ZONE_KEY] = &dynamodb.AttributeValue{S: aws.String(checkpointer.kclConfig.AvailabilityZone)}
+	}
+}
+
 func (checkpointer *DynamoCheckpoint) createTable() error {
 	input := &dynamodb.CreateTableInput{
 		AttributeDefinitions: []*dynamodb.AttributeDefinition{
 			{
-				AttributeName: aws.String(LEASE_KEY_KEY),
+				AttributeName: aws.String(checkpointer.attributes.LeaseKey),
 				AttributeType: aws.String("S"),
 			},
 		},
 		KeySchema: []*dynamodb.KeySchemaElement{
 			{
-				AttributeName: aws.String(LEASE_KEY_KEY),
+				AttributeName: aws.String(checkpointer.attributes.LeaseKey),
 				KeyType:       aws.String("HASH"),
 			},
 		},
-		ProvisionedThroughput: &dynamodb.ProvisionedThroughput{
+		TableName: aws.String(checkpointer.TableName),
+	}
+	// the capacity can't be sent with on-demand billing
+	if checkpointer.leaseTableBillingMode == dynamodb.BillingModePayPerRequest {
+		input.BillingMode = aws.String(dynamodb.BillingModePayPerRequest)
+	} else {
+		input.BillingMode = aws.String(dynamodb.BillingModeProvisioned)
+		input.ProvisionedThroughput = &dynamodb.ProvisionedThroughput{
 			ReadCapacityUnits:  aws.Int64(checkpointer.leaseTableReadCapacity),
 			WriteCapacityUnits: aws.Int64(checkpointer.leaseTableWriteCapacity),
-		},
-		TableName: aws.String(checkpointer.TableName),
+		}
 	}
 	_, err := checkpointer.svc.CreateTable(input)
 	return err
 }
 
+// recreateDeletedTable creates the lease table again if err tells it was deleted and RecreateDeletedLeaseTable is set.
+// The failed operation isn't retried: the leases are written again as the worker renews them.
+func (checkpointer *DynamoCheckpoint) recreateDeletedTable(err error) {
+	if !checkpointer.kclConfig.RecreateDeletedLeaseTable || checkpointer.skipTableCheck {
+		return
+	}
+	if awsErr, ok := err.(awserr.Error); !ok || awsErr.Code() != dynamodb.ErrCodeResourceNotFoundException {
+		return
+	}
+
+	checkpointer.recreateMux.Lock()
+	defer checkpointer.recreateMux.Unlock()
+	// recreated meanwhile, by this worker or another one
+	if checkpointer.doesTableExist() {
+		return
+	}
+
+	logrus.Errorf("Lease table %s was deleted, creating it again", checkpointer.TableName)
+	if err := checkpointer.createTable(); err != nil {
+		if awsErr, ok := err.(awserr.Error); !ok || awsErr.Code() != dynamodb.ErrCodeResourceInUseException {
+			logrus.Errorf("Failed to create lease table %s again: %+v", checkpointer.TableName, err)
+			return
+		}
+	}
+	util.EmitEvent(checkpointer.kclConfig.EventListener, util.CRITICAL, util.EVENT_LEASE_TABLE_RECREATED, "",
+		fmt.Sprintf("lease table %s was deleted and created again, its checkpoints are lost", checkpointer.TableName))
+}
+
 func (checkpointer *DynamoCheckpoint) doesTableExist() bool {
 	input := &dynamodb.DescribeTableInput{
 		TableName: aws.String(checkpointer.TableName),
@@ -287,6 +946,18 @@ func (checkpointer *DynamoCheckpoint) doesTableExist() bool {
 	return err == nil
 }
 
+// isThrottlingError returns true if the DynamoDB request failed because it was throttled.
+func isThrottlingError(err error) bool {
+	if awsErr, ok := err.(awserr.Error); ok {
+		switch awsErr.Code() {
+		case dynamodb.ErrCodeProvisionedThroughputExceededException, dynamodb.ErrCodeRequestLimitExceeded,
+			dynamodb.ErrCodeLimitExceededException, "ThrottlingException":
+			return true
+		}
+	}
+	return false
+}
+
 func (checkpointer *DynamoCheckpoint) saveItem(item map[string]*dynamodb.AttributeValue) error {
 	return checkpointer.putItem(&dynamodb.PutItemInput{
 		TableName: aws.String(checkpointer.TableName),
@@ -294,38 +965,71 @@ func (checkpointer *DynamoCheckpoint) saveItem(item map[string]*dynamodb.Attribu
 	})
 }
 
-func (checkpointer *DynamoCheckpoint) conditionalUpdate(conditionExpression string, expressionAttributeValues map[string]*dynamodb.AttributeValue, item map[string]*dynamodb.AttributeValue) error {
+func (checkpointer *DynamoCheckpoint) conditionalUpdate(conditionExpression string, expressionAttributeNames map[string]*string, expressionAttributeValues map[string]*dynamodb.AttributeValue, item map[string]*dynamodb.AttributeValue) error {
 	return checkpointer.putItem(&dynamodb.PutItemInput{
 		ConditionExpression:       aws.String(conditionExpression),
 		TableName:                 aws.String(checkpointer.TableName),
 		Item:                      item,
+		ExpressionAttributeNames:  expressionAttributeNames,
 		ExpressionAttributeValues: expressionAttributeValues,
 	})
 }
 
 func (checkpointer *DynamoCheckpoint) putItem(input *dynamodb.PutItemInput) error {
 	_, err := checkpointer.svc.PutItem(input)
+	if err != nil {
+		checkpointer.recreateDeletedTable(err)
+	}
 	return err
 }
 
+// getItem reads the lease of the shard with the configured read consistency.
 func (checkpointer *DynamoCheckpoint) getItem(shardID string) (map[string]*dynamodb.AttributeValue, error) {
+	switch checkpointer.readConsistency {
+	case goKCL.CONSISTENT_READS:
+		return checkpointer.readItem(shardID, true)
+	case goKCL.RECONCILED_READS:
+		first, err := checkpointer.readItem(shardID, false)
+		if err != nil {
+			return nil, err
+		}
+		second, err := checkpointer.readItem(shardID, false)
+		if err != nil {
+			return nil, err
+		}
+		if reflect.DeepEqual(first, second) {
+			return second, nil
+		}
+		logrus.Debugf("Inconsistent reads of lease %s, reconciling with a consistent read", shardID)
+		return checkpointer.readItem(shardID, true)
+	default:
+		return checkpointer.readItem(shardID, false)
+	}
+}
+
+func (checkpointer *DynamoCheckpoint) readItem(shardID string, consistent bool) (map[string]*dynamodb.AttributeValue, error) {
 	item, err := checkpointer.svc.GetItem(&dynamodb.GetItemInput{
-		TableName: aws.String(checkpointer.TableName),
+		TableName:      aws.String(checkpointer.TableName),
+		ConsistentRead: aws.Bool(consistent),
 		Key: map[string]*dynamodb.AttributeValue{
-			LEASE_KEY_KEY: {
-				S: aws.String(shardID),
+			checkpointer.attributes.LeaseKey: {
+				S: aws.String(checkpointer.leaseKey(shardID)),
 			},
 		},
 	})
-	return item.Item, err
+	if err != nil {
+		checkpointer.recreateDeletedTable(err)
+		return nil, err
+	}
+	return item.Item, nil
 }
 
 func (checkpointer *DynamoCheckpoint) removeItem(shardID string) error {
 	_, err := checkpointer.svc.DeleteItem(&dynamodb.DeleteItemInput{
 		TableName: aws.String(checkpointer.TableName),
 		Key: map[string]*dynamodb.AttributeValue{
-			LEASE_KEY_KEY: {
-				S: aws.String(shardID),
+			checkpointer.attributes.LeaseKey: {
+				S: aws.String(checkpointer.leaseKey(shardID)),
 			},
 		},
 	})
@@ -353,5 +1057,127 @@ type Checkpointer interface {
 	RemoveLeaseOwner(string) error
 }
 
+// LeaseReleaseSignal tells the peers of a departing worker which leases it released
+type LeaseReleaseSignal struct {
+	WorkerID   string
+	ShardIDs   []string
+	ReleasedAt time.Time
+}
+
+// LeaseReleaseSignaler is implemented by checkpointers supporting cooperative shutdown
+type LeaseReleaseSignaler interface {
+	// SignalLeasesReleased records that the worker released the leases of the given shards while departing
+	SignalLeasesReleased(string, []string) error
+
+	// FetchLeaseReleaseSignal retrieves the latest lease release signal, nil if there is none
+	FetchLeaseReleaseSignal() (*LeaseReleaseSignal, error)
+}
+
+// Lease is a lease of the lease table
+type Lease struct {
+	ShardID       string
+	Owner         string
+	LeaseTimeout  time.Time
+	Checkpoint    string
+	ParentShardId string
+	// sub-sequence number of the checkpoint, zero if the checkpoint covers the whole record, -1 if none of it
+	CheckpointSubSequenceNumber int64
+	// availability zone of the owner, empty if it didn't report one
+	OwnerAvailabilityZone string
+	// latest lag snapshot of the shard, nil if none was written
+	MillisBehindLatest *int64
+}
+
+// AccessValidator is implemented by checkpointers able to check their access to the lease table without changing
+// it, see goKCL.Worker.Validate
+type AccessValidator interface {
+	// ValidateAccess returns an error if the lease table can't be read or written
+	ValidateAccess() error
+}
+
+// LeaseLister is implemented by checkpointers able to list all the leases of the lease table
+type LeaseLister interface {
+	// GetLeases retrieves all the leases of the lease table
+	GetLeases() ([]*Lease, error)
+}
+
+// LeaseStealer is implemented by checkpointers able to take a lease from a live owner, to balance the leases
+type LeaseStealer interface {
+	// StealLease takes the lease of the shard from the given owner for the new owner, even if it didn't expire yet
+	StealLease(*Status, string, string) error
+}
+
+// LeaseNamespacer is implemented by checkpointers able to keep the leases of several streams in one lease table
+type LeaseNamespacer interface {
+	// WithLeaseKeyPrefix returns a checkpointer of the same lease table prefixing the lease keys with the prefix
+	WithLeaseKeyPrefix(string) Checkpointer
+}
+
+// CheckpointOverrider is implemented by checkpointers able to move the checkpoint of a lease whoever holds it, see
+// goKCL.Worker.ReplayShard
+type CheckpointOverrider interface {
+	// OverrideCheckpoint writes the checkpoint of the shard, leaving the owner and the lease timeout of its lease as
+	// they are, and sets the owner of the shard to the one of the lease. It fails with ErrLeaseNotAquired if the
+	// lease is held by a worker other than the owner of the shard and hasn't expired, or if the lease changed owner
+	// or was renewed between its read and the write.
+	OverrideCheckpoint(*Status) error
+}
+
+// RetryingCheckpointer is implemented by checkpointers retrying their calls, so that their retries draw from the
+// retry budget of the worker, see util.Backoff
+type RetryingCheckpointer interface {
+	// SetRetryBudget makes the retries draw from the budget
+	SetRetryBudget(*util.RetryBudget)
+}
+
+// LagRecorder is implemented by checkpointers able to store the lag of the shards in the lease table
+type LagRecorder interface {
+	// RecordLag writes the latest MillisBehindLatest of the shard into its lease
+	RecordLag(string, int64) error
+}
+
 // ErrSequenceIDNotFound is returned by FetchCheckpoint when no SequenceID is found
 var ErrSequenceIDNotFound = errors.New("SequenceIDNotFoundForShard")
+
+// ValidateSequenceNumber returns an IllegalArgumentError unless the sequence number can be the one of a record of the
+// shard, i.e. it is well formed and within the sequence number range of the shard.
+func (ss *Status) ValidateSequenceNumber(sequenceNumber string) error {
+	if !goKCL.IsValidSequenceNumber(sequenceNumber) {
+		return util.IllegalArgumentError.MakeErr().WithDetail("invalid sequence number %q", sequenceNumber)
+	}
+
+	ss.Mux.Lock()
+	start, end := ss.StartingSequenceNumber, ss.EndingSequenceNumber
+	ss.Mux.Unlock()
+	if start != "" && CompareSequenceNumbers(sequenceNumber, start) < 0 {
+		return util.IllegalArgumentError.MakeErr().
+			WithDetail("sequence number %s is before the start %s of shard %s", sequenceNumber, start, ss.ID)
+	}
+	if end != "" && CompareSequenceNumbers(sequenceNumber, end) > 0 {
+		return util.IllegalArgumentError.MakeErr().
+			WithDetail("sequence number %s is after the end %s of shard %s", sequenceNumber, end, ss.ID)
+	}
+	return nil
+}
+
+// CompareSequenceNumbers compares two checkpoints. Sequence numbers are decimals of varying length, no checkpoint
+// comes before any sequence number and SHARD_END after all of them.
+func CompareSequenceNumbers(a, b string) int {
+	switch {
+	case a == b:
+		return 0
+	case a == SHARD_END || b == "":
+		return 1
+	case b == SHARD_END || a == "":
+		return -1
+	case len(a) != len(b):
+		if len(a) < len(b) {
+			return -1
+		}
+		return 1
+	case a < b:
+		return -1
+	default:
+		return 1
+	}
+}
@@ -0,0 +1,52 @@
+package shard
+
+import (
+	"sync"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/kinesis"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/guygma/goKCL/record"
+)
+
+// orderIndependentProcessor returns the record processor if the record of a batch are to be processed concurrently.
+func (sc *Consumer) orderIndependentProcessor() (record.IOrderIndependentRecordProcessor, bool) {
+	if sc.kclConfig.RecordDeliveryConcurrency <= 1 {
+		return nil, false
+	}
+	processor, ok := sc.recordProcessor.(record.IOrderIndependentRecordProcessor)
+	return processor, ok
+}
+
+// processConcurrently hands the record of the batch to up to RecordDeliveryConcurrency concurrent ProcessRecord
+// calls, and checkpoints the batch once all of them returned.
+func (sc *Consumer) processConcurrently(shard *Status, processor record.IOrderIndependentRecordProcessor,
+	input *record.ProcessRecordsInput) {
+	if len(input.Records) == 0 {
+		return
+	}
+
+	records := make(chan *kinesis.Record)
+	wg := sync.WaitGroup{}
+	for i := 0; i < sc.kclConfig.RecordDeliveryConcurrency && i < len(input.Records); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for r := range records {
+				processor.ProcessRecord(r)
+			}
+		}()
+	}
+	for _, r := range input.Records {
+		records <- r
+	}
+	close(records)
+	wg.Wait()
+
+	last := input.Records[len(input.Records)-1]
+	if err := input.Checkpointer.Checkpoint(last.SequenceNumber); err != nil {
+		log.Errorf("Failed to checkpoint shard %s at %s after concurrent processing: %+v", shard.ID,
+			aws.StringValue(last.SequenceNumber), err)
+	}
+}
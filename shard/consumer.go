@@ -1,16 +1,16 @@
 package shard
 
 import (
+	"context"
+	"fmt"
 	"github.com/guygma/goKCL/record"
 	log "github.com/sirupsen/logrus"
-	"math"
 	"sync"
 	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/awserr"
 	"github.com/aws/aws-sdk-go/service/kinesis"
-	"github.com/aws/aws-sdk-go/service/kinesis/kinesisiface"
 
 	"github.com/guygma/goKCL"
 	"github.com/guygma/goKCL/util"
@@ -36,6 +36,13 @@ const (
 	// ErrCodeKMSThrottlingException is defined in the API Reference https://docs.aws.amazon.com/sdk-for-go/api/service/kinesis/#Kinesis.GetRecords
 	// But it's not a constant?
 	ErrCodeKMSThrottlingException = "KMSThrottlingException"
+
+	// leaseRenewalMargin is how long before its expiry the lease of a shard is renewed. A consumer tolerating
+	// renewal failures relinquishes the lease once within the margin.
+	leaseRenewalMargin = 5 * time.Second
+
+	// diagnosticShardIteratorLength is how much of the shard iterators the fetch diagnostics show.
+	diagnosticShardIteratorLength = 16
 )
 
 // ExtendedSequenceNumber represents a two-part sequence number for record aggregated by the Kinesis Producer Library.
@@ -52,6 +59,10 @@ type InitializationInput struct {
 	ShardId                         string
 	ExtendedSequenceNumber          *ExtendedSequenceNumber
 	PendingCheckpointSequenceNumber *ExtendedSequenceNumber
+	// HashKeyRange of the shard, for processors doing key based routing
+	HashKeyRange *kinesis.HashKeyRange
+	// Context is canceled once the worker shuts down or the lease of the shard is lost
+	Context context.Context
 }
 
 type Status struct {
@@ -65,6 +76,55 @@ type Status struct {
 	StartingSequenceNumber string
 	// child shard doesn't have end sequence number
 	EndingSequenceNumber string
+	// Range of partition key hashes served by the shard
+	HashKeyRange *kinesis.HashKeyRange
+	// sub-sequence number of the user record of the aggregated record at Checkpoint the checkpoint is at, zero if it
+	// covers the whole record, -1 if it is right before the record, e.g. replayed from it
+	CheckpointSubSequenceNumber int64
+	// second parent of a shard resulting from a merge
+	AdjacentParentShardId string
+
+	// start time and number of starts of the consumers of the shard, zero start time while none is running
+	consumerStartedAt time.Time
+	consumerStarts    int
+
+	// diagnostics of the last GetRecords call
+	fetch FetchDiagnostics
+
+	// where the last consumer of the shard started reading it
+	startingPosition StartingPosition
+
+	// moving average of the record processed per second by the consumer of the shard
+	throughput throughputAverage
+
+	// last owners of the lease, oldest first
+	ownershipHistory []LeaseOwnership
+
+	// the consumer of the shard is asked to release the lease
+	releaseRequested bool
+
+	// the consumer of the shard read it up to its end
+	shardEndReached bool
+
+	// how far the last record fetched were behind the tip of the stream
+	millisBehindLatest int64
+	// latest event time of the record delivered to the record processor
+	watermark time.Time
+	// checkpoints written and throttled fetches since the last TakeActivity
+	checkpointWrites int
+	throttles        int
+}
+
+// FetchDiagnostics tells where the consumer of a shard is reading, to debug stuck consumers.
+//
+// Unstable: it is meant for diagnostics only, its content may change or go away in any release.
+type FetchDiagnostics struct {
+	ShardID string
+	// shard iterator the next GetRecords call uses, truncated since it grants read access to the shard
+	ShardIterator string
+	// sequence number of the last record fetched, empty if none was
+	LastFetchedSequenceNumber string
+	FetchedAt                 time.Time
 }
 
 func (ss *Status) GetLeaseOwner() string {
@@ -79,6 +139,174 @@ func (ss *Status) SetLeaseOwner(owner string) {
 	ss.AssignedTo = owner
 }
 
+// GetHashKeyRange returns the starting and ending hash key of the shard as reported by shard discovery.
+func (ss *Status) GetHashKeyRange() (string, string) {
+	if ss.HashKeyRange == nil {
+		return "", ""
+	}
+	return aws.StringValue(ss.HashKeyRange.StartingHashKey), aws.StringValue(ss.HashKeyRange.EndingHashKey)
+}
+
+// ParentShardIDs returns the shards the shard resulted from: none for a shard of the original stream, one after a
+// split and two after a merge.
+func (ss *Status) ParentShardIDs() []string {
+	var parents []string
+	if ss.ParentShardId != "" {
+		parents = append(parents, ss.ParentShardId)
+	}
+	if ss.AdjacentParentShardId != "" {
+		parents = append(parents, ss.AdjacentParentShardId)
+	}
+	return parents
+}
+
+// MarkConsumerStarted records the start of a consumer of the shard. It returns true if it is a restart.
+func (ss *Status) MarkConsumerStarted(now time.Time) bool {
+	ss.Mux.Lock()
+	defer ss.Mux.Unlock()
+	ss.consumerStartedAt = now
+	ss.consumerStarts++
+	ss.throughput = throughputAverage{}
+	return ss.consumerStarts > 1
+}
+
+// MarkConsumerStopped records that the consumer of the shard stopped.
+func (ss *Status) MarkConsumerStopped() {
+	ss.Mux.Lock()
+	defer ss.Mux.Unlock()
+	ss.consumerStartedAt = time.Time{}
+}
+
+// GetConsumerUptime returns how long the consumer of the shard has been running since its last (re)start, zero if
+// none is running.
+func (ss *Status) GetConsumerUptime(now time.Time) time.Duration {
+	ss.Mux.Lock()
+	defer ss.Mux.Unlock()
+	if ss.consumerStartedAt.IsZero() {
+		return 0
+	}
+	return now.Sub(ss.consumerStartedAt)
+}
+
+// GetConsumerRestarts returns how many times a consumer of the shard has been restarted.
+func (ss *Status) GetConsumerRestarts() int {
+	ss.Mux.Lock()
+	defer ss.Mux.Unlock()
+	if ss.consumerStarts == 0 {
+		return 0
+	}
+	return ss.consumerStarts - 1
+}
+
+// GetThroughput returns the moving average of the record processed per second by the consumer of the shard.
+func (ss *Status) GetThroughput() float64 {
+	ss.Mux.Lock()
+	defer ss.Mux.Unlock()
+	return ss.throughput.rate
+}
+
+// ShardEndReached returns true once a consumer of the shard read it up to its end, i.e. the shard is closed and all
+// its record were delivered.
+func (ss *Status) ShardEndReached() bool {
+	ss.Mux.Lock()
+	defer ss.Mux.Unlock()
+	return ss.shardEndReached
+}
+
+func (ss *Status) markShardEndReached() {
+	ss.Mux.Lock()
+	defer ss.Mux.Unlock()
+	ss.shardEndReached = true
+}
+
+// recordThroughput adds the record processed by a GetRecords call to the moving average of the throughput and
+// returns it.
+func (ss *Status) recordThroughput(records int, now time.Time, smoothingFactor float64) float64 {
+	ss.Mux.Lock()
+	defer ss.Mux.Unlock()
+	ss.throughput.observe(records, now, smoothingFactor)
+	return ss.throughput.rate
+}
+
+// GetFetchDiagnostics returns the diagnostics of the last GetRecords call of the consumer of the shard.
+//
+// Unstable: it is meant for diagnostics only, see FetchDiagnostics.
+func (ss *Status) GetFetchDiagnostics() FetchDiagnostics {
+	ss.Mux.Lock()
+	defer ss.Mux.Unlock()
+	fetch := ss.fetch
+	fetch.ShardID = ss.ID
+	return fetch
+}
+
+// recordFetch updates the diagnostics after a GetRecords call.
+func (ss *Status) recordFetch(nextShardIterator *string, records []*kinesis.Record, millisBehindLatest int64,
+	now time.Time) {
+	ss.Mux.Lock()
+	defer ss.Mux.Unlock()
+	ss.millisBehindLatest = millisBehindLatest
+	ss.fetch.ShardIterator = truncateShardIterator(aws.StringValue(nextShardIterator))
+	if len(records) > 0 {
+		ss.fetch.LastFetchedSequenceNumber = aws.StringValue(records[len(records)-1].SequenceNumber)
+	}
+	ss.fetch.FetchedAt = now
+}
+
+// GetMillisBehindLatest returns how far the last record fetched from the shard were behind the tip of the stream.
+func (ss *Status) GetMillisBehindLatest() int64 {
+	ss.Mux.Lock()
+	defer ss.Mux.Unlock()
+	return ss.millisBehindLatest
+}
+
+// GetWatermark returns the latest event time of the record of the shard delivered to the record processor, zero if
+// none was. The event time is the one of KinesisClientLibConfiguration.RecordTimestampExtractor if set.
+func (ss *Status) GetWatermark() time.Time {
+	ss.Mux.Lock()
+	defer ss.Mux.Unlock()
+	return ss.watermark
+}
+
+// advanceWatermark moves the event time watermark of the shard up to t if it is later.
+func (ss *Status) advanceWatermark(t time.Time) {
+	ss.Mux.Lock()
+	defer ss.Mux.Unlock()
+	if t.After(ss.watermark) {
+		ss.watermark = t
+	}
+}
+
+// MarkCheckpointWritten counts a checkpoint of the shard written to the lease table, see TakeActivity.
+func (ss *Status) MarkCheckpointWritten() {
+	ss.Mux.Lock()
+	defer ss.Mux.Unlock()
+	ss.checkpointWrites++
+}
+
+// recordThrottle counts a throttled fetch of the shard, see TakeActivity.
+func (ss *Status) recordThrottle() {
+	ss.Mux.Lock()
+	defer ss.Mux.Unlock()
+	ss.throttles++
+}
+
+// TakeActivity returns the checkpoints written and the throttled fetches of the shard since the last call.
+func (ss *Status) TakeActivity() (checkpointWrites, throttles int) {
+	ss.Mux.Lock()
+	defer ss.Mux.Unlock()
+	checkpointWrites, throttles = ss.checkpointWrites, ss.throttles
+	ss.checkpointWrites, ss.throttles = 0, 0
+	return checkpointWrites, throttles
+}
+
+// truncateShardIterator keeps enough of a shard iterator to tell iterators apart, but not to use it.
+func truncateShardIterator(iterator string) string {
+	if len(iterator) <= diagnosticShardIteratorLength {
+		return iterator
+	}
+	return iterator[:diagnosticShardIteratorLength] + "..."
+}
+
 type ConsumerState int
 
 // ShardConsumer is responsible for consuming data record of a (specified) shard.
@@ -86,7 +314,7 @@ type ConsumerState int
 type Consumer struct {
 	streamName      string
 	shard           *Status
-	kc              kinesisiface.KinesisAPI
+	kc              goKCL.KinesisAPI
 	checkpointer    Checkpointer
 	recordProcessor record.IRecordProcessor
 	kclConfig       *goKCL.KinesisClientLibConfiguration
@@ -94,14 +322,60 @@ type Consumer struct {
 	waitGroup       *sync.WaitGroup
 	consumerID      string
 	mService        util.MonitoringService
+	retryBudget     *util.RetryBudget
+	backpressure    *backpressureDetector
+	autoCheckpoint  *autoCheckpointer
+	inFlight        *inFlightBatches
+	outstanding     *outstandingRecords
+	batching        *batchingWindow
+	watchdog        *watchdog
 	state           ConsumerState
+
+	// follower consumers read the shard without holding its lease or checkpointing
+	follower bool
+
+	// explicitly configured position to start reading the shard from, overriding the checkpoint
+	startingSequenceNumber *goKCL.StartingSequenceNumber
+
+	// retention period of the stream, zero if unknown
+	retentionPeriod time.Duration
+
+	// writes lag snapshots to the lease table, nil if disabled
+	lagRecorder LagRecorder
+
+	// enhanced fan-out: ARN of the stream consumer the shard is read as, empty to poll the shard, and the
+	// subscription to the shard
+	consumerARN  string
+	subscription *shardSubscription
+	// client of the subscriptions, nil to subscribe with kc
+	fanOutKc goKCL.EnhancedFanOutAPI
+
+	// parentListed returns true while the parent shard is listed in the stream, nil if unknown
+	parentListed func(shardID string) bool
+
+	// sub-sequence numbers of the user record deaggregated from KPL aggregated record, pending in the batching
+	// window or last delivered
+	subSequenceNumbers map[*kinesis.Record]int64
+
+	// the lease was taken by another worker, it isn't released on exit
+	leaseLost bool
+
+	// ctx is handed to the callbacks of the record processor, canceled by cancelCtx once the worker shuts down or
+	// the lease is lost
+	ctx       context.Context
+	cancelCtx context.CancelFunc
+
+	// reason of the shutdown of the record processor, zero until it is shut down
+	shutdownReason util.ShutdownReason
 }
 
-func (sc *Consumer) getShardIterator(st *Status) (*string, error) {
+// checkpointPosition fetches the checkpoint of the shard and returns the position resuming the shard from it, or
+// the initial position in stream if there isn't any.
+func (sc *Consumer) checkpointPosition(st *Status) (*kinesis.StartingPosition, StartingPositionReason, error) {
 	// Get checkpoint of the shard from dynamoDB
 	err := sc.checkpointer.FetchCheckpoint(st)
 	if err != nil && err != ErrSequenceIDNotFound {
-		return nil, err
+		return nil, 0, err
 	}
 
 	// If there isn't any checkpoint for the shard, use the configuration value.
@@ -109,95 +383,390 @@ func (sc *Consumer) getShardIterator(st *Status) (*string, error) {
 		initPos := sc.kclConfig.InitialPositionInStream
 		log.Debugf("No checkpoint recorded for shard: %v, starting with: %v", st.ID,
 			aws.StringValue(goKCL.InitalPositionInStreamToShardIteratorType(initPos)))
-		shardIterArgs := &kinesis.GetShardIteratorInput{
-			ShardId:           &st.ID,
-			ShardIteratorType: goKCL.InitalPositionInStreamToShardIteratorType(initPos),
-			StreamName:        &sc.streamName,
+		position := &kinesis.StartingPosition{Type: goKCL.InitalPositionInStreamToShardIteratorType(initPos)}
+		if initPos == goKCL.AT_TIMESTAMP {
+			position.Timestamp = sc.kclConfig.InitialPositionInStreamExtended.Timestamp
 		}
-		iterResp, err := sc.kc.GetShardIterator(shardIterArgs)
-		if err != nil {
-			return nil, err
-		}
-		return iterResp.ShardIterator, nil
+		return position, STARTED_FROM_INITIAL_POSITION, nil
 	}
 
 	log.Debugf("Start shard: %v at checkpoint: %v", st.ID, st.Checkpoint)
-	shardIterArgs := &kinesis.GetShardIteratorInput{
-		ShardId:                &st.ID,
-		ShardIteratorType:      aws.String("AFTER_SEQUENCE_NUMBER"),
-		StartingSequenceNumber: &st.Checkpoint,
-		StreamName:             &sc.streamName,
+	return &kinesis.StartingPosition{
+		Type:           aws.String(checkpointIteratorType(st)),
+		SequenceNumber: aws.String(st.Checkpoint),
+	}, STARTED_FROM_CHECKPOINT, nil
+}
+
+// checkpointIteratorType returns the iterator type resuming the shard from its checkpoint. A checkpoint within an
+// aggregated record, or right before the record, resumes at the record: its user record are delivered again rather
+// than lost.
+func checkpointIteratorType(st *Status) string {
+	if st.CheckpointSubSequenceNumber != 0 {
+		return "AT_SEQUENCE_NUMBER"
+	}
+	return "AFTER_SEQUENCE_NUMBER"
+}
+
+// startingPosition returns the position the consumer starts reading the shard from, and why. An explicitly
+// configured starting sequence number overrides both the checkpoint and the initial position in stream.
+func (sc *Consumer) startingPosition(st *Status) (*kinesis.StartingPosition, StartingPositionReason, error) {
+	if sc.startingSequenceNumber == nil {
+		return sc.checkpointPosition(st)
+	}
+
+	iteratorType := "AT_SEQUENCE_NUMBER"
+	if sc.startingSequenceNumber.After {
+		iteratorType = "AFTER_SEQUENCE_NUMBER"
+	}
+	return &kinesis.StartingPosition{
+		Type:           aws.String(iteratorType),
+		SequenceNumber: aws.String(sc.startingSequenceNumber.SequenceNumber),
+	}, STARTED_FROM_CONFIGURED_SEQUENCE_NUMBER, nil
+}
+
+// startReading returns the iterator the consumer starts polling the shard with. With enhanced fan-out, it prepares
+// the subscription to the shard instead and returns no iterator.
+func (sc *Consumer) startReading(st *Status) (*string, error) {
+	if sc.consumerARN == "" {
+		return sc.getStartingShardIterator(st)
+	}
+
+	position, reason, err := sc.startingPosition(st)
+	if err != nil {
+		return nil, err
 	}
-	iterResp, err := sc.kc.GetShardIterator(shardIterArgs)
+	efo := sc.fanOutKc
+	if efo == nil {
+		efo = sc.kc.(goKCL.EnhancedFanOutAPI)
+	}
+	sc.subscription = newShardSubscription(efo, sc.consumerARN, st.ID, position,
+		time.Duration(sc.kclConfig.FanOutReadTimeoutMillis)*time.Millisecond)
+	sc.markStartingPosition(st, position, reason)
+	return nil, nil
+}
+
+// getShardIterator returns an iterator resuming the shard from its checkpoint.
+func (sc *Consumer) getShardIterator(st *Status) (*string, error) {
+	position, _, err := sc.checkpointPosition(st)
 	if err != nil {
 		return nil, err
 	}
-	return iterResp.ShardIterator, nil
+	return sc.acquireShardIterator(sc.shardIteratorInput(st, position))
+}
+
+// maxRecords returns the limit of the next GetRecords call on the shard: the one of MaxRecordsForShard if set,
+// bounded to what Kinesis accepts, MaxRecords otherwise.
+func (sc *Consumer) maxRecords(st *Status) int {
+	if sc.kclConfig.MaxRecordsForShard == nil {
+		return sc.kclConfig.MaxRecords
+	}
+	limit := sc.kclConfig.MaxRecordsForShard(st.ID, st.GetMillisBehindLatest())
+	if limit < 1 {
+		return 1
+	}
+	if limit > goKCL.DEFAULT_MAX_RECORDS {
+		return goKCL.DEFAULT_MAX_RECORDS
+	}
+	return limit
+}
+
+// refreshShardIterator returns an iterator replacing an expired one. It resumes from the checkpoint, or at the first
+// record fetched by the consumer if the shard has none yet: the initial position in stream, e.g. LATEST, would skip
+// the record fetched since, and the configured starting sequence number, if any, applies to the start only.
+func (sc *Consumer) refreshShardIterator(st *Status, firstFetched string) (*string, error) {
+	st.Mux.Lock()
+	checkpoint := st.Checkpoint
+	st.Mux.Unlock()
+	if checkpoint != "" || firstFetched == "" {
+		return sc.getShardIterator(st)
+	}
+
+	log.Debugf("Shard %s has no checkpoint, resuming at the first record fetched %s", st.ID, firstFetched)
+	return sc.acquireShardIterator(sc.shardIteratorInput(st, &kinesis.StartingPosition{
+		Type:           aws.String("AT_SEQUENCE_NUMBER"),
+		SequenceNumber: aws.String(firstFetched),
+	}))
+}
+
+// getStartingShardIterator returns the iterator the consumer starts reading the shard from.
+func (sc *Consumer) getStartingShardIterator(st *Status) (*string, error) {
+	position, reason, err := sc.startingPosition(st)
+	if err != nil {
+		return nil, err
+	}
+	iterator, err := sc.acquireShardIterator(sc.shardIteratorInput(st, position))
+	if err != nil {
+		return nil, err
+	}
+	sc.markStartingPosition(st, position, reason)
+	return iterator, nil
+}
+
+func (sc *Consumer) shardIteratorInput(st *Status, position *kinesis.StartingPosition) *kinesis.GetShardIteratorInput {
+	return &kinesis.GetShardIteratorInput{
+		ShardId:                &st.ID,
+		ShardIteratorType:      position.Type,
+		StartingSequenceNumber: position.SequenceNumber,
+		Timestamp:              position.Timestamp,
+		StreamName:             &sc.streamName,
+	}
+}
+
+// acquireShardIterator gets a shard iterator, retrying its transient failures with jittered exponential backoff up to
+// ShardIteratorRetries times, as long as the retry budget allows. A throttled acquisition failing for good is a
+// ThrottlingError.
+func (sc *Consumer) acquireShardIterator(args *kinesis.GetShardIteratorInput) (*string, error) {
+	backoff := util.Backoff{
+		Base:     time.Duration(sc.kclConfig.ShardIteratorBackoffMillis) * time.Millisecond,
+		Max:      sc.kclConfig.RetryBackoff.Max,
+		Attempts: sc.kclConfig.ShardIteratorRetries + 1,
+		Budget:   sc.retryBudget,
+	}
+	ctx, cancel := sc.stopContext()
+	defer cancel()
+
+	var iterator *string
+	err := backoff.Retry(ctx, "Getting shard iterator for "+aws.StringValue(args.ShardId), nil, func() error {
+		iterResp, err := sc.kc.GetShardIterator(args)
+		if err == nil {
+			iterator = iterResp.ShardIterator
+		}
+		return err
+	})
+	return iterator, err
+}
+
+// stopContext returns a context canceled once the worker shuts down, e.g. to cut the backoff of a retry short.
+func (sc *Consumer) stopContext() (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		select {
+		case <-*sc.stop:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+	return ctx, cancel
+}
+
+// processorContext returns the context handed to the callbacks of the record processor, a background context
+// outside of GetRecords.
+func (sc *Consumer) processorContext() context.Context {
+	if sc.ctx == nil {
+		return context.Background()
+	}
+	return sc.ctx
+}
+
+// markStartingPosition records and logs where the consumer starts reading the shard, and why.
+func (sc *Consumer) markStartingPosition(st *Status, position *kinesis.StartingPosition, reason StartingPositionReason) {
+	st.recordStartingPosition(aws.StringValue(position.Type), aws.StringValue(position.SequenceNumber), reason)
+	if position.SequenceNumber != nil {
+		log.Infof("Starting shard: %v with %v %v from %v", st.ID, aws.StringValue(position.Type),
+			aws.StringValue(position.SequenceNumber), reason)
+		return
+	}
+	log.Infof("Starting shard: %v with %v from %v", st.ID, aws.StringValue(position.Type), reason)
 }
 
 // getRecords continously poll one shard for data record
 // Precondition: it currently has the lease on the shard.
 func (sc *Consumer) GetRecords(shard *Status) error {
 	defer sc.waitGroup.Done()
-	defer sc.releaseLease(shard)
+	if !sc.follower {
+		// the last step of the shutdown, if the record processor was shut down
+		defer sc.shutdownStep(shard, util.RELEASE_LEASE, func() { sc.releaseLease(shard) })
+	}
+
+	if shard.MarkConsumerStarted(time.Now()) {
+		log.Infof("Consumer of shard %s restarted %d times", shard.ID, shard.GetConsumerRestarts())
+		sc.mService.IncrConsumerRestarts(shard.ID)
+	}
+	defer shard.MarkConsumerStopped()
 
 	// If the shard is child shard, need to wait until the parent finished.
 	if err := sc.waitOnParentShard(shard); err != nil {
-		// If parent shard has been deleted by Kinesis system already, just ignore the error.
-		if err != ErrSequenceIDNotFound {
-			log.Errorf("Error in waiting for parent shard: %v to finish. Error: %+v", shard.ParentShardId, err)
-			return err
-		}
+		log.Errorf("Error in waiting for parent shards: %v to finish. Error: %+v", shard.ParentShardIDs(), err)
+		return err
 	}
 
-	shardIterator, err := sc.getShardIterator(shard)
+	shardIterator, err := sc.startReading(shard)
 	if err != nil {
-		log.Errorf("Unable to get shard iterator for %s: %v", shard.ID, err)
+		// the lease is released, the shard is picked up again by the next lease assignment
+		util.EmitEvent(sc.kclConfig.EventListener, util.WARNING, util.EVENT_SHARD_ITERATOR_FAILED, shard.ID,
+			fmt.Sprintf("unable to get shard iterator, releasing the lease: %v", err))
 		return err
 	}
+	if sc.subscription != nil {
+		defer sc.subscription.close()
+	}
+
+	sc.ctx, sc.cancelCtx = sc.stopContext()
+	defer sc.cancelCtx()
 
 	// Start processing events and notify record processor on shard and starting checkpoint
 	input := &InitializationInput{
 		ShardId:                shard.ID,
 		ExtendedSequenceNumber: &ExtendedSequenceNumber{SequenceNumber: aws.String(shard.Checkpoint)},
+		HashKeyRange:           shard.HashKeyRange,
+		Context:                sc.ctx,
 	}
 	sc.recordProcessor.Initialize(input)
+	warmUpEnd := time.Now().Add(time.Duration(sc.kclConfig.ProcessorWarmUpMillis) * time.Millisecond)
 
 	recordCheckpointer := record.NewRecordProcessorCheckpoint(shard, sc.checkpointer)
+	if sc.kclConfig.StrictCheckpointMonotonicity {
+		recordCheckpointer = record.NewStrictRecordProcessorCheckpoint(shard, sc.checkpointer)
+	}
+	if !sc.kclConfig.AllowPrematureShardEnd {
+		recordCheckpointer = &shardEndGuard{IRecordProcessorCheckpointer: recordCheckpointer, shard: shard}
+	}
+	var deferring *deferringCheckpointer
+	if sc.kclConfig.CheckpointMinRecords > 0 {
+		deferring = &deferringCheckpointer{
+			IRecordProcessorCheckpointer: recordCheckpointer,
+			minRecords:                   sc.kclConfig.CheckpointMinRecords,
+			maxDelay:                     time.Duration(sc.kclConfig.CheckpointMaxDelayMillis) * time.Millisecond,
+		}
+		recordCheckpointer = deferring
+	}
 	retriedErrors := 0
+	nearingTrim := false
+	var lastProcessed *kinesis.Record
+	// sequence number of the first record fetched, an expired iterator of a shard without checkpoint resumes at it
+	var firstFetched string
+	// reads the remainder of a partially processed batch again, nil otherwise
+	var retryIterator *string
+	failedAttempts := 0
+	sc.subSequenceNumbers = make(map[*kinesis.Record]int64)
+	sc.backpressure = newBackpressureDetector(sc.kclConfig)
+	sc.autoCheckpoint = newAutoCheckpointer(sc.kclConfig)
+	sc.inFlight = &inFlightBatches{max: sc.kclConfig.MaxInFlightBatches}
+	sc.outstanding = &outstandingRecords{}
+	sc.batching = &batchingWindow{
+		maxRecords: sc.kclConfig.BatchingWindowMaxRecords,
+		maxWait:    time.Duration(sc.kclConfig.BatchingWindowMillis) * time.Millisecond,
+	}
+	paused := false
+	lastDelivery := time.Now()
+	emptyBatchInterval := time.Duration(sc.kclConfig.EmptyBatchIntervalMillis) * time.Millisecond
+	var lastLagSnapshot time.Time
+	renewalFailures := 0
+
+	if sc.kclConfig.StuckShardTimeoutMillis > 0 {
+		sc.watchdog = newWatchdog(time.Duration(sc.kclConfig.StuckShardTimeoutMillis)*time.Millisecond, time.Now())
+		watchdogDone := make(chan struct{})
+		defer close(watchdogDone)
+		go sc.runWatchdog(shard, watchdogDone)
+	}
 
 	for {
 		getRecordsStartTime := time.Now()
-		if time.Now().UTC().After(shard.LeaseTimeout.Add(-5 * time.Second)) {
+		if !sc.follower && sc.leaseRenewalDue(shard, time.Now()) {
 			log.Debugf("Refreshing lease on shard: %s for worker: %s", shard.ID, sc.consumerID)
 			err = sc.checkpointer.GetLease(shard, sc.consumerID)
 			if err != nil {
 				if err.Error() == ErrLeaseNotAquired {
+					// the lease expired or was stolen, the new owner processes the shard from the last checkpoint
 					log.Warnf("Failed in acquiring lease on shard: %s for worker: %s", shard.ID, sc.consumerID)
+					sc.leaseLost = true
+					sc.cancelCtx()
+					sc.reportLeaseLost(shard)
+					sc.shutdownProcessor(shard, util.ZOMBIE, recordCheckpointer, lastProcessed)
 					return nil
 				}
-				// log and return error
-				log.Errorf("Error in refreshing lease on shard: %s for worker: %s. Error: %+v",
-					shard.ID, sc.consumerID, err)
-				return err
+				renewalFailures++
+				sc.mService.IncrLeaseRenewalFailures(shard.ID)
+				if renewalFailures > sc.kclConfig.LeaseRenewalFailureTolerance ||
+					time.Now().UTC().After(shard.LeaseTimeout.Add(-leaseRenewalMargin)) {
+					// log and return error
+					log.Errorf("Error in refreshing lease on shard: %s for worker: %s. Error: %+v",
+						shard.ID, sc.consumerID, err)
+					sc.shutdownProcessor(shard, util.REQUESTED, recordCheckpointer, lastProcessed)
+					return err
+				}
+				log.Warnf("Failed to refresh lease on shard: %s for worker: %s, %d consecutive failures. Error: %+v",
+					shard.ID, sc.consumerID, renewalFailures, err)
+			} else {
+				renewalFailures = 0
+				sc.mService.LeaseRenewed(shard.ID)
+			}
+		}
+
+		// write the checkpoint deferred for too long
+		if deferring != nil {
+			if err := deferring.flushDue(time.Now()); err != nil {
+				log.Errorf("Failed to write the deferred checkpoint of shard %s: %+v", shard.ID, err)
+			}
+		}
+
+		// pause fetching while too many batches are waiting for a checkpoint
+		shard.Mux.Lock()
+		checkpoint := shard.Checkpoint
+		shard.Mux.Unlock()
+		if sc.inFlight.full(checkpoint) {
+			if !paused {
+				log.Infof("Pausing shard %s, %d batches are in flight", shard.ID, sc.inFlight.max)
+				paused = true
+			}
+			select {
+			case <-*sc.stop:
+				sc.shutdownProcessor(shard, util.REQUESTED, recordCheckpointer, lastProcessed)
+				return nil
+			case <-time.After(time.Duration(sc.kclConfig.IdleTimeBetweenReadsInMillis) * time.Millisecond):
 			}
+			continue
+		}
+		if paused {
+			log.Infof("Resuming shard %s", shard.ID)
+			paused = false
 		}
 
-		log.Debugf("Trying to read %d record from iterator: %v", sc.kclConfig.MaxRecords, aws.StringValue(shardIterator))
+		maxRecords := sc.maxRecords(shard)
+		log.Debugf("Trying to read %d record from iterator: %v", maxRecords, aws.StringValue(shardIterator))
 		getRecordsArgs := &kinesis.GetRecordsInput{
-			Limit:         aws.Int64(int64(sc.kclConfig.MaxRecords)),
+			Limit:         aws.Int64(int64(maxRecords)),
 			ShardIterator: shardIterator,
 		}
 		// Get record from stream and retry as needed
-		getResp, err := sc.kc.GetRecords(getRecordsArgs)
+		var getResp *kinesis.GetRecordsOutput
+		if sc.subscription != nil {
+			getResp, err = sc.subscription.next(*sc.stop)
+		} else {
+			getResp, err = sc.kc.GetRecords(getRecordsArgs)
+		}
 		if err != nil {
 			if awsErr, ok := err.(awserr.Error); ok {
+				if awsErr.Code() == kinesis.ErrCodeExpiredIteratorException {
+					sc.mService.IncrExpiredIterators(shard.ID)
+					if sc.kclConfig.ExpiredIteratorPolicy == goKCL.FAIL_SHARD {
+						log.Errorf("Shard iterator of %s expired, stop consuming the shard: %+v", shard.ID, err)
+						sc.shutdownProcessor(shard, util.REQUESTED, recordCheckpointer, lastProcessed)
+						return util.InvalidStateError.MakeErr().WithDetail("shard iterator expired").WithCause(err)
+					}
+
+					log.Warnf("Shard iterator of %s expired, refreshing it from checkpoint: %v", shard.ID, shard.Checkpoint)
+					// the record of the pending batch are fetched again from the checkpoint
+					sc.batching.reset()
+					shardIterator, err = sc.refreshShardIterator(shard, firstFetched)
+					if err != nil {
+						log.Errorf("Unable to refresh shard iterator for %s: %v", shard.ID, err)
+						return err
+					}
+					continue
+				}
+
 				if awsErr.Code() == kinesis.ErrCodeProvisionedThroughputExceededException || awsErr.Code() == ErrCodeKMSThrottlingException {
 					log.Errorf("Error getting record from shard %v: %+v", shard.ID, err)
+					shard.recordThrottle()
+					if !sc.retryBudget.Acquire() {
+						log.Errorf("Retry budget exhausted, giving up on shard %v", shard.ID)
+						return util.ThrottlingError.MakeErr().WithDetail("retry budget exhausted").WithCause(err)
+					}
 					retriedErrors++
-					// exponential backoff
+					// exponential backoff with full jitter, so that the consumers throttled together don't retry together
 					// https://docs.aws.amazon.com/amazondynamodb/latest/developerguide/Programming.Errors.html#Programming.Errors.RetryAndBackoff
-					time.Sleep(time.Duration(math.Exp2(float64(retriedErrors))*100) * time.Millisecond)
+					time.Sleep(sc.kclConfig.RetryBackoff.Wait(retriedErrors))
 					continue
 				}
 			}
@@ -207,12 +776,65 @@ func (sc *Consumer) GetRecords(shard *Status) error {
 
 		// reset the retry count after success
 		retriedErrors = 0
+		if firstFetched == "" && len(getResp.Records) > 0 {
+			firstFetched = aws.StringValue(getResp.Records[0].SequenceNumber)
+		}
+		shard.recordFetch(getResp.NextShardIterator, getResp.Records, aws.Int64Value(getResp.MillisBehindLatest),
+			time.Now())
+
+		// warn once whenever the shard starts nearing the trim horizon
+		nearing := sc.nearingTrim(aws.Int64Value(getResp.MillisBehindLatest))
+		if nearing && !nearingTrim {
+			log.Warnf("Shard %s is %d ms behind, its record are nearing the retention period %v and will be trimmed",
+				shard.ID, aws.Int64Value(getResp.MillisBehindLatest), sc.retentionPeriod)
+		}
+		nearingTrim = nearing
+
+		lagSnapshotInterval := time.Duration(sc.kclConfig.LagSnapshotIntervalMillis) * time.Millisecond
+		if sc.lagRecorder != nil && time.Since(lastLagSnapshot) >= lagSnapshotInterval {
+			if err := sc.lagRecorder.RecordLag(shard.ID, aws.Int64Value(getResp.MillisBehindLatest)); err != nil {
+				log.Warnf("Failed to snapshot the lag of shard %s: %+v", shard.ID, err)
+			}
+			lastLagSnapshot = time.Now()
+		}
+
+		if sc.watchdog != nil {
+			sc.watchdog.recordsReceived(len(getResp.Records), aws.Int64Value(getResp.MillisBehindLatest), time.Now())
+		}
+
+		if sc.backpressure.observe(len(getResp.Records), maxRecords, time.Now()) {
+			log.Warnf("Backpressure on shard %s: %v", shard.ID, sc.backpressure.active)
+			sc.mService.Backpressure(shard.ID, sc.backpressure.active)
+		}
+
+		records, err := sc.prepareRecords(shard, getResp.Records)
+		if err != nil {
+			log.Errorf("Stop consuming shard %s on invalid record: %+v", shard.ID, err)
+			sc.shutdownProcessor(shard, util.REQUESTED, recordCheckpointer, lastProcessed)
+			return err
+		}
+		records = sc.suppressDuplicates(shard, records, lastProcessed)
+
+		// accumulate the record in the batching window until it is full or elapsed, the end of the shard delivers
+		// the pending batch
+		deliver := true
+		if sc.batching.enabled() {
+			sc.batching.add(records, time.Now())
+			if getResp.NextShardIterator == nil || sc.batching.ready(time.Now()) {
+				records = sc.batching.take()
+			} else {
+				records = nil
+				deliver = false
+			}
+		}
 
 		// IRecordProcessorCheckpointer
 		input := &record.ProcessRecordsInput{
-			Records:            getResp.Records,
+			Records:            records,
 			MillisBehindLatest: aws.Int64Value(getResp.MillisBehindLatest),
 			Checkpointer:       recordCheckpointer,
+			SubSequenceNumbers: sc.deliveredSubSequenceNumbers(records),
+			Context:            sc.processorContext(),
 		}
 
 		recordLength := len(input.Records)
@@ -223,20 +845,124 @@ func (sc *Consumer) GetRecords(shard *Status) error {
 			recordBytes += int64(len(r.Data))
 		}
 
-		if recordLength > 0 || sc.kclConfig.CallProcessRecordsEvenForEmptyRecordList {
+		// time driven record processors get an empty batch every interval without record
+		tick := emptyBatchInterval > 0 && time.Since(lastDelivery) >= emptyBatchInterval
+
+		if deliver && (recordLength > 0 || sc.kclConfig.CallProcessRecordsEvenForEmptyRecordList || tick) {
 			processRecordsStartTime := time.Now()
+			lastDelivery = processRecordsStartTime
+
+			// age of the record at delivery time
+			for _, r := range input.Records {
+				if eventTime, ok := record.EventTime(r, sc.kclConfig.RecordTimestampExtractor); ok {
+					age := processRecordsStartTime.Sub(eventTime)
+					sc.mService.RecordAge(shard.ID, float64(age/time.Millisecond))
+					shard.advanceWatermark(eventTime)
+				}
+			}
+
+			shard.Mux.Lock()
+			checkpointBefore := shard.Checkpoint
+			shard.Mux.Unlock()
 
 			// Delivery the events to the record processor
-			sc.recordProcessor.ProcessRecords(input)
+			var result record.ProcessRecordsResult
+			if processor, ok := sc.orderIndependentProcessor(); ok {
+				sc.processConcurrently(shard, processor, input)
+			} else {
+				result = sc.processRecords(input, processRecordsStartTime, warmUpEnd)
+			}
+			if result.Failed {
+				processed := sc.partiallyProcessed(shard, input, result, recordCheckpointer)
+				if processed > 0 {
+					lastProcessed = input.Records[processed-1]
+				}
+				if processed < recordLength {
+					wait := time.Duration(sc.kclConfig.TaskBackoffTimeMillis) * time.Millisecond
+					retryIterator, err = sc.redeliver(shard, input.Records[processed:], wait)
+					if err != nil {
+						log.Errorf("Unable to deliver the remainder of the batch of shard %s again: %v", shard.ID, err)
+						return err
+					}
+				}
+			} else if sc.retriesFailure(input) {
+				failedAttempts++
+				if util.IsRetryable(input.Err()) && failedAttempts < sc.kclConfig.RetryBackoff.Attempts {
+					log.Warnf("Record processor failed batch of shard %s, delivering it again (attempt %d): %v",
+						shard.ID, failedAttempts, input.Err())
+					retryIterator, err = sc.redeliver(shard, input.Records, sc.kclConfig.RetryBackoff.Wait(failedAttempts))
+					if err != nil {
+						log.Errorf("Unable to deliver the failed batch of shard %s again: %v", shard.ID, err)
+						return err
+					}
+				} else {
+					if err := sc.abandonBatch(shard, input, failedAttempts, lastProcessed); err != nil {
+						return err
+					}
+					failedAttempts = 0
+					lastProcessed = input.Records[recordLength-1]
+				}
+			} else if recordLength > 0 {
+				failedAttempts = 0
+				lastProcessed = input.Records[recordLength-1]
+			}
+			sc.forgetSubSequenceNumbers(lastProcessed)
+			sc.inFlight.delivered(input.Records)
+			sc.outstanding.delivered(input.Records)
+			if deferring != nil {
+				deferring.delivered(recordLength)
+			}
 
 			// Convert from nanoseconds to milliseconds
 			processedRecordsTiming := time.Since(processRecordsStartTime) / 1000000
 			sc.mService.RecordProcessRecordsTime(shard.ID, float64(processedRecordsTiming))
+
+			if timeout := sc.kclConfig.ProcessRecordsTimeoutMillis; timeout > 0 && int(processedRecordsTiming) > timeout {
+				if time.Now().Before(warmUpEnd) {
+					log.Infof("Processing record of shard %s took %d ms during warm-up", shard.ID, processedRecordsTiming)
+				} else {
+					log.Errorf("Processing record of shard %s took %d ms, giving up the shard", shard.ID, processedRecordsTiming)
+					sc.shutdownProcessor(shard, util.REQUESTED, recordCheckpointer, lastProcessed)
+					return util.KinesisClientLibError.MakeErr().
+						WithDetail("processing record took %d ms, longer than the timeout of %d ms", processedRecordsTiming, timeout)
+				}
+			}
+
+			if sc.autoCheckpoint.enabled() && recordLength > 0 {
+				shard.Mux.Lock()
+				checkpointed := shard.Checkpoint != checkpointBefore
+				shard.Mux.Unlock()
+				switch {
+				case result.Failed, sc.retriesFailure(input):
+					// checkpointed at the last successful record, or past the abandoned batch, already
+				case input.Err() != nil:
+					log.Warnf("Record processor failed batch of shard %s, suspending auto checkpoints: %v", shard.ID,
+						input.Err())
+					sc.autoCheckpoint.failed()
+				case checkpointed:
+					sc.autoCheckpoint.checkpointed(time.Now())
+				case sc.stopping():
+					// the shutdown checkpoints the last processed record, if configured
+				default:
+					if r := sc.autoCheckpoint.checkpointAt(input.Records, time.Now()); r != nil {
+						if err := recordCheckpointer.Checkpoint(r.SequenceNumber); err != nil {
+							log.Errorf("Failed to auto checkpoint shard %s at %s: %+v", shard.ID, aws.StringValue(r.SequenceNumber), err)
+						}
+					}
+				}
+			}
 		}
 
 		sc.mService.IncrRecordsProcessed(shard.ID, recordLength)
 		sc.mService.IncrBytesProcessed(shard.ID, recordBytes)
 		sc.mService.MillisBehindLatest(shard.ID, float64(*getResp.MillisBehindLatest))
+		sc.mService.ConsumerUptime(shard.ID, shard.GetConsumerUptime(time.Now()).Seconds())
+		sc.mService.ProcessingThroughput(shard.ID,
+			shard.recordThroughput(recordLength, time.Now(), sc.kclConfig.ThroughputSmoothingFactor))
+		shard.Mux.Lock()
+		checkpoint = shard.Checkpoint
+		shard.Mux.Unlock()
+		sc.mService.UncheckpointedRecords(shard.ID, sc.outstanding.count(checkpoint))
 
 		// Convert from nanoseconds to milliseconds
 		getRecordsTime := time.Since(getRecordsStartTime) / 1000000
@@ -245,63 +971,469 @@ func (sc *Consumer) GetRecords(shard *Status) error {
 		// Idle between each read, the user is responsible for checkpoint the progress
 		// This value is only used when no record are returned; if record are returned, it should immediately
 		// retrieve the next set of record.
-		if recordLength == 0 && aws.Int64Value(getResp.MillisBehindLatest) < int64(sc.kclConfig.IdleTimeBetweenReadsInMillis) {
+		// The subscription of enhanced fan-out waits for the record itself.
+		if sc.subscription == nil && recordLength == 0 &&
+			aws.Int64Value(getResp.MillisBehindLatest) < int64(sc.kclConfig.IdleTimeBetweenReadsInMillis) {
 			time.Sleep(time.Duration(sc.kclConfig.IdleTimeBetweenReadsInMillis) * time.Millisecond)
 		}
 
-		// The shard has been closed, so no new record can be read from it
-		if getResp.NextShardIterator == nil {
+		// The shard has been closed, so no new record can be read from it, once the remainder of a partially
+		// processed batch has been
+		if getResp.NextShardIterator == nil && retryIterator == nil {
 			log.Infof("Shard %s closed", shard.ID)
-			shutdownInput := &util.ShutdownInput{ShutdownReason: util.TERMINATE, Checkpointer: recordCheckpointer}
-			sc.recordProcessor.Shutdown(shutdownInput)
+			shard.markShardEndReached()
+			sc.shutdownProcessor(shard, util.TERMINATE, recordCheckpointer, lastProcessed)
 			return nil
 		}
 		shardIterator = getResp.NextShardIterator
+		if retryIterator != nil {
+			// the remainder of a partially processed batch is read again
+			shardIterator, retryIterator = retryIterator, nil
+		}
+
+		if shard.takeLeaseReleaseRequest() {
+			log.Infof("Releasing the lease of shard %s as requested", shard.ID)
+			sc.shutdownProcessor(shard, util.REQUESTED, recordCheckpointer, lastProcessed)
+			return nil
+		}
 
 		select {
 		case <-*sc.stop:
-			shutdownInput := &util.ShutdownInput{ShutdownReason: util.REQUESTED, Checkpointer: recordCheckpointer}
-			sc.recordProcessor.Shutdown(shutdownInput)
+			sc.shutdownProcessor(shard, util.REQUESTED, recordCheckpointer, lastProcessed)
 			return nil
 		case <-time.After(1 * time.Nanosecond):
 		}
 	}
 }
 
-// Need to wait until the parent shard finished
-func (sc *Consumer) waitOnParentShard(shard *Status) error {
-	if len(shard.ParentShardId) == 0 {
-		return nil
+// shutdownProcessor shuts the record processor down for the given reason, running the shutdown steps in the
+// configured order. The lease is released last, when the consumer exits. If configured for the reason, the last
+// processed record is checkpointed. A deferred checkpoint is written unless the lease was lost.
+func (sc *Consumer) shutdownProcessor(shard *Status, reason util.ShutdownReason,
+	checkpointer record.IRecordProcessorCheckpointer, lastProcessed *kinesis.Record) {
+	sc.shutdownReason = reason
+	for _, step := range sc.kclConfig.ShutdownOrder {
+		switch step {
+		case util.STOP_FETCHING:
+			sc.shutdownStep(shard, step, func() {
+				if sc.subscription != nil {
+					sc.subscription.close()
+				}
+			})
+		case util.CHECKPOINT:
+			sc.shutdownStep(shard, step, func() {
+				sc.shutdownCheckpoint(shard, reason, checkpointer, lastProcessed)
+			})
+		case util.SHUTDOWN_PROCESSOR:
+			sc.shutdownStep(shard, step, func() {
+				sc.recordProcessor.Shutdown(&util.ShutdownInput{
+					ShutdownReason: reason,
+					Checkpointer:   checkpointer,
+					Context:        sc.processorContext(),
+				})
+			})
+		}
 	}
+}
 
-	pshard := &Status{
-		ID:  shard.ParentShardId,
-		Mux: &sync.Mutex{},
+// shutdownCheckpoint writes the deferred checkpoint and, if configured for the reason, checkpoints the last
+// processed record. A shard the record processor checkpointed at SHARD_END while shutting down isn't checkpointed
+// back.
+func (sc *Consumer) shutdownCheckpoint(shard *Status, reason util.ShutdownReason,
+	checkpointer record.IRecordProcessorCheckpointer, lastProcessed *kinesis.Record) {
+	if deferring, ok := checkpointer.(*deferringCheckpointer); ok && reason != util.ZOMBIE {
+		if err := deferring.force(); err != nil {
+			log.Errorf("Failed to write the deferred checkpoint of shard %s on shutdown: %+v", shard.ID, err)
+		}
 	}
 
-	for {
-		if err := sc.checkpointer.FetchCheckpoint(pshard); err != nil {
-			return err
+	shard.Mux.Lock()
+	finished := shard.Checkpoint == SHARD_END
+	// e.g. auto-checkpointed already
+	checkpointed := lastProcessed != nil && shard.Checkpoint == aws.StringValue(lastProcessed.SequenceNumber) &&
+		shard.CheckpointSubSequenceNumber == 0
+	shard.Mux.Unlock()
+	if lastProcessed != nil && !finished && !checkpointed && checkpointOnShutdown(sc.kclConfig, reason) {
+		if err := checkpointer.Checkpoint(lastProcessed.SequenceNumber); err != nil {
+			log.Errorf("Failed to checkpoint shard %s at %s on shutdown: %+v", shard.ID,
+				aws.StringValue(lastProcessed.SequenceNumber), err)
 		}
+	}
+}
 
-		// Parent shard is finished.
-		if pshard.Checkpoint == SHARD_END {
-			return nil
+// stopping returns true once the worker is shutting down.
+func (sc *Consumer) stopping() bool {
+	select {
+	case <-*sc.stop:
+		return true
+	default:
+		return false
+	}
+}
+
+// shutdownStep runs a step of the shutdown between the calls of the shutdown hook, if any. The hook isn't called
+// unless the record processor is being shut down, e.g. for the lease released by a consumer failing.
+func (sc *Consumer) shutdownStep(shard *Status, step util.ShutdownStep, run func()) {
+	hook := sc.kclConfig.ShutdownHook
+	if hook == nil || sc.shutdownReason == 0 {
+		run()
+		return
+	}
+
+	hook.BeforeShutdownStep(shard.ID, sc.shutdownReason, step)
+	run()
+	hook.AfterShutdownStep(shard.ID, sc.shutdownReason, step)
+}
+
+// processRecords delivers the batch to the record processor. A record.IContextRecordProcessor or a
+// record.IPartialFailureRecordProcessor gets a context expiring with the processing timeout of the batch, unless it
+// is warming up.
+func (sc *Consumer) processRecords(input *record.ProcessRecordsInput, start,
+	warmUpEnd time.Time) record.ProcessRecordsResult {
+	partial, partialFailure := sc.recordProcessor.(record.IPartialFailureRecordProcessor)
+	processor, withContext := sc.recordProcessor.(record.IContextRecordProcessor)
+	if !partialFailure && !withContext {
+		sc.recordProcessor.ProcessRecords(input)
+		return record.ProcessRecordsResult{}
+	}
+
+	ctx := sc.processorContext()
+	if timeout := sc.kclConfig.ProcessRecordsTimeoutMillis; timeout > 0 && !start.Before(warmUpEnd) {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithDeadline(ctx, start.Add(time.Duration(timeout)*time.Millisecond))
+		defer cancel()
+	}
+	input.Context = ctx
+	if partialFailure {
+		return partial.ProcessRecordsWithResult(input)
+	}
+	processor.ProcessRecordsWithContext(ctx, input)
+	return record.ProcessRecordsResult{}
+}
+
+// partiallyProcessed checkpoints a partially processed batch at its last successful record, and returns how many
+// record of the batch were processed. An unknown last successful record counts as none.
+func (sc *Consumer) partiallyProcessed(shard *Status, input *record.ProcessRecordsInput,
+	result record.ProcessRecordsResult, checkpointer record.IRecordProcessorCheckpointer) int {
+	if result.LastSuccessfulSequenceNumber == nil {
+		log.Warnf("Record processor failed the whole batch of shard %s", shard.ID)
+		return 0
+	}
+
+	lastSuccessful := aws.StringValue(result.LastSuccessfulSequenceNumber)
+	for i, r := range input.Records {
+		if aws.StringValue(r.SequenceNumber) != lastSuccessful ||
+			input.SubSequenceNumbers[r] != result.LastSuccessfulSubSequenceNumber {
+			continue
+		}
+		log.Warnf("Record processor failed the batch of shard %s after %s, %d record to deliver again", shard.ID,
+			lastSuccessful, len(input.Records)-i-1)
+		err := checkpointer.CheckpointSequenceWithSubSequence(lastSuccessful, result.LastSuccessfulSubSequenceNumber)
+		if err != nil {
+			log.Errorf("Failed to checkpoint shard %s at %s: %+v", shard.ID, lastSuccessful, err)
 		}
+		return i + 1
+	}
+
+	log.Warnf("Record processor failed the batch of shard %s after %s, which isn't in the batch, delivering the whole "+
+		"batch again", shard.ID, lastSuccessful)
+	return 0
+}
+
+// redeliver returns the shard iterator reading the given record of a failed batch again, after the given wait. The
+// record pending in the batching window are read again too. With enhanced fan-out, the subscription is restarted at
+// the record instead, whose sequence number stands for the iterator.
+func (sc *Consumer) redeliver(shard *Status, remainder []*kinesis.Record, wait time.Duration) (*string, error) {
+	sc.batching.reset()
+	time.Sleep(wait)
+	position := &kinesis.StartingPosition{
+		Type:           aws.String(kinesis.ShardIteratorTypeAtSequenceNumber),
+		SequenceNumber: remainder[0].SequenceNumber,
+	}
+	if sc.subscription != nil {
+		sc.subscription.restartAt(position)
+		return position.SequenceNumber, nil
+	}
+	return sc.acquireShardIterator(sc.shardIteratorInput(shard, position))
+}
+
+// retriesFailure returns true if the record processor failed the batch with ProcessRecordsInput.Fail, and the
+// ProcessingFailurePolicy says how to deal with it.
+func (sc *Consumer) retriesFailure(input *record.ProcessRecordsInput) bool {
+	return input.Err() != nil && len(input.Records) > 0 && sc.kclConfig.ProcessingFailurePolicy != 0
+}
+
+// abandonBatch applies the ProcessingFailurePolicy to a batch which failed for good after the given number of
+// attempts. Unless the policy is STOP, the batch is checkpointed as if it had been processed. With STOP, the record
+// processor is shut down, lastProcessed being the last record processed before the batch.
+func (sc *Consumer) abandonBatch(shard *Status, input *record.ProcessRecordsInput, attempts int,
+	lastProcessed *kinesis.Record) error {
+	failure := input.Err()
+	switch sc.kclConfig.ProcessingFailurePolicy {
+	case record.STOP:
+		log.Errorf("Record processor failed batch of shard %s after %d attempts, stop consuming the shard: %+v",
+			shard.ID, attempts, failure)
+		sc.shutdownProcessor(shard, util.REQUESTED, input.Checkpointer, lastProcessed)
+		return util.KinesisClientLibNonRetryableException.MakeErr().
+			WithDetail("processing record failed after %d attempts", attempts).WithCause(failure)
+	case record.DEAD_LETTER:
+		if sc.kclConfig.DeadLetterHandler == nil {
+			log.Warnf("No dead-letter handler configured, dropping failed batch of shard %s", shard.ID)
+			break
+		}
+		for _, r := range input.Records {
+			sc.kclConfig.DeadLetterHandler.DeadLetter(
+				record.NewDeadLetterInput(shard.ID, r, attempts, failure, util.KinesisClientLibNonRetryableException))
+		}
+	default:
+		log.Warnf("Dropping batch of shard %s failed after %d attempts: %+v", shard.ID, attempts, failure)
+	}
+
+	last := input.Records[len(input.Records)-1]
+	err := input.Checkpointer.CheckpointSequenceWithSubSequence(aws.StringValue(last.SequenceNumber),
+		input.SubSequenceNumbers[last])
+	if err != nil {
+		log.Errorf("Failed to checkpoint shard %s at %s: %+v", shard.ID, aws.StringValue(last.SequenceNumber), err)
+	}
+	return nil
+}
+
+// nearingTrim returns true if the consumer is so far behind that the record it reads are about to be trimmed,
+// i.e. past 90% of the retention period of the stream.
+func (sc *Consumer) nearingTrim(millisBehindLatest int64) bool {
+	if sc.retentionPeriod <= 0 {
+		return false
+	}
+	return time.Duration(millisBehindLatest)*time.Millisecond > sc.retentionPeriod*9/10
+}
+
+// prepareRecords applies the record transformations of the library before the record are delivered to the
+// record processor. In raw mode the record are delivered exactly as returned by GetRecords.
+func (sc *Consumer) prepareRecords(shard *Status, records []*kinesis.Record) ([]*kinesis.Record, error) {
+	if sc.kclConfig.DeliverRawRecords {
+		return records, nil
+	}
+	if sc.kclConfig.EnableAggregation {
+		var err error
+		if records, err = sc.deaggregateRecords(shard, records); err != nil {
+			return nil, err
+		}
+	}
+	return sc.validateRecords(shard, records)
+}
+
+// deaggregateRecords expands the record aggregated by the Kinesis Producer Library into their user record and
+// keeps their sub-sequence numbers. The user record at or behind a checkpoint within their aggregated record are
+// dropped, they were processed before the shard was resumed from it.
+func (sc *Consumer) deaggregateRecords(shard *Status, records []*kinesis.Record) ([]*kinesis.Record, error) {
+	userRecords, subSequenceNumbers, err := record.DeaggregateUserRecords(records)
+	if err != nil {
+		return nil, util.KinesisClientLibError.MakeErr().WithDetail("invalid aggregated record").WithCause(err)
+	}
+
+	shard.Mux.Lock()
+	checkpoint, checkpointSubSequence := shard.Checkpoint, shard.CheckpointSubSequenceNumber
+	shard.Mux.Unlock()
+
+	kept := userRecords[:0]
+	for _, r := range userRecords {
+		subSequence, ok := subSequenceNumbers[r]
+		if !ok {
+			kept = append(kept, r)
+			continue
+		}
+		if aws.StringValue(r.SequenceNumber) == checkpoint && subSequence <= checkpointSubSequence {
+			continue
+		}
+		if sc.subSequenceNumbers == nil {
+			sc.subSequenceNumbers = make(map[*kinesis.Record]int64)
+		}
+		sc.subSequenceNumbers[r] = subSequence
+		kept = append(kept, r)
+	}
+	return kept, nil
+}
+
+// deliveredSubSequenceNumbers returns the sub-sequence numbers of the user record about to be delivered, nil if
+// none of them was aggregated.
+func (sc *Consumer) deliveredSubSequenceNumbers(records []*kinesis.Record) map[*kinesis.Record]int64 {
+	var delivered map[*kinesis.Record]int64
+	for _, r := range records {
+		if subSequence, ok := sc.subSequenceNumbers[r]; ok {
+			if delivered == nil {
+				delivered = make(map[*kinesis.Record]int64)
+			}
+			delivered[r] = subSequence
+		}
+	}
+	return delivered
+}
+
+// forgetSubSequenceNumbers drops the sub-sequence numbers of the delivered or discarded user record once a batch is
+// delivered, but the one of the last record delivered, which duplicates are compared against.
+func (sc *Consumer) forgetSubSequenceNumbers(lastProcessed *kinesis.Record) {
+	if len(sc.subSequenceNumbers) == 0 {
+		return
+	}
+	last, ok := sc.subSequenceNumbers[lastProcessed]
+	sc.subSequenceNumbers = make(map[*kinesis.Record]int64)
+	if ok {
+		sc.subSequenceNumbers[lastProcessed] = last
+	}
+}
+
+// suppressDuplicates drops the record at or behind the last record delivered to the record processor, or pending in
+// the batching window, if the DuplicateRecordPolicy says so. Sequence numbers only increase within a shard, so such
+// record have been fetched again, e.g. after a shard iterator refresh.
+func (sc *Consumer) suppressDuplicates(shard *Status, records []*kinesis.Record, lastProcessed *kinesis.Record) []*kinesis.Record {
+	if sc.kclConfig.DuplicateRecordPolicy != goKCL.SUPPRESS_DUPLICATES {
+		return records
+	}
+
+	// the user record of an aggregated record share its sequence number, they are ordered by sub-sequence number
+	last, lastSubSequence := "", int64(0)
+	if pending := sc.batching.last(); pending != nil {
+		last, lastSubSequence = aws.StringValue(pending.SequenceNumber), sc.subSequenceNumbers[pending]
+	} else if lastProcessed != nil {
+		last, lastSubSequence = aws.StringValue(lastProcessed.SequenceNumber), sc.subSequenceNumbers[lastProcessed]
+	}
+
+	unique := make([]*kinesis.Record, 0, len(records))
+	for _, r := range records {
+		if last != "" {
+			c := CompareSequenceNumbers(aws.StringValue(r.SequenceNumber), last)
+			if c < 0 || c == 0 && sc.subSequenceNumbers[r] <= lastSubSequence {
+				continue
+			}
+		}
+		unique = append(unique, r)
+		last, lastSubSequence = aws.StringValue(r.SequenceNumber), sc.subSequenceNumbers[r]
+	}
+
+	if duplicates := len(records) - len(unique); duplicates > 0 {
+		log.Debugf("Suppressed %d duplicate record of shard %s", duplicates, shard.ID)
+		sc.mService.IncrDuplicateRecords(shard.ID, duplicates)
+	}
+	return unique
+}
 
-		time.Sleep(time.Duration(sc.kclConfig.ParentShardPollIntervalMillis) * time.Millisecond)
+// validateRecords runs the configured RecordValidator against the record and returns the ones which passed.
+// Invalid record are dropped or dead-lettered according to the InvalidRecordPolicy. An error is returned
+// if the policy is STOP.
+func (sc *Consumer) validateRecords(shard *Status, records []*kinesis.Record) ([]*kinesis.Record, error) {
+	if sc.kclConfig.RecordValidator == nil {
+		return records, nil
+	}
+
+	valid := make([]*kinesis.Record, 0, len(records))
+	invalid := 0
+	for _, r := range records {
+		err := sc.kclConfig.RecordValidator(r)
+		if err == nil {
+			valid = append(valid, r)
+			continue
+		}
+
+		invalid++
+		switch sc.kclConfig.InvalidRecordPolicy {
+		case record.STOP:
+			sc.mService.IncrInvalidRecords(shard.ID, invalid)
+			return nil, util.IllegalArgumentError.MakeErr().
+				WithDetail("invalid record %s", aws.StringValue(r.SequenceNumber)).WithCause(err)
+		case record.DEAD_LETTER:
+			if sc.kclConfig.DeadLetterHandler == nil {
+				log.Warnf("No dead-letter handler configured, dropping invalid record %s of shard %s",
+					aws.StringValue(r.SequenceNumber), shard.ID)
+				continue
+			}
+			sc.kclConfig.DeadLetterHandler.DeadLetter(record.NewDeadLetterInput(shard.ID, r, 1, err, util.IllegalArgumentError))
+		default:
+			log.Debugf("Dropping invalid record %s of shard %s: %+v", aws.StringValue(r.SequenceNumber), shard.ID, err)
+		}
+	}
+
+	if invalid > 0 {
+		sc.mService.IncrInvalidRecords(shard.ID, invalid)
+	}
+	return valid, nil
+}
+
+// Need to wait until the parent shards finished: checkpointed at SHARD_END, or without lease and no longer listed in
+// the stream, the rule the worker acquires the lease of the shard with.
+func (sc *Consumer) waitOnParentShard(shard *Status) error {
+	for _, parentID := range shard.ParentShardIDs() {
+		pshard := &Status{
+			ID:  parentID,
+			Mux: &sync.Mutex{},
+		}
+
+		for {
+			err := sc.checkpointer.FetchCheckpoint(pshard)
+			if err == ErrSequenceIDNotFound {
+				// Parent shard without lease is gone once it isn't listed anymore, as when the lease was acquired.
+				if sc.parentListed == nil || !sc.parentListed(parentID) {
+					break
+				}
+			} else if err != nil {
+				return err
+			} else if pshard.Checkpoint == SHARD_END {
+				// Parent shard is finished.
+				break
+			}
+
+			time.Sleep(time.Duration(sc.kclConfig.ParentShardPollIntervalMillis) * time.Millisecond)
+		}
+	}
+	return nil
+}
+
+// leaseRenewalDue returns true once the lease of the shard is to be renewed: within leaseRenewalMargin of its expiry,
+// or from the middle of the failover window on if renewal failures are tolerated, leaving room to retry them.
+func (sc *Consumer) leaseRenewalDue(shard *Status, now time.Time) bool {
+	margin := leaseRenewalMargin
+	if sc.kclConfig.LeaseRenewalFailureTolerance > 0 {
+		if half := time.Duration(sc.kclConfig.FailoverTimeMillis) * time.Millisecond / 2; half > margin {
+			margin = half
+		}
+	}
+	return now.UTC().After(shard.LeaseTimeout.Add(-margin))
+}
+
+// reportLeaseLost reports the lease of the shard taken over by another worker: it is counted, emitted as an event
+// naming the new owner, if known, and handed to the record processor if it is an ILeaseLostRecordProcessor.
+func (sc *Consumer) reportLeaseLost(shard *Status) {
+	// the lease is read aside, the checkpoint and the owner of the shard are the ones of this consumer until it exits
+	newOwner := ""
+	lease := &Status{ID: shard.ID, Mux: &sync.Mutex{}}
+	if err := sc.checkpointer.FetchCheckpoint(lease); err == nil {
+		if owner := lease.GetLeaseOwner(); owner != sc.consumerID {
+			newOwner = owner
+		}
+	} else if err != ErrSequenceIDNotFound {
+		log.Warnf("Unable to fetch the new owner of the lease of shard %s: %v", shard.ID, err)
+	}
+
+	sc.mService.IncrLeasesLost(shard.ID)
+	util.EmitEvent(sc.kclConfig.EventListener, util.WARNING, util.EVENT_LEASE_LOST, shard.ID,
+		fmt.Sprintf("lease of worker %s taken over by %q", sc.consumerID, newOwner))
+	if processor, ok := sc.recordProcessor.(record.ILeaseLostRecordProcessor); ok {
+		processor.LeaseLost(&record.LeaseLostInput{ShardID: shard.ID, NewOwner: newOwner})
 	}
 }
 
 // Cleanup the internal lease cache
 func (sc *Consumer) releaseLease(shard *Status) {
 	log.Infof("Release lease for shard %s", shard.ID)
-	shard.SetLeaseOwner("")
+	shard.Mux.Lock()
+	shard.changeLeaseOwner("", time.Now(), sc.kclConfig.LeaseOwnershipHistoryLength)
+	shard.Mux.Unlock()
 
-	// Release the lease by wiping out the lease owner for the shard
+	// Release the lease by wiping out the lease owner for the shard, unless the lease belongs to another worker now
 	// Note: we don't need to do anything in case of error here and shard lease will eventuall be expired.
-	if err := sc.checkpointer.RemoveLeaseOwner(shard.ID); err != nil {
-		log.Errorf("Failed to release shard lease or shard: %s Error: %+v", shard.ID, err)
+	if !sc.leaseLost {
+		if err := sc.checkpointer.RemoveLeaseOwner(shard.ID); err != nil {
+			log.Errorf("Failed to release shard lease or shard: %s Error: %+v", shard.ID, err)
+		}
 	}
 
 	// reporting lease lose metrics
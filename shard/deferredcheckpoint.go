@@ -0,0 +1,108 @@
+package shard
+
+import (
+	"sync"
+	"time"
+
+	"github.com/guygma/goKCL/record"
+)
+
+// deferringCheckpointer defers the checkpoints of the record processor until at least minRecords record have been
+// delivered since the last checkpoint written. Only the latest deferred checkpoint is kept, it is written once enough
+// record are delivered, maxDelay after it was first deferred, or when forced on shutdown. A zero minRecords writes
+// every checkpoint right away.
+type deferringCheckpointer struct {
+	record.IRecordProcessorCheckpointer
+	minRecords int
+	maxDelay   time.Duration
+
+	mux sync.Mutex
+	// record delivered since the last checkpoint written
+	records  int
+	deferred bool
+	pending  *string
+	// time the pending checkpoint was first deferred
+	since  time.Time
+	forced bool
+}
+
+// delivered counts the record delivered to the record processor.
+func (c *deferringCheckpointer) delivered(n int) {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	c.records += n
+}
+
+// Checkpoint writes the checkpoint if enough record have been delivered since the last one, defers it otherwise.
+// A checkpoint at the end of a closed shard is never deferred.
+func (c *deferringCheckpointer) Checkpoint(sequenceNumber *string) error {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+
+	if c.minRecords <= 0 || c.forced || sequenceNumber == nil || c.records >= c.minRecords {
+		return c.write(sequenceNumber)
+	}
+
+	if !c.deferred {
+		c.deferred = true
+		c.since = time.Now()
+	}
+	c.pending = sequenceNumber
+	return c.flushDueLocked(time.Now())
+}
+
+// CheckpointSequence writes the checkpoint right away, replacing any deferred one: an explicit sequence number is
+// typically the last record durably persisted by the processor, deferring it would only widen the replay on failover.
+func (c *deferringCheckpointer) CheckpointSequence(sequenceNumber string) error {
+	return c.CheckpointSequenceWithSubSequence(sequenceNumber, 0)
+}
+
+// CheckpointSequenceWithSubSequence writes the checkpoint right away, replacing any deferred one.
+func (c *deferringCheckpointer) CheckpointSequenceWithSubSequence(sequenceNumber string, subSequenceNumber int64) error {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+
+	if err := c.IRecordProcessorCheckpointer.CheckpointSequenceWithSubSequence(sequenceNumber, subSequenceNumber); err != nil {
+		return err
+	}
+	c.records = 0
+	c.deferred = false
+	c.pending = nil
+	return nil
+}
+
+// flushDue writes the pending checkpoint if it has been deferred for maxDelay.
+func (c *deferringCheckpointer) flushDue(now time.Time) error {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	return c.flushDueLocked(now)
+}
+
+func (c *deferringCheckpointer) flushDueLocked(now time.Time) error {
+	if !c.deferred || c.maxDelay <= 0 || now.Sub(c.since) < c.maxDelay {
+		return nil
+	}
+	return c.write(c.pending)
+}
+
+// force writes the pending checkpoint and every later one right away, e.g. before the record processor is shut down.
+func (c *deferringCheckpointer) force() error {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+
+	c.forced = true
+	if !c.deferred {
+		return nil
+	}
+	return c.write(c.pending)
+}
+
+func (c *deferringCheckpointer) write(sequenceNumber *string) error {
+	if err := c.IRecordProcessorCheckpointer.Checkpoint(sequenceNumber); err != nil {
+		return err
+	}
+	c.records = 0
+	c.deferred = false
+	c.pending = nil
+	return nil
+}
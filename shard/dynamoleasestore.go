@@ -0,0 +1,172 @@
+package shard
+
+import (
+	"errors"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+
+	"github.com/guygma/goKCL"
+	"github.com/guygma/goKCL/util"
+)
+
+// DynamoLeaseStore implements the LeaseStore interface with the lease table of DynamoCheckpoint, so that the leases
+// can be shared with the workers using DynamoCheckpoint.
+type DynamoLeaseStore struct {
+	checkpointer *DynamoCheckpoint
+}
+
+// NewDynamoLeaseStore returns the lease store of the lease table of the configuration.
+func NewDynamoLeaseStore(kclConfig *goKCL.KinesisClientLibConfiguration) *DynamoLeaseStore {
+	return &DynamoLeaseStore{checkpointer: NewDynamoCheckpoint(kclConfig)}
+}
+
+// WithDynamoDB is used to provide DynamoDB service
+func (store *DynamoLeaseStore) WithDynamoDB(svc dynamodbiface.DynamoDBAPI) *DynamoLeaseStore {
+	store.checkpointer.WithDynamoDB(svc)
+	return store
+}
+
+// Init creates the lease table if needed
+func (store *DynamoLeaseStore) Init() error {
+	return store.checkpointer.Init()
+}
+
+// GetLease retrieves the lease of the shard, nil if the shard has none
+func (store *DynamoLeaseStore) GetLease(shardID string) (*Lease, error) {
+	item, err := store.checkpointer.getItem(shardID)
+	if err != nil {
+		return nil, leaseStoreError(err)
+	}
+	if len(item) == 0 {
+		return nil, nil
+	}
+	return store.checkpointer.unmarshalLease(shardID, item), nil
+}
+
+// CreateLease creates the lease of a shard, it fails with ErrLeaseNotAquired if the shard already has one
+func (store *DynamoLeaseStore) CreateLease(lease *Lease) error {
+	err := store.checkpointer.conditionalUpdate("attribute_not_exists(#id)",
+		map[string]*string{"#id": aws.String(store.checkpointer.attributes.LeaseKey)}, nil, store.marshalLease(lease))
+	return leaseStoreError(err)
+}
+
+// RenewLease extends the lease until the lease timeout, it fails with ErrLeaseNotAquired if the lease isn't held by
+// its owner anymore
+func (store *DynamoLeaseStore) RenewLease(lease *Lease, leaseTimeout time.Time) error {
+	return store.updateLease(lease.ShardID, "#assigned_to = :assigned_to", "set #lease_timeout = :new_lease_timeout",
+		map[string]*dynamodb.AttributeValue{
+			":assigned_to":       {S: aws.String(lease.Owner)},
+			":new_lease_timeout": {S: aws.String(leaseTimeout.UTC().Format(time.RFC3339))},
+		})
+}
+
+// TakeLease assigns the lease to the new owner until the lease timeout, an empty owner releasing it. It fails with
+// ErrLeaseNotAquired if the owner or the lease timeout of the lease changed since it was retrieved.
+func (store *DynamoLeaseStore) TakeLease(lease *Lease, newOwner string, leaseTimeout time.Time) error {
+	values := map[string]*dynamodb.AttributeValue{
+		":new_lease_timeout": {S: aws.String(leaseTimeout.UTC().Format(time.RFC3339))},
+	}
+	condition := "attribute_not_exists(#assigned_to)"
+	if lease.Owner != "" {
+		condition = "#assigned_to = :assigned_to"
+		values[":assigned_to"] = &dynamodb.AttributeValue{S: aws.String(lease.Owner)}
+	}
+	if !lease.LeaseTimeout.IsZero() {
+		condition += " AND #lease_timeout = :lease_timeout"
+		values[":lease_timeout"] = &dynamodb.AttributeValue{S: aws.String(lease.LeaseTimeout.UTC().Format(time.RFC3339))}
+	}
+	update := "remove #assigned_to set #lease_timeout = :new_lease_timeout"
+	if newOwner != "" {
+		update = "set #assigned_to = :new_assigned_to, #lease_timeout = :new_lease_timeout"
+		values[":new_assigned_to"] = &dynamodb.AttributeValue{S: aws.String(newOwner)}
+	}
+	return store.updateLease(lease.ShardID, condition, update, values)
+}
+
+// UpdateCheckpoint writes the checkpoint of the lease, along with its owner and lease timeout
+func (store *DynamoLeaseStore) UpdateCheckpoint(lease *Lease) error {
+	return leaseStoreError(store.checkpointer.saveItem(store.marshalLease(lease)))
+}
+
+// ListLeases retrieves all the leases of the lease table
+func (store *DynamoLeaseStore) ListLeases() ([]*Lease, error) {
+	leases, err := store.checkpointer.GetLeases()
+	return leases, leaseStoreError(err)
+}
+
+// DeleteLease deletes the lease of the shard
+func (store *DynamoLeaseStore) DeleteLease(shardID string) error {
+	return leaseStoreError(store.checkpointer.removeItem(shardID))
+}
+
+// updateLease updates the owner and lease timeout of the lease item of the shard if the condition holds.
+func (store *DynamoLeaseStore) updateLease(shardID, condition, update string,
+	values map[string]*dynamodb.AttributeValue) error {
+	attributes := store.checkpointer.attributes
+	_, err := store.checkpointer.svc.UpdateItem(&dynamodb.UpdateItemInput{
+		TableName: aws.String(store.checkpointer.TableName),
+		Key: map[string]*dynamodb.AttributeValue{
+			attributes.LeaseKey: {
+				S: aws.String(store.checkpointer.leaseKey(shardID)),
+			},
+		},
+		ConditionExpression: aws.String(condition),
+		UpdateExpression:    aws.String(update),
+		ExpressionAttributeNames: map[string]*string{
+			"#assigned_to":   aws.String(attributes.LeaseOwner),
+			"#lease_timeout": aws.String(attributes.LeaseTimeout),
+		},
+		ExpressionAttributeValues: values,
+	})
+	return leaseStoreError(err)
+}
+
+// marshalLease returns the lease item of a lease.
+func (store *DynamoLeaseStore) marshalLease(lease *Lease) map[string]*dynamodb.AttributeValue {
+	attributes := store.checkpointer.attributes
+	item := map[string]*dynamodb.AttributeValue{
+		attributes.LeaseKey: {
+			S: aws.String(store.checkpointer.leaseKey(lease.ShardID)),
+		},
+	}
+	if lease.Owner != "" {
+		item[attributes.LeaseOwner] = &dynamodb.AttributeValue{S: aws.String(lease.Owner)}
+	}
+	if !lease.LeaseTimeout.IsZero() {
+		item[attributes.LeaseTimeout] = &dynamodb.AttributeValue{
+			S: aws.String(lease.LeaseTimeout.UTC().Format(time.RFC3339)),
+		}
+	}
+	if lease.Checkpoint != "" {
+		item[attributes.Checkpoint] = &dynamodb.AttributeValue{S: aws.String(lease.Checkpoint)}
+	}
+	if lease.CheckpointSubSequenceNumber != 0 {
+		item[CHECKPOINT_SUBSEQUENCE_KEY] = &dynamodb.AttributeValue{
+			N: aws.String(strconv.FormatInt(lease.CheckpointSubSequenceNumber, 10)),
+		}
+	}
+	if lease.ParentShardId != "" {
+		item[attributes.ParentShardId] = &dynamodb.AttributeValue{S: aws.String(lease.ParentShardId)}
+	}
+	return item
+}
+
+// leaseStoreError translates the DynamoDB errors into the errors of the LeaseStore interface: a failed condition is
+// ErrLeaseNotAquired, throttling is a LeasingProvisionedThroughputError.
+func leaseStoreError(err error) error {
+	if err == nil {
+		return nil
+	}
+	if awsErr, ok := err.(awserr.Error); ok && awsErr.Code() == dynamodb.ErrCodeConditionalCheckFailedException {
+		return errors.New(ErrLeaseNotAquired)
+	}
+	if isThrottlingError(err) {
+		return util.LeasingProvisionedThroughputError.MakeErr().WithCause(err)
+	}
+	return err
+}
@@ -0,0 +1,37 @@
+package shard
+
+import (
+	log "github.com/sirupsen/logrus"
+)
+
+// FollowerCheckpointer isolates read-only followers from the lease table: it reads the checkpoints of the shard
+// owners, so a follower starts reading a shard where its owner is, but never takes leases or writes checkpoints.
+type FollowerCheckpointer struct {
+	Checkpointer
+}
+
+// NewFollowerCheckpointer wraps the checkpointer shared with the shard owners.
+func NewFollowerCheckpointer(checkpointer Checkpointer) *FollowerCheckpointer {
+	return &FollowerCheckpointer{Checkpointer: checkpointer}
+}
+
+// GetLease is a no-op, followers don't hold leases.
+func (f *FollowerCheckpointer) GetLease(shard *Status, newAssignTo string) error {
+	return nil
+}
+
+// CheckpointSequence drops the checkpoint, followers don't record progress.
+func (f *FollowerCheckpointer) CheckpointSequence(shard *Status) error {
+	log.Debugf("Follower ignores checkpoint of shard %s at %s", shard.ID, shard.Checkpoint)
+	return nil
+}
+
+// RemoveLeaseInfo is a no-op, the lease info belongs to the shard owners.
+func (f *FollowerCheckpointer) RemoveLeaseInfo(shardID string) error {
+	return nil
+}
+
+// RemoveLeaseOwner is a no-op, the lease belongs to the shard owner.
+func (f *FollowerCheckpointer) RemoveLeaseOwner(shardID string) error {
+	return nil
+}
@@ -0,0 +1,34 @@
+package shard
+
+import (
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/kinesis"
+)
+
+// inFlightBatches tracks the batches delivered to the record processor which are outstanding, i.e. whose last
+// record is past the checkpoint of the shard. Once max of them are outstanding the shard consumer pauses fetching
+// until the record processor checkpoints. A zero max disables the limit.
+type inFlightBatches struct {
+	max int
+	// last sequence number of every outstanding batch, in delivery order
+	last []string
+}
+
+// delivered records a batch delivered to the record processor.
+func (b *inFlightBatches) delivered(records []*kinesis.Record) {
+	if b.max <= 0 || len(records) == 0 {
+		return
+	}
+	b.last = append(b.last, aws.StringValue(records[len(records)-1].SequenceNumber))
+}
+
+// full drops the batches covered by the checkpoint and returns true if the limit of outstanding batches is reached.
+func (b *inFlightBatches) full(checkpoint string) bool {
+	if b.max <= 0 {
+		return false
+	}
+	for len(b.last) > 0 && CompareSequenceNumbers(b.last[0], checkpoint) <= 0 {
+		b.last = b.last[1:]
+	}
+	return len(b.last) >= b.max
+}
@@ -0,0 +1,230 @@
+package shard
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/guygma/goKCL"
+	"github.com/guygma/goKCL/util"
+)
+
+// LeaseStore stores the leases and checkpoints of the shards, e.g. in a relational database where DynamoDB isn't an
+// option, see LeaseStoreCheckpointer. DynamoLeaseStore is the DynamoDB implementation.
+//
+// The writes are conditional so that the workers can share the store: a write whose condition doesn't hold anymore
+// fails with ErrLeaseNotAquired. A failure expected to succeed upon retry, e.g. the store being overloaded, is a
+// retryable util.ClientLibraryError, e.g. util.LeasingDependencyError, or a util.LeasingProvisionedThroughputError.
+type LeaseStore interface {
+	// Init prepares the store, e.g. creates the table of the leases if needed
+	Init() error
+
+	// GetLease retrieves the lease of the shard, nil if the shard has none
+	GetLease(shardID string) (*Lease, error)
+
+	// CreateLease creates the lease of a shard, it fails with ErrLeaseNotAquired if the shard already has one
+	CreateLease(lease *Lease) error
+
+	// RenewLease extends the lease until the lease timeout, it fails with ErrLeaseNotAquired if the lease isn't held
+	// by its owner anymore
+	RenewLease(lease *Lease, leaseTimeout time.Time) error
+
+	// TakeLease assigns the lease to the new owner until the lease timeout, an empty owner releasing it. It fails
+	// with ErrLeaseNotAquired if the owner or the lease timeout of the lease changed since it was retrieved.
+	TakeLease(lease *Lease, newOwner string, leaseTimeout time.Time) error
+
+	// UpdateCheckpoint writes the checkpoint of the lease, along with its owner and lease timeout
+	UpdateCheckpoint(lease *Lease) error
+
+	// ListLeases retrieves all the leases
+	ListLeases() ([]*Lease, error)
+
+	// DeleteLease deletes the lease of the shard
+	DeleteLease(shardID string) error
+}
+
+// LeaseStoreCheckpointer implements the Checkpointer interface on top of a LeaseStore. The retryable failures of the
+// store are retried with the RetryBackoff of the configuration up to Retries times, and then handled as any failure
+// of the lease table: the lease is acquired again at the next shard sync, and a failed renewal counts towards
+// LeaseRenewalFailureTolerance.
+//
+// Unlike DynamoCheckpoint, it doesn't keep the lag snapshots, availability zones or owner switches in the leases.
+type LeaseStoreCheckpointer struct {
+	LeaseDuration int
+	Retries       int
+
+	store     LeaseStore
+	kclConfig *goKCL.KinesisClientLibConfiguration
+
+	// the retries of the lease store operations draw from it, nil if unlimited
+	retryBudget *util.RetryBudget
+}
+
+// NewLeaseStoreCheckpointer returns a checkpointer storing the leases and checkpoints in the store.
+func NewLeaseStoreCheckpointer(kclConfig *goKCL.KinesisClientLibConfiguration,
+	store LeaseStore) *LeaseStoreCheckpointer {
+	return &LeaseStoreCheckpointer{
+		LeaseDuration: kclConfig.FailoverTimeMillis,
+		Retries:       kclConfig.RetryBackoff.Attempts - 1,
+		store:         store,
+		kclConfig:     kclConfig,
+	}
+}
+
+// Init initialises the lease store
+func (checkpointer *LeaseStoreCheckpointer) Init() error {
+	return checkpointer.retry(checkpointer.store.Init)
+}
+
+// GetLease attempts to gain a lock on the given shard
+func (checkpointer *LeaseStoreCheckpointer) GetLease(shard *Status, newAssignTo string) error {
+	return checkpointer.takeLease(shard, newAssignTo, "")
+}
+
+// StealLease takes the lease of the shard from owner, even if it didn't expire yet. It fails with ErrLeaseNotAquired
+// if the lease isn't held by owner anymore.
+func (checkpointer *LeaseStoreCheckpointer) StealLease(shard *Status, owner, newAssignTo string) error {
+	return checkpointer.takeLease(shard, newAssignTo, owner)
+}
+
+// takeLease gains the lease of the shard for newAssignTo, creating it if the shard has none. The lease of another
+// owner is only taken once expired, unless it is held by stealFrom.
+func (checkpointer *LeaseStoreCheckpointer) takeLease(shard *Status, newAssignTo, stealFrom string) error {
+	lease, err := checkpointer.getLease(shard.ID)
+	if err != nil {
+		return err
+	}
+	if stealFrom != "" && (lease == nil || lease.Owner != stealFrom) {
+		return errors.New(ErrLeaseNotAquired)
+	}
+
+	now := time.Now()
+	leaseTimeout := now.Add(time.Duration(checkpointer.LeaseDuration) * time.Millisecond).UTC()
+	grace := time.Duration(checkpointer.kclConfig.LeaseTakeoverGraceMillis) * time.Millisecond
+	switch {
+	case lease == nil:
+		err = checkpointer.retry(func() error {
+			return checkpointer.store.CreateLease(&Lease{
+				ShardID:                     shard.ID,
+				Owner:                       newAssignTo,
+				LeaseTimeout:                leaseTimeout,
+				Checkpoint:                  shard.Checkpoint,
+				CheckpointSubSequenceNumber: shard.CheckpointSubSequenceNumber,
+				ParentShardId:               shard.ParentShardId,
+			})
+		})
+	case lease.Owner == newAssignTo:
+		err = checkpointer.retry(func() error { return checkpointer.store.RenewLease(lease, leaseTimeout) })
+	case lease.Owner == "" || lease.Owner == stealFrom || now.After(lease.LeaseTimeout.Add(grace)):
+		logrus.Debugf("Attempting to get a lock for shard: %s, leaseTimeout: %s, assignedTo: %s", shard.ID,
+			lease.LeaseTimeout, lease.Owner)
+		err = checkpointer.retry(func() error { return checkpointer.store.TakeLease(lease, newAssignTo, leaseTimeout) })
+	default:
+		return errors.New(ErrLeaseNotAquired)
+	}
+	if err != nil {
+		return err
+	}
+
+	shard.Mux.Lock()
+	shard.changeLeaseOwner(newAssignTo, time.Now(), checkpointer.kclConfig.LeaseOwnershipHistoryLength)
+	shard.LeaseTimeout = leaseTimeout
+	shard.Mux.Unlock()
+	return nil
+}
+
+// CheckpointSequence writes a checkpoint at the designated sequence ID
+func (checkpointer *LeaseStoreCheckpointer) CheckpointSequence(shard *Status) error {
+	lease := &Lease{
+		ShardID:                     shard.ID,
+		Owner:                       shard.AssignedTo,
+		LeaseTimeout:                shard.LeaseTimeout.UTC(),
+		Checkpoint:                  shard.Checkpoint,
+		CheckpointSubSequenceNumber: shard.CheckpointSubSequenceNumber,
+		ParentShardId:               shard.ParentShardId,
+	}
+	return checkpointer.retry(func() error { return checkpointer.store.UpdateCheckpoint(lease) })
+}
+
+// FetchCheckpoint retrieves the checkpoint for the given shard
+func (checkpointer *LeaseStoreCheckpointer) FetchCheckpoint(shard *Status) error {
+	lease, err := checkpointer.getLease(shard.ID)
+	if err != nil {
+		return err
+	}
+	if lease == nil || lease.Checkpoint == "" {
+		return ErrSequenceIDNotFound
+	}
+
+	shard.Mux.Lock()
+	defer shard.Mux.Unlock()
+	shard.Checkpoint = lease.Checkpoint
+	shard.CheckpointSubSequenceNumber = lease.CheckpointSubSequenceNumber
+	if lease.Owner != "" {
+		shard.changeLeaseOwner(lease.Owner, time.Now(), checkpointer.kclConfig.LeaseOwnershipHistoryLength)
+	}
+	return nil
+}
+
+// RemoveLeaseInfo to remove lease info for shard entry because the shard no longer exists in Kinesis
+func (checkpointer *LeaseStoreCheckpointer) RemoveLeaseInfo(shardID string) error {
+	err := checkpointer.retry(func() error { return checkpointer.store.DeleteLease(shardID) })
+	if err != nil {
+		logrus.Errorf("Error in removing lease info for shard: %s, Error: %+v", shardID, err)
+	} else {
+		logrus.Infof("Lease info for shard: %s has been removed.", shardID)
+	}
+	return err
+}
+
+// RemoveLeaseOwner to remove lease owner for the shard entry
+func (checkpointer *LeaseStoreCheckpointer) RemoveLeaseOwner(shardID string) error {
+	lease, err := checkpointer.getLease(shardID)
+	if err != nil || lease == nil || lease.Owner == "" {
+		return err
+	}
+	return checkpointer.retry(func() error { return checkpointer.store.TakeLease(lease, "", lease.LeaseTimeout) })
+}
+
+// GetLeases retrieves all the leases of the lease store
+func (checkpointer *LeaseStoreCheckpointer) GetLeases() ([]*Lease, error) {
+	var leases []*Lease
+	err := checkpointer.retry(func() error {
+		var err error
+		leases, err = checkpointer.store.ListLeases()
+		return err
+	})
+	return leases, err
+}
+
+func (checkpointer *LeaseStoreCheckpointer) getLease(shardID string) (*Lease, error) {
+	var lease *Lease
+	err := checkpointer.retry(func() error {
+		var err error
+		lease, err = checkpointer.store.GetLease(shardID)
+		return err
+	})
+	return lease, err
+}
+
+// SetRetryBudget makes the retries of the lease store operations draw from the budget
+func (checkpointer *LeaseStoreCheckpointer) SetRetryBudget(budget *util.RetryBudget) {
+	checkpointer.retryBudget = budget
+}
+
+// retry calls op until it succeeds or fails for good, retrying its retryable failures with jittered exponential
+// backoff up to Retries times, as long as the retry budget allows.
+func (checkpointer *LeaseStoreCheckpointer) retry(op func() error) error {
+	backoff := checkpointer.kclConfig.RetryBackoff
+	backoff.Attempts = checkpointer.Retries + 1
+	backoff.Budget = checkpointer.retryBudget
+	return backoff.Retry(context.Background(), "Lease store operation", isRetryableLeaseError, op)
+}
+
+// isRetryableLeaseError returns true if a lease store operation failed with an error expected to succeed upon retry.
+// The lack of throughput of the store is retried too, even though it isn't retryable for the other operations.
+func isRetryableLeaseError(err error) bool {
+	return util.IsRetryable(err) || errors.Is(err, util.LeasingProvisionedThroughputError.MakeErr())
+}
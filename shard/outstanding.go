@@ -0,0 +1,42 @@
+package shard
+
+import (
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/kinesis"
+)
+
+// outstandingRecords approximates the record delivered to the record processor but not checkpointed yet, i.e. the
+// record reprocessed if the worker crashed, by counting the record of the batches whose last record is past the
+// checkpoint of the shard. A batch checkpointed partway is counted in full.
+type outstandingRecords struct {
+	batches []outstandingBatch
+}
+
+type outstandingBatch struct {
+	last    string
+	records int
+}
+
+// delivered records a batch delivered to the record processor.
+func (o *outstandingRecords) delivered(records []*kinesis.Record) {
+	if len(records) == 0 {
+		return
+	}
+	o.batches = append(o.batches, outstandingBatch{
+		last:    aws.StringValue(records[len(records)-1].SequenceNumber),
+		records: len(records),
+	})
+}
+
+// count drops the batches covered by the checkpoint and returns the number of record of the others.
+func (o *outstandingRecords) count(checkpoint string) int {
+	for len(o.batches) > 0 && CompareSequenceNumbers(o.batches[0].last, checkpoint) <= 0 {
+		o.batches = o.batches[1:]
+	}
+
+	records := 0
+	for _, b := range o.batches {
+		records += b.records
+	}
+	return records
+}
@@ -0,0 +1,76 @@
+package shard
+
+import (
+	"time"
+)
+
+const (
+	// LEASE_RELEASED tells that the owner released the lease, e.g. on shutdown or after failing to renew it.
+	LEASE_RELEASED LeaseOwnershipChange = iota + 1
+
+	// LEASE_TAKEN_OVER tells that another worker took the lease over, e.g. once it expired.
+	LEASE_TAKEN_OVER
+)
+
+// LeaseOwnershipChange tells why a worker stopped owning the lease of a shard.
+type LeaseOwnershipChange int
+
+// LeaseOwnership is a period during which a worker owned the lease of a shard, as observed by the worker keeping the
+// history. ReleasedAt and Reason are zero while the owner still holds the lease.
+type LeaseOwnership struct {
+	Owner      string
+	AcquiredAt time.Time
+	ReleasedAt time.Time
+	Reason     LeaseOwnershipChange
+}
+
+// GetLeaseOwnershipHistory returns the last owners of the lease of the shard, oldest first, to diagnose lease
+// flapping and hot-potato handoffs.
+func (ss *Status) GetLeaseOwnershipHistory() []LeaseOwnership {
+	ss.Mux.Lock()
+	defer ss.Mux.Unlock()
+	return append([]LeaseOwnership(nil), ss.ownershipHistory...)
+}
+
+// RequestLeaseRelease asks the consumer of the shard to shut its record processor down with REQUESTED, so that it
+// can checkpoint, and to release the lease, e.g. to hand the shard off to another worker.
+func (ss *Status) RequestLeaseRelease() {
+	ss.Mux.Lock()
+	defer ss.Mux.Unlock()
+	ss.releaseRequested = true
+}
+
+// takeLeaseReleaseRequest returns true once after the release of the lease was requested.
+func (ss *Status) takeLeaseReleaseRequest() bool {
+	ss.Mux.Lock()
+	defer ss.Mux.Unlock()
+	requested := ss.releaseRequested
+	ss.releaseRequested = false
+	return requested
+}
+
+// changeLeaseOwner sets the owner of the lease, an empty owner releasing it, and records the change in the history
+// of at most historyLength owners. It must be called with the lock held.
+func (ss *Status) changeLeaseOwner(owner string, now time.Time, historyLength int) {
+	if owner == ss.AssignedTo {
+		return
+	}
+
+	if n := len(ss.ownershipHistory); n > 0 && ss.AssignedTo != "" {
+		if last := &ss.ownershipHistory[n-1]; last.Owner == ss.AssignedTo && last.ReleasedAt.IsZero() {
+			last.ReleasedAt = now
+			last.Reason = LEASE_TAKEN_OVER
+			if owner == "" {
+				last.Reason = LEASE_RELEASED
+			}
+		}
+	}
+	if owner != "" && historyLength > 0 {
+		ss.ownershipHistory = append(ss.ownershipHistory, LeaseOwnership{Owner: owner, AcquiredAt: now})
+	}
+	if len(ss.ownershipHistory) > historyLength {
+		ss.ownershipHistory = ss.ownershipHistory[len(ss.ownershipHistory)-historyLength:]
+	}
+
+	ss.AssignedTo = owner
+}
@@ -0,0 +1,128 @@
+package shard
+
+import (
+	"context"
+	"sync"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/kinesis"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/guygma/goKCL"
+	"github.com/guygma/goKCL/record"
+	"github.com/guygma/goKCL/util"
+)
+
+// Replay reads the record of a shard from startSequenceNumber to endSequenceNumber, both included, and feeds them
+// to processor, e.g. to reprocess a known bad window. The checkpoints of the shard are left untouched: the
+// checkpoints made by processor are discarded. The range is validated against the sequence number range of the
+// shard, and Replay fails if the stream does not hold the whole range yet.
+func Replay(kc goKCL.KinesisAPI, streamName string, st *Status, startSequenceNumber, endSequenceNumber string,
+	processor record.IRecordProcessor, maxRecords int64) error {
+	if err := validateReplayRange(st, startSequenceNumber, endSequenceNumber); err != nil {
+		return err
+	}
+
+	iterResp, err := kc.GetShardIterator(&kinesis.GetShardIteratorInput{
+		ShardId:                aws.String(st.ID),
+		ShardIteratorType:      aws.String("AT_SEQUENCE_NUMBER"),
+		StartingSequenceNumber: aws.String(startSequenceNumber),
+		StreamName:             aws.String(streamName),
+	})
+	if err != nil {
+		return err
+	}
+
+	// the processor checkpoints a detached copy of the shard
+	replayed := &Status{ID: st.ID, Mux: &sync.Mutex{}, Checkpoint: startSequenceNumber}
+	checkpointer := record.NewRecordProcessorCheckpoint(replayed, discardingCheckpointer{})
+	processor.Initialize(&InitializationInput{
+		ShardId:                st.ID,
+		ExtendedSequenceNumber: &ExtendedSequenceNumber{SequenceNumber: aws.String(startSequenceNumber)},
+		HashKeyRange:           st.HashKeyRange,
+		Context:                context.Background(),
+	})
+	defer processor.Shutdown(&util.ShutdownInput{
+		ShutdownReason: util.REQUESTED,
+		Checkpointer:   checkpointer,
+		Context:        context.Background(),
+	})
+
+	log.Infof("Replaying shard %s from %s to %s", st.ID, startSequenceNumber, endSequenceNumber)
+	shardIterator := iterResp.ShardIterator
+	for shardIterator != nil {
+		getResp, err := kc.GetRecords(&kinesis.GetRecordsInput{
+			Limit:         aws.Int64(maxRecords),
+			ShardIterator: shardIterator,
+		})
+		if err != nil {
+			return err
+		}
+
+		records := make([]*kinesis.Record, 0, len(getResp.Records))
+		done := false
+		for _, r := range getResp.Records {
+			cmp := CompareSequenceNumbers(aws.StringValue(r.SequenceNumber), endSequenceNumber)
+			if cmp > 0 {
+				done = true
+				break
+			}
+			records = append(records, r)
+			if cmp == 0 {
+				done = true
+				break
+			}
+		}
+
+		if len(records) > 0 {
+			processor.ProcessRecords(&record.ProcessRecordsInput{
+				Records:            records,
+				Checkpointer:       checkpointer,
+				MillisBehindLatest: aws.Int64Value(getResp.MillisBehindLatest),
+				Context:            context.Background(),
+			})
+		}
+		if done {
+			return nil
+		}
+
+		if len(getResp.Records) == 0 && aws.Int64Value(getResp.MillisBehindLatest) == 0 && getResp.NextShardIterator != nil {
+			return util.IllegalArgumentError.MakeErr().
+				WithDetail("shard %s has no record up to %s yet", st.ID, endSequenceNumber)
+		}
+		shardIterator = getResp.NextShardIterator
+	}
+
+	// a closed shard ending within the range
+	return nil
+}
+
+// validateReplayRange checks that the range is ordered and within the sequence number range of the shard.
+func validateReplayRange(st *Status, startSequenceNumber, endSequenceNumber string) error {
+	if startSequenceNumber == "" || endSequenceNumber == "" {
+		return util.IllegalArgumentError.MakeErr().WithDetail("replay range of shard %s is not bounded", st.ID)
+	}
+	if CompareSequenceNumbers(startSequenceNumber, endSequenceNumber) > 0 {
+		return util.IllegalArgumentError.MakeErr().
+			WithDetail("replay range of shard %s starts at %s after its end %s", st.ID, startSequenceNumber, endSequenceNumber)
+	}
+	if st.StartingSequenceNumber != "" && CompareSequenceNumbers(startSequenceNumber, st.StartingSequenceNumber) < 0 {
+		return util.IllegalArgumentError.MakeErr().
+			WithDetail("replay range of shard %s starts at %s before the shard %s", st.ID, startSequenceNumber, st.StartingSequenceNumber)
+	}
+	if st.EndingSequenceNumber != "" && CompareSequenceNumbers(endSequenceNumber, st.EndingSequenceNumber) > 0 {
+		return util.IllegalArgumentError.MakeErr().
+			WithDetail("replay range of shard %s ends at %s after the shard %s", st.ID, endSequenceNumber, st.EndingSequenceNumber)
+	}
+	return nil
+}
+
+// discardingCheckpointer is the Checkpointer of a replay, it never touches the lease table.
+type discardingCheckpointer struct{}
+
+func (discardingCheckpointer) Init() error                      { return nil }
+func (discardingCheckpointer) GetLease(*Status, string) error   { return nil }
+func (discardingCheckpointer) CheckpointSequence(*Status) error { return nil }
+func (discardingCheckpointer) FetchCheckpoint(*Status) error    { return nil }
+func (discardingCheckpointer) RemoveLeaseInfo(string) error     { return nil }
+func (discardingCheckpointer) RemoveLeaseOwner(string) error    { return nil }
@@ -0,0 +1,22 @@
+package shard
+
+import (
+	"github.com/guygma/goKCL/record"
+	"github.com/guygma/goKCL/util"
+)
+
+// shardEndGuard rejects the checkpoints at SHARD_END until the consumer of the shard reached its end, so that the
+// children of the shard don't start while record of the shard remain to be processed.
+type shardEndGuard struct {
+	record.IRecordProcessorCheckpointer
+	shard *Status
+}
+
+// Checkpoint returns an InvalidStateError for a checkpoint at SHARD_END before the end of the shard is reached.
+func (g *shardEndGuard) Checkpoint(sequenceNumber *string) error {
+	if sequenceNumber == nil && !g.shard.ShardEndReached() {
+		return util.InvalidStateError.MakeErr().
+			WithDetail("SHARD_END checkpointed before the end of shard %s was reached", g.shard.ID)
+	}
+	return g.IRecordProcessorCheckpointer.Checkpoint(sequenceNumber)
+}
@@ -0,0 +1,60 @@
+package shard
+
+const (
+	// STARTED_FROM_CHECKPOINT tells that the consumer resumed after the checkpoint of the shard.
+	STARTED_FROM_CHECKPOINT StartingPositionReason = iota + 1
+
+	// STARTED_FROM_INITIAL_POSITION tells that the shard had no checkpoint, so the consumer started at the initial
+	// position in stream of the configuration.
+	STARTED_FROM_INITIAL_POSITION
+
+	// STARTED_FROM_CONFIGURED_SEQUENCE_NUMBER tells that the consumer started at the sequence number it was
+	// explicitly configured with, overriding both the checkpoint and the initial position in stream.
+	STARTED_FROM_CONFIGURED_SEQUENCE_NUMBER
+)
+
+// StartingPositionReason tells why the consumer of a shard started where it did.
+type StartingPositionReason int
+
+func (r StartingPositionReason) String() string {
+	switch r {
+	case STARTED_FROM_CHECKPOINT:
+		return "checkpoint"
+	case STARTED_FROM_INITIAL_POSITION:
+		return "initial position in stream"
+	case STARTED_FROM_CONFIGURED_SEQUENCE_NUMBER:
+		return "configured sequence number"
+	}
+	return "unknown"
+}
+
+// StartingPosition is the shard iterator type the consumer of a shard started reading with, i.e. AT_SEQUENCE_NUMBER,
+// AFTER_SEQUENCE_NUMBER, TRIM_HORIZON, LATEST or AT_TIMESTAMP, and why. IteratorType is empty until a consumer
+// started.
+//
+// Unstable: it is meant for diagnostics only, its content may change or go away in any release.
+type StartingPosition struct {
+	ShardID      string
+	IteratorType string
+	// sequence number the iterator is relative to, empty for the other iterator types
+	SequenceNumber string
+	Reason         StartingPositionReason
+}
+
+// GetStartingPosition returns where the last consumer of the shard started reading it.
+//
+// Unstable: it is meant for diagnostics only, see StartingPosition.
+func (ss *Status) GetStartingPosition() StartingPosition {
+	ss.Mux.Lock()
+	defer ss.Mux.Unlock()
+	position := ss.startingPosition
+	position.ShardID = ss.ID
+	return position
+}
+
+// recordStartingPosition records where the consumer of the shard starts reading it.
+func (ss *Status) recordStartingPosition(iteratorType, sequenceNumber string, reason StartingPositionReason) {
+	ss.Mux.Lock()
+	defer ss.Mux.Unlock()
+	ss.startingPosition = StartingPosition{IteratorType: iteratorType, SequenceNumber: sequenceNumber, Reason: reason}
+}
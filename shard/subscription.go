@@ -0,0 +1,120 @@
+package shard
+
+import (
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/kinesis"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/guygma/goKCL"
+)
+
+// shardSubscription reads a shard with enhanced fan-out: Kinesis pushes the record of the shard to the stream
+// consumer over a SubscribeToShard event stream. A subscription lasts 5 minutes, it is then renewed from the
+// continuation sequence number of its last event, or once no event arrived within the read timeout, as Kinesis sends
+// one at least every 5 seconds on a live connection.
+type shardSubscription struct {
+	kc          goKCL.EnhancedFanOutAPI
+	consumerARN string
+	shardID     string
+	readTimeout time.Duration
+
+	// position of the next subscription
+	position *kinesis.StartingPosition
+	stream   *kinesis.SubscribeToShardEventStream
+	// lag of the last event
+	millisBehindLatest int64
+}
+
+func newShardSubscription(kc goKCL.EnhancedFanOutAPI, consumerARN, shardID string,
+	position *kinesis.StartingPosition, readTimeout time.Duration) *shardSubscription {
+	return &shardSubscription{
+		kc:          kc,
+		consumerARN: consumerARN,
+		shardID:     shardID,
+		readTimeout: readTimeout,
+		position:    position,
+	}
+}
+
+// next waits for the next event of the subscription, subscribing first if needed, and returns it as the output of
+// a GetRecords call. The continuation sequence number stands for the next shard iterator, nil at the end of the
+// shard. Once stop is closed, it returns an output without record right away.
+func (s *shardSubscription) next(stop <-chan struct{}) (*kinesis.GetRecordsOutput, error) {
+	for {
+		if s.stream == nil {
+			out, err := s.kc.SubscribeToShard(&kinesis.SubscribeToShardInput{
+				ConsumerARN:      aws.String(s.consumerARN),
+				ShardId:          aws.String(s.shardID),
+				StartingPosition: s.position,
+			})
+			if err != nil {
+				return nil, err
+			}
+			s.stream = out.EventStream
+		}
+
+		var timeout <-chan time.Time
+		if s.readTimeout > 0 {
+			timeout = time.After(s.readTimeout)
+		}
+
+		select {
+		case <-stop:
+			// not the end of the shard
+			return &kinesis.GetRecordsOutput{
+				MillisBehindLatest: aws.Int64(s.millisBehindLatest),
+				NextShardIterator:  aws.String(aws.StringValue(s.position.SequenceNumber)),
+			}, nil
+		case <-timeout:
+			log.Warnf("No event from the subscription to shard %s for %v, renewing it at %v", s.shardID,
+				s.readTimeout, s.position)
+			s.close()
+		case event, ok := <-s.stream.Events():
+			if !ok {
+				err := s.stream.Close()
+				s.stream = nil
+				if err != nil {
+					return nil, err
+				}
+				log.Debugf("Subscription to shard %s expired, renewing it at %v", s.shardID, s.position)
+				continue
+			}
+
+			e, ok := event.(*kinesis.SubscribeToShardEvent)
+			if !ok {
+				continue
+			}
+			if e.ContinuationSequenceNumber != nil {
+				s.position = &kinesis.StartingPosition{
+					Type:           aws.String(kinesis.ShardIteratorTypeAfterSequenceNumber),
+					SequenceNumber: e.ContinuationSequenceNumber,
+				}
+			}
+			s.millisBehindLatest = aws.Int64Value(e.MillisBehindLatest)
+			return &kinesis.GetRecordsOutput{
+				Records:            e.Records,
+				MillisBehindLatest: aws.Int64(s.millisBehindLatest),
+				NextShardIterator:  e.ContinuationSequenceNumber,
+			}, nil
+		}
+	}
+}
+
+// restartAt closes the current subscription, the next one starting at the position.
+func (s *shardSubscription) restartAt(position *kinesis.StartingPosition) {
+	s.close()
+	s.position = position
+}
+
+// close closes the current subscription, if any.
+func (s *shardSubscription) close() {
+	if s.stream == nil {
+		return
+	}
+	if err := s.stream.Close(); err != nil {
+		log.Debugf("Closing the subscription to shard %s: %v", s.shardID, err)
+	}
+	s.stream = nil
+}
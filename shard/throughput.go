@@ -0,0 +1,37 @@
+package shard
+
+import (
+	"time"
+)
+
+// throughputAverage is an exponentially weighted moving average of the record processed per second. Every
+// observation weighs smoothingFactor of the average, the average before it weighs the rest.
+type throughputAverage struct {
+	// record per second
+	rate float64
+	// time of the last observation, zero before the first one
+	last   time.Time
+	primed bool
+}
+
+// observe adds the record processed since the last observation to the average. The first observation only marks
+// the start of the first interval.
+func (a *throughputAverage) observe(records int, now time.Time, smoothingFactor float64) {
+	if a.last.IsZero() {
+		a.last = now
+		return
+	}
+	elapsed := now.Sub(a.last).Seconds()
+	if elapsed <= 0 {
+		return
+	}
+	a.last = now
+
+	rate := float64(records) / elapsed
+	if !a.primed {
+		a.rate = rate
+		a.primed = true
+		return
+	}
+	a.rate = smoothingFactor*rate + (1-smoothingFactor)*a.rate
+}
@@ -0,0 +1,82 @@
+package shard
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/guygma/goKCL/util"
+)
+
+// watchdog flags a shard as stuck when it neither received record nor made checkpoint progress for the timeout
+// while the shard is still behind. This catches silent deadlocks in record processors, which block the shard
+// consumer itself. A shard which is caught up (MillisBehindLatest is zero) is legitimately idle and never stuck.
+type watchdog struct {
+	mux                sync.Mutex
+	timeout            time.Duration
+	lastProgress       time.Time
+	lastCheckpoint     string
+	millisBehindLatest int64
+	stuck              bool
+}
+
+func newWatchdog(timeout time.Duration, now time.Time) *watchdog {
+	return &watchdog{timeout: timeout, lastProgress: now}
+}
+
+// recordsReceived is called with the result of every GetRecords call.
+func (w *watchdog) recordsReceived(n int, millisBehindLatest int64, now time.Time) {
+	w.mux.Lock()
+	defer w.mux.Unlock()
+
+	w.millisBehindLatest = millisBehindLatest
+	if n > 0 {
+		w.lastProgress = now
+		w.stuck = false
+	}
+}
+
+// check returns true, together with how far behind the shard is, when the shard has just become stuck.
+func (w *watchdog) check(checkpoint string, now time.Time) (bool, int64) {
+	w.mux.Lock()
+	defer w.mux.Unlock()
+
+	if checkpoint != w.lastCheckpoint {
+		w.lastCheckpoint = checkpoint
+		w.lastProgress = now
+		w.stuck = false
+	}
+
+	if w.millisBehindLatest == 0 {
+		w.stuck = false
+		return false, 0
+	}
+
+	if !w.stuck && now.Sub(w.lastProgress) >= w.timeout {
+		w.stuck = true
+		return true, w.millisBehindLatest
+	}
+	return false, w.millisBehindLatest
+}
+
+// runWatchdog periodically checks the shard for progress until done is closed.
+func (sc *Consumer) runWatchdog(shard *Status, done chan struct{}) {
+	ticker := time.NewTicker(sc.watchdog.timeout / 2)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-done:
+			return
+		case now := <-ticker.C:
+			shard.Mux.Lock()
+			checkpoint := shard.Checkpoint
+			shard.Mux.Unlock()
+
+			if stuck, behind := sc.watchdog.check(checkpoint, now); stuck {
+				util.EmitEvent(sc.kclConfig.EventListener, util.CRITICAL, util.EVENT_SHARD_STUCK, shard.ID,
+					fmt.Sprintf("no progress for %v while %d ms behind latest", sc.watchdog.timeout, behind))
+			}
+		}
+	}
+}
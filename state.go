@@ -0,0 +1,170 @@
+package goKCL
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/kinesis"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/guygma/goKCL/shard"
+)
+
+// WorkerState is the lightweight worker scoped state persisted across restarts, on top of the checkpoints.
+type WorkerState struct {
+	WorkerID   string
+	StreamName string
+	// last known shards of the stream
+	Shards []ShardState
+	// shards whose lease was held by the worker
+	HeldLeases []string
+	SavedAt    time.Time
+}
+
+// ShardState is the persisted description of a shard.
+type ShardState struct {
+	ID                     string
+	ParentShardId          string
+	AdjacentParentShardId  string
+	StartingSequenceNumber string
+	EndingSequenceNumber   string
+	StartingHashKey        string
+	EndingHashKey          string
+}
+
+// WorkerStateStore persists the WorkerState.
+type WorkerStateStore interface {
+	// Save persists the state of the worker, replacing the previous one
+	Save(state *WorkerState) error
+
+	// Load retrieves the state of the worker, nil if none was saved
+	Load(workerID string) (*WorkerState, error)
+}
+
+// FileWorkerStateStore persists the state of each worker as a JSON file in a directory.
+type FileWorkerStateStore struct {
+	dir string
+	mux sync.Mutex
+}
+
+// NewFileWorkerStateStore creates a WorkerStateStore keeping its files in dir.
+func NewFileWorkerStateStore(dir string) *FileWorkerStateStore {
+	return &FileWorkerStateStore{dir: dir}
+}
+
+func (s *FileWorkerStateStore) Save(state *WorkerState) error {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	data, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+
+	// write and rename, so that a crash never leaves a truncated state behind
+	path := s.path(state.WorkerID)
+	if err := ioutil.WriteFile(path+".tmp", data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(path+".tmp", path)
+}
+
+func (s *FileWorkerStateStore) Load(workerID string) (*WorkerState, error) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	data, err := ioutil.ReadFile(s.path(workerID))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	state := &WorkerState{}
+	if err := json.Unmarshal(data, state); err != nil {
+		return nil, err
+	}
+	return state, nil
+}
+
+func (s *FileWorkerStateStore) path(workerID string) string {
+	return filepath.Join(s.dir, "worker-"+workerID+".json")
+}
+
+// restoreState restores the shards and held leases of a previous run of the worker. The held leases are acquired
+// first, so that the worker converges back to its previous assignment. The restored shards are validated against
+// the stream on the first shard sync, which drops the ones which no longer exist.
+func (w *Worker) restoreState() {
+	state, err := w.kclConfig.WorkerStateStore.Load(w.workerID)
+	if err != nil {
+		log.Errorf("Failed to load worker state, starting from scratch: %+v", err)
+		return
+	}
+	if state == nil {
+		return
+	}
+	if state.WorkerID != w.workerID || state.StreamName != w.streamName {
+		log.Warnf("Ignoring worker state of %s/%s saved at %v", state.WorkerID, state.StreamName, state.SavedAt)
+		return
+	}
+
+	for _, s := range state.Shards {
+		sh := &shard.Status{
+			ID:                     s.ID,
+			ParentShardId:          s.ParentShardId,
+			AdjacentParentShardId:  s.AdjacentParentShardId,
+			Mux:                    &sync.Mutex{},
+			StartingSequenceNumber: s.StartingSequenceNumber,
+			EndingSequenceNumber:   s.EndingSequenceNumber,
+		}
+		if s.StartingHashKey != "" || s.EndingHashKey != "" {
+			sh.HashKeyRange = &kinesis.HashKeyRange{
+				StartingHashKey: aws.String(s.StartingHashKey),
+				EndingHashKey:   aws.String(s.EndingHashKey),
+			}
+		}
+		w.shardMux.Lock()
+		w.shardStatus[s.ID] = sh
+		w.shardMux.Unlock()
+	}
+
+	w.preferredLeases = make(map[string]bool)
+	for _, shardID := range state.HeldLeases {
+		w.preferredLeases[shardID] = true
+	}
+	log.Infof("Restored %d shards and %d held leases saved at %v", len(state.Shards), len(state.HeldLeases), state.SavedAt)
+}
+
+// persistState saves the current shards and the given held leases.
+func (w *Worker) persistState(heldLeases []string) {
+	shards := w.listShards()
+	state := &WorkerState{
+		WorkerID:   w.workerID,
+		StreamName: w.streamName,
+		Shards:     make([]ShardState, 0, len(shards)),
+		HeldLeases: heldLeases,
+		SavedAt:    time.Now(),
+	}
+	for _, sh := range shards {
+		start, end := sh.GetHashKeyRange()
+		state.Shards = append(state.Shards, ShardState{
+			ID:                     sh.ID,
+			ParentShardId:          sh.ParentShardId,
+			AdjacentParentShardId:  sh.AdjacentParentShardId,
+			StartingSequenceNumber: sh.StartingSequenceNumber,
+			EndingSequenceNumber:   sh.EndingSequenceNumber,
+			StartingHashKey:        start,
+			EndingHashKey:          end,
+		})
+	}
+
+	if err := w.kclConfig.WorkerStateStore.Save(state); err != nil {
+		log.Errorf("Failed to persist worker state: %+v", err)
+	}
+}
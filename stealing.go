@@ -0,0 +1,108 @@
+package goKCL
+
+import (
+	"math/rand"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/guygma/goKCL/shard"
+)
+
+// stealLeases takes leases from the most loaded worker while the worker holds less than its fair share of them, i.e.
+// the leases over the live workers. It waits a random delay of up to LeaseStealingJitterMillis first, and lists the
+// leases afterwards, so that workers joining together see each other's steals instead of all stealing at once.
+func (w *Worker) stealLeases() {
+	jitter := time.Duration(rand.Int63n(int64(w.kclConfig.LeaseStealingJitterMillis)+1)) * time.Millisecond
+	select {
+	case <-*w.stop:
+		return
+	case <-time.After(jitter):
+	}
+
+	leases, err := w.leaseLister.GetLeases()
+	if err != nil {
+		log.Warnf("Failed to list the leases, not stealing any: %+v", err)
+		return
+	}
+
+	for _, lease := range w.leasesToSteal(leases, time.Now()) {
+		sh := w.shardStatus[lease.ShardID]
+		err := w.checkpointer.FetchCheckpoint(sh)
+		if err != nil && err != shard.ErrSequenceIDNotFound {
+			log.Errorf("Failed to fetch checkpoint of shard %s: %+v", sh.ID, err)
+			continue
+		}
+		if sh.Checkpoint == shard.SHARD_END {
+			continue
+		}
+
+		// the previous owner fails to renew the lease and shuts its record processor down as ZOMBIE
+		if err := w.leaseStealer.StealLease(sh, lease.Owner, w.workerID); err != nil {
+			if err.Error() != shard.ErrLeaseNotAquired {
+				log.Error(err)
+			}
+			continue
+		}
+		log.Infof("Stole the lease of shard %s from worker %s", sh.ID, lease.Owner)
+		w.startShardConsumer(sh)
+	}
+}
+
+// leasesToSteal returns up to MaxLeasesToStealAtOneTime leases of the most loaded worker, picked at random, to get
+// the worker closer to its fair share. There are none to steal if the worker holds its fair share already, if the
+// most loaded worker doesn't hold more than its fair share, or if some lease is available anyway: leases which
+// aren't owned or expired are acquired instead.
+func (w *Worker) leasesToSteal(leases []*shard.Lease, now time.Time) []*shard.Lease {
+	// leases within the takeover grace period are still held
+	expiry := now.Add(-time.Duration(w.kclConfig.LeaseTakeoverGraceMillis) * time.Millisecond)
+	held := map[string][]*shard.Lease{w.workerID: nil}
+	total := 0
+	for _, lease := range leases {
+		if _, ok := w.shardStatus[lease.ShardID]; !ok || lease.Checkpoint == shard.SHARD_END {
+			continue
+		}
+		if lease.Owner == "" || !lease.LeaseTimeout.After(expiry) {
+			return nil
+		}
+		held[lease.Owner] = append(held[lease.Owner], lease)
+		total++
+	}
+
+	fairShare := (total + len(held) - 1) / len(held)
+	if fairShare > w.kclConfig.MaxLeasesForWorker {
+		fairShare = w.kclConfig.MaxLeasesForWorker
+	}
+	n := fairShare - len(held[w.workerID])
+	if n <= 0 {
+		return nil
+	}
+
+	busiest := ""
+	for owner, ownerLeases := range held {
+		if owner == w.workerID {
+			continue
+		}
+		if busiest == "" || len(ownerLeases) > len(held[busiest]) ||
+			(len(ownerLeases) == len(held[busiest]) && owner < busiest) {
+			busiest = owner
+		}
+	}
+	if excess := len(held[busiest]) - fairShare; excess < n {
+		n = excess
+	}
+	if n > w.kclConfig.MaxLeasesToStealAtOneTime {
+		n = w.kclConfig.MaxLeasesToStealAtOneTime
+	}
+	if n <= 0 {
+		return nil
+	}
+
+	log.Debugf("Worker %s holds %d of %d leases, stealing %d from worker %s holding %d", w.workerID,
+		len(held[w.workerID]), total, n, busiest, len(held[busiest]))
+	stolen := make([]*shard.Lease, 0, n)
+	for _, i := range rand.Perm(len(held[busiest]))[:n] {
+		stolen = append(stolen, held[busiest][i])
+	}
+	return stolen
+}
@@ -0,0 +1,49 @@
+package goKCL
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/service/kinesis"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/guygma/goKCL/util"
+)
+
+func TestWorkerShutdownFlushesAuditEntries(t *testing.T) {
+	hook := &slowAuditHook{}
+	store := newMemoryLeaseStore(10 * time.Second)
+	kc := &mockKinesis{shards: []*kinesis.Shard{mockShard("shardId-0", "0", "340282366920938463463374607431768211455")}}
+	kclConfig := NewKinesisClientLibConfig("appName", "test", "us-west-2", "worker").
+		WithFailoverTimeMillis(10000).
+		WithShardSyncIntervalMillis(20).
+		WithIdleTimeBetweenReadsInMillis(10).
+		WithAuditHook(hook, util.INCLUDE_VALUES)
+	w := NewWorker(&mockProcessorFactory{}, kclConfig, nil).WithKinesis(kc).WithCheckpointer(store)
+	assert.Nil(t, w.Start())
+	assert.True(t, store.waitForOwner("worker", time.Second, "shardId-0"))
+
+	// the release of the lease on shutdown is reported by the time the shutdown returns
+	w.Shutdown()
+	assert.Contains(t, hook.types(), util.LEASE_RELEASED)
+}
+
+// slowAuditHook records the types of the audit entries, taking its time to report each of them.
+type slowAuditHook struct {
+	mux     sync.Mutex
+	entries []util.AuditType
+}
+
+func (h *slowAuditHook) Audit(entry *util.AuditEntry) {
+	time.Sleep(5 * time.Millisecond)
+	h.mux.Lock()
+	defer h.mux.Unlock()
+	h.entries = append(h.entries, entry.Type)
+}
+
+func (h *slowAuditHook) types() []util.AuditType {
+	h.mux.Lock()
+	defer h.mux.Unlock()
+	return append([]util.AuditType(nil), h.entries...)
+}
@@ -0,0 +1,111 @@
+package shard
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/guygma/goKCL/util"
+)
+
+func TestAuditMutations(t *testing.T) {
+	hook := newMockAuditHook()
+	checkpointer := NewAuditingCheckpointer(newMockShardCheckpointer(), "worker-1", hook, util.INCLUDE_VALUES)
+	shard := testShard()
+	shard.AssignedTo = "worker-0"
+	shard.LeaseTimeout = time.Now().Add(-time.Minute)
+
+	// taken over from an expired owner, renewed, checkpointed twice and released
+	assert.Nil(t, checkpointer.GetLease(shard, "worker-1"))
+	assert.Nil(t, checkpointer.GetLease(shard, "worker-1"))
+	shard.Checkpoint = "10"
+	assert.Nil(t, checkpointer.CheckpointSequence(shard))
+	shard.Checkpoint = "20"
+	assert.Nil(t, checkpointer.CheckpointSequence(shard))
+	assert.Nil(t, checkpointer.RemoveLeaseOwner(shard.ID))
+
+	entries := hook.wait(t, 5)
+	assert.Equal(t, util.LEASE_TAKEN, entries[0].Type)
+	assert.Equal(t, "worker-0", entries[0].OldValue)
+	assert.Equal(t, "worker-1", entries[0].NewValue)
+
+	assert.Equal(t, util.LEASE_RENEWED, entries[1].Type)
+	assert.NotEqual(t, entries[1].OldValue, "")
+	assert.NotEqual(t, entries[1].NewValue, "")
+
+	assert.Equal(t, util.CHECKPOINT_WRITTEN, entries[2].Type)
+	assert.Equal(t, "", entries[2].OldValue)
+	assert.Equal(t, "10", entries[2].NewValue)
+	assert.Equal(t, util.CHECKPOINT_WRITTEN, entries[3].Type)
+	assert.Equal(t, "10", entries[3].OldValue)
+	assert.Equal(t, "20", entries[3].NewValue)
+
+	assert.Equal(t, util.LEASE_RELEASED, entries[4].Type)
+	assert.Equal(t, "worker-1", entries[4].OldValue)
+
+	for _, entry := range entries {
+		assert.Equal(t, "0001", entry.ShardID)
+		assert.Equal(t, "worker-1", entry.Actor)
+		assert.False(t, entry.Timestamp.IsZero())
+	}
+}
+
+func TestAuditHashedValues(t *testing.T) {
+	hook := newMockAuditHook()
+	checkpointer := NewAuditingCheckpointer(newMockShardCheckpointer(), "worker-1", hook, util.HASH_VALUES)
+	shard := testShard()
+	shard.Checkpoint = "10"
+
+	assert.Nil(t, checkpointer.CheckpointSequence(shard))
+
+	entries := hook.wait(t, 1)
+	assert.Equal(t, util.CHECKPOINT_WRITTEN, entries[0].Type)
+	assert.Equal(t, util.HASH_VALUES.ApplyValuePolicy("10"), entries[0].NewValue)
+	assert.NotContains(t, entries[0].NewValue, "10")
+	assert.NotEqual(t, "worker-1", entries[0].Actor)
+}
+
+func TestAuditClose(t *testing.T) {
+	hook := newMockAuditHook()
+	checkpointer := NewAuditingCheckpointer(newMockShardCheckpointer(), "worker-1", hook, util.INCLUDE_VALUES)
+	shard := testShard()
+	shard.Checkpoint = "10"
+	assert.Nil(t, checkpointer.CheckpointSequence(shard))
+
+	// the buffered entries are reported by the time Close returns
+	checkpointer.Close()
+	assert.Equal(t, 1, len(hook.entries))
+
+	// the later mutations go through unaudited, closing again is harmless
+	shard.Checkpoint = "20"
+	assert.Nil(t, checkpointer.CheckpointSequence(shard))
+	checkpointer.Close()
+	assert.Equal(t, 1, len(hook.entries))
+}
+
+type mockAuditHook struct {
+	entries chan *util.AuditEntry
+}
+
+func newMockAuditHook() *mockAuditHook {
+	return &mockAuditHook{entries: make(chan *util.AuditEntry, 100)}
+}
+
+func (m *mockAuditHook) Audit(entry *util.AuditEntry) {
+	m.entries <- entry
+}
+
+// wait returns the first n audit entries, failing the test if they are not delivered in time.
+func (m *mockAuditHook) wait(t *testing.T, n int) []*util.AuditEntry {
+	entries := make([]*util.AuditEntry, 0, n)
+	for len(entries) < n {
+		select {
+		case entry := <-m.entries:
+			entries = append(entries, entry)
+		case <-time.After(time.Second):
+			t.Fatalf("received %d of %d audit entries", len(entries), n)
+		}
+	}
+	return entries
+}
@@ -0,0 +1,104 @@
+package shard
+
+import (
+	"errors"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/kinesis"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/guygma/goKCL"
+	"github.com/guygma/goKCL/record"
+)
+
+func TestAutoCheckpointEveryNRecords(t *testing.T) {
+	kc := newMockKinesisClient(7, true)
+	checkpointer := newMockShardCheckpointer()
+	processor := &mockRecordProcessor{skipCheckpoint: true}
+	sc := newTestConsumer(kc, checkpointer, processor, testConfig().
+		WithMaxRecords(2).
+		WithAutoCheckpointRecordCount(3))
+
+	err := sc.GetRecords(testShard())
+	assert.Nil(t, err)
+
+	// checkpoints every 3 record regardless of the batches of 2, then the end of the shard
+	assert.Equal(t, []string{"3", "6", SHARD_END}, checkpointer.history)
+}
+
+func TestAutoCheckpointWhicheverTriggersFirst(t *testing.T) {
+	a := &autoCheckpointer{recordCount: 5, interval: time.Second, lastCheckpoint: time.Now()}
+	now := a.lastCheckpoint
+
+	// neither the count nor the interval has been reached
+	assert.Nil(t, a.checkpointAt(autoCheckpointRecords(1, 2), now.Add(100*time.Millisecond)))
+
+	// the interval triggers first
+	at := a.checkpointAt(autoCheckpointRecords(3, 4), now.Add(time.Second))
+	assert.Equal(t, "4", aws.StringValue(at.SequenceNumber))
+
+	// the count restarts after the time based checkpoint and triggers first
+	assert.Nil(t, a.checkpointAt(autoCheckpointRecords(5, 6, 7), now.Add(1100*time.Millisecond)))
+	at = a.checkpointAt(autoCheckpointRecords(8, 9, 10), now.Add(1200*time.Millisecond))
+	assert.Equal(t, "9", aws.StringValue(at.SequenceNumber))
+}
+
+func TestAutoCheckpointSuspendedByFailedBatch(t *testing.T) {
+	kc := newMockKinesisClient(7, true)
+	checkpointer := newMockShardCheckpointer()
+	processor := &failingRecordProcessor{fail: "3", checkpoint: "6"}
+	sc := newTestConsumer(kc, checkpointer, processor, testConfig().
+		WithMaxRecords(2).
+		WithCheckpointConfig(goKCL.CheckpointConfig{RecordCount: 2}))
+
+	err := sc.GetRecords(testShard())
+	assert.Nil(t, err)
+
+	// the failed batch [3 4] isn't checkpointed, the automatic checkpoints resume after the manual one at 6 and the
+	// count restarts from there
+	assert.Equal(t, []string{"2", "6", SHARD_END}, checkpointer.history)
+}
+
+func TestCheckpointConfigValidation(t *testing.T) {
+	kclConfig := testConfig().WithCheckpointConfig(goKCL.CheckpointConfig{Interval: time.Minute})
+	a := newAutoCheckpointer(kclConfig)
+	assert.Equal(t, time.Minute, a.interval)
+	assert.Equal(t, 0, a.recordCount)
+
+	assert.Panics(t, func() { testConfig().WithCheckpointConfig(goKCL.CheckpointConfig{}) })
+	assert.Panics(t, func() { testConfig().WithCheckpointConfig(goKCL.CheckpointConfig{RecordCount: -1}) })
+	assert.Panics(t, func() { testConfig().WithCheckpointConfig(goKCL.CheckpointConfig{Interval: -time.Second}) })
+}
+
+// failingRecordProcessor fails the batch starting at the fail record and checkpoints the batch ending at the
+// checkpoint record itself.
+type failingRecordProcessor struct {
+	mockRecordProcessor
+	fail       string
+	checkpoint string
+}
+
+func (m *failingRecordProcessor) ProcessRecords(input *record.ProcessRecordsInput) {
+	if len(input.Records) == 0 {
+		return
+	}
+	if aws.StringValue(input.Records[0].SequenceNumber) == m.fail {
+		input.Fail(errors.New("poison record"))
+		return
+	}
+	last := input.Records[len(input.Records)-1].SequenceNumber
+	if aws.StringValue(last) == m.checkpoint {
+		input.Checkpointer.Checkpoint(last)
+	}
+}
+
+func autoCheckpointRecords(seqs ...int) []*kinesis.Record {
+	records := make([]*kinesis.Record, 0, len(seqs))
+	for _, seq := range seqs {
+		records = append(records, &kinesis.Record{SequenceNumber: aws.String(strconv.Itoa(seq))})
+	}
+	return records
+}
@@ -0,0 +1,87 @@
+package goKCL
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/guygma/goKCL/shard"
+)
+
+func TestAvailabilityZoneSpread(t *testing.T) {
+	table := &zoneLeaseTable{}
+	shards := make(map[string]*shard.Status)
+	for i := 0; i < 8; i++ {
+		id := fmt.Sprintf("shardId-%d", i)
+		shards[id] = &shard.Status{ID: id, Mux: &sync.Mutex{}}
+	}
+	newZoneWorker := func(workerID, availabilityZone string) *Worker {
+		kclConfig := NewKinesisClientLibConfig("appName", "test", "us-west-2", workerID).
+			WithAvailabilityZone(availabilityZone)
+		w := NewWorker(nil, kclConfig, nil)
+		w.shardStatus = shards
+		w.leaseLister = table
+		return w
+	}
+	a1 := newZoneWorker("a1", "az-a")
+	a2 := newZoneWorker("a2", "az-a")
+	b1 := newZoneWorker("b1", "az-b")
+
+	// b1 already holds a lease, reporting its zone
+	table.take(b1, 1)
+	for _, w := range []*Worker{a1, a2, b1} {
+		table.take(w, w.availabilityZoneQuota(8))
+	}
+	assert.Equal(t, map[string]int{"az-a": 4, "az-b": 4}, table.leasesPerZone())
+
+	// the leases of a zone going down expire, and the other zone takes its shards over
+	table.expire("az-b")
+	assert.Equal(t, 4, a2.availabilityZoneQuota(8))
+
+	// without affinity, the first worker takes everything it can
+	table = &zoneLeaseTable{}
+	table.take(b1, 1)
+	a1.leaseLister = nil
+	table.take(a1, a1.availabilityZoneQuota(8))
+	assert.Equal(t, map[string]int{"az-a": 7, "az-b": 1}, table.leasesPerZone())
+}
+
+// zoneLeaseTable is a lease table shared by several workers, assigning the free shards in order.
+type zoneLeaseTable struct {
+	leases []*shard.Lease
+}
+
+func (m *zoneLeaseTable) GetLeases() ([]*shard.Lease, error) {
+	return m.leases, nil
+}
+
+func (m *zoneLeaseTable) take(w *Worker, n int) {
+	for i := len(m.leases); i < len(w.shardStatus) && n > 0; i++ {
+		m.leases = append(m.leases, &shard.Lease{
+			ShardID:               fmt.Sprintf("shardId-%d", i),
+			Owner:                 w.workerID,
+			LeaseTimeout:          time.Now().Add(time.Hour),
+			OwnerAvailabilityZone: w.kclConfig.AvailabilityZone,
+		})
+		n--
+	}
+}
+
+func (m *zoneLeaseTable) expire(availabilityZone string) {
+	for _, lease := range m.leases {
+		if lease.OwnerAvailabilityZone == availabilityZone {
+			lease.LeaseTimeout = time.Now().Add(-time.Minute)
+		}
+	}
+}
+
+func (m *zoneLeaseTable) leasesPerZone() map[string]int {
+	counts := make(map[string]int)
+	for _, lease := range m.leases {
+		counts[lease.OwnerAvailabilityZone]++
+	}
+	return counts
+}
@@ -0,0 +1,107 @@
+package util
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBackoffWaitFullJitter(t *testing.T) {
+	b := Backoff{Base: 100 * time.Millisecond, Max: 30 * time.Second, Attempts: 20}
+
+	// the wait is random up to the exponential bound, capped at Max
+	for _, tc := range []struct {
+		retry int
+		bound time.Duration
+	}{{1, 100 * time.Millisecond}, {2, 200 * time.Millisecond}, {5, 1600 * time.Millisecond}, {15, 30 * time.Second}} {
+		distinct := make(map[time.Duration]bool)
+		for i := 0; i < 50; i++ {
+			wait := b.Wait(tc.retry)
+			assert.True(t, wait >= 0 && wait <= tc.bound, "retry %d waits %v", tc.retry, wait)
+			distinct[wait] = true
+		}
+		assert.True(t, len(distinct) > 1, "retry %d isn't randomized", tc.retry)
+	}
+}
+
+func TestBackoffRetry(t *testing.T) {
+	b := Backoff{Base: time.Millisecond, Max: 10 * time.Millisecond, Attempts: 3}
+
+	// retryable errors are retried until the operation succeeds
+	calls := 0
+	err := b.Retry(context.Background(), "op", nil, func() error {
+		calls++
+		if calls < 3 {
+			return KinesisClientLibIOError.MakeErr()
+		}
+		return nil
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, 3, calls)
+
+	// the others aren't retried
+	calls = 0
+	err = b.Retry(context.Background(), "op", nil, func() error {
+		calls++
+		return InvalidStateError.MakeErr()
+	})
+	assert.True(t, errors.Is(err, InvalidStateError.MakeErr()))
+	assert.Equal(t, 1, calls)
+
+	// the last error is returned with the attempt count once all attempts failed
+	calls = 0
+	err = b.Retry(context.Background(), "op", nil, func() error {
+		calls++
+		return KinesisClientLibIOError.MakeErr()
+	})
+	assert.True(t, errors.Is(err, KinesisClientLibIOError.MakeErr()))
+	assert.Contains(t, err.(*ClientLibraryError).Detail, "op failed after 3 attempts")
+	assert.Equal(t, 3, calls)
+
+	// a throttled AWS call failing for good is a ThrottlingError
+	throttled := awserr.New("ProvisionedThroughputExceededException", "Rate exceeded", nil)
+	err = b.Retry(context.Background(), "op", nil, func() error { return throttled })
+	assert.True(t, errors.Is(err, ThrottlingError.MakeErr()))
+	var awsErr awserr.Error
+	assert.True(t, errors.As(err, &awsErr))
+}
+
+func TestBackoffRetryCanceled(t *testing.T) {
+	b := Backoff{Base: time.Hour, Max: time.Hour, Attempts: 3}
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(10*time.Millisecond, cancel)
+
+	// the cancellation cuts the backoff short
+	start := time.Now()
+	err := b.Retry(ctx, "op", func(error) bool { return true }, func() error { return errors.New("boom") })
+	assert.Equal(t, context.Canceled, err)
+	assert.True(t, time.Since(start) < time.Second)
+}
+
+func TestBackoffRetryBudget(t *testing.T) {
+	budget := NewRetryBudget(2, 0.001)
+	b := Backoff{Base: time.Millisecond, Max: time.Millisecond, Attempts: 10, Budget: budget}
+
+	// every retry draws from the budget, the operation fails for good once it is exhausted
+	calls := 0
+	err := b.Retry(context.Background(), "op", nil, func() error {
+		calls++
+		return KinesisClientLibIOError.MakeErr()
+	})
+	assert.True(t, errors.Is(err, KinesisClientLibIOError.MakeErr()))
+	assert.Contains(t, err.(*ClientLibraryError).Detail, "retry budget exhausted")
+	assert.Equal(t, 3, calls)
+	assert.Equal(t, 0, budget.Available())
+
+	// the first call isn't a retry
+	calls = 0
+	assert.Nil(t, b.Retry(context.Background(), "op", nil, func() error {
+		calls++
+		return nil
+	}))
+	assert.Equal(t, 1, calls)
+}
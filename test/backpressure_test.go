@@ -0,0 +1,46 @@
+package shard
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/guygma/goKCL"
+)
+
+func TestBackpressureActivatesAndClears(t *testing.T) {
+	kclConfig := goKCL.NewKinesisClientLibConfig("appName", "test", "us-west-2", "abc").
+		WithMaxRecords(100).
+		WithBackpressure(90, 1000)
+	b := newBackpressureDetector(kclConfig)
+	start := time.Now()
+
+	// saturated, but not for the whole window yet
+	assert.False(t, b.observe(100, 100, start))
+	assert.False(t, b.observe(95, 100, start.Add(500*time.Millisecond)))
+	assert.False(t, b.active)
+
+	// sustained saturation activates the signal once
+	assert.True(t, b.observe(100, 100, start.Add(1000*time.Millisecond)))
+	assert.True(t, b.active)
+	assert.False(t, b.observe(100, 100, start.Add(1500*time.Millisecond)))
+
+	// draining the buffer clears it
+	assert.True(t, b.observe(10, 100, start.Add(2000*time.Millisecond)))
+	assert.False(t, b.active)
+}
+
+func TestBackpressureRequiresSustainedSaturation(t *testing.T) {
+	kclConfig := goKCL.NewKinesisClientLibConfig("appName", "test", "us-west-2", "abc").
+		WithBackpressure(90, 1000)
+	b := newBackpressureDetector(kclConfig)
+	start := time.Now()
+
+	assert.False(t, b.observe(100, 100, start))
+	// a dip below the threshold restarts the window
+	assert.False(t, b.observe(50, 100, start.Add(600*time.Millisecond)))
+	assert.False(t, b.observe(100, 100, start.Add(700*time.Millisecond)))
+	assert.False(t, b.observe(100, 100, start.Add(1200*time.Millisecond)))
+	assert.False(t, b.active)
+}
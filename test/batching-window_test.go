@@ -0,0 +1,83 @@
+package shard
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/guygma/goKCL/record"
+)
+
+func TestBatchingWindowSize(t *testing.T) {
+	kc := newMockKinesisClient(10, true)
+	checkpointer := newMockShardCheckpointer()
+	processor := &batchRecordingProcessor{}
+	sc := newTestConsumer(kc, checkpointer, processor, testConfig().
+		WithMaxRecords(2).
+		WithBatchingWindow(6, 3600000))
+
+	assert.Nil(t, sc.GetRecords(testShard()))
+	assert.Equal(t, 5, kc.getRecordsCalls)
+	// the end of the shard delivers the pending batch
+	assert.Equal(t, []int{6, 4}, processor.batches)
+	assert.Equal(t, []string{"1", "2", "3", "4", "5", "6", "7", "8", "9", "10"}, processor.sequenceNumbers())
+	// every batch is checkpointed as a whole
+	assert.Equal(t, []string{"6", "10", SHARD_END}, checkpointer.history)
+}
+
+func TestBatchingWindowTime(t *testing.T) {
+	kc := newMockKinesisClient(3, false)
+	checkpointer := newMockShardCheckpointer()
+	processor := &batchRecordingProcessor{}
+	sc := newTestConsumer(kc, checkpointer, processor, testConfig().
+		WithMaxRecords(1).
+		WithBatchingWindow(100, 50))
+
+	done := make(chan error)
+	go func() { done <- sc.GetRecords(testShard()) }()
+
+	assert.True(t, waitForDelivered(&processor.mockRecordProcessor, 3, time.Second))
+	close(*sc.stop)
+	assert.Nil(t, <-done)
+
+	assert.Equal(t, []int{3}, processor.batches)
+	assert.Equal(t, []string{"3"}, checkpointer.history)
+}
+
+func TestBatchingWindow(t *testing.T) {
+	now := time.Now()
+	w := &batchingWindow{maxRecords: 3, maxWait: time.Second}
+	assert.True(t, w.enabled())
+	assert.False(t, (&batchingWindow{}).enabled())
+
+	w.add(autoCheckpointRecords(), now)
+	assert.False(t, w.ready(now.Add(time.Hour)))
+	w.add(autoCheckpointRecords(1, 2), now)
+	assert.False(t, w.ready(now))
+	assert.True(t, w.ready(now.Add(time.Second)))
+	w.add(autoCheckpointRecords(3), now.Add(time.Millisecond))
+	assert.True(t, w.ready(now))
+	assert.Equal(t, 3, len(w.take()))
+
+	// the window of a new batch starts with its first record
+	w.add(autoCheckpointRecords(4), now.Add(time.Minute))
+	assert.False(t, w.ready(now.Add(time.Minute+time.Millisecond)))
+	w.reset()
+	assert.Equal(t, 0, len(w.take()))
+}
+
+// batchRecordingProcessor records the size of every non-empty batch it processes.
+type batchRecordingProcessor struct {
+	mockRecordProcessor
+	batches []int
+}
+
+func (m *batchRecordingProcessor) ProcessRecords(input *record.ProcessRecordsInput) {
+	if len(input.Records) > 0 {
+		m.mux.Lock()
+		m.batches = append(m.batches, len(input.Records))
+		m.mux.Unlock()
+	}
+	m.mockRecordProcessor.ProcessRecords(input)
+}
@@ -0,0 +1,56 @@
+package shard
+
+import (
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/guygma/goKCL/record"
+)
+
+func TestCheckpointDeferredUntilMinRecords(t *testing.T) {
+	kc := newMockKinesisClient(7, true)
+	checkpointer := newMockShardCheckpointer()
+	processor := &mockRecordProcessor{}
+	sc := newTestConsumer(kc, checkpointer, processor, testConfig().
+		WithMaxRecords(2).
+		WithCheckpointMinRecords(5, 60000))
+
+	err := sc.GetRecords(testShard())
+	assert.Nil(t, err)
+
+	// the checkpoints of the batches 2 and 4 are deferred until 5 record are delivered, the deferred checkpoint
+	// of the last batch is forced on shutdown, then the end of the shard
+	assert.Equal(t, []string{"6", "7", SHARD_END}, checkpointer.history)
+}
+
+func TestDeferredCheckpointTimeCap(t *testing.T) {
+	checkpointer := newMockShardCheckpointer()
+	st := testShard()
+	deferring := &deferringCheckpointer{
+		IRecordProcessorCheckpointer: record.NewRecordProcessorCheckpoint(st, checkpointer),
+		minRecords:                   10,
+		maxDelay:                     time.Second,
+	}
+
+	deferring.delivered(1)
+	assert.Nil(t, deferring.Checkpoint(aws.String("1")))
+	deferring.delivered(1)
+	assert.Nil(t, deferring.Checkpoint(aws.String("2")))
+	assert.Nil(t, deferring.flushDue(time.Now()))
+	assert.Empty(t, checkpointer.history)
+
+	// the latest deferred checkpoint is written once deferred for too long
+	assert.Nil(t, deferring.flushDue(time.Now().Add(time.Second)))
+	assert.Equal(t, []string{"2"}, checkpointer.history)
+
+	// the count restarts after the write
+	deferring.delivered(9)
+	assert.Nil(t, deferring.Checkpoint(aws.String("11")))
+	assert.Equal(t, []string{"2"}, checkpointer.history)
+	deferring.delivered(1)
+	assert.Nil(t, deferring.Checkpoint(aws.String("12")))
+	assert.Equal(t, []string{"2", "12"}, checkpointer.history)
+}
@@ -0,0 +1,116 @@
+package shard
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestOverrideCheckpoint(t *testing.T) {
+	table := &overridingLeaseTable{lagLeaseTable: lagLeaseTable{items: make(map[string]map[string]*dynamodb.AttributeValue)}}
+	checkpointer := NewDynamoCheckpoint(testConfig()).WithDynamoDB(table)
+
+	// an expired lease of another worker
+	leaseTimeout := time.Now().Add(-time.Minute).UTC().Format(time.RFC3339)
+	table.items["0001"] = map[string]*dynamodb.AttributeValue{
+		LEASE_KEY_KEY:                  {S: aws.String("0001")},
+		LEASE_OWNER_KEY:                {S: aws.String("def")},
+		LEASE_TIMEOUT_KEY:              {S: aws.String(leaseTimeout)},
+		CHECKPOINT_SEQUENCE_NUMBER_KEY: {S: aws.String("50")},
+	}
+
+	// the checkpoint is moved right before the record, the lease is left as it is
+	sh := &Status{ID: "0001", Mux: &sync.Mutex{}, Checkpoint: "42", CheckpointSubSequenceNumber: -1}
+	assert.Nil(t, checkpointer.OverrideCheckpoint(sh))
+	assert.Equal(t, "def", sh.AssignedTo)
+	item := table.items["0001"]
+	assert.Equal(t, "42", aws.StringValue(item[CHECKPOINT_SEQUENCE_NUMBER_KEY].S))
+	assert.Equal(t, "-1", aws.StringValue(item[CHECKPOINT_SUBSEQUENCE_KEY].N))
+	assert.Equal(t, "def", aws.StringValue(item[LEASE_OWNER_KEY].S))
+	assert.Equal(t, leaseTimeout, aws.StringValue(item[LEASE_TIMEOUT_KEY].S))
+
+	// it resumes at the record
+	fetched := &Status{ID: "0001", Mux: &sync.Mutex{}}
+	assert.Nil(t, checkpointer.FetchCheckpoint(fetched))
+	assert.Equal(t, int64(-1), fetched.CheckpointSubSequenceNumber)
+	assert.Equal(t, "AT_SEQUENCE_NUMBER", checkpointIteratorType(fetched))
+}
+
+func TestOverrideCheckpointLeaseHeldByAnotherWorker(t *testing.T) {
+	table := &overridingLeaseTable{lagLeaseTable: lagLeaseTable{items: make(map[string]map[string]*dynamodb.AttributeValue)}}
+	checkpointer := NewDynamoCheckpoint(testConfig()).WithDynamoDB(table)
+
+	leaseTimeout := time.Now().Add(time.Minute).UTC().Format(time.RFC3339)
+	table.items["0001"] = map[string]*dynamodb.AttributeValue{
+		LEASE_KEY_KEY:                  {S: aws.String("0001")},
+		LEASE_OWNER_KEY:                {S: aws.String("def")},
+		LEASE_TIMEOUT_KEY:              {S: aws.String(leaseTimeout)},
+		CHECKPOINT_SEQUENCE_NUMBER_KEY: {S: aws.String("50")},
+	}
+
+	// the live owner would write its checkpoint back on its next renewal
+	sh := &Status{ID: "0001", Mux: &sync.Mutex{}, Checkpoint: "42", AssignedTo: "abc"}
+	err := checkpointer.OverrideCheckpoint(sh)
+	assert.NotNil(t, err)
+	assert.Equal(t, ErrLeaseNotAquired, err.Error())
+	assert.Equal(t, "50", aws.StringValue(table.items["0001"][CHECKPOINT_SEQUENCE_NUMBER_KEY].S))
+
+	// its owner can
+	sh = &Status{ID: "0001", Mux: &sync.Mutex{}, Checkpoint: "42", AssignedTo: "def"}
+	assert.Nil(t, checkpointer.OverrideCheckpoint(sh))
+	assert.Equal(t, "42", aws.StringValue(table.items["0001"][CHECKPOINT_SEQUENCE_NUMBER_KEY].S))
+}
+
+func TestOverrideCheckpointRenewedMeanwhile(t *testing.T) {
+	table := &overridingLeaseTable{lagLeaseTable: lagLeaseTable{items: make(map[string]map[string]*dynamodb.AttributeValue)}}
+	checkpointer := NewDynamoCheckpoint(testConfig()).WithDynamoDB(table)
+
+	table.items["0001"] = map[string]*dynamodb.AttributeValue{
+		LEASE_KEY_KEY:                  {S: aws.String("0001")},
+		LEASE_OWNER_KEY:                {S: aws.String("def")},
+		LEASE_TIMEOUT_KEY:              {S: aws.String(time.Now().Add(time.Minute).UTC().Format(time.RFC3339))},
+		CHECKPOINT_SEQUENCE_NUMBER_KEY: {S: aws.String("50")},
+	}
+	// the owner renews the lease between the read and the write
+	table.beforeUpdate = func(item map[string]*dynamodb.AttributeValue) {
+		item[LEASE_TIMEOUT_KEY] = &dynamodb.AttributeValue{
+			S: aws.String(time.Now().Add(2 * time.Minute).UTC().Format(time.RFC3339)),
+		}
+	}
+
+	sh := &Status{ID: "0001", Mux: &sync.Mutex{}, Checkpoint: "42", AssignedTo: "def"}
+	err := checkpointer.OverrideCheckpoint(sh)
+	assert.NotNil(t, err)
+	assert.Equal(t, ErrLeaseNotAquired, err.Error())
+	assert.Equal(t, "50", aws.StringValue(table.items["0001"][CHECKPOINT_SEQUENCE_NUMBER_KEY].S))
+}
+
+// overridingLeaseTable is a lease table supporting the conditional updates overriding the checkpoints.
+type overridingLeaseTable struct {
+	lagLeaseTable
+	beforeUpdate func(item map[string]*dynamodb.AttributeValue)
+}
+
+func (m *overridingLeaseTable) UpdateItem(input *dynamodb.UpdateItemInput) (*dynamodb.UpdateItemOutput, error) {
+	item := m.items[aws.StringValue(input.Key[LEASE_KEY_KEY].S)]
+	if m.beforeUpdate != nil {
+		m.beforeUpdate(item)
+	}
+	if aws.StringValue(item[LEASE_OWNER_KEY].S) != aws.StringValue(input.ExpressionAttributeValues[":assigned_to"].S) ||
+		aws.StringValue(item[LEASE_TIMEOUT_KEY].S) != aws.StringValue(input.ExpressionAttributeValues[":lease_timeout"].S) {
+		return nil, awserr.New(dynamodb.ErrCodeConditionalCheckFailedException, "lease changed", nil)
+	}
+	item[CHECKPOINT_SEQUENCE_NUMBER_KEY] = input.ExpressionAttributeValues[":checkpoint"]
+	if subSequence, ok := input.ExpressionAttributeValues[":sub_sequence"]; ok {
+		item[CHECKPOINT_SUBSEQUENCE_KEY] = subSequence
+	} else {
+		delete(item, CHECKPOINT_SUBSEQUENCE_KEY)
+	}
+	delete(item, aws.StringValue(input.ExpressionAttributeNames["#owner_switches"]))
+	return &dynamodb.UpdateItemOutput{}, nil
+}
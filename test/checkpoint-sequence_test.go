@@ -0,0 +1,79 @@
+package shard
+
+import (
+	"errors"
+	"sync"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/guygma/goKCL/record"
+	"github.com/guygma/goKCL/util"
+)
+
+func TestCheckpointSequenceValidatesShardRange(t *testing.T) {
+	checkpointer := newMockShardCheckpointer()
+	st := testShard()
+	st.StartingSequenceNumber = "10"
+	st.EndingSequenceNumber = "99"
+	rc := record.NewRecordProcessorCheckpoint(st, checkpointer)
+
+	for _, sequenceNumber := range []string{"5", "100", "not-a-number", ""} {
+		err := rc.CheckpointSequence(sequenceNumber)
+		assert.True(t, errors.Is(err, util.IllegalArgumentError.MakeErr()), sequenceNumber)
+	}
+	assert.Empty(t, checkpointer.history)
+
+	// the checkpoint may lag the last record delivered
+	assert.Nil(t, rc.CheckpointSequence("42"))
+	assert.Equal(t, []string{"42"}, checkpointer.history)
+	assert.Equal(t, int64(0), st.CheckpointSubSequenceNumber)
+
+	assert.Nil(t, rc.CheckpointSequenceWithSubSequence("43", 2))
+	assert.Equal(t, []string{"42", "43"}, checkpointer.history)
+	assert.Equal(t, int64(2), st.CheckpointSubSequenceNumber)
+
+	err := rc.CheckpointSequenceWithSubSequence("43", -1)
+	assert.True(t, errors.Is(err, util.IllegalArgumentError.MakeErr()))
+	assert.Equal(t, 2, len(checkpointer.history))
+}
+
+func TestCheckpointSubSequenceIsPersisted(t *testing.T) {
+	svc := &lagLeaseTable{items: make(map[string]map[string]*dynamodb.AttributeValue)}
+	checkpointer := NewDynamoCheckpoint(testConfig()).WithDynamoDB(svc)
+	st := &Status{ID: "0001", Mux: &sync.Mutex{}}
+	assert.Nil(t, checkpointer.GetLease(st, "worker-1"))
+
+	rc := record.NewRecordProcessorCheckpoint(st, checkpointer)
+	assert.Nil(t, rc.CheckpointSequenceWithSubSequence("5", 3))
+
+	fetched := &Status{ID: "0001", Mux: &sync.Mutex{}}
+	assert.Nil(t, checkpointer.FetchCheckpoint(fetched))
+	assert.Equal(t, "5", fetched.Checkpoint)
+	assert.Equal(t, int64(3), fetched.CheckpointSubSequenceNumber)
+
+	// a checkpoint of a whole record clears it
+	assert.Nil(t, rc.Checkpoint(aws.String("6")))
+	assert.Nil(t, checkpointer.FetchCheckpoint(fetched))
+	assert.Equal(t, "6", fetched.Checkpoint)
+	assert.Equal(t, int64(0), fetched.CheckpointSubSequenceNumber)
+}
+
+func TestCheckpointSubSequenceResumesAtRecord(t *testing.T) {
+	kc := newMockKinesisClient(4, true)
+	checkpointer := newMockShardCheckpointer()
+	checkpointer.checkpoints["0001"] = "2"
+	processor := &mockRecordProcessor{}
+	sc := newTestConsumer(kc, checkpointer, processor, testConfig())
+	st := testShard()
+	st.CheckpointSubSequenceNumber = 1
+
+	err := sc.GetRecords(st)
+	assert.Nil(t, err)
+
+	// the aggregated record partially processed is delivered again
+	assert.Equal(t, "AT_SEQUENCE_NUMBER", aws.StringValue(kc.iteratorRequests[0].ShardIteratorType))
+	assert.Equal(t, []string{"2", "3", "4"}, processor.sequenceNumbers())
+}
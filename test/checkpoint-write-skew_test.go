@@ -0,0 +1,60 @@
+package shard
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/guygma/goKCL/util"
+)
+
+func TestCheckpointWriteSkew(t *testing.T) {
+	listener := &mockEventListener{}
+	svc := &skewLeaseTable{lagLeaseTable{items: make(map[string]map[string]*dynamodb.AttributeValue)}}
+	checkpointer := NewDynamoCheckpoint(testConfig().WithEventListener(listener)).WithDynamoDB(svc)
+
+	// the sole owner checkpoints without skew
+	previous := &Status{ID: "0001", Mux: &sync.Mutex{}}
+	assert.Nil(t, checkpointer.GetLease(previous, "worker-a"))
+	previous.Checkpoint = "5"
+	assert.Nil(t, checkpointer.CheckpointSequence(previous))
+	assert.Empty(t, listener.received())
+
+	// another worker takes the lease over while the previous owner is still processing the shard
+	next := &Status{ID: "0001", Mux: &sync.Mutex{}, Checkpoint: "5"}
+	assert.Nil(t, checkpointer.StealLease(next, "worker-a", "worker-b"))
+
+	// both keep checkpointing
+	previous.Checkpoint = "10"
+	assert.Nil(t, checkpointer.CheckpointSequence(previous))
+	next.Checkpoint = "12"
+	assert.Nil(t, checkpointer.CheckpointSequence(next))
+
+	events := listener.received()
+	assert.Equal(t, 2, len(events))
+	for _, event := range events {
+		assert.Equal(t, util.CRITICAL, event.Severity)
+		assert.Equal(t, util.EVENT_CHECKPOINT_WRITE_SKEW, event.Type)
+		assert.Equal(t, "0001", event.ShardID)
+	}
+	assert.Equal(t, "checkpoint 10 written by worker-a while the lease was owned by worker-b, 1 owner switches "+
+		"since the last checkpoint", events[0].Detail)
+	assert.Contains(t, events[1].Detail, "written by worker-b while the lease was owned by worker-a")
+}
+
+// skewLeaseTable is a lease table returning the replaced item of a put when asked to.
+type skewLeaseTable struct {
+	lagLeaseTable
+}
+
+func (m *skewLeaseTable) PutItem(input *dynamodb.PutItemInput) (*dynamodb.PutItemOutput, error) {
+	old := m.items[aws.StringValue(input.Item[LEASE_KEY_KEY].S)]
+	output, err := m.lagLeaseTable.PutItem(input)
+	if err == nil && aws.StringValue(input.ReturnValues) == dynamodb.ReturnValueAllOld {
+		output.Attributes = old
+	}
+	return output, err
+}
@@ -0,0 +1,43 @@
+package goKCL
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/kinesis"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/guygma/goKCL/shard"
+)
+
+func TestMaxChildLeasesPerAcquisition(t *testing.T) {
+	// a parent shard processed to its end, which ended into 8 children
+	shards := []*kinesis.Shard{mockShard("shardId-0", "0", "340282366920938463463374607431768211455")}
+	for i := 1; i <= 8; i++ {
+		child := mockShard(fmt.Sprintf("shardId-%d", i), "0", "340282366920938463463374607431768211455")
+		child.ParentShardId = aws.String("shardId-0")
+		shards = append(shards, child)
+	}
+	store := newMemoryLeaseStore(10 * time.Second)
+	store.checkpoints["shardId-0"] = shard.SHARD_END
+
+	kclConfig := NewKinesisClientLibConfig("appName", "children", "us-west-2", "worker-a").
+		WithFailoverTimeMillis(10000).
+		WithShardSyncIntervalMillis(10000).
+		WithMaxLeasesPerAcquisition(8).
+		WithMaxChildLeasesPerAcquisition(2)
+	worker := NewWorker(&mockProcessorFactory{}, kclConfig, nil).
+		WithKinesis(&mockKinesis{shards: shards}).
+		WithCheckpointer(store)
+	assert.Nil(t, worker.Start())
+	defer worker.Shutdown()
+
+	// the first cycle creates the leases of 2 children only, the others are deferred
+	time.Sleep(200 * time.Millisecond)
+	store.mux.Lock()
+	defer store.mux.Unlock()
+	assert.Equal(t, 2, len(store.owners))
+	assert.Empty(t, store.owners["shardId-0"])
+}
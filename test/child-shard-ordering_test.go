@@ -0,0 +1,158 @@
+package kcltest
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/guygma/goKCL"
+	"github.com/guygma/goKCL/record"
+	"github.com/guygma/goKCL/shard"
+	"github.com/guygma/goKCL/util"
+)
+
+func TestChildShardsWaitForSplitParent(t *testing.T) {
+	stream := NewStream("orders", 1)
+	store := NewLeaseStore()
+	parent := stream.ShardIDs()[0]
+	for i := 0; i < 10; i++ {
+		_, _, err := stream.Put(fmt.Sprintf("order-%d", i), []byte("created"))
+		assert.Nil(t, err)
+	}
+
+	// the children are listed along with their parent, which still has a backlog
+	children, err := stream.SplitShard(parent, "170141183460469231731687303715884105728")
+	assert.Nil(t, err)
+	for i := 10; i < 20; i++ {
+		_, _, err := stream.Put(fmt.Sprintf("order-%d", i), []byte("created"))
+		assert.Nil(t, err)
+	}
+
+	factory := newOrderingFactory(store, map[string][]string{children[0]: {parent}, children[1]: {parent}}, parent)
+	worker := startOrderingWorker(t, stream, store, factory)
+	defer worker.Shutdown()
+
+	for _, child := range children {
+		AssertCheckpoint(t, store, child, lastSequenceNumber(t, stream, child), 2*time.Second)
+	}
+	assert.Equal(t, shard.SHARD_END, store.Checkpoint(parent))
+	assert.Equal(t, 20, factory.count())
+	assert.Empty(t, factory.violations())
+}
+
+func TestChildShardWaitsForMergedParents(t *testing.T) {
+	stream := NewStream("orders", 2)
+	store := NewLeaseStore()
+	parents := stream.ShardIDs()
+	for i := 0; i < 10; i++ {
+		_, _, err := stream.Put(fmt.Sprintf("order-%d", i), []byte("created"))
+		assert.Nil(t, err)
+	}
+
+	child, err := stream.MergeShards(parents[0], parents[1])
+	assert.Nil(t, err)
+	for i := 10; i < 20; i++ {
+		_, _, err := stream.Put(fmt.Sprintf("order-%d", i), []byte("created"))
+		assert.Nil(t, err)
+	}
+
+	factory := newOrderingFactory(store, map[string][]string{child: parents}, parents...)
+	worker := startOrderingWorker(t, stream, store, factory)
+	defer worker.Shutdown()
+
+	AssertCheckpoint(t, store, child, lastSequenceNumber(t, stream, child), 2*time.Second)
+	for _, parent := range parents {
+		assert.Equal(t, shard.SHARD_END, store.Checkpoint(parent))
+	}
+	assert.Equal(t, 20, factory.count())
+	assert.Empty(t, factory.violations())
+}
+
+// startOrderingWorker starts a worker reading the stream in small batches from its start.
+func startOrderingWorker(t *testing.T, stream *Stream, store *LeaseStore, factory *orderingFactory) *goKCL.Worker {
+	kclConfig := goKCL.NewKinesisClientLibConfig("appName", "orders", "us-west-2", "worker").
+		WithInitialPositionInStream(goKCL.TRIM_HORIZON).
+		WithMaxRecords(2).
+		WithShardSyncIntervalMillis(20).
+		WithIdleTimeBetweenReadsInMillis(10)
+	worker := goKCL.NewWorker(factory, kclConfig, nil).WithKinesis(stream).WithLeaseStore(store)
+	assert.Nil(t, worker.Start())
+	return worker
+}
+
+// orderingFactory creates record processors checking that the record of a child shard are only delivered once its
+// parents are checkpointed at SHARD_END. The processors of the slow shards take their time, leaving room for the
+// children to start early.
+type orderingFactory struct {
+	store   *LeaseStore
+	parents map[string][]string
+	slow    map[string]bool
+
+	mux      sync.Mutex
+	records  int
+	violated []string
+}
+
+func newOrderingFactory(store *LeaseStore, parents map[string][]string, slow ...string) *orderingFactory {
+	f := &orderingFactory{store: store, parents: parents, slow: map[string]bool{}}
+	for _, shardID := range slow {
+		f.slow[shardID] = true
+	}
+	return f
+}
+
+func (f *orderingFactory) CreateProcessor() record.IRecordProcessor {
+	return &orderingProcessor{factory: f}
+}
+
+func (f *orderingFactory) count() int {
+	f.mux.Lock()
+	defer f.mux.Unlock()
+	return f.records
+}
+
+func (f *orderingFactory) violations() []string {
+	f.mux.Lock()
+	defer f.mux.Unlock()
+	return append([]string(nil), f.violated...)
+}
+
+type orderingProcessor struct {
+	factory *orderingFactory
+	shardID string
+}
+
+func (p *orderingProcessor) Initialize(input *shard.InitializationInput) {
+	p.shardID = input.ShardId
+}
+
+func (p *orderingProcessor) ProcessRecords(input *record.ProcessRecordsInput) {
+	if len(input.Records) == 0 {
+		return
+	}
+
+	f := p.factory
+	f.mux.Lock()
+	for _, parent := range f.parents[p.shardID] {
+		if checkpoint := f.store.Checkpoint(parent); checkpoint != shard.SHARD_END {
+			f.violated = append(f.violated,
+				fmt.Sprintf("record of %s delivered with its parent %s at %q", p.shardID, parent, checkpoint))
+		}
+	}
+	f.records += len(input.Records)
+	f.mux.Unlock()
+
+	if f.slow[p.shardID] {
+		time.Sleep(30 * time.Millisecond)
+	}
+	input.Checkpointer.Checkpoint(input.Records[len(input.Records)-1].SequenceNumber)
+}
+
+func (p *orderingProcessor) Shutdown(input *util.ShutdownInput) {
+	if input.ShutdownReason == util.TERMINATE {
+		input.Checkpointer.Checkpoint(nil)
+	}
+}
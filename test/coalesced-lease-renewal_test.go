@@ -0,0 +1,95 @@
+package shard
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCoalescedLeaseRenewal(t *testing.T) {
+	table := &coalescingLeaseTable{lagLeaseTable: lagLeaseTable{items: make(map[string]map[string]*dynamodb.AttributeValue)}}
+	checkpointer := NewDynamoCheckpoint(testConfig().WithCoalescedLeaseRenewals(true)).WithDynamoDB(table)
+
+	// a lease held by the worker, due for renewal in a minute out of the 5 minutes of the failover time
+	leaseTimeout := time.Now().Add(time.Minute).UTC()
+	table.items["0001"] = map[string]*dynamodb.AttributeValue{
+		LEASE_KEY_KEY:            {S: aws.String("0001")},
+		LEASE_OWNER_KEY:          {S: aws.String("abc")},
+		LEASE_TIMEOUT_KEY:        {S: aws.String(leaseTimeout.Format(time.RFC3339))},
+		OWNER_SWITCHES_KEY:       {N: aws.String("2")},
+		MILLIS_BEHIND_LATEST_KEY: {N: aws.String("1000")},
+	}
+	sh := &Status{ID: "0001", Mux: &sync.Mutex{}, AssignedTo: "abc", LeaseTimeout: leaseTimeout, Checkpoint: "5"}
+
+	// the checkpoint renews the lease in the same update
+	assert.Nil(t, checkpointer.CheckpointSequence(sh))
+	assert.Equal(t, 1, table.updates)
+	assert.Equal(t, 0, table.puts)
+	item := table.items["0001"]
+	assert.Equal(t, "5", aws.StringValue(item[CHECKPOINT_SEQUENCE_NUMBER_KEY].S))
+	assert.Equal(t, "abc", aws.StringValue(item[LEASE_OWNER_KEY].S))
+	assert.Equal(t, sh.LeaseTimeout.Format(time.RFC3339), aws.StringValue(item[LEASE_TIMEOUT_KEY].S))
+	assert.True(t, sh.LeaseTimeout.After(time.Now().Add(4*time.Minute)))
+	assert.Nil(t, item[OWNER_SWITCHES_KEY])
+	assert.Equal(t, "1000", aws.StringValue(item[MILLIS_BEHIND_LATEST_KEY].N))
+
+	// the lease renewed, the next checkpoint is written alone
+	sh.Checkpoint = "6"
+	assert.Nil(t, checkpointer.CheckpointSequence(sh))
+	assert.Equal(t, 1, table.updates)
+	assert.Equal(t, 1, table.puts)
+}
+
+func TestCoalescedLeaseRenewalLost(t *testing.T) {
+	table := &coalescingLeaseTable{lagLeaseTable: lagLeaseTable{items: make(map[string]map[string]*dynamodb.AttributeValue)}}
+	checkpointer := NewDynamoCheckpoint(testConfig().WithCoalescedLeaseRenewals(true)).WithDynamoDB(table)
+
+	// the lease was taken over by another worker meanwhile
+	leaseTimeout := time.Now().Add(time.Minute).UTC()
+	table.items["0001"] = map[string]*dynamodb.AttributeValue{
+		LEASE_KEY_KEY:     {S: aws.String("0001")},
+		LEASE_OWNER_KEY:   {S: aws.String("def")},
+		LEASE_TIMEOUT_KEY: {S: aws.String(leaseTimeout.Format(time.RFC3339))},
+	}
+	sh := &Status{ID: "0001", Mux: &sync.Mutex{}, AssignedTo: "abc", LeaseTimeout: leaseTimeout, Checkpoint: "5"}
+
+	err := checkpointer.CheckpointSequence(sh)
+	assert.NotNil(t, err)
+	assert.Equal(t, ErrLeaseNotAquired, err.Error())
+	assert.Nil(t, table.items["0001"][CHECKPOINT_SEQUENCE_NUMBER_KEY])
+}
+
+// coalescingLeaseTable is a lease table supporting the updates of the checkpoints renewing the leases.
+type coalescingLeaseTable struct {
+	lagLeaseTable
+	puts    int
+	updates int
+}
+
+func (m *coalescingLeaseTable) PutItem(input *dynamodb.PutItemInput) (*dynamodb.PutItemOutput, error) {
+	m.puts++
+	return m.lagLeaseTable.PutItem(input)
+}
+
+func (m *coalescingLeaseTable) UpdateItem(input *dynamodb.UpdateItemInput) (*dynamodb.UpdateItemOutput, error) {
+	m.updates++
+	item := m.items[aws.StringValue(input.Key[LEASE_KEY_KEY].S)]
+	if aws.StringValue(item[LEASE_OWNER_KEY].S) != aws.StringValue(input.ExpressionAttributeValues[":assigned_to"].S) ||
+		aws.StringValue(item[LEASE_TIMEOUT_KEY].S) != aws.StringValue(input.ExpressionAttributeValues[":lease_timeout"].S) {
+		return nil, awserr.New(dynamodb.ErrCodeConditionalCheckFailedException, "lease changed", nil)
+	}
+	item[CHECKPOINT_SEQUENCE_NUMBER_KEY] = input.ExpressionAttributeValues[":checkpoint"]
+	item[LEASE_TIMEOUT_KEY] = input.ExpressionAttributeValues[":new_lease_timeout"]
+	if subSequence, ok := input.ExpressionAttributeValues[":sub_sequence"]; ok {
+		item[CHECKPOINT_SUBSEQUENCE_KEY] = subSequence
+	} else {
+		delete(item, CHECKPOINT_SUBSEQUENCE_KEY)
+	}
+	delete(item, aws.StringValue(input.ExpressionAttributeNames["#owner_switches"]))
+	return &dynamodb.UpdateItemOutput{}, nil
+}
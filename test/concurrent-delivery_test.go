@@ -0,0 +1,87 @@
+package shard
+
+import (
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/service/kinesis"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/guygma/goKCL/record"
+)
+
+func TestConcurrentDelivery(t *testing.T) {
+	processor := &orderIndependentProcessor{delay: 20 * time.Millisecond}
+	checkpointer := &completionRecordingCheckpointer{mockShardCheckpointer: newMockShardCheckpointer(), processor: processor}
+	sc := newTestConsumer(newMockKinesisClient(8, true), checkpointer, processor, testConfig().
+		WithMaxRecords(8).
+		WithRecordDeliveryConcurrency(4))
+
+	assert.Nil(t, sc.GetRecords(testShard()))
+	assert.Equal(t, int32(8), atomic.LoadInt32(&processor.processed))
+	assert.True(t, atomic.LoadInt32(&processor.maxInFlight) > 1)
+	assert.True(t, atomic.LoadInt32(&processor.maxInFlight) <= 4)
+	assert.False(t, processor.batchDelivered)
+
+	// the batch is checkpointed once all its record are processed
+	assert.Equal(t, []string{"8", SHARD_END}, checkpointer.history)
+	assert.Equal(t, []int32{8, 8}, checkpointer.processedAtCheckpoint)
+}
+
+func TestConcurrentDeliveryRequiresOptIn(t *testing.T) {
+	processor := &orderIndependentProcessor{}
+	sc := newTestConsumer(newMockKinesisClient(8, true), newMockShardCheckpointer(), processor, testConfig().
+		WithMaxRecords(8))
+
+	assert.Nil(t, sc.GetRecords(testShard()))
+	assert.True(t, processor.batchDelivered)
+	assert.Equal(t, int32(0), atomic.LoadInt32(&processor.processed))
+}
+
+// orderIndependentProcessor processes record one by one, tracking how many are processed concurrently.
+type orderIndependentProcessor struct {
+	mockRecordProcessor
+	delay          time.Duration
+	processed      int32
+	inFlight       int32
+	maxInFlight    int32
+	batchDelivered bool
+}
+
+func (m *orderIndependentProcessor) ProcessRecords(input *record.ProcessRecordsInput) {
+	if len(input.Records) > 0 {
+		m.batchDelivered = true
+	}
+	m.mockRecordProcessor.ProcessRecords(input)
+}
+
+func (m *orderIndependentProcessor) ProcessRecord(r *kinesis.Record) {
+	n := atomic.AddInt32(&m.inFlight, 1)
+	defer atomic.AddInt32(&m.inFlight, -1)
+	for {
+		max := atomic.LoadInt32(&m.maxInFlight)
+		if n <= max || atomic.CompareAndSwapInt32(&m.maxInFlight, max, n) {
+			break
+		}
+	}
+
+	time.Sleep(m.delay)
+	atomic.AddInt32(&m.processed, 1)
+}
+
+// completionRecordingCheckpointer records how many record were processed when every checkpoint was written.
+type completionRecordingCheckpointer struct {
+	*mockShardCheckpointer
+	processor             *orderIndependentProcessor
+	completionMux         sync.Mutex
+	processedAtCheckpoint []int32
+}
+
+func (m *completionRecordingCheckpointer) CheckpointSequence(shard *Status) error {
+	m.completionMux.Lock()
+	m.processedAtCheckpoint = append(m.processedAtCheckpoint, atomic.LoadInt32(&m.processor.processed))
+	m.completionMux.Unlock()
+	return m.mockShardCheckpointer.CheckpointSequence(shard)
+}
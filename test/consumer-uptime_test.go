@@ -0,0 +1,39 @@
+package shard
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestConsumerRestartCounter(t *testing.T) {
+	shard := testShard()
+	mService := newMockMonitoringService()
+
+	for i := 0; i < 3; i++ {
+		sc := newTestConsumer(newMockKinesisClient(2, true), newMockShardCheckpointer(), &mockRecordProcessor{}, testConfig())
+		sc.mService = mService
+		assert.Nil(t, sc.GetRecords(shard))
+
+		// the consumer is done with the closed shard
+		assert.Equal(t, time.Duration(0), shard.GetConsumerUptime(time.Now()))
+	}
+
+	assert.Equal(t, 2, shard.GetConsumerRestarts())
+	assert.Equal(t, 2, mService.consumerRestarts)
+}
+
+func TestConsumerUptimeResetOnRestart(t *testing.T) {
+	shard := testShard()
+	start := time.Now()
+
+	assert.False(t, shard.MarkConsumerStarted(start))
+	assert.Equal(t, time.Minute, shard.GetConsumerUptime(start.Add(time.Minute)))
+	assert.Equal(t, 0, shard.GetConsumerRestarts())
+
+	shard.MarkConsumerStopped()
+	assert.True(t, shard.MarkConsumerStarted(start.Add(2*time.Minute)))
+	assert.Equal(t, time.Second, shard.GetConsumerUptime(start.Add(2*time.Minute+time.Second)))
+	assert.Equal(t, 1, shard.GetConsumerRestarts())
+}
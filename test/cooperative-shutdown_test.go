@@ -0,0 +1,184 @@
+package goKCL
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/service/kinesis"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/guygma/goKCL/record"
+	"github.com/guygma/goKCL/shard"
+	"github.com/guygma/goKCL/util"
+)
+
+func TestCooperativeShutdown(t *testing.T) {
+	kc := &mockKinesis{
+		shards: []*kinesis.Shard{
+			mockShard("shardId-0", "0", "170141183460469231731687303715884105727"),
+			mockShard("shardId-1", "170141183460469231731687303715884105728", "340282366920938463463374607431768211455"),
+		},
+	}
+	store := newMemoryLeaseStore(10 * time.Second)
+	newWorker := func(workerID string) *Worker {
+		kclConfig := NewKinesisClientLibConfig("appName", "test", "us-west-2", workerID).
+			WithFailoverTimeMillis(10000).
+			WithShardSyncIntervalMillis(60000).
+			WithMaxLeasesPerAcquisition(2).
+			WithIdleTimeBetweenReadsInMillis(10).
+			WithCooperativeShutdown(10)
+		return NewWorker(&mockProcessorFactory{}, kclConfig, nil).WithKinesis(kc).WithCheckpointer(store)
+	}
+
+	departing := newWorker("worker-a")
+	assert.Nil(t, departing.Start())
+	assert.True(t, store.waitForOwner("worker-a", time.Second, "shardId-0", "shardId-1"))
+
+	peer := newWorker("worker-b")
+	assert.Nil(t, peer.Start())
+	defer peer.Shutdown()
+
+	// the leases are taken over long before they expire or the peer syncs shards again
+	departing.Shutdown()
+	assert.True(t, store.waitForOwner("worker-b", 3*time.Second, "shardId-0", "shardId-1"))
+}
+
+// memoryLeaseStore keeps leases in memory and supports cooperative shutdown.
+type memoryLeaseStore struct {
+	mux           sync.Mutex
+	leaseDuration time.Duration
+	owners        map[string]string
+	leaseTimeouts map[string]time.Time
+	checkpoints   map[string]string
+	subSequences  map[string]int64
+	signal        *shard.LeaseReleaseSignal
+}
+
+func newMemoryLeaseStore(leaseDuration time.Duration) *memoryLeaseStore {
+	return &memoryLeaseStore{
+		leaseDuration: leaseDuration,
+		owners:        make(map[string]string),
+		leaseTimeouts: make(map[string]time.Time),
+		checkpoints:   make(map[string]string),
+		subSequences:  make(map[string]int64),
+	}
+}
+
+func (m *memoryLeaseStore) Init() error {
+	return nil
+}
+
+func (m *memoryLeaseStore) GetLease(sh *shard.Status, newAssignTo string) error {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+	owner := m.owners[sh.ID]
+	if owner != "" && owner != newAssignTo && time.Now().Before(m.leaseTimeouts[sh.ID]) {
+		return errors.New(shard.ErrLeaseNotAquired)
+	}
+	m.owners[sh.ID] = newAssignTo
+	m.leaseTimeouts[sh.ID] = time.Now().Add(m.leaseDuration)
+
+	sh.Mux.Lock()
+	sh.AssignedTo = newAssignTo
+	sh.LeaseTimeout = m.leaseTimeouts[sh.ID]
+	sh.Mux.Unlock()
+	return nil
+}
+
+func (m *memoryLeaseStore) CheckpointSequence(sh *shard.Status) error {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+	m.checkpoints[sh.ID] = sh.Checkpoint
+	m.subSequences[sh.ID] = sh.CheckpointSubSequenceNumber
+	return nil
+}
+
+func (m *memoryLeaseStore) OverrideCheckpoint(sh *shard.Status) error {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+	if owner := m.owners[sh.ID]; owner != "" && owner != sh.AssignedTo && time.Now().Before(m.leaseTimeouts[sh.ID]) {
+		return errors.New(shard.ErrLeaseNotAquired)
+	}
+	m.checkpoints[sh.ID] = sh.Checkpoint
+	m.subSequences[sh.ID] = sh.CheckpointSubSequenceNumber
+	sh.Mux.Lock()
+	sh.AssignedTo = m.owners[sh.ID]
+	sh.Mux.Unlock()
+	return nil
+}
+
+func (m *memoryLeaseStore) FetchCheckpoint(sh *shard.Status) error {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+	checkpoint, ok := m.checkpoints[sh.ID]
+	if !ok {
+		return shard.ErrSequenceIDNotFound
+	}
+	sh.Mux.Lock()
+	sh.Checkpoint = checkpoint
+	sh.CheckpointSubSequenceNumber = m.subSequences[sh.ID]
+	sh.Mux.Unlock()
+	return nil
+}
+
+func (m *memoryLeaseStore) RemoveLeaseInfo(shardID string) error {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+	delete(m.owners, shardID)
+	delete(m.checkpoints, shardID)
+	return nil
+}
+
+func (m *memoryLeaseStore) RemoveLeaseOwner(shardID string) error {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+	delete(m.owners, shardID)
+	return nil
+}
+
+func (m *memoryLeaseStore) SignalLeasesReleased(workerID string, shardIDs []string) error {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+	m.signal = &shard.LeaseReleaseSignal{WorkerID: workerID, ShardIDs: shardIDs, ReleasedAt: time.Now()}
+	return nil
+}
+
+func (m *memoryLeaseStore) FetchLeaseReleaseSignal() (*shard.LeaseReleaseSignal, error) {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+	return m.signal, nil
+}
+
+// waitForOwner returns true once all the shards are owned by owner, false if that didn't happen within timeout.
+func (m *memoryLeaseStore) waitForOwner(owner string, timeout time.Duration, shardIDs ...string) bool {
+	deadline := time.Now().Add(timeout)
+	for time.Now().Before(deadline) {
+		m.mux.Lock()
+		owned := true
+		for _, shardID := range shardIDs {
+			owned = owned && m.owners[shardID] == owner
+		}
+		m.mux.Unlock()
+		if owned {
+			return true
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	return false
+}
+
+type mockProcessorFactory struct{}
+
+func (f *mockProcessorFactory) CreateProcessor() record.IRecordProcessor {
+	return &noopRecordProcessor{}
+}
+
+type noopRecordProcessor struct{}
+
+func (p *noopRecordProcessor) Initialize(input *shard.InitializationInput) {}
+
+func (p *noopRecordProcessor) ProcessRecords(input *record.ProcessRecordsInput) {}
+
+func (p *noopRecordProcessor) Shutdown(input *util.ShutdownInput) {}
@@ -0,0 +1,35 @@
+package util
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRegisterErrorCode(t *testing.T) {
+	const enrichmentUnavailable ErrorCode = 50001
+	assert.Nil(t, RegisterErrorCode(enrichmentUnavailable, true, http.StatusBadGateway, "Enrichment service unavailable"))
+
+	err := enrichmentUnavailable.MakeErr().WithDetail("shard %s", "0001")
+	assert.Equal(t, enrichmentUnavailable, err.ErrorCode)
+	assert.Equal(t, http.StatusBadGateway, err.Status)
+	assert.Equal(t, "Enrichment service unavailable", enrichmentUnavailable.Message())
+	assert.True(t, IsRetryable(err))
+	assert.True(t, errors.Is(enrichmentUnavailable.MakeError("down"), err))
+
+	// a code can't be registered twice
+	dup := RegisterErrorCode(enrichmentUnavailable, false, http.StatusInternalServerError, "Duplicate")
+	assert.Equal(t, IllegalArgumentError, dup.(*ClientLibraryError).ErrorCode)
+	assert.True(t, enrichmentUnavailable.MakeErr().Retryable)
+}
+
+func TestRegisterReservedErrorCode(t *testing.T) {
+	for _, code := range []ErrorCode{41000, 41500, 42000} {
+		err := RegisterErrorCode(code, true, http.StatusServiceUnavailable, "Reserved")
+		assert.NotNil(t, err)
+		assert.True(t, errors.Is(err, IllegalArgumentError.MakeErr()))
+	}
+	assert.Equal(t, "Requests are throttled by a service (e.g. DynamoDB when storing a checkpoint).", ThrottlingError.Message())
+}
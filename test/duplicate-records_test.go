@@ -0,0 +1,63 @@
+package shard
+
+import (
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/kinesis"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/guygma/goKCL"
+)
+
+func TestDuplicateRecordsSuppressed(t *testing.T) {
+	checkpointer := newMockShardCheckpointer()
+	processor := &mockRecordProcessor{}
+	sc := newTestConsumer(duplicateRecordsClient(), checkpointer, processor, testConfig().
+		WithMaxRecords(2).
+		WithDuplicateRecordPolicy(goKCL.SUPPRESS_DUPLICATES))
+
+	assert.Nil(t, sc.GetRecords(testShard()))
+	assert.Equal(t, []string{"1", "2", "3", "4"}, processor.sequenceNumbers())
+	assert.Equal(t, 1, sc.mService.(*mockMonitoringService).duplicateRecords)
+	// the checkpoint never regresses
+	assert.Equal(t, []string{"2", "3", "4", SHARD_END}, checkpointer.history)
+}
+
+func TestDuplicateRecordsDelivered(t *testing.T) {
+	checkpointer := newMockShardCheckpointer()
+	processor := &mockRecordProcessor{}
+	sc := newTestConsumer(duplicateRecordsClient(), checkpointer, processor, testConfig().WithMaxRecords(2))
+
+	assert.Nil(t, sc.GetRecords(testShard()))
+	assert.Equal(t, []string{"1", "2", "3", "2", "4"}, processor.sequenceNumbers())
+	assert.Equal(t, []string{"2", "2", "4", SHARD_END}, checkpointer.history)
+}
+
+func TestDuplicateRecordsAfterIteratorRefresh(t *testing.T) {
+	kc := newMockKinesisClient(5, true)
+	kc.getRecordsErrors = map[int]error{
+		3: awserr.New(kinesis.ErrCodeExpiredIteratorException, "iterator expired", nil),
+	}
+	// without checkpoints the refreshed iterator starts over from the trim horizon
+	processor := &mockRecordProcessor{skipCheckpoint: true}
+	sc := newTestConsumer(kc, newMockShardCheckpointer(), processor, testConfig().
+		WithMaxRecords(2).
+		WithDuplicateRecordPolicy(goKCL.SUPPRESS_DUPLICATES))
+
+	assert.Nil(t, sc.GetRecords(testShard()))
+	assert.Equal(t, []string{"1", "2", "3", "4", "5"}, processor.sequenceNumbers())
+	assert.Equal(t, 4, sc.mService.(*mockMonitoringService).duplicateRecords)
+}
+
+// duplicateRecordsClient serves the sequence numbers 1, 2, 3, 2, 4.
+func duplicateRecordsClient() *mockKinesisClient {
+	kc := newMockKinesisClient(3, true)
+	kc.records = append(kc.records, kc.records[1], &kinesis.Record{
+		Data:           []byte("data-4"),
+		PartitionKey:   aws.String("key"),
+		SequenceNumber: aws.String("4"),
+	})
+	return kc
+}
@@ -135,6 +135,8 @@ type mockDynamoDB struct {
 	dynamodbiface.DynamoDBAPI
 	tableExist bool
 	item       map[string]*dynamodb.AttributeValue
+	// input of the last CreateTable call
+	createTableInput *dynamodb.CreateTableInput
 }
 
 func (m *mockDynamoDB) DescribeTable(*dynamodb.DescribeTableInput) (*dynamodb.DescribeTableOutput, error) {
@@ -179,7 +181,7 @@ func (m *mockDynamoDB) GetItem(input *dynamodb.GetItemInput) (*dynamodb.GetItemO
 func (m *mockDynamoDB) UpdateItem(input *dynamodb.UpdateItemInput) (*dynamodb.UpdateItemOutput, error) {
 	exp := input.UpdateExpression
 
-	if aws.StringValue(exp) == "remove "+LEASE_OWNER_KEY {
+	if aws.StringValue(exp) == "remove #assigned_to" {
 		delete(m.item, LEASE_OWNER_KEY)
 	}
 
@@ -187,5 +189,6 @@ func (m *mockDynamoDB) UpdateItem(input *dynamodb.UpdateItemInput) (*dynamodb.Up
 }
 
 func (m *mockDynamoDB) CreateTable(input *dynamodb.CreateTableInput) (*dynamodb.CreateTableOutput, error) {
+	m.createTableInput = input
 	return &dynamodb.CreateTableOutput{}, nil
 }
@@ -0,0 +1,19 @@
+package shard
+
+import (
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDynamoDBClientConfig(t *testing.T) {
+	checkpointer := NewDynamoCheckpoint(testConfig().
+		WithKinesisClientConfig(2000, 10).
+		WithDynamoDBClientConfig(500, 5))
+	checkpointer.skipTableCheck = true
+
+	assert.Nil(t, checkpointer.Init())
+	assert.Equal(t, 500*time.Millisecond, checkpointer.svc.(*dynamodb.DynamoDB).Client.Config.HTTPClient.Timeout)
+}
@@ -0,0 +1,75 @@
+package shard
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/guygma/goKCL/record"
+)
+
+func TestEmptyBatchTicks(t *testing.T) {
+	kc := newMockKinesisClient(0, false)
+	processor := &tickRecordingProcessor{}
+	sc := newTestConsumer(kc, newMockShardCheckpointer(), processor, testConfig().WithEmptyBatchIntervalMillis(50))
+
+	start := time.Now()
+	done := make(chan error)
+	go func() { done <- sc.GetRecords(testShard()) }()
+	time.Sleep(280 * time.Millisecond)
+	close(*sc.stop)
+	assert.Nil(t, <-done)
+
+	// an empty batch every 50 ms while no record arrive
+	ticks := processor.recorded()
+	assert.True(t, len(ticks) >= 4 && len(ticks) <= 5, "%d ticks", len(ticks))
+	last := start
+	for _, tick := range ticks {
+		assert.True(t, tick.Sub(last) >= 50*time.Millisecond)
+		last = tick
+	}
+}
+
+func TestEmptyBatchTicksPostponedByRecords(t *testing.T) {
+	kc := newMockKinesisClient(0, false)
+	processor := &tickRecordingProcessor{}
+	sc := newTestConsumer(kc, newMockShardCheckpointer(), processor, testConfig().WithEmptyBatchIntervalMillis(100))
+
+	done := make(chan error)
+	go func() { done <- sc.GetRecords(testShard()) }()
+	for i := 0; i < 5; i++ {
+		time.Sleep(40 * time.Millisecond)
+		kc.addRecord("data", time.Now())
+	}
+	assert.True(t, waitForDelivered(&processor.mockRecordProcessor, 5, time.Second))
+	close(*sc.stop)
+	assert.Nil(t, <-done)
+
+	// record arrived more often than the interval
+	assert.Empty(t, processor.recorded())
+	assert.Equal(t, 5, len(processor.sequenceNumbers()))
+}
+
+// tickRecordingProcessor records the time of every empty batch.
+type tickRecordingProcessor struct {
+	mockRecordProcessor
+	tickMux sync.Mutex
+	ticks   []time.Time
+}
+
+func (m *tickRecordingProcessor) ProcessRecords(input *record.ProcessRecordsInput) {
+	if len(input.Records) == 0 {
+		m.tickMux.Lock()
+		m.ticks = append(m.ticks, time.Now())
+		m.tickMux.Unlock()
+	}
+	m.mockRecordProcessor.ProcessRecords(input)
+}
+
+func (m *tickRecordingProcessor) recorded() []time.Time {
+	m.tickMux.Lock()
+	defer m.tickMux.Unlock()
+	return append([]time.Time(nil), m.ticks...)
+}
@@ -0,0 +1,86 @@
+package goKCL
+
+import (
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/kinesis"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStreamConsumerRegistration(t *testing.T) {
+	kc := &fanOutKinesis{}
+	w := NewWorker(nil, NewKinesisClientLibConfig("appName", "test", "us-west-2", "abc").
+		WithEnhancedFanOut("consumer"), nil).WithKinesis(kc)
+
+	// the consumer is registered as it doesn't exist, and deregistered on shutdown
+	assert.Nil(t, w.registerStreamConsumer())
+	assert.Equal(t, "arn:consumer", w.consumerARN)
+	assert.Equal(t, []string{"consumer"}, kc.registered)
+	w.deregisterStreamConsumer()
+	assert.Equal(t, []string{"arn:consumer"}, kc.deregistered)
+
+	// an existing consumer is used as it is, and kept on shutdown
+	kc = &fanOutKinesis{existing: true}
+	w.WithKinesis(kc)
+	assert.Nil(t, w.registerStreamConsumer())
+	assert.Equal(t, "arn:consumer", w.consumerARN)
+	assert.Empty(t, kc.registered)
+	w.deregisterStreamConsumer()
+	assert.Empty(t, kc.deregistered)
+}
+
+func TestStreamConsumerFallsBackToPolling(t *testing.T) {
+	kclConfig := NewKinesisClientLibConfig("appName", "test", "us-west-2", "abc").WithEnhancedFanOut("consumer")
+
+	// no room for another consumer
+	kc := &fanOutKinesis{full: true}
+	w := NewWorker(nil, kclConfig, nil).WithKinesis(kc)
+	assert.Nil(t, w.registerStreamConsumer())
+	assert.Equal(t, "", w.consumerARN)
+	w.deregisterStreamConsumer()
+	assert.Empty(t, kc.deregistered)
+
+	// a client without enhanced fan-out
+	w = NewWorker(nil, kclConfig, nil).WithKinesis(&recordingKinesis{kc: &mockKinesis{}})
+	assert.Nil(t, w.registerStreamConsumer())
+	assert.Equal(t, "", w.consumerARN)
+}
+
+// fanOutKinesis supports the registration of the stream consumers.
+type fanOutKinesis struct {
+	mockKinesis
+	// the consumer exists already
+	existing bool
+	// the stream has no room for another consumer
+	full         bool
+	registered   []string
+	deregistered []string
+}
+
+func (m *fanOutKinesis) DescribeStreamConsumer(input *kinesis.DescribeStreamConsumerInput) (*kinesis.DescribeStreamConsumerOutput, error) {
+	if !m.existing && len(m.registered) == 0 {
+		return nil, awserr.New(kinesis.ErrCodeResourceNotFoundException, "consumer not found", nil)
+	}
+	return &kinesis.DescribeStreamConsumerOutput{ConsumerDescription: &kinesis.ConsumerDescription{
+		ConsumerARN:    aws.String("arn:consumer"),
+		ConsumerStatus: aws.String(kinesis.ConsumerStatusActive),
+	}}, nil
+}
+
+func (m *fanOutKinesis) RegisterStreamConsumer(input *kinesis.RegisterStreamConsumerInput) (*kinesis.RegisterStreamConsumerOutput, error) {
+	if m.full {
+		return nil, awserr.New(kinesis.ErrCodeLimitExceededException, "too many consumers", nil)
+	}
+	m.registered = append(m.registered, aws.StringValue(input.ConsumerName))
+	return &kinesis.RegisterStreamConsumerOutput{Consumer: &kinesis.Consumer{
+		ConsumerARN:    aws.String("arn:consumer"),
+		ConsumerStatus: aws.String(kinesis.ConsumerStatusCreating),
+	}}, nil
+}
+
+func (m *fanOutKinesis) DeregisterStreamConsumer(input *kinesis.DeregisterStreamConsumerInput) (*kinesis.DeregisterStreamConsumerOutput, error) {
+	m.deregistered = append(m.deregistered, aws.StringValue(input.ConsumerARN))
+	return &kinesis.DeregisterStreamConsumerOutput{}, nil
+}
@@ -0,0 +1,45 @@
+package util
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestErrorWithCauses(t *testing.T) {
+	first := errors.New("shard 0001 failed")
+	second := errors.New("shard 0002 failed")
+	third := errors.New("shard 0003 failed")
+	err := ShutdownError.MakeErr().WithCauses(first, nil, second, third)
+
+	assert.Equal(t, []error{first, second, third}, err.Causes())
+	assert.Equal(t, first, errors.Unwrap(err))
+	assert.True(t, errors.Is(err, first))
+	assert.Contains(t, err.Error(), "3 causes")
+
+	// a single cause isn't counted
+	single := ShutdownError.MakeErr().WithCause(first)
+	assert.Equal(t, []error{first}, single.Causes())
+	assert.NotContains(t, single.Error(), "causes")
+	assert.Empty(t, ShutdownError.MakeErr().Causes())
+}
+
+func TestErrorCausesMarshaling(t *testing.T) {
+	err := ShutdownError.MakeErr().WithDetail("shard %s", "0001").
+		WithCauses(errors.New("first"), errors.New("second"))
+
+	b, e := json.Marshal(err)
+	assert.Nil(t, e)
+
+	var decoded map[string]interface{}
+	assert.Nil(t, json.Unmarshal(b, &decoded))
+	assert.Equal(t, []interface{}{"first", "second"}, decoded["causes"])
+	assert.Equal(t, "shard 0001", decoded["detail"])
+
+	// no causes, no list
+	b, e = json.Marshal(ShutdownError.MakeErr())
+	assert.Nil(t, e)
+	assert.NotContains(t, string(b), "causes")
+}
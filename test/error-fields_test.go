@@ -0,0 +1,37 @@
+package util
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestErrorFields(t *testing.T) {
+	fields := ShutdownError.MakeErr().Fields()
+	assert.Equal(t, map[string]interface{}{
+		"code":      int32(ShutdownError),
+		"retryable": false,
+		"status":    http.StatusServiceUnavailable,
+		"msg":       ShutdownError.Message(),
+		"detail":    "",
+	}, fields)
+}
+
+func TestErrorFieldsWithCauses(t *testing.T) {
+	inner := ThrottlingError.MakeErr().WithDetail("table %s", "leases")
+	err := KinesisClientLibDependencyError.MakeErr().WithDetail("shard %s", "0001").
+		WithCauses(inner, errors.New("connection reset"))
+
+	fields := err.Fields()
+	assert.Equal(t, int32(KinesisClientLibDependencyError), fields["code"])
+	assert.Equal(t, true, fields["retryable"])
+	assert.Equal(t, "shard 0001", fields["detail"])
+
+	causes := fields["causes"].([]interface{})
+	assert.Equal(t, 2, len(causes))
+	assert.Equal(t, int32(ThrottlingError), causes[0].(map[string]interface{})["code"])
+	assert.Equal(t, "table leases", causes[0].(map[string]interface{})["detail"])
+	assert.Equal(t, "connection reset", causes[1])
+}
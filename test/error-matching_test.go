@@ -0,0 +1,36 @@
+package util
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestErrorIsMatchesErrorCode(t *testing.T) {
+	err := ShutdownError.MakeErr().WithDetail("shard %s", "0001").WithCause(errors.New("stopped"))
+
+	assert.True(t, errors.Is(err, ShutdownError.MakeErr()))
+	assert.False(t, errors.Is(err, InvalidStateError.MakeErr()))
+
+	// also through wrapping errors
+	wrapped := fmt.Errorf("processing failed: %w", err)
+	assert.True(t, errors.Is(wrapped, ShutdownError.MakeErr()))
+}
+
+func TestErrorUnwrapsCause(t *testing.T) {
+	cause := awserr.New(dynamodb.ErrCodeProvisionedThroughputExceededException, "throughput exceeded", nil)
+	err := LeasingProvisionedThroughputError.MakeErr().WithCause(cause).WithCause(errors.New("retried"))
+
+	var awsErr awserr.Error
+	assert.True(t, errors.As(err, &awsErr))
+	assert.Equal(t, dynamodb.ErrCodeProvisionedThroughputExceededException, awsErr.Code())
+	assert.Equal(t, cause, errors.Unwrap(err))
+
+	// the cause is still part of the detail
+	assert.Contains(t, err.Detail, "ProvisionedThroughputExceededException")
+	assert.Nil(t, errors.Unwrap(ShutdownError.MakeErr()))
+}
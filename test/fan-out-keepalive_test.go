@@ -0,0 +1,58 @@
+package shard
+
+import (
+	"io/ioutil"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/kinesis"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/guygma/goKCL"
+)
+
+func TestDeadSubscriptionRenewed(t *testing.T) {
+	kc := &deadConnectionKinesis{subscribingKinesis: &subscribingKinesis{
+		mockKinesisClient: newMockKinesisClient(0, true),
+		subscriptions: [][]*kinesis.SubscribeToShardEvent{
+			{subscriptionEvent("2", "1", "2"), subscriptionEvent("", "3")},
+		},
+	}}
+	processor := &mockRecordProcessor{}
+	sc := newTestConsumer(kc, newMockShardCheckpointer(), processor, testConfig().
+		WithEnhancedFanOutTimeouts(1000, 1000, 50))
+	sc.consumerARN = "arn:consumer"
+
+	assert.Nil(t, sc.GetRecords(testShard()))
+	assert.Equal(t, []string{"1", "2", "3"}, processor.sequenceNumbers())
+
+	// the silent connection was given up and subscribed again from the same position
+	assert.Equal(t, 1, kc.dead)
+	assert.Equal(t, 1, len(kc.requests))
+	assert.Equal(t, "TRIM_HORIZON", aws.StringValue(kc.requests[0].StartingPosition.Type))
+}
+
+func TestEnhancedFanOutTimeoutsValidation(t *testing.T) {
+	kclConfig := goKCL.NewKinesisClientLibConfig("appName", "test", "us-west-2", "abc")
+	assert.Panics(t, func() { kclConfig.WithEnhancedFanOutTimeouts(0, 1000, 1000) })
+	assert.Panics(t, func() { kclConfig.WithEnhancedFanOutTimeouts(1000, -1, 1000) })
+	assert.Panics(t, func() { kclConfig.WithEnhancedFanOutTimeouts(1000, 1000, 0) })
+}
+
+// deadConnectionKinesis serves a first subscription whose connection silently died: it never delivers any event.
+type deadConnectionKinesis struct {
+	*subscribingKinesis
+	dead int
+}
+
+func (m *deadConnectionKinesis) SubscribeToShard(input *kinesis.SubscribeToShardInput) (*kinesis.SubscribeToShardOutput, error) {
+	if m.dead > 0 {
+		return m.subscribingKinesis.SubscribeToShard(input)
+	}
+	m.dead++
+	return &kinesis.SubscribeToShardOutput{EventStream: &kinesis.SubscribeToShardEventStream{
+		Reader:       &eventStreamReader{events: make(chan kinesis.SubscribeToShardEventStreamEvent)},
+		StreamCloser: ioutil.NopCloser(strings.NewReader("")),
+	}}, nil
+}
@@ -0,0 +1,184 @@
+package record
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/guygma/goKCL/shard"
+	"github.com/guygma/goKCL/util"
+)
+
+func TestFanOutIndependentCheckpoints(t *testing.T) {
+	store := newMemoryCheckpointStore()
+	fast := &collectingProcessor{checkpoint: true}
+	slow := &collectingProcessor{}
+	checkpointer := &mockRecordCheckpointer{}
+
+	processor := NewFanOutFactory(store, 1).
+		WithProcessor("fast", fast).
+		WithProcessor("slow", slow).
+		CreateProcessor()
+	processor.Initialize(&shard.InitializationInput{ShardId: "0001"})
+	processor.ProcessRecords(&ProcessRecordsInput{Records: sinkRecords("a", "b"), Checkpointer: checkpointer})
+	processor.ProcessRecords(&ProcessRecordsInput{Records: sinkRecords("a", "b", "c")[2:], Checkpointer: checkpointer})
+	processor.Shutdown(&util.ShutdownInput{ShutdownReason: util.REQUESTED, Checkpointer: checkpointer})
+
+	// both processors received every record, but only the fast one checkpointed
+	assert.Equal(t, []string{"1", "2", "3"}, fast.sequenceNumbers())
+	assert.Equal(t, []string{"1", "2", "3"}, slow.sequenceNumbers())
+	assert.Equal(t, "3", store.checkpoints["0001.fast"])
+	_, ok := store.checkpoints["0001.slow"]
+	assert.False(t, ok)
+
+	// the shard is checkpointed at the slowest processor
+	assert.Empty(t, checkpointer.checkpoints)
+}
+
+func TestFanOutShardCheckpointAtSlowest(t *testing.T) {
+	store := newMemoryCheckpointStore()
+	store.checkpoints["0001.first"] = "2"
+	first := &collectingProcessor{checkpoint: true}
+	second := &collectingProcessor{checkpoint: true}
+	checkpointer := &mockRecordCheckpointer{}
+
+	processor := NewFanOutFactory(store, 10).
+		WithProcessor("first", first).
+		WithProcessor("second", second).
+		CreateProcessor()
+	processor.Initialize(&shard.InitializationInput{ShardId: "0001"})
+	processor.ProcessRecords(&ProcessRecordsInput{Records: sinkRecords("a", "b", "c"), Checkpointer: checkpointer})
+	processor.Shutdown(&util.ShutdownInput{ShutdownReason: util.TERMINATE, Checkpointer: checkpointer})
+
+	// the first processor already processed the first two record before the restart
+	assert.Equal(t, []string{"3"}, first.sequenceNumbers())
+	assert.Equal(t, []string{"1", "2", "3"}, second.sequenceNumbers())
+	assert.Equal(t, shard.SHARD_END, store.checkpoints["0001.first"])
+	assert.Equal(t, shard.SHARD_END, store.checkpoints["0001.second"])
+	assert.Equal(t, "", checkpointer.checkpoints[len(checkpointer.checkpoints)-1])
+}
+
+func TestFanOutNoShardCheckpointOnceZombie(t *testing.T) {
+	store := newMemoryCheckpointStore()
+	branch := &shutdownCheckpointingProcessor{}
+	checkpointer := &mockRecordCheckpointer{}
+
+	processor := NewFanOutFactory(store, 10).WithProcessor("only", branch).CreateProcessor()
+	processor.Initialize(&shard.InitializationInput{ShardId: "0001"})
+	processor.ProcessRecords(&ProcessRecordsInput{Records: sinkRecords("a", "b"), Checkpointer: checkpointer})
+	processor.Shutdown(&util.ShutdownInput{ShutdownReason: util.ZOMBIE, Checkpointer: checkpointer})
+
+	// the processor checkpoints its namespace, the shard whose lease was lost isn't checkpointed
+	assert.Equal(t, "2", store.checkpoints["0001.only"])
+	assert.Empty(t, checkpointer.checkpoints)
+}
+
+func TestFanOutRemoveLeaseItems(t *testing.T) {
+	store := newMemoryCheckpointStore()
+	for _, key := range []string{"0001", "0001.first", "0001.second", "0002.first"} {
+		store.checkpoints[key] = "1"
+	}
+	factory := NewFanOutFactory(store, 1).
+		WithProcessor("first", &collectingProcessor{}).
+		WithProcessor("second", &collectingProcessor{})
+
+	// only the items of the processors of the shard are removed, the lease of the shard is left to the worker
+	assert.Nil(t, factory.RemoveLeaseItems("0001"))
+	assert.Equal(t, map[string]string{"0001": "1", "0002.first": "1"}, store.checkpoints)
+}
+
+// shutdownCheckpointingProcessor is its own factory. It only checkpoints the last record it received on shutdown,
+// whatever the reason.
+type shutdownCheckpointingProcessor struct {
+	collectingProcessor
+}
+
+func (p *shutdownCheckpointingProcessor) CreateProcessor() IRecordProcessor {
+	return p
+}
+
+func (p *shutdownCheckpointingProcessor) Shutdown(input *util.ShutdownInput) {
+	if records := p.sequenceNumbers(); len(records) > 0 {
+		input.Checkpointer.CheckpointSequence(records[len(records)-1])
+	}
+}
+
+// collectingProcessor is its own factory. It checkpoints after every batch and at the end of the shard if
+// checkpoint is set.
+type collectingProcessor struct {
+	mux        sync.Mutex
+	checkpoint bool
+	records    []string
+}
+
+func (p *collectingProcessor) CreateProcessor() IRecordProcessor {
+	return p
+}
+
+func (p *collectingProcessor) Initialize(input *shard.InitializationInput) {}
+
+func (p *collectingProcessor) ProcessRecords(input *ProcessRecordsInput) {
+	if len(input.Records) == 0 {
+		return
+	}
+	p.mux.Lock()
+	for _, r := range input.Records {
+		p.records = append(p.records, aws.StringValue(r.SequenceNumber))
+	}
+	p.mux.Unlock()
+	if p.checkpoint {
+		input.Checkpointer.Checkpoint(input.Records[len(input.Records)-1].SequenceNumber)
+	}
+}
+
+func (p *collectingProcessor) Shutdown(input *util.ShutdownInput) {
+	if p.checkpoint && input.ShutdownReason == util.TERMINATE {
+		input.Checkpointer.Checkpoint(nil)
+	}
+}
+
+func (p *collectingProcessor) sequenceNumbers() []string {
+	p.mux.Lock()
+	defer p.mux.Unlock()
+	return append([]string{}, p.records...)
+}
+
+// memoryCheckpointStore keeps checkpoints in memory.
+type memoryCheckpointStore struct {
+	shard.Checkpointer
+	mux         sync.Mutex
+	checkpoints map[string]string
+}
+
+func newMemoryCheckpointStore() *memoryCheckpointStore {
+	return &memoryCheckpointStore{checkpoints: make(map[string]string)}
+}
+
+func (m *memoryCheckpointStore) CheckpointSequence(status *shard.Status) error {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+	m.checkpoints[status.ID] = status.Checkpoint
+	return nil
+}
+
+func (m *memoryCheckpointStore) FetchCheckpoint(status *shard.Status) error {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+	checkpoint, ok := m.checkpoints[status.ID]
+	if !ok {
+		return shard.ErrSequenceIDNotFound
+	}
+	status.Mux.Lock()
+	status.Checkpoint = checkpoint
+	status.Mux.Unlock()
+	return nil
+}
+
+func (m *memoryCheckpointStore) RemoveLeaseInfo(shardID string) error {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+	delete(m.checkpoints, shardID)
+	return nil
+}
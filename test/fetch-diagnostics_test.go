@@ -0,0 +1,42 @@
+package shard
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFetchDiagnostics(t *testing.T) {
+	kc := newMockKinesisClient(3, false)
+	processor := &mockRecordProcessor{}
+	sc := newTestConsumer(kc, newMockShardCheckpointer(), processor, testConfig().WithMaxRecords(2))
+
+	sh := testShard()
+	assert.Equal(t, FetchDiagnostics{ShardID: "0001"}, sh.GetFetchDiagnostics())
+
+	done := make(chan error)
+	go func() { done <- sc.GetRecords(sh) }()
+	assert.True(t, waitForDelivered(processor, 3, time.Second))
+	close(*sc.stop)
+	assert.Nil(t, <-done)
+
+	diagnostics := sh.GetFetchDiagnostics()
+	assert.Equal(t, "0001", diagnostics.ShardID)
+	assert.Equal(t, "pos:3", diagnostics.ShardIterator)
+	// the record fetched last is kept across empty fetches
+	assert.Equal(t, "3", diagnostics.LastFetchedSequenceNumber)
+	assert.False(t, diagnostics.FetchedAt.IsZero())
+}
+
+func TestFetchDiagnosticsTruncateShardIterator(t *testing.T) {
+	iterator := "AAAAAAAAAAHSywljv0zEgPX4NyKdZ5wryMzP9yALs8NeKbUjp1IxtZs1Sp+KEd9I6AJ9ZG4lNR1EMi+9Md/nHvtLyxpfhEzYvkTZ4D9DQVz/mBYWRO6OTZRKnW9gd+efGN2aHFdkH1rJl4BL9Wyrk+ghYG22D2T1Da2EyNSH1+LAbK33gQweTJADBdyMwlo5r6PqcP2dzhg="
+	sh := testShard()
+	sh.recordFetch(&iterator, nil, 0, time.Now())
+
+	diagnostics := sh.GetFetchDiagnostics()
+	assert.Equal(t, iterator[:16]+"...", diagnostics.ShardIterator)
+	assert.False(t, strings.Contains(diagnostics.ShardIterator, iterator[16:32]))
+	assert.Equal(t, "", diagnostics.LastFetchedSequenceNumber)
+}
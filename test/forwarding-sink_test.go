@@ -0,0 +1,152 @@
+package record
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/kinesis"
+	"github.com/aws/aws-sdk-go/service/kinesis/kinesisiface"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/guygma/goKCL/shard"
+	"github.com/guygma/goKCL/util"
+)
+
+func TestForwardingSinkRetriesFailedRecords(t *testing.T) {
+	kc := &partiallyFailingKinesis{failures: map[string]int{"b": 1, "d": 2}}
+	checkpointer := &mockRecordCheckpointer{}
+	sink := NewForwardingSinkFactory(kc, "tee").WithRetries(3, time.Millisecond).CreateProcessor()
+	sink.Initialize(&shard.InitializationInput{ShardId: "0001"})
+
+	sink.ProcessRecords(&ProcessRecordsInput{
+		Records:      keyedRecords("a", "b", "c", "d", "e"),
+		Checkpointer: checkpointer,
+	})
+
+	// only the failed record are retried, in their original order
+	assert.Equal(t, [][]string{{"a", "b", "c", "d", "e"}, {"b", "d"}, {"d"}}, kc.requests)
+	assert.Equal(t, []string{"5"}, checkpointer.checkpoints)
+}
+
+func TestForwardingSinkPersistentFailure(t *testing.T) {
+	kc := &partiallyFailingKinesis{failures: map[string]int{"b": 10}}
+	handler := &forwardFailureHandler{}
+	checkpointer := &mockRecordCheckpointer{}
+	sink := NewForwardingSinkFactory(kc, "tee").
+		WithRetries(2, time.Millisecond).
+		WithFailureHandler(handler).
+		CreateProcessor()
+	sink.Initialize(&shard.InitializationInput{ShardId: "0001"})
+
+	sink.ProcessRecords(&ProcessRecordsInput{Records: keyedRecords("a", "b", "c"), Checkpointer: checkpointer})
+
+	assert.Equal(t, [][]string{{"a", "b", "c"}, {"b"}, {"b"}}, kc.requests)
+	assert.Equal(t, 1, len(handler.failed))
+	assert.Equal(t, "2", handler.failed[0].SequenceNumber)
+	assert.Equal(t, 3, handler.failed[0].Attempts)
+	assert.Equal(t, util.KinesisClientLibDependencyError, handler.failed[0].ClientLibraryError.ErrorCode)
+	assert.Equal(t, []string{"3"}, checkpointer.checkpoints)
+
+	// without failure handler the sink fails the batch and every later one without checkpointing
+	kc = &partiallyFailingKinesis{failures: map[string]int{"b": 10}}
+	checkpointer = &mockRecordCheckpointer{}
+	sink = NewForwardingSinkFactory(kc, "tee").WithRetries(1, time.Millisecond).CreateProcessor()
+	failed := &ProcessRecordsInput{Records: keyedRecords("a", "b"), Checkpointer: checkpointer}
+	sink.ProcessRecords(failed)
+	assert.True(t, errors.Is(failed.Err(), util.KinesisClientLibNonRetryableException.MakeErr()))
+	later := &ProcessRecordsInput{Records: keyedRecords("c"), Checkpointer: checkpointer}
+	sink.ProcessRecords(later)
+	assert.Equal(t, failed.Err(), later.Err())
+	assert.Empty(t, checkpointer.checkpoints)
+}
+
+func TestForwardingSinkKeepsPartitionKeyOrder(t *testing.T) {
+	kc := &partiallyFailingKinesis{failures: map[string]int{"a1": 2}}
+	checkpointer := &mockRecordCheckpointer{}
+	sink := NewForwardingSinkFactory(kc, "tee").WithRetries(3, time.Millisecond).CreateProcessor()
+	sink.Initialize(&shard.InitializationInput{ShardId: "0001"})
+
+	records := keyedRecords("a1", "b1", "a2", "b2", "a3")
+	for _, r := range records {
+		r.PartitionKey = aws.String(string(r.Data[:1]))
+	}
+	sink.ProcessRecords(&ProcessRecordsInput{Records: records, Checkpointer: checkpointer})
+
+	// the later record of a are held back until a1 is forwarded, b isn't
+	assert.Equal(t, [][]string{{"a1", "b1"}, {"a1", "b2"}, {"a1"}, {"a2"}, {"a3"}}, kc.requests)
+	assert.Equal(t, []string{"5"}, checkpointer.checkpoints)
+}
+
+func TestForwardingSinkPersistentFailureHoldsBackPartitionKey(t *testing.T) {
+	kc := &partiallyFailingKinesis{failures: map[string]int{"a1": 10}}
+	handler := &forwardFailureHandler{}
+	checkpointer := &mockRecordCheckpointer{}
+	sink := NewForwardingSinkFactory(kc, "tee").
+		WithRetries(1, time.Millisecond).
+		WithFailureHandler(handler).
+		CreateProcessor()
+	sink.Initialize(&shard.InitializationInput{ShardId: "0001"})
+
+	records := keyedRecords("a1", "b1", "a2")
+	for _, r := range records {
+		r.PartitionKey = aws.String(string(r.Data[:1]))
+	}
+	sink.ProcessRecords(&ProcessRecordsInput{Records: records, Checkpointer: checkpointer})
+
+	// a2 is never forwarded ahead of a1, both are handed to the failure handler
+	assert.Equal(t, [][]string{{"a1", "b1"}, {"a1"}}, kc.requests)
+	assert.Equal(t, 2, len(handler.failed))
+	assert.Equal(t, "1", handler.failed[0].SequenceNumber)
+	assert.Equal(t, 2, handler.failed[0].Attempts)
+	assert.Equal(t, "3", handler.failed[1].SequenceNumber)
+	assert.Equal(t, 0, handler.failed[1].Attempts)
+	assert.Equal(t, []string{"3"}, checkpointer.checkpoints)
+}
+
+// keyedRecords creates one record per data string, with the data as partition key and sequence numbers starting
+// from 1.
+func keyedRecords(data ...string) []*kinesis.Record {
+	records := sinkRecords(data...)
+	for _, r := range records {
+		r.PartitionKey = aws.String(string(r.Data))
+	}
+	return records
+}
+
+// partiallyFailingKinesis fails the record with the given data the given number of times, and records the data of
+// every PutRecords request.
+type partiallyFailingKinesis struct {
+	kinesisiface.KinesisAPI
+	failures map[string]int
+	requests [][]string
+}
+
+func (m *partiallyFailingKinesis) PutRecords(input *kinesis.PutRecordsInput) (*kinesis.PutRecordsOutput, error) {
+	var data []string
+	output := &kinesis.PutRecordsOutput{FailedRecordCount: aws.Int64(0)}
+	for _, entry := range input.Records {
+		data = append(data, string(entry.Data))
+		if m.failures[string(entry.Data)] > 0 {
+			m.failures[string(entry.Data)]--
+			output.FailedRecordCount = aws.Int64(aws.Int64Value(output.FailedRecordCount) + 1)
+			output.Records = append(output.Records, &kinesis.PutRecordsResultEntry{
+				ErrorCode:    aws.String(kinesis.ErrCodeProvisionedThroughputExceededException),
+				ErrorMessage: aws.String("Rate exceeded for shard"),
+			})
+		} else {
+			output.Records = append(output.Records, &kinesis.PutRecordsResultEntry{SequenceNumber: aws.String("1")})
+		}
+	}
+	m.requests = append(m.requests, data)
+	return output, nil
+}
+
+type forwardFailureHandler struct {
+	failed []*DeadLetterInput
+}
+
+func (h *forwardFailureHandler) DeadLetter(input *DeadLetterInput) {
+	h.failed = append(h.failed, input)
+}
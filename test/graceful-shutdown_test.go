@@ -0,0 +1,135 @@
+package goKCL
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/service/kinesis"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/guygma/goKCL/record"
+	"github.com/guygma/goKCL/util"
+)
+
+func TestGracefulShutdown(t *testing.T) {
+	factory := &shutdownRecordingFactory{}
+	store := newMemoryLeaseStore(10 * time.Second)
+	w := newShutdownTestWorker(factory, store)
+	assert.Nil(t, w.Start())
+	assert.True(t, store.waitForOwner("worker", time.Second, "shardId-0", "shardId-1"))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	assert.Nil(t, w.ShutdownWithContext(ctx))
+
+	// every record processor has been shut down as requested and the leases are released
+	assert.Equal(t, []util.ShutdownReason{util.REQUESTED, util.REQUESTED}, factory.shutdownReasons())
+	assert.True(t, store.waitForOwner("", time.Second, "shardId-0", "shardId-1"))
+
+	// shutting down again is a no-op
+	assert.Nil(t, w.ShutdownWithContext(ctx))
+}
+
+func TestGracefulShutdownTimeout(t *testing.T) {
+	factory := &shutdownRecordingFactory{block: make(chan struct{})}
+	store := newMemoryLeaseStore(10 * time.Second)
+	w := newShutdownTestWorker(factory, store)
+	w.kclConfig.WithCooperativeShutdown(10)
+	assert.Nil(t, w.Start())
+	assert.True(t, store.waitForOwner("worker", time.Second, "shardId-0", "shardId-1"))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	err := w.ShutdownWithContext(ctx)
+	assert.NotNil(t, err)
+
+	var cle *util.ClientLibraryError
+	assert.True(t, errors.As(err, &cle))
+	assert.Equal(t, util.ShutdownError, cle.ErrorCode)
+	assert.Contains(t, cle.Detail, "shardId-0, shardId-1")
+	assert.True(t, errors.Is(err, context.DeadlineExceeded))
+
+	// shutting down again doesn't wait any longer for the shards still draining
+	ctx, cancel = context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	assert.True(t, errors.Is(w.ShutdownWithContext(ctx), util.ShutdownError.MakeErr()))
+
+	// the shards keep draining in the background, and the worker is cleaned up after them
+	close(factory.block)
+	assert.True(t, store.waitForOwner("", time.Second, "shardId-0", "shardId-1"))
+	assert.Nil(t, w.ShutdownWithContext(context.Background()))
+	signal, err := store.FetchLeaseReleaseSignal()
+	assert.Nil(t, err)
+	if assert.NotNil(t, signal) {
+		sort.Strings(signal.ShardIDs)
+		assert.Equal(t, []string{"shardId-0", "shardId-1"}, signal.ShardIDs)
+	}
+}
+
+func TestConcurrentShutdowns(t *testing.T) {
+	store := newMemoryLeaseStore(10 * time.Second)
+	w := newShutdownTestWorker(&shutdownRecordingFactory{}, store)
+	assert.Nil(t, w.Start())
+	assert.True(t, store.waitForOwner("worker", time.Second, "shardId-0", "shardId-1"))
+
+	// the stop channel is closed once, and every call returns once the worker is shut down
+	wg := sync.WaitGroup{}
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			assert.Nil(t, w.ShutdownWithContext(context.Background()))
+		}()
+	}
+	wg.Wait()
+	assert.True(t, store.waitForOwner("", 10*time.Millisecond, "shardId-0", "shardId-1"))
+}
+
+func newShutdownTestWorker(factory record.IRecordProcessorFactory, store *memoryLeaseStore) *Worker {
+	kc := &mockKinesis{shards: []*kinesis.Shard{
+		mockShard("shardId-0", "0", "170141183460469231731687303715884105727"),
+		mockShard("shardId-1", "170141183460469231731687303715884105728", "340282366920938463463374607431768211455"),
+	}}
+	kclConfig := NewKinesisClientLibConfig("appName", "test", "us-west-2", "worker").
+		WithFailoverTimeMillis(10000).
+		WithShardSyncIntervalMillis(60000).
+		WithMaxLeasesPerAcquisition(2).
+		WithIdleTimeBetweenReadsInMillis(10)
+	return NewWorker(factory, kclConfig, nil).WithKinesis(kc).WithCheckpointer(store)
+}
+
+// shutdownRecordingFactory creates record processors recording their shutdown reason. Their shutdown blocks until
+// block is closed, if set.
+type shutdownRecordingFactory struct {
+	block   chan struct{}
+	mux     sync.Mutex
+	reasons []util.ShutdownReason
+}
+
+func (f *shutdownRecordingFactory) CreateProcessor() record.IRecordProcessor {
+	return &shutdownRecordingProcessor{factory: f}
+}
+
+func (f *shutdownRecordingFactory) shutdownReasons() []util.ShutdownReason {
+	f.mux.Lock()
+	defer f.mux.Unlock()
+	return append([]util.ShutdownReason(nil), f.reasons...)
+}
+
+type shutdownRecordingProcessor struct {
+	noopRecordProcessor
+	factory *shutdownRecordingFactory
+}
+
+func (p *shutdownRecordingProcessor) Shutdown(input *util.ShutdownInput) {
+	if p.factory.block != nil {
+		<-p.factory.block
+	}
+	p.factory.mux.Lock()
+	defer p.factory.mux.Unlock()
+	p.factory.reasons = append(p.factory.reasons, input.ShutdownReason)
+}
@@ -0,0 +1,41 @@
+package grpcstatus
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/guygma/goKCL/util"
+)
+
+func TestGRPCStatusRoundTrip(t *testing.T) {
+	for _, code := range []util.ErrorCode{util.ThrottlingError, util.KinesisClientLibIOError, util.InvalidStateError,
+		util.IllegalArgumentError, util.KinesisClientLibNotImplemented} {
+		original := code.MakeErr().WithDetail("shard %s", "shardId-000000000000")
+
+		st := ToStatus(original)
+		assert.Equal(t, original.Error(), st.Message())
+
+		restored := FromStatus(st)
+		assert.Equal(t, original, restored)
+	}
+}
+
+func TestGRPCStatusCode(t *testing.T) {
+	assert.Equal(t, codes.ResourceExhausted, ToStatus(util.ThrottlingError.MakeErr()).Code())
+	assert.Equal(t, codes.Unavailable, ToStatus(util.KinesisClientLibDependencyError.MakeErr()).Code())
+	assert.Equal(t, codes.InvalidArgument, ToStatus(util.IllegalArgumentError.MakeErr()).Code())
+	assert.Equal(t, codes.Unimplemented, ToStatus(util.KinesisClientLibNotImplemented.MakeErr()).Code())
+	assert.Equal(t, codes.FailedPrecondition, ToStatus(util.ShutdownError.MakeErr()).Code())
+}
+
+func TestGRPCStatusFromForeignStatus(t *testing.T) {
+	// a status made by another service carries no ErrorCode
+	e := FromStatus(status.New(codes.Unavailable, "connection refused"))
+
+	assert.Equal(t, util.KinesisClientLibRetryableError, e.ErrorCode)
+	assert.True(t, e.Retryable)
+	assert.Equal(t, "connection refused", e.Detail)
+}
@@ -0,0 +1,80 @@
+package goKCL
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/kinesis"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/guygma/goKCL/shard"
+	"github.com/guygma/goKCL/util"
+)
+
+func TestIdleStreamRecovers(t *testing.T) {
+	listener := &recordingEventListener{}
+	kclConfig := NewKinesisClientLibConfig("appName", "test", "us-west-2", "abc").
+		WithShardSyncIntervalMillis(60000).
+		WithIdleShardSyncIntervalMillis(5000).
+		WithEventListener(listener)
+
+	// the only shard of the stream is closed
+	closed := mockShard("shardId-0", "0", "100")
+	closed.SequenceNumberRange.EndingSequenceNumber = aws.String("99")
+	kc := &mockKinesis{shards: []*kinesis.Shard{closed}}
+	w := NewWorker(nil, kclConfig, nil).WithKinesis(kc)
+	w.shardStatus = make(map[string]*shard.Status)
+
+	assert.Nil(t, w.syncShard())
+	w.checkIdle()
+	assert.Equal(t, 5*time.Second, w.shardSyncInterval())
+
+	// idling is only reported once
+	assert.Nil(t, w.syncShard())
+	w.checkIdle()
+	events := listener.received()
+	assert.Equal(t, 1, len(events))
+	assert.Equal(t, util.INFO, events[0].Severity)
+	assert.Equal(t, util.EVENT_STREAM_IDLE, events[0].Type)
+
+	child := mockShard("shardId-1", "0", "100")
+	child.ParentShardId = aws.String("shardId-0")
+	kc.shards = append(kc.shards, child)
+
+	assert.Nil(t, w.syncShard())
+	w.checkIdle()
+	assert.Equal(t, time.Minute, w.shardSyncInterval())
+	events = listener.received()
+	assert.Equal(t, 2, len(events))
+	assert.Equal(t, util.EVENT_STREAM_RESUMED, events[1].Type)
+}
+
+func TestStreamWithoutShardsIdles(t *testing.T) {
+	w := NewWorker(nil, NewKinesisClientLibConfig("appName", "test", "us-west-2", "abc"), nil).
+		WithKinesis(&mockKinesis{})
+	w.shardStatus = make(map[string]*shard.Status)
+
+	assert.Nil(t, w.syncShard())
+	w.checkIdle()
+	assert.True(t, w.idle)
+	assert.Equal(t, time.Duration(DEFAULT_IDLE_SHARD_SYNC_INTERVAL_MILLIS)*time.Millisecond, w.shardSyncInterval())
+}
+
+type recordingEventListener struct {
+	mux    sync.Mutex
+	events []*util.Event
+}
+
+func (l *recordingEventListener) OnEvent(event *util.Event) {
+	l.mux.Lock()
+	defer l.mux.Unlock()
+	l.events = append(l.events, event)
+}
+
+func (l *recordingEventListener) received() []*util.Event {
+	l.mux.Lock()
+	defer l.mux.Unlock()
+	return append([]*util.Event{}, l.events...)
+}
@@ -0,0 +1,66 @@
+package shard
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFetchingPausesOnInFlightBatches(t *testing.T) {
+	kc := newMockKinesisClient(5, false)
+	// the processor never checkpoints by itself, as if it processed asynchronously
+	processor := &mockRecordProcessor{skipCheckpoint: true}
+	sc := newTestConsumer(kc, newMockShardCheckpointer(), processor, testConfig().
+		WithMaxRecords(1).
+		WithMaxInFlightBatches(2))
+
+	sh := testShard()
+	done := make(chan error)
+	go func() { done <- sc.GetRecords(sh) }()
+
+	assert.True(t, waitForDelivered(processor, 2, time.Second))
+	time.Sleep(50 * time.Millisecond)
+	assert.Equal(t, []string{"1", "2"}, processor.sequenceNumbers())
+
+	// checkpointing the first batch lets one more in
+	sh.Mux.Lock()
+	sh.Checkpoint = "1"
+	sh.Mux.Unlock()
+	assert.True(t, waitForDelivered(processor, 3, time.Second))
+	time.Sleep(50 * time.Millisecond)
+	assert.Equal(t, []string{"1", "2", "3"}, processor.sequenceNumbers())
+
+	close(*sc.stop)
+	assert.Nil(t, <-done)
+}
+
+func TestInFlightBatches(t *testing.T) {
+	b := &inFlightBatches{max: 2}
+	b.delivered(autoCheckpointRecords(1, 2))
+	assert.False(t, b.full(""))
+	b.delivered(autoCheckpointRecords(3))
+	assert.True(t, b.full(""))
+	// a checkpoint within a batch doesn't settle it
+	assert.True(t, b.full("1"))
+	assert.False(t, b.full("2"))
+	// empty batches aren't in flight
+	b.delivered(autoCheckpointRecords())
+	assert.False(t, b.full("2"))
+
+	unbounded := &inFlightBatches{}
+	unbounded.delivered(autoCheckpointRecords(1))
+	assert.False(t, unbounded.full(""))
+}
+
+// waitForDelivered returns true once the processor received n record, false if that didn't happen within timeout.
+func waitForDelivered(processor *mockRecordProcessor, n int, timeout time.Duration) bool {
+	deadline := time.Now().Add(timeout)
+	for time.Now().Before(deadline) {
+		if len(processor.sequenceNumbers()) >= n {
+			return true
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	return false
+}
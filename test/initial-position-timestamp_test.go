@@ -0,0 +1,46 @@
+package shard
+
+import (
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestInitialPositionAtTimestamp(t *testing.T) {
+	now := time.Now()
+	kc := &mockKinesisClient{closed: true}
+	for _, age := range []time.Duration{3 * time.Minute, 2 * time.Minute, time.Minute} {
+		kc.addRecord("data", now.Add(-age))
+	}
+	incident := now.Add(-150 * time.Second)
+	processor := &mockRecordProcessor{}
+	sc := newTestConsumer(kc, newMockShardCheckpointer(), processor, testConfig().
+		WithTimestampAtInitialPositionInStream(&incident))
+
+	// a shard without checkpoint starts at the timestamp
+	assert.Nil(t, sc.GetRecords(testShard()))
+	assert.Equal(t, []string{"2", "3"}, processor.sequenceNumbers())
+	assert.Equal(t, "AT_TIMESTAMP", aws.StringValue(kc.iteratorRequests[0].ShardIteratorType))
+	assert.Equal(t, incident, aws.TimeValue(kc.iteratorRequests[0].Timestamp))
+}
+
+func TestInitialPositionIgnoredWithCheckpoint(t *testing.T) {
+	now := time.Now()
+	kc := &mockKinesisClient{closed: true}
+	for _, age := range []time.Duration{3 * time.Minute, 2 * time.Minute, time.Minute} {
+		kc.addRecord("data", now.Add(-age))
+	}
+	checkpointer := newMockShardCheckpointer()
+	checkpointer.checkpoints["0001"] = "2"
+	incident := now.Add(-time.Hour)
+	processor := &mockRecordProcessor{}
+	sc := newTestConsumer(kc, checkpointer, processor, testConfig().
+		WithTimestampAtInitialPositionInStream(&incident))
+
+	// a shard with a checkpoint always resumes from it
+	assert.Nil(t, sc.GetRecords(testShard()))
+	assert.Equal(t, []string{"3"}, processor.sequenceNumbers())
+	assert.Equal(t, "AFTER_SEQUENCE_NUMBER", aws.StringValue(kc.iteratorRequests[0].ShardIteratorType))
+}
@@ -0,0 +1,36 @@
+package goKCL
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/guygma/goKCL/util"
+)
+
+func TestInitialPositionValidation(t *testing.T) {
+	for name, kclConfig := range map[string]*KinesisClientLibConfiguration{
+		"without timestamp": NewKinesisClientLibConfig("appName", "test", "us-west-2", "abc").
+			WithInitialPositionInStream(AT_TIMESTAMP),
+		"nil timestamp": NewKinesisClientLibConfig("appName", "test", "us-west-2", "abc").
+			WithTimestampAtInitialPositionInStream(nil),
+		"unknown position": NewKinesisClientLibConfig("appName", "test", "us-west-2", "abc").
+			WithInitialPositionInStream(InitialPositionInStream(42)),
+	} {
+		w := NewWorker(nil, kclConfig, nil).
+			WithKinesis(&mockKinesis{retentionHours: 24}).
+			WithCheckpointer(newMemoryLeaseStore(time.Minute))
+		assert.True(t, errors.Is(w.initialize(), util.IllegalArgumentError.MakeErr()), name)
+	}
+
+	for _, position := range []InitialPositionInStream{LATEST, TRIM_HORIZON} {
+		kclConfig := NewKinesisClientLibConfig("appName", "test", "us-west-2", "abc").
+			WithInitialPositionInStream(position)
+		w := NewWorker(nil, kclConfig, nil).
+			WithKinesis(&mockKinesis{retentionHours: 24}).
+			WithCheckpointer(newMemoryLeaseStore(time.Minute))
+		assert.Nil(t, w.initialize())
+	}
+}
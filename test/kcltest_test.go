@@ -0,0 +1,126 @@
+package kcltest
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/kinesis"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/guygma/goKCL"
+	"github.com/guygma/goKCL/record"
+	"github.com/guygma/goKCL/shard"
+	"github.com/guygma/goKCL/util"
+)
+
+func TestWorkerOnFakeStream(t *testing.T) {
+	stream := NewStream("orders", 1)
+	store := NewLeaseStore()
+	for i := 0; i < 10; i++ {
+		_, _, err := stream.Put(fmt.Sprintf("order-%d", i), []byte("created"))
+		assert.Nil(t, err)
+	}
+
+	factory := &collectingFactory{}
+	kclConfig := goKCL.NewKinesisClientLibConfig("appName", "orders", "us-west-2", "worker").
+		WithInitialPositionInStream(goKCL.TRIM_HORIZON).
+		WithShardSyncIntervalMillis(20).
+		WithIdleTimeBetweenReadsInMillis(10)
+	worker := goKCL.NewWorker(factory, kclConfig, nil).WithKinesis(stream).WithLeaseStore(store)
+	assert.Nil(t, worker.Start())
+	defer worker.Shutdown()
+
+	parent := stream.ShardIDs()[0]
+	AssertCheckpoint(t, store, parent, lastSequenceNumber(t, stream, parent), time.Second)
+
+	// the shard is split, the parent is finished before its children are processed
+	children, err := stream.SplitShard(parent, "170141183460469231731687303715884105728")
+	assert.Nil(t, err)
+	var last string
+	for i := 10; i < 20; i++ {
+		_, last, err = stream.Put(fmt.Sprintf("order-%d", i), []byte("created"))
+		assert.Nil(t, err)
+	}
+	AssertCheckpoint(t, store, parent, shard.SHARD_END, time.Second)
+	for _, child := range children {
+		AssertCheckpoint(t, store, child, lastSequenceNumber(t, stream, child), time.Second)
+	}
+	assert.Equal(t, "worker", store.Owner(children[0]))
+	assert.Equal(t, 20, factory.count())
+	assert.Contains(t, []string{store.Checkpoint(children[0]), store.Checkpoint(children[1])}, last)
+}
+
+func TestStreamMergeShards(t *testing.T) {
+	stream := NewStream("orders", 2)
+	shardIDs := stream.ShardIDs()
+	child, err := stream.MergeShards(shardIDs[1], shardIDs[0])
+	assert.Nil(t, err)
+
+	// every record goes to the child now
+	for i := 0; i < 10; i++ {
+		shardID, _, err := stream.Put(fmt.Sprintf("order-%d", i), []byte("created"))
+		assert.Nil(t, err)
+		assert.Equal(t, child, shardID)
+	}
+
+	// only adjacent open shards are merged
+	_, err = stream.MergeShards(shardIDs[0], child)
+	assert.NotNil(t, err)
+}
+
+// lastSequenceNumber returns the sequence number of the last record of the shard.
+func lastSequenceNumber(t *testing.T, stream *Stream, shardID string) string {
+	iterator, err := stream.GetShardIterator(&kinesis.GetShardIteratorInput{
+		StreamName:        aws.String("orders"),
+		ShardId:           aws.String(shardID),
+		ShardIteratorType: aws.String(kinesis.ShardIteratorTypeTrimHorizon),
+	})
+	assert.Nil(t, err)
+	records, err := stream.GetRecords(&kinesis.GetRecordsInput{ShardIterator: iterator.ShardIterator})
+	assert.Nil(t, err)
+	if len(records.Records) == 0 {
+		return ""
+	}
+	return aws.StringValue(records.Records[len(records.Records)-1].SequenceNumber)
+}
+
+// collectingFactory creates record processors counting the record and checkpointing every batch.
+type collectingFactory struct {
+	mux     sync.Mutex
+	records int
+}
+
+func (f *collectingFactory) CreateProcessor() record.IRecordProcessor {
+	return &collectingProcessor{factory: f}
+}
+
+func (f *collectingFactory) count() int {
+	f.mux.Lock()
+	defer f.mux.Unlock()
+	return f.records
+}
+
+type collectingProcessor struct {
+	factory *collectingFactory
+}
+
+func (p *collectingProcessor) Initialize(input *shard.InitializationInput) {}
+
+func (p *collectingProcessor) ProcessRecords(input *record.ProcessRecordsInput) {
+	if len(input.Records) == 0 {
+		return
+	}
+	p.factory.mux.Lock()
+	p.factory.records += len(input.Records)
+	p.factory.mux.Unlock()
+	input.Checkpointer.Checkpoint(input.Records[len(input.Records)-1].SequenceNumber)
+}
+
+func (p *collectingProcessor) Shutdown(input *util.ShutdownInput) {
+	if input.ShutdownReason == util.TERMINATE {
+		input.Checkpointer.Checkpoint(nil)
+	}
+}
@@ -0,0 +1,87 @@
+package goKCL
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/service/kinesis"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWorkerWithCustomKinesisAPI(t *testing.T) {
+	kc := &recordingKinesis{kc: &mockKinesis{shards: []*kinesis.Shard{
+		mockShard("shardId-0", "0", "340282366920938463463374607431768211455"),
+	}}}
+	kclConfig := NewKinesisClientLibConfig("appName", "test", "us-west-2", "worker").
+		WithShardSyncIntervalMillis(60000).
+		WithIdleTimeBetweenReadsInMillis(10)
+	store := newMemoryLeaseStore(10 * time.Second)
+	w := NewWorker(&mockProcessorFactory{}, kclConfig, nil).WithKinesis(kc).WithCheckpointer(store)
+
+	assert.Nil(t, w.Start())
+	assert.True(t, store.waitForOwner("worker", time.Second, "shardId-0"))
+	assert.True(t, kc.waitForCall("GetRecords", time.Second))
+	w.Shutdown()
+
+	for _, method := range []string{"DescribeStream", "DescribeStreamSummary", "GetShardIterator", "GetRecords"} {
+		assert.True(t, kc.called(method), method)
+	}
+	assert.False(t, kc.called("PutRecord"))
+}
+
+// recordingKinesis implements only KinesisAPI, recording the methods called before delegating to kc.
+type recordingKinesis struct {
+	kc    KinesisAPI
+	mux   sync.Mutex
+	calls map[string]int
+}
+
+func (m *recordingKinesis) record(method string) {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+	if m.calls == nil {
+		m.calls = make(map[string]int)
+	}
+	m.calls[method]++
+}
+
+func (m *recordingKinesis) called(method string) bool {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+	return m.calls[method] > 0
+}
+
+func (m *recordingKinesis) waitForCall(method string, timeout time.Duration) bool {
+	for deadline := time.Now().Add(timeout); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		if m.called(method) {
+			return true
+		}
+	}
+	return false
+}
+
+func (m *recordingKinesis) DescribeStream(input *kinesis.DescribeStreamInput) (*kinesis.DescribeStreamOutput, error) {
+	m.record("DescribeStream")
+	return m.kc.DescribeStream(input)
+}
+
+func (m *recordingKinesis) DescribeStreamSummary(input *kinesis.DescribeStreamSummaryInput) (*kinesis.DescribeStreamSummaryOutput, error) {
+	m.record("DescribeStreamSummary")
+	return m.kc.DescribeStreamSummary(input)
+}
+
+func (m *recordingKinesis) GetShardIterator(input *kinesis.GetShardIteratorInput) (*kinesis.GetShardIteratorOutput, error) {
+	m.record("GetShardIterator")
+	return m.kc.GetShardIterator(input)
+}
+
+func (m *recordingKinesis) GetRecords(input *kinesis.GetRecordsInput) (*kinesis.GetRecordsOutput, error) {
+	m.record("GetRecords")
+	return m.kc.GetRecords(input)
+}
+
+func (m *recordingKinesis) PutRecord(input *kinesis.PutRecordInput) (*kinesis.PutRecordOutput, error) {
+	m.record("PutRecord")
+	return m.kc.PutRecord(input)
+}
@@ -0,0 +1,25 @@
+package goKCL
+
+import (
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/service/kinesis"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestKinesisClientConfig(t *testing.T) {
+	kclConfig := NewKinesisClientLibConfig("appName", "test", "us-west-2", "abc").
+		WithKinesisClientConfig(2000, 10).
+		WithDynamoDBClientConfig(500, 5)
+	w := NewWorker(nil, kclConfig, nil).WithCheckpointer(newMemoryLeaseStore(time.Minute))
+
+	assert.Nil(t, w.initialize())
+	assert.Equal(t, 2*time.Second, w.kc.(*kinesis.Kinesis).Client.Config.HTTPClient.Timeout)
+}
+
+func TestClientConfigValidation(t *testing.T) {
+	kclConfig := NewKinesisClientLibConfig("appName", "test", "us-west-2", "abc")
+	assert.Panics(t, func() { kclConfig.WithKinesisClientConfig(0, 10) })
+	assert.Panics(t, func() { kclConfig.WithDynamoDBClientConfig(500, -1) })
+}
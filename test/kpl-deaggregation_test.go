@@ -0,0 +1,104 @@
+package shard
+
+import (
+	"crypto/md5"
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/golang/protobuf/proto"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/guygma/goKCL"
+	"github.com/guygma/goKCL/record"
+	"github.com/guygma/goKCL/util"
+)
+
+func TestAggregatedRecordsDeaggregated(t *testing.T) {
+	processor := &userRecordProcessor{}
+	sc := newTestConsumer(aggregatedRecordsClient(), newMockShardCheckpointer(), processor, testConfig().
+		WithDuplicateRecordPolicy(goKCL.SUPPRESS_DUPLICATES))
+
+	assert.Nil(t, sc.GetRecords(testShard()))
+	// the user record of an aggregated record aren't duplicates of each other
+	assert.Equal(t, []string{"1.1/a", "1.2/b", "1.3/c", "2.0/key"}, processor.userRecords())
+	assert.Equal(t, 0, sc.mService.(*mockMonitoringService).duplicateRecords)
+}
+
+func TestAggregationDisabled(t *testing.T) {
+	processor := &userRecordProcessor{}
+	sc := newTestConsumer(aggregatedRecordsClient(), newMockShardCheckpointer(), processor, testConfig().
+		WithAggregation(false))
+
+	assert.Nil(t, sc.GetRecords(testShard()))
+	assert.Equal(t, []string{"1.0/agg", "2.0/key"}, processor.userRecords())
+}
+
+func TestAggregatedRecordsResumedWithinRecord(t *testing.T) {
+	sc := &Consumer{kclConfig: testConfig(), mService: newMockMonitoringService()}
+	st := testShard()
+	st.Checkpoint = "1"
+	st.CheckpointSubSequenceNumber = 2
+
+	// the user record up to the checkpoint were processed before
+	records, err := sc.prepareRecords(st, aggregatedRecordsClient().records)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(records))
+	assert.Equal(t, "c", aws.StringValue(records[0].PartitionKey))
+	assert.Equal(t, int64(3), sc.subSequenceNumbers[records[0]])
+	assert.Equal(t, "2", aws.StringValue(records[1].SequenceNumber))
+	_, aggregated := sc.subSequenceNumbers[records[1]]
+	assert.False(t, aggregated)
+}
+
+// aggregatedRecordsClient serves a record aggregating the user record a, b and c, and a regular record.
+func aggregatedRecordsClient() *mockKinesisClient {
+	kc := newMockKinesisClient(2, true)
+	kc.records[0].Data = aggregateUserRecords("a", "b", "c")
+	kc.records[0].PartitionKey = aws.String("agg")
+	return kc
+}
+
+// aggregateUserRecords encodes a record in the KPL aggregation format, with one user record per partition key.
+func aggregateUserRecords(partitionKeys ...string) []byte {
+	aggregated := &record.AggregatedRecord{PartitionKeyTable: partitionKeys}
+	for i, key := range partitionKeys {
+		index := uint64(i)
+		aggregated.Records = append(aggregated.Records, &record.Record{
+			PartitionKeyIndex: &index,
+			Data:              []byte("data-" + key),
+		})
+	}
+
+	data, _ := proto.Marshal(aggregated)
+	digest := md5.Sum(data)
+	encoded := append([]byte("\xf3\x89\x9a\xc2"), data...)
+	return append(encoded, digest[:]...)
+}
+
+// userRecordProcessor records the extended sequence number and partition key of every record delivered.
+type userRecordProcessor struct {
+	mux     sync.Mutex
+	records []string
+}
+
+func (p *userRecordProcessor) Initialize(input *InitializationInput) {}
+
+func (p *userRecordProcessor) ProcessRecords(input *record.ProcessRecordsInput) {
+	p.mux.Lock()
+	defer p.mux.Unlock()
+	for _, r := range input.Records {
+		seq := input.ExtendedSequenceNumber(r)
+		p.records = append(p.records, fmt.Sprintf("%s.%d/%s",
+			aws.StringValue(seq.SequenceNumber), seq.SubSequenceNumber, aws.StringValue(r.PartitionKey)))
+	}
+}
+
+func (p *userRecordProcessor) Shutdown(input *util.ShutdownInput) {}
+
+func (p *userRecordProcessor) userRecords() []string {
+	p.mux.Lock()
+	defer p.mux.Unlock()
+	return append([]string(nil), p.records...)
+}
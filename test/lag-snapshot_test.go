@@ -0,0 +1,114 @@
+package shard
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLagSnapshotCadence(t *testing.T) {
+	kc := newMockKinesisClient(5, true)
+	recorder := &mockLagRecorder{}
+	sc := newTestConsumer(kc, newMockShardCheckpointer(), &mockRecordProcessor{}, testConfig().
+		WithMaxRecords(1).
+		WithLagSnapshotIntervalMillis(3600000))
+	sc.lagRecorder = recorder
+
+	assert.Nil(t, sc.GetRecords(testShard()))
+	// only the first fetch is due within the interval
+	assert.Equal(t, 1, recorder.snapshots())
+
+	kc = newMockKinesisClient(5, true)
+	recorder = &mockLagRecorder{}
+	sc = newTestConsumer(kc, newMockShardCheckpointer(), &mockRecordProcessor{delay: 2 * time.Millisecond}, testConfig().
+		WithMaxRecords(1).
+		WithLagSnapshotIntervalMillis(1))
+	sc.lagRecorder = recorder
+
+	assert.Nil(t, sc.GetRecords(testShard()))
+	// every fetch takes longer than the interval
+	assert.Equal(t, kc.getRecordsCalls, recorder.snapshots())
+}
+
+func TestLagSnapshotReadableFromLeases(t *testing.T) {
+	svc := &lagLeaseTable{items: make(map[string]map[string]*dynamodb.AttributeValue)}
+	checkpointer := NewDynamoCheckpoint(testConfig()).WithDynamoDB(svc)
+
+	sh := &Status{ID: "0001", Mux: &sync.Mutex{}}
+	assert.Nil(t, checkpointer.GetLease(sh, "worker"))
+	assert.Nil(t, checkpointer.RecordLag("0001", 1500))
+	// no lease, no snapshot
+	assert.Nil(t, checkpointer.RecordLag("0002", 10))
+
+	leases, err := checkpointer.GetLeases()
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(leases))
+	assert.Equal(t, "0001", leases[0].ShardID)
+	assert.Equal(t, "worker", leases[0].Owner)
+	assert.Equal(t, int64(1500), aws.Int64Value(leases[0].MillisBehindLatest))
+
+	// the snapshot survives the lease item being rewritten by a checkpoint
+	sh.Checkpoint = "42"
+	assert.Nil(t, checkpointer.CheckpointSequence(sh))
+	leases, err = checkpointer.GetLeases()
+	assert.Nil(t, err)
+	assert.Equal(t, "42", leases[0].Checkpoint)
+	assert.Equal(t, int64(1500), aws.Int64Value(leases[0].MillisBehindLatest))
+}
+
+type mockLagRecorder struct {
+	mux  sync.Mutex
+	lags []int64
+}
+
+func (m *mockLagRecorder) RecordLag(shardID string, millisBehindLatest int64) error {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+	m.lags = append(m.lags, millisBehindLatest)
+	return nil
+}
+
+func (m *mockLagRecorder) snapshots() int {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+	return len(m.lags)
+}
+
+// lagLeaseTable is a lease table supporting the lag snapshot updates and scans.
+type lagLeaseTable struct {
+	dynamodbiface.DynamoDBAPI
+	items map[string]map[string]*dynamodb.AttributeValue
+}
+
+func (m *lagLeaseTable) PutItem(input *dynamodb.PutItemInput) (*dynamodb.PutItemOutput, error) {
+	m.items[aws.StringValue(input.Item[LEASE_KEY_KEY].S)] = input.Item
+	return &dynamodb.PutItemOutput{}, nil
+}
+
+func (m *lagLeaseTable) GetItem(input *dynamodb.GetItemInput) (*dynamodb.GetItemOutput, error) {
+	return &dynamodb.GetItemOutput{Item: m.items[aws.StringValue(input.Key[LEASE_KEY_KEY].S)]}, nil
+}
+
+func (m *lagLeaseTable) UpdateItem(input *dynamodb.UpdateItemInput) (*dynamodb.UpdateItemOutput, error) {
+	item, ok := m.items[aws.StringValue(input.Key[LEASE_KEY_KEY].S)]
+	if !ok {
+		return nil, awserr.New(dynamodb.ErrCodeConditionalCheckFailedException, "no lease", nil)
+	}
+	item[aws.StringValue(input.ExpressionAttributeNames["#lag"])] = input.ExpressionAttributeValues[":lag"]
+	return &dynamodb.UpdateItemOutput{}, nil
+}
+
+func (m *lagLeaseTable) ScanPages(input *dynamodb.ScanInput, fn func(*dynamodb.ScanOutput, bool) bool) error {
+	output := &dynamodb.ScanOutput{}
+	for _, item := range m.items {
+		output.Items = append(output.Items, item)
+	}
+	fn(output, true)
+	return nil
+}
@@ -0,0 +1,101 @@
+package goKCL
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/service/kinesis"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/guygma/goKCL/shard"
+)
+
+func TestLeaseAcquisitionConcurrency(t *testing.T) {
+	checkpointer := &mockCheckpointer{getLeaseDelay: 10 * time.Millisecond}
+	kclConfig := NewKinesisClientLibConfig("appName", "test", "us-west-2", "abc").
+		WithMaxLeaseAcquisitionConcurrency(3).
+		WithMaxLeasesPerAcquisition(20)
+	w := NewWorker(nil, kclConfig, nil).WithCheckpointer(checkpointer)
+	w.shardStatus = make(map[string]*shard.Status)
+	for i := 0; i < 20; i++ {
+		id := fmt.Sprintf("shardId-%d", i)
+		w.shardStatus[id] = &shard.Status{ID: id, Mux: &sync.Mutex{}}
+	}
+
+	w.acquireLeases(20)
+
+	assert.Equal(t, int32(20), atomic.LoadInt32(&checkpointer.getLeaseCalls))
+	assert.True(t, atomic.LoadInt32(&checkpointer.maxInFlight) <= 3)
+	assert.True(t, atomic.LoadInt32(&checkpointer.maxInFlight) > 1)
+}
+
+func TestMaxLeasesPerAcquisition(t *testing.T) {
+	kc := &mockKinesis{shards: []*kinesis.Shard{
+		mockShard("shardId-0", "0", "85070591730234615865843651857942052863"),
+		mockShard("shardId-1", "85070591730234615865843651857942052864", "170141183460469231731687303715884105727"),
+		mockShard("shardId-2", "170141183460469231731687303715884105728", "255211775190703847597530955573826158591"),
+		mockShard("shardId-3", "255211775190703847597530955573826158592", "340282366920938463463374607431768211455"),
+	}}
+	newWorker := func(kclConfig *KinesisClientLibConfiguration, store *memoryLeaseStore) *Worker {
+		kclConfig.WithFailoverTimeMillis(10000).
+			WithShardSyncIntervalMillis(60000).
+			WithIdleTimeBetweenReadsInMillis(10)
+		return NewWorker(&mockProcessorFactory{}, kclConfig, nil).WithKinesis(kc).WithCheckpointer(store)
+	}
+
+	// a single lease is taken per cycle by default, leaving the other shards to the other workers
+	store := newMemoryLeaseStore(10 * time.Second)
+	w := newWorker(NewKinesisClientLibConfig("appName", "test", "us-west-2", "worker-a"), store)
+	assert.Nil(t, w.Start())
+	time.Sleep(100 * time.Millisecond)
+	store.mux.Lock()
+	assert.Equal(t, 1, len(store.owners))
+	store.mux.Unlock()
+	w.Shutdown()
+
+	// the leases held by another live worker don't count toward the leases taken
+	store = newMemoryLeaseStore(10 * time.Second)
+	for _, id := range []string{"shardId-0", "shardId-1"} {
+		store.owners[id] = "worker-b"
+		store.leaseTimeouts[id] = time.Now().Add(time.Minute)
+	}
+	w = newWorker(NewKinesisClientLibConfig("appName", "test", "us-west-2", "worker-a").WithMaxLeasesPerAcquisition(2),
+		store)
+	assert.Nil(t, w.Start())
+	defer w.Shutdown()
+	assert.True(t, store.waitForOwner("worker-a", time.Second, "shardId-2", "shardId-3"))
+	assert.True(t, store.waitForOwner("worker-b", 10*time.Millisecond, "shardId-0", "shardId-1"))
+}
+
+// mockCheckpointer never grants a lease, but keeps track of the GetLease calls in flight.
+type mockCheckpointer struct {
+	shard.Checkpointer
+	getLeaseDelay time.Duration
+	getLeaseCalls int32
+	inFlight      int32
+	maxInFlight   int32
+}
+
+func (m *mockCheckpointer) FetchCheckpoint(*shard.Status) error {
+	return shard.ErrSequenceIDNotFound
+}
+
+func (m *mockCheckpointer) GetLease(*shard.Status, string) error {
+	atomic.AddInt32(&m.getLeaseCalls, 1)
+	n := atomic.AddInt32(&m.inFlight, 1)
+	defer atomic.AddInt32(&m.inFlight, -1)
+
+	for {
+		max := atomic.LoadInt32(&m.maxInFlight)
+		if n <= max || atomic.CompareAndSwapInt32(&m.maxInFlight, max, n) {
+			break
+		}
+	}
+
+	time.Sleep(m.getLeaseDelay)
+	return errors.New(shard.ErrLeaseNotAquired)
+}
@@ -0,0 +1,149 @@
+package shard
+
+import (
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/guygma/goKCL"
+)
+
+var customLeaseAttributeNames = goKCL.LeaseAttributeNames{
+	LeaseKey:                     "leaseKey",
+	LeaseOwner:                   "leaseOwner",
+	LeaseTimeout:                 "leaseExpiry",
+	Checkpoint:                   "checkpoint",
+	ParentShardId:                "parentShardIds",
+	OwnerSwitchesSinceCheckpoint: "ownerSwitches",
+}
+
+func TestLeaseAttributeNames(t *testing.T) {
+	svc := &attributeRecordingTable{items: make(map[string]map[string]*dynamodb.AttributeValue)}
+	checkpointer := NewDynamoCheckpoint(testConfig().WithLeaseAttributeNames(customLeaseAttributeNames)).
+		WithDynamoDB(svc)
+	assert.Nil(t, checkpointer.Init())
+
+	sh := &Status{ID: "0001", ParentShardId: "0000", Mux: &sync.Mutex{}}
+	assert.Nil(t, checkpointer.GetLease(sh, "worker"))
+	// renewing the lease is conditioned on the current one
+	assert.Nil(t, checkpointer.GetLease(sh, "worker"))
+
+	sh.Checkpoint = "42"
+	assert.Nil(t, checkpointer.CheckpointSequence(sh))
+
+	fetched := &Status{ID: "0001", Mux: &sync.Mutex{}}
+	assert.Nil(t, checkpointer.FetchCheckpoint(fetched))
+	assert.Equal(t, "42", fetched.Checkpoint)
+	assert.Equal(t, "worker", fetched.AssignedTo)
+
+	// the expired lease taken over by another worker counts the owner switch
+	svc.items["0001"]["leaseExpiry"] = &dynamodb.AttributeValue{
+		S: aws.String(time.Now().Add(-time.Minute).UTC().Format(time.RFC3339)),
+	}
+	assert.Nil(t, checkpointer.GetLease(fetched, "other"))
+	assert.Equal(t, "1", aws.StringValue(svc.items["0001"]["ownerSwitches"].N))
+
+	assert.Nil(t, checkpointer.RemoveLeaseOwner("0001"))
+	assert.NotContains(t, svc.items["0001"], "leaseOwner")
+
+	assert.Nil(t, checkpointer.SignalLeasesReleased("worker", []string{"0001"}))
+	assert.Nil(t, checkpointer.RemoveLeaseInfo("0001"))
+
+	item := svc.items[LEASE_RELEASE_SIGNAL_ID]
+	assert.Contains(t, item, "leaseKey")
+
+	// every operation used the remapped names only
+	assert.NotEmpty(t, svc.names)
+	defaults := DefaultLeaseAttributeNames()
+	for _, name := range []string{defaults.LeaseKey, defaults.LeaseOwner, defaults.LeaseTimeout,
+		defaults.Checkpoint, defaults.ParentShardId, defaults.OwnerSwitchesSinceCheckpoint} {
+		assert.NotContains(t, svc.names, name)
+	}
+	for _, name := range []string{"leaseKey", "leaseOwner", "leaseExpiry", "checkpoint", "parentShardIds",
+		"ownerSwitches"} {
+		assert.Contains(t, svc.names, name)
+	}
+}
+
+func TestLeaseAttributeNamesValidation(t *testing.T) {
+	missing := customLeaseAttributeNames
+	missing.Checkpoint = ""
+	assert.Panics(t, func() { testConfig().WithLeaseAttributeNames(missing) })
+
+	duplicate := customLeaseAttributeNames
+	duplicate.LeaseTimeout = duplicate.LeaseOwner
+	assert.Panics(t, func() { testConfig().WithLeaseAttributeNames(duplicate) })
+
+	duplicate = customLeaseAttributeNames
+	duplicate.OwnerSwitchesSinceCheckpoint = duplicate.Checkpoint
+	assert.Panics(t, func() { testConfig().WithLeaseAttributeNames(duplicate) })
+}
+
+func TestDefaultLeaseAttributeNames(t *testing.T) {
+	checkpointer := NewDynamoCheckpoint(testConfig())
+	assert.Equal(t, DefaultLeaseAttributeNames(), checkpointer.attributes)
+}
+
+// attributeRecordingTable is a lease table keeping track of every attribute name the checkpointer refers to, be it
+// in keys, items or expressions.
+type attributeRecordingTable struct {
+	dynamodbiface.DynamoDBAPI
+	keyName string
+	items   map[string]map[string]*dynamodb.AttributeValue
+	names   []string
+}
+
+func (m *attributeRecordingTable) record(item map[string]*dynamodb.AttributeValue, names map[string]*string) {
+	for name := range item {
+		m.names = append(m.names, name)
+	}
+	for _, name := range names {
+		m.names = append(m.names, aws.StringValue(name))
+	}
+}
+
+func (m *attributeRecordingTable) DescribeTable(*dynamodb.DescribeTableInput) (*dynamodb.DescribeTableOutput, error) {
+	return nil, awserr.New(dynamodb.ErrCodeResourceNotFoundException, "doesNotExist", errors.New(""))
+}
+
+func (m *attributeRecordingTable) CreateTable(input *dynamodb.CreateTableInput) (*dynamodb.CreateTableOutput, error) {
+	m.keyName = aws.StringValue(input.KeySchema[0].AttributeName)
+	m.names = append(m.names, m.keyName, aws.StringValue(input.AttributeDefinitions[0].AttributeName))
+	return &dynamodb.CreateTableOutput{}, nil
+}
+
+func (m *attributeRecordingTable) PutItem(input *dynamodb.PutItemInput) (*dynamodb.PutItemOutput, error) {
+	m.record(input.Item, input.ExpressionAttributeNames)
+	m.items[aws.StringValue(input.Item[m.keyName].S)] = input.Item
+	return &dynamodb.PutItemOutput{}, nil
+}
+
+func (m *attributeRecordingTable) GetItem(input *dynamodb.GetItemInput) (*dynamodb.GetItemOutput, error) {
+	m.record(input.Key, nil)
+	return &dynamodb.GetItemOutput{Item: m.items[aws.StringValue(input.Key[m.keyName].S)]}, nil
+}
+
+func (m *attributeRecordingTable) UpdateItem(input *dynamodb.UpdateItemInput) (*dynamodb.UpdateItemOutput, error) {
+	m.record(input.Key, input.ExpressionAttributeNames)
+	item := m.items[aws.StringValue(input.Key[m.keyName].S)]
+	for placeholder, name := range input.ExpressionAttributeNames {
+		if strings.HasPrefix(aws.StringValue(input.UpdateExpression), "remove "+placeholder) {
+			delete(item, aws.StringValue(name))
+		}
+	}
+	return &dynamodb.UpdateItemOutput{}, nil
+}
+
+func (m *attributeRecordingTable) DeleteItem(input *dynamodb.DeleteItemInput) (*dynamodb.DeleteItemOutput, error) {
+	m.record(input.Key, nil)
+	delete(m.items, aws.StringValue(input.Key[m.keyName].S))
+	return &dynamodb.DeleteItemOutput{}, nil
+}
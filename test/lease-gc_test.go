@@ -0,0 +1,183 @@
+package goKCL
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/kinesis"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/guygma/goKCL/record"
+	"github.com/guygma/goKCL/shard"
+)
+
+func TestLeaseGCConcurrency(t *testing.T) {
+	var shards []*kinesis.Shard
+	for i := 0; i < 10; i++ {
+		shards = append(shards, mockShard(fmt.Sprintf("shardId-%d", i), "0", "340282366920938463463374607431768211455"))
+	}
+	kc := &mockKinesis{shards: shards}
+	store := &gcLeaseStore{memoryLeaseStore: newMemoryLeaseStore(time.Minute)}
+	kclConfig := NewKinesisClientLibConfig("appName", "test", "us-west-2", "abc").WithLeaseGC(3, 1000)
+	w := newGCTestWorker(NewWorker(nil, kclConfig, nil).WithKinesis(kc).WithCheckpointer(store))
+	assert.Nil(t, w.syncShard())
+
+	// all the shards expired
+	kc.shards = nil
+	assert.Nil(t, w.syncShard())
+	w.waitGroup.Wait()
+	assert.Equal(t, 10, len(store.removedShards()))
+	assert.Equal(t, 3, store.maxInFlight)
+	assert.Empty(t, staleLeases(w))
+}
+
+func TestLeaseGCUnlimitedRate(t *testing.T) {
+	var shards []*kinesis.Shard
+	for i := 0; i < 10; i++ {
+		shards = append(shards, mockShard(fmt.Sprintf("shardId-%d", i), "0", "340282366920938463463374607431768211455"))
+	}
+	kc := &mockKinesis{shards: shards}
+	store := &gcLeaseStore{memoryLeaseStore: newMemoryLeaseStore(time.Minute)}
+	kclConfig := NewKinesisClientLibConfig("appName", "test", "us-west-2", "abc").WithLeaseGC(10, 0)
+	w := newGCTestWorker(NewWorker(nil, kclConfig, nil).WithKinesis(kc).WithCheckpointer(store))
+	assert.Nil(t, w.syncShard())
+
+	// with no rate limit, all the leases are deleted at once
+	kc.shards = nil
+	assert.Nil(t, w.syncShard())
+	w.waitGroup.Wait()
+	assert.Equal(t, 10, len(store.removedShards()))
+	assert.Equal(t, 10, store.maxInFlight)
+	assert.Empty(t, staleLeases(w))
+}
+
+func TestLeaseGCDoesNotBlockShardSync(t *testing.T) {
+	var shards []*kinesis.Shard
+	for i := 0; i < 5; i++ {
+		shards = append(shards, mockShard(fmt.Sprintf("shardId-%d", i), "0", "340282366920938463463374607431768211455"))
+	}
+	kc := &mockKinesis{shards: shards}
+	store := &gcLeaseStore{memoryLeaseStore: newMemoryLeaseStore(time.Minute)}
+	kclConfig := NewKinesisClientLibConfig("appName", "test", "us-west-2", "abc").WithLeaseGC(1, 10)
+	w := newGCTestWorker(NewWorker(nil, kclConfig, nil).WithKinesis(kc).WithCheckpointer(store))
+	assert.Nil(t, w.syncShard())
+
+	// the shard sync returns while the leases are deleted at 10 per second
+	kc.shards = nil
+	start := time.Now()
+	assert.Nil(t, w.syncShard())
+	assert.True(t, time.Since(start) < 100*time.Millisecond)
+
+	// a single garbage collection runs at a time, the next sync doesn't delete the same leases again
+	assert.Nil(t, w.syncShard())
+	time.Sleep(250 * time.Millisecond)
+
+	// and it stops at shutdown, leaving the remaining leases to collect
+	close(*w.stop)
+	w.waitGroup.Wait()
+	removed := store.removedShards()
+	assert.True(t, len(removed) > 0 && len(removed) < 5)
+	assert.Equal(t, 1, store.maxInFlight)
+	assert.Equal(t, 5-len(removed), len(staleLeases(w)))
+	for _, shardID := range removed {
+		assert.False(t, staleLeases(w)[shardID])
+	}
+}
+
+func TestLeaseGCKeepsParentOfUnfinishedChild(t *testing.T) {
+	parent := mockShard("shardId-0", "0", "340282366920938463463374607431768211455")
+	parent.SequenceNumberRange.EndingSequenceNumber = aws.String("99")
+	child := mockShard("shardId-1", "0", "340282366920938463463374607431768211455")
+	child.ParentShardId = aws.String("shardId-0")
+	kc := &mockKinesis{shards: []*kinesis.Shard{parent, child}}
+	store := &gcLeaseStore{memoryLeaseStore: newMemoryLeaseStore(time.Minute)}
+	w := newGCTestWorker(NewWorker(nil, NewKinesisClientLibConfig("appName", "test", "us-west-2", "abc"), nil).
+		WithKinesis(kc).WithCheckpointer(store))
+	assert.Nil(t, w.syncShard())
+
+	// the parent expired, but its child is still being processed
+	kc.shards = []*kinesis.Shard{child}
+	assert.Nil(t, w.syncShard())
+	w.waitGroup.Wait()
+	assert.Empty(t, store.removedShards())
+	assert.True(t, staleLeases(w)["shardId-0"])
+
+	// the lease is collected once the child is finished
+	w.shardStatus["shardId-1"].Checkpoint = shard.SHARD_END
+	assert.Nil(t, w.syncShard())
+	w.waitGroup.Wait()
+	assert.Equal(t, []string{"shardId-0"}, store.removedShards())
+	assert.Empty(t, staleLeases(w))
+}
+
+func TestLeaseGCRemovesFanOutCheckpoints(t *testing.T) {
+	kc := &mockKinesis{shards: []*kinesis.Shard{mockShard("shardId-0", "0", "340282366920938463463374607431768211455")}}
+	store := &gcLeaseStore{memoryLeaseStore: newMemoryLeaseStore(time.Minute)}
+	factory := record.NewFanOutFactory(store, 1).
+		WithProcessor("archive", &mockProcessorFactory{}).
+		WithProcessor("index", &mockProcessorFactory{})
+	w := newGCTestWorker(NewWorker(factory, NewKinesisClientLibConfig("appName", "test", "us-west-2", "abc"), nil).
+		WithKinesis(kc).WithCheckpointer(store))
+	assert.Nil(t, w.syncShard())
+
+	// the checkpoints of the fan-out processors are removed along with the lease of the expired shard
+	kc.shards = nil
+	assert.Nil(t, w.syncShard())
+	w.waitGroup.Wait()
+	assert.Equal(t, []string{"shardId-0.archive", "shardId-0.index", "shardId-0"}, store.removedShards())
+}
+
+// newGCTestWorker sets up the state of a worker which isn't started for its shard syncs to collect stale leases.
+func newGCTestWorker(w *Worker) *Worker {
+	stop := make(chan struct{})
+	w.stop = &stop
+	w.waitGroup = &sync.WaitGroup{}
+	w.shardStatus = make(map[string]*shard.Status)
+	return w
+}
+
+// staleLeases returns the stale leases of the worker still to be deleted.
+func staleLeases(w *Worker) map[string]bool {
+	w.staleMux.Lock()
+	defer w.staleMux.Unlock()
+	leases := make(map[string]bool)
+	for shardID := range w.staleLeases {
+		leases[shardID] = true
+	}
+	return leases
+}
+
+// gcLeaseStore records the removed leases and the maximum number of removals in flight.
+type gcLeaseStore struct {
+	*memoryLeaseStore
+	gcMux       sync.Mutex
+	inFlight    int
+	maxInFlight int
+	removed     []string
+}
+
+func (m *gcLeaseStore) RemoveLeaseInfo(shardID string) error {
+	m.gcMux.Lock()
+	m.inFlight++
+	if m.inFlight > m.maxInFlight {
+		m.maxInFlight = m.inFlight
+	}
+	m.gcMux.Unlock()
+
+	time.Sleep(20 * time.Millisecond)
+
+	m.gcMux.Lock()
+	defer m.gcMux.Unlock()
+	m.inFlight--
+	m.removed = append(m.removed, shardID)
+	return m.memoryLeaseStore.RemoveLeaseInfo(shardID)
+}
+
+func (m *gcLeaseStore) removedShards() []string {
+	m.gcMux.Lock()
+	defer m.gcMux.Unlock()
+	return append([]string(nil), m.removed...)
+}
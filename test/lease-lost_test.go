@@ -0,0 +1,90 @@
+package shard
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/guygma/goKCL"
+	"github.com/guygma/goKCL/record"
+	"github.com/guygma/goKCL/util"
+)
+
+func TestLeaseLost(t *testing.T) {
+	kc := newMockKinesisClient(3, false)
+	checkpointer := &ownerFetchingCheckpointer{mockShardCheckpointer: newMockShardCheckpointer(), takenOverBy: "def"}
+	listener := &mockEventListener{}
+	processor := &leaseLostProcessor{}
+	sc := newTestConsumer(kc, checkpointer, processor, testConfig().WithEventListener(listener))
+
+	// the lease is due for renewal, and was taken over by another worker meanwhile
+	sh := testShard()
+	sh.LeaseTimeout = time.Now()
+	checkpointer.checkpoints[sh.ID] = "2"
+	assert.Nil(t, sc.GetRecords(sh))
+
+	// the record processor is told before its ZOMBIE shutdown, with its context canceled already
+	assert.Equal(t, []*record.LeaseLostInput{{ShardID: "0001", NewOwner: "def"}}, processor.inputs)
+	assert.True(t, processor.canceled)
+	assert.Equal(t, []util.ShutdownReason{util.ZOMBIE}, processor.shutdownReasons)
+	assert.Equal(t, 1, sc.mService.(*mockMonitoringService).leasesLost)
+
+	events := listener.events
+	assert.Equal(t, 1, len(events))
+	assert.Equal(t, util.EVENT_LEASE_LOST, events[0].Type)
+	assert.Contains(t, events[0].Detail, `"def"`)
+
+	// the lease is read aside, the shard keeps the checkpoint of this consumer and isn't recorded as owned by the
+	// new owner
+	assert.Equal(t, "2", sh.Checkpoint)
+	for _, ownership := range sh.GetLeaseOwnershipHistory() {
+		assert.NotEqual(t, "def", ownership.Owner)
+	}
+}
+
+// ownerFetchingCheckpointer fetches the lease owner along with the checkpoint, like the DynamoDB checkpointer. The
+// lease is taken over by takenOverBy, checkpointing further, on the first renewal.
+type ownerFetchingCheckpointer struct {
+	*mockShardCheckpointer
+	takenOverBy string
+}
+
+func (m *ownerFetchingCheckpointer) GetLease(shard *Status, newAssignTo string) error {
+	m.mux.Lock()
+	if m.takenOverBy != "" {
+		m.owners[shard.ID] = m.takenOverBy
+		m.checkpoints[shard.ID] = "3"
+	}
+	m.mux.Unlock()
+	return m.mockShardCheckpointer.GetLease(shard, newAssignTo)
+}
+
+func (m *ownerFetchingCheckpointer) FetchCheckpoint(shard *Status) error {
+	if err := m.mockShardCheckpointer.FetchCheckpoint(shard); err != nil {
+		return err
+	}
+	m.mux.Lock()
+	defer m.mux.Unlock()
+	shard.Mux.Lock()
+	defer shard.Mux.Unlock()
+	shard.changeLeaseOwner(m.owners[shard.ID], time.Now(), goKCL.DEFAULT_LEASE_OWNERSHIP_HISTORY_LENGTH)
+	return nil
+}
+
+type leaseLostProcessor struct {
+	mockRecordProcessor
+	ctx      context.Context
+	inputs   []*record.LeaseLostInput
+	canceled bool
+}
+
+func (p *leaseLostProcessor) Initialize(input *InitializationInput) {
+	p.ctx = input.Context
+}
+
+func (p *leaseLostProcessor) LeaseLost(input *record.LeaseLostInput) {
+	p.inputs = append(p.inputs, input)
+	p.canceled = p.ctx.Err() != nil
+}
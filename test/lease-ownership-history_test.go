@@ -0,0 +1,61 @@
+package shard
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLeaseOwnershipHistory(t *testing.T) {
+	sh := testShard()
+	start := time.Now()
+	at := func(seconds int) time.Time { return start.Add(time.Duration(seconds) * time.Second) }
+
+	sh.Mux.Lock()
+	sh.changeLeaseOwner("worker-a", at(0), 3)
+	// renewals don't change the owner
+	sh.changeLeaseOwner("worker-a", at(1), 3)
+	sh.changeLeaseOwner("worker-b", at(2), 3)
+	sh.changeLeaseOwner("", at(3), 3)
+	sh.changeLeaseOwner("worker-a", at(4), 3)
+	sh.Mux.Unlock()
+
+	assert.Equal(t, []LeaseOwnership{
+		{Owner: "worker-a", AcquiredAt: at(0), ReleasedAt: at(2), Reason: LEASE_TAKEN_OVER},
+		{Owner: "worker-b", AcquiredAt: at(2), ReleasedAt: at(3), Reason: LEASE_RELEASED},
+		{Owner: "worker-a", AcquiredAt: at(4)},
+	}, sh.GetLeaseOwnershipHistory())
+	assert.Equal(t, "worker-a", sh.GetLeaseOwner())
+
+	// the oldest owners are dropped beyond the history length
+	sh.Mux.Lock()
+	sh.changeLeaseOwner("worker-c", at(5), 3)
+	sh.Mux.Unlock()
+
+	history := sh.GetLeaseOwnershipHistory()
+	assert.Equal(t, 3, len(history))
+	assert.Equal(t, "worker-b", history[0].Owner)
+	assert.Equal(t, LeaseOwnership{Owner: "worker-a", AcquiredAt: at(4), ReleasedAt: at(5), Reason: LEASE_TAKEN_OVER},
+		history[1])
+	assert.Equal(t, "worker-c", history[2].Owner)
+}
+
+func TestLeaseOwnershipHistoryOnRelease(t *testing.T) {
+	kc := newMockKinesisClient(2, false)
+	checkpointer := newMockShardCheckpointer()
+	sc := newTestConsumer(kc, checkpointer, &mockRecordProcessor{}, testConfig())
+
+	sh := testShard()
+	sh.Mux.Lock()
+	sh.changeLeaseOwner("abc", time.Now(), 10)
+	sh.Mux.Unlock()
+	sc.releaseLease(sh)
+
+	history := sh.GetLeaseOwnershipHistory()
+	assert.Equal(t, 1, len(history))
+	assert.Equal(t, "abc", history[0].Owner)
+	assert.Equal(t, LEASE_RELEASED, history[0].Reason)
+	assert.False(t, history[0].ReleasedAt.IsZero())
+	assert.Equal(t, "", sh.GetLeaseOwner())
+}
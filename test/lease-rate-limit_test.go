@@ -0,0 +1,31 @@
+package goKCL
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLeaseAcquisitionMinInterval(t *testing.T) {
+	kclConfig := NewKinesisClientLibConfig("appName", "test", "us-west-2", "abc").
+		WithMinLeaseAcquisitionIntervalMillis(100)
+	w := NewWorker(nil, kclConfig, nil)
+	start := time.Now()
+
+	assert.True(t, w.leaseAcquisitionAllowed(start))
+	assert.False(t, w.leaseAcquisitionAllowed(start.Add(50*time.Millisecond)))
+	assert.False(t, w.leaseAcquisitionAllowed(start.Add(99*time.Millisecond)))
+	assert.True(t, w.leaseAcquisitionAllowed(start.Add(100*time.Millisecond)))
+	// the interval is measured from the last allowed cycle
+	assert.False(t, w.leaseAcquisitionAllowed(start.Add(150*time.Millisecond)))
+	assert.True(t, w.leaseAcquisitionAllowed(start.Add(200*time.Millisecond)))
+}
+
+func TestLeaseAcquisitionWithoutMinInterval(t *testing.T) {
+	w := NewWorker(nil, NewKinesisClientLibConfig("appName", "test", "us-west-2", "abc"), nil)
+	now := time.Now()
+
+	assert.True(t, w.leaseAcquisitionAllowed(now))
+	assert.True(t, w.leaseAcquisitionAllowed(now))
+}
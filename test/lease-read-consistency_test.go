@@ -0,0 +1,82 @@
+package shard
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/guygma/goKCL"
+)
+
+func TestLeaseReadsConsistent(t *testing.T) {
+	svc := newMockLeaseTable("0001", "42")
+	checkpointer := NewDynamoCheckpoint(testConfig().WithLeaseReadConsistency(goKCL.CONSISTENT_READS)).WithDynamoDB(svc)
+
+	status := &Status{ID: "0001", Mux: &sync.Mutex{}}
+	assert.Nil(t, checkpointer.FetchCheckpoint(status))
+	assert.Equal(t, "42", status.Checkpoint)
+	assert.Equal(t, []bool{true}, svc.consistentReads())
+}
+
+func TestLeaseReadsEventualByDefault(t *testing.T) {
+	svc := newMockLeaseTable("0001", "42")
+	checkpointer := NewDynamoCheckpoint(testConfig()).WithDynamoDB(svc)
+
+	status := &Status{ID: "0001", Mux: &sync.Mutex{}}
+	assert.Nil(t, checkpointer.FetchCheckpoint(status))
+	assert.Equal(t, []bool{false}, svc.consistentReads())
+}
+
+func TestLeaseReadsReconciled(t *testing.T) {
+	svc := newMockLeaseTable("0001", "42")
+	svc.staleReads = 1
+	checkpointer := NewDynamoCheckpoint(testConfig().WithLeaseReadConsistency(goKCL.RECONCILED_READS)).WithDynamoDB(svc)
+
+	// the stale first read disagrees with the second one, so a consistent read settles it
+	status := &Status{ID: "0001", Mux: &sync.Mutex{}}
+	assert.Nil(t, checkpointer.FetchCheckpoint(status))
+	assert.Equal(t, "42", status.Checkpoint)
+	assert.Equal(t, []bool{false, false, true}, svc.consistentReads())
+
+	// agreeing reads are trusted
+	assert.Nil(t, checkpointer.FetchCheckpoint(status))
+	assert.Equal(t, []bool{false, false, true, false, false}, svc.consistentReads())
+}
+
+// mockLeaseTable holds the lease of a single shard. The first staleReads eventually consistent reads miss it.
+type mockLeaseTable struct {
+	dynamodbiface.DynamoDBAPI
+	item       map[string]*dynamodb.AttributeValue
+	staleReads int
+	inputs     []*dynamodb.GetItemInput
+}
+
+func newMockLeaseTable(shardID, checkpoint string) *mockLeaseTable {
+	return &mockLeaseTable{
+		item: map[string]*dynamodb.AttributeValue{
+			LEASE_KEY_KEY:                  {S: aws.String(shardID)},
+			CHECKPOINT_SEQUENCE_NUMBER_KEY: {S: aws.String(checkpoint)},
+		},
+	}
+}
+
+func (m *mockLeaseTable) GetItem(input *dynamodb.GetItemInput) (*dynamodb.GetItemOutput, error) {
+	m.inputs = append(m.inputs, input)
+	if !aws.BoolValue(input.ConsistentRead) && m.staleReads > 0 {
+		m.staleReads--
+		return &dynamodb.GetItemOutput{}, nil
+	}
+	return &dynamodb.GetItemOutput{Item: m.item}, nil
+}
+
+func (m *mockLeaseTable) consistentReads() []bool {
+	reads := make([]bool, 0, len(m.inputs))
+	for _, input := range m.inputs {
+		reads = append(reads, aws.BoolValue(input.ConsistentRead))
+	}
+	return reads
+}
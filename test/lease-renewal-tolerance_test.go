@@ -0,0 +1,96 @@
+package shard
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/guygma/goKCL/util"
+)
+
+func TestLeaseRenewalFailuresRelinquish(t *testing.T) {
+	kc := newMockKinesisClient(100, true)
+	checkpointer := &failingRenewalCheckpointer{mockShardCheckpointer: newMockShardCheckpointer(), failures: -1}
+	processor := &mockRecordProcessor{}
+	sc := newTestConsumer(kc, checkpointer, processor, testConfig().
+		WithMaxRecords(1).
+		WithLeaseRenewalFailureTolerance(2))
+
+	// the lease is in the second half of the failover window
+	shard := testShard()
+	shard.LeaseTimeout = time.Now().Add(time.Minute)
+	shard.AssignedTo = "abc"
+
+	err := sc.GetRecords(shard)
+	assert.Equal(t, errRenewal, err)
+	assert.Equal(t, 3, checkpointer.renewals())
+	assert.Equal(t, 2, kc.getRecordsCalls)
+	// relinquished well before the lease expired
+	assert.True(t, shard.LeaseTimeout.After(time.Now()))
+	assert.Equal(t, "", shard.GetLeaseOwner())
+	assert.Equal(t, []util.ShutdownReason{util.REQUESTED}, processor.shutdownReasons)
+}
+
+func TestLeaseRenewalFailuresTolerated(t *testing.T) {
+	kc := newMockKinesisClient(5, true)
+	checkpointer := &failingRenewalCheckpointer{mockShardCheckpointer: newMockShardCheckpointer(), failures: 2}
+	processor := &mockRecordProcessor{}
+	sc := newTestConsumer(kc, checkpointer, processor, testConfig().
+		WithMaxRecords(1).
+		WithLeaseRenewalFailureTolerance(2))
+
+	shard := testShard()
+	shard.LeaseTimeout = time.Now().Add(time.Minute)
+
+	assert.Nil(t, sc.GetRecords(shard))
+	assert.Equal(t, 3, checkpointer.renewals())
+	assert.Equal(t, 5, len(processor.records))
+	assert.Equal(t, 2, sc.mService.(*mockMonitoringService).renewalFailures)
+}
+
+func TestLeaseRenewalFailureNearExpiry(t *testing.T) {
+	kc := newMockKinesisClient(100, true)
+	checkpointer := &failingRenewalCheckpointer{mockShardCheckpointer: newMockShardCheckpointer(), failures: -1}
+	sc := newTestConsumer(kc, checkpointer, &mockRecordProcessor{}, testConfig().
+		WithMaxRecords(1).
+		WithLeaseRenewalFailureTolerance(5))
+
+	// no room left for another renewal, the lease is relinquished on the first failure
+	shard := testShard()
+	shard.LeaseTimeout = time.Now().Add(2 * time.Second)
+
+	assert.Equal(t, errRenewal, sc.GetRecords(shard))
+	assert.Equal(t, 1, checkpointer.renewals())
+	assert.Equal(t, 0, kc.getRecordsCalls)
+}
+
+var errRenewal = errors.New("lease table unavailable")
+
+// failingRenewalCheckpointer fails the first failures lease renewals, all of them if negative.
+type failingRenewalCheckpointer struct {
+	*mockShardCheckpointer
+	failures int
+
+	renewalMux sync.Mutex
+	calls      int
+}
+
+func (m *failingRenewalCheckpointer) GetLease(shard *Status, newAssignTo string) error {
+	m.renewalMux.Lock()
+	m.calls++
+	fail := m.failures < 0 || m.calls <= m.failures
+	m.renewalMux.Unlock()
+	if fail {
+		return errRenewal
+	}
+	return m.mockShardCheckpointer.GetLease(shard, newAssignTo)
+}
+
+func (m *failingRenewalCheckpointer) renewals() int {
+	m.renewalMux.Lock()
+	defer m.renewalMux.Unlock()
+	return m.calls
+}
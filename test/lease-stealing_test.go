@@ -0,0 +1,156 @@
+package goKCL
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/service/kinesis"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/guygma/goKCL/shard"
+	"github.com/guygma/goKCL/util"
+)
+
+func TestLeaseStealing(t *testing.T) {
+	kc := &mockKinesis{shards: []*kinesis.Shard{
+		mockShard("shardId-0", "0", "170141183460469231731687303715884105727"),
+		mockShard("shardId-1", "170141183460469231731687303715884105728", "340282366920938463463374607431768211455"),
+	}}
+	// the leases are renewed on every read, so that a stolen lease is noticed right away
+	store := &stealingLeaseStore{memoryLeaseStore: newMemoryLeaseStore(5 * time.Second)}
+	newWorker := func(workerID string, factory *shutdownRecordingFactory) *Worker {
+		kclConfig := NewKinesisClientLibConfig("appName", "test", "us-west-2", workerID).
+			WithFailoverTimeMillis(5000).
+			WithShardSyncIntervalMillis(60000).
+			WithMaxLeasesPerAcquisition(2).
+			WithIdleTimeBetweenReadsInMillis(10).
+			WithLeaseStealing(1, 10)
+		return NewWorker(factory, kclConfig, nil).WithKinesis(kc).WithCheckpointer(store)
+	}
+
+	loadedFactory := &shutdownRecordingFactory{}
+	loaded := newWorker("worker-a", loadedFactory)
+	assert.Nil(t, loaded.Start())
+	defer loaded.Shutdown()
+	assert.True(t, store.waitForOwner("worker-a", time.Second, "shardId-0", "shardId-1"))
+
+	// the joining worker steals one of the two live leases
+	joining := newWorker("worker-b", &shutdownRecordingFactory{})
+	assert.Nil(t, joining.Start())
+	defer joining.Shutdown()
+	assert.True(t, store.waitForOwners(map[string]int{"worker-a": 1, "worker-b": 1}, time.Second))
+
+	// the previous owner shuts the record processor of the stolen shard down as ZOMBIE, without releasing the lease
+	deadline := time.Now().Add(time.Second)
+	for len(loadedFactory.shutdownReasons()) == 0 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	assert.Equal(t, []util.ShutdownReason{util.ZOMBIE}, loadedFactory.shutdownReasons())
+	time.Sleep(50 * time.Millisecond)
+	assert.True(t, store.waitForOwners(map[string]int{"worker-a": 1, "worker-b": 1}, 10*time.Millisecond))
+}
+
+func TestLeasesToSteal(t *testing.T) {
+	kclConfig := NewKinesisClientLibConfig("appName", "test", "us-west-2", "worker-c").WithLeaseStealing(2, 10)
+	w := NewWorker(nil, kclConfig, nil)
+	w.shardStatus = make(map[string]*shard.Status)
+	now := time.Now()
+	lease := func(shardID, owner string) *shard.Lease {
+		w.shardStatus[shardID] = &shard.Status{ID: shardID}
+		return &shard.Lease{ShardID: shardID, Owner: owner, LeaseTimeout: now.Add(time.Minute)}
+	}
+
+	// the fair share of 3 workers over 6 leases is 2: the busiest worker only holds 1 too many
+	leases := []*shard.Lease{
+		lease("shardId-0", "worker-a"),
+		lease("shardId-1", "worker-a"),
+		lease("shardId-2", "worker-a"),
+		lease("shardId-3", "worker-b"),
+		lease("shardId-4", "worker-b"),
+		lease("shardId-5", "worker-b"),
+	}
+	stolen := w.leasesToSteal(leases, now)
+	assert.Equal(t, 1, len(stolen))
+	assert.Equal(t, "worker-a", stolen[0].Owner)
+
+	// up to MaxLeasesToStealAtOneTime are stolen at once
+	leases[3].Owner, leases[4].Owner, leases[5].Owner = "worker-a", "worker-a", "worker-a"
+	stolen = w.leasesToSteal(leases, now)
+	assert.Equal(t, 2, len(stolen))
+
+	// no lease is stolen while one is available
+	leases[5].LeaseTimeout = now.Add(-time.Minute)
+	assert.Empty(t, w.leasesToSteal(leases, now))
+
+	// nor once the worker holds its fair share
+	leases[5].LeaseTimeout = now.Add(time.Minute)
+	leases[0].Owner, leases[1].Owner, leases[2].Owner = "worker-c", "worker-c", "worker-c"
+	assert.Empty(t, w.leasesToSteal(leases, now))
+
+	// the finished shards aren't balanced
+	leases[0].Owner, leases[1].Owner, leases[2].Owner = "worker-a", "worker-a", "worker-a"
+	for _, l := range leases {
+		l.Checkpoint = shard.SHARD_END
+	}
+	assert.Empty(t, w.leasesToSteal(leases, now))
+}
+
+// stealingLeaseStore is a memoryLeaseStore listing its leases and letting the workers steal them.
+type stealingLeaseStore struct {
+	*memoryLeaseStore
+}
+
+func (m *stealingLeaseStore) GetLeases() ([]*shard.Lease, error) {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+	var leases []*shard.Lease
+	for shardID, owner := range m.owners {
+		leases = append(leases, &shard.Lease{
+			ShardID:      shardID,
+			Owner:        owner,
+			LeaseTimeout: m.leaseTimeouts[shardID],
+			Checkpoint:   m.checkpoints[shardID],
+		})
+	}
+	return leases, nil
+}
+
+func (m *stealingLeaseStore) StealLease(sh *shard.Status, owner, newAssignTo string) error {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+	if m.owners[sh.ID] != owner {
+		return errors.New(shard.ErrLeaseNotAquired)
+	}
+	m.owners[sh.ID] = newAssignTo
+	m.leaseTimeouts[sh.ID] = time.Now().Add(m.leaseDuration)
+
+	sh.Mux.Lock()
+	sh.AssignedTo = newAssignTo
+	sh.LeaseTimeout = m.leaseTimeouts[sh.ID]
+	sh.Mux.Unlock()
+	return nil
+}
+
+// waitForOwners returns true once every owner holds the given number of leases, false if that didn't happen within
+// timeout.
+func (m *stealingLeaseStore) waitForOwners(counts map[string]int, timeout time.Duration) bool {
+	deadline := time.Now().Add(timeout)
+	for time.Now().Before(deadline) {
+		m.mux.Lock()
+		held := make(map[string]int)
+		for _, owner := range m.owners {
+			held[owner]++
+		}
+		m.mux.Unlock()
+		balanced := true
+		for owner, n := range counts {
+			balanced = balanced && held[owner] == n
+		}
+		if balanced {
+			return true
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	return false
+}
@@ -0,0 +1,212 @@
+package shard
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/guygma/goKCL/util"
+)
+
+func TestLeaseStoreCheckpointer(t *testing.T) {
+	store := newMapLeaseStore()
+	checkpointer := NewLeaseStoreCheckpointer(testConfig(), store)
+	assert.Nil(t, checkpointer.Init())
+
+	// the lease is created by the first worker and renewed by its owner only
+	shard := testShard()
+	assert.Nil(t, checkpointer.GetLease(shard, "abc"))
+	assert.Nil(t, checkpointer.GetLease(shard, "abc"))
+	assert.Equal(t, errors.New(ErrLeaseNotAquired), checkpointer.GetLease(testShard(), "def"))
+
+	shard.Checkpoint = "5"
+	assert.Nil(t, checkpointer.CheckpointSequence(shard))
+	fetched := testShard()
+	assert.Nil(t, checkpointer.FetchCheckpoint(fetched))
+	assert.Equal(t, "5", fetched.Checkpoint)
+	assert.Equal(t, "abc", fetched.GetLeaseOwner())
+
+	// an expired lease is taken over
+	store.leases["0001"].LeaseTimeout = time.Now().Add(-time.Second)
+	assert.Nil(t, checkpointer.GetLease(fetched, "def"))
+	assert.Equal(t, "def", store.leases["0001"].Owner)
+	assert.Equal(t, "5", store.leases["0001"].Checkpoint)
+
+	// a live lease is only stolen from its owner
+	assert.Equal(t, errors.New(ErrLeaseNotAquired), checkpointer.StealLease(shard, "abc", "ghi"))
+	assert.Nil(t, checkpointer.StealLease(shard, "def", "ghi"))
+	assert.Equal(t, "ghi", store.leases["0001"].Owner)
+
+	// a released lease is available right away
+	assert.Nil(t, checkpointer.RemoveLeaseOwner("0001"))
+	assert.Equal(t, "", store.leases["0001"].Owner)
+	assert.Nil(t, checkpointer.GetLease(shard, "abc"))
+
+	leases, err := checkpointer.GetLeases()
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(leases))
+
+	assert.Nil(t, checkpointer.RemoveLeaseInfo("0001"))
+	assert.Equal(t, ErrSequenceIDNotFound, checkpointer.FetchCheckpoint(testShard()))
+}
+
+func TestLeaseStoreRetries(t *testing.T) {
+	store := newMapLeaseStore()
+	checkpointer := NewLeaseStoreCheckpointer(testConfig(), store)
+	checkpointer.Retries = 2
+
+	// the lack of throughput of the store is retried like the retryable errors
+	store.failures = []error{
+		util.LeasingProvisionedThroughputError.MakeErr().WithDetail("too many writes"),
+		util.LeasingDependencyError.MakeErr().WithDetail("connection reset"),
+	}
+	assert.Nil(t, checkpointer.GetLease(testShard(), "abc"))
+	assert.Empty(t, store.failures)
+
+	// up to Retries times
+	store.failures = []error{
+		util.LeasingProvisionedThroughputError.MakeErr(),
+		util.LeasingProvisionedThroughputError.MakeErr(),
+		util.LeasingProvisionedThroughputError.MakeErr(),
+	}
+	err := checkpointer.FetchCheckpoint(testShard())
+	assert.True(t, errors.Is(err, util.LeasingProvisionedThroughputError.MakeErr()))
+
+	// the other errors aren't retried
+	store.failures = []error{util.IllegalArgumentError.MakeErr(), nil}
+	err = checkpointer.FetchCheckpoint(testShard())
+	assert.True(t, errors.Is(err, util.IllegalArgumentError.MakeErr()))
+	assert.Equal(t, 1, len(store.failures))
+}
+
+func TestDynamoLeaseStoreErrors(t *testing.T) {
+	throttled := awserr.New(dynamodb.ErrCodeProvisionedThroughputExceededException, "throttled", nil)
+	assert.True(t, errors.Is(leaseStoreError(throttled), util.LeasingProvisionedThroughputError.MakeErr()))
+	assert.True(t, isRetryableLeaseError(leaseStoreError(throttled)))
+
+	conditionFailed := awserr.New(dynamodb.ErrCodeConditionalCheckFailedException, "condition failed", nil)
+	assert.Equal(t, errors.New(ErrLeaseNotAquired), leaseStoreError(conditionFailed))
+	assert.False(t, isRetryableLeaseError(leaseStoreError(conditionFailed)))
+}
+
+// mapLeaseStore is a LeaseStore keeping the leases in a map. Its operations fail with the queued failures first, a
+// nil failure letting the operation through.
+type mapLeaseStore struct {
+	mux      sync.Mutex
+	leases   map[string]*Lease
+	failures []error
+}
+
+func newMapLeaseStore() *mapLeaseStore {
+	return &mapLeaseStore{leases: make(map[string]*Lease)}
+}
+
+func (m *mapLeaseStore) fail() error {
+	if len(m.failures) == 0 {
+		return nil
+	}
+	err := m.failures[0]
+	m.failures = m.failures[1:]
+	return err
+}
+
+func (m *mapLeaseStore) Init() error {
+	return nil
+}
+
+func (m *mapLeaseStore) GetLease(shardID string) (*Lease, error) {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+	if err := m.fail(); err != nil {
+		return nil, err
+	}
+	lease, ok := m.leases[shardID]
+	if !ok {
+		return nil, nil
+	}
+	copied := *lease
+	return &copied, nil
+}
+
+func (m *mapLeaseStore) CreateLease(lease *Lease) error {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+	if err := m.fail(); err != nil {
+		return err
+	}
+	if _, ok := m.leases[lease.ShardID]; ok {
+		return errors.New(ErrLeaseNotAquired)
+	}
+	created := *lease
+	m.leases[lease.ShardID] = &created
+	return nil
+}
+
+func (m *mapLeaseStore) RenewLease(lease *Lease, leaseTimeout time.Time) error {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+	if err := m.fail(); err != nil {
+		return err
+	}
+	current, ok := m.leases[lease.ShardID]
+	if !ok || current.Owner != lease.Owner {
+		return errors.New(ErrLeaseNotAquired)
+	}
+	current.LeaseTimeout = leaseTimeout
+	return nil
+}
+
+func (m *mapLeaseStore) TakeLease(lease *Lease, newOwner string, leaseTimeout time.Time) error {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+	if err := m.fail(); err != nil {
+		return err
+	}
+	current, ok := m.leases[lease.ShardID]
+	if !ok || current.Owner != lease.Owner || !current.LeaseTimeout.Equal(lease.LeaseTimeout) {
+		return errors.New(ErrLeaseNotAquired)
+	}
+	current.Owner = newOwner
+	current.LeaseTimeout = leaseTimeout
+	return nil
+}
+
+func (m *mapLeaseStore) UpdateCheckpoint(lease *Lease) error {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+	if err := m.fail(); err != nil {
+		return err
+	}
+	updated := *lease
+	m.leases[lease.ShardID] = &updated
+	return nil
+}
+
+func (m *mapLeaseStore) ListLeases() ([]*Lease, error) {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+	if err := m.fail(); err != nil {
+		return nil, err
+	}
+	var leases []*Lease
+	for _, lease := range m.leases {
+		copied := *lease
+		leases = append(leases, &copied)
+	}
+	return leases, nil
+}
+
+func (m *mapLeaseStore) DeleteLease(shardID string) error {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+	if err := m.fail(); err != nil {
+		return err
+	}
+	delete(m.leases, shardID)
+	return nil
+}
@@ -0,0 +1,46 @@
+package shard
+
+import (
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/guygma/goKCL"
+)
+
+func TestLeaseTableProvisionedBillingMode(t *testing.T) {
+	// the initial capacity is provisioned by default
+	svc := &mockDynamoDB{tableExist: false, item: map[string]*dynamodb.AttributeValue{}}
+	assert.Nil(t, NewDynamoCheckpoint(testConfig()).WithDynamoDB(svc).Init())
+	assert.Equal(t, dynamodb.BillingModeProvisioned, aws.StringValue(svc.createTableInput.BillingMode))
+	assert.Equal(t, int64(goKCL.DEFAULT_INITIAL_LEASE_TABLE_READ_CAPACITY),
+		aws.Int64Value(svc.createTableInput.ProvisionedThroughput.ReadCapacityUnits))
+
+	svc = &mockDynamoDB{tableExist: false, item: map[string]*dynamodb.AttributeValue{}}
+	kclConfig := testConfig().WithLeaseTableConfig(goKCL.LeaseTableConfig{
+		BillingMode:        dynamodb.BillingModeProvisioned,
+		ReadCapacityUnits:  50,
+		WriteCapacityUnits: 100,
+	})
+	assert.Nil(t, NewDynamoCheckpoint(kclConfig).WithDynamoDB(svc).Init())
+	assert.Equal(t, int64(50), aws.Int64Value(svc.createTableInput.ProvisionedThroughput.ReadCapacityUnits))
+	assert.Equal(t, int64(100), aws.Int64Value(svc.createTableInput.ProvisionedThroughput.WriteCapacityUnits))
+}
+
+func TestLeaseTablePayPerRequestBillingMode(t *testing.T) {
+	svc := &mockDynamoDB{tableExist: false, item: map[string]*dynamodb.AttributeValue{}}
+	kclConfig := testConfig().WithLeaseTableConfig(goKCL.LeaseTableConfig{
+		BillingMode:        dynamodb.BillingModePayPerRequest,
+		ReadCapacityUnits:  50,
+		WriteCapacityUnits: 100,
+	})
+	assert.Nil(t, NewDynamoCheckpoint(kclConfig).WithDynamoDB(svc).Init())
+
+	// the capacity isn't sent to DynamoDB
+	assert.Equal(t, dynamodb.BillingModePayPerRequest, aws.StringValue(svc.createTableInput.BillingMode))
+	assert.Nil(t, svc.createTableInput.ProvisionedThroughput)
+
+	assert.Panics(t, func() { testConfig().WithLeaseTableConfig(goKCL.LeaseTableConfig{BillingMode: "ON_DEMAND"}) })
+}
@@ -0,0 +1,94 @@
+package shard
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/guygma/goKCL/util"
+)
+
+func TestLeaseTableRecreation(t *testing.T) {
+	listener := &mockEventListener{}
+	table := &deletableLeaseTable{lagLeaseTable: lagLeaseTable{items: make(map[string]map[string]*dynamodb.AttributeValue)}}
+	checkpointer := NewDynamoCheckpoint(testConfig().WithEventListener(listener).WithRecreateDeletedLeaseTable(true)).
+		WithDynamoDB(table)
+
+	sh := &Status{ID: "0001", Mux: &sync.Mutex{}}
+	assert.Nil(t, checkpointer.GetLease(sh, "abc"))
+	sh.Checkpoint = "5"
+	assert.Nil(t, checkpointer.CheckpointSequence(sh))
+
+	// the table is deleted mid-run: the lease renewal fails, and the table is created again once
+	table.delete()
+	assert.NotNil(t, checkpointer.GetLease(sh, "abc"))
+	assert.Equal(t, 1, table.creates)
+
+	events := listener.received()
+	assert.Equal(t, 1, len(events))
+	assert.Equal(t, util.CRITICAL, events[0].Severity)
+	assert.Equal(t, util.EVENT_LEASE_TABLE_RECREATED, events[0].Type)
+
+	// the next renewal writes the lease again, with the checkpoint of the shard
+	assert.Nil(t, checkpointer.GetLease(sh, "abc"))
+	lease := table.items["0001"]
+	assert.Equal(t, "abc", aws.StringValue(lease[LEASE_OWNER_KEY].S))
+	assert.Equal(t, "5", aws.StringValue(lease[CHECKPOINT_SEQUENCE_NUMBER_KEY].S))
+}
+
+func TestLeaseTableRecreationDisabled(t *testing.T) {
+	table := &deletableLeaseTable{lagLeaseTable: lagLeaseTable{items: make(map[string]map[string]*dynamodb.AttributeValue)}}
+	checkpointer := NewDynamoCheckpoint(testConfig()).WithDynamoDB(table)
+
+	sh := &Status{ID: "0001", Mux: &sync.Mutex{}}
+	table.delete()
+	assert.NotNil(t, checkpointer.GetLease(sh, "abc"))
+	assert.Equal(t, 0, table.creates)
+}
+
+// deletableLeaseTable is a lease table which can be deleted and created again.
+type deletableLeaseTable struct {
+	lagLeaseTable
+	deleted bool
+	creates int
+}
+
+func (m *deletableLeaseTable) delete() {
+	m.deleted = true
+	m.items = make(map[string]map[string]*dynamodb.AttributeValue)
+}
+
+func (m *deletableLeaseTable) notFound() error {
+	return awserr.New(dynamodb.ErrCodeResourceNotFoundException, "table not found", nil)
+}
+
+func (m *deletableLeaseTable) DescribeTable(input *dynamodb.DescribeTableInput) (*dynamodb.DescribeTableOutput, error) {
+	if m.deleted {
+		return nil, m.notFound()
+	}
+	return &dynamodb.DescribeTableOutput{}, nil
+}
+
+func (m *deletableLeaseTable) CreateTable(input *dynamodb.CreateTableInput) (*dynamodb.CreateTableOutput, error) {
+	m.deleted = false
+	m.creates++
+	return &dynamodb.CreateTableOutput{}, nil
+}
+
+func (m *deletableLeaseTable) PutItem(input *dynamodb.PutItemInput) (*dynamodb.PutItemOutput, error) {
+	if m.deleted {
+		return nil, m.notFound()
+	}
+	return m.lagLeaseTable.PutItem(input)
+}
+
+func (m *deletableLeaseTable) GetItem(input *dynamodb.GetItemInput) (*dynamodb.GetItemOutput, error) {
+	if m.deleted {
+		return nil, m.notFound()
+	}
+	return m.lagLeaseTable.GetItem(input)
+}
@@ -0,0 +1,65 @@
+package goKCL
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/guygma/goKCL/shard"
+	"github.com/guygma/goKCL/util"
+)
+
+func TestWorkerStartsAfterThrottledLeaseTable(t *testing.T) {
+	table := &throttledLeaseTable{throttles: 2}
+	kclConfig := NewKinesisClientLibConfig("appName", "test", "us-west-2", "abc").
+		WithShardSyncIntervalMillis(60000)
+	w := NewWorker(&mockProcessorFactory{}, kclConfig, nil).
+		WithKinesis(&mockKinesis{}).
+		WithCheckpointer(shard.NewDynamoCheckpoint(kclConfig).WithDynamoDB(table))
+
+	assert.Nil(t, w.Start())
+	defer w.Shutdown()
+	assert.Equal(t, 3, table.describeCalls())
+}
+
+func TestWorkerFailsOnPersistentlyThrottledLeaseTable(t *testing.T) {
+	table := &throttledLeaseTable{throttles: 5}
+	kclConfig := NewKinesisClientLibConfig("appName", "test", "us-west-2", "abc").
+		WithLeaseTableStartupRetries(1)
+	w := NewWorker(&mockProcessorFactory{}, kclConfig, nil).
+		WithKinesis(&mockKinesis{}).
+		WithCheckpointer(shard.NewDynamoCheckpoint(kclConfig).WithDynamoDB(table))
+
+	err := w.Start()
+	assert.NotNil(t, err)
+	assert.Equal(t, util.LeasingProvisionedThroughputError, err.(*util.ClientLibraryError).ErrorCode)
+	assert.Equal(t, 2, table.describeCalls())
+}
+
+// throttledLeaseTable throttles the first lookups of the lease table.
+type throttledLeaseTable struct {
+	dynamodbiface.DynamoDBAPI
+	mux       sync.Mutex
+	throttles int
+	describes int
+}
+
+func (m *throttledLeaseTable) DescribeTable(input *dynamodb.DescribeTableInput) (*dynamodb.DescribeTableOutput, error) {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+	m.describes++
+	if m.describes <= m.throttles {
+		return nil, awserr.New(dynamodb.ErrCodeProvisionedThroughputExceededException, "throttled", nil)
+	}
+	return &dynamodb.DescribeTableOutput{}, nil
+}
+
+func (m *throttledLeaseTable) describeCalls() int {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+	return m.describes
+}
@@ -0,0 +1,47 @@
+package shard
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLeaseTakeoverGrace(t *testing.T) {
+	svc := &lagLeaseTable{items: make(map[string]map[string]*dynamodb.AttributeValue)}
+	checkpointer := NewDynamoCheckpoint(testConfig().WithLeaseTakeoverGraceMillis(10000)).WithDynamoDB(svc)
+	sh := &Status{ID: "0001", Mux: &sync.Mutex{}}
+
+	// expired, but within the grace period
+	peerLease(svc, "0001", time.Now().Add(-2*time.Second))
+	err := checkpointer.GetLease(sh, "worker")
+	assert.NotNil(t, err)
+	assert.Equal(t, ErrLeaseNotAquired, err.Error())
+	assert.Equal(t, "peer", aws.StringValue(svc.items["0001"][LEASE_OWNER_KEY].S))
+
+	// expired beyond the grace period
+	peerLease(svc, "0001", time.Now().Add(-20*time.Second))
+	assert.Nil(t, checkpointer.GetLease(sh, "worker"))
+	assert.Equal(t, "worker", aws.StringValue(svc.items["0001"][LEASE_OWNER_KEY].S))
+	assert.Equal(t, "worker", sh.GetLeaseOwner())
+
+	// a worker renews its own lease regardless of the grace period
+	assert.Nil(t, checkpointer.GetLease(sh, "worker"))
+
+	// without grace period the expired lease is taken over right away
+	checkpointer = NewDynamoCheckpoint(testConfig()).WithDynamoDB(svc)
+	peerLease(svc, "0001", time.Now().Add(-2*time.Second))
+	assert.Nil(t, checkpointer.GetLease(&Status{ID: "0001", Mux: &sync.Mutex{}}, "worker"))
+}
+
+// peerLease makes the shard leased by another worker until leaseTimeout.
+func peerLease(svc *lagLeaseTable, shardID string, leaseTimeout time.Time) {
+	svc.items[shardID] = map[string]*dynamodb.AttributeValue{
+		LEASE_KEY_KEY:     {S: aws.String(shardID)},
+		LEASE_OWNER_KEY:   {S: aws.String("peer")},
+		LEASE_TIMEOUT_KEY: {S: aws.String(leaseTimeout.UTC().Format(time.RFC3339))},
+	}
+}
@@ -0,0 +1,57 @@
+package shard
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/kinesis"
+	"github.com/aws/aws-sdk-go/service/kinesis/kinesisiface"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMaxRecordsForShard(t *testing.T) {
+	// larger batches for the shards more than 5 minutes behind
+	cfg := testConfig().WithMaxRecords(2).WithMaxRecordsForShard(func(shardID string, millisBehindLatest int64) int {
+		if millisBehindLatest >= int64(5*time.Minute/time.Millisecond) {
+			return 10
+		}
+		return 2
+	})
+
+	behind := &limitRecordingKinesis{KinesisAPI: &backloggedKinesis{mockKinesisClient: newMockKinesisClient(20, true)}}
+	assert.Nil(t, newTestConsumer(behind, newMockShardCheckpointer(), &mockRecordProcessor{}, cfg).GetRecords(testShard()))
+	assert.Equal(t, []int64{2, 10, 10}, behind.recordedLimits())
+
+	idle := &limitRecordingKinesis{KinesisAPI: newMockKinesisClient(4, true)}
+	assert.Nil(t, newTestConsumer(idle, newMockShardCheckpointer(), &mockRecordProcessor{}, cfg).GetRecords(testShard()))
+	assert.Equal(t, []int64{2, 2}, idle.recordedLimits())
+}
+
+func TestMaxRecordsForShardBounded(t *testing.T) {
+	kc := &limitRecordingKinesis{KinesisAPI: newMockKinesisClient(1, true)}
+	cfg := testConfig().WithMaxRecordsForShard(func(string, int64) int { return 100000 })
+	assert.Nil(t, newTestConsumer(kc, newMockShardCheckpointer(), &mockRecordProcessor{}, cfg).GetRecords(testShard()))
+	assert.Equal(t, []int64{10000}, kc.recordedLimits())
+}
+
+// limitRecordingKinesis records the limit of every GetRecords call.
+type limitRecordingKinesis struct {
+	kinesisiface.KinesisAPI
+	mux    sync.Mutex
+	limits []int64
+}
+
+func (m *limitRecordingKinesis) GetRecords(input *kinesis.GetRecordsInput) (*kinesis.GetRecordsOutput, error) {
+	m.mux.Lock()
+	m.limits = append(m.limits, aws.Int64Value(input.Limit))
+	m.mux.Unlock()
+	return m.KinesisAPI.GetRecords(input)
+}
+
+func (m *limitRecordingKinesis) recordedLimits() []int64 {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+	return append([]int64(nil), m.limits...)
+}
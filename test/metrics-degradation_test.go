@@ -0,0 +1,57 @@
+package util
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCloudWatchUnavailable(t *testing.T) {
+	svc := &mockCloudWatch{err: errors.New("cloudwatch unavailable"), block: make(chan struct{})}
+	cw := newTestCloudWatch(svc, METRICS_DETAILED)
+	cw.MetricsMaxQueueSize = 3
+
+	for i := 0; i < 2; i++ {
+		cw.MillisBehindLatest("0001", float64(i))
+		cw.IncrRecordsProcessed("0001", 10)
+	}
+
+	flushed := make(chan struct{})
+	go func() {
+		cw.flush()
+		close(flushed)
+	}()
+
+	// recording metrics isn't blocked by a hanging CloudWatch
+	recorded := make(chan struct{})
+	go func() {
+		for i := 2; i < 4; i++ {
+			cw.MillisBehindLatest("0001", float64(i))
+			cw.IncrRecordsProcessed("0001", 10)
+		}
+		close(recorded)
+	}()
+	select {
+	case <-recorded:
+	case <-time.After(time.Second):
+		t.Fatal("recording metrics blocked on CloudWatch")
+	}
+
+	close(svc.block)
+	<-flushed
+
+	// the counters are kept for the next flush, the oldest samples beyond the queue size are dropped
+	assert.Equal(t, int64(1), cw.DroppedMetrics())
+	m := cw.getOrCreatePerShardMetrics("0001")
+	assert.Equal(t, int64(40), m.processedRecords)
+	assert.Equal(t, []float64{1, 2, 3}, m.behindLatestMillis)
+
+	// once CloudWatch is back, the kept metrics are published
+	svc.err = nil
+	assert.Nil(t, cw.flush())
+	assert.Equal(t, float64(40), *svc.datum("RecordsProcessed").Value)
+	assert.Equal(t, float64(3), *svc.datum("MillisBehindLatest").StatisticValues.SampleCount)
+	assert.Equal(t, int64(1), cw.DroppedMetrics())
+}
@@ -0,0 +1,90 @@
+package util
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/cloudwatch"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMetricsPublisher(t *testing.T) {
+	publisher := &recordingPublisher{}
+	metricsConfig := &MonitoringConfiguration{Publisher: publisher}
+	assert.Nil(t, metricsConfig.Init("appName", "test", "abc"))
+	service := metricsConfig.GetMonitoringService()
+
+	service.IncrRecordsProcessed("0001", 10)
+	service.MillisBehindLatest("0001", 1500)
+	service.IncrLeaseRenewalFailures("0002")
+	service.ProcessingThroughput("0001", 2.5)
+
+	assert.Equal(t, []string{
+		"count RecordsProcessed 10 0001",
+		"latency MillisBehindLatest 1.5s 0001",
+		"count LeaseRenewalFailures 1 0002",
+		"value ProcessingThroughput 2.5 0001",
+	}, publisher.metrics)
+	assert.Equal(t, map[string]string{"Shard": "0001", "KinesisStreamName": "test", "WorkerID": "abc"},
+		publisher.dims)
+}
+
+func TestMetricsPublisherLevel(t *testing.T) {
+	publisher := &recordingPublisher{}
+	metricsConfig := &MonitoringConfiguration{Publisher: publisher, MetricsLevel: METRICS_SUMMARY}
+	assert.Nil(t, metricsConfig.Init("appName", "test", "abc"))
+	metricsConfig.GetMonitoringService().RecordAge("0001", 100)
+	assert.Empty(t, publisher.metrics)
+
+	// no metrics at all at METRICS_NONE
+	metricsConfig = &MonitoringConfiguration{Publisher: publisher, MetricsLevel: METRICS_NONE}
+	assert.Nil(t, metricsConfig.Init("appName", "test", "abc"))
+	assert.IsType(t, &noopMonitoringService{}, metricsConfig.GetMonitoringService())
+}
+
+func TestCloudWatchMetricsPublisher(t *testing.T) {
+	svc := &mockCloudWatch{}
+	publisher := NewCloudWatchMetricsPublisher("appName", svc)
+
+	publisher.RecordLatency("MillisBehindLatest", 1500*time.Millisecond, map[string]string{"Shard": "0001"})
+	datum := svc.datum("MillisBehindLatest")
+	assert.NotNil(t, datum)
+	assert.Equal(t, cloudwatch.StandardUnitMilliseconds, aws.StringValue(datum.Unit))
+	assert.Equal(t, 1500.0, aws.Float64Value(datum.Value))
+	assert.Equal(t, "Shard", aws.StringValue(datum.Dimensions[0].Name))
+	assert.Equal(t, "0001", aws.StringValue(datum.Dimensions[0].Value))
+
+	publisher.IncrementCount("RecordsProcessed", 10, nil)
+	datum = svc.datum("RecordsProcessed")
+	assert.Equal(t, cloudwatch.StandardUnitCount, aws.StringValue(datum.Unit))
+	assert.Equal(t, 10.0, aws.Float64Value(datum.Value))
+}
+
+// recordingPublisher records the metrics published, and the dimensions of the last one.
+type recordingPublisher struct {
+	mux     sync.Mutex
+	metrics []string
+	dims    map[string]string
+}
+
+func (p *recordingPublisher) IncrementCount(name string, value float64, dims map[string]string) {
+	p.record(fmt.Sprintf("count %s %v %s", name, value, dims["Shard"]), dims)
+}
+
+func (p *recordingPublisher) RecordLatency(name string, d time.Duration, dims map[string]string) {
+	p.record(fmt.Sprintf("latency %s %v %s", name, d, dims["Shard"]), dims)
+}
+
+func (p *recordingPublisher) RecordValue(name string, value float64, dims map[string]string) {
+	p.record(fmt.Sprintf("value %s %v %s", name, value, dims["Shard"]), dims)
+}
+
+func (p *recordingPublisher) record(metric string, dims map[string]string) {
+	p.mux.Lock()
+	defer p.mux.Unlock()
+	p.metrics = append(p.metrics, metric)
+	p.dims = dims
+}
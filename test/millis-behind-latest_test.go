@@ -0,0 +1,90 @@
+package shard
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/kinesis"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/guygma/goKCL/record"
+	"github.com/guygma/goKCL/util"
+)
+
+func TestMillisBehindLatestDelivered(t *testing.T) {
+	kc := &backloggedKinesis{mockKinesisClient: newMockKinesisClient(6, true)}
+	processor := &lagRecordingProcessor{}
+	sc := newTestConsumer(kc, newMockShardCheckpointer(), processor, testConfig().WithMaxRecords(2))
+
+	// reading the backlog from TRIM_HORIZON, until caught up
+	assert.Nil(t, sc.GetRecords(testShard()))
+	assert.Equal(t, []int64{4 * 60000, 2 * 60000, 0}, processor.lags())
+}
+
+func TestMillisBehindLatestDeliveredWithEnhancedFanOut(t *testing.T) {
+	backlog, caughtUp := subscriptionEvent("2", "1", "2"), subscriptionEvent("", "3")
+	backlog.MillisBehindLatest = aws.Int64(3600000)
+	kc := &subscribingKinesis{
+		mockKinesisClient: newMockKinesisClient(0, true),
+		subscriptions:     [][]*kinesis.SubscribeToShardEvent{{backlog, caughtUp}},
+	}
+	processor := &lagRecordingProcessor{}
+	sc := newTestConsumer(kc, newMockShardCheckpointer(), processor, testConfig())
+	sc.consumerARN = "arn:consumer"
+
+	assert.Nil(t, sc.GetRecords(testShard()))
+	assert.Equal(t, []int64{3600000, 0}, processor.lags())
+}
+
+// backloggedKinesis reports the shard a minute behind the tip of the stream for every record left to read.
+type backloggedKinesis struct {
+	*mockKinesisClient
+}
+
+func (m *backloggedKinesis) GetRecords(input *kinesis.GetRecordsInput) (*kinesis.GetRecordsOutput, error) {
+	output, err := m.mockKinesisClient.GetRecords(input)
+	if err != nil {
+		return nil, err
+	}
+	read := 0
+	if len(output.Records) > 0 {
+		last := output.Records[len(output.Records)-1]
+		for i, r := range m.records {
+			if r == last {
+				read = i + 1
+			}
+		}
+	}
+	output.MillisBehindLatest = aws.Int64(int64(len(m.records)-read) * 60000)
+	return output, nil
+}
+
+// lagRecordingProcessor records the MillisBehindLatest of every batch of record delivered.
+type lagRecordingProcessor struct {
+	mux          sync.Mutex
+	behindLatest []int64
+}
+
+func (p *lagRecordingProcessor) Initialize(input *InitializationInput) {}
+
+func (p *lagRecordingProcessor) ProcessRecords(input *record.ProcessRecordsInput) {
+	if len(input.Records) == 0 {
+		return
+	}
+	p.mux.Lock()
+	defer p.mux.Unlock()
+	p.behindLatest = append(p.behindLatest, input.MillisBehindLatest)
+}
+
+func (p *lagRecordingProcessor) Shutdown(input *util.ShutdownInput) {
+	if input.ShutdownReason == util.TERMINATE {
+		input.Checkpointer.Checkpoint(nil)
+	}
+}
+
+func (p *lagRecordingProcessor) lags() []int64 {
+	p.mux.Lock()
+	defer p.mux.Unlock()
+	return append([]int64(nil), p.behindLatest...)
+}
@@ -0,0 +1,38 @@
+package shard
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLeaseKeyPrefix(t *testing.T) {
+	svc := &lagLeaseTable{items: make(map[string]map[string]*dynamodb.AttributeValue)}
+	checkpointer := NewDynamoCheckpoint(testConfig()).WithDynamoDB(svc)
+	orders := checkpointer.WithLeaseKeyPrefix("123456789012:orders:")
+	payments := checkpointer.WithLeaseKeyPrefix("123456789012:payments:")
+
+	// the same shard of two streams is leased and checkpointed under two lease keys
+	ordersShard := &Status{ID: "shardId-0", Mux: &sync.Mutex{}}
+	assert.Nil(t, orders.GetLease(ordersShard, "abc"))
+	ordersShard.Checkpoint = "5"
+	assert.Nil(t, orders.CheckpointSequence(ordersShard))
+	paymentsShard := &Status{ID: "shardId-0", Mux: &sync.Mutex{}}
+	assert.Nil(t, payments.GetLease(paymentsShard, "abc"))
+	assert.Contains(t, svc.items, "123456789012:orders:shardId-0")
+	assert.Contains(t, svc.items, "123456789012:payments:shardId-0")
+
+	fetched := &Status{ID: "shardId-0", Mux: &sync.Mutex{}}
+	assert.Nil(t, orders.FetchCheckpoint(fetched))
+	assert.Equal(t, "5", fetched.Checkpoint)
+	assert.Equal(t, ErrSequenceIDNotFound, payments.FetchCheckpoint(fetched))
+
+	// each lists the leases of its stream only, by shard ID
+	leases, err := orders.(LeaseLister).GetLeases()
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(leases))
+	assert.Equal(t, "shardId-0", leases[0].ShardID)
+	assert.Equal(t, "5", leases[0].Checkpoint)
+}
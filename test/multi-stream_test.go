@@ -0,0 +1,132 @@
+package goKCL
+
+import (
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/service/kinesis"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/guygma/goKCL/record"
+	"github.com/guygma/goKCL/shard"
+)
+
+const (
+	ordersStreamARN   = "arn:aws:kinesis:us-west-2:123456789012:stream/orders"
+	paymentsStreamARN = "arn:aws:kinesis:us-west-2:123456789012:stream/payments"
+)
+
+func TestMultiStreamWorker(t *testing.T) {
+	kc := &mockKinesis{shards: []*kinesis.Shard{
+		mockShard("shardId-0", "0", "170141183460469231731687303715884105727"),
+		mockShard("shardId-1", "170141183460469231731687303715884105728", "340282366920938463463374607431768211455"),
+	}}
+	store := &namespacingLeaseStore{memoryLeaseStore: newMemoryLeaseStore(10 * time.Second)}
+	factory := &streamRecordingFactory{}
+	kclConfig := NewKinesisClientLibConfig("appName", "test", "us-west-2", "worker").
+		WithFailoverTimeMillis(10000).
+		WithShardSyncIntervalMillis(60000).
+		WithMaxLeasesPerAcquisition(2).
+		WithIdleTimeBetweenReadsInMillis(10).
+		WithMultiStreamConfig(MultiStreamConfig{StreamARNs: []string{ordersStreamARN, paymentsStreamARN}})
+	worker := NewWorker(factory, kclConfig, nil).WithKinesis(kc).WithCheckpointer(store)
+
+	assert.Nil(t, worker.Start())
+	defer worker.Shutdown()
+
+	// the leases of each stream are kept apart in the lease table
+	orders := store.namespace("123456789012:orders:")
+	payments := store.namespace("123456789012:payments:")
+	assert.True(t, orders.waitForOwner("worker", time.Second, "shardId-0", "shardId-1"))
+	assert.True(t, payments.waitForOwner("worker", time.Second, "shardId-0", "shardId-1"))
+
+	// every record processor knows the stream it consumes
+	assert.Equal(t, []string{ordersStreamARN, ordersStreamARN, paymentsStreamARN, paymentsStreamARN}, factory.streams())
+
+	assert.Equal(t, "orders", worker.GetStreamWorker(ordersStreamARN).streamName)
+	assert.Equal(t, "payments", worker.GetStreamWorker(paymentsStreamARN).streamName)
+	assert.Nil(t, worker.GetStreamWorker("arn:aws:kinesis:us-west-2:123456789012:stream/refunds"))
+}
+
+func TestSingleStreamARN(t *testing.T) {
+	kclConfig := NewKinesisClientLibConfig("appName", "test", "us-west-2", "worker").
+		WithMultiStreamConfig(MultiStreamConfig{StreamARNs: []string{ordersStreamARN}})
+	assert.Equal(t, "orders", kclConfig.StreamName)
+
+	// a single stream is consumed by the worker itself, its leases aren't namespaced
+	worker := NewWorker(&streamRecordingFactory{}, kclConfig, nil)
+	assert.False(t, worker.multiStream())
+	assert.Equal(t, worker, worker.GetStreamWorker(ordersStreamARN))
+	assert.Equal(t, ordersStreamARN, worker.createProcessor().(*streamRecordingProcessor).stream)
+}
+
+func TestMultiStreamConfigValidation(t *testing.T) {
+	withStreams := func(streamARNs ...string) func() {
+		return func() {
+			NewKinesisClientLibConfig("appName", "test", "us-west-2", "worker").
+				WithMultiStreamConfig(MultiStreamConfig{StreamARNs: streamARNs})
+		}
+	}
+
+	assert.NotPanics(t, withStreams(ordersStreamARN, paymentsStreamARN))
+	assert.Panics(t, withStreams())
+	assert.Panics(t, withStreams("orders"))
+	assert.Panics(t, withStreams("arn:aws:sqs:us-west-2:123456789012:orders"))
+	assert.Panics(t, withStreams("arn:aws:kinesis:us-east-1:123456789012:stream/orders"))
+	assert.Panics(t, withStreams(ordersStreamARN, ordersStreamARN))
+}
+
+// namespacingLeaseStore keeps the leases of every lease key prefix in a memoryLeaseStore of its own.
+type namespacingLeaseStore struct {
+	*memoryLeaseStore
+	namespaceMux sync.Mutex
+	namespaces   map[string]*memoryLeaseStore
+}
+
+func (m *namespacingLeaseStore) WithLeaseKeyPrefix(prefix string) shard.Checkpointer {
+	return m.namespace(prefix)
+}
+
+func (m *namespacingLeaseStore) namespace(prefix string) *memoryLeaseStore {
+	m.namespaceMux.Lock()
+	defer m.namespaceMux.Unlock()
+	if m.namespaces == nil {
+		m.namespaces = make(map[string]*memoryLeaseStore)
+	}
+	if _, ok := m.namespaces[prefix]; !ok {
+		m.namespaces[prefix] = newMemoryLeaseStore(m.leaseDuration)
+	}
+	return m.namespaces[prefix]
+}
+
+// streamRecordingFactory creates record processors recording the stream they were created for.
+type streamRecordingFactory struct {
+	mux     sync.Mutex
+	created []string
+}
+
+func (f *streamRecordingFactory) CreateProcessor() record.IRecordProcessor {
+	return f.CreateProcessorForStream("")
+}
+
+func (f *streamRecordingFactory) CreateProcessorForStream(streamARN string) record.IRecordProcessor {
+	f.mux.Lock()
+	defer f.mux.Unlock()
+	f.created = append(f.created, streamARN)
+	return &streamRecordingProcessor{stream: streamARN}
+}
+
+func (f *streamRecordingFactory) streams() []string {
+	f.mux.Lock()
+	defer f.mux.Unlock()
+	streams := append([]string(nil), f.created...)
+	sort.Strings(streams)
+	return streams
+}
+
+type streamRecordingProcessor struct {
+	noopRecordProcessor
+	stream string
+}
@@ -0,0 +1,64 @@
+package util
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestOpenMetricsHandler(t *testing.T) {
+	config := &MonitoringConfiguration{MonitoringService: "openmetrics"}
+	assert.Nil(t, config.Init("appName", "test", "abc"))
+	svc := config.GetMonitoringService()
+
+	svc.IncrRecordsProcessed("0001", 3)
+	svc.IncrRecordsProcessed("0001", 2)
+	svc.IncrBytesProcessed("0001", 128)
+	svc.MillisBehindLatest("0001", 1500)
+	svc.LeaseGained("0001")
+	svc.RecordGetRecordsTime("0001", 20)
+	svc.RecordGetRecordsTime("0001", 30)
+	svc.RecordAge("0001", 50)
+	svc.RecordAge("0001", 5000)
+	svc.LeaseGained("0002")
+
+	recorder := httptest.NewRecorder()
+	svc.(http.Handler).ServeHTTP(recorder, httptest.NewRequest("GET", "/metrics", nil))
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	assert.Contains(t, recorder.Header().Get("Content-Type"), "version=0.0.4")
+
+	labels := `{application="appName",stream="test",worker="abc",shard="0001"}`
+	body := recorder.Body.String()
+	for _, line := range []string{
+		"# TYPE kcl_records_processed_total counter",
+		"kcl_records_processed_total" + labels + " 5",
+		"kcl_bytes_processed_total" + labels + " 128",
+		"# TYPE kcl_millis_behind_latest gauge",
+		"kcl_millis_behind_latest" + labels + " 1500",
+		"kcl_leases_held" + labels + " 1",
+		`kcl_leases_held{application="appName",stream="test",worker="abc",shard="0002"} 1`,
+		"kcl_get_records_time_milliseconds_sum" + labels + " 50",
+		"kcl_get_records_time_milliseconds_count" + labels + " 2",
+		"# TYPE kcl_record_age_milliseconds histogram",
+		`kcl_record_age_milliseconds_bucket{application="appName",stream="test",worker="abc",shard="0001",le="10"} 0`,
+		`kcl_record_age_milliseconds_bucket{application="appName",stream="test",worker="abc",shard="0001",le="100"} 1`,
+		`kcl_record_age_milliseconds_bucket{application="appName",stream="test",worker="abc",shard="0001",le="10000"} 2`,
+		`kcl_record_age_milliseconds_bucket{application="appName",stream="test",worker="abc",shard="0001",le="+Inf"} 2`,
+		"kcl_record_age_milliseconds_count" + labels + " 2",
+	} {
+		assert.Contains(t, body, line+"\n")
+	}
+}
+
+func TestOpenMetricsSummaryLevel(t *testing.T) {
+	config := &MonitoringConfiguration{MonitoringService: "openmetrics", MetricsLevel: METRICS_SUMMARY}
+	assert.Nil(t, config.Init("appName", "test", "abc"))
+	svc := config.GetMonitoringService()
+	svc.RecordAge("0001", 50)
+
+	recorder := httptest.NewRecorder()
+	config.OpenMetrics.ServeHTTP(recorder, httptest.NewRequest("GET", "/metrics", nil))
+	assert.NotContains(t, recorder.Body.String(), "kcl_record_age_milliseconds")
+}
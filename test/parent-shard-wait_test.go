@@ -0,0 +1,42 @@
+package shard
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWaitOnParentShardWithoutLease(t *testing.T) {
+	checkpointer := newMockShardCheckpointer()
+	checkpointer.checkpoints["0002"] = SHARD_END
+	kclConfig := testConfig()
+	kclConfig.ParentShardPollIntervalMillis = 1
+	sc := newTestConsumer(newMockKinesisClient(0, true), checkpointer, &mockRecordProcessor{}, kclConfig)
+	child := &Status{ID: "0003", Mux: &sync.Mutex{}, ParentShardId: "0001", AdjacentParentShardId: "0002"}
+
+	// the parent 0001 has no lease and isn't listed anymore, as when the lease of the child was acquired
+	sc.parentListed = func(shardID string) bool { return false }
+	assert.Nil(t, sc.waitOnParentShard(child))
+
+	// while it is still listed, its lease is waited for
+	sc.parentListed = func(shardID string) bool { return true }
+	done := make(chan error)
+	go func() { done <- sc.waitOnParentShard(child) }()
+
+	select {
+	case <-done:
+		t.Fatal("parent shard without lease not waited for while listed")
+	case <-time.After(50 * time.Millisecond):
+	}
+	checkpointer.mux.Lock()
+	checkpointer.checkpoints["0001"] = SHARD_END
+	checkpointer.mux.Unlock()
+	select {
+	case err := <-done:
+		assert.Nil(t, err)
+	case <-time.After(time.Second):
+		t.Fatal("parent shard processed to its end still waited for")
+	}
+}
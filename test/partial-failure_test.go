@@ -0,0 +1,74 @@
+package shard
+
+import (
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/kinesis"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/guygma/goKCL/record"
+)
+
+func TestPartialFailureFirstRecord(t *testing.T) {
+	kc := newMockKinesisClient(3, true)
+	checkpointer := newMockShardCheckpointer()
+	processor := &partialFailureProcessor{failures: map[string]int{"1": 1}}
+	sc := newTestConsumer(kc, checkpointer, processor, testConfig().
+		WithMaxRecords(3).
+		WithTaskBackoffTimeMillis(1))
+
+	assert.Nil(t, sc.GetRecords(testShard()))
+
+	// the checkpoint doesn't advance, the whole batch is delivered again from its first record
+	assert.Equal(t, [][]string{{"1", "2", "3"}, {"1", "2", "3"}}, processor.batches)
+	assert.Equal(t, []string{SHARD_END}, checkpointer.history)
+	last := kc.iteratorRequests[len(kc.iteratorRequests)-1]
+	assert.Equal(t, kinesis.ShardIteratorTypeAtSequenceNumber, aws.StringValue(last.ShardIteratorType))
+	assert.Equal(t, "1", aws.StringValue(last.StartingSequenceNumber))
+}
+
+func TestPartialFailureMidBatch(t *testing.T) {
+	kc := newMockKinesisClient(5, true)
+	checkpointer := newMockShardCheckpointer()
+	processor := &partialFailureProcessor{failures: map[string]int{"3": 2}}
+	sc := newTestConsumer(kc, checkpointer, processor, testConfig().
+		WithMaxRecords(5).
+		WithTaskBackoffTimeMillis(1))
+
+	assert.Nil(t, sc.GetRecords(testShard()))
+
+	// checkpointed at the last successful record, the remainder is delivered until it succeeds
+	assert.Equal(t, [][]string{{"1", "2", "3", "4", "5"}, {"3", "4", "5"}, {"3", "4", "5"}}, processor.batches)
+	assert.Equal(t, []string{"2", SHARD_END}, checkpointer.history)
+}
+
+// partialFailureProcessor fails the batches at the given record the given number of times, the record before it
+// being processed.
+type partialFailureProcessor struct {
+	mockRecordProcessor
+	failures map[string]int
+	batches  [][]string
+}
+
+func (p *partialFailureProcessor) ProcessRecordsWithResult(input *record.ProcessRecordsInput) record.ProcessRecordsResult {
+	if len(input.Records) == 0 {
+		return record.ProcessRecordsResult{}
+	}
+
+	var seqs []string
+	for _, r := range input.Records {
+		seqs = append(seqs, aws.StringValue(r.SequenceNumber))
+	}
+	p.batches = append(p.batches, seqs)
+
+	var lastSuccessful *kinesis.Record
+	for _, r := range input.Records {
+		if p.failures[aws.StringValue(r.SequenceNumber)] > 0 {
+			p.failures[aws.StringValue(r.SequenceNumber)]--
+			return input.PartialFailure(lastSuccessful)
+		}
+		lastSuccessful = r
+	}
+	return record.ProcessRecordsResult{}
+}
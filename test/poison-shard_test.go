@@ -0,0 +1,82 @@
+package shard
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/guygma/goKCL"
+	"github.com/guygma/goKCL/util"
+)
+
+func TestPoisonShardAlert(t *testing.T) {
+	svc := &lagLeaseTable{items: make(map[string]map[string]*dynamodb.AttributeValue)}
+	listener := &mockEventListener{}
+	checkpointer := NewDynamoCheckpoint(testConfig().
+		WithPoisonShardDetection(3, goKCL.ALERT_POISON_SHARD).
+		WithEventListener(listener)).WithDynamoDB(svc)
+	sh := &Status{ID: "0001", Mux: &sync.Mutex{}}
+
+	// a new lease isn't an owner switch, nor is a renewal
+	assert.Nil(t, checkpointer.GetLease(sh, "worker-1"))
+	assert.Nil(t, checkpointer.GetLease(sh, "worker-1"))
+	assert.Equal(t, 0, ownerSwitchesOf(svc, "0001"))
+
+	// the alert fires once the lease changed owner 3 times without checkpoint
+	for i, owner := range []string{"worker-2", "worker-1", "worker-2"} {
+		assert.Empty(t, listener.received())
+		expireLease(svc, "0001", time.Now().Add(-time.Second))
+		assert.Nil(t, checkpointer.GetLease(sh, owner))
+		assert.Equal(t, i+1, ownerSwitchesOf(svc, "0001"))
+	}
+	events := listener.received()
+	assert.Equal(t, 1, len(events))
+	assert.Equal(t, util.EVENT_POISON_SHARD, events[0].Type)
+	assert.Equal(t, "0001", events[0].ShardID)
+
+	// a checkpoint resets the owner switches
+	sh.Checkpoint = "5"
+	assert.Nil(t, checkpointer.CheckpointSequence(sh))
+	assert.Equal(t, 0, ownerSwitchesOf(svc, "0001"))
+	expireLease(svc, "0001", time.Now().Add(-time.Second))
+	assert.Nil(t, checkpointer.GetLease(sh, "worker-1"))
+	assert.Equal(t, 1, ownerSwitchesOf(svc, "0001"))
+	assert.Equal(t, 1, len(listener.received()))
+}
+
+func TestPoisonShardDelayedTakeover(t *testing.T) {
+	svc := &lagLeaseTable{items: make(map[string]map[string]*dynamodb.AttributeValue)}
+	checkpointer := NewDynamoCheckpoint(testConfig().
+		WithFailoverTimeMillis(10000).
+		WithPoisonShardDetection(2, goKCL.DELAY_POISON_SHARD_TAKEOVER)).WithDynamoDB(svc)
+	sh := &Status{ID: "0001", Mux: &sync.Mutex{}}
+
+	// below the threshold the expired lease is taken over right away
+	peerLease(svc, "0001", time.Now().Add(-time.Second))
+	svc.items["0001"][OWNER_SWITCHES_KEY] = &dynamodb.AttributeValue{N: aws.String("1")}
+	assert.Nil(t, checkpointer.GetLease(sh, "worker"))
+
+	// at the threshold the lease must have been expired for another lease duration
+	peerLease(svc, "0001", time.Now().Add(-time.Second))
+	svc.items["0001"][OWNER_SWITCHES_KEY] = &dynamodb.AttributeValue{N: aws.String("2")}
+	err := checkpointer.GetLease(sh, "worker")
+	assert.NotNil(t, err)
+	assert.Equal(t, ErrLeaseNotAquired, err.Error())
+
+	expireLease(svc, "0001", time.Now().Add(-15*time.Second))
+	assert.Nil(t, checkpointer.GetLease(sh, "worker"))
+	assert.Equal(t, 3, ownerSwitchesOf(svc, "0001"))
+}
+
+// expireLease sets the lease timeout of the shard, keeping the rest of its lease.
+func expireLease(svc *lagLeaseTable, shardID string, leaseTimeout time.Time) {
+	svc.items[shardID][LEASE_TIMEOUT_KEY] = &dynamodb.AttributeValue{S: aws.String(leaseTimeout.UTC().Format(time.RFC3339))}
+}
+
+func ownerSwitchesOf(svc *lagLeaseTable, shardID string) int {
+	return (&DynamoCheckpoint{attributes: DefaultLeaseAttributeNames()}).ownerSwitches(svc.items[shardID])
+}
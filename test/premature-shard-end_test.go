@@ -0,0 +1,68 @@
+package shard
+
+import (
+	"errors"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/guygma/goKCL/record"
+	"github.com/guygma/goKCL/util"
+)
+
+func TestPrematureShardEndRejected(t *testing.T) {
+	checkpointer := newMockShardCheckpointer()
+	processor := &shardEndProcessor{}
+	sc := newTestConsumer(newMockKinesisClient(3, true), checkpointer, processor, testConfig().WithMaxRecords(1))
+
+	shard := testShard()
+	assert.Nil(t, sc.GetRecords(shard))
+
+	// SHARD_END is rejected while record of the shard remain
+	assert.Equal(t, 3, len(processor.errs))
+	for _, err := range processor.errs {
+		assert.True(t, errors.Is(err, util.InvalidStateError.MakeErr()))
+		assert.Contains(t, err.(*util.ClientLibraryError).Detail, "shard 0001")
+	}
+
+	// and accepted once the end of the shard is reached
+	assert.True(t, shard.ShardEndReached())
+	assert.Equal(t, []string{SHARD_END}, checkpointer.history)
+}
+
+func TestPrematureShardEndAllowed(t *testing.T) {
+	checkpointer := newMockShardCheckpointer()
+	processor := &shardEndProcessor{}
+	sc := newTestConsumer(newMockKinesisClient(3, true), checkpointer, processor, testConfig().
+		WithMaxRecords(1).
+		WithPrematureShardEnd(true))
+
+	assert.Nil(t, sc.GetRecords(testShard()))
+	assert.Equal(t, []error{nil, nil, nil}, processor.errs)
+	assert.Equal(t, SHARD_END, checkpointer.history[0])
+}
+
+// shardEndProcessor checkpoints SHARD_END after every batch, recording the errors, and at the end of the shard.
+type shardEndProcessor struct {
+	mux  sync.Mutex
+	errs []error
+}
+
+func (p *shardEndProcessor) Initialize(input *InitializationInput) {}
+
+func (p *shardEndProcessor) ProcessRecords(input *record.ProcessRecordsInput) {
+	if len(input.Records) == 0 {
+		return
+	}
+	err := input.Checkpointer.Checkpoint(nil)
+	p.mux.Lock()
+	defer p.mux.Unlock()
+	p.errs = append(p.errs, err)
+}
+
+func (p *shardEndProcessor) Shutdown(input *util.ShutdownInput) {
+	if input.ShutdownReason == util.TERMINATE {
+		input.Checkpointer.Checkpoint(nil)
+	}
+}
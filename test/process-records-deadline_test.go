@@ -0,0 +1,92 @@
+package shard
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/guygma/goKCL/record"
+)
+
+func TestProcessRecordsDeadline(t *testing.T) {
+	kc := newMockKinesisClient(4, true)
+	processor := &deadlineRecordingProcessor{}
+	sc := newTestConsumer(kc, newMockShardCheckpointer(), processor, testConfig().
+		WithMaxRecords(2).
+		WithProcessRecordsTimeoutMillis(5000))
+
+	start := time.Now()
+	assert.Nil(t, sc.GetRecords(testShard()))
+	end := time.Now()
+
+	// every batch is delivered with a deadline 5s after its delivery
+	deadlines := processor.recorded()
+	assert.Equal(t, 2, len(deadlines))
+	for _, deadline := range deadlines {
+		assert.False(t, deadline.Before(start.Add(5*time.Second)))
+		assert.False(t, deadline.After(end.Add(5*time.Second)))
+	}
+}
+
+func TestProcessRecordsWithoutDeadline(t *testing.T) {
+	kc := newMockKinesisClient(2, true)
+	processor := &deadlineRecordingProcessor{}
+	sc := newTestConsumer(kc, newMockShardCheckpointer(), processor, testConfig())
+
+	assert.Nil(t, sc.GetRecords(testShard()))
+
+	assert.Equal(t, 1, len(processor.recorded()))
+	assert.True(t, processor.recorded()[0].IsZero())
+}
+
+func TestPartialFailureProcessRecordsDeadline(t *testing.T) {
+	kc := newMockKinesisClient(2, true)
+	processor := &partialDeadlineRecordingProcessor{}
+	sc := newTestConsumer(kc, newMockShardCheckpointer(), processor, testConfig().
+		WithProcessRecordsTimeoutMillis(5000))
+
+	start := time.Now()
+	assert.Nil(t, sc.GetRecords(testShard()))
+
+	// the partial failure processor gets the deadline too
+	deadlines := processor.recorded()
+	assert.Equal(t, 1, len(deadlines))
+	assert.False(t, deadlines[0].Before(start.Add(5*time.Second)))
+}
+
+// deadlineRecordingProcessor records the deadline of the context of every batch, zero if there is none.
+type deadlineRecordingProcessor struct {
+	mockRecordProcessor
+	deadlineMux sync.Mutex
+	deadlines   []time.Time
+}
+
+func (m *deadlineRecordingProcessor) ProcessRecordsWithContext(ctx context.Context, input *record.ProcessRecordsInput) {
+	if len(input.Records) > 0 {
+		deadline, _ := ctx.Deadline()
+		m.deadlineMux.Lock()
+		m.deadlines = append(m.deadlines, deadline)
+		m.deadlineMux.Unlock()
+	}
+	m.mockRecordProcessor.ProcessRecords(input)
+}
+
+func (m *deadlineRecordingProcessor) recorded() []time.Time {
+	m.deadlineMux.Lock()
+	defer m.deadlineMux.Unlock()
+	return append([]time.Time(nil), m.deadlines...)
+}
+
+// partialDeadlineRecordingProcessor records the deadline of the context of every batch delivered with its result.
+type partialDeadlineRecordingProcessor struct {
+	deadlineRecordingProcessor
+}
+
+func (m *partialDeadlineRecordingProcessor) ProcessRecordsWithResult(
+	input *record.ProcessRecordsInput) record.ProcessRecordsResult {
+	m.deadlineRecordingProcessor.ProcessRecordsWithContext(input.Context, input)
+	return record.ProcessRecordsResult{}
+}
@@ -0,0 +1,125 @@
+package shard
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/guygma/goKCL"
+	"github.com/guygma/goKCL/record"
+	"github.com/guygma/goKCL/util"
+)
+
+func TestProcessingFailureRetryable(t *testing.T) {
+	kc := newMockKinesisClient(3, true)
+	checkpointer := newMockShardCheckpointer()
+	handler := &mockDeadLetterHandler{}
+	processor := &classifyingProcessor{failures: map[string]int{"1": 2},
+		err: util.KinesisClientLibRetryableError.MakeErr().WithCause(errors.New("database unavailable"))}
+	sc := newTestConsumer(kc, checkpointer, processor, processingFailureConfig(3, handler))
+
+	assert.Nil(t, sc.GetRecords(testShard()))
+
+	// the batch is backed off and delivered again until it succeeds
+	assert.Equal(t, [][]string{{"1", "2", "3"}, {"1", "2", "3"}, {"1", "2", "3"}}, processor.batches)
+	assert.Empty(t, handler.inputs)
+	assert.Equal(t, []string{"3", SHARD_END}, checkpointer.history)
+}
+
+func TestProcessingFailureRetriesExhausted(t *testing.T) {
+	kc := newMockKinesisClient(3, true)
+	checkpointer := newMockShardCheckpointer()
+	handler := &mockDeadLetterHandler{}
+	processor := &classifyingProcessor{failures: map[string]int{"1": 10},
+		err: util.KinesisClientLibRetryableError.MakeErr().WithCause(errors.New("database unavailable"))}
+	sc := newTestConsumer(kc, checkpointer, processor, processingFailureConfig(2, handler))
+
+	assert.Nil(t, sc.GetRecords(testShard()))
+
+	// once the attempts are exhausted, the batch is dead-lettered like a non-retryable failure
+	assert.Equal(t, [][]string{{"1", "2", "3"}, {"1", "2", "3"}}, processor.batches)
+	assert.Equal(t, 3, len(handler.inputs))
+	assert.Equal(t, 2, handler.inputs[0].Attempts)
+	assert.Equal(t, []string{"3", SHARD_END}, checkpointer.history)
+}
+
+func TestProcessingFailureNonRetryable(t *testing.T) {
+	kc := newMockKinesisClient(5, true)
+	checkpointer := newMockShardCheckpointer()
+	handler := &mockDeadLetterHandler{}
+	processor := &classifyingProcessor{failures: map[string]int{"3": 1},
+		err: util.KinesisClientLibNonRetryableException.MakeErr().WithCause(errors.New("poison record"))}
+	sc := newTestConsumer(kc, checkpointer, processor, processingFailureConfig(3, handler).WithMaxRecords(2))
+
+	assert.Nil(t, sc.GetRecords(testShard()))
+
+	// the failed batch is dead-lettered right away and skipped
+	assert.Equal(t, [][]string{{"1", "2"}, {"3", "4"}, {"5"}}, processor.batches)
+	var deadLettered []string
+	for _, input := range handler.inputs {
+		deadLettered = append(deadLettered, input.SequenceNumber)
+		assert.Equal(t, 1, input.Attempts)
+		assert.True(t, errors.Is(input.ClientLibraryError, util.KinesisClientLibNonRetryableException.MakeErr()))
+	}
+	assert.Equal(t, []string{"3", "4"}, deadLettered)
+	assert.Equal(t, []string{"2", "4", "5", SHARD_END}, checkpointer.history)
+}
+
+func TestProcessingFailureStop(t *testing.T) {
+	kc := newMockKinesisClient(3, true)
+	checkpointer := newMockShardCheckpointer()
+	processor := &classifyingProcessor{failures: map[string]int{"1": 1}, err: errors.New("poison record")}
+	sc := newTestConsumer(kc, checkpointer, processor, processingFailureConfig(3, nil).
+		WithProcessingFailurePolicy(record.STOP))
+
+	// an error which isn't a ClientLibraryError isn't retryable
+	err := sc.GetRecords(testShard())
+	assert.True(t, errors.Is(err, util.KinesisClientLibNonRetryableException.MakeErr()))
+	assert.Equal(t, 1, len(processor.batches))
+	assert.Empty(t, checkpointer.history)
+	// the record processor is shut down before the consumer gives up the shard
+	assert.Equal(t, []util.ShutdownReason{util.REQUESTED}, processor.shutdownReasons)
+}
+
+func TestProcessingFailurePolicyValidation(t *testing.T) {
+	assert.Panics(t, func() { testConfig().WithProcessingFailurePolicy(0) })
+	assert.Equal(t, record.SKIP, testConfig().WithProcessingFailurePolicy(record.SKIP).ProcessingFailurePolicy)
+}
+
+func processingFailureConfig(attempts int, handler record.IDeadLetterHandler) *goKCL.KinesisClientLibConfiguration {
+	return testConfig().
+		WithMaxRecords(3).
+		WithRetryBackoff(1, 1, attempts).
+		WithProcessingFailurePolicy(record.DEAD_LETTER).
+		WithDeadLetterHandler(handler)
+}
+
+// classifyingProcessor fails the batches starting at the given record with err the given number of times, and
+// checkpoints the others.
+type classifyingProcessor struct {
+	mockRecordProcessor
+	failures map[string]int
+	err      error
+	batches  [][]string
+}
+
+func (p *classifyingProcessor) ProcessRecords(input *record.ProcessRecordsInput) {
+	if len(input.Records) == 0 {
+		return
+	}
+
+	var seqs []string
+	for _, r := range input.Records {
+		seqs = append(seqs, aws.StringValue(r.SequenceNumber))
+	}
+	p.batches = append(p.batches, seqs)
+
+	if p.failures[seqs[0]] > 0 {
+		p.failures[seqs[0]]--
+		input.Fail(p.err)
+		return
+	}
+	input.Checkpointer.Checkpoint(input.Records[len(input.Records)-1].SequenceNumber)
+}
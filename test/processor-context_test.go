@@ -0,0 +1,77 @@
+package shard
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/guygma/goKCL/record"
+	"github.com/guygma/goKCL/util"
+)
+
+func TestProcessorContextCanceledOnShutdown(t *testing.T) {
+	kc := newMockKinesisClient(3, false)
+	processor := &contextProcessor{blocked: make(chan struct{})}
+	sc := newTestConsumer(kc, newMockShardCheckpointer(), processor, testConfig())
+
+	done := make(chan error)
+	go func() { done <- sc.GetRecords(testShard()) }()
+
+	// the long call of the processor is aborted once the worker shuts down
+	select {
+	case <-processor.blocked:
+	case <-time.After(time.Second):
+		t.Fatal("no batch delivered")
+	}
+	close(*sc.stop)
+	assert.Nil(t, <-done)
+
+	assert.NotNil(t, processor.initialize)
+	assert.Equal(t, context.Canceled, processor.initialize.Err())
+	assert.Equal(t, context.Canceled, processor.process.Err())
+	assert.Equal(t, context.Canceled, processor.shutdown.Err())
+}
+
+func TestProcessorContextAtShardEnd(t *testing.T) {
+	kc := newMockKinesisClient(3, true)
+	processor := &contextProcessor{}
+	sc := newTestConsumer(kc, newMockShardCheckpointer(), processor, testConfig())
+
+	assert.Nil(t, sc.GetRecords(testShard()))
+
+	// the shard ended, the processor still has a live context to checkpoint it
+	assert.Nil(t, processor.shutdown.Err())
+	assert.Equal(t, []util.ShutdownReason{util.TERMINATE}, processor.shutdownReasons)
+}
+
+// contextProcessor keeps the context of its callbacks. With blocked set, it blocks processing the first batch until
+// its context is done.
+type contextProcessor struct {
+	mockRecordProcessor
+	blocked    chan struct{}
+	initialize context.Context
+	process    context.Context
+	shutdown   context.Context
+}
+
+func (p *contextProcessor) Initialize(input *InitializationInput) {
+	p.initialize = input.Context
+}
+
+func (p *contextProcessor) ProcessRecords(input *record.ProcessRecordsInput) {
+	if len(input.Records) == 0 || p.process != nil {
+		return
+	}
+	p.process = input.Context
+	if p.blocked != nil {
+		close(p.blocked)
+		<-input.Context.Done()
+	}
+}
+
+func (p *contextProcessor) Shutdown(input *util.ShutdownInput) {
+	p.shutdown = input.Context
+	p.mockRecordProcessor.Shutdown(input)
+}
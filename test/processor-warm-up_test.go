@@ -0,0 +1,43 @@
+package shard
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/guygma/goKCL/util"
+)
+
+func TestProcessRecordsTimeout(t *testing.T) {
+	checkpointer := newMockShardCheckpointer()
+	checkpointer.owners["0001"] = "abc"
+	processor := &mockRecordProcessor{delay: 20 * time.Millisecond}
+	sc := newTestConsumer(newMockKinesisClient(4, true), checkpointer, processor, testConfig().
+		WithMaxRecords(2).
+		WithProcessRecordsTimeoutMillis(5))
+
+	err := sc.GetRecords(testShard())
+	assert.NotNil(t, err)
+	assert.Equal(t, util.KinesisClientLibError, err.(*util.ClientLibraryError).ErrorCode)
+	assert.Equal(t, []string{"1", "2"}, processor.sequenceNumbers())
+	assert.Equal(t, []util.ShutdownReason{util.REQUESTED}, processor.shutdownReasons)
+
+	// the lease of the shard has been released
+	_, ok := checkpointer.owners["0001"]
+	assert.False(t, ok)
+}
+
+func TestProcessRecordsTimeoutNotEnforcedDuringWarmUp(t *testing.T) {
+	checkpointer := newMockShardCheckpointer()
+	processor := &mockRecordProcessor{delay: 20 * time.Millisecond}
+	sc := newTestConsumer(newMockKinesisClient(4, true), checkpointer, processor, testConfig().
+		WithMaxRecords(2).
+		WithProcessRecordsTimeoutMillis(5).
+		WithProcessorWarmUpMillis(60000))
+
+	err := sc.GetRecords(testShard())
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"1", "2", "3", "4"}, processor.sequenceNumbers())
+	assert.Equal(t, SHARD_END, checkpointer.checkpoints["0001"])
+}
@@ -0,0 +1,90 @@
+package record
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/kinesis"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/guygma/goKCL/shard"
+)
+
+func TestShardConsumerAckWithinAggregatedRecord(t *testing.T) {
+	checkpointer := &subSequenceCheckpointer{}
+	c := NewShardConsumer(3)
+	c.Initialize(&shard.InitializationInput{ShardId: "0001"})
+
+	// three user record deaggregated from the KPL record 10
+	records := []*kinesis.Record{
+		{SequenceNumber: aws.String("10")}, {SequenceNumber: aws.String("10")}, {SequenceNumber: aws.String("10")},
+	}
+	c.ProcessRecords(&ProcessRecordsInput{
+		Records:            records,
+		SubSequenceNumbers: map[*kinesis.Record]int64{records[0]: 1, records[1]: 2, records[2]: 3},
+		Checkpointer:       checkpointer,
+	})
+
+	// the Ack of the first user record only covers it, not the whole aggregated record
+	_, ack, err := c.Next(context.Background())
+	assert.Nil(t, err)
+	assert.Nil(t, ack())
+	assert.Equal(t, []string{"10/1"}, checkpointer.checkpoints)
+}
+
+func TestShardConsumerProcessRecordsCanceled(t *testing.T) {
+	c := NewShardConsumer(1)
+	c.Initialize(&shard.InitializationInput{ShardId: "0001"})
+
+	// nobody pulls the record, the buffer is full after the first one
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		c.ProcessRecords(&ProcessRecordsInput{
+			Records:      []*kinesis.Record{{SequenceNumber: aws.String("1")}, {SequenceNumber: aws.String("2")}},
+			Checkpointer: &subSequenceCheckpointer{},
+			Context:      ctx,
+		})
+	}()
+
+	// the lease is lost, the shard consumer isn't held back
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("ProcessRecords still blocked")
+	}
+}
+
+func TestAcceptedShardConsumerInitializeCanceled(t *testing.T) {
+	processor := NewShardConsumerFactory(1).CreateProcessor()
+
+	// the shard consumer is never accepted
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		processor.Initialize(&shard.InitializationInput{ShardId: "0001", Context: ctx})
+	}()
+
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Initialize still blocked")
+	}
+}
+
+// subSequenceCheckpointer records the checkpoints as sequence number/sub-sequence number.
+type subSequenceCheckpointer struct {
+	mockRecordCheckpointer
+}
+
+func (m *subSequenceCheckpointer) CheckpointSequenceWithSubSequence(sequenceNumber string, subSequenceNumber int64) error {
+	m.checkpoints = append(m.checkpoints, fmt.Sprintf("%s/%d", sequenceNumber, subSequenceNumber))
+	return nil
+}
@@ -0,0 +1,67 @@
+package goKCL
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/service/kinesis"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/guygma/goKCL/util"
+)
+
+func TestQuiesce(t *testing.T) {
+	factory := &shutdownRecordingFactory{}
+	store := newMemoryLeaseStore(10 * time.Second)
+	kc := &mockKinesis{shards: []*kinesis.Shard{
+		mockShard("shardId-0", "0", "170141183460469231731687303715884105727"),
+		mockShard("shardId-1", "170141183460469231731687303715884105728", "340282366920938463463374607431768211455"),
+	}}
+	kclConfig := NewKinesisClientLibConfig("appName", "test", "us-west-2", "worker").
+		WithFailoverTimeMillis(10000).
+		WithShardSyncIntervalMillis(20).
+		WithIdleTimeBetweenReadsInMillis(10).
+		WithQuiesceWindow(400)
+	w := NewWorker(factory, kclConfig, nil).WithKinesis(kc).WithCheckpointer(store)
+	assert.Nil(t, w.Start())
+	defer w.ShutdownWithContext(context.Background())
+	assert.True(t, store.waitForOwner("worker", time.Second, "shardId-0", "shardId-1"))
+
+	// the leases are handed off one at a time over the window
+	w.Quiesce()
+	assert.True(t, store.waitForOwner("", 300*time.Millisecond, "shardId-0"))
+	assert.True(t, store.waitForOwner("worker", 10*time.Millisecond, "shardId-1"))
+	assert.True(t, store.waitForOwner("", time.Second, "shardId-0", "shardId-1"))
+	assert.Equal(t, []util.ShutdownReason{util.REQUESTED, util.REQUESTED}, factory.shutdownReasons())
+
+	// and aren't acquired again while quiesced
+	time.Sleep(100 * time.Millisecond)
+	assert.True(t, store.waitForOwner("", 10*time.Millisecond, "shardId-0", "shardId-1"))
+
+	w.Resume()
+	assert.True(t, store.waitForOwner("worker", time.Second, "shardId-0", "shardId-1"))
+}
+
+func TestQuiesceAndShutdownWhileShardsChange(t *testing.T) {
+	kc := &churningKinesis{mockKinesis: &mockKinesis{}}
+	store := newMemoryLeaseStore(10 * time.Second)
+	kclConfig := NewKinesisClientLibConfig("appName", "churn", "us-west-2", "worker-a").
+		WithFailoverTimeMillis(10000).
+		WithShardSyncIntervalMillis(1).
+		WithIdleTimeBetweenReadsInMillis(10).
+		WithQuiesceWindow(10)
+	worker := NewWorker(&mockProcessorFactory{}, kclConfig, nil).WithKinesis(kc).WithCheckpointer(store)
+	assert.Nil(t, worker.Start())
+
+	// the shard map is read from this goroutine while the event loop adds and removes shards
+	deadline := time.Now().Add(200 * time.Millisecond)
+	for time.Now().Before(deadline) {
+		worker.Quiesce()
+		worker.Resume()
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	assert.Nil(t, worker.ShutdownWithContext(ctx))
+}
@@ -0,0 +1,49 @@
+package shard
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/kinesis"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/guygma/goKCL"
+	"github.com/guygma/goKCL/record"
+)
+
+func TestRawRecordsDeliveredUnmodified(t *testing.T) {
+	rejectAll := func(r *kinesis.Record) error { return errors.New("rejected") }
+	sc := &Consumer{
+		kclConfig: goKCL.NewKinesisClientLibConfig("appName", "test", "us-west-2", "abc").
+			WithRecordValidator(rejectAll, record.SKIP).
+			WithRawRecords(true),
+		mService: newMockMonitoringService(),
+	}
+
+	arrival := time.Now().Add(-time.Minute)
+	raw := []*kinesis.Record{
+		{
+			Data:                        []byte("data"),
+			PartitionKey:                aws.String("key"),
+			SequenceNumber:              aws.String("49590338271490256608559692538361571095921575989136588898"),
+			EncryptionType:              aws.String(kinesis.EncryptionTypeKms),
+			ApproximateArrivalTimestamp: &arrival,
+		},
+	}
+
+	records, err := sc.prepareRecords(&Status{ID: "0001"}, raw)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(records))
+	assert.True(t, raw[0] == records[0])
+	assert.Equal(t, kinesis.EncryptionTypeKms, aws.StringValue(records[0].EncryptionType))
+	assert.Equal(t, arrival, *records[0].ApproximateArrivalTimestamp)
+	assert.Equal(t, "49590338271490256608559692538361571095921575989136588898", aws.StringValue(records[0].SequenceNumber))
+
+	// without raw mode the library transformations apply
+	sc.kclConfig.DeliverRawRecords = false
+	records, err = sc.prepareRecords(&Status{ID: "0001"}, raw)
+	assert.Nil(t, err)
+	assert.Equal(t, 0, len(records))
+}
@@ -0,0 +1,31 @@
+package shard
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReadOnlyFollower(t *testing.T) {
+	kc := newMockKinesisClient(5, true)
+	checkpointer := newMockShardCheckpointer()
+	checkpointer.owners["0001"] = "owner"
+	checkpointer.checkpoints["0001"] = "2"
+	processor := &mockRecordProcessor{}
+	sc := newTestConsumer(kc, NewFollowerCheckpointer(checkpointer), processor, testConfig().WithReadOnlyFollower())
+	sc.follower = true
+
+	// the follower never holds the lease, even once it would need to be renewed
+	shard := testShard()
+	shard.LeaseTimeout = time.Now().Add(-time.Minute)
+
+	err := sc.GetRecords(shard)
+	assert.Nil(t, err)
+
+	// the follower starts where the owner is, and its checkpoints (including the end of the shard) are dropped
+	assert.Equal(t, []string{"3", "4", "5"}, processor.sequenceNumbers())
+	assert.Equal(t, "2", checkpointer.checkpoints["0001"])
+	assert.Empty(t, checkpointer.history)
+	assert.Equal(t, "owner", checkpointer.owners["0001"])
+}
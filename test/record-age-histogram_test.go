@@ -0,0 +1,87 @@
+package util
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/cloudwatch"
+	"github.com/aws/aws-sdk-go/service/cloudwatch/cloudwatchiface"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRecordAgeHistogram(t *testing.T) {
+	svc := &mockCloudWatch{}
+	cw := newTestCloudWatch(svc, METRICS_DETAILED)
+
+	for _, age := range []float64{5, 50, 70, 5000, 7200000, 1e10} {
+		cw.RecordAge("0001", age)
+	}
+	assert.Nil(t, cw.flush())
+
+	datum := svc.datum("RecordAge")
+	assert.NotNil(t, datum)
+	assert.Equal(t, []float64{10, 100, 10000, 21600000, 604800000}, aws.Float64ValueSlice(datum.Values))
+	assert.Equal(t, []float64{1, 2, 1, 1, 1}, aws.Float64ValueSlice(datum.Counts))
+
+	// the ages are reset once published
+	assert.Nil(t, cw.flush())
+	assert.Nil(t, svc.datum("RecordAge"))
+}
+
+func TestRecordAgeSummaryLevel(t *testing.T) {
+	svc := &mockCloudWatch{}
+	cw := newTestCloudWatch(svc, METRICS_SUMMARY)
+
+	cw.RecordAge("0001", 5)
+	cw.IncrRecordsProcessed("0001", 1)
+	assert.Nil(t, cw.flush())
+
+	assert.NotNil(t, svc.datum("RecordsProcessed"))
+	assert.Nil(t, svc.datum("RecordAge"))
+}
+
+func TestMetricsLevelNone(t *testing.T) {
+	metricsConfig := &MonitoringConfiguration{MonitoringService: "cloudwatch", MetricsLevel: METRICS_NONE}
+	assert.Nil(t, metricsConfig.Init("appName", "test", "abc"))
+	assert.IsType(t, &noopMonitoringService{}, metricsConfig.GetMonitoringService())
+}
+
+func newTestCloudWatch(svc cloudwatchiface.CloudWatchAPI, level MetricsLevel) *CloudWatchMonitoringService {
+	return &CloudWatchMonitoringService{
+		KinesisStream: "test",
+		WorkerID:      "abc",
+		MetricsLevel:  level,
+		svc:           svc,
+		shardMetrics:  new(sync.Map),
+	}
+}
+
+// mockCloudWatch keeps the metric data of the last PutMetricData. It fails with err if set, after waiting for
+// block to be closed if set.
+type mockCloudWatch struct {
+	cloudwatchiface.CloudWatchAPI
+	data  []*cloudwatch.MetricDatum
+	err   error
+	block chan struct{}
+}
+
+func (m *mockCloudWatch) PutMetricData(input *cloudwatch.PutMetricDataInput) (*cloudwatch.PutMetricDataOutput, error) {
+	if m.block != nil {
+		<-m.block
+	}
+	m.data = input.MetricData
+	if m.err != nil {
+		return nil, m.err
+	}
+	return &cloudwatch.PutMetricDataOutput{}, nil
+}
+
+func (m *mockCloudWatch) datum(name string) *cloudwatch.MetricDatum {
+	for _, d := range m.data {
+		if aws.StringValue(d.MetricName) == name {
+			return d
+		}
+	}
+	return nil
+}
@@ -0,0 +1,27 @@
+package shard
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRecordAgeAtDelivery(t *testing.T) {
+	now := time.Now()
+	kc := &mockKinesisClient{closed: true}
+	for _, age := range []time.Duration{time.Hour, time.Minute, time.Second, 0} {
+		kc.addRecord("data", now.Add(-age))
+	}
+	mService := newMockMonitoringService()
+	sc := newTestConsumer(kc, newMockShardCheckpointer(), &mockRecordProcessor{}, testConfig())
+	sc.mService = mService
+
+	assert.Nil(t, sc.GetRecords(testShard()))
+
+	assert.Equal(t, 4, len(mService.recordAges))
+	// the ages are measured at delivery, slightly after now
+	for i, age := range []time.Duration{time.Hour, time.Minute, time.Second, 0} {
+		assert.InDelta(t, float64(age/time.Millisecond), mService.recordAges[i], 1000)
+	}
+}
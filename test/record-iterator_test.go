@@ -0,0 +1,82 @@
+package record
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/guygma/goKCL/shard"
+	"github.com/guygma/goKCL/util"
+)
+
+func TestRecordIteratorAckOutOfOrder(t *testing.T) {
+	checkpointer := &mockRecordCheckpointer{}
+	iterator := NewRecordIterator(10)
+	records := pullRecords(iterator, checkpointer, "a", "b", "c", "d")
+
+	records[1].Ack()
+	records[3].Ack()
+	assert.Empty(t, checkpointer.checkpoints)
+
+	// the checkpoint only advances over the contiguous prefix of Ack'd record
+	records[0].Ack()
+	assert.Equal(t, []string{"2"}, checkpointer.checkpoints)
+	records[2].Ack()
+	assert.Equal(t, []string{"2", "4"}, checkpointer.checkpoints)
+}
+
+func TestRecordIteratorNackStop(t *testing.T) {
+	checkpointer := &mockRecordCheckpointer{}
+	iterator := NewRecordIterator(10)
+	records := pullRecords(iterator, checkpointer, "a", "b", "c")
+
+	records[0].Ack()
+	records[1].Nack(errors.New("boom"))
+	records[2].Ack()
+	assert.Equal(t, []string{"1"}, checkpointer.checkpoints)
+}
+
+func TestRecordIteratorNackSkip(t *testing.T) {
+	checkpointer := &mockRecordCheckpointer{}
+	iterator := NewRecordIterator(10).WithFailurePolicy(SKIP, nil)
+	records := pullRecords(iterator, checkpointer, "a", "b")
+
+	records[0].Nack(errors.New("boom"))
+	records[1].Ack()
+	assert.Equal(t, []string{"1", "2"}, checkpointer.checkpoints)
+}
+
+func TestRecordIteratorShardEnd(t *testing.T) {
+	checkpointer := &mockRecordCheckpointer{}
+	iterator := NewRecordIterator(10)
+	processor := iterator.CreateProcessor()
+	processor.Initialize(&shard.InitializationInput{ShardId: "0001"})
+	processor.ProcessRecords(&ProcessRecordsInput{Records: sinkRecords("a"), Checkpointer: checkpointer})
+	r := iterator.Next()
+
+	// the end of the shard is only checkpointed once its record have been settled
+	done := make(chan struct{})
+	go func() {
+		processor.Shutdown(&util.ShutdownInput{ShutdownReason: util.TERMINATE, Checkpointer: checkpointer})
+		close(done)
+	}()
+	time.Sleep(10 * time.Millisecond)
+	r.Ack()
+	<-done
+	assert.Equal(t, []string{"1", ""}, checkpointer.checkpoints)
+}
+
+// pullRecords delivers the record to a processor of the iterator and pulls them back from the iterator.
+func pullRecords(iterator *RecordIterator, checkpointer IRecordProcessorCheckpointer, data ...string) []*AckableRecord {
+	processor := iterator.CreateProcessor()
+	processor.Initialize(&shard.InitializationInput{ShardId: "0001"})
+	processor.ProcessRecords(&ProcessRecordsInput{Records: sinkRecords(data...), Checkpointer: checkpointer})
+
+	records := make([]*AckableRecord, 0, len(data))
+	for range data {
+		records = append(records, iterator.Next())
+	}
+	return records
+}
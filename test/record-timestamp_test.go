@@ -0,0 +1,52 @@
+package shard
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/service/kinesis"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRecordTimestampExtractor(t *testing.T) {
+	now := time.Now()
+	kc := &mockKinesisClient{closed: true}
+	// the events happened well before they arrived in Kinesis, the last one has no timestamp
+	for _, age := range []time.Duration{time.Hour, time.Minute} {
+		payload, _ := json.Marshal(map[string]interface{}{"ts": now.Add(-age).UnixNano()})
+		kc.addRecord(string(payload), now)
+	}
+	kc.addRecord(`{}`, now.Add(-time.Second))
+	mService := newMockMonitoringService()
+	cfg := testConfig()
+	cfg.RecordTimestampExtractor = payloadTimestamp
+	sc := newTestConsumer(kc, newMockShardCheckpointer(), &mockRecordProcessor{}, cfg)
+	sc.mService = mService
+
+	sh := testShard()
+	assert.Nil(t, sc.GetRecords(sh))
+
+	// the ages are the ones of the payload, or of the arrival time when it can't be extracted
+	assert.Equal(t, 3, len(mService.recordAges))
+	for i, age := range []time.Duration{time.Hour, time.Minute, time.Second} {
+		assert.InDelta(t, float64(age/time.Millisecond), mService.recordAges[i], 1000)
+	}
+	// the watermark is the latest event time
+	assert.WithinDuration(t, now.Add(-time.Second), sh.GetWatermark(), time.Millisecond)
+}
+
+// payloadTimestamp extracts the ts field of a JSON payload, in nanoseconds since the epoch.
+func payloadTimestamp(r *kinesis.Record) (time.Time, error) {
+	var payload struct {
+		TS int64 `json:"ts"`
+	}
+	if err := json.Unmarshal(r.Data, &payload); err != nil {
+		return time.Time{}, err
+	}
+	if payload.TS == 0 {
+		return time.Time{}, errors.New("no timestamp")
+	}
+	return time.Unix(0, payload.TS), nil
+}
@@ -0,0 +1,136 @@
+package shard
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/kinesis"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/guygma/goKCL"
+	"github.com/guygma/goKCL/record"
+	"github.com/guygma/goKCL/util"
+)
+
+func TestValidateRecordsDrop(t *testing.T) {
+	mService := newMockMonitoringService()
+	sc := &Consumer{
+		kclConfig: goKCL.NewKinesisClientLibConfig("appName", "test", "us-west-2", "abc").
+			WithRecordValidator(jsonValidator, record.SKIP),
+		mService: mService,
+	}
+
+	valid, err := sc.validateRecords(&Status{ID: "0001"}, validationRecords())
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(valid))
+	assert.Equal(t, "1", aws.StringValue(valid[0].SequenceNumber))
+	assert.Equal(t, "3", aws.StringValue(valid[1].SequenceNumber))
+	assert.Equal(t, 1, mService.invalidRecords)
+}
+
+func TestValidateRecordsDeadLetter(t *testing.T) {
+	mService := newMockMonitoringService()
+	handler := &mockDeadLetterHandler{}
+	sc := &Consumer{
+		kclConfig: goKCL.NewKinesisClientLibConfig("appName", "test", "us-west-2", "abc").
+			WithRecordValidator(jsonValidator, record.DEAD_LETTER).
+			WithDeadLetterHandler(handler),
+		mService: mService,
+	}
+
+	valid, err := sc.validateRecords(&Status{ID: "0001"}, validationRecords())
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(valid))
+	assert.Equal(t, 1, len(handler.inputs))
+	assert.Equal(t, "0001", handler.inputs[0].ShardID)
+	assert.Equal(t, "2", aws.StringValue(handler.inputs[0].Record.SequenceNumber))
+	assert.Equal(t, errNotJSON, handler.inputs[0].Error)
+	assert.Equal(t, 1, mService.invalidRecords)
+}
+
+func TestValidateRecordsStop(t *testing.T) {
+	sc := &Consumer{
+		kclConfig: goKCL.NewKinesisClientLibConfig("appName", "test", "us-west-2", "abc").
+			WithRecordValidator(jsonValidator, record.STOP),
+		mService: newMockMonitoringService(),
+	}
+
+	_, err := sc.validateRecords(&Status{ID: "0001"}, validationRecords())
+	assert.NotNil(t, err)
+	assert.Equal(t, util.IllegalArgumentError, err.(*util.ClientLibraryError).ErrorCode)
+}
+
+func TestInvalidRecordStopsShard(t *testing.T) {
+	kc := newMockKinesisClient(0, true)
+	kc.addRecord(`{"id": 1}`, time.Now())
+	kc.addRecord("not-json", time.Now())
+	processor := &mockRecordProcessor{}
+	sc := newTestConsumer(kc, newMockShardCheckpointer(), processor, testConfig().
+		WithMaxRecords(1).
+		WithRecordValidator(jsonValidator, record.STOP))
+
+	err := sc.GetRecords(testShard())
+	assert.NotNil(t, err)
+	assert.Equal(t, util.IllegalArgumentError, err.(*util.ClientLibraryError).ErrorCode)
+	assert.Equal(t, []string{"1"}, processor.sequenceNumbers())
+	// the record processor is shut down before the lease is released
+	assert.Equal(t, []util.ShutdownReason{util.REQUESTED}, processor.shutdownReasons)
+}
+
+func TestDeadLetterContext(t *testing.T) {
+	handler := &mockDeadLetterHandler{}
+	sc := &Consumer{
+		kclConfig: goKCL.NewKinesisClientLibConfig("appName", "test", "us-west-2", "abc").
+			WithRecordValidator(jsonValidator, record.DEAD_LETTER).
+			WithDeadLetterHandler(handler),
+		mService: newMockMonitoringService(),
+	}
+
+	arrival := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	_, err := sc.validateRecords(&Status{ID: "0001"}, []*kinesis.Record{{
+		Data:                        []byte(`garbage`),
+		SequenceNumber:              aws.String("42"),
+		PartitionKey:                aws.String("key"),
+		ApproximateArrivalTimestamp: aws.Time(arrival),
+	}})
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(handler.inputs))
+
+	input := handler.inputs[0]
+	assert.Equal(t, "0001", input.ShardID)
+	assert.Equal(t, "42", input.SequenceNumber)
+	assert.Equal(t, "key", input.PartitionKey)
+	assert.Equal(t, arrival, input.ApproximateArrivalTimestamp)
+	assert.Equal(t, 1, input.Attempts)
+	assert.Equal(t, errNotJSON, input.Error)
+	assert.NotNil(t, input.ClientLibraryError)
+	assert.Equal(t, util.IllegalArgumentError, input.ClientLibraryError.ErrorCode)
+	assert.Contains(t, input.ClientLibraryError.Detail, errNotJSON.Error())
+}
+
+var errNotJSON = errors.New("not a json object")
+
+func jsonValidator(r *kinesis.Record) error {
+	if len(r.Data) == 0 || r.Data[0] != '{' {
+		return errNotJSON
+	}
+	return nil
+}
+
+func validationRecords() []*kinesis.Record {
+	return []*kinesis.Record{
+		{Data: []byte(`{"a":1}`), SequenceNumber: aws.String("1")},
+		{Data: []byte(`garbage`), SequenceNumber: aws.String("2")},
+		{Data: []byte(`{"b":2}`), SequenceNumber: aws.String("3")},
+	}
+}
+
+type mockDeadLetterHandler struct {
+	inputs []*record.DeadLetterInput
+}
+
+func (m *mockDeadLetterHandler) DeadLetter(input *record.DeadLetterInput) {
+	m.inputs = append(m.inputs, input)
+}
@@ -0,0 +1,144 @@
+package goKCL
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/kinesis"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/guygma/goKCL/util"
+)
+
+func TestReplayShard(t *testing.T) {
+	kc := &iteratorRecordingKinesis{mockKinesis: &mockKinesis{shards: []*kinesis.Shard{
+		mockShard("shardId-0", "0", "170141183460469231731687303715884105727"),
+		mockShard("shardId-1", "170141183460469231731687303715884105728", "340282366920938463463374607431768211455"),
+	}}}
+	store := newMemoryLeaseStore(10 * time.Second)
+	store.checkpoints["shardId-1"] = "50"
+	kclConfig := NewKinesisClientLibConfig("appName", "test", "us-west-2", "worker").
+		WithFailoverTimeMillis(10000).
+		WithShardSyncIntervalMillis(20).
+		WithIdleTimeBetweenReadsInMillis(10)
+	factory := &shutdownRecordingFactory{}
+	w := NewWorker(factory, kclConfig, nil).WithKinesis(kc).WithCheckpointer(store)
+	assert.Nil(t, w.Start())
+	defer w.ShutdownWithContext(context.Background())
+	assert.True(t, store.waitForOwner("worker", time.Second, "shardId-0", "shardId-1"))
+
+	// the shard is released, checkpointed at the sequence number, and read again from it
+	assert.Nil(t, w.ReplayShard("shardId-1", "42", false))
+	assert.Equal(t, []util.ShutdownReason{util.REQUESTED}, factory.shutdownReasons())
+	// right before the record, so that any worker taking the lease resumes at it
+	store.mux.Lock()
+	assert.Equal(t, "42", store.checkpoints["shardId-1"])
+	assert.Equal(t, int64(-1), store.subSequences["shardId-1"])
+	store.mux.Unlock()
+	assert.True(t, store.waitForOwner("worker", time.Second, "shardId-1"))
+	input := kc.waitForIteratorRequest("shardId-1", "AT_SEQUENCE_NUMBER", time.Second)
+	if assert.NotNil(t, input) {
+		assert.Equal(t, "42", aws.StringValue(input.StartingSequenceNumber))
+	}
+
+	// the other shard is left alone
+	assert.Equal(t, 1, kc.iteratorRequestCount("shardId-0"))
+}
+
+func TestReplayShardHeldByAnotherWorker(t *testing.T) {
+	kc := &mockKinesis{shards: []*kinesis.Shard{
+		mockShard("shardId-0", "0", "340282366920938463463374607431768211455"),
+	}}
+	store := newMemoryLeaseStore(10 * time.Second)
+	store.checkpoints["shardId-0"] = "50"
+	store.owners["shardId-0"] = "other"
+	store.leaseTimeouts["shardId-0"] = time.Now().Add(time.Minute)
+	kclConfig := NewKinesisClientLibConfig("appName", "test", "us-west-2", "worker").
+		WithFailoverTimeMillis(10000).
+		WithShardSyncIntervalMillis(10000)
+	w := NewWorker(&mockProcessorFactory{}, kclConfig, nil).WithKinesis(kc).WithCheckpointer(store)
+	assert.Nil(t, w.Start())
+	defer w.Shutdown()
+	assert.Nil(t, w.WaitReady(context.Background()))
+
+	// the live owner would write its own checkpoint back on its next renewal, undoing the replay
+	err := w.ReplayShard("shardId-0", "42", false)
+	assert.True(t, errors.Is(err, util.LeasingError.MakeErr()), "%v", err)
+	store.mux.Lock()
+	assert.Equal(t, "50", store.checkpoints["shardId-0"])
+	assert.Equal(t, "other", store.owners["shardId-0"])
+	store.mux.Unlock()
+
+	// nor does this worker resume at the sequence number if it takes the lease later on
+	assert.Nil(t, w.takeStartingSequenceNumber("shardId-0"))
+}
+
+func TestReplayShardInvalidInput(t *testing.T) {
+	kc := &mockKinesis{shards: []*kinesis.Shard{
+		mockShard("shardId-0", "0", "340282366920938463463374607431768211455"),
+	}}
+	kclConfig := NewKinesisClientLibConfig("appName", "test", "us-west-2", "worker").
+		WithFailoverTimeMillis(10000).
+		WithShardSyncIntervalMillis(10000)
+	w := NewWorker(&mockProcessorFactory{}, kclConfig, nil).WithKinesis(kc).WithCheckpointer(newMemoryLeaseStore(time.Second))
+	assert.Nil(t, w.Start())
+	defer w.Shutdown()
+	assert.Nil(t, w.WaitReady(context.Background()))
+
+	for _, err := range []error{
+		w.ReplayShard("shardId-0", "not-a-number", false),
+		w.ReplayShard("shardId-9", "42", true),
+		// before the start of the shard
+		w.ReplayShard("shardId-0", "0", true),
+	} {
+		assert.True(t, errors.Is(err, util.IllegalArgumentError.MakeErr()), "%v", err)
+	}
+}
+
+// iteratorRecordingKinesis records the shard iterators requested.
+type iteratorRecordingKinesis struct {
+	*mockKinesis
+	mux      sync.Mutex
+	requests []*kinesis.GetShardIteratorInput
+}
+
+func (m *iteratorRecordingKinesis) GetShardIterator(input *kinesis.GetShardIteratorInput) (*kinesis.GetShardIteratorOutput, error) {
+	m.mux.Lock()
+	m.requests = append(m.requests, input)
+	m.mux.Unlock()
+	return m.mockKinesis.GetShardIterator(input)
+}
+
+// waitForIteratorRequest waits for an iterator of the given type on the shard to be requested.
+func (m *iteratorRecordingKinesis) waitForIteratorRequest(shardID, iteratorType string,
+	timeout time.Duration) *kinesis.GetShardIteratorInput {
+	deadline := time.Now().Add(timeout)
+	for time.Now().Before(deadline) {
+		m.mux.Lock()
+		for _, input := range m.requests {
+			if aws.StringValue(input.ShardId) == shardID && aws.StringValue(input.ShardIteratorType) == iteratorType {
+				m.mux.Unlock()
+				return input
+			}
+		}
+		m.mux.Unlock()
+		time.Sleep(10 * time.Millisecond)
+	}
+	return nil
+}
+
+func (m *iteratorRecordingKinesis) iteratorRequestCount(shardID string) int {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+	count := 0
+	for _, input := range m.requests {
+		if aws.StringValue(input.ShardId) == shardID {
+			count++
+		}
+	}
+	return count
+}
@@ -0,0 +1,46 @@
+package shard
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/guygma/goKCL/util"
+)
+
+func TestReplayRange(t *testing.T) {
+	kc := newMockKinesisClient(10, false)
+	processor := &mockRecordProcessor{}
+	st := &Status{ID: "0001", Mux: &sync.Mutex{}, Checkpoint: "8"}
+
+	assert.Nil(t, Replay(kc, "test", st, "3", "6", processor, 3))
+
+	var delivered []string
+	for _, r := range processor.records {
+		delivered = append(delivered, aws.StringValue(r.SequenceNumber))
+	}
+	assert.Equal(t, []string{"3", "4", "5", "6"}, delivered)
+	assert.Equal(t, []util.ShutdownReason{util.REQUESTED}, processor.shutdownReasons)
+	// the checkpoints made by the processor are discarded
+	assert.Equal(t, "8", st.Checkpoint)
+}
+
+func TestReplayRangeValidation(t *testing.T) {
+	kc := newMockKinesisClient(10, false)
+	st := &Status{ID: "0001", Mux: &sync.Mutex{}, StartingSequenceNumber: "2", EndingSequenceNumber: "9"}
+
+	for _, r := range [][2]string{{"6", "3"}, {"1", "3"}, {"3", "10"}, {"", "3"}} {
+		err := Replay(kc, "test", st, r[0], r[1], &mockRecordProcessor{}, 3)
+		assert.NotNil(t, err, "%v", r)
+		assert.Equal(t, util.IllegalArgumentError, err.(*util.ClientLibraryError).ErrorCode)
+	}
+	assert.Equal(t, 0, kc.getRecordsCalls)
+
+	// the stream does not hold the end of the range yet
+	processor := &mockRecordProcessor{}
+	err := Replay(kc, "test", &Status{ID: "0001", Mux: &sync.Mutex{}}, "8", "12", processor, 3)
+	assert.NotNil(t, err)
+	assert.Equal(t, 3, len(processor.records))
+}
@@ -0,0 +1,59 @@
+package goKCL
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/kinesis"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/guygma/goKCL/shard"
+)
+
+func TestReshardRebalancesCoalesced(t *testing.T) {
+	kclConfig := NewKinesisClientLibConfig("appName", "test", "us-west-2", "abc").
+		WithReshardCoalesceWindowMillis(60000)
+	rebalances := reshardBurst(t, kclConfig)
+
+	// none during the burst, a single one once the reshard settled
+	assert.Equal(t, 1, rebalances)
+}
+
+func TestReshardRebalancesWithoutCoalescing(t *testing.T) {
+	rebalances := reshardBurst(t, NewKinesisClientLibConfig("appName", "test", "us-west-2", "abc"))
+
+	// one per shard sync of the burst, and the one after it
+	assert.Equal(t, 5, rebalances)
+}
+
+// reshardBurst runs the event loop cycles of a worker while the 4 children of a reshard appear one at a time, then one
+// more after the coalescing window, and returns how many of them rebalanced leases.
+func reshardBurst(t *testing.T, kclConfig *KinesisClientLibConfiguration) int {
+	kc := &mockKinesis{shards: []*kinesis.Shard{mockShard("shardId-0", "0", "100")}}
+	w := NewWorker(nil, kclConfig, nil).WithKinesis(kc)
+	w.shardStatus = make(map[string]*shard.Status)
+
+	rebalances := 0
+	cycle := func(now time.Time) {
+		assert.Nil(t, w.syncShard())
+		if w.reshardSettled(now) && w.leaseAcquisitionAllowed(now) {
+			rebalances++
+		}
+	}
+
+	// the shards found on startup aren't a reshard
+	cycle(time.Now())
+	assert.Equal(t, 1, rebalances)
+	rebalances = 0
+
+	for i := 1; i <= 4; i++ {
+		child := mockShard(fmt.Sprintf("shardId-%d", i), "0", "100")
+		child.ParentShardId = aws.String("shardId-0")
+		kc.shards = append(kc.shards, child)
+		cycle(time.Now())
+	}
+	cycle(time.Now().Add(time.Minute))
+	return rebalances
+}
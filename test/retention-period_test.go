@@ -0,0 +1,51 @@
+package goKCL
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/guygma/goKCL/util"
+)
+
+func TestRetentionPeriod(t *testing.T) {
+	kc := &mockKinesis{retentionHours: 48}
+	w := NewWorker(nil, NewKinesisClientLibConfig("appName", "test", "us-west-2", "abc"), nil).
+		WithKinesis(kc).
+		WithCheckpointer(newMemoryLeaseStore(time.Minute))
+	assert.Nil(t, w.initialize())
+
+	retention, err := w.GetRetentionPeriod()
+	assert.Nil(t, err)
+	assert.Equal(t, 48*time.Hour, retention)
+
+	// the retention period is cached
+	_, err = w.GetRetentionPeriod()
+	assert.Nil(t, err)
+	assert.Equal(t, 1, kc.summaryCalls)
+}
+
+func TestTimestampBeyondRetentionRejected(t *testing.T) {
+	timestamp := time.Now().Add(-72 * time.Hour)
+	kclConfig := NewKinesisClientLibConfig("appName", "test", "us-west-2", "abc").
+		WithTimestampAtInitialPositionInStream(&timestamp)
+	w := NewWorker(nil, kclConfig, nil).
+		WithKinesis(&mockKinesis{retentionHours: 48}).
+		WithCheckpointer(newMemoryLeaseStore(time.Minute))
+
+	err := w.Start()
+	assert.NotNil(t, err)
+	assert.Equal(t, util.IllegalArgumentError, err.(*util.ClientLibraryError).ErrorCode)
+}
+
+func TestTimestampWithinRetentionAccepted(t *testing.T) {
+	timestamp := time.Now().Add(-24 * time.Hour)
+	kclConfig := NewKinesisClientLibConfig("appName", "test", "us-west-2", "abc").
+		WithTimestampAtInitialPositionInStream(&timestamp)
+	w := NewWorker(nil, kclConfig, nil).
+		WithKinesis(&mockKinesis{retentionHours: 48}).
+		WithCheckpointer(newMemoryLeaseStore(time.Minute))
+
+	assert.Nil(t, w.initialize())
+}
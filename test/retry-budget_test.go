@@ -0,0 +1,34 @@
+package util
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRetryBudgetExhausted(t *testing.T) {
+	budget := NewRetryBudget(3, 0.001)
+
+	for i := 0; i < 3; i++ {
+		assert.True(t, budget.Acquire())
+	}
+
+	// once the budget is exhausted further retries short-circuit
+	assert.False(t, budget.Acquire())
+	assert.False(t, budget.Acquire())
+	assert.Equal(t, 0, budget.Available())
+}
+
+func TestRetryBudgetRefill(t *testing.T) {
+	budget := NewRetryBudget(2, 100)
+
+	assert.True(t, budget.Acquire())
+	assert.True(t, budget.Acquire())
+
+	time.Sleep(50 * time.Millisecond)
+
+	// refilled, but never beyond the budget size
+	assert.Equal(t, 2, budget.Available())
+	assert.True(t, budget.Acquire())
+}
@@ -0,0 +1,38 @@
+package util
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIsRetryableClientLibraryError(t *testing.T) {
+	assert.True(t, IsRetryable(ThrottlingError.MakeErr()))
+	assert.True(t, IsRetryable(fmt.Errorf("checkpoint failed: %w", KinesisClientLibDependencyError.MakeErr())))
+	assert.False(t, IsRetryable(ShutdownError.MakeErr()))
+	assert.False(t, IsRetryable(IllegalArgumentError.MakeErr()))
+
+	// the flag of the library error wins over its cause
+	cause := awserr.New("ThrottlingException", "rate exceeded", nil)
+	assert.False(t, IsRetryable(InvalidStateError.MakeErr().WithCause(cause)))
+}
+
+func TestIsRetryableAWSError(t *testing.T) {
+	for _, code := range []string{"ProvisionedThroughputExceededException", "ThrottlingException",
+		"RequestLimitExceeded", "InternalServerError"} {
+		assert.True(t, IsRetryable(awserr.New(code, "", nil)), code)
+	}
+	assert.False(t, IsRetryable(awserr.New("ResourceNotFoundException", "", nil)))
+	assert.False(t, IsRetryable(awserr.New("ConditionalCheckFailedException", "", nil)))
+}
+
+func TestIsRetryableOtherErrors(t *testing.T) {
+	assert.False(t, IsRetryable(nil))
+	assert.False(t, IsRetryable(context.Canceled))
+	assert.False(t, IsRetryable(fmt.Errorf("shutting down: %w", context.Canceled)))
+	assert.False(t, IsRetryable(errors.New("unknown")))
+}
@@ -0,0 +1,370 @@
+package shard
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/kinesis"
+	"github.com/aws/aws-sdk-go/service/kinesis/kinesisiface"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/guygma/goKCL"
+	"github.com/guygma/goKCL/record"
+	"github.com/guygma/goKCL/util"
+)
+
+func TestExpiredIteratorRefreshed(t *testing.T) {
+	kc := newMockKinesisClient(5, true)
+	kc.getRecordsErrors = map[int]error{
+		2: awserr.New(kinesis.ErrCodeExpiredIteratorException, "Iterator expired", nil),
+	}
+	checkpointer := newMockShardCheckpointer()
+	processor := &mockRecordProcessor{}
+	sc := newTestConsumer(kc, checkpointer, processor, testConfig().WithMaxRecords(2))
+
+	err := sc.GetRecords(testShard())
+	assert.Nil(t, err)
+
+	// every record is delivered exactly once, resuming after the last checkpoint
+	assert.Equal(t, []string{"1", "2", "3", "4", "5"}, processor.sequenceNumbers())
+	assert.Equal(t, 2, len(kc.iteratorRequests))
+	assert.Equal(t, "AFTER_SEQUENCE_NUMBER", aws.StringValue(kc.iteratorRequests[1].ShardIteratorType))
+	assert.Equal(t, "2", aws.StringValue(kc.iteratorRequests[1].StartingSequenceNumber))
+	assert.Equal(t, 1, sc.mService.(*mockMonitoringService).expiredIterators)
+	assert.Equal(t, SHARD_END, checkpointer.checkpoints["0001"])
+}
+
+func TestExpiredIteratorFailsShard(t *testing.T) {
+	kc := newMockKinesisClient(5, true)
+	kc.getRecordsErrors = map[int]error{
+		2: awserr.New(kinesis.ErrCodeExpiredIteratorException, "Iterator expired", nil),
+	}
+	checkpointer := newMockShardCheckpointer()
+	processor := &mockRecordProcessor{}
+	sc := newTestConsumer(kc, checkpointer, processor, testConfig().
+		WithMaxRecords(2).
+		WithExpiredIteratorPolicy(goKCL.FAIL_SHARD))
+
+	err := sc.GetRecords(testShard())
+	assert.NotNil(t, err)
+	assert.Equal(t, util.InvalidStateError, err.(*util.ClientLibraryError).ErrorCode)
+	assert.Equal(t, []string{"1", "2"}, processor.sequenceNumbers())
+	assert.Equal(t, []util.ShutdownReason{util.REQUESTED}, processor.shutdownReasons)
+	assert.Equal(t, 1, sc.mService.(*mockMonitoringService).expiredIterators)
+	assert.Equal(t, "2", checkpointer.checkpoints["0001"])
+}
+
+func testConfig() *goKCL.KinesisClientLibConfiguration {
+	return goKCL.NewKinesisClientLibConfig("appName", "test", "us-west-2", "abc").
+		WithInitialPositionInStream(goKCL.TRIM_HORIZON).
+		WithIdleTimeBetweenReadsInMillis(1).
+		WithFailoverTimeMillis(300000)
+}
+
+func testShard() *Status {
+	return &Status{
+		ID:           "0001",
+		Mux:          &sync.Mutex{},
+		LeaseTimeout: time.Now().Add(time.Hour),
+	}
+}
+
+// newTestConsumer creates a shard consumer holding the lease of the test shard. GetRecords can be called once.
+func newTestConsumer(kc kinesisiface.KinesisAPI, checkpointer Checkpointer, processor record.IRecordProcessor,
+	kclConfig *goKCL.KinesisClientLibConfiguration) *Consumer {
+	stop := make(chan struct{})
+	wg := &sync.WaitGroup{}
+	wg.Add(1)
+	return &Consumer{
+		streamName:      "test",
+		kc:              kc,
+		checkpointer:    checkpointer,
+		recordProcessor: processor,
+		kclConfig:       kclConfig,
+		stop:            &stop,
+		waitGroup:       wg,
+		consumerID:      "abc",
+		mService:        newMockMonitoringService(),
+		retryBudget:     util.NewRetryBudget(100, 10),
+		state:           WAITING_ON_PARENT_SHARDS,
+	}
+}
+
+// mockKinesisClient serves a single shard holding record with sequence numbers "1".."n". Shard iterators are
+// encoded as "pos:<index of the next record>".
+type mockKinesisClient struct {
+	kinesisiface.KinesisAPI
+	mux     sync.Mutex
+	records []*kinesis.Record
+	// closed makes the shard end once all record have been read
+	closed bool
+	// getRecordsErrors are returned by the GetRecords call with the given (1 based) number
+	getRecordsErrors map[int]error
+	getRecordsCalls  int
+	iteratorRequests []*kinesis.GetShardIteratorInput
+}
+
+func newMockKinesisClient(n int, closed bool) *mockKinesisClient {
+	m := &mockKinesisClient{closed: closed}
+	for i := 1; i <= n; i++ {
+		m.addRecord(fmt.Sprintf("data-%d", i), time.Now())
+	}
+	return m
+}
+
+func (m *mockKinesisClient) addRecord(data string, arrival time.Time) {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+	m.records = append(m.records, &kinesis.Record{
+		Data:                        []byte(data),
+		PartitionKey:                aws.String("key"),
+		SequenceNumber:              aws.String(strconv.Itoa(len(m.records) + 1)),
+		ApproximateArrivalTimestamp: aws.Time(arrival),
+	})
+}
+
+func (m *mockKinesisClient) GetShardIterator(input *kinesis.GetShardIteratorInput) (*kinesis.GetShardIteratorOutput, error) {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+	m.iteratorRequests = append(m.iteratorRequests, input)
+
+	pos := 0
+	switch aws.StringValue(input.ShardIteratorType) {
+	case "LATEST":
+		pos = len(m.records)
+	case "AT_SEQUENCE_NUMBER", "AFTER_SEQUENCE_NUMBER":
+		seq, err := strconv.Atoi(aws.StringValue(input.StartingSequenceNumber))
+		if err != nil {
+			return nil, awserr.New(kinesis.ErrCodeInvalidArgumentException, "invalid sequence number", err)
+		}
+		pos = seq - 1
+		if aws.StringValue(input.ShardIteratorType) == "AFTER_SEQUENCE_NUMBER" {
+			pos = seq
+		}
+	case "AT_TIMESTAMP":
+		pos = len(m.records)
+		for i, r := range m.records {
+			if !r.ApproximateArrivalTimestamp.Before(aws.TimeValue(input.Timestamp)) {
+				pos = i
+				break
+			}
+		}
+	}
+	return &kinesis.GetShardIteratorOutput{ShardIterator: aws.String(fmt.Sprintf("pos:%d", pos))}, nil
+}
+
+func (m *mockKinesisClient) GetRecords(input *kinesis.GetRecordsInput) (*kinesis.GetRecordsOutput, error) {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+	m.getRecordsCalls++
+	if err, ok := m.getRecordsErrors[m.getRecordsCalls]; ok {
+		return nil, err
+	}
+
+	pos, err := strconv.Atoi(strings.TrimPrefix(aws.StringValue(input.ShardIterator), "pos:"))
+	if err != nil {
+		return nil, awserr.New(kinesis.ErrCodeInvalidArgumentException, "invalid shard iterator", err)
+	}
+	end := pos + int(aws.Int64Value(input.Limit))
+	if end > len(m.records) {
+		end = len(m.records)
+	}
+
+	output := &kinesis.GetRecordsOutput{
+		Records:            m.records[pos:end],
+		MillisBehindLatest: aws.Int64(0),
+	}
+	if !m.closed || end < len(m.records) {
+		output.NextShardIterator = aws.String(fmt.Sprintf("pos:%d", end))
+	}
+	return output, nil
+}
+
+// mockShardCheckpointer keeps leases and checkpoints in memory.
+type mockShardCheckpointer struct {
+	mux         sync.Mutex
+	checkpoints map[string]string
+	owners      map[string]string
+	// history of all checkpoints written
+	history []string
+}
+
+func newMockShardCheckpointer() *mockShardCheckpointer {
+	return &mockShardCheckpointer{
+		checkpoints: make(map[string]string),
+		owners:      make(map[string]string),
+	}
+}
+
+func (m *mockShardCheckpointer) Init() error {
+	return nil
+}
+
+func (m *mockShardCheckpointer) GetLease(shard *Status, newAssignTo string) error {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+	if owner, ok := m.owners[shard.ID]; ok && owner != "" && owner != newAssignTo {
+		return errors.New(ErrLeaseNotAquired)
+	}
+	m.owners[shard.ID] = newAssignTo
+	shard.Mux.Lock()
+	shard.AssignedTo = newAssignTo
+	shard.LeaseTimeout = time.Now().Add(time.Hour)
+	shard.Mux.Unlock()
+	return nil
+}
+
+func (m *mockShardCheckpointer) CheckpointSequence(shard *Status) error {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+	m.checkpoints[shard.ID] = shard.Checkpoint
+	m.history = append(m.history, shard.Checkpoint)
+	return nil
+}
+
+func (m *mockShardCheckpointer) FetchCheckpoint(shard *Status) error {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+	checkpoint, ok := m.checkpoints[shard.ID]
+	if !ok {
+		return ErrSequenceIDNotFound
+	}
+	shard.Mux.Lock()
+	shard.Checkpoint = checkpoint
+	shard.Mux.Unlock()
+	return nil
+}
+
+func (m *mockShardCheckpointer) RemoveLeaseInfo(shardID string) error {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+	delete(m.checkpoints, shardID)
+	delete(m.owners, shardID)
+	return nil
+}
+
+func (m *mockShardCheckpointer) RemoveLeaseOwner(shardID string) error {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+	delete(m.owners, shardID)
+	return nil
+}
+
+// mockRecordProcessor checkpoints after every batch (unless skipCheckpoint is set) and at the end of the shard.
+// Processing a batch takes at least delay.
+type mockRecordProcessor struct {
+	mux             sync.Mutex
+	skipCheckpoint  bool
+	delay           time.Duration
+	records         []*kinesis.Record
+	shutdownReasons []util.ShutdownReason
+}
+
+func (m *mockRecordProcessor) Initialize(input *InitializationInput) {}
+
+func (m *mockRecordProcessor) ProcessRecords(input *record.ProcessRecordsInput) {
+	if len(input.Records) == 0 {
+		return
+	}
+	time.Sleep(m.delay)
+	m.mux.Lock()
+	m.records = append(m.records, input.Records...)
+	m.mux.Unlock()
+	if !m.skipCheckpoint {
+		input.Checkpointer.Checkpoint(input.Records[len(input.Records)-1].SequenceNumber)
+	}
+}
+
+func (m *mockRecordProcessor) Shutdown(input *util.ShutdownInput) {
+	m.mux.Lock()
+	m.shutdownReasons = append(m.shutdownReasons, input.ShutdownReason)
+	m.mux.Unlock()
+	if input.ShutdownReason == util.TERMINATE {
+		input.Checkpointer.Checkpoint(nil)
+	}
+}
+
+func (m *mockRecordProcessor) sequenceNumbers() []string {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+	seqs := make([]string, 0, len(m.records))
+	for _, r := range m.records {
+		seqs = append(seqs, aws.StringValue(r.SequenceNumber))
+	}
+	return seqs
+}
+
+// mockMonitoringService counts the metrics asserted by the tests and ignores the others.
+type mockMonitoringService struct {
+	util.MonitoringService
+	mux              sync.Mutex
+	invalidRecords   int
+	expiredIterators int
+	consumerRestarts int
+	recordAges       []float64
+	duplicateRecords int
+	uncheckpointed   []int
+	renewalFailures  int
+	leasesLost       int
+}
+
+func newMockMonitoringService() *mockMonitoringService {
+	metricsConfig := &util.MonitoringConfiguration{MonitoringService: ""}
+	metricsConfig.Init("appName", "test", "abc")
+	return &mockMonitoringService{MonitoringService: metricsConfig.GetMonitoringService()}
+}
+
+func (m *mockMonitoringService) IncrInvalidRecords(shard string, count int) {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+	m.invalidRecords += count
+}
+
+func (m *mockMonitoringService) IncrLeasesLost(shard string) {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+	m.leasesLost++
+}
+
+func (m *mockMonitoringService) IncrExpiredIterators(shard string) {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+	m.expiredIterators++
+}
+
+func (m *mockMonitoringService) IncrConsumerRestarts(shard string) {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+	m.consumerRestarts++
+}
+
+func (m *mockMonitoringService) RecordAge(shard string, millis float64) {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+	m.recordAges = append(m.recordAges, millis)
+}
+
+func (m *mockMonitoringService) IncrDuplicateRecords(shard string, count int) {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+	m.duplicateRecords += count
+}
+
+func (m *mockMonitoringService) IncrLeaseRenewalFailures(shard string) {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+	m.renewalFailures++
+}
+
+func (m *mockMonitoringService) UncheckpointedRecords(shard string, count int) {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+	m.uncheckpointed = append(m.uncheckpointed, count)
+}
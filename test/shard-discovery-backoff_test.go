@@ -0,0 +1,46 @@
+package goKCL
+
+import (
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/kinesis"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/guygma/goKCL/shard"
+)
+
+func TestShardDiscoveryBackoff(t *testing.T) {
+	parent := mockShard("shardId-0", "0", "340282366920938463463374607431768211455")
+	kc := &mockKinesis{shards: []*kinesis.Shard{parent}}
+	kclConfig := NewKinesisClientLibConfig("appName", "test", "us-west-2", "abc").
+		WithShardDiscoveryBackoff(1000, 8000)
+	w := NewWorker(nil, kclConfig, nil).WithKinesis(kc).WithCheckpointer(newMemoryLeaseStore(time.Minute))
+	w.shardStatus = make(map[string]*shard.Status)
+
+	now := time.Now()
+	assert.Nil(t, w.discoverShards(now))
+	assert.Equal(t, 1, len(w.shardStatus))
+	assert.Equal(t, time.Second, w.discoveryInterval)
+
+	// the interval widens while the shards are stable, up to the maximum
+	for _, expected := range []time.Duration{2 * time.Second, 4 * time.Second, 8 * time.Second, 8 * time.Second} {
+		now = w.nextDiscovery
+		assert.Nil(t, w.discoverShards(now))
+		assert.Equal(t, expected, w.discoveryInterval)
+	}
+
+	// a reshard isn't discovered before the discovery is due
+	parent.SequenceNumberRange.EndingSequenceNumber = aws.String("99")
+	child := mockShard("shardId-1", "0", "340282366920938463463374607431768211455")
+	child.ParentShardId = aws.String("shardId-0")
+	kc.shards = append(kc.shards, child)
+	assert.Nil(t, w.discoverShards(now.Add(time.Second)))
+	assert.Equal(t, 1, len(w.shardStatus))
+
+	// the interval tightens once the reshard is discovered
+	assert.Nil(t, w.discoverShards(w.nextDiscovery))
+	assert.Equal(t, 2, len(w.shardStatus))
+	assert.Equal(t, time.Second, w.discoveryInterval)
+}
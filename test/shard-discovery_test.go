@@ -0,0 +1,93 @@
+package goKCL
+
+import (
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/kinesis"
+	"github.com/aws/aws-sdk-go/service/kinesis/kinesisiface"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/guygma/goKCL/shard"
+)
+
+func TestShardHashKeyRange(t *testing.T) {
+	kc := &mockKinesis{
+		shards: []*kinesis.Shard{
+			mockShard("shardId-0", "0", "170141183460469231731687303715884105727"),
+			mockShard("shardId-1", "170141183460469231731687303715884105728", "340282366920938463463374607431768211455"),
+		},
+	}
+	w := NewWorker(nil, NewKinesisClientLibConfig("appName", "test", "us-west-2", "abc"), nil).WithKinesis(kc)
+	w.shardStatus = make(map[string]*shard.Status)
+
+	err := w.getShardIDs("", make(map[string]bool))
+	assert.Nil(t, err)
+
+	start, end := w.shardStatus["shardId-0"].GetHashKeyRange()
+	assert.Equal(t, "0", start)
+	assert.Equal(t, "170141183460469231731687303715884105727", end)
+
+	start, end = w.shardStatus["shardId-1"].GetHashKeyRange()
+	assert.Equal(t, "170141183460469231731687303715884105728", start)
+	assert.Equal(t, "340282366920938463463374607431768211455", end)
+}
+
+func mockShard(id, startingHashKey, endingHashKey string) *kinesis.Shard {
+	return &kinesis.Shard{
+		ShardId: aws.String(id),
+		HashKeyRange: &kinesis.HashKeyRange{
+			StartingHashKey: aws.String(startingHashKey),
+			EndingHashKey:   aws.String(endingHashKey),
+		},
+		SequenceNumberRange: &kinesis.SequenceNumberRange{
+			StartingSequenceNumber: aws.String("1"),
+		},
+	}
+}
+
+type mockKinesis struct {
+	kinesisiface.KinesisAPI
+	shards []*kinesis.Shard
+	// retentionHours of the stream, 24 if not set
+	retentionHours int64
+	summaryCalls   int
+}
+
+func (m *mockKinesis) DescribeStream(input *kinesis.DescribeStreamInput) (*kinesis.DescribeStreamOutput, error) {
+	return &kinesis.DescribeStreamOutput{
+		StreamDescription: &kinesis.StreamDescription{
+			StreamName:    input.StreamName,
+			StreamStatus:  aws.String("ACTIVE"),
+			Shards:        m.shards,
+			HasMoreShards: aws.Bool(false),
+		},
+	}, nil
+}
+
+// GetShardIterator and GetRecords serve open, empty shards.
+func (m *mockKinesis) GetShardIterator(input *kinesis.GetShardIteratorInput) (*kinesis.GetShardIteratorOutput, error) {
+	return &kinesis.GetShardIteratorOutput{ShardIterator: aws.String("iterator")}, nil
+}
+
+func (m *mockKinesis) GetRecords(input *kinesis.GetRecordsInput) (*kinesis.GetRecordsOutput, error) {
+	return &kinesis.GetRecordsOutput{
+		NextShardIterator:  input.ShardIterator,
+		MillisBehindLatest: aws.Int64(0),
+	}, nil
+}
+
+func (m *mockKinesis) DescribeStreamSummary(input *kinesis.DescribeStreamSummaryInput) (*kinesis.DescribeStreamSummaryOutput, error) {
+	m.summaryCalls++
+	retentionHours := m.retentionHours
+	if retentionHours == 0 {
+		retentionHours = 24
+	}
+	return &kinesis.DescribeStreamSummaryOutput{
+		StreamDescriptionSummary: &kinesis.StreamDescriptionSummary{
+			StreamName:           input.StreamName,
+			StreamStatus:         aws.String("ACTIVE"),
+			RetentionPeriodHours: aws.Int64(retentionHours),
+		},
+	}, nil
+}
@@ -0,0 +1,64 @@
+package shard
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/kinesis"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/guygma/goKCL/util"
+)
+
+func TestShardIteratorAcquisitionRetried(t *testing.T) {
+	kc := &failingIteratorKinesis{mockKinesisClient: newMockKinesisClient(3, true), failures: 2}
+	processor := &mockRecordProcessor{}
+	sc := newTestConsumer(kc, newMockShardCheckpointer(), processor, testConfig().WithShardIteratorRetries(2, 1))
+
+	err := sc.GetRecords(testShard())
+	assert.Nil(t, err)
+
+	// the shard proceeds once the iterator is acquired
+	assert.Equal(t, 3, kc.calls)
+	assert.Equal(t, []string{"1", "2", "3"}, processor.sequenceNumbers())
+}
+
+func TestShardIteratorAcquisitionFails(t *testing.T) {
+	kc := &failingIteratorKinesis{mockKinesisClient: newMockKinesisClient(3, true), failures: 5}
+	checkpointer := newMockShardCheckpointer()
+	listener := &mockEventListener{}
+	processor := &mockRecordProcessor{}
+	sc := newTestConsumer(kc, checkpointer, processor, testConfig().
+		WithShardIteratorRetries(2, 1).
+		WithEventListener(listener))
+	shard := testShard()
+	checkpointer.owners[shard.ID] = "abc"
+
+	err := sc.GetRecords(shard)
+	assert.True(t, errors.Is(err, util.ThrottlingError.MakeErr()))
+	assert.Equal(t, 3, kc.calls)
+	assert.Empty(t, processor.sequenceNumbers())
+
+	// the failure is reported and the lease released rather than the shard stalling
+	events := listener.received()
+	assert.Equal(t, 1, len(events))
+	assert.Equal(t, util.EVENT_SHARD_ITERATOR_FAILED, events[0].Type)
+	assert.Equal(t, "", checkpointer.owners[shard.ID])
+}
+
+// failingIteratorKinesis throttles the given number of GetShardIterator calls.
+type failingIteratorKinesis struct {
+	*mockKinesisClient
+	failures int
+	calls    int
+}
+
+func (m *failingIteratorKinesis) GetShardIterator(input *kinesis.GetShardIteratorInput) (*kinesis.GetShardIteratorOutput, error) {
+	m.calls++
+	if m.failures > 0 {
+		m.failures--
+		return nil, awserr.New(kinesis.ErrCodeProvisionedThroughputExceededException, "Rate exceeded for shard", nil)
+	}
+	return m.mockKinesisClient.GetShardIterator(input)
+}
@@ -0,0 +1,68 @@
+package goKCL
+
+import (
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/kinesis"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/guygma/goKCL/shard"
+	"github.com/guygma/goKCL/util"
+)
+
+func TestShardListingThrottling(t *testing.T) {
+	kc := &throttledKinesis{
+		mockKinesis: mockKinesis{shards: []*kinesis.Shard{
+			mockShard("shardId-0", "0", "340282366920938463463374607431768211455"),
+		}},
+		throttles: 2,
+	}
+	mService := &shardListingMonitoringService{}
+	kclConfig := NewKinesisClientLibConfig("appName", "test", "us-west-2", "abc").WithShardListingRetries(3, 1)
+	w := NewWorker(nil, kclConfig, nil).WithKinesis(kc).WithCheckpointer(newMemoryLeaseStore(time.Minute))
+	w.mService = mService
+	w.shardStatus = make(map[string]*shard.Status)
+
+	// the discovery backs off and succeeds once the listing isn't throttled anymore
+	assert.Nil(t, w.syncShard())
+	assert.Equal(t, 3, kc.calls)
+	assert.Equal(t, 1, len(w.shardStatus))
+	assert.Equal(t, 2, mService.throttles["test"])
+
+	// the discovery fails once the retries are exhausted
+	kc.calls, kc.throttles = 0, 10
+	err := w.syncShard()
+	assert.NotNil(t, err)
+	assert.Equal(t, kinesis.ErrCodeLimitExceededException, err.(awserr.Error).Code())
+	assert.Equal(t, 4, kc.calls)
+}
+
+// throttledKinesis fails the given number of DescribeStream calls with LimitExceededException.
+type throttledKinesis struct {
+	mockKinesis
+	throttles int
+	calls     int
+}
+
+func (m *throttledKinesis) DescribeStream(input *kinesis.DescribeStreamInput) (*kinesis.DescribeStreamOutput, error) {
+	m.calls++
+	if m.throttles > 0 {
+		m.throttles--
+		return nil, awserr.New(kinesis.ErrCodeLimitExceededException, "Rate exceeded for stream", nil)
+	}
+	return m.mockKinesis.DescribeStream(input)
+}
+
+type shardListingMonitoringService struct {
+	util.MonitoringService
+	throttles map[string]int
+}
+
+func (m *shardListingMonitoringService) IncrShardListingThrottles(stream string) {
+	if m.throttles == nil {
+		m.throttles = make(map[string]int)
+	}
+	m.throttles[stream]++
+}
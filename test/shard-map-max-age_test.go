@@ -0,0 +1,100 @@
+package goKCL
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/service/kinesis"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/guygma/goKCL/shard"
+	"github.com/guygma/goKCL/util"
+)
+
+func TestStaleShardMap(t *testing.T) {
+	kc := &failingDiscoveryKinesis{mockKinesis: &mockKinesis{shards: []*kinesis.Shard{
+		mockShard("shardId-0", "0", "170141183460469231731687303715884105727"),
+		mockShard("shardId-1", "170141183460469231731687303715884105728", "340282366920938463463374607431768211455"),
+	}}}
+	store := newMemoryLeaseStore(10 * time.Second)
+	// the lease of the second shard is held by a peer, for a little while
+	store.owners["shardId-1"] = "worker-b"
+	store.leaseTimeouts["shardId-1"] = time.Now().Add(200 * time.Millisecond)
+
+	listener := &recordingEventListener{}
+	factory := &shutdownRecordingFactory{}
+	kclConfig := NewKinesisClientLibConfig("appName", "test", "us-west-2", "worker-a").
+		WithFailoverTimeMillis(10000).
+		WithShardSyncIntervalMillis(10).
+		WithIdleTimeBetweenReadsInMillis(10).
+		WithShardMapMaxAgeMillis(50).
+		WithEventListener(listener)
+	worker := NewWorker(factory, kclConfig, nil).WithKinesis(kc).WithCheckpointer(store)
+	assert.Nil(t, worker.Start())
+	defer worker.Shutdown()
+	assert.True(t, store.waitForOwner("worker-a", time.Second, "shardId-0"))
+
+	// the shard discovery fails past the max age of the shard map while the lease of the peer expires
+	kc.setFailing(true)
+	time.Sleep(400 * time.Millisecond)
+
+	// the expired lease isn't taken from the stale shard map, the owned shard keeps being processed
+	assert.False(t, store.waitForOwner("worker-a", 10*time.Millisecond, "shardId-1"))
+	assert.True(t, store.waitForOwner("worker-a", 10*time.Millisecond, "shardId-0"))
+	assert.Empty(t, factory.shutdownReasons())
+	events := listener.received()
+	assert.Equal(t, 1, len(events))
+	assert.Equal(t, util.WARNING, events[0].Severity)
+	assert.Equal(t, util.EVENT_SHARD_MAP_STALE, events[0].Type)
+
+	// the lease is taken once the shards are discovered again
+	kc.setFailing(false)
+	assert.True(t, store.waitForOwner("worker-a", time.Second, "shardId-0", "shardId-1"))
+}
+
+func TestShardMapMaxAge(t *testing.T) {
+	kc := &failingDiscoveryKinesis{mockKinesis: &mockKinesis{shards: []*kinesis.Shard{
+		mockShard("shardId-0", "0", "340282366920938463463374607431768211455"),
+	}}}
+	w := NewWorker(nil, NewKinesisClientLibConfig("appName", "test", "us-west-2", "abc").
+		WithShardMapMaxAgeMillis(1000), nil).WithKinesis(kc)
+	w.shardStatus = make(map[string]*shard.Status)
+
+	// nothing was discovered yet
+	assert.True(t, w.shardMapStale(time.Now()))
+
+	assert.Nil(t, w.syncShard())
+	kc.setFailing(true)
+	assert.NotNil(t, w.syncShard())
+	assert.False(t, w.shardMapStale(w.lastShardSync.Add(time.Second)))
+	assert.True(t, w.shardMapStale(w.lastShardSync.Add(2*time.Second)))
+
+	// with no max age, the shard map is stale as soon as a discovery fails
+	w.kclConfig.ShardMapMaxAgeMillis = 0
+	assert.True(t, w.shardMapStale(w.lastShardSync.Add(time.Millisecond)))
+}
+
+// failingDiscoveryKinesis is a mockKinesis whose shard discovery fails on demand.
+type failingDiscoveryKinesis struct {
+	*mockKinesis
+	mux     sync.Mutex
+	failing bool
+}
+
+func (m *failingDiscoveryKinesis) DescribeStream(input *kinesis.DescribeStreamInput) (*kinesis.DescribeStreamOutput, error) {
+	m.mux.Lock()
+	failing := m.failing
+	m.mux.Unlock()
+	if failing {
+		return nil, errors.New("discovery failed")
+	}
+	return m.mockKinesis.DescribeStream(input)
+}
+
+func (m *failingDiscoveryKinesis) setFailing(failing bool) {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+	m.failing = failing
+}
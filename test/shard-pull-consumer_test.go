@@ -0,0 +1,71 @@
+package shard
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/guygma/goKCL/record"
+)
+
+func TestShardConsumerPullAndAck(t *testing.T) {
+	checkpointer := newMockShardCheckpointer()
+	pc := record.NewShardConsumer(1)
+	sc := newTestConsumer(newMockKinesisClient(3, true), checkpointer, pc, testConfig().WithMaxRecords(2))
+
+	done := make(chan error)
+	go func() { done <- sc.GetRecords(testShard()) }()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	for _, seq := range []string{"1", "2", "3"} {
+		r, ack, err := pc.Next(ctx)
+		assert.Nil(t, err)
+		assert.Equal(t, seq, aws.StringValue(r.SequenceNumber))
+		assert.Equal(t, "0001", pc.ShardID())
+
+		// every Ack advances the checkpoint to the record pulled with it
+		assert.Nil(t, ack())
+		assert.Equal(t, seq, pullCheckpoint(checkpointer))
+	}
+
+	// the end of the shard is checkpointed once all its record have been Ack'd, and signaled by Next
+	_, ack, err := pc.Next(ctx)
+	assert.Equal(t, record.ErrShardEnd, err)
+	assert.Nil(t, ack)
+	assert.Nil(t, <-done)
+	assert.Equal(t, []string{"1", "2", "3", SHARD_END}, checkpointer.history)
+}
+
+func TestShardConsumerAckInShardOrder(t *testing.T) {
+	checkpointer := newMockShardCheckpointer()
+	pc := record.NewShardConsumer(3)
+	sc := newTestConsumer(newMockKinesisClient(3, false), checkpointer, pc, testConfig().WithMaxRecords(3))
+	go sc.GetRecords(testShard())
+	defer close(*sc.stop)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	var acks []record.Ack
+	for range []string{"1", "2", "3"} {
+		_, ack, err := pc.Next(ctx)
+		assert.Nil(t, err)
+		acks = append(acks, ack)
+	}
+
+	// the Ack of a record checkpoints the record pulled before it too, their own Ack becoming a no-op
+	assert.Nil(t, acks[1]())
+	assert.Nil(t, acks[0]())
+	assert.Nil(t, acks[2]())
+	assert.Equal(t, []string{"2", "3"}, checkpointer.history)
+}
+
+// pullCheckpoint returns the checkpoint of the test shard.
+func pullCheckpoint(checkpointer *mockShardCheckpointer) string {
+	checkpointer.mux.Lock()
+	defer checkpointer.mux.Unlock()
+	return checkpointer.checkpoints["0001"]
+}
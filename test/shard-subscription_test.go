@@ -0,0 +1,94 @@
+package shard
+
+import (
+	"io/ioutil"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/kinesis"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestConsumerReadsSubscription(t *testing.T) {
+	kc := &subscribingKinesis{
+		mockKinesisClient: newMockKinesisClient(0, true),
+		// the first subscription expires after a batch, the second one reaches the end of the shard
+		subscriptions: [][]*kinesis.SubscribeToShardEvent{
+			{subscriptionEvent("2", "1", "2")},
+			{subscriptionEvent("3", "3"), subscriptionEvent("", "4")},
+		},
+	}
+	checkpointer := newMockShardCheckpointer()
+	processor := &mockRecordProcessor{}
+	sc := newTestConsumer(kc, checkpointer, processor, testConfig())
+	sc.consumerARN = "arn:consumer"
+
+	err := sc.GetRecords(testShard())
+	assert.Nil(t, err)
+
+	assert.Equal(t, []string{"1", "2", "3", "4"}, processor.sequenceNumbers())
+	assert.Equal(t, SHARD_END, checkpointer.checkpoints["0001"])
+	assert.Empty(t, kc.iteratorRequests)
+	assert.Equal(t, 0, kc.getRecordsCalls)
+
+	// the first subscription starts at the initial position, the renewal after the continuation sequence number
+	assert.Equal(t, 2, len(kc.requests))
+	assert.Equal(t, "arn:consumer", aws.StringValue(kc.requests[0].ConsumerARN))
+	assert.Equal(t, "TRIM_HORIZON", aws.StringValue(kc.requests[0].StartingPosition.Type))
+	assert.Equal(t, "AFTER_SEQUENCE_NUMBER", aws.StringValue(kc.requests[1].StartingPosition.Type))
+	assert.Equal(t, "2", aws.StringValue(kc.requests[1].StartingPosition.SequenceNumber))
+}
+
+func subscriptionEvent(continuation string, sequenceNumbers ...string) *kinesis.SubscribeToShardEvent {
+	event := &kinesis.SubscribeToShardEvent{MillisBehindLatest: aws.Int64(0)}
+	if continuation != "" {
+		event.ContinuationSequenceNumber = aws.String(continuation)
+	}
+	for _, sequenceNumber := range sequenceNumbers {
+		event.Records = append(event.Records, &kinesis.Record{
+			Data:           []byte("data-" + sequenceNumber),
+			PartitionKey:   aws.String("key"),
+			SequenceNumber: aws.String(sequenceNumber),
+		})
+	}
+	return event
+}
+
+// subscribingKinesis pushes the given events to the successive subscriptions.
+type subscribingKinesis struct {
+	*mockKinesisClient
+	subscriptions [][]*kinesis.SubscribeToShardEvent
+	requests      []*kinesis.SubscribeToShardInput
+}
+
+func (m *subscribingKinesis) SubscribeToShard(input *kinesis.SubscribeToShardInput) (*kinesis.SubscribeToShardOutput, error) {
+	m.requests = append(m.requests, input)
+	events := make(chan kinesis.SubscribeToShardEventStreamEvent, len(m.subscriptions[0]))
+	for _, event := range m.subscriptions[0] {
+		events <- event
+	}
+	close(events)
+	m.subscriptions = m.subscriptions[1:]
+
+	return &kinesis.SubscribeToShardOutput{EventStream: &kinesis.SubscribeToShardEventStream{
+		Reader:       &eventStreamReader{events: events},
+		StreamCloser: ioutil.NopCloser(strings.NewReader("")),
+	}}, nil
+}
+
+type eventStreamReader struct {
+	events chan kinesis.SubscribeToShardEventStreamEvent
+}
+
+func (r *eventStreamReader) Events() <-chan kinesis.SubscribeToShardEventStreamEvent {
+	return r.events
+}
+
+func (r *eventStreamReader) Close() error {
+	return nil
+}
+
+func (r *eventStreamReader) Err() error {
+	return nil
+}
@@ -0,0 +1,93 @@
+package shard
+
+import (
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/guygma/goKCL"
+)
+
+func TestSharedLeaseTable(t *testing.T) {
+	svc := &prefixScanLeaseTable{lagLeaseTable{items: make(map[string]map[string]*dynamodb.AttributeValue)}}
+	orders := NewDynamoCheckpoint(sharedLeaseTableConfig("orders")).WithDynamoDB(svc)
+	payments := NewDynamoCheckpoint(sharedLeaseTableConfig("payments")).WithDynamoDB(svc)
+
+	// both applications lease the same shard of the stream from different workers
+	ordersShard := &Status{ID: "shardId-0", Mux: &sync.Mutex{}}
+	assert.Nil(t, orders.GetLease(ordersShard, "orders-worker"))
+	ordersShard.Checkpoint = "5"
+	assert.Nil(t, orders.CheckpointSequence(ordersShard))
+	paymentsShard := &Status{ID: "shardId-0", Mux: &sync.Mutex{}}
+	assert.Nil(t, payments.GetLease(paymentsShard, "payments-worker"))
+	assert.Contains(t, svc.items, "orders#shardId-0")
+	assert.Contains(t, svc.items, "payments#shardId-0")
+
+	fetched := &Status{ID: "shardId-0", Mux: &sync.Mutex{}}
+	assert.Nil(t, orders.FetchCheckpoint(fetched))
+	assert.Equal(t, "5", fetched.Checkpoint)
+	assert.Equal(t, ErrSequenceIDNotFound, payments.FetchCheckpoint(fetched))
+
+	// each scans its own leases only
+	leases, err := payments.GetLeases()
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(leases))
+	assert.Equal(t, "shardId-0", leases[0].ShardID)
+	assert.Equal(t, "payments-worker", leases[0].Owner)
+
+	// and removes its own leases only
+	assert.Nil(t, payments.RemoveLeaseInfo("shardId-0"))
+	leases, err = orders.GetLeases()
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(leases))
+	assert.Equal(t, "orders-worker", leases[0].Owner)
+
+	// the streams of a multi-stream worker are namespaced within the application
+	stream := orders.WithLeaseKeyPrefix("123456789012:orders:")
+	streamShard := &Status{ID: "shardId-1", Mux: &sync.Mutex{}}
+	assert.Nil(t, stream.GetLease(streamShard, "orders-worker"))
+	assert.Contains(t, svc.items, "orders#123456789012:orders:shardId-1")
+}
+
+func TestSharedLeaseTableValidation(t *testing.T) {
+	kclConfig := sharedLeaseTableConfig("orders.v2")
+	assert.True(t, kclConfig.SharedLeaseTable)
+	assert.Equal(t, "leases", kclConfig.TableName)
+
+	assert.Panics(t, func() { sharedLeaseTableConfig("orders#v2") })
+	assert.Panics(t, func() { sharedLeaseTableConfig("orders v2") })
+	assert.Panics(t, func() { testConfig().WithSharedLeaseTable("") })
+}
+
+func sharedLeaseTableConfig(applicationName string) *goKCL.KinesisClientLibConfiguration {
+	return goKCL.NewKinesisClientLibConfig(applicationName, "test", "us-west-2", "abc").
+		WithSharedLeaseTable("leases")
+}
+
+// prefixScanLeaseTable is a lease table evaluating the lease key prefix filter of the scans, and deleting items.
+type prefixScanLeaseTable struct {
+	lagLeaseTable
+}
+
+func (m *prefixScanLeaseTable) ScanPages(input *dynamodb.ScanInput, fn func(*dynamodb.ScanOutput, bool) bool) error {
+	output := &dynamodb.ScanOutput{}
+	for key, item := range m.items {
+		if input.FilterExpression != nil {
+			if !strings.HasPrefix(key, aws.StringValue(input.ExpressionAttributeValues[":prefix"].S)) {
+				continue
+			}
+		}
+		output.Items = append(output.Items, item)
+	}
+	fn(output, true)
+	return nil
+}
+
+func (m *prefixScanLeaseTable) DeleteItem(input *dynamodb.DeleteItemInput) (*dynamodb.DeleteItemOutput, error) {
+	delete(m.items, aws.StringValue(input.Key[LEASE_KEY_KEY].S))
+	return &dynamodb.DeleteItemOutput{}, nil
+}
@@ -0,0 +1,67 @@
+package shard
+
+import (
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/kinesis"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/guygma/goKCL/record"
+	"github.com/guygma/goKCL/util"
+)
+
+func TestCheckpointOnShutdownPerReason(t *testing.T) {
+	kclConfig := testConfig().
+		WithCheckpointOnShutdown(util.TERMINATE, true).
+		WithCheckpointOnShutdown(util.REQUESTED, false)
+
+	for reason, expected := range map[util.ShutdownReason][]string{
+		util.TERMINATE: {"7", SHARD_END},
+		util.REQUESTED: nil,
+		util.ZOMBIE:    nil,
+	} {
+		checkpointer := newMockShardCheckpointer()
+		processor := &mockRecordProcessor{skipCheckpoint: true}
+		sc := newTestConsumer(nil, checkpointer, processor, kclConfig)
+
+		sh := testShard()
+		sc.shutdownProcessor(sh, reason, record.NewRecordProcessorCheckpoint(sh, checkpointer),
+			&kinesis.Record{SequenceNumber: aws.String("7")})
+
+		assert.Equal(t, expected, checkpointer.history, aws.StringValue(util.ShutdownReasonMessage(reason)))
+		assert.Equal(t, []util.ShutdownReason{reason}, processor.shutdownReasons)
+	}
+}
+
+func TestCheckpointOnRequestedShutdown(t *testing.T) {
+	checkpointer := newMockShardCheckpointer()
+	sc := newTestConsumer(nil, checkpointer, &mockRecordProcessor{skipCheckpoint: true},
+		testConfig().WithCheckpointOnShutdown(util.REQUESTED, true))
+
+	sh := testShard()
+	sc.shutdownProcessor(sh, util.REQUESTED, record.NewRecordProcessorCheckpoint(sh, checkpointer),
+		&kinesis.Record{SequenceNumber: aws.String("7")})
+	assert.Equal(t, []string{"7"}, checkpointer.history)
+
+	// nothing was processed, nothing to checkpoint
+	checkpointer = newMockShardCheckpointer()
+	sc.shutdownProcessor(sh, util.REQUESTED, record.NewRecordProcessorCheckpoint(sh, checkpointer), nil)
+	assert.Empty(t, checkpointer.history)
+}
+
+func TestCheckpointOnTerminate(t *testing.T) {
+	kc := newMockKinesisClient(5, true)
+	checkpointer := newMockShardCheckpointer()
+	sc := newTestConsumer(kc, checkpointer, &mockRecordProcessor{skipCheckpoint: true}, testConfig().
+		WithMaxRecords(2).
+		WithCheckpointOnShutdown(util.TERMINATE, true))
+
+	assert.Nil(t, sc.GetRecords(testShard()))
+	assert.Equal(t, []string{"5", SHARD_END}, checkpointer.history)
+}
+
+func TestCheckpointOnZombieShutdownRejected(t *testing.T) {
+	assert.Panics(t, func() { testConfig().WithCheckpointOnShutdown(util.ZOMBIE, true) })
+	assert.NotPanics(t, func() { testConfig().WithCheckpointOnShutdown(util.ZOMBIE, false) })
+}
@@ -0,0 +1,93 @@
+package shard
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/guygma/goKCL/util"
+)
+
+func TestShutdownHooks(t *testing.T) {
+	hook := &recordingShutdownHook{}
+	checkpointer := newMockShardCheckpointer()
+	sc := newTestConsumer(newMockKinesisClient(3, true), checkpointer, &mockRecordProcessor{skipCheckpoint: true},
+		testConfig().
+			WithCheckpointOnShutdown(util.TERMINATE, true).
+			WithShutdownHook(hook))
+
+	assert.Nil(t, sc.GetRecords(testShard()))
+	assert.Equal(t, []string{
+		"before TERMINATE STOP_FETCHING", "after TERMINATE STOP_FETCHING",
+		"before TERMINATE CHECKPOINT", "after TERMINATE CHECKPOINT",
+		"before TERMINATE SHUTDOWN_PROCESSOR", "after TERMINATE SHUTDOWN_PROCESSOR",
+		"before TERMINATE RELEASE_LEASE", "after TERMINATE RELEASE_LEASE",
+	}, hook.steps())
+	assert.Equal(t, []string{"3", SHARD_END}, checkpointer.history)
+	assert.Empty(t, checkpointer.owners["0001"])
+}
+
+func TestShutdownOrderCheckpointAfterProcessor(t *testing.T) {
+	hook := &recordingShutdownHook{}
+	checkpointer := newMockShardCheckpointer()
+	sc := newTestConsumer(newMockKinesisClient(3, true), checkpointer, &mockRecordProcessor{skipCheckpoint: true},
+		testConfig().
+			WithCheckpointOnShutdown(util.TERMINATE, true).
+			WithShutdownOrder(util.STOP_FETCHING, util.SHUTDOWN_PROCESSOR, util.CHECKPOINT, util.RELEASE_LEASE).
+			WithShutdownHook(hook))
+
+	assert.Nil(t, sc.GetRecords(testShard()))
+	assert.Equal(t, []string{
+		"before TERMINATE STOP_FETCHING", "after TERMINATE STOP_FETCHING",
+		"before TERMINATE SHUTDOWN_PROCESSOR", "after TERMINATE SHUTDOWN_PROCESSOR",
+		"before TERMINATE CHECKPOINT", "after TERMINATE CHECKPOINT",
+		"before TERMINATE RELEASE_LEASE", "after TERMINATE RELEASE_LEASE",
+	}, hook.steps())
+
+	// the shard the record processor finished isn't checkpointed back to the last record
+	assert.Equal(t, []string{SHARD_END}, checkpointer.history)
+}
+
+func TestShutdownOrderValidation(t *testing.T) {
+	assert.NotPanics(t, func() {
+		testConfig().WithShutdownOrder(util.STOP_FETCHING, util.SHUTDOWN_PROCESSOR, util.CHECKPOINT, util.RELEASE_LEASE)
+	})
+	assert.Panics(t, func() {
+		testConfig().WithShutdownOrder(util.CHECKPOINT, util.STOP_FETCHING, util.SHUTDOWN_PROCESSOR, util.RELEASE_LEASE)
+	})
+	assert.Panics(t, func() {
+		testConfig().WithShutdownOrder(util.STOP_FETCHING, util.SHUTDOWN_PROCESSOR, util.RELEASE_LEASE, util.CHECKPOINT)
+	})
+	assert.Panics(t, func() {
+		testConfig().WithShutdownOrder(util.STOP_FETCHING, util.CHECKPOINT, util.CHECKPOINT, util.RELEASE_LEASE)
+	})
+	assert.Panics(t, func() { testConfig().WithShutdownOrder(util.STOP_FETCHING, util.RELEASE_LEASE) })
+}
+
+// recordingShutdownHook records the shutdown steps in the order the hook is called.
+type recordingShutdownHook struct {
+	mux    sync.Mutex
+	called []string
+}
+
+func (h *recordingShutdownHook) BeforeShutdownStep(shardID string, reason util.ShutdownReason, step util.ShutdownStep) {
+	h.record("before", reason, step)
+}
+
+func (h *recordingShutdownHook) AfterShutdownStep(shardID string, reason util.ShutdownReason, step util.ShutdownStep) {
+	h.record("after", reason, step)
+}
+
+func (h *recordingShutdownHook) record(when string, reason util.ShutdownReason, step util.ShutdownStep) {
+	h.mux.Lock()
+	defer h.mux.Unlock()
+	h.called = append(h.called, fmt.Sprintf("%s %s %s", when, *util.ShutdownReasonMessage(reason), step))
+}
+
+func (h *recordingShutdownHook) steps() []string {
+	h.mux.Lock()
+	defer h.mux.Unlock()
+	return append([]string(nil), h.called...)
+}
@@ -0,0 +1,56 @@
+package shard
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/guygma/goKCL"
+)
+
+func TestStartingPositionFromCheckpoint(t *testing.T) {
+	checkpointer := newMockShardCheckpointer()
+	checkpointer.checkpoints["0001"] = "2"
+	sc := newTestConsumer(newMockKinesisClient(3, true), checkpointer, &mockRecordProcessor{}, testConfig())
+	shard := testShard()
+
+	err := sc.GetRecords(shard)
+	assert.Nil(t, err)
+
+	position := shard.GetStartingPosition()
+	assert.Equal(t, "0001", position.ShardID)
+	assert.Equal(t, "AFTER_SEQUENCE_NUMBER", position.IteratorType)
+	assert.Equal(t, "2", position.SequenceNumber)
+	assert.Equal(t, STARTED_FROM_CHECKPOINT, position.Reason)
+}
+
+func TestStartingPositionFromInitialPosition(t *testing.T) {
+	sc := newTestConsumer(newMockKinesisClient(3, true), newMockShardCheckpointer(), &mockRecordProcessor{},
+		testConfig().WithInitialPositionInStream(goKCL.LATEST))
+	shard := testShard()
+	assert.Equal(t, "", shard.GetStartingPosition().IteratorType)
+
+	err := sc.GetRecords(shard)
+	assert.Nil(t, err)
+
+	position := shard.GetStartingPosition()
+	assert.Equal(t, "LATEST", position.IteratorType)
+	assert.Equal(t, "", position.SequenceNumber)
+	assert.Equal(t, STARTED_FROM_INITIAL_POSITION, position.Reason)
+}
+
+func TestStartingPositionFromConfiguredSequenceNumber(t *testing.T) {
+	checkpointer := newMockShardCheckpointer()
+	checkpointer.checkpoints["0001"] = "1"
+	sc := newTestConsumer(newMockKinesisClient(6, true), checkpointer, &mockRecordProcessor{}, testConfig())
+	sc.startingSequenceNumber = &goKCL.StartingSequenceNumber{SequenceNumber: "4"}
+	shard := testShard()
+
+	err := sc.GetRecords(shard)
+	assert.Nil(t, err)
+
+	position := shard.GetStartingPosition()
+	assert.Equal(t, "AT_SEQUENCE_NUMBER", position.IteratorType)
+	assert.Equal(t, "4", position.SequenceNumber)
+	assert.Equal(t, STARTED_FROM_CONFIGURED_SEQUENCE_NUMBER, position.Reason)
+}
@@ -0,0 +1,70 @@
+package shard
+
+import (
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/kinesis"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/guygma/goKCL"
+)
+
+func TestStartingSequenceNumberOverridesCheckpoint(t *testing.T) {
+	kc := newMockKinesisClient(6, true)
+	checkpointer := newMockShardCheckpointer()
+	checkpointer.checkpoints["0001"] = "1"
+	processor := &mockRecordProcessor{}
+	sc := newTestConsumer(kc, checkpointer, processor, testConfig())
+	sc.startingSequenceNumber = &goKCL.StartingSequenceNumber{SequenceNumber: "4"}
+
+	err := sc.GetRecords(testShard())
+	assert.Nil(t, err)
+
+	assert.Equal(t, "AT_SEQUENCE_NUMBER", aws.StringValue(kc.iteratorRequests[0].ShardIteratorType))
+	assert.Equal(t, "4", aws.StringValue(kc.iteratorRequests[0].StartingSequenceNumber))
+	assert.Equal(t, []string{"4", "5", "6"}, processor.sequenceNumbers())
+}
+
+func TestStartingSequenceNumberAfter(t *testing.T) {
+	kc := newMockKinesisClient(6, true)
+	processor := &mockRecordProcessor{}
+	sc := newTestConsumer(kc, newMockShardCheckpointer(), processor, testConfig())
+	sc.startingSequenceNumber = &goKCL.StartingSequenceNumber{SequenceNumber: "4", After: true}
+
+	err := sc.GetRecords(testShard())
+	assert.Nil(t, err)
+
+	assert.Equal(t, "AFTER_SEQUENCE_NUMBER", aws.StringValue(kc.iteratorRequests[0].ShardIteratorType))
+	assert.Equal(t, []string{"5", "6"}, processor.sequenceNumbers())
+}
+
+func TestStartingSequenceNumberValidation(t *testing.T) {
+	kclConfig := testConfig().WithStartingSequenceNumber("0001", "49590338271490256608559692538361571095921575989136588898", false)
+	assert.Equal(t, "49590338271490256608559692538361571095921575989136588898",
+		kclConfig.StartingSequenceNumbers["0001"].SequenceNumber)
+
+	assert.Panics(t, func() { testConfig().WithStartingSequenceNumber("0001", "not-a-number", false) })
+	assert.Panics(t, func() { testConfig().WithStartingSequenceNumber("0001", "0123", true) })
+}
+
+func TestExpiredIteratorWithoutCheckpoint(t *testing.T) {
+	kc := newMockKinesisClient(6, true)
+	kc.getRecordsErrors = map[int]error{
+		2: awserr.New(kinesis.ErrCodeExpiredIteratorException, "Iterator expired", nil),
+	}
+	processor := &mockRecordProcessor{skipCheckpoint: true}
+	sc := newTestConsumer(kc, newMockShardCheckpointer(), processor, testConfig().WithMaxRecords(2))
+	sc.startingSequenceNumber = &goKCL.StartingSequenceNumber{SequenceNumber: "3"}
+
+	err := sc.GetRecords(testShard())
+	assert.Nil(t, err)
+
+	// nothing was checkpointed, the refreshed iterator resumes at the first record fetched rather than at the
+	// initial position in stream
+	assert.Equal(t, 2, len(kc.iteratorRequests))
+	assert.Equal(t, "AT_SEQUENCE_NUMBER", aws.StringValue(kc.iteratorRequests[1].ShardIteratorType))
+	assert.Equal(t, "3", aws.StringValue(kc.iteratorRequests[1].StartingSequenceNumber))
+	assert.Equal(t, []string{"3", "4", "3", "4", "5", "6"}, processor.sequenceNumbers())
+}
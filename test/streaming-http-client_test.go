@@ -0,0 +1,21 @@
+package util
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStreamingHTTPClient(t *testing.T) {
+	client := NewStreamingHTTPClient(10, 5*time.Second, 3*time.Second)
+
+	// the event streams outlive any request timeout, their connections are health checked instead
+	assert.Equal(t, time.Duration(0), client.Timeout)
+	transport := client.Transport.(*http.Transport)
+	assert.True(t, transport.ForceAttemptHTTP2)
+	assert.Equal(t, 5*time.Second, transport.HTTP2.SendPingTimeout)
+	assert.Equal(t, 3*time.Second, transport.HTTP2.PingTimeout)
+	assert.Equal(t, 10, transport.MaxConnsPerHost)
+}
@@ -0,0 +1,41 @@
+package record
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/guygma/goKCL/shard"
+	"github.com/guygma/goKCL/util"
+)
+
+func TestStrictCheckpointRejectsBackwardCheckpoint(t *testing.T) {
+	store := newMemoryCheckpointStore()
+	status := &shard.Status{ID: "0001", Mux: &sync.Mutex{}, Checkpoint: "10"}
+	checkpointer := NewStrictRecordProcessorCheckpoint(status, store)
+
+	err := checkpointer.Checkpoint(aws.String("9"))
+	assert.NotNil(t, err)
+	assert.Equal(t, util.IllegalArgumentError, err.(*util.ClientLibraryError).ErrorCode)
+	assert.Equal(t, "10", status.Checkpoint)
+	assert.Empty(t, store.checkpoints)
+
+	// sequence numbers are compared numerically
+	assert.Nil(t, checkpointer.Checkpoint(aws.String("100")))
+	assert.Equal(t, "100", store.checkpoints["0001"])
+
+	// only an explicit reset moves the checkpoint backwards
+	assert.Nil(t, checkpointer.(*RecordProcessorCheckpointer).ResetCheckpoint(aws.String("5")))
+	assert.Equal(t, "5", store.checkpoints["0001"])
+}
+
+func TestLenientCheckpointAcceptsBackwardCheckpoint(t *testing.T) {
+	store := newMemoryCheckpointStore()
+	status := &shard.Status{ID: "0001", Mux: &sync.Mutex{}, Checkpoint: "10"}
+	checkpointer := NewRecordProcessorCheckpoint(status, store)
+
+	assert.Nil(t, checkpointer.Checkpoint(aws.String("9")))
+	assert.Equal(t, "9", store.checkpoints["0001"])
+}
@@ -0,0 +1,48 @@
+package shard
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestThroughputConvergesOnSteadyRate(t *testing.T) {
+	var a throughputAverage
+	now := time.Now()
+	a.observe(0, now, 0.2)
+
+	// a burst followed by a steady 50 record per second
+	now = now.Add(100 * time.Millisecond)
+	a.observe(1000, now, 0.2)
+	assert.InDelta(t, 10000, a.rate, 0.001)
+
+	for i := 0; i < 100; i++ {
+		now = now.Add(100 * time.Millisecond)
+		a.observe(5, now, 0.2)
+	}
+	assert.InDelta(t, 50, a.rate, 0.01)
+}
+
+func TestThroughputSmoothing(t *testing.T) {
+	var a throughputAverage
+	now := time.Now()
+	a.observe(0, now, 0.5)
+	a.observe(10, now.Add(time.Second), 0.5)
+	a.observe(30, now.Add(2*time.Second), 0.5)
+
+	// half the latest 30 per second, half the previous average of 10
+	assert.InDelta(t, 20, a.rate, 0.001)
+}
+
+func TestShardThroughput(t *testing.T) {
+	sh := testShard()
+	now := time.Now()
+	sh.recordThroughput(0, now, 1)
+	assert.InDelta(t, 4, sh.recordThroughput(8, now.Add(2*time.Second), 1), 0.001)
+	assert.InDelta(t, 4, sh.GetThroughput(), 0.001)
+
+	// a restarted consumer starts a new average
+	sh.MarkConsumerStarted(now)
+	assert.Equal(t, 0.0, sh.GetThroughput())
+}
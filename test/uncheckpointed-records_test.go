@@ -0,0 +1,39 @@
+package shard
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestUncheckpointedRecords(t *testing.T) {
+	processor := &mockRecordProcessor{skipCheckpoint: true}
+	sc := newTestConsumer(newMockKinesisClient(5, true), newMockShardCheckpointer(), processor,
+		testConfig().WithMaxRecords(2))
+
+	// nothing is checkpointed before the end of the shard, every record delivered is outstanding
+	assert.Nil(t, sc.GetRecords(testShard()))
+	assert.Equal(t, []int{2, 4, 5}, sc.mService.(*mockMonitoringService).uncheckpointed)
+}
+
+func TestUncheckpointedRecordsCoveredByCheckpoint(t *testing.T) {
+	processor := &mockRecordProcessor{}
+	sc := newTestConsumer(newMockKinesisClient(5, true), newMockShardCheckpointer(), processor,
+		testConfig().WithMaxRecords(2))
+
+	// every batch is checkpointed once processed
+	assert.Nil(t, sc.GetRecords(testShard()))
+	assert.Equal(t, []int{0, 0, 0}, sc.mService.(*mockMonitoringService).uncheckpointed)
+}
+
+func TestOutstandingRecordsCountsBatchesPastCheckpoint(t *testing.T) {
+	o := &outstandingRecords{}
+	o.delivered(newMockKinesisClient(3, true).records)
+	o.delivered(newMockKinesisClient(5, true).records[3:])
+	assert.Equal(t, 5, o.count(""))
+
+	// a batch checkpointed partway is still outstanding
+	assert.Equal(t, 5, o.count("2"))
+	assert.Equal(t, 2, o.count("3"))
+	assert.Equal(t, 0, o.count(SHARD_END))
+}
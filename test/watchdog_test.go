@@ -0,0 +1,79 @@
+package shard
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/guygma/goKCL/util"
+)
+
+func TestWatchdogStuckShard(t *testing.T) {
+	start := time.Now()
+	w := newWatchdog(time.Minute, start)
+
+	// record were received, but the processor never checkpoints while the shard is behind
+	w.recordsReceived(10, 5000, start)
+	stuck, _ := w.check("", start.Add(30*time.Second))
+	assert.False(t, stuck)
+
+	stuck, behind := w.check("", start.Add(time.Minute))
+	assert.True(t, stuck)
+	assert.Equal(t, int64(5000), behind)
+
+	// fires only once, and clears on progress
+	stuck, _ = w.check("", start.Add(2*time.Minute))
+	assert.False(t, stuck)
+	stuck, _ = w.check("10", start.Add(2*time.Minute))
+	assert.False(t, stuck)
+	assert.False(t, w.stuck)
+}
+
+func TestWatchdogCaughtUpShard(t *testing.T) {
+	start := time.Now()
+	w := newWatchdog(time.Minute, start)
+
+	// no record and no checkpoint for a long time, but nothing to read either
+	w.recordsReceived(0, 0, start)
+	stuck, _ := w.check("", start.Add(time.Hour))
+	assert.False(t, stuck)
+}
+
+func TestWatchdogEmitsCriticalEvent(t *testing.T) {
+	listener := &mockEventListener{}
+	sc := &Consumer{
+		kclConfig: testConfig().WithEventListener(listener),
+		watchdog:  newWatchdog(20*time.Millisecond, time.Now()),
+	}
+	sc.watchdog.recordsReceived(1, 1000, time.Now())
+
+	done := make(chan struct{})
+	go sc.runWatchdog(testShard(), done)
+	time.Sleep(100 * time.Millisecond)
+	close(done)
+
+	events := listener.received()
+	assert.Equal(t, 1, len(events))
+	assert.Equal(t, util.CRITICAL, events[0].Severity)
+	assert.Equal(t, util.EVENT_SHARD_STUCK, events[0].Type)
+	assert.Equal(t, "0001", events[0].ShardID)
+}
+
+type mockEventListener struct {
+	mux    sync.Mutex
+	events []*util.Event
+}
+
+func (m *mockEventListener) OnEvent(event *util.Event) {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+	m.events = append(m.events, event)
+}
+
+func (m *mockEventListener) received() []*util.Event {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+	return append([]*util.Event{}, m.events...)
+}
@@ -0,0 +1,64 @@
+package goKCL
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/service/kinesis"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWorkerGettersWhileShardsChange(t *testing.T) {
+	kc := &churningKinesis{mockKinesis: &mockKinesis{}}
+	store := newMemoryLeaseStore(10 * time.Second)
+	kclConfig := NewKinesisClientLibConfig("appName", "churn", "us-west-2", "worker-a").
+		WithFailoverTimeMillis(10000).
+		WithShardSyncIntervalMillis(1).
+		WithIdleTimeBetweenReadsInMillis(10)
+	worker := NewWorker(&mockProcessorFactory{}, kclConfig, nil).WithKinesis(kc).WithCheckpointer(store)
+	assert.Nil(t, worker.Start())
+	defer worker.Shutdown()
+
+	// the getters are called from other goroutines while the event loop adds and removes shards
+	deadline := time.Now().Add(200 * time.Millisecond)
+	wg := sync.WaitGroup{}
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for time.Now().Before(deadline) {
+				worker.GetShardConsumerUptime("shardId-1")
+				worker.GetShardThroughput("shardId-1")
+				worker.GetThroughput()
+				worker.GetLeaseOwnershipHistory("shardId-1")
+				worker.GetShardFetchDiagnostics()
+				worker.GetShardStartingPositions()
+			}
+		}()
+	}
+	wg.Wait()
+	assert.True(t, kc.listings() > 1)
+}
+
+// churningKinesis lists a different set of shards on every call.
+type churningKinesis struct {
+	*mockKinesis
+	mux   sync.Mutex
+	calls int
+}
+
+func (m *churningKinesis) DescribeStream(input *kinesis.DescribeStreamInput) (*kinesis.DescribeStreamOutput, error) {
+	m.mux.Lock()
+	m.calls++
+	shards := []*kinesis.Shard{mockShard(fmt.Sprintf("shardId-%d", m.calls%3), "0", "100")}
+	m.mux.Unlock()
+	return (&mockKinesis{shards: shards}).DescribeStream(input)
+}
+
+func (m *churningKinesis) listings() int {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+	return m.calls
+}
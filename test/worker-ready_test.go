@@ -0,0 +1,54 @@
+package goKCL
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/service/kinesis"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/guygma/goKCL/shard"
+)
+
+func TestWorkerWaitReady(t *testing.T) {
+	store := &slowLeaseStore{memoryLeaseStore: newMemoryLeaseStore(10 * time.Second), delay: 100 * time.Millisecond}
+	kclConfig := NewKinesisClientLibConfig("appName", "ready", "us-west-2", "worker-a").
+		WithFailoverTimeMillis(10000).
+		WithShardSyncIntervalMillis(10000)
+	worker := NewWorker(&mockProcessorFactory{}, kclConfig, nil).
+		WithKinesis(&mockKinesis{shards: []*kinesis.Shard{
+			mockShard("shardId-0", "0", "340282366920938463463374607431768211455"),
+		}}).
+		WithCheckpointer(store)
+
+	// not ready before it is started
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	assert.Equal(t, context.DeadlineExceeded, worker.WaitReady(ctx))
+
+	assert.Nil(t, worker.Start())
+	defer worker.Shutdown()
+
+	// ready only once the lease of the first acquisition cycle was taken
+	assert.Nil(t, worker.WaitReady(context.Background()))
+	store.mux.Lock()
+	assert.Equal(t, "worker-a", store.owners["shardId-0"])
+	store.mux.Unlock()
+
+	// and stays ready
+	assert.Nil(t, worker.WaitReady(context.Background()))
+}
+
+// slowLeaseStore takes delay to take a lease.
+type slowLeaseStore struct {
+	*memoryLeaseStore
+	delay time.Duration
+	once  sync.Once
+}
+
+func (m *slowLeaseStore) GetLease(sh *shard.Status, newAssignTo string) error {
+	m.once.Do(func() { time.Sleep(m.delay) })
+	return m.memoryLeaseStore.GetLease(sh, newAssignTo)
+}
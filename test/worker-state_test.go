@@ -0,0 +1,126 @@
+package goKCL
+
+import (
+	"io/ioutil"
+	"os"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/service/kinesis"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWorkerStateRestoresHeldLeases(t *testing.T) {
+	dir, err := ioutil.TempDir("", "worker-state")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	store := NewFileWorkerStateStore(dir)
+	assert.Nil(t, store.Save(&WorkerState{
+		WorkerID:   "abc",
+		StreamName: "test",
+		Shards:     []ShardState{{ID: "shardId-0"}, {ID: "shardId-1"}, {ID: "shardId-2"}, {ID: "shardId-3"}},
+		HeldLeases: []string{"shardId-2", "shardId-3"},
+	}))
+
+	// the leases of the previous run haven't expired yet
+	leases := newMemoryLeaseStore(time.Minute)
+	leases.owners["shardId-2"] = "abc"
+	leases.owners["shardId-3"] = "abc"
+	leases.leaseTimeouts["shardId-2"] = time.Now().Add(time.Minute)
+	leases.leaseTimeouts["shardId-3"] = time.Now().Add(time.Minute)
+
+	w := newStateTestWorker(store, leases, stateTestShards("shardId-0", "shardId-1", "shardId-2", "shardId-3"))
+	assert.Nil(t, w.initialize())
+	w.restoreState()
+	assert.Equal(t, 4, len(w.shardStatus))
+
+	// the worker converges back to its previous leases in a single cycle
+	assert.Nil(t, w.syncShard())
+	w.acquireLeases(2)
+	assert.Equal(t, []string{"shardId-2", "shardId-3"}, sortedOwnedShardIDs(w))
+
+	w.persistState(w.ownedShardIDs())
+	state, err := store.Load("abc")
+	assert.Nil(t, err)
+	assert.Equal(t, 4, len(state.Shards))
+	sort.Strings(state.HeldLeases)
+	assert.Equal(t, []string{"shardId-2", "shardId-3"}, state.HeldLeases)
+
+	close(*w.stop)
+	w.waitGroup.Wait()
+}
+
+func TestWorkerStateValidatedAgainstStream(t *testing.T) {
+	dir, err := ioutil.TempDir("", "worker-state")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	store := NewFileWorkerStateStore(dir)
+	assert.Nil(t, store.Save(&WorkerState{
+		WorkerID:   "abc",
+		StreamName: "test",
+		Shards:     []ShardState{{ID: "shardId-0"}, {ID: "shardId-1"}, {ID: "shardId-9"}},
+		HeldLeases: []string{"shardId-9", "shardId-1"},
+	}))
+
+	w := newStateTestWorker(store, newMemoryLeaseStore(time.Minute), stateTestShards("shardId-0", "shardId-1"))
+	assert.Nil(t, w.initialize())
+	w.restoreState()
+	assert.Equal(t, 3, len(w.shardStatus))
+
+	// the shard which no longer exists is dropped, the remaining held lease is still preferred
+	assert.Nil(t, w.syncShard())
+	_, ok := w.shardStatus["shardId-9"]
+	assert.False(t, ok)
+	w.acquireLeases(1)
+	assert.Equal(t, []string{"shardId-1"}, sortedOwnedShardIDs(w))
+
+	close(*w.stop)
+	w.waitGroup.Wait()
+}
+
+func TestWorkerStateOfOtherStreamIgnored(t *testing.T) {
+	dir, err := ioutil.TempDir("", "worker-state")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	store := NewFileWorkerStateStore(dir)
+	assert.Nil(t, store.Save(&WorkerState{
+		WorkerID:   "abc",
+		StreamName: "other",
+		Shards:     []ShardState{{ID: "shardId-0"}},
+		HeldLeases: []string{"shardId-0"},
+	}))
+
+	w := newStateTestWorker(store, newMemoryLeaseStore(time.Minute), stateTestShards("shardId-0"))
+	assert.Nil(t, w.initialize())
+	w.restoreState()
+	assert.Empty(t, w.shardStatus)
+	assert.Empty(t, w.preferredLeases)
+}
+
+func newStateTestWorker(store WorkerStateStore, leases *memoryLeaseStore, shards []*kinesis.Shard) *Worker {
+	kclConfig := NewKinesisClientLibConfig("appName", "test", "us-west-2", "abc").
+		WithIdleTimeBetweenReadsInMillis(10).
+		WithMaxLeasesPerAcquisition(2).
+		WithWorkerStateStore(store)
+	return NewWorker(&mockProcessorFactory{}, kclConfig, nil).
+		WithKinesis(&mockKinesis{shards: shards}).
+		WithCheckpointer(leases)
+}
+
+func stateTestShards(ids ...string) []*kinesis.Shard {
+	shards := make([]*kinesis.Shard, 0, len(ids))
+	for _, id := range ids {
+		shards = append(shards, mockShard(id, "0", "1"))
+	}
+	return shards
+}
+
+func sortedOwnedShardIDs(w *Worker) []string {
+	shardIDs := w.ownedShardIDs()
+	sort.Strings(shardIDs)
+	return shardIDs
+}
@@ -0,0 +1,127 @@
+package goKCL
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/kinesis"
+	log "github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/guygma/goKCL/record"
+)
+
+func TestWorkerSummary(t *testing.T) {
+	hook := &summaryHook{}
+	log.AddHook(hook)
+
+	kc := &summaryKinesis{mockKinesis: &mockKinesis{shards: []*kinesis.Shard{
+		mockShard("shardId-0", "0", "340282366920938463463374607431768211455"),
+	}}, records: 3}
+	store := newMemoryLeaseStore(10 * time.Second)
+	kclConfig := NewKinesisClientLibConfig("appName", "summary", "us-west-2", "worker-a").
+		WithFailoverTimeMillis(10000).
+		WithShardSyncIntervalMillis(10000).
+		WithIdleTimeBetweenReadsInMillis(10).
+		WithSummaryLogIntervalMillis(50)
+	worker := NewWorker(&checkpointingFactory{}, kclConfig, nil).WithKinesis(kc).WithCheckpointer(store)
+	assert.Nil(t, worker.Start())
+	defer worker.Shutdown()
+	assert.True(t, store.waitForOwner("worker-a", time.Second, "shardId-0"))
+
+	// the summaries are logged on schedule, independently of the shard syncs
+	time.Sleep(300 * time.Millisecond)
+	summaries := hook.summaries()
+	assert.True(t, len(summaries) >= 4, "%d summaries logged", len(summaries))
+
+	// the counts are since the last summary, so they add up to the activity of the worker
+	checkpointWrites, throttles := 0, 0
+	for _, summary := range summaries {
+		checkpointWrites += summary["checkpointWrites"].(int)
+		throttles += summary["throttles"].(int)
+	}
+	assert.Equal(t, 3, checkpointWrites)
+	assert.Equal(t, 1, throttles)
+
+	last := summaries[len(summaries)-1]
+	assert.Equal(t, "worker-a", last["worker"])
+	assert.Equal(t, 1, last["shards"])
+	assert.Equal(t, int64(1500), last["maxMillisBehindLatest"])
+	assert.Equal(t, 0, last["checkpointWrites"])
+}
+
+// summaryKinesis serves the given number of records one at a time, 1500 ms behind the tip of the stream. The first
+// fetch is throttled.
+type summaryKinesis struct {
+	*mockKinesis
+	mux       sync.Mutex
+	records   int
+	fetched   int
+	throttled bool
+}
+
+func (m *summaryKinesis) GetRecords(input *kinesis.GetRecordsInput) (*kinesis.GetRecordsOutput, error) {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+	if !m.throttled {
+		m.throttled = true
+		return nil, awserr.New(kinesis.ErrCodeProvisionedThroughputExceededException, "Rate exceeded for shard", nil)
+	}
+
+	output := &kinesis.GetRecordsOutput{NextShardIterator: input.ShardIterator, MillisBehindLatest: aws.Int64(1500)}
+	if m.fetched < m.records {
+		m.fetched++
+		output.Records = []*kinesis.Record{{
+			SequenceNumber: aws.String(string(rune('0' + m.fetched))),
+			Data:           []byte("data"),
+			PartitionKey:   aws.String("key"),
+		}}
+	}
+	return output, nil
+}
+
+// checkpointingFactory creates record processors checkpointing every batch.
+type checkpointingFactory struct{}
+
+func (f *checkpointingFactory) CreateProcessor() record.IRecordProcessor {
+	return &checkpointingProcessor{}
+}
+
+type checkpointingProcessor struct {
+	noopRecordProcessor
+}
+
+func (p *checkpointingProcessor) ProcessRecords(input *record.ProcessRecordsInput) {
+	if len(input.Records) > 0 {
+		input.Checkpointer.Checkpoint(input.Records[len(input.Records)-1].SequenceNumber)
+	}
+}
+
+// summaryHook collects the fields of the summaries of the worker activity.
+type summaryHook struct {
+	mux     sync.Mutex
+	entries []log.Fields
+}
+
+func (h *summaryHook) Levels() []log.Level {
+	return []log.Level{log.InfoLevel}
+}
+
+func (h *summaryHook) Fire(entry *log.Entry) error {
+	if entry.Data["stream"] != "summary" || entry.Data["checkpointWrites"] == nil {
+		return nil
+	}
+	h.mux.Lock()
+	defer h.mux.Unlock()
+	h.entries = append(h.entries, entry.Data)
+	return nil
+}
+
+func (h *summaryHook) summaries() []log.Fields {
+	h.mux.Lock()
+	defer h.mux.Unlock()
+	return append([]log.Fields(nil), h.entries...)
+}
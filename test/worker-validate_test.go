@@ -0,0 +1,79 @@
+package goKCL
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/kinesis"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/guygma/goKCL/util"
+)
+
+func TestWorkerValidate(t *testing.T) {
+	kc := &mockKinesis{shards: []*kinesis.Shard{
+		mockShard("shardId-0", "0", "340282366920938463463374607431768211455"),
+	}}
+	worker := NewWorker(&checkpointingFactory{}, validateConfig(), nil).
+		WithKinesis(kc).
+		WithCheckpointer(&probedLeaseStore{memoryLeaseStore: newMemoryLeaseStore(time.Second)})
+
+	assert.Nil(t, worker.Validate(context.Background()))
+}
+
+func TestWorkerValidateFailures(t *testing.T) {
+	denied := awserr.New("AccessDeniedException", "not authorized", nil)
+	kc := &missingStreamKinesis{mockKinesis: &mockKinesis{}}
+	worker := NewWorker(&checkpointingFactory{}, validateConfig(), nil).
+		WithKinesis(kc).
+		WithCheckpointer(&probedLeaseStore{memoryLeaseStore: newMemoryLeaseStore(time.Second), err: denied})
+
+	// every check runs, the missing stream makes it a misconfiguration
+	err := worker.Validate(context.Background())
+	assert.True(t, errors.Is(err, util.IllegalArgumentError.MakeErr()))
+	var cle *util.ClientLibraryError
+	assert.True(t, errors.As(err, &cle))
+	assert.Equal(t, 2, len(cle.Fields()["causes"].([]interface{})))
+
+	// a lease table which can't be accessed is a dependency failure
+	worker = NewWorker(&checkpointingFactory{}, validateConfig(), nil).
+		WithKinesis(&mockKinesis{}).
+		WithCheckpointer(&probedLeaseStore{memoryLeaseStore: newMemoryLeaseStore(time.Second), err: denied})
+	err = worker.Validate(context.Background())
+	assert.True(t, errors.Is(err, util.KinesisClientLibDependencyError.MakeErr()))
+	assert.True(t, errors.Is(err, denied))
+
+	// the checks stop once the context is canceled
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	assert.Equal(t, context.Canceled, worker.Validate(ctx))
+}
+
+func validateConfig() *KinesisClientLibConfiguration {
+	return NewKinesisClientLibConfig("appName", "validate", "us-west-2", "worker-a")
+}
+
+// missingStreamKinesis is a Kinesis client whose stream doesn't exist.
+type missingStreamKinesis struct {
+	*mockKinesis
+}
+
+func (m *missingStreamKinesis) DescribeStreamSummary(input *kinesis.DescribeStreamSummaryInput) (*kinesis.DescribeStreamSummaryOutput, error) {
+	return nil, awserr.New(kinesis.ErrCodeResourceNotFoundException, "stream not found", nil)
+}
+
+// probedLeaseStore is a memoryLeaseStore failing its access validation with err.
+type probedLeaseStore struct {
+	*memoryLeaseStore
+	err error
+}
+
+func (m *probedLeaseStore) ValidateAccess() error {
+	if m.err != nil {
+		return util.KinesisClientLibDependencyError.MakeErr().WithDetail("unable to read lease table").WithCause(m.err)
+	}
+	return nil
+}
@@ -0,0 +1,129 @@
+package record
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/kinesis"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/guygma/goKCL/shard"
+	"github.com/guygma/goKCL/util"
+)
+
+func TestWriterSinkNewlineDelimited(t *testing.T) {
+	buf := &bytes.Buffer{}
+	checkpointer := &mockRecordCheckpointer{}
+	sink := NewWriterSinkFactory(buf, NEWLINE_DELIMITED).CreateProcessor()
+	sink.Initialize(&shard.InitializationInput{ShardId: "0001"})
+
+	sink.ProcessRecords(&ProcessRecordsInput{
+		Records:      sinkRecords("abc", "de"),
+		Checkpointer: checkpointer,
+	})
+
+	assert.Equal(t, "abc\nde\n", buf.String())
+	assert.Equal(t, []string{"2"}, checkpointer.checkpoints)
+}
+
+func TestWriterSinkLengthPrefixed(t *testing.T) {
+	buf := &bytes.Buffer{}
+	checkpointer := &mockRecordCheckpointer{}
+	sink := NewWriterSinkFactory(buf, LENGTH_PREFIXED).CreateProcessor()
+	sink.Initialize(&shard.InitializationInput{ShardId: "0001"})
+
+	sink.ProcessRecords(&ProcessRecordsInput{
+		Records:      sinkRecords("abc", "de"),
+		Checkpointer: checkpointer,
+	})
+
+	out := buf.Bytes()
+	assert.Equal(t, uint32(3), binary.BigEndian.Uint32(out[0:4]))
+	assert.Equal(t, "abc", string(out[4:7]))
+	assert.Equal(t, uint32(2), binary.BigEndian.Uint32(out[7:11]))
+	assert.Equal(t, "de", string(out[11:13]))
+	assert.Equal(t, []string{"2"}, checkpointer.checkpoints)
+}
+
+func TestWriterSinkCheckpointOnlyOnSuccess(t *testing.T) {
+	w := &failingWriter{}
+	checkpointer := &mockRecordCheckpointer{}
+	sink := NewWriterSinkFactory(w, NEWLINE_DELIMITED).CreateProcessor()
+	sink.Initialize(&shard.InitializationInput{ShardId: "0001"})
+
+	sink.ProcessRecords(&ProcessRecordsInput{Records: sinkRecords("a"), Checkpointer: checkpointer})
+	assert.Equal(t, []string{"1"}, checkpointer.checkpoints)
+
+	// a failed write must not advance the checkpoint, nor any later batch with the STOP policy, both are failed
+	w.fail = true
+	failed := &ProcessRecordsInput{Records: sinkRecords("b"), Checkpointer: checkpointer}
+	sink.ProcessRecords(failed)
+	assert.True(t, errors.Is(failed.Err(), util.KinesisClientLibNonRetryableException.MakeErr()))
+	w.fail = false
+	later := &ProcessRecordsInput{Records: sinkRecords("c"), Checkpointer: checkpointer}
+	sink.ProcessRecords(later)
+	assert.Equal(t, failed.Err(), later.Err())
+	assert.Equal(t, []string{"1"}, checkpointer.checkpoints)
+
+	// with the SKIP policy the failed batch is checkpointed past
+	checkpointer = &mockRecordCheckpointer{}
+	sink = NewWriterSinkFactory(w, NEWLINE_DELIMITED).WithFailurePolicy(SKIP).CreateProcessor()
+	w.fail = true
+	skipped := &ProcessRecordsInput{Records: sinkRecords("d"), Checkpointer: checkpointer}
+	sink.ProcessRecords(skipped)
+	assert.Nil(t, skipped.Err())
+	assert.Equal(t, []string{"1"}, checkpointer.checkpoints)
+}
+
+func TestWriterSinkRejectsDeadLetterPolicy(t *testing.T) {
+	factory := NewWriterSinkFactory(&bytes.Buffer{}, RAW)
+	assert.Panics(t, func() { factory.WithFailurePolicy(DEAD_LETTER) })
+}
+
+// sinkRecords creates one record per data string with sequence numbers starting from 1.
+func sinkRecords(data ...string) []*kinesis.Record {
+	records := make([]*kinesis.Record, 0, len(data))
+	for i, d := range data {
+		records = append(records, &kinesis.Record{
+			Data:           []byte(d),
+			PartitionKey:   aws.String("key"),
+			SequenceNumber: aws.String(string(rune('1' + i))),
+		})
+	}
+	return records
+}
+
+type failingWriter struct {
+	fail bool
+}
+
+func (w *failingWriter) Write(p []byte) (int, error) {
+	if w.fail {
+		return 0, errors.New("write failed")
+	}
+	return len(p), nil
+}
+
+type mockRecordCheckpointer struct {
+	checkpoints []string
+}
+
+func (m *mockRecordCheckpointer) Checkpoint(sequenceNumber *string) error {
+	m.checkpoints = append(m.checkpoints, aws.StringValue(sequenceNumber))
+	return nil
+}
+
+func (m *mockRecordCheckpointer) CheckpointSequence(sequenceNumber string) error {
+	return m.Checkpoint(&sequenceNumber)
+}
+
+func (m *mockRecordCheckpointer) CheckpointSequenceWithSubSequence(sequenceNumber string, subSequenceNumber int64) error {
+	return m.Checkpoint(&sequenceNumber)
+}
+
+func (m *mockRecordCheckpointer) PrepareCheckpoint(sequenceNumber *string) (IPreparedCheckpointer, error) {
+	return &PreparedCheckpointer{}, nil
+}
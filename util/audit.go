@@ -0,0 +1,64 @@
+package util
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"time"
+)
+
+const (
+	LEASE_TAKEN AuditType = iota + 1
+	LEASE_RENEWED
+	LEASE_RELEASED
+	CHECKPOINT_WRITTEN
+)
+
+const (
+	// INCLUDE_VALUES audits old and new values as they are.
+	INCLUDE_VALUES AuditValuePolicy = iota + 1
+
+	// HASH_VALUES replaces old and new values (e.g. worker IDs, sequence numbers) with a digest, so mutations can
+	// still be correlated without exposing the values.
+	HASH_VALUES
+)
+
+// AuditType is the kind of lease or checkpoint mutation being audited.
+type AuditType int
+
+// AuditValuePolicy determines how the values of mutations appear in the audit trail.
+type AuditValuePolicy int
+
+// AuditEntry is the record of a single lease or checkpoint mutation.
+type AuditEntry struct {
+	Type      AuditType
+	ShardID   string
+	Actor     string
+	OldValue  string
+	NewValue  string
+	Timestamp time.Time
+}
+
+// AuditHook receives an AuditEntry for every lease take/renew/release and checkpoint write, e.g. to keep a
+// compliance trail. It is called from a single goroutine, in the order of the mutations.
+type AuditHook interface {
+	Audit(entry *AuditEntry)
+}
+
+var auditTypeMap = map[AuditType]string{
+	LEASE_TAKEN:        "LEASE_TAKEN",
+	LEASE_RENEWED:      "LEASE_RENEWED",
+	LEASE_RELEASED:     "LEASE_RELEASED",
+	CHECKPOINT_WRITTEN: "CHECKPOINT_WRITTEN",
+}
+
+func (t AuditType) String() string {
+	return auditTypeMap[t]
+}
+
+// ApplyValuePolicy returns the value as it should appear in the audit trail.
+func (p AuditValuePolicy) ApplyValuePolicy(value string) string {
+	if p != HASH_VALUES || value == "" {
+		return value
+	}
+	return fmt.Sprintf("sha256:%x", sha256.Sum256([]byte(value)))[:19]
+}
@@ -0,0 +1,100 @@
+package util
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	log "github.com/sirupsen/logrus"
+)
+
+// throttlingAWSErrorCodes are the codes of the AWS SDK errors telling that a service throttled the request.
+var throttlingAWSErrorCodes = map[string]bool{
+	"ProvisionedThroughputExceededException": true,
+	"ThrottlingException":                    true,
+	"RequestLimitExceeded":                   true,
+	"LimitExceededException":                 true,
+}
+
+// Backoff retries an operation with exponential backoff and full jitter: the wait before the nth retry is random
+// between 0 and Base * 2^(n-1), capped at Max. Unlike a fixed or merely exponential backoff, the workers throttled
+// together don't retry together again.
+type Backoff struct {
+	// Base bounds the wait before the first retry
+	Base time.Duration
+
+	// Max caps the bound of the waits, 0 leaves them uncapped
+	Max time.Duration
+
+	// Attempts bounds the calls of the operation, the first one included
+	Attempts int
+
+	// Budget, if set, is drawn a token from before every retry: the operation fails for good once it is exhausted
+	Budget *RetryBudget
+}
+
+// Wait returns the random wait before the given (1 based) retry.
+func (b Backoff) Wait(retry int) time.Duration {
+	bound := b.Base
+	for i := 1; i < retry && (b.Max <= 0 || bound < b.Max); i++ {
+		bound *= 2
+	}
+	if b.Max > 0 && bound > b.Max {
+		bound = b.Max
+	}
+	if bound <= 0 {
+		return 0
+	}
+	return time.Duration(rand.Int63n(int64(bound) + 1))
+}
+
+// Retry calls op until it succeeds, fails with an error retryable rejects, or Attempts calls failed or the Budget is
+// exhausted. A nil retryable retries the errors IsRetryable accepts. The error op failed with for good is returned as a
+// ClientLibraryError noting the attempt count in its Detail: a ThrottlingError or a KinesisClientLibDependencyError if
+// op failed with an AWS SDK error. A non-retryable error is returned as is, and so is the error of the context, which
+// cuts the wait short.
+func (b Backoff) Retry(ctx context.Context, operation string, retryable func(error) bool, op func() error) error {
+	if retryable == nil {
+		retryable = IsRetryable
+	}
+
+	for attempt := 1; ; attempt++ {
+		err := op()
+		if err == nil || !retryable(err) {
+			return err
+		}
+		if attempt >= b.Attempts {
+			return exhaustedError(err, "%s failed after %d attempts", operation, attempt)
+		}
+		if b.Budget != nil && !b.Budget.Acquire() {
+			log.Errorf("%s failed, retry budget exhausted: %v", operation, err)
+			return exhaustedError(err, "%s failed after %d attempts, retry budget exhausted", operation, attempt)
+		}
+
+		wait := b.Wait(attempt)
+		log.Warnf("%s failed, retrying in %v: %v", operation, wait, err)
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
+
+// exhaustedError returns the error of an operation failing for good, with the given Detail.
+func exhaustedError(err error, format string, v ...interface{}) error {
+	cle, ok := err.(*ClientLibraryError)
+	if !ok {
+		code := KinesisClientLibDependencyError
+		var awsErr awserr.Error
+		if errors.As(err, &awsErr) && throttlingAWSErrorCodes[awsErr.Code()] {
+			code = ThrottlingError
+		}
+		cle = code.MakeErr().WithCause(err)
+	}
+	return cle.WithDetail(format, v...)
+}
@@ -0,0 +1,59 @@
+package util
+
+import (
+	"sync"
+	"time"
+)
+
+// RetryBudget is a token bucket shared by all shard consumers of a worker. Every retry consumes a token, so
+// under a broad outage the worker stops retrying once the budget is exhausted and surfaces errors instead of
+// retry-storming across all shards simultaneously. Tokens are refilled continuously up to the budget size.
+type RetryBudget struct {
+	size            float64
+	refillPerSecond float64
+	tokens          float64
+	lastRefill      time.Time
+	mux             sync.Mutex
+}
+
+// NewRetryBudget creates a full RetryBudget holding size tokens and refilling refillPerSecond tokens per second.
+func NewRetryBudget(size int, refillPerSecond float64) *RetryBudget {
+	return &RetryBudget{
+		size:            float64(size),
+		refillPerSecond: refillPerSecond,
+		tokens:          float64(size),
+		lastRefill:      time.Now(),
+	}
+}
+
+// Acquire takes a token from the budget. It returns false if the budget is exhausted, in which case the
+// caller should not retry.
+func (b *RetryBudget) Acquire() bool {
+	b.mux.Lock()
+	defer b.mux.Unlock()
+
+	b.refill()
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+// Available returns the number of retries currently left in the budget.
+func (b *RetryBudget) Available() int {
+	b.mux.Lock()
+	defer b.mux.Unlock()
+
+	b.refill()
+	return int(b.tokens)
+}
+
+func (b *RetryBudget) refill() {
+	now := time.Now()
+	b.tokens += now.Sub(b.lastRefill).Seconds() * b.refillPerSecond
+	if b.tokens > b.size {
+		b.tokens = b.size
+	}
+	b.lastRefill = now
+}
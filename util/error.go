@@ -1,10 +1,15 @@
 package util
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
+	"sync"
 
 	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
 
 	"github.com/guygma/goKCL/record"
 )
@@ -46,6 +51,15 @@ const (
 	IllegalArgumentError ErrorCode = 41302
 )
 
+// The range of error codes reserved for the Kinesis Client Library, which can't be registered.
+const (
+	minReservedErrorCode ErrorCode = 41000
+	maxReservedErrorCode ErrorCode = 42000
+)
+
+// errorMapMux guards errorMap against the registration of custom error codes.
+var errorMapMux sync.RWMutex
+
 var errorMap = map[ErrorCode]ClientLibraryError{
 	KinesisClientLibError: {ErrorCode: KinesisClientLibError, Retryable: true, Status: http.StatusServiceUnavailable, Msg: "Top level error of Kinesis Client Library"},
 
@@ -74,20 +88,57 @@ var errorMap = map[ErrorCode]ClientLibraryError{
 	KinesisClientLibNotImplemented: {ErrorCode: KinesisClientLibNotImplemented, Retryable: false, Status: http.StatusNotImplemented, Msg: "Not Implemented"},
 }
 
+// RegisterErrorCode registers an application specific error code, so that its failures flow through
+// ClientLibraryError, e.g. to be retried like the ones of the library. It returns an error if the code is in the
+// range reserved for the library, 41000 - 42000, or has already been registered.
+func RegisterErrorCode(code ErrorCode, retryable bool, status int, msg string) error {
+	if code >= minReservedErrorCode && code <= maxReservedErrorCode {
+		return IllegalArgumentError.MakeErr().
+			WithDetail("error code %d is reserved, %d - %d", code, minReservedErrorCode, maxReservedErrorCode)
+	}
+
+	errorMapMux.Lock()
+	_, registered := errorMap[code]
+	if !registered {
+		errorMap[code] = ClientLibraryError{ErrorCode: code, Retryable: retryable, Status: status, Msg: msg}
+	}
+	errorMapMux.Unlock()
+
+	if registered {
+		return IllegalArgumentError.MakeErr().WithDetail("error code %d is already registered", code)
+	}
+	return nil
+}
+
+// lookup returns the definition of the error code, and false if the error code isn't defined.
+func (c ErrorCode) lookup() (ClientLibraryError, bool) {
+	errorMapMux.RLock()
+	defer errorMapMux.RUnlock()
+	e, ok := errorMap[c]
+	return e, ok
+}
+
+// IsDefined returns true if the error code is defined by the library or registered with RegisterErrorCode.
+func (c ErrorCode) IsDefined() bool {
+	_, ok := c.lookup()
+	return ok
+}
+
 // Message returns the message of the error code
 func (c ErrorCode) Message() string {
-	return errorMap[c].Msg
+	e, _ := c.lookup()
+	return e.Msg
 }
 
 // MakeErr makes an error with default message
 func (c ErrorCode) MakeErr() *ClientLibraryError {
-	e := errorMap[c]
+	e, _ := c.lookup()
 	return &e
 }
 
 // MakeError makes an error with message and data
 func (c ErrorCode) MakeError(detail string) error {
-	e := errorMap[c]
+	e, _ := c.lookup()
 	return e.WithDetail(detail)
 }
 
@@ -103,6 +154,9 @@ type ClientLibraryError struct {
 	Msg string `json:"msg"`
 	// Detail provides a detailed description of the error. Its value is set using WithDetail.
 	Detail string `json:"detail"`
+
+	// causes are the errors set using WithCause and WithCauses, the first one is returned by Unwrap.
+	causes []error
 }
 
 // Error implements error
@@ -117,9 +171,82 @@ func (e *ClientLibraryError) Error() string {
 	if e.Detail != "" {
 		msg = fmt.Sprintf("%s, detail: %s", msg, e.Detail)
 	}
+	if len(e.causes) > 1 {
+		msg = fmt.Sprintf("%s, %d causes", msg, len(e.causes))
+	}
 	return msg
 }
 
+// MarshalJSON adds the message of every cause to the JSON of the error, as a list.
+func (e *ClientLibraryError) MarshalJSON() ([]byte, error) {
+	type plain ClientLibraryError
+	var causes []string
+	for _, cause := range e.causes {
+		causes = append(causes, cause.Error())
+	}
+	return json.Marshal(struct {
+		*plain
+		Causes []string `json:"causes,omitempty"`
+	}{(*plain)(e), causes})
+}
+
+// Is makes errors.Is match the errors with the same ErrorCode, regardless of their Detail or cause, e.g.
+// errors.Is(err, util.ShutdownError.MakeErr()).
+func (e *ClientLibraryError) Is(target error) bool {
+	t, ok := target.(*ClientLibraryError)
+	return ok && t.ErrorCode == e.ErrorCode
+}
+
+// Fields returns the error as discrete fields for structured logging, e.g. log.WithFields(err.Fields()). The causes
+// are listed under "causes", a cause being itself described by its fields if it is a ClientLibraryError, by its
+// message otherwise.
+func (e *ClientLibraryError) Fields() map[string]interface{} {
+	fields := map[string]interface{}{
+		"code":      int32(e.ErrorCode),
+		"retryable": e.Retryable,
+		"status":    e.Status,
+		"msg":       e.Msg,
+		"detail":    e.Detail,
+	}
+	if len(e.causes) > 0 {
+		causes := make([]interface{}, 0, len(e.causes))
+		for _, cause := range e.causes {
+			var cle *ClientLibraryError
+			if errors.As(cause, &cle) {
+				causes = append(causes, cle.Fields())
+			} else {
+				causes = append(causes, cause.Error())
+			}
+		}
+		fields["causes"] = causes
+	}
+	return fields
+}
+
+// Unwrap returns the first cause of the error, so that errors.Is and errors.As can drill down to it.
+func (e *ClientLibraryError) Unwrap() error {
+	if len(e.causes) == 0 {
+		return nil
+	}
+	return e.causes[0]
+}
+
+// Causes returns the causes of the error, in the order they were added.
+func (e *ClientLibraryError) Causes() []error {
+	return append([]error(nil), e.causes...)
+}
+
+// WithCauses adds several underlying failures to error, e.g. of an operation over several shards. Unlike WithCause,
+// their messages aren't added to the Detail, Error only tells how many causes there are.
+func (e *ClientLibraryError) WithCauses(errs ...error) *ClientLibraryError {
+	for _, err := range errs {
+		if err != nil {
+			e.causes = append(e.causes, err)
+		}
+	}
+	return e
+}
+
 // WithMsg overwrites the default error message
 func (e *ClientLibraryError) WithMsg(format string, v ...interface{}) *ClientLibraryError {
 	e.Msg = fmt.Sprintf(format, v...)
@@ -136,9 +263,10 @@ func (e *ClientLibraryError) WithDetail(format string, v ...interface{}) *Client
 	return e
 }
 
-// WithCause adds CauseBy to error
+// WithCause adds CauseBy to error. The first cause is returned by Unwrap.
 func (e *ClientLibraryError) WithCause(err error) *ClientLibraryError {
 	if err != nil {
+		e.causes = append(e.causes, err)
 		// Store error message in Detail, so the info can be preserved
 		// when CascadeError is marshaled to json.
 		if len(e.Detail) == 0 {
@@ -150,6 +278,34 @@ func (e *ClientLibraryError) WithCause(err error) *ClientLibraryError {
 	return e
 }
 
+// retryableAWSErrorCodes are the codes of the AWS SDK errors which are expected to succeed upon retry.
+var retryableAWSErrorCodes = map[string]bool{
+	"ProvisionedThroughputExceededException": true,
+	"ThrottlingException":                    true,
+	"RequestLimitExceeded":                   true,
+	"InternalServerError":                    true,
+}
+
+// IsRetryable returns true if the operation which failed with err is expected to succeed upon (back off and) retry:
+// a retryable ClientLibraryError, or an AWS SDK error caused by throttling or an internal server error. A canceled
+// context is never retryable.
+func IsRetryable(err error) bool {
+	if err == nil || errors.Is(err, context.Canceled) {
+		return false
+	}
+
+	var cle *ClientLibraryError
+	if errors.As(err, &cle) {
+		return cle.Retryable
+	}
+
+	var awsErr awserr.Error
+	if errors.As(err, &awsErr) {
+		return retryableAWSErrorCodes[awsErr.Code()]
+	}
+	return false
+}
+
 const (
 	/**
 	 * Indicates that the entire application is being shutdown, and if desired the record processor will be given a
@@ -188,6 +344,9 @@ type (
 	ShutdownInput struct {
 		ShutdownReason ShutdownReason
 		Checkpointer   record.IRecordProcessorCheckpointer
+		// Context is canceled once the worker shuts down or the lease of the shard is lost, i.e. already canceled
+		// unless the shard ended
+		Context context.Context
 	}
 )
 
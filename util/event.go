@@ -0,0 +1,87 @@
+package util
+
+import (
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	INFO EventSeverity = iota + 1
+	WARNING
+	CRITICAL
+)
+
+const (
+	// EVENT_SHARD_STUCK is emitted when a shard which is behind makes no progress for too long.
+	EVENT_SHARD_STUCK = "ShardStuck"
+
+	// EVENT_STREAM_IDLE is emitted when the stream has no open shard left, the worker idles until one appears.
+	EVENT_STREAM_IDLE = "StreamIdle"
+
+	// EVENT_STREAM_RESUMED is emitted when an open shard appears in an idle stream.
+	EVENT_STREAM_RESUMED = "StreamResumed"
+
+	// EVENT_POISON_SHARD is emitted when the lease of a shard keeps changing owner without the shard being checkpointed.
+	EVENT_POISON_SHARD = "PoisonShard"
+
+	// EVENT_SHARD_ITERATOR_FAILED is emitted when the consumer of a shard fails to get the shard iterator it starts
+	// from, once its retries are exhausted.
+	EVENT_SHARD_ITERATOR_FAILED = "ShardIteratorFailed"
+
+	// EVENT_CHECKPOINT_WRITE_SKEW is emitted when a worker checkpoints a shard whose lease another worker took over,
+	// i.e. two workers processed the shard at the same time. The failover window may need to be wider.
+	EVENT_CHECKPOINT_WRITE_SKEW = "CheckpointWriteSkew"
+
+	// EVENT_SHARD_MAP_STALE is emitted when the shards haven't been discovered for longer than the max age of the
+	// shard map, no lease is acquired until they are.
+	EVENT_SHARD_MAP_STALE = "ShardMapStale"
+
+	// EVENT_LEASE_LOST is emitted when the lease of a shard was taken over by another worker while it was consumed.
+	EVENT_LEASE_LOST = "LeaseLost"
+
+	// EVENT_LEASE_TABLE_RECREATED is emitted when the lease table was deleted while the worker ran and was created
+	// again, the checkpoints it held are lost.
+	EVENT_LEASE_TABLE_RECREATED = "LeaseTableRecreated"
+)
+
+// EventSeverity tells how urgently an event needs the attention of an operator.
+type EventSeverity int
+
+// Event describes a noteworthy occurrence in a worker which operators may want to be alerted of.
+type Event struct {
+	Severity  EventSeverity
+	Type      string
+	ShardID   string
+	Detail    string
+	Timestamp time.Time
+}
+
+// EventListener receives the events emitted by a worker. Implementations must not block.
+type EventListener interface {
+	OnEvent(event *Event)
+}
+
+// EmitEvent logs the event at the level matching its severity and hands it to the listener, if any.
+func EmitEvent(listener EventListener, severity EventSeverity, eventType, shardID, detail string) {
+	event := &Event{
+		Severity:  severity,
+		Type:      eventType,
+		ShardID:   shardID,
+		Detail:    detail,
+		Timestamp: time.Now(),
+	}
+
+	switch severity {
+	case CRITICAL:
+		log.Errorf("%s on shard %s: %s", eventType, shardID, detail)
+	case WARNING:
+		log.Warnf("%s on shard %s: %s", eventType, shardID, detail)
+	default:
+		log.Infof("%s on shard %s: %s", eventType, shardID, detail)
+	}
+
+	if listener != nil {
+		listener.OnEvent(event)
+	}
+}
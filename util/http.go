@@ -0,0 +1,35 @@
+package util
+
+import (
+	"net"
+	"net/http"
+	"time"
+)
+
+// NewHTTPClient creates the HTTP client of an AWS service client, so that each service can be tuned to its own
+// latency characteristics. A zero timeout or maxConnections keeps the default of the net/http package.
+func NewHTTPClient(timeout time.Duration, maxConnections int) *http.Client {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if maxConnections > 0 {
+		transport.MaxConnsPerHost = maxConnections
+		transport.MaxIdleConnsPerHost = maxConnections
+	}
+	return &http.Client{
+		Timeout:   timeout,
+		Transport: transport,
+	}
+}
+
+// NewStreamingHTTPClient creates the HTTP/2 client of long-lived event streams, e.g. the SubscribeToShard calls of
+// enhanced fan-out. It has no overall timeout, idle connections are health checked with a ping after keepAlive
+// instead, and closed if the ping isn't answered within pingTimeout.
+func NewStreamingHTTPClient(maxConnections int, keepAlive, pingTimeout time.Duration) *http.Client {
+	transport := NewHTTPClient(0, maxConnections).Transport.(*http.Transport)
+	transport.ForceAttemptHTTP2 = true
+	transport.DialContext = (&net.Dialer{Timeout: 30 * time.Second, KeepAlive: keepAlive}).DialContext
+	transport.HTTP2 = &http.HTTP2Config{
+		SendPingTimeout: keepAlive,
+		PingTimeout:     pingTimeout,
+	}
+	return &http.Client{Transport: transport}
+}
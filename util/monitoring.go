@@ -2,7 +2,9 @@ package util
 
 import (
 	"fmt"
+	"sort"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
@@ -14,11 +16,35 @@ import (
 	log "github.com/sirupsen/logrus"
 )
 
+const (
+	// METRICS_NONE disables all metrics.
+	METRICS_NONE MetricsLevel = iota + 1
+
+	// METRICS_SUMMARY emits the metrics summarizing the processing of the shards.
+	METRICS_SUMMARY
+
+	// METRICS_DETAILED emits the summary metrics, plus the detailed ones (e.g. the record age distribution).
+	METRICS_DETAILED
+
+	// DEFAULT_METRICS_LEVEL is the metrics level used if none is configured.
+	DEFAULT_METRICS_LEVEL = METRICS_DETAILED
+)
+
+// MetricsLevel determines how many metrics are emitted.
+type MetricsLevel int
+
+// recordAgeBucketsMillis are the upper bounds of the buckets of the record age histogram, the last bucket holds
+// all the older record as well.
+var recordAgeBucketsMillis = []float64{10, 100, 1000, 10000, 60000, 600000, 3600000, 21600000, 86400000, 604800000}
+
 // MonitoringConfiguration allows you to configure how record processing metrics are exposed
 type MonitoringConfiguration struct {
-	MonitoringService string // Type of monitoring to expose. Supported types are "prometheus"
+	MonitoringService string // Type of monitoring to expose. Supported types are "cloudwatch" and "openmetrics"
 	Region            string
 	CloudWatch        CloudWatchMonitoringService
+	OpenMetrics       OpenMetricsMonitoringService // http.Handler serving the metrics with "openmetrics"
+	MetricsLevel      MetricsLevel                 // DEFAULT_METRICS_LEVEL if not set
+	Publisher         MetricsPublisher             // receives the metrics as emitted instead of MonitoringService, if set
 	service           MonitoringService
 }
 
@@ -31,24 +57,57 @@ type MonitoringService interface {
 	LeaseGained(string)
 	LeaseLost(string)
 	LeaseRenewed(string)
+	IncrLeaseRenewalFailures(string)
 	RecordGetRecordsTime(string, float64)
 	RecordProcessRecordsTime(string, float64)
+	IncrInvalidRecords(string, int)
+	Backpressure(string, bool)
+	IncrExpiredIterators(string)
+	ConsumerUptime(string, float64)
+	IncrConsumerRestarts(string)
+	RecordAge(string, float64)
+	IncrDuplicateRecords(string, int)
+	ProcessingThroughput(string, float64)
+	IncrShardListingThrottles(string)
+	UncheckpointedRecords(string, int)
+	IncrLeasesLost(string)
 	Shutdown()
 }
 
 func (m *MonitoringConfiguration) Init(nameSpace, streamName string, workerID string) error {
-	if m.MonitoringService == "" {
+	if m.MetricsLevel == 0 {
+		m.MetricsLevel = DEFAULT_METRICS_LEVEL
+	}
+
+	if m.MetricsLevel == METRICS_NONE || m.MonitoringService == "" && m.Publisher == nil {
 		m.service = &noopMonitoringService{}
 		return nil
 	}
 
+	if m.Publisher != nil {
+		m.service = &publishingMonitoringService{
+			publisher:     m.Publisher,
+			kinesisStream: streamName,
+			workerID:      workerID,
+			metricsLevel:  m.MetricsLevel,
+		}
+		return m.service.Init()
+	}
+
 	switch m.MonitoringService {
 	case "cloudwatch":
 		m.CloudWatch.Namespace = nameSpace
 		m.CloudWatch.KinesisStream = streamName
 		m.CloudWatch.WorkerID = workerID
 		m.CloudWatch.Region = m.Region
+		m.CloudWatch.MetricsLevel = m.MetricsLevel
 		m.service = &m.CloudWatch
+	case "openmetrics":
+		m.OpenMetrics.Namespace = nameSpace
+		m.OpenMetrics.KinesisStream = streamName
+		m.OpenMetrics.WorkerID = workerID
+		m.OpenMetrics.MetricsLevel = m.MetricsLevel
+		m.service = &m.OpenMetrics
 	default:
 		return fmt.Errorf("Invalid monitoring service type %s", m.MonitoringService)
 	}
@@ -59,6 +118,38 @@ func (m *MonitoringConfiguration) GetMonitoringService() MonitoringService {
 	return m.service
 }
 
+// ValidateAccess checks that the metrics can be published to CloudWatch, if it is the monitoring service, by
+// publishing a single ConfigurationValidated sample since CloudWatch has no dry run. The other monitoring services
+// aren't checked.
+func (m *MonitoringConfiguration) ValidateAccess(nameSpace, streamName, workerID string) error {
+	if m.MetricsLevel == METRICS_NONE || m.Publisher != nil || m.MonitoringService != "cloudwatch" {
+		return nil
+	}
+
+	cw := m.CloudWatch
+	cw.Region = m.Region
+	if err := cw.Init(); err != nil {
+		return KinesisClientLibDependencyError.MakeErr().WithDetail("unable to create CloudWatch client").WithCause(err)
+	}
+	_, err := cw.svc.PutMetricData(&cloudwatch.PutMetricDataInput{
+		Namespace: aws.String(nameSpace),
+		MetricData: []*cloudwatch.MetricDatum{{
+			Dimensions: []*cloudwatch.Dimension{
+				{Name: aws.String("KinesisStreamName"), Value: aws.String(streamName)},
+				{Name: aws.String("WorkerID"), Value: aws.String(workerID)},
+			},
+			MetricName: aws.String("ConfigurationValidated"),
+			Unit:       aws.String(cloudwatch.StandardUnitCount),
+			Value:      aws.Float64(1),
+		}},
+	})
+	if err != nil {
+		return KinesisClientLibDependencyError.MakeErr().
+			WithDetail("unable to publish metrics to CloudWatch namespace %s", nameSpace).WithCause(err)
+	}
+	return nil
+}
+
 type noopMonitoringService struct{}
 
 func (n *noopMonitoringService) Init() error  { return nil }
@@ -71,8 +162,20 @@ func (n *noopMonitoringService) MillisBehindLatest(shard string, millSeconds flo
 func (n *noopMonitoringService) LeaseGained(shard string)                             {}
 func (n *noopMonitoringService) LeaseLost(shard string)                               {}
 func (n *noopMonitoringService) LeaseRenewed(shard string)                            {}
+func (n *noopMonitoringService) IncrLeaseRenewalFailures(shard string)                {}
 func (n *noopMonitoringService) RecordGetRecordsTime(shard string, time float64)      {}
 func (n *noopMonitoringService) RecordProcessRecordsTime(shard string, time float64)  {}
+func (n *noopMonitoringService) IncrInvalidRecords(shard string, count int)           {}
+func (n *noopMonitoringService) Backpressure(shard string, active bool)               {}
+func (n *noopMonitoringService) IncrExpiredIterators(shard string)                    {}
+func (n *noopMonitoringService) ConsumerUptime(shard string, seconds float64)         {}
+func (n *noopMonitoringService) IncrConsumerRestarts(shard string)                    {}
+func (n *noopMonitoringService) RecordAge(shard string, millis float64)               {}
+func (n *noopMonitoringService) IncrDuplicateRecords(shard string, count int)         {}
+func (n *noopMonitoringService) ProcessingThroughput(shard string, rate float64)      {}
+func (n *noopMonitoringService) IncrShardListingThrottles(stream string)              {}
+func (n *noopMonitoringService) UncheckpointedRecords(shard string, count int)        {}
+func (n *noopMonitoringService) IncrLeasesLost(shard string)                          {}
 
 type CloudWatchMonitoringService struct {
 	Namespace     string
@@ -83,12 +186,19 @@ type CloudWatchMonitoringService struct {
 
 	// control how often to pusblish to CloudWatch
 	MetricsBufferTimeMillis int
-	MetricsMaxQueueSize     int
+	// max number of samples of each metric kept while they can't be published, the older ones are dropped
+	MetricsMaxQueueSize int
+
+	// MetricsLevel determines which metrics are emitted, DEFAULT_METRICS_LEVEL if not set
+	MetricsLevel MetricsLevel
 
 	stop         *chan struct{}
 	waitGroup    *sync.WaitGroup
 	svc          cloudwatchiface.CloudWatchAPI
 	shardMetrics *sync.Map
+
+	// number of metric samples dropped because they couldn't be published
+	droppedMetrics int64
 }
 
 type cloudWatchMetrics struct {
@@ -97,8 +207,23 @@ type cloudWatchMetrics struct {
 	behindLatestMillis []float64
 	leasesHeld         int64
 	leaseRenewals      int64
+	renewalFailures    int64
 	getRecordsTime     []float64
 	processRecordsTime []float64
+	invalidRecords     int64
+	backpressure       bool
+	expiredIterators   int64
+	consumerUptime     float64
+	consumerRestarts   int64
+	recordAges         []float64
+	duplicateRecords   int64
+	throughput         float64
+	// record delivered to the record processor but not checkpointed yet
+	uncheckpointedRecords int64
+	// throttled listings of the shards, recorded under the name of the stream
+	shardListingThrottles int64
+	// leases taken over by another worker
+	leasesLost int64
 	sync.Mutex
 }
 
@@ -207,6 +332,13 @@ func (cw *CloudWatchMonitoringService) flushShard(shard string, metric *cloudWat
 			Timestamp:  &metricTimestamp,
 			Value:      aws.Float64(float64(metric.leaseRenewals)),
 		},
+		{
+			Dimensions: leaseDimensions,
+			MetricName: aws.String("LeaseRenewalFailures"),
+			Unit:       aws.String("Count"),
+			Timestamp:  &metricTimestamp,
+			Value:      aws.Float64(float64(metric.renewalFailures)),
+		},
 		{
 			Dimensions: leaseDimensions,
 			MetricName: aws.String("CurrentLeases"),
@@ -214,6 +346,79 @@ func (cw *CloudWatchMonitoringService) flushShard(shard string, metric *cloudWat
 			Timestamp:  &metricTimestamp,
 			Value:      aws.Float64(float64(metric.leasesHeld)),
 		},
+		{
+			Dimensions: defaultDimensions,
+			MetricName: aws.String("RecordsInvalid"),
+			Unit:       aws.String("Count"),
+			Timestamp:  &metricTimestamp,
+			Value:      aws.Float64(float64(metric.invalidRecords)),
+		},
+		{
+			Dimensions: defaultDimensions,
+			MetricName: aws.String("Backpressure"),
+			Unit:       aws.String("None"),
+			Timestamp:  &metricTimestamp,
+			Value:      aws.Float64(boolToFloat64(metric.backpressure)),
+		},
+		{
+			Dimensions: defaultDimensions,
+			MetricName: aws.String("ExpiredIterator"),
+			Unit:       aws.String("Count"),
+			Timestamp:  &metricTimestamp,
+			Value:      aws.Float64(float64(metric.expiredIterators)),
+		},
+		{
+			Dimensions: leaseDimensions,
+			MetricName: aws.String("LeaseLost"),
+			Unit:       aws.String("Count"),
+			Timestamp:  &metricTimestamp,
+			Value:      aws.Float64(float64(metric.leasesLost)),
+		},
+		{
+			Dimensions: defaultDimensions,
+			MetricName: aws.String("ConsumerUptime"),
+			Unit:       aws.String("Seconds"),
+			Timestamp:  &metricTimestamp,
+			Value:      aws.Float64(metric.consumerUptime),
+		},
+		{
+			Dimensions: defaultDimensions,
+			MetricName: aws.String("ConsumerRestarts"),
+			Unit:       aws.String("Count"),
+			Timestamp:  &metricTimestamp,
+			Value:      aws.Float64(float64(metric.consumerRestarts)),
+		},
+		{
+			Dimensions: defaultDimensions,
+			MetricName: aws.String("RecordsDuplicate"),
+			Unit:       aws.String("Count"),
+			Timestamp:  &metricTimestamp,
+			Value:      aws.Float64(float64(metric.duplicateRecords)),
+		},
+		{
+			Dimensions: defaultDimensions,
+			MetricName: aws.String("ProcessingThroughput"),
+			Unit:       aws.String("Count/Second"),
+			Timestamp:  &metricTimestamp,
+			Value:      aws.Float64(metric.throughput),
+		},
+		{
+			Dimensions: defaultDimensions,
+			MetricName: aws.String("UncheckpointedRecords"),
+			Unit:       aws.String("Count"),
+			Timestamp:  &metricTimestamp,
+			Value:      aws.Float64(float64(metric.uncheckpointedRecords)),
+		},
+	}
+
+	if metric.shardListingThrottles > 0 {
+		data = append(data, &cloudwatch.MetricDatum{
+			Dimensions: defaultDimensions,
+			MetricName: aws.String("ShardListingThrottles"),
+			Unit:       aws.String("Count"),
+			Timestamp:  &metricTimestamp,
+			Value:      aws.Float64(float64(metric.shardListingThrottles)),
+		})
 	}
 
 	if len(metric.behindLatestMillis) > 0 {
@@ -258,27 +463,114 @@ func (cw *CloudWatchMonitoringService) flushShard(shard string, metric *cloudWat
 			}})
 	}
 
+	if len(metric.recordAges) > 0 {
+		values, counts := recordAgeHistogram(metric.recordAges)
+		data = append(data, &cloudwatch.MetricDatum{
+			Dimensions: defaultDimensions,
+			MetricName: aws.String("RecordAge"),
+			Unit:       aws.String("Milliseconds"),
+			Timestamp:  &metricTimestamp,
+			Values:     values,
+			Counts:     counts,
+		})
+	}
+
+	// The metrics are published without holding the lock, so that a slow or unavailable CloudWatch never blocks
+	// the shard consumers recording metrics.
+	pending := metric.reset()
+	metric.Unlock()
+
 	// Publish metrics data to cloud watch
 	_, err := cw.svc.PutMetricData(&cloudwatch.PutMetricDataInput{
 		Namespace:  aws.String(cw.Namespace),
 		MetricData: data,
 	})
 
-	if err == nil {
-		metric.processedRecords = 0
-		metric.processedBytes = 0
-		metric.behindLatestMillis = []float64{}
-		metric.leaseRenewals = 0
-		metric.getRecordsTime = []float64{}
-		metric.processRecordsTime = []float64{}
-	} else {
+	if err != nil {
 		log.Errorf("Error in publishing cloudwatch metrics. Error: %+v", err)
+
+		// keep the metrics for the next flush, as long as there is room for them
+		metric.Lock()
+		dropped := metric.restore(pending, cw.MetricsMaxQueueSize)
+		metric.Unlock()
+		if dropped > 0 {
+			atomic.AddInt64(&cw.droppedMetrics, int64(dropped))
+			log.Warnf("Dropped %d metrics of shard %s which couldn't be published", dropped, shard)
+		}
 	}
 
-	metric.Unlock()
 	return true
 }
 
+// DroppedMetrics returns how many metric samples have been dropped because they couldn't be published.
+func (cw *CloudWatchMonitoringService) DroppedMetrics() int64 {
+	return atomic.LoadInt64(&cw.droppedMetrics)
+}
+
+// reset clears the metrics accumulated since the last flush and returns them. It must be called with the lock held.
+func (m *cloudWatchMetrics) reset() *cloudWatchMetrics {
+	pending := &cloudWatchMetrics{
+		processedRecords:      m.processedRecords,
+		processedBytes:        m.processedBytes,
+		behindLatestMillis:    m.behindLatestMillis,
+		leaseRenewals:         m.leaseRenewals,
+		getRecordsTime:        m.getRecordsTime,
+		processRecordsTime:    m.processRecordsTime,
+		invalidRecords:        m.invalidRecords,
+		expiredIterators:      m.expiredIterators,
+		consumerRestarts:      m.consumerRestarts,
+		recordAges:            m.recordAges,
+		duplicateRecords:      m.duplicateRecords,
+		shardListingThrottles: m.shardListingThrottles,
+		leasesLost:            m.leasesLost,
+	}
+
+	m.processedRecords = 0
+	m.processedBytes = 0
+	m.behindLatestMillis = []float64{}
+	m.leaseRenewals = 0
+	m.getRecordsTime = []float64{}
+	m.processRecordsTime = []float64{}
+	m.invalidRecords = 0
+	m.expiredIterators = 0
+	m.consumerRestarts = 0
+	m.recordAges = []float64{}
+	m.duplicateRecords = 0
+	m.shardListingThrottles = 0
+	m.leasesLost = 0
+	return pending
+}
+
+// restore adds back metrics which failed to be published. The counters are kept, but every series of samples is
+// bounded by maxSamples: the oldest samples beyond it are dropped, and their number returned. It must be called with
+// the lock held.
+func (m *cloudWatchMetrics) restore(pending *cloudWatchMetrics, maxSamples int) int {
+	m.processedRecords += pending.processedRecords
+	m.processedBytes += pending.processedBytes
+	m.leaseRenewals += pending.leaseRenewals
+	m.invalidRecords += pending.invalidRecords
+	m.expiredIterators += pending.expiredIterators
+	m.consumerRestarts += pending.consumerRestarts
+	m.duplicateRecords += pending.duplicateRecords
+	m.shardListingThrottles += pending.shardListingThrottles
+	m.leasesLost += pending.leasesLost
+
+	dropped := 0
+	restoreSamples := func(pending, current []float64) []float64 {
+		samples := append(pending, current...)
+		if len(samples) > maxSamples {
+			dropped += len(samples) - maxSamples
+			samples = samples[len(samples)-maxSamples:]
+		}
+		return samples
+	}
+	m.behindLatestMillis = restoreSamples(pending.behindLatestMillis, m.behindLatestMillis)
+	m.getRecordsTime = restoreSamples(pending.getRecordsTime, m.getRecordsTime)
+	m.processRecordsTime = restoreSamples(pending.processRecordsTime, m.processRecordsTime)
+	m.recordAges = restoreSamples(pending.recordAges, m.recordAges)
+	return dropped
+}
+
 func (cw *CloudWatchMonitoringService) flush() error {
 	log.Debugf("Flushing metrics data. Stream: %s, Worker: %s", cw.KinesisStream, cw.WorkerID)
 	// publish per shard metrics
@@ -332,6 +624,14 @@ func (cw *CloudWatchMonitoringService) LeaseRenewed(shard string) {
 	m.leaseRenewals++
 }
 
+// IncrLeaseRenewalFailures counts the failed renewals of the lease of the shard.
+func (cw *CloudWatchMonitoringService) IncrLeaseRenewalFailures(shard string) {
+	m := cw.getOrCreatePerShardMetrics(shard)
+	m.Lock()
+	defer m.Unlock()
+	m.renewalFailures++
+}
+
 func (cw *CloudWatchMonitoringService) RecordGetRecordsTime(shard string, time float64) {
 	m := cw.getOrCreatePerShardMetrics(shard)
 	m.Lock()
@@ -345,6 +645,97 @@ func (cw *CloudWatchMonitoringService) RecordProcessRecordsTime(shard string, ti
 	m.processRecordsTime = append(m.processRecordsTime, time)
 }
 
+func (cw *CloudWatchMonitoringService) IncrInvalidRecords(shard string, count int) {
+	m := cw.getOrCreatePerShardMetrics(shard)
+	m.Lock()
+	defer m.Unlock()
+	m.invalidRecords += int64(count)
+}
+
+func (cw *CloudWatchMonitoringService) Backpressure(shard string, active bool) {
+	m := cw.getOrCreatePerShardMetrics(shard)
+	m.Lock()
+	defer m.Unlock()
+	m.backpressure = active
+}
+
+func (cw *CloudWatchMonitoringService) IncrExpiredIterators(shard string) {
+	m := cw.getOrCreatePerShardMetrics(shard)
+	m.Lock()
+	defer m.Unlock()
+	m.expiredIterators++
+}
+
+func (cw *CloudWatchMonitoringService) ConsumerUptime(shard string, seconds float64) {
+	m := cw.getOrCreatePerShardMetrics(shard)
+	m.Lock()
+	defer m.Unlock()
+	m.consumerUptime = seconds
+}
+
+func (cw *CloudWatchMonitoringService) IncrConsumerRestarts(shard string) {
+	m := cw.getOrCreatePerShardMetrics(shard)
+	m.Lock()
+	defer m.Unlock()
+	m.consumerRestarts++
+}
+
+// RecordAge records the age of a record delivered to the record processor. It is a DETAILED metric.
+func (cw *CloudWatchMonitoringService) RecordAge(shard string, millis float64) {
+	if !cw.detailed() {
+		return
+	}
+	m := cw.getOrCreatePerShardMetrics(shard)
+	m.Lock()
+	defer m.Unlock()
+	m.recordAges = append(m.recordAges, millis)
+}
+
+func (cw *CloudWatchMonitoringService) IncrDuplicateRecords(shard string, count int) {
+	m := cw.getOrCreatePerShardMetrics(shard)
+	m.Lock()
+	defer m.Unlock()
+	m.duplicateRecords += int64(count)
+}
+
+// ProcessingThroughput records the moving average of the record processed per second.
+func (cw *CloudWatchMonitoringService) ProcessingThroughput(shard string, rate float64) {
+	m := cw.getOrCreatePerShardMetrics(shard)
+	m.Lock()
+	defer m.Unlock()
+	m.throughput = rate
+}
+
+// IncrLeasesLost counts the leases of the shard taken over by another worker.
+func (cw *CloudWatchMonitoringService) IncrLeasesLost(shard string) {
+	m := cw.getOrCreatePerShardMetrics(shard)
+	m.Lock()
+	defer m.Unlock()
+	m.leasesLost++
+}
+
+// UncheckpointedRecords records the number of record delivered to the record processor but not checkpointed yet.
+func (cw *CloudWatchMonitoringService) UncheckpointedRecords(shard string, count int) {
+	m := cw.getOrCreatePerShardMetrics(shard)
+	m.Lock()
+	defer m.Unlock()
+	m.uncheckpointedRecords = int64(count)
+}
+
+// IncrShardListingThrottles counts the throttled listings of the shards. They aren't specific to a shard, so they are
+// recorded under the name of the stream.
+func (cw *CloudWatchMonitoringService) IncrShardListingThrottles(stream string) {
+	m := cw.getOrCreatePerShardMetrics(stream)
+	m.Lock()
+	defer m.Unlock()
+	m.shardListingThrottles++
+}
+
+// detailed returns true if the DETAILED metrics are emitted.
+func (cw *CloudWatchMonitoringService) detailed() bool {
+	return cw.MetricsLevel == 0 || cw.MetricsLevel >= METRICS_DETAILED
+}
+
 func (cw *CloudWatchMonitoringService) getOrCreatePerShardMetrics(shard string) *cloudWatchMetrics {
 	var i interface{}
 	var ok bool
@@ -390,3 +781,32 @@ func minFloat64(slice []float64) *float64 {
 	}
 	return &min
 }
+
+func boolToFloat64(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// recordAgeHistogram distributes the record ages in the buckets of recordAgeBucketsMillis. It returns the upper bound
+// and the count of every non-empty bucket, as the values and counts of a CloudWatch metric datum.
+func recordAgeHistogram(ages []float64) ([]*float64, []*float64) {
+	counts := make([]float64, len(recordAgeBucketsMillis))
+	for _, age := range ages {
+		i := sort.SearchFloat64s(recordAgeBucketsMillis, age)
+		if i == len(recordAgeBucketsMillis) {
+			i--
+		}
+		counts[i]++
+	}
+
+	var values, nonEmpty []*float64
+	for i, count := range counts {
+		if count > 0 {
+			values = append(values, aws.Float64(recordAgeBucketsMillis[i]))
+			nonEmpty = append(nonEmpty, aws.Float64(count))
+		}
+	}
+	return values, nonEmpty
+}
@@ -0,0 +1,386 @@
+package util
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// OpenMetricsMonitoringService keeps the metrics of the worker in memory and renders them in the Prometheus text
+// exposition format. It is an http.Handler to mount on the metrics endpoint of the application, and doesn't
+// require the Prometheus client library.
+type OpenMetricsMonitoringService struct {
+	Namespace     string
+	KinesisStream string
+	WorkerID      string
+
+	// MetricsLevel determines which metrics are emitted, DEFAULT_METRICS_LEVEL if not set
+	MetricsLevel MetricsLevel
+
+	shardMetrics *sync.Map
+}
+
+type openMetricsShard struct {
+	processedRecords   int64
+	processedBytes     int64
+	behindLatestMillis float64
+	leasesHeld         int64
+	leaseRenewals      int64
+	renewalFailures    int64
+	getRecordsTime     openMetricsSummary
+	processRecordsTime openMetricsSummary
+	invalidRecords     int64
+	backpressure       bool
+	expiredIterators   int64
+	consumerUptime     float64
+	consumerRestarts   int64
+	// count of record ages per bucket of recordAgeBucketsMillis, plus the older ones
+	recordAgeBuckets []int64
+	recordAges       openMetricsSummary
+	duplicateRecords int64
+	throughput       float64
+	// record delivered to the record processor but not checkpointed yet
+	uncheckpointedRecords int64
+	// throttled listings of the shards, recorded under the name of the stream
+	shardListingThrottles int64
+	// leases taken over by another worker
+	leasesLost int64
+	sync.Mutex
+}
+
+type openMetricsSummary struct {
+	sum   float64
+	count int64
+}
+
+// openMetric describes a per shard metric with a single sample.
+type openMetric struct {
+	name  string
+	kind  string
+	help  string
+	value func(m *openMetricsShard) float64
+}
+
+var openMetrics = []openMetric{
+	{"kcl_records_processed_total", "counter", "Number of records processed.",
+		func(m *openMetricsShard) float64 { return float64(m.processedRecords) }},
+	{"kcl_bytes_processed_total", "counter", "Number of bytes processed.",
+		func(m *openMetricsShard) float64 { return float64(m.processedBytes) }},
+	{"kcl_millis_behind_latest", "gauge", "Milliseconds the shard consumer is behind the tip of the stream.",
+		func(m *openMetricsShard) float64 { return m.behindLatestMillis }},
+	{"kcl_leases_held", "gauge", "Number of leases held.",
+		func(m *openMetricsShard) float64 { return float64(m.leasesHeld) }},
+	{"kcl_lease_renewals_total", "counter", "Number of lease renewals.",
+		func(m *openMetricsShard) float64 { return float64(m.leaseRenewals) }},
+	{"kcl_lease_renewal_failures_total", "counter", "Number of failed lease renewals.",
+		func(m *openMetricsShard) float64 { return float64(m.renewalFailures) }},
+	{"kcl_invalid_records_total", "counter", "Number of records rejected by the record validator.",
+		func(m *openMetricsShard) float64 { return float64(m.invalidRecords) }},
+	{"kcl_backpressure", "gauge", "1 while the shard consumer is throttled by backpressure.",
+		func(m *openMetricsShard) float64 { return boolToFloat64(m.backpressure) }},
+	{"kcl_expired_iterators_total", "counter", "Number of expired shard iterators.",
+		func(m *openMetricsShard) float64 { return float64(m.expiredIterators) }},
+	{"kcl_consumer_uptime_seconds", "gauge", "Uptime of the shard consumer.",
+		func(m *openMetricsShard) float64 { return m.consumerUptime }},
+	{"kcl_consumer_restarts_total", "counter", "Number of shard consumer restarts.",
+		func(m *openMetricsShard) float64 { return float64(m.consumerRestarts) }},
+	{"kcl_duplicate_records_total", "counter", "Number of records suppressed as duplicates.",
+		func(m *openMetricsShard) float64 { return float64(m.duplicateRecords) }},
+	{"kcl_processing_throughput_records_per_second", "gauge", "Moving average of the records processed per second.",
+		func(m *openMetricsShard) float64 { return m.throughput }},
+	{"kcl_uncheckpointed_records", "gauge", "Number of records delivered but not checkpointed yet.",
+		func(m *openMetricsShard) float64 { return float64(m.uncheckpointedRecords) }},
+	{"kcl_shard_listing_throttles_total", "counter", "Number of throttled listings of the shards of the stream.",
+		func(m *openMetricsShard) float64 { return float64(m.shardListingThrottles) }},
+	{"kcl_leases_lost_total", "counter", "Number of leases taken over by another worker.",
+		func(m *openMetricsShard) float64 { return float64(m.leasesLost) }},
+}
+
+func (om *OpenMetricsMonitoringService) Init() error {
+	om.shardMetrics = &sync.Map{}
+	return nil
+}
+
+func (om *OpenMetricsMonitoringService) Start() error { return nil }
+func (om *OpenMetricsMonitoringService) Shutdown()    {}
+
+func (om *OpenMetricsMonitoringService) IncrRecordsProcessed(shard string, count int) {
+	m := om.getOrCreatePerShardMetrics(shard)
+	m.Lock()
+	defer m.Unlock()
+	m.processedRecords += int64(count)
+}
+
+func (om *OpenMetricsMonitoringService) IncrBytesProcessed(shard string, count int64) {
+	m := om.getOrCreatePerShardMetrics(shard)
+	m.Lock()
+	defer m.Unlock()
+	m.processedBytes += count
+}
+
+func (om *OpenMetricsMonitoringService) MillisBehindLatest(shard string, millSeconds float64) {
+	m := om.getOrCreatePerShardMetrics(shard)
+	m.Lock()
+	defer m.Unlock()
+	m.behindLatestMillis = millSeconds
+}
+
+func (om *OpenMetricsMonitoringService) LeaseGained(shard string) {
+	m := om.getOrCreatePerShardMetrics(shard)
+	m.Lock()
+	defer m.Unlock()
+	m.leasesHeld++
+}
+
+func (om *OpenMetricsMonitoringService) LeaseLost(shard string) {
+	m := om.getOrCreatePerShardMetrics(shard)
+	m.Lock()
+	defer m.Unlock()
+	m.leasesHeld--
+}
+
+func (om *OpenMetricsMonitoringService) LeaseRenewed(shard string) {
+	m := om.getOrCreatePerShardMetrics(shard)
+	m.Lock()
+	defer m.Unlock()
+	m.leaseRenewals++
+}
+
+// IncrLeaseRenewalFailures counts the failed renewals of the lease of the shard.
+func (om *OpenMetricsMonitoringService) IncrLeaseRenewalFailures(shard string) {
+	m := om.getOrCreatePerShardMetrics(shard)
+	m.Lock()
+	defer m.Unlock()
+	m.renewalFailures++
+}
+
+func (om *OpenMetricsMonitoringService) RecordGetRecordsTime(shard string, time float64) {
+	m := om.getOrCreatePerShardMetrics(shard)
+	m.Lock()
+	defer m.Unlock()
+	m.getRecordsTime.observe(time)
+}
+
+func (om *OpenMetricsMonitoringService) RecordProcessRecordsTime(shard string, time float64) {
+	m := om.getOrCreatePerShardMetrics(shard)
+	m.Lock()
+	defer m.Unlock()
+	m.processRecordsTime.observe(time)
+}
+
+func (om *OpenMetricsMonitoringService) IncrInvalidRecords(shard string, count int) {
+	m := om.getOrCreatePerShardMetrics(shard)
+	m.Lock()
+	defer m.Unlock()
+	m.invalidRecords += int64(count)
+}
+
+func (om *OpenMetricsMonitoringService) Backpressure(shard string, active bool) {
+	m := om.getOrCreatePerShardMetrics(shard)
+	m.Lock()
+	defer m.Unlock()
+	m.backpressure = active
+}
+
+func (om *OpenMetricsMonitoringService) IncrExpiredIterators(shard string) {
+	m := om.getOrCreatePerShardMetrics(shard)
+	m.Lock()
+	defer m.Unlock()
+	m.expiredIterators++
+}
+
+func (om *OpenMetricsMonitoringService) ConsumerUptime(shard string, seconds float64) {
+	m := om.getOrCreatePerShardMetrics(shard)
+	m.Lock()
+	defer m.Unlock()
+	m.consumerUptime = seconds
+}
+
+func (om *OpenMetricsMonitoringService) IncrConsumerRestarts(shard string) {
+	m := om.getOrCreatePerShardMetrics(shard)
+	m.Lock()
+	defer m.Unlock()
+	m.consumerRestarts++
+}
+
+func (om *OpenMetricsMonitoringService) IncrDuplicateRecords(shard string, count int) {
+	m := om.getOrCreatePerShardMetrics(shard)
+	m.Lock()
+	defer m.Unlock()
+	m.duplicateRecords += int64(count)
+}
+
+// ProcessingThroughput records the moving average of the record processed per second.
+func (om *OpenMetricsMonitoringService) ProcessingThroughput(shard string, rate float64) {
+	m := om.getOrCreatePerShardMetrics(shard)
+	m.Lock()
+	defer m.Unlock()
+	m.throughput = rate
+}
+
+// IncrLeasesLost counts the leases of the shard taken over by another worker.
+func (om *OpenMetricsMonitoringService) IncrLeasesLost(shard string) {
+	m := om.getOrCreatePerShardMetrics(shard)
+	m.Lock()
+	defer m.Unlock()
+	m.leasesLost++
+}
+
+// UncheckpointedRecords records the number of record delivered to the record processor but not checkpointed yet.
+func (om *OpenMetricsMonitoringService) UncheckpointedRecords(shard string, count int) {
+	m := om.getOrCreatePerShardMetrics(shard)
+	m.Lock()
+	defer m.Unlock()
+	m.uncheckpointedRecords = int64(count)
+}
+
+// IncrShardListingThrottles counts the throttled listings of the shards, under the name of the stream.
+func (om *OpenMetricsMonitoringService) IncrShardListingThrottles(stream string) {
+	m := om.getOrCreatePerShardMetrics(stream)
+	m.Lock()
+	defer m.Unlock()
+	m.shardListingThrottles++
+}
+
+// RecordAge records the age of a record delivered to the record processor. It is a DETAILED metric.
+func (om *OpenMetricsMonitoringService) RecordAge(shard string, millis float64) {
+	if om.MetricsLevel != 0 && om.MetricsLevel < METRICS_DETAILED {
+		return
+	}
+	m := om.getOrCreatePerShardMetrics(shard)
+	m.Lock()
+	defer m.Unlock()
+	if m.recordAgeBuckets == nil {
+		m.recordAgeBuckets = make([]int64, len(recordAgeBucketsMillis)+1)
+	}
+	m.recordAgeBuckets[sort.SearchFloat64s(recordAgeBucketsMillis, millis)]++
+	m.recordAges.observe(millis)
+}
+
+// ServeHTTP renders the metrics of all shards in the Prometheus text exposition format.
+func (om *OpenMetricsMonitoringService) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	w.Write(om.render())
+}
+
+func (om *OpenMetricsMonitoringService) render() []byte {
+	var shards []string
+	metrics := make(map[string]*openMetricsShard)
+	if om.shardMetrics != nil {
+		om.shardMetrics.Range(func(key, value interface{}) bool {
+			shards = append(shards, key.(string))
+			metrics[key.(string)] = value.(*openMetricsShard)
+			return true
+		})
+	}
+	sort.Strings(shards)
+
+	// take a consistent snapshot of every shard
+	snapshots := make([]*openMetricsShard, len(shards))
+	labels := make([]string, len(shards))
+	for i, shard := range shards {
+		m := metrics[shard]
+		m.Lock()
+		snapshots[i] = &openMetricsShard{
+			processedRecords:      m.processedRecords,
+			processedBytes:        m.processedBytes,
+			behindLatestMillis:    m.behindLatestMillis,
+			leasesHeld:            m.leasesHeld,
+			leaseRenewals:         m.leaseRenewals,
+			renewalFailures:       m.renewalFailures,
+			getRecordsTime:        m.getRecordsTime,
+			processRecordsTime:    m.processRecordsTime,
+			invalidRecords:        m.invalidRecords,
+			backpressure:          m.backpressure,
+			expiredIterators:      m.expiredIterators,
+			consumerUptime:        m.consumerUptime,
+			consumerRestarts:      m.consumerRestarts,
+			recordAgeBuckets:      append([]int64(nil), m.recordAgeBuckets...),
+			recordAges:            m.recordAges,
+			duplicateRecords:      m.duplicateRecords,
+			throughput:            m.throughput,
+			uncheckpointedRecords: m.uncheckpointedRecords,
+			shardListingThrottles: m.shardListingThrottles,
+			leasesLost:            m.leasesLost,
+		}
+		m.Unlock()
+		labels[i] = fmt.Sprintf(`application="%s",stream="%s",worker="%s",shard="%s"`,
+			escapeLabelValue(om.Namespace), escapeLabelValue(om.KinesisStream), escapeLabelValue(om.WorkerID),
+			escapeLabelValue(shard))
+	}
+
+	var buf bytes.Buffer
+	for _, metric := range openMetrics {
+		writeMetricHeader(&buf, metric.name, metric.kind, metric.help)
+		for i, m := range snapshots {
+			fmt.Fprintf(&buf, "%s{%s} %s\n", metric.name, labels[i], formatFloat(metric.value(m)))
+		}
+	}
+
+	summaries := []struct {
+		name  string
+		help  string
+		value func(m *openMetricsShard) openMetricsSummary
+	}{
+		{"kcl_get_records_time_milliseconds", "Time taken by the GetRecords calls.",
+			func(m *openMetricsShard) openMetricsSummary { return m.getRecordsTime }},
+		{"kcl_process_records_time_milliseconds", "Time taken by the record processor to process a batch.",
+			func(m *openMetricsShard) openMetricsSummary { return m.processRecordsTime }},
+	}
+	for _, summary := range summaries {
+		writeMetricHeader(&buf, summary.name, "summary", summary.help)
+		for i, m := range snapshots {
+			s := summary.value(m)
+			fmt.Fprintf(&buf, "%s_sum{%s} %s\n", summary.name, labels[i], formatFloat(s.sum))
+			fmt.Fprintf(&buf, "%s_count{%s} %d\n", summary.name, labels[i], s.count)
+		}
+	}
+
+	if om.MetricsLevel == 0 || om.MetricsLevel >= METRICS_DETAILED {
+		name := "kcl_record_age_milliseconds"
+		writeMetricHeader(&buf, name, "histogram", "Age of the records delivered to the record processor.")
+		for i, m := range snapshots {
+			if m.recordAges.count == 0 {
+				continue
+			}
+			cumulative := int64(0)
+			for b, bound := range recordAgeBucketsMillis {
+				cumulative += m.recordAgeBuckets[b]
+				fmt.Fprintf(&buf, "%s_bucket{%s,le=\"%s\"} %d\n", name, labels[i], formatFloat(bound), cumulative)
+			}
+			fmt.Fprintf(&buf, "%s_bucket{%s,le=\"+Inf\"} %d\n", name, labels[i], m.recordAges.count)
+			fmt.Fprintf(&buf, "%s_sum{%s} %s\n", name, labels[i], formatFloat(m.recordAges.sum))
+			fmt.Fprintf(&buf, "%s_count{%s} %d\n", name, labels[i], m.recordAges.count)
+		}
+	}
+	return buf.Bytes()
+}
+
+func (om *OpenMetricsMonitoringService) getOrCreatePerShardMetrics(shard string) *openMetricsShard {
+	i, _ := om.shardMetrics.LoadOrStore(shard, &openMetricsShard{})
+	return i.(*openMetricsShard)
+}
+
+func (s *openMetricsSummary) observe(value float64) {
+	s.sum += value
+	s.count++
+}
+
+func writeMetricHeader(buf *bytes.Buffer, name, kind, help string) {
+	fmt.Fprintf(buf, "# HELP %s %s\n", name, help)
+	fmt.Fprintf(buf, "# TYPE %s %s\n", name, kind)
+}
+
+func formatFloat(f float64) string {
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
+
+var labelValueEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func escapeLabelValue(value string) string {
+	return labelValueEscaper.Replace(value)
+}
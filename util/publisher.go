@@ -0,0 +1,176 @@
+package util
+
+import (
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/cloudwatch"
+	"github.com/aws/aws-sdk-go/service/cloudwatch/cloudwatchiface"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// MetricsPublisher receives the operational metrics of the worker as they are emitted, to route them to any metrics
+// system. The dimensions identify the shard and the stream, see MonitoringConfiguration.Publisher.
+type MetricsPublisher interface {
+	// IncrementCount adds to a counter, e.g. RecordsProcessed or LeaseRenewalFailures.
+	IncrementCount(name string, value float64, dims map[string]string)
+
+	// RecordLatency records a duration, e.g. MillisBehindLatest or KinesisDataFetcher.getRecords.Time.
+	RecordLatency(name string, d time.Duration, dims map[string]string)
+
+	// RecordValue records the current value of a gauge, e.g. CurrentLeases or ProcessingThroughput.
+	RecordValue(name string, value float64, dims map[string]string)
+}
+
+// CloudWatchMetricsPublisher publishes every metric to CloudWatch as it is emitted, under the given namespace.
+type CloudWatchMetricsPublisher struct {
+	Namespace string
+	svc       cloudwatchiface.CloudWatchAPI
+}
+
+// NewCloudWatchMetricsPublisher creates a MetricsPublisher putting the metrics to CloudWatch with the given client.
+func NewCloudWatchMetricsPublisher(namespace string, svc cloudwatchiface.CloudWatchAPI) *CloudWatchMetricsPublisher {
+	return &CloudWatchMetricsPublisher{Namespace: namespace, svc: svc}
+}
+
+func (p *CloudWatchMetricsPublisher) IncrementCount(name string, value float64, dims map[string]string) {
+	p.put(name, cloudwatch.StandardUnitCount, value, dims)
+}
+
+func (p *CloudWatchMetricsPublisher) RecordLatency(name string, d time.Duration, dims map[string]string) {
+	p.put(name, cloudwatch.StandardUnitMilliseconds, float64(d)/float64(time.Millisecond), dims)
+}
+
+func (p *CloudWatchMetricsPublisher) RecordValue(name string, value float64, dims map[string]string) {
+	p.put(name, cloudwatch.StandardUnitNone, value, dims)
+}
+
+func (p *CloudWatchMetricsPublisher) put(name, unit string, value float64, dims map[string]string) {
+	dimensions := make([]*cloudwatch.Dimension, 0, len(dims))
+	for k, v := range dims {
+		dimensions = append(dimensions, &cloudwatch.Dimension{Name: aws.String(k), Value: aws.String(v)})
+	}
+
+	_, err := p.svc.PutMetricData(&cloudwatch.PutMetricDataInput{
+		Namespace: aws.String(p.Namespace),
+		MetricData: []*cloudwatch.MetricDatum{{
+			Dimensions: dimensions,
+			MetricName: aws.String(name),
+			Unit:       aws.String(unit),
+			Timestamp:  aws.Time(time.Now()),
+			Value:      aws.Float64(value),
+		}},
+	})
+	if err != nil {
+		log.Errorf("Error sending metric %s to CloudWatch. %+v", name, err)
+	}
+}
+
+// publishingMonitoringService emits the metrics of the worker to a MetricsPublisher, under the names the CloudWatch
+// monitoring service uses.
+type publishingMonitoringService struct {
+	publisher     MetricsPublisher
+	kinesisStream string
+	workerID      string
+	metricsLevel  MetricsLevel
+}
+
+func (p *publishingMonitoringService) Init() error  { return nil }
+func (p *publishingMonitoringService) Start() error { return nil }
+func (p *publishingMonitoringService) Shutdown()    {}
+
+// dims returns the dimensions of the metrics of a shard.
+func (p *publishingMonitoringService) dims(shard string) map[string]string {
+	return map[string]string{"Shard": shard, "KinesisStreamName": p.kinesisStream, "WorkerID": p.workerID}
+}
+
+func (p *publishingMonitoringService) IncrRecordsProcessed(shard string, count int) {
+	p.publisher.IncrementCount("RecordsProcessed", float64(count), p.dims(shard))
+}
+
+func (p *publishingMonitoringService) IncrBytesProcessed(shard string, count int64) {
+	p.publisher.IncrementCount("DataBytesProcessed", float64(count), p.dims(shard))
+}
+
+func (p *publishingMonitoringService) MillisBehindLatest(shard string, millSeconds float64) {
+	p.publisher.RecordLatency("MillisBehindLatest", millisToDuration(millSeconds), p.dims(shard))
+}
+
+func (p *publishingMonitoringService) LeaseGained(shard string) {
+	p.publisher.RecordValue("CurrentLeases", 1, p.dims(shard))
+}
+
+func (p *publishingMonitoringService) LeaseLost(shard string) {
+	p.publisher.RecordValue("CurrentLeases", 0, p.dims(shard))
+}
+
+func (p *publishingMonitoringService) LeaseRenewed(shard string) {
+	p.publisher.IncrementCount("RenewLease.Success", 1, p.dims(shard))
+}
+
+func (p *publishingMonitoringService) IncrLeaseRenewalFailures(shard string) {
+	p.publisher.IncrementCount("LeaseRenewalFailures", 1, p.dims(shard))
+}
+
+func (p *publishingMonitoringService) RecordGetRecordsTime(shard string, time float64) {
+	p.publisher.RecordLatency("KinesisDataFetcher.getRecords.Time", millisToDuration(time), p.dims(shard))
+}
+
+func (p *publishingMonitoringService) RecordProcessRecordsTime(shard string, time float64) {
+	p.publisher.RecordLatency("RecordProcessor.processRecords.Time", millisToDuration(time), p.dims(shard))
+}
+
+func (p *publishingMonitoringService) IncrInvalidRecords(shard string, count int) {
+	p.publisher.IncrementCount("RecordsInvalid", float64(count), p.dims(shard))
+}
+
+func (p *publishingMonitoringService) Backpressure(shard string, active bool) {
+	p.publisher.RecordValue("Backpressure", boolToFloat64(active), p.dims(shard))
+}
+
+func (p *publishingMonitoringService) IncrExpiredIterators(shard string) {
+	p.publisher.IncrementCount("ExpiredIterator", 1, p.dims(shard))
+}
+
+func (p *publishingMonitoringService) ConsumerUptime(shard string, seconds float64) {
+	p.publisher.RecordValue("ConsumerUptime", seconds, p.dims(shard))
+}
+
+func (p *publishingMonitoringService) IncrConsumerRestarts(shard string) {
+	p.publisher.IncrementCount("ConsumerRestarts", 1, p.dims(shard))
+}
+
+// RecordAge is a detailed metric, it is only emitted at METRICS_DETAILED.
+func (p *publishingMonitoringService) RecordAge(shard string, millis float64) {
+	if p.metricsLevel != 0 && p.metricsLevel < METRICS_DETAILED {
+		return
+	}
+	p.publisher.RecordLatency("RecordAge", millisToDuration(millis), p.dims(shard))
+}
+
+func (p *publishingMonitoringService) IncrDuplicateRecords(shard string, count int) {
+	p.publisher.IncrementCount("RecordsDuplicate", float64(count), p.dims(shard))
+}
+
+func (p *publishingMonitoringService) ProcessingThroughput(shard string, rate float64) {
+	p.publisher.RecordValue("ProcessingThroughput", rate, p.dims(shard))
+}
+
+// IncrShardListingThrottles counts the throttled listings of the shards, which aren't specific to a shard.
+func (p *publishingMonitoringService) IncrShardListingThrottles(stream string) {
+	p.publisher.IncrementCount("ShardListingThrottles", 1,
+		map[string]string{"KinesisStreamName": stream, "WorkerID": p.workerID})
+}
+
+func (p *publishingMonitoringService) UncheckpointedRecords(shard string, count int) {
+	p.publisher.RecordValue("UncheckpointedRecords", float64(count), p.dims(shard))
+}
+
+func (p *publishingMonitoringService) IncrLeasesLost(shard string) {
+	p.publisher.IncrementCount("LeaseLost", 1, p.dims(shard))
+}
+
+func millisToDuration(millis float64) time.Duration {
+	return time.Duration(millis * float64(time.Millisecond))
+}
@@ -0,0 +1,39 @@
+package util
+
+const (
+	// STOP_FETCHING stops reading the shard, closing its enhanced fan-out subscription if any. No record is
+	// delivered to the record processor afterwards.
+	STOP_FETCHING ShutdownStep = iota + 1
+
+	// CHECKPOINT writes the deferred checkpoint, and checkpoints the last processed record if configured for the
+	// shutdown reason, see KinesisClientLibConfiguration.CheckpointOnShutdown.
+	CHECKPOINT
+
+	// SHUTDOWN_PROCESSOR calls the Shutdown of the record processor.
+	SHUTDOWN_PROCESSOR
+
+	// RELEASE_LEASE releases the lease of the shard, unless it was lost to another worker.
+	RELEASE_LEASE
+)
+
+// ShutdownStep is a step of shutting the consumer of a shard down.
+type ShutdownStep int
+
+// ShutdownHook is called before and after every step of shutting the consumer of a shard down, e.g. to flush the
+// processed records downstream right before they are checkpointed. It is called from the goroutine of the shard
+// consumer: the shutdown waits for it.
+type ShutdownHook interface {
+	BeforeShutdownStep(shardID string, reason ShutdownReason, step ShutdownStep)
+	AfterShutdownStep(shardID string, reason ShutdownReason, step ShutdownStep)
+}
+
+var shutdownStepMap = map[ShutdownStep]string{
+	STOP_FETCHING:      "STOP_FETCHING",
+	CHECKPOINT:         "CHECKPOINT",
+	SHUTDOWN_PROCESSOR: "SHUTDOWN_PROCESSOR",
+	RELEASE_LEASE:      "RELEASE_LEASE",
+}
+
+func (s ShutdownStep) String() string {
+	return shutdownStepMap[s]
+}
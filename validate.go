@@ -0,0 +1,108 @@
+package goKCL
+
+import (
+	"context"
+	"errors"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/kinesis"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/guygma/goKCL/shard"
+	"github.com/guygma/goKCL/util"
+)
+
+// Validate checks the configuration of the worker against AWS without consuming the stream, e.g. in CI before a
+// deployment: the stream is described and its shards listed, the lease table is probed for reads and writes if the
+// checkpointer is a shard.AccessValidator, and the publishing of the CloudWatch metrics is checked. All the checks
+// run, and their failures are returned together as the causes of one ClientLibraryError: an IllegalArgumentError if
+// one of them is a misconfiguration, a KinesisClientLibDependencyError otherwise. The cancellation of ctx cuts the
+// checks short.
+func (w *Worker) Validate(ctx context.Context) error {
+	if w.kc == nil {
+		w.createKinesisClients()
+	}
+
+	var errs []error
+	check := func(err error) {
+		if err != nil {
+			log.Errorf("Configuration check failed: %+v", err)
+			errs = append(errs, err)
+		}
+	}
+
+	streams := []string{w.streamName}
+	if w.multiStream() {
+		streams = nil
+		for _, streamARN := range w.kclConfig.MultiStreamConfig.StreamARNs {
+			stream, err := parseStreamARN(streamARN)
+			if err != nil {
+				check(util.IllegalArgumentError.MakeErr().WithCause(err))
+				continue
+			}
+			streams = append(streams, stream.name)
+		}
+	} else {
+		check(w.validateInitialPosition())
+	}
+	for _, streamName := range streams {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		check(w.validateStream(streamName))
+	}
+
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	checkpointer := w.checkpointer
+	if checkpointer == nil {
+		checkpointer = shard.NewDynamoCheckpoint(w.kclConfig)
+	}
+	if validator, ok := checkpointer.(shard.AccessValidator); ok {
+		check(validator.ValidateAccess())
+	} else {
+		log.Warn("Checkpointer can't validate its access to the lease table, skipping the lease table check.")
+	}
+
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	check(w.metricsConfig.ValidateAccess(w.kclConfig.ApplicationName, w.streamName, w.workerID))
+
+	if len(errs) == 0 {
+		return nil
+	}
+	code := util.KinesisClientLibDependencyError
+	for _, err := range errs {
+		if errors.Is(err, util.IllegalArgumentError.MakeErr()) {
+			code = util.IllegalArgumentError
+		}
+	}
+	return code.MakeErr().WithDetail("%d configuration checks failed", len(errs)).WithCauses(errs...)
+}
+
+// validateStream describes the given stream and lists its shards.
+func (w *Worker) validateStream(streamName string) error {
+	_, err := w.kc.DescribeStreamSummary(&kinesis.DescribeStreamSummaryInput{StreamName: aws.String(streamName)})
+	if err != nil {
+		return validationError(err, "unable to describe stream %s", streamName)
+	}
+	_, err = w.kc.DescribeStream(&kinesis.DescribeStreamInput{StreamName: aws.String(streamName), Limit: aws.Int64(1)})
+	if err != nil {
+		return validationError(err, "unable to list the shards of stream %s", streamName)
+	}
+	return nil
+}
+
+// validationError returns the error of a failed check of the stream: an IllegalArgumentError if the stream doesn't
+// exist, a KinesisClientLibDependencyError otherwise, e.g. when access is denied.
+func validationError(err error, format string, v ...interface{}) error {
+	code := util.KinesisClientLibDependencyError
+	var awsErr awserr.Error
+	if errors.As(err, &awsErr) && awsErr.Code() == kinesis.ErrCodeResourceNotFoundException {
+		code = util.IllegalArgumentError
+	}
+	return code.MakeErr().WithDetail(format, v...).WithCause(err)
+}